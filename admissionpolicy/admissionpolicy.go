@@ -0,0 +1,291 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package admissionpolicy generates ValidatingAdmissionPolicies (and their
+// bindings) that enforce reference constraints with CEL rules, so that
+// clusters with in-tree admission can enforce them without running a
+// validating webhook.
+package admissionpolicy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gpu-ninja/operator-utils/celutil"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// ErrInvalidPath is returned when a constraint's path is not a dotted
+	// path of CEL identifiers.
+	ErrInvalidPath = errors.New("invalid path")
+	// ErrNoValidations is returned when the constraints produce no rules.
+	ErrNoValidations = errors.New("no validations")
+)
+
+var identifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Constraint constrains a reference field (eg. a reference.ObjectReference).
+type Constraint struct {
+	// Path is the dotted path of the reference field (eg. "spec.secretRef").
+	Path string
+	// List is true if the field is a list of references, in which case
+	// the constraints apply to every item.
+	List bool
+	// Required is true if the reference must be set.
+	Required bool
+	// RequiredFields are the fields of the reference that must be set (and
+	// non-empty), eg. "name".
+	RequiredFields []string
+	// AllowedKinds, if set, are the kinds the reference may point to.
+	AllowedKinds []string
+	// AllowedAPIVersions, if set, are the API versions the reference may point to.
+	AllowedAPIVersions []string
+	// SameNamespace is true if the reference must not point to another namespace.
+	SameNamespace bool
+}
+
+// Options configures the generated policy.
+type Options struct {
+	// Name is the name of the policy and binding.
+	Name string
+	// APIGroup is the API group of the validated resource.
+	APIGroup string
+	// APIVersions are the API versions of the validated resource. Defaults to all versions.
+	APIVersions []string
+	// Resource is the (plural) resource name of the validated resource.
+	Resource string
+	// FailurePolicy defaults to Fail.
+	FailurePolicy admissionregistrationv1beta1.FailurePolicyType
+	// ValidationActions are the binding's actions. Defaults to Deny.
+	ValidationActions []admissionregistrationv1beta1.ValidationAction
+	// Labels are set on the policy and binding.
+	Labels map[string]string
+}
+
+// Generate returns a ValidatingAdmissionPolicy enforcing the constraints,
+// along with a binding that enables it cluster wide. Each rule is compiled
+// to check that it is valid CEL.
+func Generate(constraints []Constraint, opts Options) (*admissionregistrationv1beta1.ValidatingAdmissionPolicy, *admissionregistrationv1beta1.ValidatingAdmissionPolicyBinding, error) {
+	if opts.FailurePolicy == "" {
+		opts.FailurePolicy = admissionregistrationv1beta1.Fail
+	}
+
+	if len(opts.APIVersions) == 0 {
+		opts.APIVersions = []string{"*"}
+	}
+
+	if len(opts.ValidationActions) == 0 {
+		opts.ValidationActions = []admissionregistrationv1beta1.ValidationAction{admissionregistrationv1beta1.Deny}
+	}
+
+	var validations []admissionregistrationv1beta1.Validation
+	for _, constraint := range constraints {
+		constraintValidations, err := Validations(constraint)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		validations = append(validations, constraintValidations...)
+	}
+
+	if len(validations) == 0 {
+		return nil, nil, ErrNoValidations
+	}
+
+	env, err := celutil.NewEnv(celutil.Options{Variables: []string{"object", "oldObject"}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, validation := range validations {
+		if _, err := env.Compile(validation.Expression); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	policy := &admissionregistrationv1beta1.ValidatingAdmissionPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionregistrationv1beta1.SchemeGroupVersion.String(),
+			Kind:       "ValidatingAdmissionPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   opts.Name,
+			Labels: opts.Labels,
+		},
+		Spec: admissionregistrationv1beta1.ValidatingAdmissionPolicySpec{
+			FailurePolicy: ptr.To(opts.FailurePolicy),
+			MatchConstraints: &admissionregistrationv1beta1.MatchResources{
+				ResourceRules: []admissionregistrationv1beta1.NamedRuleWithOperations{{
+					RuleWithOperations: admissionregistrationv1beta1.RuleWithOperations{
+						Operations: []admissionregistrationv1beta1.OperationType{
+							admissionregistrationv1beta1.Create,
+							admissionregistrationv1beta1.Update,
+						},
+						Rule: admissionregistrationv1beta1.Rule{
+							APIGroups:   []string{opts.APIGroup},
+							APIVersions: opts.APIVersions,
+							Resources:   []string{opts.Resource},
+						},
+					},
+				}},
+			},
+			Validations: validations,
+		},
+	}
+
+	binding := &admissionregistrationv1beta1.ValidatingAdmissionPolicyBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionregistrationv1beta1.SchemeGroupVersion.String(),
+			Kind:       "ValidatingAdmissionPolicyBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   opts.Name,
+			Labels: opts.Labels,
+		},
+		Spec: admissionregistrationv1beta1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName:        opts.Name,
+			ValidationActions: opts.ValidationActions,
+		},
+	}
+
+	return policy, binding, nil
+}
+
+// Validations returns the CEL validations enforcing a single constraint.
+func Validations(constraint Constraint) ([]admissionregistrationv1beta1.Validation, error) {
+	segments := strings.Split(constraint.Path, ".")
+	for _, segment := range segments {
+		if !identifierRegexp.MatchString(segment) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, constraint.Path)
+		}
+	}
+
+	for _, field := range constraint.RequiredFields {
+		if !identifierRegexp.MatchString(field) {
+			return nil, fmt.Errorf("%w: required field %q", ErrInvalidPath, field)
+		}
+	}
+
+	field := "object." + constraint.Path
+
+	// has() must be applied to each level of the path.
+	var present []string
+	for i := range segments {
+		present = append(present, fmt.Sprintf("has(object.%s)", strings.Join(segments[:i+1], ".")))
+	}
+	isSet := strings.Join(present, " && ")
+
+	var validations []admissionregistrationv1beta1.Validation
+
+	if constraint.Required {
+		validations = append(validations, admissionregistrationv1beta1.Validation{
+			Expression: isSet,
+			Message:    fmt.Sprintf("%s is required", constraint.Path),
+			Reason:     ptr.To(metav1.StatusReasonInvalid),
+		})
+	}
+
+	// ref is the variable (or expression) referring to a single reference.
+	ref := field
+	refPath := constraint.Path
+	if constraint.List {
+		ref = "r"
+		refPath += "[*]"
+	}
+
+	type rule struct {
+		expression string
+		message    string
+	}
+
+	var rules []rule
+
+	for _, required := range constraint.RequiredFields {
+		rules = append(rules, rule{
+			expression: fmt.Sprintf("has(%s.%s) && %s.%s != ''", ref, required, ref, required),
+			message:    fmt.Sprintf("%s.%s is required", refPath, required),
+		})
+	}
+
+	if len(constraint.AllowedKinds) > 0 {
+		rules = append(rules, rule{
+			expression: fmt.Sprintf("!has(%s.kind) || %s.kind in %s", ref, ref, celList(constraint.AllowedKinds)),
+			message:    fmt.Sprintf("%s.kind must be one of: %s", refPath, strings.Join(constraint.AllowedKinds, ", ")),
+		})
+	}
+
+	if len(constraint.AllowedAPIVersions) > 0 {
+		rules = append(rules, rule{
+			expression: fmt.Sprintf("!has(%s.apiVersion) || %s.apiVersion in %s", ref, ref, celList(constraint.AllowedAPIVersions)),
+			message:    fmt.Sprintf("%s.apiVersion must be one of: %s", refPath, strings.Join(constraint.AllowedAPIVersions, ", ")),
+		})
+	}
+
+	if constraint.SameNamespace {
+		rules = append(rules, rule{
+			expression: fmt.Sprintf("!has(%s.namespace) || %s.namespace == '' || %s.namespace == object.metadata.namespace", ref, ref, ref),
+			message:    fmt.Sprintf("%s must not reference another namespace", refPath),
+		})
+	}
+
+	for _, r := range rules {
+		expression := r.expression
+		if constraint.List {
+			expression = fmt.Sprintf("%s.all(r, %s)", field, r.expression)
+		}
+
+		validations = append(validations, admissionregistrationv1beta1.Validation{
+			Expression: fmt.Sprintf("!(%s) || (%s)", isSet, expression),
+			Message:    r.message,
+			Reason:     ptr.To(metav1.StatusReasonInvalid),
+		})
+	}
+
+	return validations, nil
+}
+
+// Manifests returns the policy and binding as a multi-document YAML stream.
+func Manifests(policy *admissionregistrationv1beta1.ValidatingAdmissionPolicy, binding *admissionregistrationv1beta1.ValidatingAdmissionPolicyBinding) ([]byte, error) {
+	var sb strings.Builder
+	for _, obj := range []any{policy, binding} {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal object: %w", err)
+		}
+
+		sb.WriteString("---\n")
+		sb.Write(data)
+	}
+
+	return []byte(sb.String()), nil
+}
+
+func celList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,123 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package admissionpolicy_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/admissionpolicy"
+	"github.com/gpu-ninja/operator-utils/celutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+)
+
+func TestGenerate(t *testing.T) {
+	constraints := []admissionpolicy.Constraint{
+		{
+			Path:           "spec.secretRef",
+			Required:       true,
+			RequiredFields: []string{"name"},
+			SameNamespace:  true,
+		},
+		{
+			Path:               "spec.backends",
+			List:               true,
+			RequiredFields:     []string{"name"},
+			AllowedKinds:       []string{"Service", "Deployment"},
+			AllowedAPIVersions: []string{"v1", "apps/v1"},
+		},
+	}
+
+	policy, binding, err := admissionpolicy.Generate(constraints, admissionpolicy.Options{
+		Name:     "widgets.example.com",
+		APIGroup: "example.com",
+		Resource: "widgets",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "ValidatingAdmissionPolicy", policy.Kind)
+	assert.Equal(t, admissionregistrationv1beta1.Fail, *policy.Spec.FailurePolicy)
+	assert.Equal(t, []string{"widgets"}, policy.Spec.MatchConstraints.ResourceRules[0].Resources)
+	assert.Len(t, policy.Spec.Validations, 6)
+
+	assert.Equal(t, "widgets.example.com", binding.Spec.PolicyName)
+	assert.Equal(t, []admissionregistrationv1beta1.ValidationAction{admissionregistrationv1beta1.Deny}, binding.Spec.ValidationActions)
+
+	manifests, err := admissionpolicy.Manifests(policy, binding)
+	require.NoError(t, err)
+	assert.Contains(t, string(manifests), "kind: ValidatingAdmissionPolicyBinding")
+
+	env, err := celutil.NewEnv(celutil.Options{Variables: []string{"object"}})
+	require.NoError(t, err)
+
+	// violations returns the messages of the failed validations.
+	violations := func(object map[string]any) []string {
+		var messages []string
+		for _, validation := range policy.Spec.Validations {
+			ok, err := env.EvalBool(context.Background(), validation.Expression, nil, map[string]any{"object": object})
+			require.NoError(t, err, validation.Expression)
+
+			if !ok {
+				messages = append(messages, validation.Message)
+			}
+		}
+		return messages
+	}
+
+	assert.Empty(t, violations(map[string]any{
+		"metadata": map[string]any{"namespace": "default"},
+		"spec": map[string]any{
+			"secretRef": map[string]any{"name": "credentials", "namespace": "default"},
+			"backends": []any{
+				map[string]any{"name": "api", "kind": "Service", "apiVersion": "v1"},
+				map[string]any{"name": "worker"},
+			},
+		},
+	}))
+
+	assert.Equal(t, []string{"spec.secretRef is required"}, violations(map[string]any{
+		"metadata": map[string]any{"namespace": "default"},
+		"spec":     map[string]any{},
+	}))
+
+	assert.ElementsMatch(t, []string{
+		"spec.secretRef.name is required",
+		"spec.secretRef must not reference another namespace",
+		"spec.backends[*].kind must be one of: Service, Deployment",
+		"spec.backends[*].name is required",
+	}, violations(map[string]any{
+		"metadata": map[string]any{"namespace": "default"},
+		"spec": map[string]any{
+			"secretRef": map[string]any{"namespace": "kube-system"},
+			"backends": []any{
+				map[string]any{"name": "api", "kind": "Secret"},
+				map[string]any{"name": ""},
+			},
+		},
+	}))
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, _, err := admissionpolicy.Generate([]admissionpolicy.Constraint{{Path: "spec.secret-ref", Required: true}}, admissionpolicy.Options{})
+		assert.ErrorIs(t, err, admissionpolicy.ErrInvalidPath)
+
+		_, _, err = admissionpolicy.Generate([]admissionpolicy.Constraint{{Path: "spec.secretRef"}}, admissionpolicy.Options{})
+		assert.ErrorIs(t, err, admissionpolicy.ErrNoValidations)
+	})
+}
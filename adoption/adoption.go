@@ -0,0 +1,304 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package adoption provides a workflow for bringing existing objects (eg.
+// from a Helm or kubectl managed install) under the control of an operator.
+package adoption
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// AdoptedFromAnnotation records the field managers that managed an
+	// object before it was adopted.
+	AdoptedFromAnnotation = "gpu-ninja.com/adopted-from"
+)
+
+// DefaultAllowedManagers are the field managers used by Helm and kubectl.
+var DefaultAllowedManagers = []string{
+	"helm",
+	"kubectl",
+	"kubectl-client-side-apply",
+	"kubectl-create",
+	"kubectl-edit",
+	"kubectl-label",
+	"kubectl-annotate",
+	"kubectl-patch",
+	"kubectl-replace",
+	"kubectl-scale",
+	"kubectl-set",
+}
+
+// DefaultRemoveAnnotations are the Helm release annotations.
+var DefaultRemoveAnnotations = []string{
+	"meta.helm.sh/release-name",
+	"meta.helm.sh/release-namespace",
+}
+
+// Candidates selects the objects of a kind that may be adopted.
+type Candidates struct {
+	// GroupVersionKind is the kind of object.
+	GroupVersionKind schema.GroupVersionKind
+	// Selector, if set, selects candidates by label.
+	Selector labels.Selector
+	// NamePrefix, if set, selects candidates by name prefix.
+	NamePrefix string
+	// Names, if set, selects candidates by name.
+	Names []string
+}
+
+// Options configures adoption.
+type Options struct {
+	// Candidates are the objects that may be adopted (in the owner's namespace).
+	Candidates []Candidates
+	// AllowedManagers are the field managers that may have previously
+	// managed a candidate. Candidates with fields owned by any other
+	// manager are skipped. Defaults to DefaultAllowedManagers.
+	AllowedManagers []string
+	// FieldManager is the operator's own field manager (always allowed).
+	FieldManager string
+	// Labels are set on adopted objects (eg. app.kubernetes.io/managed-by).
+	Labels map[string]string
+	// RemoveLabels are removed from adopted objects.
+	RemoveLabels []string
+	// RemoveAnnotations are removed from adopted objects. Defaults to DefaultRemoveAnnotations.
+	RemoveAnnotations []string
+	// DryRun reports what would be adopted without modifying anything.
+	DryRun bool
+}
+
+// ObjectRef identifies an object in a report.
+type ObjectRef struct {
+	schema.GroupVersionKind
+	client.ObjectKey
+}
+
+func (r ObjectRef) String() string {
+	return fmt.Sprintf("%s %s", r.Kind, r.ObjectKey)
+}
+
+// Skipped is an object that was not adopted.
+type Skipped struct {
+	ObjectRef
+	// Reason explains why the object was not adopted.
+	Reason string
+}
+
+// Report summarizes the outcome of an adoption.
+type Report struct {
+	// Adopted are the objects that were adopted.
+	Adopted []ObjectRef
+	// Skipped are the candidates that were not adopted.
+	Skipped []Skipped
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "adopted %d objects, skipped %d objects", len(r.Adopted), len(r.Skipped))
+	for _, s := range r.Skipped {
+		fmt.Fprintf(&sb, "\n  skipped %s: %s", s.ObjectRef, s.Reason)
+	}
+
+	return sb.String()
+}
+
+// Adopt finds candidate objects in the owner's namespace, verifies that
+// they are not managed by a conflicting controller or field manager, and
+// brings them under the owner's control (by relabeling them, and setting
+// the owner as their controller).
+func Adopt(ctx context.Context, c client.Client, scheme *runtime.Scheme, owner client.Object, opts Options) (*Report, error) {
+	logger := log.FromContext(ctx)
+
+	if opts.AllowedManagers == nil {
+		opts.AllowedManagers = DefaultAllowedManagers
+	}
+
+	if opts.RemoveAnnotations == nil {
+		opts.RemoveAnnotations = DefaultRemoveAnnotations
+	}
+
+	allowedManagers := make(map[string]bool)
+	for _, manager := range opts.AllowedManagers {
+		allowedManagers[manager] = true
+	}
+	if opts.FieldManager != "" {
+		allowedManagers[opts.FieldManager] = true
+	}
+
+	report := &Report{}
+	for _, candidates := range opts.Candidates {
+		objs, err := find(ctx, c, owner.GetNamespace(), &candidates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find %s candidates: %w", candidates.GroupVersionKind.Kind, err)
+		}
+
+		for _, obj := range objs {
+			ref := ObjectRef{
+				GroupVersionKind: candidates.GroupVersionKind,
+				ObjectKey:        client.ObjectKeyFromObject(obj),
+			}
+
+			if reason := checkConflicts(obj, owner, allowedManagers); reason != "" {
+				report.Skipped = append(report.Skipped, Skipped{ObjectRef: ref, Reason: reason})
+				continue
+			}
+
+			if !opts.DryRun {
+				if err := adopt(ctx, c, scheme, owner, obj, &opts); err != nil {
+					return nil, fmt.Errorf("failed to adopt %s: %w", ref, err)
+				}
+
+				logger.Info("Adopted object", "kind", ref.Kind, "name", ref.Name)
+			}
+
+			report.Adopted = append(report.Adopted, ref)
+		}
+	}
+
+	return report, nil
+}
+
+func find(ctx context.Context, c client.Client, namespace string, candidates *Candidates) ([]*unstructured.Unstructured, error) {
+	var list unstructured.UnstructuredList
+	list.SetGroupVersionKind(candidates.GroupVersionKind.GroupVersion().WithKind(candidates.GroupVersionKind.Kind + "List"))
+
+	listOpts := []client.ListOption{client.InNamespace(namespace)}
+	if candidates.Selector != nil {
+		listOpts = append(listOpts, client.MatchingLabelsSelector{Selector: candidates.Selector})
+	}
+
+	if err := c.List(ctx, &list, listOpts...); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, name := range candidates.Names {
+		names[name] = true
+	}
+
+	var objs []*unstructured.Unstructured
+	for i := range list.Items {
+		obj := &list.Items[i]
+
+		if len(names) > 0 && !names[obj.GetName()] {
+			continue
+		}
+
+		if candidates.NamePrefix != "" && !strings.HasPrefix(obj.GetName(), candidates.NamePrefix) {
+			continue
+		}
+
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
+
+// checkConflicts returns a reason if the object cannot be adopted.
+func checkConflicts(obj client.Object, owner client.Object, allowedManagers map[string]bool) string {
+	if controller := metav1.GetControllerOf(obj); controller != nil {
+		if controller.UID == owner.GetUID() {
+			return "already adopted"
+		}
+
+		return fmt.Sprintf("controlled by %s %s", controller.Kind, controller.Name)
+	}
+
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return "being deleted"
+	}
+
+	var conflicting []string
+	for _, managedFields := range obj.GetManagedFields() {
+		if !allowedManagers[managedFields.Manager] {
+			conflicting = append(conflicting, managedFields.Manager)
+		}
+	}
+
+	if len(conflicting) > 0 {
+		sort.Strings(conflicting)
+		return fmt.Sprintf("managed by conflicting field managers: %s", strings.Join(conflicting, ", "))
+	}
+
+	return ""
+}
+
+func adopt(ctx context.Context, c client.Client, scheme *runtime.Scheme, owner client.Object, obj *unstructured.Unstructured, opts *Options) error {
+	patch := client.MergeFrom(obj.DeepCopy())
+
+	objLabels := obj.GetLabels()
+	if objLabels == nil {
+		objLabels = make(map[string]string)
+	}
+	for _, key := range opts.RemoveLabels {
+		delete(objLabels, key)
+	}
+	for key, value := range opts.Labels {
+		objLabels[key] = value
+	}
+	obj.SetLabels(objLabels)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for _, key := range opts.RemoveAnnotations {
+		delete(annotations, key)
+	}
+	if managers := previousManagers(obj); managers != "" {
+		annotations[AdoptedFromAnnotation] = managers
+	}
+	obj.SetAnnotations(annotations)
+
+	if err := controllerutil.SetControllerReference(owner, obj, scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference: %w", err)
+	}
+
+	if err := c.Patch(ctx, obj, patch); err != nil {
+		return fmt.Errorf("failed to patch object: %w", err)
+	}
+
+	return nil
+}
+
+func previousManagers(obj client.Object) string {
+	seen := make(map[string]bool)
+	var managers []string
+	for _, managedFields := range obj.GetManagedFields() {
+		if !seen[managedFields.Manager] {
+			seen[managedFields.Manager] = true
+			managers = append(managers, managedFields.Manager)
+		}
+	}
+
+	sort.Strings(managers)
+
+	return strings.Join(managers, ",")
+}
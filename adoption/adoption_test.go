@@ -0,0 +1,152 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adoption_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/adoption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAdopt(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	owner := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"},
+	}
+
+	helmLabels := map[string]string{
+		"app.kubernetes.io/instance":   "demo",
+		"app.kubernetes.io/managed-by": "Helm",
+	}
+
+	helmManaged := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "demo-credentials",
+			Labels:    helmLabels,
+			Annotations: map[string]string{
+				"meta.helm.sh/release-name": "demo",
+			},
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "helm", Operation: metav1.ManagedFieldsOperationUpdate}},
+		},
+	}
+
+	conflicting := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:     "default",
+			Name:          "demo-tls",
+			Labels:        helmLabels,
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "cert-manager", Operation: metav1.ManagedFieldsOperationUpdate}},
+		},
+	}
+
+	controlled := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "demo-token",
+			Labels:    helmLabels,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "ServiceAccount",
+				Name:       "demo",
+				UID:        "sa-uid",
+				Controller: ptr(true),
+			}},
+		},
+	}
+
+	unrelated := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "unrelated"},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithObjects(owner, helmManaged, conflicting, controlled, unrelated).Build()
+
+	opts := adoption.Options{
+		Candidates: []adoption.Candidates{{
+			GroupVersionKind: corev1.SchemeGroupVersion.WithKind("Secret"),
+			Selector:         labels.SelectorFromSet(labels.Set{"app.kubernetes.io/instance": "demo"}),
+			NamePrefix:       "demo-",
+		}},
+		Labels: map[string]string{
+			"app.kubernetes.io/managed-by": "demo-operator",
+		},
+	}
+
+	t.Run("DryRun", func(t *testing.T) {
+		opts := opts
+		opts.DryRun = true
+
+		report, err := adoption.Adopt(ctx, c, scheme, owner, opts)
+		require.NoError(t, err)
+
+		assert.Len(t, report.Adopted, 1)
+
+		var secret corev1.Secret
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(helmManaged), &secret))
+		assert.Empty(t, secret.OwnerReferences)
+	})
+
+	report, err := adoption.Adopt(ctx, c, scheme, owner, opts)
+	require.NoError(t, err)
+
+	require.Len(t, report.Adopted, 1)
+	assert.Equal(t, "demo-credentials", report.Adopted[0].Name)
+
+	require.Len(t, report.Skipped, 2)
+	assert.Equal(t, "demo-tls", report.Skipped[0].Name)
+	assert.Contains(t, report.Skipped[0].Reason, "cert-manager")
+	assert.Equal(t, "demo-token", report.Skipped[1].Name)
+	assert.Contains(t, report.Skipped[1].Reason, "ServiceAccount demo")
+
+	var secret corev1.Secret
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(helmManaged), &secret))
+
+	assert.Equal(t, "demo-operator", secret.Labels["app.kubernetes.io/managed-by"])
+	assert.Equal(t, "helm", secret.Annotations[adoption.AdoptedFromAnnotation])
+	assert.NotContains(t, secret.Annotations, "meta.helm.sh/release-name")
+
+	controller := metav1.GetControllerOf(&secret)
+	require.NotNil(t, controller)
+	assert.Equal(t, owner.UID, controller.UID)
+
+	// Adopting again is a no-op.
+	report, err = adoption.Adopt(ctx, c, scheme, owner, opts)
+	require.NoError(t, err)
+
+	assert.Empty(t, report.Adopted)
+	assert.Len(t, report.Skipped, 3)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
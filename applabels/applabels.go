@@ -0,0 +1,125 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package applabels applies the Kubernetes recommended app.kubernetes.io/*
+// labels to objects, and builds matching selectors, so that an operator's
+// children are labeled and selected consistently.
+//
+// See: https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+package applabels
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Recommended labels.
+const (
+	NameLabel      = "app.kubernetes.io/name"
+	InstanceLabel  = "app.kubernetes.io/instance"
+	VersionLabel   = "app.kubernetes.io/version"
+	ComponentLabel = "app.kubernetes.io/component"
+	PartOfLabel    = "app.kubernetes.io/part-of"
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// Labels are the recommended labels of an object. Empty fields are not set.
+type Labels struct {
+	// Name is the name of the application (eg. "mysql").
+	Name string
+	// Instance uniquely identifies the instance of the application (eg. the
+	// name of the custom resource).
+	Instance string
+	// Version is the version of the application (eg. "5.7.21").
+	Version string
+	// Component is the component within the architecture (eg. "database").
+	Component string
+	// PartOf is the higher level application this one is part of (eg. "wordpress").
+	PartOf string
+	// ManagedBy is the tool managing the application (eg. the operator's name).
+	ManagedBy string
+}
+
+// WithComponent returns a copy of the labels with the component set.
+func (l Labels) WithComponent(component string) Labels {
+	l.Component = component
+	return l
+}
+
+// Map returns the labels as a map.
+func (l Labels) Map() map[string]string {
+	m := l.SelectorMap()
+	set(m, VersionLabel, l.Version)
+	set(m, PartOfLabel, l.PartOf)
+	set(m, ManagedByLabel, l.ManagedBy)
+
+	return m
+}
+
+// SelectorMap returns the subset of labels that identify an object's
+// application instance and component. Labels that change over an object's
+// lifetime (eg. the version) are excluded, as selectors are often immutable.
+func (l Labels) SelectorMap() map[string]string {
+	m := make(map[string]string)
+	set(m, NameLabel, l.Name)
+	set(m, InstanceLabel, l.Instance)
+	set(m, ComponentLabel, l.Component)
+
+	return m
+}
+
+// LabelSelector returns a label selector (eg. for a Deployment or Service)
+// matching the selector labels.
+func (l Labels) LabelSelector() *metav1.LabelSelector {
+	return &metav1.LabelSelector{MatchLabels: l.SelectorMap()}
+}
+
+// Selector returns a selector (eg. for listing children) matching the
+// selector labels.
+func (l Labels) Selector() labels.Selector {
+	return labels.SelectorFromSet(l.SelectorMap())
+}
+
+// Merge returns the given labels with the recommended labels set (or
+// overwritten). The given labels are not modified.
+func (l Labels) Merge(existing map[string]string) map[string]string {
+	merged := make(map[string]string, len(existing))
+	for k, v := range existing {
+		merged[k] = v
+	}
+
+	for k, v := range l.Map() {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// Apply sets the recommended labels on each of the objects, preserving any
+// other labels.
+func (l Labels) Apply(objs ...client.Object) {
+	for _, obj := range objs {
+		obj.SetLabels(l.Merge(obj.GetLabels()))
+	}
+}
+
+func set(m map[string]string, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
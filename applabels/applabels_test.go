@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package applabels_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/applabels"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestLabels(t *testing.T) {
+	l := applabels.Labels{
+		Name:      "widget",
+		Instance:  "test",
+		Version:   "1.2.3",
+		PartOf:    "gadget",
+		ManagedBy: "widget-operator",
+	}
+
+	server := l.WithComponent("server")
+	assert.Empty(t, l.Component)
+
+	assert.Equal(t, map[string]string{
+		applabels.NameLabel:      "widget",
+		applabels.InstanceLabel:  "test",
+		applabels.VersionLabel:   "1.2.3",
+		applabels.ComponentLabel: "server",
+		applabels.PartOfLabel:    "gadget",
+		applabels.ManagedByLabel: "widget-operator",
+	}, server.Map())
+
+	assert.Equal(t, &metav1.LabelSelector{MatchLabels: map[string]string{
+		applabels.NameLabel:      "widget",
+		applabels.InstanceLabel:  "test",
+		applabels.ComponentLabel: "server",
+	}}, server.LabelSelector())
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+			"example.com/team":       "platform",
+			applabels.VersionLabel:   "1.2.2",
+			applabels.ComponentLabel: "old",
+		}},
+	}
+	service := &corev1.Service{}
+
+	server.Apply(deployment, service)
+
+	assert.Equal(t, "platform", deployment.Labels["example.com/team"])
+	assert.Equal(t, "1.2.3", deployment.Labels[applabels.VersionLabel])
+	assert.Equal(t, "server", deployment.Labels[applabels.ComponentLabel])
+
+	assert.True(t, server.Selector().Matches(labels.Set(service.Labels)))
+	assert.False(t, l.WithComponent("worker").Selector().Matches(labels.Set(service.Labels)))
+
+	// Empty fields are omitted.
+	assert.Equal(t, map[string]string{applabels.NameLabel: "widget"}, applabels.Labels{Name: "widget"}.Map())
+}
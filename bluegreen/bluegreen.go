@@ -0,0 +1,499 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bluegreen manages blue-green deployments of a workload (a
+// Deployment or StatefulSet) behind a single Service. Each revision of the
+// workload is deployed as a separate generation (colored blue or green).
+// Once the new generation is ready the Service's selector is flipped to it
+// in a single update. The new generation is then verified for a grace
+// period, during which the old generation is kept running so that traffic
+// can be flipped back if verification fails. After the grace period the old
+// generation is torn down.
+package bluegreen
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/results"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/rollout"
+	"github.com/gpu-ninja/operator-utils/updater"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ColorLabel identifies the generation of a workload's pods.
+	ColorLabel = "gpu-ninja.com/color"
+	// ConditionTypeBlueGreen is the type of the blue-green condition.
+	ConditionTypeBlueGreen = "BlueGreen"
+	// DefaultGracePeriod is how long the old generation is kept after a switchover.
+	DefaultGracePeriod = 5 * time.Minute
+	// DefaultRequeueAfter is how long to wait before rechecking a switchover in progress.
+	DefaultRequeueAfter = 10 * time.Second
+)
+
+// Condition reasons.
+const (
+	ReasonProgressing = "NewGenerationProgressing"
+	ReasonVerifying   = "NewGenerationVerifying"
+	ReasonActive      = "GenerationActive"
+	ReasonRolledBack  = "RolledBack"
+)
+
+// Color is the color of a workload generation.
+type Color string
+
+const (
+	Blue  Color = "blue"
+	Green Color = "green"
+)
+
+// Other returns the other color.
+func (c Color) Other() Color {
+	if c == Blue {
+		return Green
+	}
+
+	return Blue
+}
+
+// Phase is the phase of a blue-green deployment.
+type Phase string
+
+const (
+	// PhaseProgressing indicates a new generation is being rolled out.
+	PhaseProgressing Phase = "Progressing"
+	// PhaseVerifying indicates traffic has been switched to the new
+	// generation, and it is being verified.
+	PhaseVerifying Phase = "Verifying"
+	// PhaseActive indicates a single generation is active.
+	PhaseActive Phase = "Active"
+	// PhaseRolledBack indicates the last new generation failed and was removed.
+	PhaseRolledBack Phase = "RolledBack"
+)
+
+// State is the state of a blue-green deployment, suitable for embedding in
+// the status of a custom resource.
+// +kubebuilder:object:generate=true
+type State struct {
+	// Phase is the phase of the deployment.
+	Phase Phase `json:"phase,omitempty"`
+	// ActiveColor is the color of the generation receiving traffic.
+	ActiveColor Color `json:"activeColor,omitempty"`
+	// ActiveRevision is the revision (template hash) of the active generation.
+	ActiveRevision string `json:"activeRevision,omitempty"`
+	// PreviousRevision is the revision of the previous generation, while it
+	// is retained during verification.
+	PreviousRevision string `json:"previousRevision,omitempty"`
+	// FailedRevision is the revision of the last generation that was rolled back.
+	FailedRevision string `json:"failedRevision,omitempty"`
+	// SwitchTime is when traffic was last switched.
+	SwitchTime *metav1.Time `json:"switchTime,omitempty"`
+	// Message is a human readable description of the deployment's progress.
+	Message string `json:"message,omitempty"`
+}
+
+// VerifyFunc verifies a new generation after traffic has been switched to
+// it (eg. by sending test requests through the service), it returns
+// whether the generation is healthy along with a human readable message
+// explaining why not.
+type VerifyFunc func(ctx context.Context, service *corev1.Service, workload client.Object) (bool, string, error)
+
+// Options configures a Switcher.
+type Options struct {
+	// GracePeriod is how long the previous generation is retained (and the
+	// new generation verified) after switching traffic. Defaults to
+	// DefaultGracePeriod.
+	GracePeriod time.Duration
+	// Verify, if set, verifies the new generation during the grace period.
+	Verify VerifyFunc
+	// RequeueAfter is how long to wait before rechecking a switchover in
+	// progress. Defaults to DefaultRequeueAfter.
+	RequeueAfter time.Duration
+	// Clock is used to time the grace period (useful for testing).
+	Clock clock.PassiveClock
+}
+
+// Switcher manages blue-green deployments.
+type Switcher struct {
+	c    client.Client
+	opts Options
+}
+
+// NewSwitcher returns a new blue-green switcher.
+func NewSwitcher(c client.Client, opts Options) *Switcher {
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = DefaultGracePeriod
+	}
+
+	if opts.RequeueAfter == 0 {
+		opts.RequeueAfter = DefaultRequeueAfter
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &Switcher{
+		c:    c,
+		opts: opts,
+	}
+}
+
+// WorkloadName returns the name of a generation of a workload.
+func WorkloadName(name string, color Color) string {
+	return name + "-" + string(color)
+}
+
+// Reconcile deploys the workload template (a Deployment or StatefulSet,
+// named after the workload) as a generation behind the service template
+// (whose selector should match the workload's pods), recording progress in
+// the state. It returns a result that requeues the parent until the
+// switchover completes.
+func (s *Switcher) Reconcile(ctx context.Context, template client.Object, service *corev1.Service, state *State) (results.Result, error) {
+	logger := log.FromContext(ctx)
+
+	revision := updater.HashObject(template)
+
+	if state.ActiveColor == "" {
+		*state = State{ActiveColor: Blue}
+	}
+
+	switch {
+	case revision == state.ActiveRevision:
+		// Make sure the active generation hasn't drifted (or been deleted).
+		if _, err := s.applyWorkload(ctx, template, state.ActiveColor); err != nil {
+			return results.Done(), err
+		}
+
+		if state.Phase == PhaseVerifying {
+			return s.verify(ctx, template, service, state)
+		}
+
+		if err := s.applyService(ctx, service, state.ActiveColor); err != nil {
+			return results.Done(), err
+		}
+
+		state.Phase = PhaseActive
+		state.Message = fmt.Sprintf("%s generation is active", state.ActiveColor)
+
+		return results.Done(), nil
+	case revision == state.FailedRevision:
+		// Don't retry a revision that has already been rolled back.
+		return results.Done(), nil
+	}
+
+	// The first generation is switched to directly.
+	newColor := state.ActiveColor
+	if state.ActiveRevision != "" {
+		newColor = state.ActiveColor.Other()
+	}
+
+	// A newer revision supersedes one still being verified.
+	if state.Phase == PhaseVerifying {
+		logger.Info("Finishing previous switchover early", "color", state.ActiveColor.Other())
+
+		if err := s.deleteWorkload(ctx, template, state.ActiveColor.Other()); err != nil {
+			return results.Done(), err
+		}
+
+		state.Phase = PhaseActive
+		state.PreviousRevision = ""
+	}
+
+	workload, err := s.applyWorkload(ctx, template, newColor)
+	if err != nil {
+		return results.Done(), err
+	}
+
+	status, err := rollout.Inspect(ctx, s.c, workload)
+	if err != nil {
+		return results.Done(), fmt.Errorf("failed to inspect %s generation: %w", newColor, err)
+	}
+
+	switch status.Phase {
+	case rollout.PhaseStalled:
+		// There is nothing to roll back to, so keep waiting for the first generation.
+		if state.ActiveRevision == "" {
+			state.Phase = PhaseProgressing
+			state.Message = fmt.Sprintf("%s generation stalled: %s", newColor, status.Message)
+
+			return results.RequeueAfter(s.opts.RequeueAfter), nil
+		}
+
+		logger.Info("New generation stalled, rolling back", "color", newColor, "reason", status.Message)
+
+		if err := s.deleteWorkload(ctx, template, newColor); err != nil {
+			return results.Done(), err
+		}
+
+		state.Phase = PhaseRolledBack
+		state.FailedRevision = revision
+		state.Message = fmt.Sprintf("%s generation stalled: %s", newColor, status.Message)
+
+		return results.Done(), nil
+	case rollout.PhaseProgressing:
+		state.Phase = PhaseProgressing
+		state.Message = fmt.Sprintf("waiting for %s generation: %s", newColor, status.Message)
+
+		return results.RequeueAfter(s.opts.RequeueAfter), nil
+	}
+
+	if err := s.applyService(ctx, service, newColor); err != nil {
+		return results.Done(), err
+	}
+
+	logger.Info("Switched traffic", "color", newColor, "revision", revision)
+
+	first := state.ActiveRevision == ""
+
+	state.PreviousRevision = state.ActiveRevision
+	state.ActiveColor = newColor
+	state.ActiveRevision = revision
+	state.SwitchTime = &metav1.Time{Time: s.opts.Clock.Now()}
+
+	if first {
+		state.Phase = PhaseActive
+		state.Message = fmt.Sprintf("%s generation is active", newColor)
+
+		return results.Done(), nil
+	}
+
+	state.Phase = PhaseVerifying
+	state.Message = fmt.Sprintf("switched traffic to %s generation, verifying", newColor)
+
+	return results.RequeueAfter(s.opts.RequeueAfter), nil
+}
+
+// verify verifies the active generation during the grace period, then tears
+// down the previous generation (or, if verification fails, switches traffic
+// back to it).
+func (s *Switcher) verify(ctx context.Context, template client.Object, service *corev1.Service, state *State) (results.Result, error) {
+	if s.opts.Verify != nil {
+		workload, err := newObject(template)
+		if err != nil {
+			return results.Done(), err
+		}
+
+		key := client.ObjectKey{Namespace: template.GetNamespace(), Name: WorkloadName(template.GetName(), state.ActiveColor)}
+		if err := s.c.Get(ctx, key, workload); err != nil {
+			return results.Done(), fmt.Errorf("failed to get %s generation: %w", state.ActiveColor, err)
+		}
+
+		healthy, message, err := s.opts.Verify(ctx, service, workload)
+		if err != nil {
+			return results.Done(), fmt.Errorf("failed to verify %s generation: %w", state.ActiveColor, err)
+		}
+
+		if !healthy {
+			return s.rollback(ctx, template, service, state, message)
+		}
+	}
+
+	if remaining := state.SwitchTime.Add(s.opts.GracePeriod).Sub(s.opts.Clock.Now()); remaining > 0 {
+		if remaining > s.opts.RequeueAfter {
+			remaining = s.opts.RequeueAfter
+		}
+
+		return results.RequeueAfter(remaining), nil
+	}
+
+	if err := s.deleteWorkload(ctx, template, state.ActiveColor.Other()); err != nil {
+		return results.Done(), err
+	}
+
+	log.FromContext(ctx).Info("Removed previous generation", "color", state.ActiveColor.Other())
+
+	state.Phase = PhaseActive
+	state.PreviousRevision = ""
+	state.Message = fmt.Sprintf("%s generation is active", state.ActiveColor)
+
+	return results.Done(), nil
+}
+
+func (s *Switcher) rollback(ctx context.Context, template client.Object, service *corev1.Service, state *State, message string) (results.Result, error) {
+	failedColor := state.ActiveColor
+	previousColor := failedColor.Other()
+
+	log.FromContext(ctx).Info("Verification failed, switching traffic back", "color", previousColor, "reason", message)
+
+	if err := s.applyService(ctx, service, previousColor); err != nil {
+		return results.Done(), err
+	}
+
+	if err := s.deleteWorkload(ctx, template, failedColor); err != nil {
+		return results.Done(), err
+	}
+
+	*state = State{
+		Phase:          PhaseRolledBack,
+		ActiveColor:    previousColor,
+		ActiveRevision: state.PreviousRevision,
+		FailedRevision: state.ActiveRevision,
+		SwitchTime:     &metav1.Time{Time: s.opts.Clock.Now()},
+		Message:        fmt.Sprintf("%s generation failed verification: %s", failedColor, message),
+	}
+
+	return results.Done(), nil
+}
+
+// applyWorkload creates or updates the given generation of the workload. A
+// generation that is still being deleted (eg. the previous generation, when
+// a switchover was finished early) is waited for, rather than updated.
+func (s *Switcher) applyWorkload(ctx context.Context, template client.Object, color Color) (client.Object, error) {
+	existing, err := newObject(template)
+	if err != nil {
+		return nil, err
+	}
+
+	key := client.ObjectKey{Namespace: template.GetNamespace(), Name: WorkloadName(template.GetName(), color)}
+	if err := s.c.Get(ctx, key, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get %s generation: %w", color, err)
+		}
+	} else if existing.GetDeletionTimestamp() != nil {
+		return nil, retryable.After(fmt.Errorf("waiting for previous %s generation to be deleted", color), s.opts.RequeueAfter)
+	}
+
+	workloadTemplate, err := newWorkloadTemplate(template, color)
+	if err != nil {
+		return nil, err
+	}
+
+	workload, err := updater.CreateOrUpdateFromTemplate(ctx, s.c, workloadTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s generation: %w", color, err)
+	}
+
+	return workload, nil
+}
+
+// applyService points the service at the given generation.
+func (s *Switcher) applyService(ctx context.Context, service *corev1.Service, color Color) error {
+	template := service.DeepCopy()
+	template.Spec.Selector = withColor(template.Spec.Selector, color)
+
+	if _, err := updater.CreateOrUpdateFromTemplate(ctx, s.c, template); err != nil {
+		return fmt.Errorf("failed to apply service: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Switcher) deleteWorkload(ctx context.Context, template client.Object, color Color) error {
+	workload, err := newObject(template)
+	if err != nil {
+		return err
+	}
+
+	workload.SetNamespace(template.GetNamespace())
+	workload.SetName(WorkloadName(template.GetName(), color))
+
+	if err := s.c.Delete(ctx, workload, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s generation: %w", color, err)
+	}
+
+	return nil
+}
+
+// Condition returns the BlueGreen condition.
+func (s *State) Condition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionTypeBlueGreen,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonProgressing,
+		Message:            s.Message,
+	}
+
+	switch s.Phase {
+	case PhaseActive:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonActive
+	case PhaseVerifying:
+		condition.Reason = ReasonVerifying
+	case PhaseRolledBack:
+		condition.Reason = ReasonRolledBack
+	}
+
+	return condition
+}
+
+// SetCondition sets the BlueGreen condition on the given conditions slice.
+func (s *State) SetCondition(conditions *[]metav1.Condition, generation int64) {
+	meta.SetStatusCondition(conditions, s.Condition(generation))
+}
+
+func newObject(template client.Object) (client.Object, error) {
+	switch template.(type) {
+	case *appsv1.Deployment:
+		return &appsv1.Deployment{}, nil
+	case *appsv1.StatefulSet:
+		return &appsv1.StatefulSet{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported workload type %T", template)
+	}
+}
+
+// newWorkloadTemplate returns a copy of the template for a generation.
+func newWorkloadTemplate(template client.Object, color Color) (client.Object, error) {
+	workload := template.DeepCopyObject().(client.Object)
+	switch workload := workload.(type) {
+	case *appsv1.Deployment:
+		workload.Spec.Selector = withColorSelector(workload.Spec.Selector, color)
+		workload.Spec.Template.Labels = withColor(workload.Spec.Template.Labels, color)
+	case *appsv1.StatefulSet:
+		workload.Spec.Selector = withColorSelector(workload.Spec.Selector, color)
+		workload.Spec.Template.Labels = withColor(workload.Spec.Template.Labels, color)
+	default:
+		return nil, fmt.Errorf("unsupported workload type %T", template)
+	}
+
+	workload.SetName(WorkloadName(template.GetName(), color))
+	workload.SetResourceVersion("")
+
+	return workload, nil
+}
+
+func withColorSelector(selector *metav1.LabelSelector, color Color) *metav1.LabelSelector {
+	if selector == nil {
+		selector = &metav1.LabelSelector{}
+	}
+
+	selector.MatchLabels = withColor(selector.MatchLabels, color)
+
+	return selector
+}
+
+func withColor(labels map[string]string, color Color) map[string]string {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	labels[ColorLabel] = string(color)
+
+	return labels
+}
@@ -0,0 +1,260 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bluegreen_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/bluegreen"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/updater"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSwitcher(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&appsv1.Deployment{}).
+		Build()
+
+	newTemplate := func(image string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.To(int32(2)),
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: image}}},
+				},
+			},
+		}
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "web"},
+			Ports:    []corev1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+
+	ready := func(color bluegreen.Color) {
+		var deploy appsv1.Deployment
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: bluegreen.WorkloadName("web", color)}, &deploy))
+
+		deploy.Status = appsv1.DeploymentStatus{
+			ObservedGeneration: deploy.Generation,
+			Replicas:           *deploy.Spec.Replicas,
+			UpdatedReplicas:    *deploy.Spec.Replicas,
+			ReadyReplicas:      *deploy.Spec.Replicas,
+			AvailableReplicas:  *deploy.Spec.Replicas,
+		}
+		require.NoError(t, c.Status().Update(ctx, &deploy))
+	}
+
+	exists := func(color bluegreen.Color) bool {
+		err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: bluegreen.WorkloadName("web", color)}, &appsv1.Deployment{})
+		if apierrors.IsNotFound(err) {
+			return false
+		}
+		require.NoError(t, err)
+		return true
+	}
+
+	serviceColor := func() string {
+		var svc corev1.Service
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(service), &svc))
+		assert.Equal(t, "web", svc.Spec.Selector["app"])
+		return svc.Spec.Selector[bluegreen.ColorLabel]
+	}
+
+	healthy := true
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+
+	s := bluegreen.NewSwitcher(c, bluegreen.Options{
+		GracePeriod: time.Minute,
+		Verify: func(ctx context.Context, service *corev1.Service, workload client.Object) (bool, string, error) {
+			if !healthy {
+				return false, "smoke test failed", nil
+			}
+			return true, "", nil
+		},
+		Clock: fakeClock,
+	})
+
+	var state bluegreen.State
+
+	// The first generation.
+	v1 := newTemplate("web:v1")
+
+	res, err := s.Reconcile(ctx, v1, service, &state)
+	require.NoError(t, err)
+	assert.False(t, res.IsDone())
+	assert.Equal(t, bluegreen.PhaseProgressing, state.Phase)
+	assert.True(t, exists(bluegreen.Blue))
+
+	ready(bluegreen.Blue)
+
+	res, err = s.Reconcile(ctx, v1, service, &state)
+	require.NoError(t, err)
+	assert.True(t, res.IsDone())
+	assert.Equal(t, bluegreen.PhaseActive, state.Phase)
+	assert.Equal(t, bluegreen.Blue, state.ActiveColor)
+	assert.Equal(t, updater.HashObject(v1), state.ActiveRevision)
+	assert.Equal(t, "blue", serviceColor())
+
+	// Switch to a new generation.
+	v2 := newTemplate("web:v2")
+
+	_, err = s.Reconcile(ctx, v2, service, &state)
+	require.NoError(t, err)
+	assert.Equal(t, bluegreen.PhaseProgressing, state.Phase)
+	assert.Equal(t, "blue", serviceColor())
+
+	var green appsv1.Deployment
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web-green"}, &green))
+	assert.Equal(t, "green", green.Spec.Selector.MatchLabels[bluegreen.ColorLabel])
+	assert.Equal(t, "green", green.Spec.Template.Labels[bluegreen.ColorLabel])
+
+	ready(bluegreen.Green)
+
+	res, err = s.Reconcile(ctx, v2, service, &state)
+	require.NoError(t, err)
+	assert.False(t, res.IsDone())
+	assert.Equal(t, bluegreen.PhaseVerifying, state.Phase)
+	assert.Equal(t, "green", serviceColor())
+	assert.True(t, exists(bluegreen.Blue))
+
+	// The previous generation is retained for the grace period.
+	res, err = s.Reconcile(ctx, v2, service, &state)
+	require.NoError(t, err)
+	assert.False(t, res.IsDone())
+	assert.True(t, exists(bluegreen.Blue))
+
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+
+	res, err = s.Reconcile(ctx, v2, service, &state)
+	require.NoError(t, err)
+	assert.True(t, res.IsDone())
+	assert.Equal(t, bluegreen.PhaseActive, state.Phase)
+	assert.False(t, exists(bluegreen.Blue))
+	assert.Equal(t, metav1.ConditionTrue, state.Condition(1).Status)
+
+	t.Run("RollBack", func(t *testing.T) {
+		v3 := newTemplate("web:v3")
+
+		_, err := s.Reconcile(ctx, v3, service, &state)
+		require.NoError(t, err)
+
+		ready(bluegreen.Blue)
+
+		_, err = s.Reconcile(ctx, v3, service, &state)
+		require.NoError(t, err)
+		assert.Equal(t, "blue", serviceColor())
+
+		healthy = false
+
+		res, err := s.Reconcile(ctx, v3, service, &state)
+		require.NoError(t, err)
+		assert.True(t, res.IsDone())
+		assert.Equal(t, bluegreen.PhaseRolledBack, state.Phase)
+		assert.Equal(t, bluegreen.Green, state.ActiveColor)
+		assert.Equal(t, updater.HashObject(v2), state.ActiveRevision)
+		assert.Equal(t, updater.HashObject(v3), state.FailedRevision)
+		assert.Equal(t, "blue generation failed verification: smoke test failed", state.Message)
+		assert.Equal(t, "green", serviceColor())
+		assert.False(t, exists(bluegreen.Blue))
+		assert.Equal(t, bluegreen.ReasonRolledBack, state.Condition(2).Reason)
+
+		// The failed revision isn't retried.
+		_, err = s.Reconcile(ctx, v3, service, &state)
+		require.NoError(t, err)
+		assert.False(t, exists(bluegreen.Blue))
+	})
+
+	t.Run("Active Generation Deleted", func(t *testing.T) {
+		require.NoError(t, c.Delete(ctx, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: bluegreen.WorkloadName("web", bluegreen.Green)},
+		}))
+
+		_, err := s.Reconcile(ctx, v2, service, &state)
+		require.NoError(t, err)
+		assert.True(t, exists(bluegreen.Green))
+	})
+
+	t.Run("Superseded Switchover", func(t *testing.T) {
+		healthy = true
+
+		v4 := newTemplate("web:v4")
+
+		_, err := s.Reconcile(ctx, v4, service, &state)
+		require.NoError(t, err)
+
+		ready(bluegreen.Blue)
+
+		_, err = s.Reconcile(ctx, v4, service, &state)
+		require.NoError(t, err)
+		assert.Equal(t, bluegreen.PhaseVerifying, state.Phase)
+		assert.Equal(t, "blue", serviceColor())
+
+		// Keep the previous generation around after it's deleted.
+		var green appsv1.Deployment
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: bluegreen.WorkloadName("web", bluegreen.Green)}, &green))
+		green.Finalizers = []string{"example.com/finalizer"}
+		require.NoError(t, c.Update(ctx, &green))
+
+		v5 := newTemplate("web:v5")
+
+		_, err = s.Reconcile(ctx, v5, service, &state)
+		require.Error(t, err)
+		assert.True(t, retryable.Is(err))
+
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(&green), &green))
+		assert.NotNil(t, green.DeletionTimestamp)
+		assert.Equal(t, "web:v2", green.Spec.Template.Spec.Containers[0].Image)
+
+		green.Finalizers = nil
+		require.NoError(t, c.Update(ctx, &green))
+
+		_, err = s.Reconcile(ctx, v5, service, &state)
+		require.NoError(t, err)
+		assert.Equal(t, bluegreen.PhaseProgressing, state.Phase)
+
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(&green), &green))
+		assert.Equal(t, "web:v5", green.Spec.Template.Spec.Containers[0].Image)
+	})
+}
@@ -0,0 +1,308 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bootstrap gates an operator's startup on its prerequisites (eg.
+// the CRDs it watches, the operators it depends on, and the webhooks it
+// relies on), so that it doesn't start reconciling (and spewing errors)
+// before its dependencies exist.
+//
+// Typical usage:
+//
+//	gate := bootstrap.NewGate(bootstrap.Options{},
+//		bootstrap.CRDEstablished(c, "certificates.cert-manager.io"),
+//		bootstrap.DeploymentReady(c, client.ObjectKey{Namespace: "cert-manager", Name: "cert-manager-webhook"}),
+//	)
+//
+//	_ = mgr.Add(gate)
+//	_ = mgr.AddReadyzCheck("dependencies", gate.Checker())
+//
+//	err := ctrl.NewControllerManagedBy(mgr).For(&v1.Foo{}).Complete(gate.Wrap(reconciler))
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"github.com/gpu-ninja/operator-utils/waitutil"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	DefaultTimeout      = 5 * time.Minute
+	DefaultInterval     = 5 * time.Second
+	DefaultRequeueAfter = 5 * time.Second
+)
+
+// ErrNotReady is returned while dependencies are not ready.
+var ErrNotReady = errors.New("dependencies not ready")
+
+// Dependency is a startup prerequisite.
+type Dependency struct {
+	// Name identifies the dependency in logs, metrics, and errors.
+	Name string
+	// Check returns nil once the dependency is ready, or a retryable error
+	// explaining why it isn't. Any other error is treated as fatal.
+	Check waitutil.CheckFunc
+	// Timeout is how long to wait for the dependency, defaults to the
+	// gate's timeout.
+	Timeout time.Duration
+}
+
+// Options configures a Gate.
+type Options struct {
+	// Timeout is how long to wait for each dependency. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Interval is how often dependencies are checked. Defaults to DefaultInterval.
+	Interval time.Duration
+	// FailOnTimeout causes the gate (when run by the manager) to return an
+	// error, stopping the manager, if a dependency times out. Otherwise the
+	// gate keeps waiting (and remains not ready).
+	FailOnTimeout bool
+	// RequeueAfter is how long wrapped reconcilers wait before retrying
+	// while dependencies are not ready. Defaults to DefaultRequeueAfter.
+	RequeueAfter time.Duration
+	// Clock is used to schedule checks and timeouts (useful for testing).
+	Clock clock.WithTicker
+}
+
+// Gate waits for a set of dependencies to become ready.
+type Gate struct {
+	deps []Dependency
+	opts Options
+
+	mu      sync.Mutex
+	pending map[string]error
+	ready   chan struct{}
+	once    sync.Once
+}
+
+var (
+	_ manager.Runnable               = (*Gate)(nil)
+	_ manager.LeaderElectionRunnable = (*Gate)(nil)
+)
+
+// NewGate returns a new gate for the given dependencies.
+func NewGate(opts Options, deps ...Dependency) *Gate {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	if opts.Interval == 0 {
+		opts.Interval = DefaultInterval
+	}
+
+	if opts.RequeueAfter == 0 {
+		opts.RequeueAfter = DefaultRequeueAfter
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	g := &Gate{
+		deps:    deps,
+		opts:    opts,
+		pending: make(map[string]error, len(deps)),
+		ready:   make(chan struct{}),
+	}
+
+	for _, dep := range deps {
+		g.pending[dep.Name] = ErrNotReady
+		metrics.BootstrapDependencyReady.WithLabelValues(dep.Name).Set(0)
+	}
+
+	if len(deps) == 0 {
+		close(g.ready)
+	}
+
+	return g
+}
+
+// Wait waits (concurrently) for every dependency to become ready, it
+// returns an error if any dependency is not ready within its timeout.
+func (g *Gate) Wait(ctx context.Context) error {
+	return g.wait(ctx, false)
+}
+
+// Start implements manager.Runnable, waiting for the dependencies in the
+// background. Dependencies that time out continue to be waited for, unless
+// FailOnTimeout is set.
+func (g *Gate) Start(ctx context.Context) error {
+	err := g.wait(ctx, !g.opts.FailOnTimeout)
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+// Every replica needs its dependencies.
+func (g *Gate) NeedLeaderElection() bool {
+	return false
+}
+
+func (g *Gate) wait(ctx context.Context, retryOnTimeout bool) error {
+	errs := make([]error, len(g.deps))
+
+	var wg sync.WaitGroup
+	for i := range g.deps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			errs[i] = g.waitFor(ctx, &g.deps[i], retryOnTimeout)
+		}(i)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (g *Gate) waitFor(ctx context.Context, dep *Dependency, retryOnTimeout bool) error {
+	logger := log.FromContext(ctx).WithValues("dependency", dep.Name)
+
+	timeout := dep.Timeout
+	if timeout == 0 {
+		timeout = g.opts.Timeout
+	}
+
+	start := g.opts.Clock.Now()
+
+	for {
+		logger.V(1).Info("Waiting for dependency")
+
+		err := waitutil.Poll(ctx, func(ctx context.Context) error {
+			err := dep.Check(ctx)
+			g.setPending(dep.Name, err)
+			return err
+		}, waitutil.Options{
+			Interval: g.opts.Interval,
+			Timeout:  timeout,
+			Clock:    g.opts.Clock,
+		})
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, waitutil.ErrTimeout) || !retryOnTimeout {
+			return fmt.Errorf("dependency %q not ready: %w", dep.Name, err)
+		}
+
+		logger.Error(err, "Dependency not ready, continuing to wait")
+	}
+
+	elapsed := g.opts.Clock.Since(start)
+
+	logger.Info("Dependency ready", "waited", elapsed.Round(time.Millisecond).String())
+
+	metrics.BootstrapDependencyReady.WithLabelValues(dep.Name).Set(1)
+	metrics.BootstrapDependencyWaitSeconds.WithLabelValues(dep.Name).Set(elapsed.Seconds())
+
+	return nil
+}
+
+func (g *Gate) setPending(name string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err != nil {
+		// Only record why the dependency is pending if it still is.
+		if _, ok := g.pending[name]; ok {
+			g.pending[name] = err
+		}
+
+		return
+	}
+
+	delete(g.pending, name)
+
+	if len(g.pending) == 0 {
+		g.once.Do(func() { close(g.ready) })
+	}
+}
+
+// Ready returns true once every dependency is ready.
+func (g *Gate) Ready() bool {
+	select {
+	case <-g.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel that is closed once every dependency is ready.
+func (g *Gate) Done() <-chan struct{} {
+	return g.ready
+}
+
+// Err returns ErrNotReady, wrapped with why each pending dependency isn't
+// ready, or nil once every dependency is ready.
+func (g *Gate) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.pending) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(g.pending))
+	for name := range g.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	details := make([]string, 0, len(names))
+	for _, name := range names {
+		details = append(details, fmt.Sprintf("%s: %v", name, g.pending[name]))
+	}
+
+	return fmt.Errorf("%w: %s", ErrNotReady, strings.Join(details, "; "))
+}
+
+// Checker returns a readyz checker that fails until every dependency is ready.
+func (g *Gate) Checker() healthz.Checker {
+	return func(_ *http.Request) error {
+		return g.Err()
+	}
+}
+
+// Wrap wraps a reconciler so that reconciles are requeued (rather than
+// failing) until every dependency is ready.
+func (g *Gate) Wrap(r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		if err := g.Err(); err != nil {
+			log.FromContext(ctx).V(1).Info("Waiting for dependencies", "reason", err.Error())
+
+			return reconcile.Result{RequeueAfter: g.opts.RequeueAfter}, nil
+		}
+
+		return r.Reconcile(ctx, req)
+	})
+}
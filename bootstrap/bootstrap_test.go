@@ -0,0 +1,174 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bootstrap_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/bootstrap"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/waitutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestGate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, apiextensionsv1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	webhookConfig := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "cert-manager-webhook"},
+	}
+
+	gate := bootstrap.NewGate(bootstrap.Options{Interval: 10 * time.Millisecond},
+		bootstrap.CRDEstablished(c, "certificates.cert-manager.io"),
+		bootstrap.DeploymentReady(c, client.ObjectKey{Namespace: "cert-manager", Name: "cert-manager"}),
+		bootstrap.WebhookServing(c, webhookConfig),
+	)
+
+	var reconciled int
+	r := gate.Wrap(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		reconciled++
+		return reconcile.Result{}, nil
+	}))
+
+	started := make(chan error, 1)
+	go func() {
+		started <- gate.Start(ctx)
+	}()
+
+	assert.False(t, gate.Ready())
+
+	res, err := r.Reconcile(ctx, reconcile.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, bootstrap.DefaultRequeueAfter, res.RequeueAfter)
+	assert.Zero(t, reconciled)
+
+	require.Eventually(t, func() bool {
+		err := gate.Checker()(nil)
+		return err != nil && strings.Count(err.Error(), "not found") == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err = gate.Checker()(nil)
+	assert.ErrorIs(t, err, bootstrap.ErrNotReady)
+	assert.ErrorContains(t, err, `crd/certificates.cert-manager.io: failed to get crd`)
+	assert.ErrorContains(t, err, `deployment/cert-manager/cert-manager: failed to get deployment`)
+	assert.ErrorContains(t, err, `validatingwebhookconfiguration/cert-manager-webhook: failed to get webhook configuration`)
+
+	// Satisfy the dependencies.
+	require.NoError(t, c.Create(ctx, &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "certificates.cert-manager.io"},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			},
+		},
+	}))
+
+	require.NoError(t, c.Create(ctx, &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "cert-manager"},
+		Status:     appsv1.DeploymentStatus{UpdatedReplicas: 1, AvailableReplicas: 1},
+	}))
+
+	webhookConfig = webhookConfig.DeepCopy()
+	webhookConfig.Webhooks = []admissionregistrationv1.ValidatingWebhook{{
+		Name: "webhook.cert-manager.io",
+		ClientConfig: admissionregistrationv1.WebhookClientConfig{
+			Service: &admissionregistrationv1.ServiceReference{Namespace: "cert-manager", Name: "cert-manager-webhook"},
+		},
+	}}
+	require.NoError(t, c.Create(ctx, webhookConfig))
+
+	require.NoError(t, c.Create(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "cert-manager-webhook"},
+	}))
+
+	// The webhook isn't serving until it has endpoints.
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, gate.Ready())
+
+	require.NoError(t, c.Create(ctx, &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "cert-manager",
+			Name:      "cert-manager-webhook-abcde",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "cert-manager-webhook"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+	}))
+
+	select {
+	case <-gate.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("dependencies not ready")
+	}
+
+	require.NoError(t, <-started)
+	assert.True(t, gate.Ready())
+	assert.NoError(t, gate.Checker()(nil))
+
+	_, err = r.Reconcile(ctx, reconcile.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, reconciled)
+
+	t.Run("Timeout", func(t *testing.T) {
+		gate := bootstrap.NewGate(bootstrap.Options{Interval: 10 * time.Millisecond}, bootstrap.Dependency{
+			Name:    "never",
+			Check:   func(ctx context.Context) error { return retryable.Errorf("not yet") },
+			Timeout: 50 * time.Millisecond,
+		})
+
+		err := gate.Wait(ctx)
+		require.ErrorIs(t, err, waitutil.ErrTimeout)
+		assert.ErrorContains(t, err, `dependency "never" not ready`)
+		assert.False(t, gate.Ready())
+	})
+
+	t.Run("Fatal", func(t *testing.T) {
+		errFatal := errors.New("forbidden")
+
+		gate := bootstrap.NewGate(bootstrap.Options{}, bootstrap.Dependency{
+			Name:  "fatal",
+			Check: func(ctx context.Context) error { return errFatal },
+		})
+
+		err := gate.Start(ctx)
+		assert.ErrorIs(t, err, errFatal)
+	})
+}
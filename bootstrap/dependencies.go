@@ -0,0 +1,149 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gpu-ninja/operator-utils/reachability"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CRDEstablished waits for the named CRD to exist and be established.
+func CRDEstablished(c client.Reader, name string) Dependency {
+	return Dependency{
+		Name: "crd/" + name,
+		Check: func(ctx context.Context) error {
+			var crd apiextensionsv1.CustomResourceDefinition
+			if err := c.Get(ctx, client.ObjectKey{Name: name}, &crd); err != nil {
+				return pending(err, "failed to get crd")
+			}
+
+			for _, condition := range crd.Status.Conditions {
+				if condition.Type == apiextensionsv1.Established && condition.Status == apiextensionsv1.ConditionTrue {
+					return nil
+				}
+			}
+
+			return retryable.Errorf("crd %q not established", name)
+		},
+	}
+}
+
+// DeploymentReady waits for a Deployment (eg. of another operator) to exist
+// and have all its replicas updated and available.
+func DeploymentReady(c client.Reader, key client.ObjectKey) Dependency {
+	return Dependency{
+		Name: "deployment/" + key.String(),
+		Check: func(ctx context.Context) error {
+			var deploy appsv1.Deployment
+			if err := c.Get(ctx, key, &deploy); err != nil {
+				return pending(err, "failed to get deployment")
+			}
+
+			replicas := int32(1)
+			if deploy.Spec.Replicas != nil {
+				replicas = *deploy.Spec.Replicas
+			}
+
+			if deploy.Status.ObservedGeneration < deploy.Generation ||
+				deploy.Status.UpdatedReplicas < replicas || deploy.Status.AvailableReplicas < replicas {
+				return retryable.Errorf("deployment %q has %d/%d replicas available", key, deploy.Status.AvailableReplicas, replicas)
+			}
+
+			return nil
+		},
+	}
+}
+
+// WebhookServing waits for a ValidatingWebhookConfiguration or
+// MutatingWebhookConfiguration (of the given name) to exist, and for the
+// services backing its webhooks to have ready endpoints.
+func WebhookServing(c client.Client, config client.Object) Dependency {
+	config = config.DeepCopyObject().(client.Object)
+
+	kind := "webhook"
+	switch config.(type) {
+	case *admissionregistrationv1.ValidatingWebhookConfiguration:
+		kind = "validatingwebhookconfiguration"
+	case *admissionregistrationv1.MutatingWebhookConfiguration:
+		kind = "mutatingwebhookconfiguration"
+	}
+
+	return Dependency{
+		Name: kind + "/" + config.GetName(),
+		Check: func(ctx context.Context) error {
+			var services []*admissionregistrationv1.ServiceReference
+
+			switch config := config.DeepCopyObject().(type) {
+			case *admissionregistrationv1.ValidatingWebhookConfiguration:
+				if err := c.Get(ctx, client.ObjectKeyFromObject(config), config); err != nil {
+					return pending(err, "failed to get webhook configuration")
+				}
+
+				for _, webhook := range config.Webhooks {
+					services = append(services, webhook.ClientConfig.Service)
+				}
+			case *admissionregistrationv1.MutatingWebhookConfiguration:
+				if err := c.Get(ctx, client.ObjectKeyFromObject(config), config); err != nil {
+					return pending(err, "failed to get webhook configuration")
+				}
+
+				for _, webhook := range config.Webhooks {
+					services = append(services, webhook.ClientConfig.Service)
+				}
+			default:
+				return fmt.Errorf("unsupported webhook configuration type %T", config)
+			}
+
+			for _, ref := range services {
+				// Webhooks served by URL are outside the cluster.
+				if ref == nil {
+					continue
+				}
+
+				var svc corev1.Service
+				if err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, &svc); err != nil {
+					return pending(err, "failed to get webhook service")
+				}
+
+				if err := reachability.ServiceHasEndpoints(ctx, c, &svc); err != nil {
+					if retryable.Is(err) {
+						return err
+					}
+
+					return pending(err, "failed to check webhook service")
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// pending wraps an API error as retryable, as the API server may itself
+// still be starting (and missing objects may yet be created).
+func pending(err error, msg string) error {
+	return retryable.New(fmt.Errorf("%s: %w", msg, err))
+}
@@ -0,0 +1,431 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package canary rolls out changes to a managed Deployment or StatefulSet in
+// canary steps. Rather than updating the stable workload directly, a canary
+// copy of the workload running the new template is scaled through the steps
+// of a policy (typically declared on the parent custom resource). At each
+// step the canary's health, and any analysis checks (eg. metric queries),
+// are evaluated before proceeding. Once every step has passed the stable
+// workload is updated (promoted) and the canary removed; if a step fails the
+// canary is removed, leaving the stable workload untouched (rolled back).
+//
+// The canary's pods carry the labels of the stable workload's pods (so are
+// selected by the same services), along with the CanaryLabel. Canary
+// replicas are in addition to the stable replicas. StatefulSet canaries are
+// separate pods (with their own volume claims) named after the canary.
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/results"
+	"github.com/gpu-ninja/operator-utils/rollout"
+	"github.com/gpu-ninja/operator-utils/updater"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// CanaryLabel is set on the canary workload's pods.
+	CanaryLabel = "gpu-ninja.com/canary"
+	// ConditionTypeCanary is the type of the canary condition.
+	ConditionTypeCanary = "Canary"
+	// DefaultRequeueAfter is how long to wait before rechecking a canary in progress.
+	DefaultRequeueAfter = 10 * time.Second
+)
+
+// Condition reasons.
+const (
+	ReasonCanaryProgressing = "CanaryProgressing"
+	ReasonCanarySucceeded   = "CanarySucceeded"
+	ReasonCanaryRolledBack  = "CanaryRolledBack"
+)
+
+// ErrUnknownCheck is returned when a step references an unregistered check.
+var ErrUnknownCheck = errors.New("unknown check")
+
+// Phase is the phase of a canary rollout.
+type Phase string
+
+const (
+	// PhaseProgressing indicates the canary is being stepped through the policy.
+	PhaseProgressing Phase = "Progressing"
+	// PhaseSucceeded indicates the canary was promoted (or no rollout was required).
+	PhaseSucceeded Phase = "Succeeded"
+	// PhaseRolledBack indicates the canary failed and was removed.
+	PhaseRolledBack Phase = "RolledBack"
+)
+
+// Policy is the canary policy, suitable for embedding in the spec of a
+// custom resource.
+// +kubebuilder:object:generate=true
+type Policy struct {
+	// Steps are the canary steps, in order. Without any steps changes are
+	// applied to the stable workload directly.
+	Steps []Step `json:"steps,omitempty"`
+}
+
+// Step is a single canary step.
+// +kubebuilder:object:generate=true
+type Step struct {
+	// Replicas is the number of canary replicas.
+	Replicas int32 `json:"replicas"`
+	// Pause is how long the canary is observed (once ready) before proceeding.
+	Pause metav1.Duration `json:"pause,omitempty"`
+	// Checks are the names of the analysis checks that must pass before proceeding.
+	Checks []string `json:"checks,omitempty"`
+}
+
+// State is the state of a canary rollout, suitable for embedding in the
+// status of a custom resource.
+// +kubebuilder:object:generate=true
+type State struct {
+	// Phase is the phase of the rollout.
+	Phase Phase `json:"phase,omitempty"`
+	// Revision is the revision (template hash) being rolled out.
+	Revision string `json:"revision,omitempty"`
+	// Step is the index of the current step.
+	Step int32 `json:"step,omitempty"`
+	// StepReadyTime is when the canary became ready for the current step.
+	StepReadyTime *metav1.Time `json:"stepReadyTime,omitempty"`
+	// Message is a human readable description of the rollout's progress.
+	Message string `json:"message,omitempty"`
+}
+
+// Check is an analysis check run against the canary workload, it returns
+// whether the canary is healthy along with a human readable message
+// explaining why not.
+type Check func(ctx context.Context, canary client.Object) (bool, string, error)
+
+// QueryFunc returns the current value of a metric (eg. the canary's error
+// rate from Prometheus).
+type QueryFunc func(ctx context.Context, canary client.Object) (float64, error)
+
+// MaxThreshold returns a check that passes while the queried value does not
+// exceed the given threshold.
+func MaxThreshold(query QueryFunc, threshold float64) Check {
+	return func(ctx context.Context, canary client.Object) (bool, string, error) {
+		value, err := query(ctx, canary)
+		if err != nil {
+			return false, "", err
+		}
+
+		if value > threshold {
+			return false, fmt.Sprintf("value %g exceeds threshold %g", value, threshold), nil
+		}
+
+		return true, "", nil
+	}
+}
+
+// Options configures an Engine.
+type Options struct {
+	// Checks are the analysis checks that can be referenced by steps.
+	Checks map[string]Check
+	// RequeueAfter is how long to wait before rechecking a canary in
+	// progress. Defaults to DefaultRequeueAfter.
+	RequeueAfter time.Duration
+	// Clock is used to time step pauses (useful for testing).
+	Clock clock.PassiveClock
+}
+
+// Engine orchestrates canary rollouts.
+type Engine struct {
+	c    client.Client
+	opts Options
+}
+
+// NewEngine returns a new canary rollout engine.
+func NewEngine(c client.Client, opts Options) *Engine {
+	if opts.RequeueAfter == 0 {
+		opts.RequeueAfter = DefaultRequeueAfter
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &Engine{
+		c:    c,
+		opts: opts,
+	}
+}
+
+// CanaryName returns the name of the canary workload for a stable workload.
+func CanaryName(name string) string {
+	return name + "-canary"
+}
+
+// Reconcile rolls out the template (a Deployment or StatefulSet) according
+// to the policy, recording its progress in the state. It returns a result
+// that requeues the parent until the rollout completes.
+func (e *Engine) Reconcile(ctx context.Context, template client.Object, policy *Policy, state *State) (results.Result, error) {
+	logger := log.FromContext(ctx)
+
+	revision := updater.HashObject(template)
+
+	stable, err := newObject(template)
+	if err != nil {
+		return results.Done(), err
+	}
+
+	stableExists := true
+	if err := e.c.Get(ctx, client.ObjectKeyFromObject(template), stable); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return results.Done(), fmt.Errorf("failed to get stable workload: %w", err)
+		}
+
+		stableExists = false
+	}
+
+	stableRevision := ""
+	if stableExists {
+		if stableRevision, err = updater.GetHash(stable); err != nil {
+			return results.Done(), fmt.Errorf("failed to get stable revision: %w", err)
+		}
+	}
+
+	// Nothing to canary (eg. the initial deployment), or already promoted.
+	if !stableExists || stableRevision == revision || len(policy.Steps) == 0 {
+		return e.promote(ctx, template, revision, state)
+	}
+
+	// Don't retry a revision that has already been rolled back.
+	if state.Revision == revision && state.Phase == PhaseRolledBack {
+		return results.Done(), e.deleteCanary(ctx, template)
+	}
+
+	if state.Revision != revision || state.Phase != PhaseProgressing {
+		logger.Info("Starting canary", "revision", revision)
+
+		*state = State{
+			Phase:    PhaseProgressing,
+			Revision: revision,
+		}
+	}
+
+	// The policy may have lost steps since the rollout started.
+	if int(state.Step) >= len(policy.Steps) {
+		return e.promote(ctx, template, revision, state)
+	}
+
+	step := policy.Steps[state.Step]
+
+	canaryTemplate, err := newCanaryTemplate(template, step.Replicas)
+	if err != nil {
+		return results.Done(), err
+	}
+
+	canary, err := updater.CreateOrUpdateFromTemplate(ctx, e.c, canaryTemplate)
+	if err != nil {
+		return results.Done(), fmt.Errorf("failed to apply canary: %w", err)
+	}
+
+	status, err := rollout.Inspect(ctx, e.c, canary)
+	if err != nil {
+		return results.Done(), fmt.Errorf("failed to inspect canary: %w", err)
+	}
+
+	switch status.Phase {
+	case rollout.PhaseStalled:
+		return e.rollback(ctx, template, state, fmt.Sprintf("step %d: canary stalled: %s", state.Step+1, status.Message))
+	case rollout.PhaseProgressing:
+		state.StepReadyTime = nil
+		state.Message = fmt.Sprintf("step %d/%d: waiting for canary: %s", state.Step+1, len(policy.Steps), status.Message)
+		return results.RequeueAfter(e.opts.RequeueAfter), nil
+	}
+
+	for _, name := range step.Checks {
+		check, ok := e.opts.Checks[name]
+		if !ok {
+			return results.Done(), fmt.Errorf("%w: %q", ErrUnknownCheck, name)
+		}
+
+		healthy, message, err := check(ctx, canary)
+		if err != nil {
+			return results.Done(), fmt.Errorf("failed to run check %q: %w", name, err)
+		}
+
+		if !healthy {
+			return e.rollback(ctx, template, state, fmt.Sprintf("step %d: check %q failed: %s", state.Step+1, name, message))
+		}
+	}
+
+	now := e.opts.Clock.Now()
+	if state.StepReadyTime == nil {
+		state.StepReadyTime = &metav1.Time{Time: now}
+	}
+
+	if remaining := state.StepReadyTime.Add(step.Pause.Duration).Sub(now); remaining > 0 {
+		state.Message = fmt.Sprintf("step %d/%d: observing canary", state.Step+1, len(policy.Steps))
+
+		// Recheck periodically so failing checks are caught during the pause.
+		if remaining > e.opts.RequeueAfter {
+			remaining = e.opts.RequeueAfter
+		}
+
+		return results.RequeueAfter(remaining), nil
+	}
+
+	state.Step++
+	state.StepReadyTime = nil
+
+	if int(state.Step) < len(policy.Steps) {
+		state.Message = fmt.Sprintf("step %d/%d: scaling canary", state.Step+1, len(policy.Steps))
+		return results.Requeue(), nil
+	}
+
+	logger.Info("Promoting canary", "revision", revision)
+
+	return e.promote(ctx, template, revision, state)
+}
+
+func (e *Engine) promote(ctx context.Context, template client.Object, revision string, state *State) (results.Result, error) {
+	if _, err := updater.CreateOrUpdateFromTemplate(ctx, e.c, template); err != nil {
+		return results.Done(), fmt.Errorf("failed to apply stable workload: %w", err)
+	}
+
+	if err := e.deleteCanary(ctx, template); err != nil {
+		return results.Done(), err
+	}
+
+	*state = State{
+		Phase:    PhaseSucceeded,
+		Revision: revision,
+		Message:  "revision " + revision + " is stable",
+	}
+
+	return results.Done(), nil
+}
+
+func (e *Engine) rollback(ctx context.Context, template client.Object, state *State, message string) (results.Result, error) {
+	log.FromContext(ctx).Info("Rolling back canary", "revision", state.Revision, "reason", message)
+
+	if err := e.deleteCanary(ctx, template); err != nil {
+		return results.Done(), err
+	}
+
+	state.Phase = PhaseRolledBack
+	state.StepReadyTime = nil
+	state.Message = message
+
+	return results.Done(), nil
+}
+
+func (e *Engine) deleteCanary(ctx context.Context, template client.Object) error {
+	canary, err := newObject(template)
+	if err != nil {
+		return err
+	}
+
+	canary.SetNamespace(template.GetNamespace())
+	canary.SetName(CanaryName(template.GetName()))
+
+	if err := e.c.Delete(ctx, canary, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete canary: %w", err)
+	}
+
+	return nil
+}
+
+// Condition returns the Canary condition.
+func (s *State) Condition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionTypeCanary,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonCanaryProgressing,
+		Message:            s.Message,
+	}
+
+	switch s.Phase {
+	case PhaseSucceeded, "":
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonCanarySucceeded
+	case PhaseRolledBack:
+		condition.Reason = ReasonCanaryRolledBack
+	}
+
+	return condition
+}
+
+// SetCondition sets the Canary condition on the given conditions slice.
+func (s *State) SetCondition(conditions *[]metav1.Condition, generation int64) {
+	meta.SetStatusCondition(conditions, s.Condition(generation))
+}
+
+func newObject(template client.Object) (client.Object, error) {
+	switch template.(type) {
+	case *appsv1.Deployment:
+		return &appsv1.Deployment{}, nil
+	case *appsv1.StatefulSet:
+		return &appsv1.StatefulSet{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported workload type %T", template)
+	}
+}
+
+// newCanaryTemplate returns a copy of the template for the canary workload.
+func newCanaryTemplate(template client.Object, replicas int32) (client.Object, error) {
+	canary := template.DeepCopyObject().(client.Object)
+	switch workload := canary.(type) {
+	case *appsv1.Deployment:
+		workload.Spec.Replicas = &replicas
+		workload.Spec.Selector = withCanarySelector(workload.Spec.Selector)
+		workload.Spec.Template.Labels = withCanaryLabel(workload.Spec.Template.Labels)
+	case *appsv1.StatefulSet:
+		workload.Spec.Replicas = &replicas
+		workload.Spec.Selector = withCanarySelector(workload.Spec.Selector)
+		workload.Spec.Template.Labels = withCanaryLabel(workload.Spec.Template.Labels)
+	default:
+		return nil, fmt.Errorf("unsupported workload type %T", template)
+	}
+
+	canary.SetName(CanaryName(template.GetName()))
+	canary.SetResourceVersion("")
+
+	return canary, nil
+}
+
+func withCanarySelector(selector *metav1.LabelSelector) *metav1.LabelSelector {
+	if selector == nil {
+		selector = &metav1.LabelSelector{}
+	}
+
+	selector.MatchLabels = withCanaryLabel(selector.MatchLabels)
+
+	return selector
+}
+
+func withCanaryLabel(labels map[string]string) map[string]string {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	labels[CanaryLabel] = "true"
+
+	return labels
+}
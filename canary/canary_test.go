@@ -0,0 +1,205 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package canary_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/canary"
+	"github.com/gpu-ninja/operator-utils/updater"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&appsv1.Deployment{}).
+		Build()
+
+	newTemplate := func(image string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.To(int32(3)),
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: image}}},
+				},
+			},
+		}
+	}
+
+	// Marks the canary as rolled out.
+	canaryReady := func() {
+		var deploy appsv1.Deployment
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: canary.CanaryName("web")}, &deploy))
+
+		deploy.Status = appsv1.DeploymentStatus{
+			ObservedGeneration: deploy.Generation,
+			Replicas:           *deploy.Spec.Replicas,
+			UpdatedReplicas:    *deploy.Spec.Replicas,
+			ReadyReplicas:      *deploy.Spec.Replicas,
+			AvailableReplicas:  *deploy.Spec.Replicas,
+		}
+		require.NoError(t, c.Status().Update(ctx, &deploy))
+	}
+
+	stableImage := func() string {
+		var deploy appsv1.Deployment
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, &deploy))
+		return deploy.Spec.Template.Spec.Containers[0].Image
+	}
+
+	canaryExists := func() bool {
+		err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: canary.CanaryName("web")}, &appsv1.Deployment{})
+		if apierrors.IsNotFound(err) {
+			return false
+		}
+		require.NoError(t, err)
+		return true
+	}
+
+	errorRate := 0.0
+	fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+
+	e := canary.NewEngine(c, canary.Options{
+		Checks: map[string]canary.Check{
+			"error-rate": canary.MaxThreshold(func(ctx context.Context, canary client.Object) (float64, error) {
+				return errorRate, nil
+			}, 0.01),
+		},
+		Clock: fakeClock,
+	})
+
+	policy := &canary.Policy{
+		Steps: []canary.Step{
+			{Replicas: 1, Pause: metav1.Duration{Duration: time.Minute}, Checks: []string{"error-rate"}},
+			{Replicas: 2, Checks: []string{"error-rate"}},
+		},
+	}
+
+	var state canary.State
+
+	// The initial deployment is applied directly.
+	res, err := e.Reconcile(ctx, newTemplate("web:v1"), policy, &state)
+	require.NoError(t, err)
+	assert.True(t, res.IsDone())
+	assert.Equal(t, canary.PhaseSucceeded, state.Phase)
+	assert.Equal(t, "web:v1", stableImage())
+	assert.False(t, canaryExists())
+
+	// Roll out v2.
+	v2 := newTemplate("web:v2")
+
+	res, err = e.Reconcile(ctx, v2, policy, &state)
+	require.NoError(t, err)
+	assert.Equal(t, canary.DefaultRequeueAfter, res.After())
+	assert.Equal(t, canary.PhaseProgressing, state.Phase)
+	assert.Equal(t, updater.HashObject(v2), state.Revision)
+	assert.Contains(t, state.Message, "step 1/2: waiting for canary")
+
+	var canaryDeploy appsv1.Deployment
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: canary.CanaryName("web")}, &canaryDeploy))
+	assert.Equal(t, int32(1), *canaryDeploy.Spec.Replicas)
+	assert.Equal(t, "true", canaryDeploy.Spec.Template.Labels[canary.CanaryLabel])
+	assert.Equal(t, "web", canaryDeploy.Spec.Template.Labels["app"])
+	assert.Equal(t, "web:v2", canaryDeploy.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "web:v1", stableImage())
+
+	canaryReady()
+
+	// Observing the canary.
+	res, err = e.Reconcile(ctx, v2, policy, &state)
+	require.NoError(t, err)
+	assert.False(t, res.IsDone())
+	assert.Equal(t, int32(0), state.Step)
+	assert.NotNil(t, state.StepReadyTime)
+
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+
+	res, err = e.Reconcile(ctx, v2, policy, &state)
+	require.NoError(t, err)
+	assert.False(t, res.IsDone())
+	assert.Equal(t, int32(1), state.Step)
+
+	res, err = e.Reconcile(ctx, v2, policy, &state)
+	require.NoError(t, err)
+	assert.False(t, res.IsDone())
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(&canaryDeploy), &canaryDeploy))
+	assert.Equal(t, int32(2), *canaryDeploy.Spec.Replicas)
+
+	canaryReady()
+
+	// Promoted.
+	res, err = e.Reconcile(ctx, v2, policy, &state)
+	require.NoError(t, err)
+	assert.True(t, res.IsDone())
+	assert.Equal(t, canary.PhaseSucceeded, state.Phase)
+	assert.Equal(t, "web:v2", stableImage())
+	assert.False(t, canaryExists())
+	assert.Equal(t, metav1.ConditionTrue, state.Condition(1).Status)
+
+	t.Run("RollBack", func(t *testing.T) {
+		v3 := newTemplate("web:v3")
+
+		_, err := e.Reconcile(ctx, v3, policy, &state)
+		require.NoError(t, err)
+		assert.True(t, canaryExists())
+
+		canaryReady()
+		errorRate = 0.5
+
+		res, err := e.Reconcile(ctx, v3, policy, &state)
+		require.NoError(t, err)
+		assert.True(t, res.IsDone())
+		assert.Equal(t, canary.PhaseRolledBack, state.Phase)
+		assert.Equal(t, `step 1: check "error-rate" failed: value 0.5 exceeds threshold 0.01`, state.Message)
+		assert.Equal(t, "web:v2", stableImage())
+		assert.False(t, canaryExists())
+
+		condition := state.Condition(2)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, canary.ReasonCanaryRolledBack, condition.Reason)
+
+		// The rolled back revision isn't retried.
+		_, err = e.Reconcile(ctx, v3, policy, &state)
+		require.NoError(t, err)
+		assert.Equal(t, canary.PhaseRolledBack, state.Phase)
+		assert.False(t, canaryExists())
+	})
+}
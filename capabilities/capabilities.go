@@ -0,0 +1,214 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package capabilities detects the capabilities of a cluster using
+// discovery, so that operators supporting multiple Kubernetes versions and
+// distributions can safely branch their behavior.
+package capabilities
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var (
+	// OpenShiftConfigGroupVersion is served by all OpenShift 4.x clusters.
+	OpenShiftConfigGroupVersion = schema.GroupVersion{Group: "config.openshift.io", Version: "v1"}
+	// CronJobV1 is the GA CronJob kind (Kubernetes 1.21+).
+	CronJobV1 = schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}
+)
+
+// Detector answers questions about the capabilities of a cluster. Discovery
+// results are cached until invalidated.
+type Detector struct {
+	discovery discovery.DiscoveryInterface
+
+	mu            sync.Mutex
+	groupVersions map[schema.GroupVersion]*metav1.APIResourceList
+	serverVersion *utilversion.Version
+}
+
+// NewDetector returns a new capability detector.
+func NewDetector(d discovery.DiscoveryInterface) *Detector {
+	return &Detector{
+		discovery:     d,
+		groupVersions: make(map[schema.GroupVersion]*metav1.APIResourceList),
+	}
+}
+
+// SetupWithManager registers the detector to invalidate its cache whenever
+// a CRD is added, updated, or removed. The CRDs are watched on every
+// replica, not just the leader.
+func (d *Detector) SetupWithManager(mgr manager.Manager) error {
+	return mgr.Add(&crdWatcher{d: d, cache: mgr.GetCache()})
+}
+
+// crdWatcher invalidates a detector's cache when CRDs change.
+type crdWatcher struct {
+	d     *Detector
+	cache cache.Cache
+}
+
+func (w *crdWatcher) Start(ctx context.Context) error {
+	return w.d.watchCRDs(ctx, w.cache)
+}
+
+// NeedLeaderElection returns false, as every replica caches discovery results.
+func (w *crdWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Invalidate clears the cached discovery results.
+func (d *Detector) Invalidate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.groupVersions = make(map[schema.GroupVersion]*metav1.APIResourceList)
+	d.serverVersion = nil
+}
+
+// IsGroupVersionServed returns true if the group version is served.
+func (d *Detector) IsGroupVersionServed(gv schema.GroupVersion) (bool, error) {
+	resources, err := d.resources(gv)
+	if err != nil {
+		return false, err
+	}
+
+	return resources != nil, nil
+}
+
+// IsServed returns true if the kind is served.
+func (d *Detector) IsServed(gvk schema.GroupVersionKind) (bool, error) {
+	resources, err := d.resources(gvk.GroupVersion())
+	if err != nil || resources == nil {
+		return false, err
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Kind == gvk.Kind {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// IsOpenShift returns true if the cluster is an OpenShift cluster.
+func (d *Detector) IsOpenShift() (bool, error) {
+	return d.IsGroupVersionServed(OpenShiftConfigGroupVersion)
+}
+
+// HasCronJobV1 returns true if the batch/v1 CronJob kind is served.
+func (d *Detector) HasCronJobV1() (bool, error) {
+	return d.IsServed(CronJobV1)
+}
+
+// ServerVersion returns the Kubernetes version of the cluster.
+func (d *Detector) ServerVersion() (*utilversion.Version, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.serverVersion != nil {
+		return d.serverVersion, nil
+	}
+
+	info, err := d.discovery.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server version: %w", err)
+	}
+
+	v, err := utilversion.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server version %q: %w", info.GitVersion, err)
+	}
+
+	d.serverVersion = v
+
+	return v, nil
+}
+
+// AtLeast returns true if the Kubernetes version of the cluster is at least
+// the given version (eg. "1.27").
+func (d *Detector) AtLeast(minVersion string) (bool, error) {
+	v, err := d.ServerVersion()
+	if err != nil {
+		return false, err
+	}
+
+	minV, err := utilversion.ParseGeneric(minVersion)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse version %q: %w", minVersion, err)
+	}
+
+	return v.AtLeast(minV), nil
+}
+
+// resources returns the resources served for a group version, or nil if
+// the group version is not served.
+func (d *Detector) resources(gv schema.GroupVersion) (*metav1.APIResourceList, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if resources, ok := d.groupVersions[gv]; ok {
+		return resources, nil
+	}
+
+	resources, err := d.discovery.ServerResourcesForGroupVersion(gv.String())
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to discover %s: %w", gv, err)
+		}
+
+		resources = nil
+	}
+
+	d.groupVersions[gv] = resources
+
+	return resources, nil
+}
+
+func (d *Detector) watchCRDs(ctx context.Context, c cache.Cache) error {
+	informer, err := c.GetInformer(ctx, &apiextensionsv1.CustomResourceDefinition{})
+	if err != nil {
+		return fmt.Errorf("failed to get crd informer: %w", err)
+	}
+
+	invalidate := func(any) { d.Invalidate() }
+	registration, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    invalidate,
+		UpdateFunc: func(_, _ any) { d.Invalidate() },
+		DeleteFunc: invalidate,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add crd event handler: %w", err)
+	}
+
+	<-ctx.Done()
+
+	return informer.RemoveEventHandler(registration)
+}
@@ -0,0 +1,97 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package capabilities_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/capabilities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestDetector(t *testing.T) {
+	fake := &fakediscovery.FakeDiscovery{
+		Fake: &clienttesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "batch/v1",
+					APIResources: []metav1.APIResource{{Name: "jobs", Kind: "Job"}},
+				},
+			},
+		},
+		FakedServerVersion: &version.Info{GitVersion: "v1.20.15"},
+	}
+
+	d := capabilities.NewDetector(fake)
+
+	ok, err := d.IsServed(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = d.HasCronJobV1()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = d.IsOpenShift()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = d.AtLeast("1.21")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Simulate an upgrade.
+	fake.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "batch/v1",
+			APIResources: []metav1.APIResource{{Name: "jobs", Kind: "Job"}, {Name: "cronjobs", Kind: "CronJob"}},
+		},
+		{GroupVersion: "config.openshift.io/v1"},
+	}
+	fake.FakedServerVersion = &version.Info{GitVersion: "v1.27.3+k3s1"}
+
+	// Cached results are returned until invalidated.
+	ok, err = d.HasCronJobV1()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	calls := len(fake.Actions())
+	_, err = d.IsOpenShift()
+	require.NoError(t, err)
+	assert.Equal(t, calls, len(fake.Actions()))
+
+	d.Invalidate()
+
+	ok, err = d.HasCronJobV1()
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = d.IsOpenShift()
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = d.AtLeast("1.21")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
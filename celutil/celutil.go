@@ -0,0 +1,254 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package celutil compiles and evaluates CEL expressions against objects,
+// using the standard Kubernetes CEL environment and cost limits, so that
+// users can safely express policies and overrides in custom resources.
+package celutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/interpreter"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/version"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/apiserver/pkg/cel/environment"
+)
+
+// SelfVariable is the name of the variable holding the object an expression
+// is evaluated against.
+const SelfVariable = "self"
+
+var (
+	// ErrCostLimitExceeded is returned when evaluating an expression exceeds the cost limit.
+	ErrCostLimitExceeded = errors.New("cost limit exceeded")
+	// ErrNotBool is returned when a boolean expression evaluates to a non boolean value.
+	ErrNotBool = errors.New("expression did not evaluate to a bool")
+)
+
+// CompileError is returned when an expression fails to compile.
+type CompileError struct {
+	// Expression is the expression that failed to compile.
+	Expression string
+	// Issues describes the problems with the expression.
+	Issues string
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("failed to compile expression %q: %s", e.Expression, e.Issues)
+}
+
+// Options configures an environment.
+type Options struct {
+	// Variables are additional (dynamically typed) variables available to
+	// expressions, besides "self".
+	Variables []string
+	// CostLimit is the maximum cost of evaluating an expression. Defaults to
+	// the Kubernetes per call limit.
+	CostLimit uint64
+	// CompatibilityVersion is the Kubernetes version whose CEL libraries are
+	// available. Defaults to 1.28.
+	CompatibilityVersion *version.Version
+}
+
+// Env is a CEL environment. Compiled programs are cached, so an Env should
+// be shared between reconciles.
+type Env struct {
+	env       *cel.Env
+	costLimit uint64
+
+	mu       sync.Mutex
+	programs map[string]*Program
+}
+
+// NewEnv returns a new CEL environment.
+func NewEnv(opts Options) (*Env, error) {
+	if opts.CostLimit == 0 {
+		opts.CostLimit = celconfig.PerCallLimit
+	}
+
+	if opts.CompatibilityVersion == nil {
+		opts.CompatibilityVersion = version.MajorMinor(1, 28)
+	}
+
+	envOpts := []cel.EnvOption{cel.Variable(SelfVariable, cel.DynType)}
+	for _, name := range opts.Variables {
+		envOpts = append(envOpts, cel.Variable(name, cel.DynType))
+	}
+
+	envSet, err := environment.MustBaseEnvSet(opts.CompatibilityVersion).Extend(environment.VersionedOptions{
+		IntroducedVersion: opts.CompatibilityVersion,
+		EnvOptions:        envOpts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create environment: %w", err)
+	}
+
+	return &Env{
+		env:       envSet.NewExpressionsEnv(),
+		costLimit: opts.CostLimit,
+		programs:  make(map[string]*Program),
+	}, nil
+}
+
+// Program is a compiled expression.
+type Program struct {
+	expression string
+	program    cel.Program
+}
+
+// Compile compiles an expression (or returns the cached program). Compilation
+// errors are returned as a *CompileError.
+func (e *Env) Compile(expression string) (*Program, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if p, ok := e.programs[expression]; ok {
+		return p, nil
+	}
+
+	ast, issues := e.env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, &CompileError{Expression: expression, Issues: issues.Err().Error()}
+	}
+
+	program, err := e.env.Program(ast,
+		cel.CostLimit(e.costLimit),
+		cel.InterruptCheckFrequency(celconfig.CheckFrequency),
+	)
+	if err != nil {
+		return nil, &CompileError{Expression: expression, Issues: err.Error()}
+	}
+
+	p := &Program{expression: expression, program: program}
+	e.programs[expression] = p
+
+	return p, nil
+}
+
+// Eval evaluates the program against self (typically an object), with
+// optional additional variables. The result is converted to a JSON
+// compatible value (eg. numbers are float64s).
+func (p *Program) Eval(ctx context.Context, self any, vars map[string]any) (any, error) {
+	activation, err := newActivation(self, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	val, _, err := p.program.ContextEval(ctx, activation)
+	if err != nil {
+		var cancelled interpreter.EvalCancelledError
+		if errors.As(err, &cancelled) && cancelled.Cause == interpreter.CostLimitExceeded {
+			return nil, fmt.Errorf("failed to evaluate expression %q: %w", p.expression, ErrCostLimitExceeded)
+		}
+
+		return nil, fmt.Errorf("failed to evaluate expression %q: %w", p.expression, err)
+	}
+
+	if val == types.NullValue {
+		return nil, nil
+	}
+
+	native, err := val.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert result of expression %q: %w", p.expression, err)
+	}
+
+	return native.(*structpb.Value).AsInterface(), nil
+}
+
+// EvalBool evaluates a boolean program (eg. a policy).
+func (p *Program) EvalBool(ctx context.Context, self any, vars map[string]any) (bool, error) {
+	result, err := p.Eval(ctx, self, vars)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("failed to evaluate expression %q: %w", p.expression, ErrNotBool)
+	}
+
+	return b, nil
+}
+
+// Eval compiles and evaluates an expression.
+func (e *Env) Eval(ctx context.Context, expression string, self any, vars map[string]any) (any, error) {
+	p, err := e.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Eval(ctx, self, vars)
+}
+
+// EvalBool compiles and evaluates a boolean expression.
+func (e *Env) EvalBool(ctx context.Context, expression string, self any, vars map[string]any) (bool, error) {
+	p, err := e.Compile(expression)
+	if err != nil {
+		return false, err
+	}
+
+	return p.EvalBool(ctx, self, vars)
+}
+
+func newActivation(self any, vars map[string]any) (map[string]any, error) {
+	activation := make(map[string]any, len(vars)+1)
+
+	var err error
+	if activation[SelfVariable], err = toValue(self); err != nil {
+		return nil, err
+	}
+
+	for name, value := range vars {
+		if activation[name], err = toValue(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return activation, nil
+}
+
+// toValue converts typed objects (eg. custom resources) into their
+// unstructured representation, so fields are addressed by their JSON names.
+func toValue(value any) (any, error) {
+	switch v := value.(type) {
+	case nil, map[string]any, []any, string, bool, int64, float64:
+		return v, nil
+	case runtime.Unstructured:
+		return v.UnstructuredContent(), nil
+	}
+
+	if reflect.TypeOf(value).Kind() == reflect.Ptr && reflect.TypeOf(value).Elem().Kind() == reflect.Struct {
+		m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert value: %w", err)
+		}
+
+		return m, nil
+	}
+
+	return value, nil
+}
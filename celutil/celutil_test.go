@@ -0,0 +1,106 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package celutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/celutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestEnv(t *testing.T) {
+	env, err := celutil.NewEnv(celutil.Options{
+		Variables: []string{"params"},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test",
+			Labels: map[string]string{"tier": "gold"},
+		},
+		Data: map[string]string{"memory": "1Gi"},
+	}
+
+	t.Run("Bool", func(t *testing.T) {
+		allowed, err := env.EvalBool(ctx, "self.metadata.labels.tier == params.tier", cm, map[string]any{"params": map[string]any{"tier": "gold"}})
+		require.NoError(t, err)
+		assert.True(t, allowed)
+
+		allowed, err = env.EvalBool(ctx, "quantity(self.data.memory).isGreaterThan(quantity('2Gi'))", cm, nil)
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	})
+
+	t.Run("Value", func(t *testing.T) {
+		u := &unstructured.Unstructured{Object: map[string]any{
+			"spec": map[string]any{"replicas": int64(3)},
+		}}
+
+		result, err := env.Eval(ctx, "{'replicas': self.spec.replicas * 2, 'minReplicas': 1}", u, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"replicas": float64(6), "minReplicas": float64(1)}, result)
+
+		result, err = env.Eval(ctx, "null", u, nil)
+		require.NoError(t, err)
+		assert.Nil(t, result)
+	})
+
+	t.Run("NotBool", func(t *testing.T) {
+		_, err := env.EvalBool(ctx, "self.metadata.name", cm, nil)
+		assert.ErrorIs(t, err, celutil.ErrNotBool)
+	})
+
+	t.Run("CompileError", func(t *testing.T) {
+		_, err := env.Compile("self.metadata.name ==")
+
+		var compileErr *celutil.CompileError
+		require.True(t, errors.As(err, &compileErr))
+		assert.Equal(t, "self.metadata.name ==", compileErr.Expression)
+
+		_, err = env.Compile("unknown.field")
+		assert.Error(t, err)
+	})
+
+	t.Run("CostLimit", func(t *testing.T) {
+		limited, err := celutil.NewEnv(celutil.Options{CostLimit: 100})
+		require.NoError(t, err)
+
+		_, err = limited.Eval(ctx, "self.all(x, self.all(y, x + y > 0))", []any{int64(1), int64(2), int64(3), int64(4), int64(5), int64(6), int64(7), int64(8)}, nil)
+		assert.ErrorIs(t, err, celutil.ErrCostLimitExceeded)
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		a, err := env.Compile("self.metadata.name")
+		require.NoError(t, err)
+
+		b, err := env.Compile("self.metadata.name")
+		require.NoError(t, err)
+
+		assert.Same(t, a, b)
+	})
+}
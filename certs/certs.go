@@ -0,0 +1,221 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package certs provides a self-signed CA and serving certificates for
+// webhook and metrics endpoints, without depending on cert-manager.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// KeyPair is a PEM encoded certificate and private key.
+type KeyPair struct {
+	// Certificate is the PEM encoded certificate.
+	Certificate []byte
+	// PrivateKey is the PEM encoded private key.
+	PrivateKey []byte
+}
+
+// GenerateCA generates a self-signed certificate authority.
+func GenerateCA(commonName string, validity time.Duration) (*KeyPair, error) {
+	return generateCA(commonName, time.Now(), validity)
+}
+
+// GenerateServingCert generates a serving certificate for the given DNS names
+// (and/or IP addresses) signed by the given certificate authority.
+func GenerateServingCert(ca *KeyPair, hosts []string, validity time.Duration) (*KeyPair, error) {
+	return generateServingCert(ca, hosts, time.Now(), validity)
+}
+
+// GenerateClientCert generates a client certificate with the given common
+// name, signed by the given certificate authority.
+func GenerateClientCert(ca *KeyPair, commonName string, validity time.Duration) (*KeyPair, error) {
+	return generateClientCert(ca, commonName, time.Now(), validity)
+}
+
+// NotAfter returns the expiry time of the first certificate in the key pair.
+func (kp *KeyPair) NotAfter() (time.Time, error) {
+	cert, err := parseCertificate(kp.Certificate)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cert.NotAfter, nil
+}
+
+// TLSCertificate returns the key pair as a tls.Certificate.
+func (kp *KeyPair) TLSCertificate() (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(kp.Certificate, kp.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key pair: %w", err)
+	}
+
+	return &cert, nil
+}
+
+func generateCA(commonName string, now time.Time, validity time.Duration) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return encodeKeyPair(certDER, key)
+}
+
+func generateServingCert(ca *KeyPair, hosts []string, now time.Time, validity time.Duration) (*KeyPair, error) {
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("at least one host is required")
+	}
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	return signCertificate(ca, template, now, validity)
+}
+
+func generateClientCert(ca *KeyPair, commonName string, now time.Time, validity time.Duration) (*KeyPair, error) {
+	if commonName == "" {
+		return nil, fmt.Errorf("common name is required")
+	}
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	return signCertificate(ca, template, now, validity)
+}
+
+// signCertificate generates a new private key and a certificate for it
+// (from the given template), signed by the given certificate authority.
+func signCertificate(ca *KeyPair, template *x509.Certificate, now time.Time, validity time.Duration) (*KeyPair, error) {
+	caCert, err := parseCertificate(ca.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ca certificate: %w", err)
+	}
+
+	caKey, err := parsePrivateKey(ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ca private key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	template.SerialNumber, err = newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template.NotBefore = now.Add(-time.Minute)
+	template.NotAfter = now.Add(validity)
+	// A certificate can't outlive the CA that signed it.
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return encodeKeyPair(certDER, key)
+}
+
+func encodeKeyPair(certDER []byte, key *ecdsa.PrivateKey) (*KeyPair, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	return &KeyPair{
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode certificate pem")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, fmt.Errorf("failed to decode private key pem")
+	}
+
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	return serialNumber, nil
+}
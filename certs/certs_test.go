@@ -0,0 +1,62 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certs_test
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateServingCert(t *testing.T) {
+	ca, err := certs.GenerateCA("test-ca", 24*time.Hour)
+	require.NoError(t, err)
+
+	servingCert, err := certs.GenerateServingCert(ca, []string{"webhook.default.svc", "127.0.0.1"}, 48*time.Hour)
+	require.NoError(t, err)
+
+	caNotAfter, err := ca.NotAfter()
+	require.NoError(t, err)
+
+	certNotAfter, err := servingCert.NotAfter()
+	require.NoError(t, err)
+
+	// Should be clamped to the lifetime of the CA.
+	assert.Equal(t, caNotAfter, certNotAfter)
+
+	tlsCert, err := servingCert.TLSCertificate()
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(ca.Certificate))
+
+	for _, host := range []string{"webhook.default.svc", "127.0.0.1"} {
+		_, err = leaf.Verify(x509.VerifyOptions{
+			DNSName: host,
+			Roots:   roots,
+		})
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,210 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// InjectorOptions selects the objects the CA bundle will be injected into.
+type InjectorOptions struct {
+	// ValidatingWebhookConfigurations are the names of validating webhook configurations.
+	ValidatingWebhookConfigurations []string
+	// MutatingWebhookConfigurations are the names of mutating webhook configurations.
+	MutatingWebhookConfigurations []string
+	// CustomResourceDefinitions are the names of CRDs with conversion webhooks.
+	CustomResourceDefinitions []string
+	// LabelSelector, if set, additionally selects webhook configurations
+	// and CRDs matching the selector.
+	LabelSelector labels.Selector
+}
+
+// Injector patches a CA bundle into webhook configurations and CRD conversion webhooks.
+type Injector struct {
+	c    client.Client
+	opts InjectorOptions
+}
+
+// NewInjector returns a new CA bundle injector.
+func NewInjector(c client.Client, opts InjectorOptions) *Injector {
+	return &Injector{
+		c:    c,
+		opts: opts,
+	}
+}
+
+// Register registers the injector with the certificate manager so that the
+// CA bundle is injected whenever it is rotated.
+func (i *Injector) Register(m *Manager) {
+	m.OnRotate(i.Inject)
+}
+
+// Inject patches the given CA bundle into all the selected objects.
+func (i *Injector) Inject(ctx context.Context, caBundle []byte) error {
+	validating, err := selectObjects(ctx, i.c, i.opts.ValidatingWebhookConfigurations, i.opts.LabelSelector,
+		func() *admissionregistrationv1.ValidatingWebhookConfiguration {
+			return &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		},
+		&admissionregistrationv1.ValidatingWebhookConfigurationList{},
+		func(list *admissionregistrationv1.ValidatingWebhookConfigurationList) []*admissionregistrationv1.ValidatingWebhookConfiguration {
+			items := make([]*admissionregistrationv1.ValidatingWebhookConfiguration, len(list.Items))
+			for j := range list.Items {
+				items[j] = &list.Items[j]
+			}
+			return items
+		})
+	if err != nil {
+		return fmt.Errorf("failed to select validating webhook configurations: %w", err)
+	}
+
+	for _, obj := range validating {
+		if err := patchIfChanged(ctx, i.c, obj, func() bool {
+			var changed bool
+			for j := range obj.Webhooks {
+				changed = setCABundle(&obj.Webhooks[j].ClientConfig.CABundle, caBundle) || changed
+			}
+			return changed
+		}); err != nil {
+			return err
+		}
+	}
+
+	mutating, err := selectObjects(ctx, i.c, i.opts.MutatingWebhookConfigurations, i.opts.LabelSelector,
+		func() *admissionregistrationv1.MutatingWebhookConfiguration {
+			return &admissionregistrationv1.MutatingWebhookConfiguration{}
+		},
+		&admissionregistrationv1.MutatingWebhookConfigurationList{},
+		func(list *admissionregistrationv1.MutatingWebhookConfigurationList) []*admissionregistrationv1.MutatingWebhookConfiguration {
+			items := make([]*admissionregistrationv1.MutatingWebhookConfiguration, len(list.Items))
+			for j := range list.Items {
+				items[j] = &list.Items[j]
+			}
+			return items
+		})
+	if err != nil {
+		return fmt.Errorf("failed to select mutating webhook configurations: %w", err)
+	}
+
+	for _, obj := range mutating {
+		if err := patchIfChanged(ctx, i.c, obj, func() bool {
+			var changed bool
+			for j := range obj.Webhooks {
+				changed = setCABundle(&obj.Webhooks[j].ClientConfig.CABundle, caBundle) || changed
+			}
+			return changed
+		}); err != nil {
+			return err
+		}
+	}
+
+	crds, err := selectObjects(ctx, i.c, i.opts.CustomResourceDefinitions, i.opts.LabelSelector,
+		func() *apiextensionsv1.CustomResourceDefinition {
+			return &apiextensionsv1.CustomResourceDefinition{}
+		},
+		&apiextensionsv1.CustomResourceDefinitionList{},
+		func(list *apiextensionsv1.CustomResourceDefinitionList) []*apiextensionsv1.CustomResourceDefinition {
+			items := make([]*apiextensionsv1.CustomResourceDefinition, len(list.Items))
+			for j := range list.Items {
+				items[j] = &list.Items[j]
+			}
+			return items
+		})
+	if err != nil {
+		return fmt.Errorf("failed to select custom resource definitions: %w", err)
+	}
+
+	for _, obj := range crds {
+		if err := patchIfChanged(ctx, i.c, obj, func() bool {
+			conversion := obj.Spec.Conversion
+			// Only CRDs with a conversion webhook need the bundle.
+			if conversion == nil || conversion.Strategy != apiextensionsv1.WebhookConverter ||
+				conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+				return false
+			}
+
+			return setCABundle(&conversion.Webhook.ClientConfig.CABundle, caBundle)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectObjects retrieves the named objects, plus any objects matching the label selector.
+func selectObjects[T client.Object, L client.ObjectList](ctx context.Context, c client.Client, names []string, selector labels.Selector,
+	newObj func() T, list L, items func(L) []T) ([]T, error) {
+	var selected []T
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		obj := newObj()
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, obj); err != nil {
+			return nil, fmt.Errorf("failed to get %q: %w", name, err)
+		}
+
+		seen[name] = true
+		selected = append(selected, obj)
+	}
+
+	if selector != nil {
+		if err := c.List(ctx, list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, obj := range items(list) {
+			if !seen[obj.GetName()] {
+				seen[obj.GetName()] = true
+				selected = append(selected, obj)
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+func patchIfChanged(ctx context.Context, c client.Client, obj client.Object, mutate func() bool) error {
+	orig := obj.DeepCopyObject().(client.Object)
+
+	if !mutate() {
+		return nil
+	}
+
+	if err := c.Patch(ctx, obj, client.MergeFrom(orig)); err != nil {
+		return fmt.Errorf("failed to patch %q: %w", obj.GetName(), err)
+	}
+
+	return nil
+}
+
+func setCABundle(dst *[]byte, caBundle []byte) bool {
+	if bytes.Equal(*dst, caBundle) {
+		return false
+	}
+
+	*dst = caBundle
+
+	return true
+}
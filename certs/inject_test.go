@@ -0,0 +1,100 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestInjector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, admissionregistrationv1.AddToScheme(scheme))
+	require.NoError(t, apiextensionsv1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "validating"},
+			Webhooks: []admissionregistrationv1.ValidatingWebhook{
+				{Name: "a.example.com"},
+				{Name: "b.example.com"},
+			},
+		},
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "mutating",
+				Labels: map[string]string{"inject": "true"},
+			},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{Name: "a.example.com"},
+			},
+		},
+		&apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "myobjects.example.com",
+				Labels: map[string]string{"inject": "true"},
+			},
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Conversion: &apiextensionsv1.CustomResourceConversion{
+					Strategy: apiextensionsv1.WebhookConverter,
+					Webhook: &apiextensionsv1.WebhookConversion{
+						ClientConfig: &apiextensionsv1.WebhookClientConfig{},
+					},
+				},
+			},
+		},
+	).Build()
+
+	injector := certs.NewInjector(c, certs.InjectorOptions{
+		ValidatingWebhookConfigurations: []string{"validating"},
+		LabelSelector:                   labels.SelectorFromSet(labels.Set{"inject": "true"}),
+	})
+
+	ctx := context.Background()
+	caBundle := []byte("ca-bundle")
+
+	require.NoError(t, injector.Inject(ctx, caBundle))
+
+	var validating admissionregistrationv1.ValidatingWebhookConfiguration
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "validating"}, &validating))
+
+	for _, webhook := range validating.Webhooks {
+		assert.Equal(t, caBundle, webhook.ClientConfig.CABundle)
+	}
+
+	var mutating admissionregistrationv1.MutatingWebhookConfiguration
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "mutating"}, &mutating))
+
+	assert.Equal(t, caBundle, mutating.Webhooks[0].ClientConfig.CABundle)
+
+	var crd apiextensionsv1.CustomResourceDefinition
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "myobjects.example.com"}, &crd))
+
+	assert.Equal(t, caBundle, crd.Spec.Conversion.Webhook.ClientConfig.CABundle)
+}
@@ -0,0 +1,517 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// CACertKey is the secret key containing the PEM encoded CA bundle.
+	CACertKey = "ca.crt"
+	// CAPrivateKeyKey is the secret key containing the PEM encoded CA private key.
+	CAPrivateKeyKey = "ca.key"
+	// NextCAPrivateKeyKey is the secret key containing the PEM encoded private
+	// key of the CA being rotated to (whose certificate has been published in
+	// the CA bundle, but isn't yet used to sign the serving certificate).
+	NextCAPrivateKeyKey = "next-ca.key"
+)
+
+const (
+	DefaultCAValidity    = 5 * 365 * 24 * time.Hour
+	DefaultCertValidity  = 365 * 24 * time.Hour
+	DefaultRotateBefore  = 30 * 24 * time.Hour
+	DefaultCheckInterval = time.Hour
+	// DefaultRotationOverlap is the default overlap period of CA rotations,
+	// it is capped to half of RotateBefore.
+	DefaultRotationOverlap = 24 * time.Hour
+)
+
+// Options configures a certificate Manager.
+type Options struct {
+	// SecretName is the name of the secret used to store the certificates.
+	SecretName string
+	// SecretNamespace is the namespace of the secret used to store the certificates.
+	SecretNamespace string
+	// CommonName is the common name of the generated CA.
+	CommonName string
+	// Hosts are the DNS names and IP addresses the serving certificate is valid for.
+	Hosts []string
+	// CAValidity is how long the generated CA is valid for.
+	CAValidity time.Duration
+	// CertValidity is how long the generated serving certificate is valid for.
+	CertValidity time.Duration
+	// RotateBefore is how long before expiry certificates will be rotated.
+	RotateBefore time.Duration
+	// CheckInterval is how often the certificates will be checked for rotation.
+	CheckInterval time.Duration
+	// RotationOverlap is how long a new CA is published in the CA bundle
+	// before it is used to sign the serving certificate, and how long the
+	// previous CA is kept in the bundle afterwards. It should be longer than
+	// it takes for the bundle to reach clients (and CheckInterval, so that
+	// every replica has loaded the new serving certificate), and must be less
+	// than RotateBefore.
+	RotationOverlap time.Duration
+	// Clock is used to determine the current time (useful for testing).
+	Clock clock.WithTicker
+}
+
+// RotateFunc is called with the current CA bundle whenever it changes.
+type RotateFunc func(ctx context.Context, caBundle []byte) error
+
+// Manager generates, stores, and rotates a CA and serving certificate.
+// The certificates are persisted in a Secret so that they are shared
+// between operator replicas and survive restarts.
+type Manager struct {
+	c        client.Client
+	opts     Options
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	ca       *KeyPair
+	caBundle []byte
+	notified []byte
+	onRotate []RotateFunc
+}
+
+// NewManager returns a new certificate manager. The client should not be
+// backed by the manager cache as the certificates are typically required
+// before the cache has been started.
+func NewManager(c client.Client, opts Options) (*Manager, error) {
+	if opts.SecretName == "" || opts.SecretNamespace == "" {
+		return nil, fmt.Errorf("secret name and namespace are required")
+	}
+
+	if len(opts.Hosts) == 0 {
+		return nil, fmt.Errorf("at least one host is required")
+	}
+
+	if opts.CommonName == "" {
+		opts.CommonName = opts.SecretName + "-ca"
+	}
+
+	if opts.CAValidity == 0 {
+		opts.CAValidity = DefaultCAValidity
+	}
+
+	if opts.CertValidity == 0 {
+		opts.CertValidity = DefaultCertValidity
+	}
+
+	if opts.RotateBefore == 0 {
+		opts.RotateBefore = DefaultRotateBefore
+	}
+
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = DefaultCheckInterval
+	}
+
+	if opts.RotationOverlap == 0 {
+		opts.RotationOverlap = DefaultRotationOverlap
+		if opts.RotationOverlap > opts.RotateBefore/2 {
+			opts.RotationOverlap = opts.RotateBefore / 2
+		}
+	}
+
+	if opts.RotationOverlap >= opts.RotateBefore {
+		return nil, fmt.Errorf("rotation overlap must be less than rotate before")
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &Manager{
+		c:    c,
+		opts: opts,
+	}, nil
+}
+
+// OnRotate registers a function that will be called whenever the CA bundle
+// changes (including when it is first loaded).
+func (m *Manager) OnRotate(fn RotateFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onRotate = append(m.onRotate, fn)
+}
+
+// Start implements manager.Runnable, periodically checking the certificates
+// and rotating them before they expire.
+func (m *Manager) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	if err := m.Ensure(ctx); err != nil {
+		logger.Error(err, "Failed to ensure certificates")
+	}
+
+	ticker := m.opts.Clock.NewTicker(m.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			if err := m.Ensure(ctx); err != nil {
+				logger.Error(err, "Failed to ensure certificates")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+// Every replica needs to serve with the current certificates.
+func (m *Manager) NeedLeaderElection() bool {
+	return false
+}
+
+// Ensure makes sure valid certificates exist (generating or rotating them as
+// required) and loads them for serving.
+func (m *Manager) Ensure(ctx context.Context) error {
+	var secret corev1.Secret
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		key := client.ObjectKey{Name: m.opts.SecretName, Namespace: m.opts.SecretNamespace}
+		if err := m.c.Get(ctx, key, &secret); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to get secret: %w", err)
+			}
+
+			secret = corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      m.opts.SecretName,
+					Namespace: m.opts.SecretNamespace,
+				},
+				Type: corev1.SecretTypeTLS,
+			}
+
+			if _, err := m.rotate(&secret); err != nil {
+				return err
+			}
+
+			if err := m.c.Create(ctx, &secret); err != nil {
+				if apierrors.IsAlreadyExists(err) {
+					// Another replica beat us to it, treat it as a conflict and retry.
+					return apierrors.NewConflict(corev1.Resource("secrets"), secret.Name, err)
+				}
+
+				return fmt.Errorf("failed to create secret: %w", err)
+			}
+
+			return nil
+		}
+
+		rotated, err := m.rotate(&secret)
+		if err != nil {
+			return err
+		}
+
+		if rotated {
+			if err := m.c.Update(ctx, &secret); err != nil {
+				return fmt.Errorf("failed to update secret: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.load(ctx, &secret)
+}
+
+// GetCertificate returns the current serving certificate, it is suitable
+// for use as tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cert == nil {
+		return nil, fmt.Errorf("serving certificate not yet available")
+	}
+
+	return m.cert, nil
+}
+
+// ConfigureTLS configures the given tls.Config to use the current serving
+// certificate. It is suitable for use with controller-runtime TLSOpts.
+func (m *Manager) ConfigureTLS(cfg *tls.Config) {
+	cfg.GetCertificate = m.GetCertificate
+}
+
+// CABundle returns the current PEM encoded CA bundle.
+func (m *Manager) CABundle() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.caBundle
+}
+
+// GenerateClientCert generates a client certificate signed by the current
+// CA, eg. for clients of a server requiring client certificates. Client
+// certificates are not rotated, so should be regenerated whenever the CA
+// bundle changes.
+func (m *Manager) GenerateClientCert(commonName string, validity time.Duration) (*KeyPair, error) {
+	m.mu.RLock()
+	ca := m.ca
+	m.mu.RUnlock()
+
+	if ca == nil {
+		return nil, fmt.Errorf("ca not yet available")
+	}
+
+	return generateClientCert(ca, commonName, m.opts.Clock.Now(), validity)
+}
+
+// rotate generates new certificates, if required, and stores them in the
+// secret. CAs are rotated in two phases so that clients never see a serving
+// certificate signed by a CA they don't yet trust: the new CA is first
+// published in the bundle alongside the current one, and only after the
+// overlap period is it used to sign the serving certificate. The previous CA
+// is then dropped from the bundle once the new serving certificate has been
+// in use for the overlap period.
+func (m *Manager) rotate(secret *corev1.Secret) (bool, error) {
+	now := m.opts.Clock.Now()
+	rotateAfter := now.Add(m.opts.RotateBefore)
+
+	caBundle := secret.Data[CACertKey]
+	ca, caCert := caFromBundle(caBundle, secret.Data[CAPrivateKeyKey])
+	next, nextCert := caFromBundle(caBundle, secret.Data[NextCAPrivateKeyKey])
+
+	var rotated, rotateCert bool
+	switch {
+	case caCert == nil || now.After(caCert.NotAfter):
+		// Nothing can be verified with the current CA, so there is no point
+		// waiting for the new CA to reach clients.
+		var err error
+		ca, caCert, err = m.generateCA(now)
+		if err != nil {
+			return false, err
+		}
+
+		caBundle = append(append([]byte{}, ca.Certificate...), filterCertificates(caBundle, func(cert *x509.Certificate) bool {
+			return !now.After(cert.NotAfter)
+		})...)
+		next, nextCert = nil, nil
+		rotated, rotateCert = true, true
+	case nextCert != nil && !now.Before(nextCert.NotBefore.Add(m.opts.RotationOverlap)):
+		// The new CA has been trusted for long enough, start using it.
+		ca, caCert = next, nextCert
+		next, nextCert = nil, nil
+		rotated, rotateCert = true, true
+	case nextCert == nil && rotateAfter.After(caCert.NotAfter):
+		var err error
+		next, nextCert, err = m.generateCA(now)
+		if err != nil {
+			return false, err
+		}
+
+		caBundle = append(append([]byte{}, caBundle...), next.Certificate...)
+		rotated = true
+	}
+
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if !rotateCert {
+		rotateCert = err != nil || m.expiring(cert, caCert, nextCert, rotateAfter) ||
+			cert.CheckSignatureFrom(caCert) != nil || !coversHosts(cert, m.opts.Hosts)
+		if !rotateCert {
+			if _, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+				rotateCert = true
+			}
+		}
+	}
+
+	if rotateCert {
+		servingCert, err := generateServingCert(ca, m.opts.Hosts, now, m.opts.CertValidity)
+		if err != nil {
+			return false, fmt.Errorf("failed to generate serving certificate: %w", err)
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+
+		secret.Data[corev1.TLSCertKey] = servingCert.Certificate
+		secret.Data[corev1.TLSPrivateKeyKey] = servingCert.PrivateKey
+		rotated = true
+	} else if !now.Before(cert.NotBefore.Add(m.opts.RotationOverlap)) {
+		// Every replica has loaded the serving certificate signed by the
+		// current CA, so previous CAs are no longer needed.
+		trusted := filterCertificates(caBundle, func(cert *x509.Certificate) bool {
+			return cert.Equal(caCert) || (nextCert != nil && cert.Equal(nextCert))
+		})
+		if !bytes.Equal(trusted, caBundle) {
+			caBundle = trusted
+			rotated = true
+		}
+	}
+
+	if !rotated {
+		return false, nil
+	}
+
+	secret.Data[CACertKey] = caBundle
+	secret.Data[CAPrivateKeyKey] = ca.PrivateKey
+	if next != nil {
+		secret.Data[NextCAPrivateKeyKey] = next.PrivateKey
+	} else {
+		delete(secret.Data, NextCAPrivateKeyKey)
+	}
+
+	return true, nil
+}
+
+// expiring returns true if the serving certificate should be rotated before
+// it expires. Serving certificates are capped at the expiry of their CA, so
+// while the CA is being rotated they are left to be re-signed by the new CA
+// (rather than being re-signed by the old CA, with the same expiry, on every
+// check).
+func (m *Manager) expiring(cert, caCert, nextCert *x509.Certificate, rotateAfter time.Time) bool {
+	if nextCert != nil && !cert.NotAfter.Before(caCert.NotAfter) {
+		return false
+	}
+
+	return rotateAfter.After(cert.NotAfter)
+}
+
+func (m *Manager) generateCA(now time.Time) (*KeyPair, *x509.Certificate, error) {
+	ca, err := generateCA(m.opts.CommonName, now, m.opts.CAValidity)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ca: %w", err)
+	}
+
+	caCert, err := parseCertificate(ca.Certificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ca certificate: %w", err)
+	}
+
+	return ca, caCert, nil
+}
+
+// load loads the certificates from the secret for serving.
+func (m *Manager) load(ctx context.Context, secret *corev1.Secret) error {
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("failed to parse serving certificate: %w", err)
+	}
+
+	caBundle := secret.Data[CACertKey]
+
+	ca, _ := caFromBundle(caBundle, secret.Data[CAPrivateKeyKey])
+	if ca == nil {
+		return fmt.Errorf("failed to find ca certificate in bundle")
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.ca = ca
+	m.caBundle = caBundle
+	changed := !bytes.Equal(m.notified, caBundle)
+	onRotate := m.onRotate
+	m.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	for _, fn := range onRotate {
+		if err := fn(ctx, caBundle); err != nil {
+			return fmt.Errorf("failed to handle ca rotation: %w", err)
+		}
+	}
+
+	// Only record the bundle as notified once every handler has succeeded,
+	// so that failed handlers will be retried on the next check.
+	m.mu.Lock()
+	m.notified = caBundle
+	m.mu.Unlock()
+
+	return nil
+}
+
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, host := range hosts {
+		if err := cert.VerifyHostname(host); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// caFromBundle returns the CA in the bundle with the given private key, or
+// nil if there isn't one.
+func caFromBundle(bundle, keyPEM []byte) (*KeyPair, *x509.Certificate) {
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil
+	}
+
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return nil, nil
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+
+		if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); ok && pub.Equal(&key.PublicKey) {
+			return &KeyPair{Certificate: pem.EncodeToMemory(block), PrivateKey: keyPEM}, cert
+		}
+	}
+}
+
+func filterCertificates(bundle []byte, keep func(cert *x509.Certificate) bool) []byte {
+	var filtered []byte
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || !keep(cert) {
+			continue
+		}
+
+		filtered = append(filtered, pem.EncodeToMemory(block)...)
+	}
+
+	return filtered
+}
@@ -0,0 +1,171 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certs_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestManager(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+
+	m, err := certs.NewManager(c, certs.Options{
+		SecretName:      "webhook-certs",
+		SecretNamespace: "default",
+		Hosts:           []string{"webhook.default.svc"},
+		CAValidity:      4 * time.Hour,
+		CertValidity:    2 * time.Hour,
+		RotateBefore:    time.Hour,
+		RotationOverlap: 10 * time.Minute,
+		Clock:           fakeClock,
+	})
+	require.NoError(t, err)
+
+	var rotations int
+	m.OnRotate(func(_ context.Context, caBundle []byte) error {
+		rotations++
+		return nil
+	})
+
+	ctx := context.Background()
+
+	_, err = m.GetCertificate(nil)
+	require.Error(t, err)
+
+	require.NoError(t, m.Ensure(ctx))
+
+	initialCert, err := m.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rotations)
+
+	var secret corev1.Secret
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "webhook-certs", Namespace: "default"}, &secret))
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.Equal(t, m.CABundle(), secret.Data[certs.CACertKey])
+
+	t.Run("No Rotation", func(t *testing.T) {
+		require.NoError(t, m.Ensure(ctx))
+
+		cert, err := m.GetCertificate(nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, initialCert.Certificate, cert.Certificate)
+		assert.Equal(t, 1, rotations)
+	})
+
+	t.Run("Serving Certificate Rotation", func(t *testing.T) {
+		fakeClock.Step(90 * time.Minute)
+
+		require.NoError(t, m.Ensure(ctx))
+
+		cert, err := m.GetCertificate(nil)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, initialCert.Certificate, cert.Certificate)
+		// The CA didn't change.
+		assert.Equal(t, 1, rotations)
+	})
+
+	t.Run("CA Rotation", func(t *testing.T) {
+		fakeClock.Step(100 * time.Minute)
+
+		require.NoError(t, m.Ensure(ctx))
+		assert.Equal(t, 2, rotations)
+
+		// The new CA is published alongside the old one.
+		cas := decodeCertificates(t, m.CABundle())
+		require.Len(t, cas, 2)
+
+		// But isn't used until clients have had a chance to trust it.
+		servingCert, err := m.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.NoError(t, verifiedBy(t, servingCert, cas[0]))
+
+		// Nor is the serving certificate re-signed by the old CA meanwhile.
+		fakeClock.Step(5 * time.Minute)
+
+		require.NoError(t, m.Ensure(ctx))
+
+		cert, err := m.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, servingCert.Certificate, cert.Certificate)
+
+		fakeClock.Step(5 * time.Minute)
+
+		require.NoError(t, m.Ensure(ctx))
+		assert.Equal(t, 2, rotations)
+
+		cert, err = m.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.NotEqual(t, servingCert.Certificate, cert.Certificate)
+		assert.NoError(t, verifiedBy(t, cert, cas[1]))
+
+		// The old CA is dropped once every replica is using the new serving
+		// certificate.
+		fakeClock.Step(10 * time.Minute)
+
+		require.NoError(t, m.Ensure(ctx))
+		assert.Equal(t, 3, rotations)
+
+		bundle := decodeCertificates(t, m.CABundle())
+		require.Len(t, bundle, 1)
+		assert.True(t, bundle[0].Equal(cas[1]))
+	})
+}
+
+func verifiedBy(t *testing.T, cert *tls.Certificate, ca *x509.Certificate) error {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.CheckSignatureFrom(ca)
+}
+
+func decodeCertificates(t *testing.T, bundle []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return certs
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+
+		certs = append(certs, cert)
+	}
+}
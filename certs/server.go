@@ -0,0 +1,225 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	DefaultShutdownTimeout   = 30 * time.Second
+	DefaultReadHeaderTimeout = 10 * time.Second
+)
+
+// ServerOptions configures a Server.
+type ServerOptions struct {
+	// Name identifies the server in logs (eg. "admin").
+	Name string
+	// Addr is the address to listen on (eg. ":9443").
+	Addr string
+	// Handler serves the requests.
+	Handler http.Handler
+	// RequireClientCert requires clients to present a certificate signed
+	// by the manager's CA (see Manager.GenerateClientCert).
+	RequireClientCert bool
+	// MinVersion is the minimum TLS version accepted, defaults to TLS 1.2.
+	MinVersion uint16
+	// ReadHeaderTimeout is how long clients have to send the request headers.
+	ReadHeaderTimeout time.Duration
+	// ShutdownTimeout is how long in-flight requests are given to complete
+	// when the server is stopped.
+	ShutdownTimeout time.Duration
+}
+
+// Server is an HTTPS server (eg. for webhook or admin endpoints) serving
+// with the manager's certificates. Rotated certificates (and CA bundles) are
+// picked up by new connections without a restart.
+type Server struct {
+	m    *Manager
+	opts ServerOptions
+
+	mu       sync.Mutex
+	addr     net.Addr
+	caBundle []byte
+	clientCA *x509.CertPool
+}
+
+var (
+	_ manager.Runnable               = (*Server)(nil)
+	_ manager.LeaderElectionRunnable = (*Server)(nil)
+)
+
+// NewServer returns a new server using the manager's certificates.
+func (m *Manager) NewServer(opts ServerOptions) (*Server, error) {
+	if opts.Addr == "" {
+		return nil, fmt.Errorf("address is required")
+	}
+
+	if opts.Handler == nil {
+		return nil, fmt.Errorf("handler is required")
+	}
+
+	if opts.Name == "" {
+		opts.Name = "https"
+	}
+
+	if opts.MinVersion == 0 {
+		opts.MinVersion = tls.VersionTLS12
+	}
+
+	if opts.ReadHeaderTimeout == 0 {
+		opts.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = DefaultShutdownTimeout
+	}
+
+	return &Server{
+		m:    m,
+		opts: opts,
+	}, nil
+}
+
+// TLSConfig returns the TLS configuration used by the server.
+func (s *Server) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     s.opts.MinVersion,
+		GetCertificate: s.m.GetCertificate,
+	}
+
+	if s.opts.RequireClientCert {
+		// Resolved per connection so that a rotated CA is trusted immediately.
+		cfg.GetConfigForClient = func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+			clientCA, err := s.clientCAPool()
+			if err != nil {
+				return nil, err
+			}
+
+			return &tls.Config{
+				MinVersion:     s.opts.MinVersion,
+				GetCertificate: s.m.GetCertificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      clientCA,
+			}, nil
+		}
+	}
+
+	return cfg
+}
+
+// Start implements manager.Runnable, serving until the context is
+// cancelled, then waiting for in-flight requests to complete (up to the
+// shutdown timeout).
+func (s *Server) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("server", s.opts.Name)
+
+	ln, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %w", s.opts.Addr, err)
+	}
+
+	s.mu.Lock()
+	s.addr = ln.Addr()
+	s.mu.Unlock()
+
+	srv := &http.Server{
+		Handler:           s.opts.Handler,
+		TLSConfig:         s.TLSConfig(),
+		ReadHeaderTimeout: s.opts.ReadHeaderTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("Serving", "addr", ln.Addr().String())
+
+		serveErr <- srv.Serve(tls.NewListener(ln, srv.TLSConfig))
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shutdown server: %w", err)
+	}
+
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+// Every replica serves requests.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Addr returns the address the server is listening on, or nil if it
+// hasn't started yet.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addr
+}
+
+// clientCAPool returns a pool of the manager's current CA bundle, it is
+// only rebuilt when the bundle changes.
+func (s *Server) clientCAPool() (*x509.CertPool, error) {
+	caBundle := s.m.CABundle()
+	if len(caBundle) == 0 {
+		return nil, fmt.Errorf("ca bundle not yet available")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.clientCA == nil || !bytes.Equal(s.caBundle, caBundle) {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("failed to parse ca bundle")
+		}
+
+		s.caBundle = caBundle
+		s.clientCA = pool
+	}
+
+	return s.clientCA, nil
+}
@@ -0,0 +1,155 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certs_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestServer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	m, err := certs.NewManager(c, certs.Options{
+		SecretName:      "admin-certs",
+		SecretNamespace: "default",
+		Hosts:           []string{"127.0.0.1"},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	require.NoError(t, m.Ensure(ctx))
+
+	srv, err := m.NewServer(certs.ServerOptions{
+		Name: "admin",
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, _ = fmt.Fprintf(w, "hello %s", req.TLS.PeerCertificates[0].Subject.CommonName)
+		}),
+		RequireClientCert: true,
+	})
+	require.NoError(t, err)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- srv.Start(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		return srv.Addr() != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	url := "https://" + srv.Addr().String()
+
+	get := func(clientCert *certs.KeyPair) (*x509.Certificate, string, error) {
+		roots := x509.NewCertPool()
+		require.True(t, roots.AppendCertsFromPEM(m.CABundle()))
+
+		tlsConfig := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+		if clientCert != nil {
+			cert, err := clientCert.TLSCertificate()
+			require.NoError(t, err)
+
+			tlsConfig.Certificates = []tls.Certificate{*cert}
+		}
+
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		defer httpClient.CloseIdleConnections()
+
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.TLS.PeerCertificates[0], string(body), nil
+	}
+
+	clientCert, err := m.GenerateClientCert("prometheus", time.Hour)
+	require.NoError(t, err)
+
+	servingCert, body, err := get(clientCert)
+	require.NoError(t, err)
+	assert.Equal(t, "hello prometheus", body)
+
+	_, _, err = get(nil)
+	assert.Error(t, err)
+
+	otherCA, err := certs.GenerateCA("other-ca", time.Hour)
+	require.NoError(t, err)
+
+	untrustedCert, err := certs.GenerateClientCert(otherCA, "intruder", time.Hour)
+	require.NoError(t, err)
+
+	_, _, err = get(untrustedCert)
+	assert.Error(t, err)
+
+	t.Run("Rotation", func(t *testing.T) {
+		// Force the CA (and so the serving certificate) to be regenerated.
+		var secret corev1.Secret
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "admin-certs", Namespace: "default"}, &secret))
+
+		delete(secret.Data, certs.CACertKey)
+		require.NoError(t, c.Update(ctx, &secret))
+
+		require.NoError(t, m.Ensure(ctx))
+
+		// The old client certificate was signed by the previous CA.
+		_, _, err := get(clientCert)
+		assert.Error(t, err)
+
+		clientCert, err := m.GenerateClientCert("prometheus", time.Hour)
+		require.NoError(t, err)
+
+		rotatedCert, body, err := get(clientCert)
+		require.NoError(t, err)
+		assert.Equal(t, "hello prometheus", body)
+		assert.NotEqual(t, servingCert.SerialNumber, rotatedCert.SerialNumber)
+	})
+
+	cancel()
+
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shutdown")
+	}
+}
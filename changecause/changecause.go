@@ -0,0 +1,195 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package changecause stamps audit annotations onto the objects an operator
+// writes, recording who changed them (the operator and its version), why
+// (the triggering parent generation and reason), and when, along with a
+// bounded history of previous changes for incident review.
+package changecause
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/updater"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ChangeCauseAnnotation is the standard annotation describing the cause
+	// of the most recent change (as shown by kubectl rollout history).
+	ChangeCauseAnnotation = "kubernetes.io/change-cause"
+	// HistoryAnnotation records the history of changes (as a JSON list, oldest first).
+	HistoryAnnotation = "gpu-ninja.com/change-history"
+)
+
+const (
+	DefaultMaxHistory = 10
+)
+
+// Cause describes why an object was changed.
+type Cause struct {
+	// Operator is the name of the operator making the change.
+	Operator string `json:"operator,omitempty"`
+	// Version is the version of the operator.
+	Version string `json:"version,omitempty"`
+	// Parent identifies the parent object that triggered the change (eg. "Widget default/test").
+	Parent string `json:"parent,omitempty"`
+	// ParentGeneration is the generation of the parent that triggered the change.
+	ParentGeneration int64 `json:"parentGeneration,omitempty"`
+	// Reason is a short description of why the object was changed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// FromParent returns the cause of a change triggered by the parent's current generation.
+func FromParent(parent client.Object, operator, version, reason string) Cause {
+	name := parent.GetName()
+	if parent.GetNamespace() != "" {
+		name = parent.GetNamespace() + "/" + name
+	}
+
+	if kind := parent.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+		name = kind + " " + name
+	}
+
+	return Cause{
+		Operator:         operator,
+		Version:          version,
+		Parent:           name,
+		ParentGeneration: parent.GetGeneration(),
+		Reason:           reason,
+	}
+}
+
+func (c Cause) String() string {
+	var parts []string
+
+	if who := strings.TrimSpace(c.Operator + " " + c.Version); who != "" {
+		parts = append(parts, who)
+	}
+
+	if c.Parent != "" {
+		parts = append(parts, fmt.Sprintf("%s generation %d", c.Parent, c.ParentGeneration))
+	}
+
+	if c.Reason != "" {
+		parts = append(parts, c.Reason)
+	}
+
+	return strings.Join(parts, ": ")
+}
+
+// Entry is a change in an object's history.
+type Entry struct {
+	Cause `json:",inline"`
+	// Time is when the change was made.
+	Time metav1.Time `json:"time"`
+}
+
+// Options configures change cause stamping.
+type Options struct {
+	// MaxHistory is the maximum number of history entries kept (values of
+	// zero or less default to DefaultMaxHistory).
+	MaxHistory int
+	// Clock is used to determine the current time (useful for testing).
+	Clock clock.PassiveClock
+}
+
+// Stamp records the cause of a change on the object, appending it to the
+// given history (typically read from the existing object).
+func Stamp(obj client.Object, cause Cause, history []Entry, opts Options) error {
+	if opts.MaxHistory <= 0 {
+		opts.MaxHistory = DefaultMaxHistory
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	history = append(history, Entry{Cause: cause, Time: metav1.NewTime(opts.Clock.Now().UTC().Truncate(time.Second))})
+	if len(history) > opts.MaxHistory {
+		history = history[len(history)-opts.MaxHistory:]
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+
+	annotations := make(map[string]string)
+	for k, v := range obj.GetAnnotations() {
+		annotations[k] = v
+	}
+	annotations[ChangeCauseAnnotation] = cause.String()
+	annotations[HistoryAnnotation] = string(data)
+	obj.SetAnnotations(annotations)
+
+	return nil
+}
+
+// History returns the recorded history of changes to the object, oldest first.
+func History(obj client.Object) ([]Entry, error) {
+	data, ok := obj.GetAnnotations()[HistoryAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var history []Entry
+	if err := json.Unmarshal([]byte(data), &history); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal history: %w", err)
+	}
+
+	return history, nil
+}
+
+// Hook returns an updater pre-apply hook that stamps the cause onto every
+// object the updater writes, extending the existing object's history. As
+// hook changes don't by themselves cause updates, unchanged objects (whose
+// template hash matches) are left untouched. The hook reads the existing
+// object on every apply, so the reader should be cached (eg. the manager's
+// client), and the hook should come before any hooks that mutate the
+// template.
+func Hook(c client.Reader, cause Cause, opts Options) updater.PreApplyHook {
+	return func(ctx context.Context, template client.Object) error {
+		var history []Entry
+
+		existing := template.DeepCopyObject().(client.Object)
+		if err := c.Get(ctx, client.ObjectKeyFromObject(template), existing); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to get object: %w", err)
+			}
+		} else {
+			existingHash, err := updater.GetHash(existing)
+			if err == nil && existingHash == updater.HashObject(template) {
+				return nil
+			}
+
+			if history, err = History(existing); err != nil {
+				// Don't let a corrupted history block changes.
+				history = nil
+			}
+		}
+
+		return Stamp(template, cause, history, opts)
+	}
+}
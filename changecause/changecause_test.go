@@ -0,0 +1,107 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package changecause_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/changecause"
+	"github.com/gpu-ninja/operator-utils/updater"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestHook(t *testing.T) {
+	ctx := context.Background()
+
+	c := fake.NewClientBuilder().Build()
+	clock := clocktesting.NewFakeClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	opts := changecause.Options{MaxHistory: 2, Clock: clock}
+
+	parent := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{Kind: "Widget"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1},
+	}
+
+	apply := func(data string) client.Object {
+		template := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "child"},
+			Data:       map[string]string{"key": data},
+		}
+
+		cause := changecause.FromParent(parent, "widget-operator", "v1.2.3", "spec changed")
+		obj, err := updater.CreateOrUpdateFromTemplate(ctx, c, template, changecause.Hook(c, cause, opts))
+		require.NoError(t, err)
+
+		return obj
+	}
+
+	obj := apply("a")
+	assert.Equal(t, "widget-operator v1.2.3: Widget default/test generation 1: spec changed",
+		obj.GetAnnotations()[changecause.ChangeCauseAnnotation])
+
+	// Unchanged templates aren't rewritten.
+	clock.Step(time.Hour)
+	obj = apply("a")
+
+	history, err := changecause.History(obj)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, int64(1), history[0].ParentGeneration)
+	assert.Equal(t, "2023-10-01T12:00:00Z", history[0].Time.UTC().Format(time.RFC3339))
+
+	// The hook doesn't stamp templates matching the existing object.
+	unchanged := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "child"},
+		Data:       map[string]string{"key": "a"},
+	}
+	hook := changecause.Hook(c, changecause.FromParent(parent, "widget-operator", "v1.2.3", "spec changed"), opts)
+	require.NoError(t, hook(ctx, unchanged))
+	assert.Empty(t, unchanged.Annotations)
+
+	parent.Generation = 2
+	apply("b")
+	parent.Generation = 3
+	obj = apply("c")
+
+	history, err = changecause.History(obj)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, int64(2), history[0].ParentGeneration)
+	assert.Equal(t, int64(3), history[1].ParentGeneration)
+	assert.Equal(t, "widget-operator", history[1].Operator)
+	assert.Contains(t, obj.GetAnnotations()[changecause.ChangeCauseAnnotation], "generation 3")
+}
+
+func TestStampNegativeMaxHistory(t *testing.T) {
+	obj := &corev1.ConfigMap{}
+
+	cause := changecause.Cause{Operator: "widget-operator", Reason: "spec changed"}
+	require.NoError(t, changecause.Stamp(obj, cause, []changecause.Entry{{Cause: cause}}, changecause.Options{MaxHistory: -1}))
+
+	history, err := changecause.History(obj)
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+}
@@ -0,0 +1,284 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package checksums computes checksums of the ConfigMaps and Secrets a
+// workload consumes, and exposes them as pod template annotations so that
+// the workload is rolled when their contents change.
+package checksums
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ConfigMapAnnotationPrefix is the prefix of ConfigMap checksum annotations.
+	ConfigMapAnnotationPrefix = "configmap.checksum.gpu-ninja.com/"
+	// SecretAnnotationPrefix is the prefix of Secret checksum annotations.
+	SecretAnnotationPrefix = "secret.checksum.gpu-ninja.com/"
+	// Absent is the checksum of an optional object that does not exist.
+	Absent = "absent"
+)
+
+// maxNameLength is the maximum length of the name part of an annotation key.
+const maxNameLength = 63
+
+// ErrUnsupportedObject is returned when an object is not a ConfigMap or Secret.
+var ErrUnsupportedObject = errors.New("object must be a config map or secret")
+
+// Refs are the names of the ConfigMaps and Secrets (in the workload's namespace) a workload consumes.
+type Refs struct {
+	ConfigMaps []string
+	Secrets    []string
+}
+
+// Referenced returns the ConfigMaps and Secrets referenced by a pod spec's
+// volumes (including projected volumes), env, and envFrom.
+func Referenced(spec *corev1.PodSpec) Refs {
+	configMaps := make(map[string]bool)
+	secrets := make(map[string]bool)
+
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap != nil {
+			configMaps[volume.ConfigMap.Name] = true
+		}
+
+		if volume.Secret != nil {
+			secrets[volume.Secret.SecretName] = true
+		}
+
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					configMaps[source.ConfigMap.Name] = true
+				}
+
+				if source.Secret != nil {
+					secrets[source.Secret.Name] = true
+				}
+			}
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				configMaps[envFrom.ConfigMapRef.Name] = true
+			}
+
+			if envFrom.SecretRef != nil {
+				secrets[envFrom.SecretRef.Name] = true
+			}
+		}
+
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				configMaps[env.ValueFrom.ConfigMapKeyRef.Name] = true
+			}
+
+			if env.ValueFrom.SecretKeyRef != nil {
+				secrets[env.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+	}
+
+	return Refs{
+		ConfigMaps: sortedKeys(configMaps),
+		Secrets:    sortedKeys(secrets),
+	}
+}
+
+// Compute returns the checksum annotations of the given ConfigMaps and Secrets.
+func Compute(objs ...client.Object) (map[string]string, error) {
+	annotations := make(map[string]string, len(objs))
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *corev1.ConfigMap:
+			annotations[annotationKey(ConfigMapAnnotationPrefix, o.Name)] = checksumConfigMap(o)
+		case *corev1.Secret:
+			annotations[annotationKey(SecretAnnotationPrefix, o.Name)] = checksumSecret(o)
+		default:
+			return nil, fmt.Errorf("%w: %T", ErrUnsupportedObject, obj)
+		}
+	}
+
+	return annotations, nil
+}
+
+// Fetch gets the referenced ConfigMaps and Secrets, and returns their
+// checksum annotations. If optional is true, missing objects are given the
+// Absent checksum (so that the workload is rolled when they are created),
+// otherwise a not found error is returned.
+func Fetch(ctx context.Context, c client.Client, namespace string, refs Refs, optional bool) (map[string]string, error) {
+	annotations := make(map[string]string, len(refs.ConfigMaps)+len(refs.Secrets))
+
+	fetch := func(prefix, name string, obj client.Object) error {
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, obj); err != nil {
+			if optional && apierrors.IsNotFound(err) {
+				annotations[annotationKey(prefix, name)] = Absent
+				return nil
+			}
+
+			return fmt.Errorf("failed to get %q: %w", name, err)
+		}
+
+		checksums, err := Compute(obj)
+		if err != nil {
+			return err
+		}
+
+		for key, value := range checksums {
+			annotations[key] = value
+		}
+
+		return nil
+	}
+
+	for _, name := range refs.ConfigMaps {
+		if err := fetch(ConfigMapAnnotationPrefix, name, &corev1.ConfigMap{}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range refs.Secrets {
+		if err := fetch(SecretAnnotationPrefix, name, &corev1.Secret{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return annotations, nil
+}
+
+// Apply sets the checksum annotations on a pod template, removing any
+// checksum annotations that are no longer present.
+func Apply(template *corev1.PodTemplateSpec, checksums map[string]string) {
+	annotations := template.Annotations
+	if annotations == nil {
+		annotations = make(map[string]string, len(checksums))
+	}
+
+	for key := range annotations {
+		if isChecksumAnnotation(key) {
+			delete(annotations, key)
+		}
+	}
+
+	for key, value := range checksums {
+		annotations[key] = value
+	}
+
+	template.Annotations = annotations
+}
+
+// IsStale returns true if the pod template's checksum annotations don't
+// match the given checksums (ie. the workload needs to be rolled).
+func IsStale(template *corev1.PodTemplateSpec, checksums map[string]string) bool {
+	var current int
+	for key, value := range template.Annotations {
+		if !isChecksumAnnotation(key) {
+			continue
+		}
+
+		current++
+
+		if checksums[key] != value {
+			return true
+		}
+	}
+
+	return current != len(checksums)
+}
+
+func checksumConfigMap(cm *corev1.ConfigMap) string {
+	h := sha256.New()
+
+	for _, key := range sortedKeys(cm.Data) {
+		writeEntry(h, key, []byte(cm.Data[key]))
+	}
+
+	for _, key := range sortedKeys(cm.BinaryData) {
+		writeEntry(h, key, cm.BinaryData[key])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func checksumSecret(secret *corev1.Secret) string {
+	h := sha256.New()
+
+	writeEntry(h, "type", []byte(secret.Type))
+
+	for _, key := range sortedKeys(secret.Data) {
+		writeEntry(h, key, secret.Data[key])
+	}
+
+	// Only set on objects that have not been round tripped through the API server.
+	for _, key := range sortedKeys(secret.StringData) {
+		writeEntry(h, key, []byte(secret.StringData[key]))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeEntry writes a length prefixed entry, so that distinct entries can't
+// produce the same stream.
+func writeEntry(w io.Writer, key string, value []byte) {
+	_, _ = fmt.Fprintf(w, "%d:%s%d:", len(key), key, len(value))
+	_, _ = w.Write(value)
+}
+
+// annotationKey returns the annotation key of an object's checksum. Names
+// that are too long for an annotation key are truncated, and suffixed with
+// a hash of the full name to keep them unique.
+func annotationKey(prefix, name string) string {
+	if len(name) > maxNameLength {
+		sum := sha256.Sum256([]byte(name))
+		suffix := hex.EncodeToString(sum[:])[:8]
+		name = strings.TrimRight(name[:maxNameLength-len(suffix)-1], ".-") + "-" + suffix
+	}
+
+	return prefix + name
+}
+
+func isChecksumAnnotation(key string) bool {
+	return strings.HasPrefix(key, ConfigMapAnnotationPrefix) || strings.HasPrefix(key, SecretAnnotationPrefix)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
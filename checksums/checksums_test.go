@@ -0,0 +1,143 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package checksums_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/checksums"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReferenced(t *testing.T) {
+	spec := &corev1.PodSpec{
+		Volumes: []corev1.Volume{
+			{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "config"},
+			}}},
+			{Name: "tls", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "tls"}}},
+					{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"}}},
+				},
+			}}},
+		},
+		InitContainers: []corev1.Container{{
+			EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "credentials"}}}},
+		}},
+		Containers: []corev1.Container{{
+			Env: []corev1.EnvVar{{
+				Name: "PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "credentials"},
+					Key:                  "password",
+				}},
+			}},
+		}},
+	}
+
+	assert.Equal(t, checksums.Refs{
+		ConfigMaps: []string{"ca-bundle", "config"},
+		Secrets:    []string{"credentials", "tls"},
+	}, checksums.Referenced(spec))
+}
+
+func TestChecksums(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"},
+		Data:       map[string]string{"a": "1", "b": "2"},
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "credentials"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cm, secret).Build()
+
+	refs := checksums.Refs{ConfigMaps: []string{"config"}, Secrets: []string{"credentials", "optional"}}
+
+	_, err := checksums.Fetch(ctx, c, "default", refs, false)
+	assert.True(t, apierrors.IsNotFound(err))
+
+	annotations, err := checksums.Fetch(ctx, c, "default", refs, true)
+	require.NoError(t, err)
+	require.Len(t, annotations, 3)
+	assert.Equal(t, checksums.Absent, annotations[checksums.SecretAnnotationPrefix+"optional"])
+
+	computed, err := checksums.Compute(cm, secret)
+	require.NoError(t, err)
+	assert.Equal(t, computed[checksums.ConfigMapAnnotationPrefix+"config"], annotations[checksums.ConfigMapAnnotationPrefix+"config"])
+
+	template := &corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				"example.com/keep":                           "true",
+				checksums.ConfigMapAnnotationPrefix + "gone": "abc",
+			},
+		},
+	}
+
+	assert.True(t, checksums.IsStale(template, annotations))
+
+	checksums.Apply(template, annotations)
+	assert.Len(t, template.Annotations, 4)
+	assert.Equal(t, "true", template.Annotations["example.com/keep"])
+	assert.False(t, checksums.IsStale(template, annotations))
+
+	// Changing a value changes the checksum.
+	cm.Data["b"] = "3"
+	changed, err := checksums.Compute(cm)
+	require.NoError(t, err)
+	assert.NotEqual(t, computed[checksums.ConfigMapAnnotationPrefix+"config"], changed[checksums.ConfigMapAnnotationPrefix+"config"])
+
+	// Moving data between keys changes the checksum.
+	a, err := checksums.Compute(&corev1.ConfigMap{Data: map[string]string{"a": "bc"}})
+	require.NoError(t, err)
+	b, err := checksums.Compute(&corev1.ConfigMap{Data: map[string]string{"ab": "c"}})
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+
+	t.Run("LongName", func(t *testing.T) {
+		long, err := checksums.Compute(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 100)}})
+		require.NoError(t, err)
+
+		for key := range long {
+			assert.Empty(t, validation.IsQualifiedName(key))
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := checksums.Compute(&corev1.Pod{})
+		assert.ErrorIs(t, err, checksums.ErrUnsupportedObject)
+	})
+}
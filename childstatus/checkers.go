@@ -0,0 +1,137 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package childstatus
+
+import (
+	"fmt"
+
+	"github.com/gpu-ninja/operator-utils/rollout"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var defaultCheckers = map[schema.GroupKind]CheckFunc{
+	{Group: "apps", Kind: "Deployment"}:        typed(checkWorkload[*appsv1.Deployment]),
+	{Group: "apps", Kind: "StatefulSet"}:       typed(checkWorkload[*appsv1.StatefulSet]),
+	{Group: "apps", Kind: "DaemonSet"}:         typed(checkWorkload[*appsv1.DaemonSet]),
+	{Group: "batch", Kind: "Job"}:              typed(checkJob),
+	{Group: "", Kind: "Pod"}:                   typed(checkPod),
+	{Group: "", Kind: "PersistentVolumeClaim"}: typed(checkPersistentVolumeClaim),
+	{Group: "", Kind: "Service"}:               typed(checkService),
+}
+
+// typed adapts a checker for a concrete type, converting unstructured objects as required.
+func typed[T any, PT interface {
+	*T
+	client.Object
+}](fn func(obj PT) (State, string)) CheckFunc {
+	return func(obj client.Object) (State, string, error) {
+		if typedObj, ok := obj.(PT); ok {
+			state, message := fn(typedObj)
+			return state, message, nil
+		}
+
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return "", "", fmt.Errorf("unexpected object type %T", obj)
+		}
+
+		typedObj := PT(new(T))
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typedObj); err != nil {
+			return "", "", fmt.Errorf("failed to convert from unstructured: %w", err)
+		}
+
+		state, message := fn(typedObj)
+		return state, message, nil
+	}
+}
+
+// checkWorkload checks the rollout of a Deployment, StatefulSet, or DaemonSet.
+func checkWorkload[PT client.Object](obj PT) (State, string) {
+	status, err := rollout.InspectStatus(obj)
+	if err != nil {
+		return StateDegraded, err.Error()
+	}
+
+	switch status.Phase {
+	case rollout.PhaseComplete:
+		return StateReady, ""
+	case rollout.PhaseStalled:
+		return StateDegraded, status.Message
+	default:
+		return StateProgressing, status.Message
+	}
+}
+
+func checkJob(job *batchv1.Job) (State, string) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return StateReady, ""
+		case batchv1.JobFailed:
+			return StateDegraded, condition.Message
+		}
+	}
+
+	return StateProgressing, "waiting for job to complete"
+}
+
+func checkPod(pod *corev1.Pod) (State, string) {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return StateReady, ""
+	case corev1.PodFailed:
+		return StateDegraded, pod.Status.Message
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return StateReady, ""
+		}
+	}
+
+	return StateProgressing, "waiting for pod to be ready"
+}
+
+func checkPersistentVolumeClaim(pvc *corev1.PersistentVolumeClaim) (State, string) {
+	switch pvc.Status.Phase {
+	case corev1.ClaimBound:
+		return StateReady, ""
+	case corev1.ClaimLost:
+		return StateDegraded, "persistent volume lost"
+	default:
+		return StateProgressing, "waiting for volume to be bound"
+	}
+}
+
+func checkService(svc *corev1.Service) (State, string) {
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer && len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return StateProgressing, "waiting for load balancer"
+	}
+
+	return StateReady, ""
+}
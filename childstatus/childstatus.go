@@ -0,0 +1,275 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package childstatus provides a way to summarize the status of an object's
+// managed children into rolled up conditions on the parent.
+package childstatus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Condition types set on the parent.
+const (
+	ConditionTypeReady       = "Ready"
+	ConditionTypeProgressing = "Progressing"
+	ConditionTypeDegraded    = "Degraded"
+)
+
+// Condition reasons set on the parent.
+const (
+	ReasonAllChildrenReady      = "AllChildrenReady"
+	ReasonChildrenNotReady      = "ChildrenNotReady"
+	ReasonChildrenProgressing   = "ChildrenProgressing"
+	ReasonChildrenDegraded      = "ChildrenDegraded"
+	ReasonNoChildrenDegraded    = "NoChildrenDegraded"
+	ReasonNoChildrenProgressing = "NoChildrenProgressing"
+)
+
+// maxMessageChildren is the maximum number of children detailed in a condition message.
+const maxMessageChildren = 5
+
+// State is the state of a child.
+type State string
+
+const (
+	StateReady       State = "Ready"
+	StateProgressing State = "Progressing"
+	StateDegraded    State = "Degraded"
+)
+
+// CheckFunc determines the state of a child, along with a human readable
+// message explaining why it is not ready (if applicable).
+type CheckFunc func(obj client.Object) (State, string, error)
+
+// ChildStatus is the status of a single child.
+type ChildStatus struct {
+	// Kind is the kind of the child.
+	Kind string
+	// Name is the name of the child.
+	Name string
+	// State is the state of the child.
+	State State
+	// Message explains why the child is not ready.
+	Message string
+}
+
+func (cs *ChildStatus) String() string {
+	if cs.Message == "" {
+		return fmt.Sprintf("%s/%s is %s", cs.Kind, cs.Name, strings.ToLower(string(cs.State)))
+	}
+
+	return fmt.Sprintf("%s/%s: %s", cs.Kind, cs.Name, cs.Message)
+}
+
+// Aggregator computes the rolled up status of a set of children.
+type Aggregator struct {
+	scheme   *runtime.Scheme
+	checkers map[schema.GroupKind]CheckFunc
+}
+
+// NewAggregator returns a new Aggregator with checkers registered for the
+// common built-in workload and storage kinds. Kinds without a registered
+// checker are evaluated using their "Ready" condition, if present.
+func NewAggregator(scheme *runtime.Scheme) *Aggregator {
+	a := &Aggregator{
+		scheme:   scheme,
+		checkers: make(map[schema.GroupKind]CheckFunc),
+	}
+
+	for gk, fn := range defaultCheckers {
+		a.checkers[gk] = fn
+	}
+
+	return a
+}
+
+// Register registers (or overrides) the checker for the given group kind.
+func (a *Aggregator) Register(gk schema.GroupKind, fn CheckFunc) {
+	a.checkers[gk] = fn
+}
+
+// Aggregate determines the state of each child and returns a summary.
+func (a *Aggregator) Aggregate(children []client.Object) (*Summary, error) {
+	summary := &Summary{}
+	for _, child := range children {
+		gvk, err := apiutil.GVKForObject(child, a.scheme)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get gvk of %q: %w", child.GetName(), err)
+		}
+
+		check, ok := a.checkers[gvk.GroupKind()]
+		if !ok {
+			check = checkReadyCondition
+		}
+
+		state, message, err := check(child)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s %q: %w", gvk.Kind, child.GetName(), err)
+		}
+
+		summary.Children = append(summary.Children, ChildStatus{
+			Kind:    gvk.Kind,
+			Name:    child.GetName(),
+			State:   state,
+			Message: message,
+		})
+	}
+
+	sort.SliceStable(summary.Children, func(i, j int) bool {
+		if summary.Children[i].Kind != summary.Children[j].Kind {
+			return summary.Children[i].Kind < summary.Children[j].Kind
+		}
+		return summary.Children[i].Name < summary.Children[j].Name
+	})
+
+	return summary, nil
+}
+
+// Summary is the rolled up status of a set of children.
+type Summary struct {
+	Children []ChildStatus
+}
+
+// InState returns the children in the given state.
+func (s *Summary) InState(state State) []ChildStatus {
+	var children []ChildStatus
+	for _, child := range s.Children {
+		if child.State == state {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// Ready returns true if all the children are ready.
+func (s *Summary) Ready() bool {
+	return len(s.InState(StateReady)) == len(s.Children)
+}
+
+// Conditions returns the Ready, Progressing, and Degraded conditions for the parent.
+func (s *Summary) Conditions(generation int64) []metav1.Condition {
+	ready := metav1.Condition{
+		Type:               ConditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             ReasonAllChildrenReady,
+		Message:            fmt.Sprintf("%d/%d children ready", len(s.Children), len(s.Children)),
+	}
+
+	if notReady := append(s.InState(StateDegraded), s.InState(StateProgressing)...); len(notReady) > 0 {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = ReasonChildrenNotReady
+		ready.Message = fmt.Sprintf("%d/%d children ready: %s",
+			len(s.Children)-len(notReady), len(s.Children), describe(notReady))
+	}
+
+	progressing := metav1.Condition{
+		Type:               ConditionTypeProgressing,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonNoChildrenProgressing,
+	}
+
+	if children := s.InState(StateProgressing); len(children) > 0 {
+		progressing.Status = metav1.ConditionTrue
+		progressing.Reason = ReasonChildrenProgressing
+		progressing.Message = describe(children)
+	}
+
+	degraded := metav1.Condition{
+		Type:               ConditionTypeDegraded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonNoChildrenDegraded,
+	}
+
+	if children := s.InState(StateDegraded); len(children) > 0 {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = ReasonChildrenDegraded
+		degraded.Message = describe(children)
+	}
+
+	return []metav1.Condition{ready, progressing, degraded}
+}
+
+// SetConditions sets the Ready, Progressing, and Degraded conditions on the
+// given conditions slice (typically the parent's status.conditions).
+func (s *Summary) SetConditions(conditions *[]metav1.Condition, generation int64) {
+	for _, condition := range s.Conditions(generation) {
+		meta.SetStatusCondition(conditions, condition)
+	}
+}
+
+func describe(children []ChildStatus) string {
+	var details []string
+	for i, child := range children {
+		if i == maxMessageChildren {
+			details = append(details, fmt.Sprintf("and %d more", len(children)-maxMessageChildren))
+			break
+		}
+
+		details = append(details, child.String())
+	}
+
+	return strings.Join(details, "; ")
+}
+
+// checkReadyCondition evaluates an arbitrary object using its "Ready"
+// condition. Objects without a Ready condition are considered ready.
+func checkReadyCondition(obj client.Object) (State, string, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to convert to unstructured: %w", err)
+	}
+
+	conditions, ok, err := unstructured.NestedSlice(u, "status", "conditions")
+	if err != nil || !ok {
+		return StateReady, "", nil
+	}
+
+	for _, c := range conditions {
+		condition, ok := c.(map[string]any)
+		if !ok || condition["type"] != ConditionTypeReady {
+			continue
+		}
+
+		if condition["status"] == string(metav1.ConditionTrue) {
+			return StateReady, "", nil
+		}
+
+		message, _ := condition["message"].(string)
+		if message == "" {
+			message, _ = condition["reason"].(string)
+		}
+
+		return StateProgressing, message, nil
+	}
+
+	return StateReady, "", nil
+}
@@ -0,0 +1,130 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package childstatus_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/childstatus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAggregator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, batchv1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	a := childstatus.NewAggregator(scheme)
+
+	readyDeployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "ready", Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(int32(2))},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 1,
+			UpdatedReplicas:    2,
+			AvailableReplicas:  2,
+		},
+	}
+
+	t.Run("All Ready", func(t *testing.T) {
+		summary, err := a.Aggregate([]client.Object{readyDeployment, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config"},
+		}})
+		require.NoError(t, err)
+
+		assert.True(t, summary.Ready())
+
+		var conditions []metav1.Condition
+		summary.SetConditions(&conditions, 3)
+
+		ready := meta.FindStatusCondition(conditions, childstatus.ConditionTypeReady)
+		require.NotNil(t, ready)
+		assert.Equal(t, metav1.ConditionTrue, ready.Status)
+		assert.Equal(t, int64(3), ready.ObservedGeneration)
+
+		assert.True(t, meta.IsStatusConditionFalse(conditions, childstatus.ConditionTypeProgressing))
+		assert.True(t, meta.IsStatusConditionFalse(conditions, childstatus.ConditionTypeDegraded))
+	})
+
+	t.Run("Partial Failure", func(t *testing.T) {
+		progressingDeployment := readyDeployment.DeepCopy()
+		progressingDeployment.Name = "progressing"
+		progressingDeployment.Status.AvailableReplicas = 1
+
+		failedJob := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "migrate"},
+			Status: batchv1.JobStatus{
+				Conditions: []batchv1.JobCondition{{
+					Type:    batchv1.JobFailed,
+					Status:  corev1.ConditionTrue,
+					Message: "Job has reached the specified backoff limit",
+				}},
+			},
+		}
+
+		summary, err := a.Aggregate([]client.Object{readyDeployment, progressingDeployment, failedJob})
+		require.NoError(t, err)
+
+		assert.False(t, summary.Ready())
+
+		conditions := summary.Conditions(1)
+
+		ready := meta.FindStatusCondition(conditions, childstatus.ConditionTypeReady)
+		require.NotNil(t, ready)
+		assert.Equal(t, metav1.ConditionFalse, ready.Status)
+		assert.Equal(t, "1/3 children ready: Job/migrate: Job has reached the specified backoff limit; "+
+			"Deployment/progressing: 1/2 replicas available", ready.Message)
+
+		progressing := meta.FindStatusCondition(conditions, childstatus.ConditionTypeProgressing)
+		require.NotNil(t, progressing)
+		assert.Equal(t, metav1.ConditionTrue, progressing.Status)
+
+		degraded := meta.FindStatusCondition(conditions, childstatus.ConditionTypeDegraded)
+		require.NotNil(t, degraded)
+		assert.Equal(t, metav1.ConditionTrue, degraded.Status)
+		assert.Equal(t, "Job/migrate: Job has reached the specified backoff limit", degraded.Message)
+	})
+
+	t.Run("Unstructured", func(t *testing.T) {
+		var u unstructured.Unstructured
+		u.SetAPIVersion("example.com/v1")
+		u.SetKind("Database")
+		u.SetName("db")
+		require.NoError(t, unstructured.SetNestedSlice(u.Object, []any{
+			map[string]any{"type": "Ready", "status": "False", "message": "waiting for primary"},
+		}, "status", "conditions"))
+
+		summary, err := a.Aggregate([]client.Object{&u})
+		require.NoError(t, err)
+
+		require.Len(t, summary.Children, 1)
+		assert.Equal(t, childstatus.StateProgressing, summary.Children[0].State)
+		assert.Equal(t, "waiting for primary", summary.Children[0].Message)
+	})
+}
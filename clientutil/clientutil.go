@@ -0,0 +1,127 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package clientutil constructs API clients with opinionated tuning (rate
+// limits, user agents, and retries) in place of the client-go defaults.
+package clientutil
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	apiruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	DefaultQPS   = 50
+	DefaultBurst = 100
+)
+
+// DefaultRetryBackoff is the default backoff between retries of requests
+// that failed with a transient error.
+var DefaultRetryBackoff = wait.Backoff{
+	Duration: 200 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    4,
+}
+
+// Options configures a client.
+type Options struct {
+	// Name is the name of the operator (used in the user agent).
+	Name string
+	// Version is the version of the operator (used in the user agent).
+	Version string
+	// QPS is the maximum sustained queries per second. Defaults to DefaultQPS.
+	QPS float32
+	// Burst is the maximum burst of queries. Defaults to DefaultBurst.
+	Burst int
+	// Timeout is the maximum time to wait for a request (this includes
+	// watches, so should be left unset for clients that back informers).
+	Timeout time.Duration
+	// Retry enables retrying idempotent requests that fail with transient
+	// network errors (eg. connection resets during API server upgrades).
+	Retry bool
+	// RetryBackoff is the backoff between retries. Defaults to DefaultRetryBackoff.
+	RetryBackoff *wait.Backoff
+	// Scheme is the scheme used by the client.
+	Scheme *apiruntime.Scheme
+	// Mapper is the REST mapper used by the client.
+	Mapper meta.RESTMapper
+}
+
+// Config returns a tuned copy of the config (eg. for use with a manager).
+func Config(cfg *rest.Config, opts Options) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+
+	cfg.QPS = opts.QPS
+	if cfg.QPS == 0 {
+		cfg.QPS = DefaultQPS
+	}
+
+	cfg.Burst = opts.Burst
+	if cfg.Burst == 0 {
+		cfg.Burst = DefaultBurst
+	}
+
+	if opts.Timeout != 0 {
+		cfg.Timeout = opts.Timeout
+	}
+
+	if opts.Name != "" {
+		cfg.UserAgent = UserAgent(opts.Name, opts.Version)
+	}
+
+	if opts.Retry {
+		backoff := DefaultRetryBackoff
+		if opts.RetryBackoff != nil {
+			backoff = *opts.RetryBackoff
+		}
+
+		cfg.Wrap(NewRetryTransportWrapper(backoff))
+	}
+
+	return cfg
+}
+
+// New returns a client using a tuned copy of the config.
+func New(cfg *rest.Config, opts Options) (client.Client, error) {
+	c, err := client.New(Config(cfg, opts), client.Options{
+		Scheme: opts.Scheme,
+		Mapper: opts.Mapper,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	return c, nil
+}
+
+// UserAgent returns a user agent identifying the operator (eg.
+// "my-operator/v1.2.3 (linux/amd64)").
+func UserAgent(name, version string) string {
+	if version == "" {
+		version = "unknown"
+	}
+
+	return fmt.Sprintf("%s/%s (%s/%s)", name, version, runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,105 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clientutil_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/clientutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestNew(t *testing.T) {
+	var requests atomic.Int32
+	var userAgent atomic.Value
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent.Store(r.UserAgent())
+
+		// Fail the first two requests.
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"},
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+
+	opts := clientutil.Options{
+		Name:    "gpu-operator",
+		Version: "v1.2.3",
+		Retry:   true,
+		RetryBackoff: &wait.Backoff{
+			Duration: time.Millisecond,
+			Factor:   1,
+			Steps:    3,
+		},
+		Mapper: mapper,
+	}
+
+	cfg := clientutil.Config(&rest.Config{Host: srv.URL}, opts)
+	assert.Equal(t, float32(clientutil.DefaultQPS), cfg.QPS)
+	assert.Equal(t, clientutil.DefaultBurst, cfg.Burst)
+
+	c, err := clientutil.New(&rest.Config{Host: srv.URL}, opts)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	var cm corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "config"}, &cm))
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Contains(t, userAgent.Load(), "gpu-operator/v1.2.3 (")
+
+	t.Run("Not Idempotent", func(t *testing.T) {
+		requests.Store(0)
+
+		err := c.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"}})
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		requests.Store(-10)
+
+		err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "config"}, &cm)
+		assert.Error(t, err)
+		assert.Equal(t, int32(-7), requests.Load())
+	})
+}
@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clientutil
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/transport"
+)
+
+// NewRetryTransportWrapper returns a transport wrapper that retries
+// idempotent requests (GET, HEAD, and OPTIONS) that fail with transient
+// network errors, or with a bad gateway, service unavailable, or gateway
+// timeout response.
+func NewRetryTransportWrapper(backoff wait.Backoff) transport.WrapperFunc {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &retryRoundTripper{
+			delegate: rt,
+			backoff:  backoff,
+		}
+	}
+}
+
+type retryRoundTripper struct {
+	delegate http.RoundTripper
+	backoff  wait.Backoff
+}
+
+func (rt *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req.Method) {
+		return rt.delegate.RoundTrip(req)
+	}
+
+	backoff := rt.backoff
+	for {
+		resp, err := rt.delegate.RoundTrip(req)
+		if !isTransient(resp, err) || backoff.Steps <= 1 {
+			return resp, err
+		}
+
+		if resp != nil {
+			// Drain the body so the connection can be reused.
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (rt *retryRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return rt.delegate
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+func isTransient(resp *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+			errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED):
+			return true
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return true
+		default:
+			return false
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
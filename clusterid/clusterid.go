@@ -0,0 +1,161 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package clusterid derives a stable identifier for the cluster an operator
+// runs in (eg. for licensing, telemetry correlation, or multi-cluster
+// inventory).
+//
+// The identifier is taken from the cluster's id.k8s.io ClusterProperty (see
+// KEP-2149) when one exists, falling back to the UID of the kube-system
+// namespace (which lives as long as the cluster does).
+package clusterid
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultClaimName is the name of the well known cluster id claim.
+	DefaultClaimName = "id.k8s.io"
+)
+
+// ClusterPropertyGroupVersionKind is the kind of KEP-2149 cluster claims.
+var ClusterPropertyGroupVersionKind = schema.GroupVersionKind{
+	Group:   "about.k8s.io",
+	Version: "v1alpha1",
+	Kind:    "ClusterProperty",
+}
+
+// Identity identifies a cluster.
+type Identity struct {
+	// ID is the stable cluster identifier (the claimed id if set, otherwise
+	// the kube-system namespace UID).
+	ID string
+	// KubeSystemUID is the UID of the kube-system namespace.
+	KubeSystemUID types.UID
+	// ClaimID is the value of the cluster id claim (if any).
+	ClaimID string
+}
+
+func (i *Identity) String() string {
+	return i.ID
+}
+
+// Options configures cluster identity lookups.
+type Options struct {
+	// ClaimGroupVersionKind is the kind of the cluster claim. Defaults to
+	// ClusterPropertyGroupVersionKind.
+	ClaimGroupVersionKind *schema.GroupVersionKind
+	// ClaimName is the name of the cluster id claim. Defaults to DefaultClaimName.
+	ClaimName string
+	// DisableClaim only uses the kube-system namespace UID.
+	DisableClaim bool
+}
+
+// Resolver looks up, and caches, the cluster's identity.
+type Resolver struct {
+	c    client.Reader
+	opts Options
+
+	mu       sync.Mutex
+	identity *Identity
+}
+
+// NewResolver returns a new cluster identity resolver.
+func NewResolver(c client.Reader, opts Options) *Resolver {
+	if opts.ClaimGroupVersionKind == nil {
+		opts.ClaimGroupVersionKind = &ClusterPropertyGroupVersionKind
+	}
+
+	if opts.ClaimName == "" {
+		opts.ClaimName = DefaultClaimName
+	}
+
+	return &Resolver{
+		c:    c,
+		opts: opts,
+	}
+}
+
+// Get returns the cluster's identity, it is looked up once and then cached.
+func (r *Resolver) Get(ctx context.Context) (*Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.identity != nil {
+		return r.identity, nil
+	}
+
+	identity, err := lookup(ctx, r.c, &r.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.identity = identity
+
+	return identity, nil
+}
+
+// Get looks up the cluster's identity (without caching).
+func Get(ctx context.Context, c client.Reader, opts Options) (*Identity, error) {
+	return NewResolver(c, opts).Get(ctx)
+}
+
+func lookup(ctx context.Context, c client.Reader, opts *Options) (*Identity, error) {
+	var ns metav1.PartialObjectMetadata
+	ns.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"})
+	if err := c.Get(ctx, client.ObjectKey{Name: metav1.NamespaceSystem}, &ns); err != nil {
+		return nil, fmt.Errorf("failed to get kube-system namespace: %w", err)
+	}
+
+	identity := &Identity{
+		ID:            string(ns.UID),
+		KubeSystemUID: ns.UID,
+	}
+
+	if opts.DisableClaim {
+		return identity, nil
+	}
+
+	var claim unstructured.Unstructured
+	claim.SetGroupVersionKind(*opts.ClaimGroupVersionKind)
+	if err := c.Get(ctx, client.ObjectKey{Name: opts.ClaimName}, &claim); err != nil {
+		// The claim is optional (and its CRD is often not installed).
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return identity, nil
+		}
+
+		return nil, fmt.Errorf("failed to get cluster claim: %w", err)
+	}
+
+	if value, _, _ := unstructured.NestedString(claim.Object, "spec", "value"); value != "" {
+		identity.ClaimID = value
+		identity.ID = value
+	}
+
+	return identity, nil
+}
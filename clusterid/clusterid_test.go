@@ -0,0 +1,84 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusterid_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/clusterid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+
+	kubeSystem := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "kube-system-uid"}}
+
+	t.Run("KubeSystemUID", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithObjects(kubeSystem.DeepCopy()).Build()
+
+		r := clusterid.NewResolver(c, clusterid.Options{})
+
+		identity, err := r.Get(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "kube-system-uid", identity.ID)
+		assert.Equal(t, types.UID("kube-system-uid"), identity.KubeSystemUID)
+		assert.Empty(t, identity.ClaimID)
+
+		// The identity is cached.
+		require.NoError(t, c.Delete(ctx, kubeSystem.DeepCopy()))
+
+		identity, err = r.Get(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "kube-system-uid", identity.ID)
+
+		_, err = clusterid.Get(ctx, c, clusterid.Options{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Claim", func(t *testing.T) {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(clusterid.ClusterPropertyGroupVersionKind, meta.RESTScopeRoot)
+		mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+
+		claim := &unstructured.Unstructured{}
+		claim.SetGroupVersionKind(clusterid.ClusterPropertyGroupVersionKind)
+		claim.SetName(clusterid.DefaultClaimName)
+		require.NoError(t, unstructured.SetNestedField(claim.Object, "gpu-cluster-1", "spec", "value"))
+
+		c := fake.NewClientBuilder().WithRESTMapper(mapper).WithObjects(kubeSystem.DeepCopy(), claim).Build()
+
+		identity, err := clusterid.Get(ctx, c, clusterid.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "gpu-cluster-1", identity.ID)
+		assert.Equal(t, "gpu-cluster-1", identity.ClaimID)
+		assert.Equal(t, types.UID("kube-system-uid"), identity.KubeSystemUID)
+
+		identity, err = clusterid.Get(ctx, c, clusterid.Options{DisableClaim: true})
+		require.NoError(t, err)
+		assert.Equal(t, "kube-system-uid", identity.ID)
+	})
+}
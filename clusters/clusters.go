@@ -0,0 +1,461 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package clusters provides a registry of clients for remote clusters,
+// built from kubeconfig Secrets (in the style of Cluster API).
+package clusters
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/clock"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// ClusterNameLabel is the label identifying the cluster a kubeconfig Secret belongs to.
+	ClusterNameLabel = "cluster.x-k8s.io/cluster-name"
+	// KubeconfigSecretSuffix is the conventional suffix of kubeconfig Secret names.
+	KubeconfigSecretSuffix = "-kubeconfig"
+	// DefaultKubeconfigKey is the conventional key of the kubeconfig in the Secret.
+	DefaultKubeconfigKey = "value"
+)
+
+const (
+	DefaultHealthCheckInterval = 30 * time.Second
+	DefaultHealthCheckTimeout  = 10 * time.Second
+)
+
+var (
+	// ErrClusterNotFound is returned when a cluster has not been registered.
+	ErrClusterNotFound = errors.New("cluster not found")
+	// ErrClusterUnhealthy is returned when a cluster has failed its most recent health check.
+	ErrClusterUnhealthy = errors.New("cluster unhealthy")
+)
+
+// Cluster provides access to a remote cluster.
+type Cluster interface {
+	// Name returns the name of the cluster.
+	Name() string
+	// GetConfig returns the REST config of the cluster.
+	GetConfig() *rest.Config
+	// GetClient returns a client for the cluster.
+	GetClient() client.Client
+	// GetRESTMapper returns a RESTMapper for the cluster.
+	GetRESTMapper() meta.RESTMapper
+}
+
+// Getter looks up clusters by name.
+type Getter interface {
+	GetCluster(name string) (Cluster, error)
+}
+
+// ClientFunc builds a client for a cluster.
+type ClientFunc func(config *rest.Config, opts client.Options) (client.Client, error)
+
+// HealthCheckFunc checks the health of a cluster.
+type HealthCheckFunc func(ctx context.Context, cluster Cluster) error
+
+// Options configures the registry.
+type Options struct {
+	// Namespace, if set, restricts the kubeconfig Secrets to a single namespace.
+	Namespace string
+	// Scheme is the scheme used by cluster clients.
+	Scheme *runtime.Scheme
+	// KubeconfigKey is the key of the kubeconfig in the Secret.
+	KubeconfigKey string
+	// HealthCheckInterval is how often clusters are health checked.
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout is the maximum amount of time a health check can take.
+	HealthCheckTimeout time.Duration
+	// HealthCheck checks the health of a cluster (defaults to querying the server version).
+	HealthCheck HealthCheckFunc
+	// NewClient builds a client for a cluster (defaults to client.New).
+	NewClient ClientFunc
+	// Clock is used to schedule health checks (useful for testing).
+	Clock clock.WithTicker
+}
+
+// Registry maintains clients for the clusters described by kubeconfig Secrets.
+type Registry struct {
+	c        client.Client
+	opts     Options
+	mu       sync.RWMutex
+	clusters map[string]*cluster
+}
+
+var _ Getter = (*Registry)(nil)
+
+// NewRegistry returns a new cluster registry.
+func NewRegistry(c client.Client, opts Options) *Registry {
+	if opts.Scheme == nil {
+		opts.Scheme = c.Scheme()
+	}
+
+	if opts.KubeconfigKey == "" {
+		opts.KubeconfigKey = DefaultKubeconfigKey
+	}
+
+	if opts.HealthCheckInterval == 0 {
+		opts.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+
+	if opts.HealthCheckTimeout == 0 {
+		opts.HealthCheckTimeout = DefaultHealthCheckTimeout
+	}
+
+	if opts.HealthCheck == nil {
+		opts.HealthCheck = checkServerVersion
+	}
+
+	if opts.NewClient == nil {
+		opts.NewClient = client.New
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &Registry{
+		c:        c,
+		opts:     opts,
+		clusters: make(map[string]*cluster),
+	}
+}
+
+// SetupWithManager registers the registry to watch kubeconfig Secrets and
+// periodically health check the registered clusters.
+func (r *Registry) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.Add(r); err != nil {
+		return fmt.Errorf("failed to add health checker: %w", err)
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("clusters").
+		For(&corev1.Secret{}, builder.WithPredicates(predicate.NewPredicateFuncs(r.isKubeconfigSecret))).
+		Complete(r)
+}
+
+// GetCluster returns the named cluster. The client is (re)built on demand
+// if it was invalidated.
+func (r *Registry) GetCluster(name string) (Cluster, error) {
+	r.mu.RLock()
+	cl, ok := r.clusters[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClusterNotFound, name)
+	}
+
+	return cl.get()
+}
+
+// Clusters returns the names of all the registered clusters.
+func (r *Registry) Clusters() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.clusters))
+	for name := range r.clusters {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Invalidate drops the cached client for the named cluster, forcing it to
+// be rebuilt on next use (eg. after repeated connection errors).
+func (r *Registry) Invalidate(name string) {
+	r.mu.RLock()
+	cl, ok := r.clusters[name]
+	r.mu.RUnlock()
+
+	if ok {
+		cl.invalidate()
+	}
+}
+
+// Reconcile registers, updates, or removes a cluster from its kubeconfig Secret.
+func (r *Registry) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var secret corev1.Secret
+	if err := r.c.Get(ctx, req.NamespacedName, &secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to get secret: %w", err)
+		}
+
+		r.removeBySecret(req.NamespacedName)
+
+		return ctrl.Result{}, nil
+	}
+
+	if !secret.DeletionTimestamp.IsZero() || !r.isKubeconfigSecret(&secret) {
+		r.removeBySecret(req.NamespacedName)
+
+		return ctrl.Result{}, nil
+	}
+
+	name := clusterName(&secret)
+	kubeconfig, ok := secret.Data[r.opts.KubeconfigKey]
+	if !ok {
+		return ctrl.Result{}, fmt.Errorf("secret %s is missing key %q", req.NamespacedName, r.opts.KubeconfigKey)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.clusters[name]; ok {
+		if existing.secret != req.NamespacedName {
+			return ctrl.Result{}, fmt.Errorf("cluster %q is already registered by secret %s", name, existing.secret)
+		}
+
+		if existing.sameKubeconfig(kubeconfig) {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	logger.Info("Registering cluster", "cluster", name)
+
+	r.clusters[name] = &cluster{
+		name:       name,
+		secret:     req.NamespacedName,
+		kubeconfig: kubeconfig,
+		opts:       &r.opts,
+		healthy:    true,
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// Start periodically health checks the registered clusters.
+func (r *Registry) Start(ctx context.Context) error {
+	ticker := r.opts.Clock.NewTicker(r.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			r.CheckHealth(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection returns false, as all replicas need healthy clients.
+func (r *Registry) NeedLeaderElection() bool {
+	return false
+}
+
+// CheckHealth health checks all the registered clusters. Unhealthy clusters
+// have their clients invalidated.
+func (r *Registry) CheckHealth(ctx context.Context) {
+	logger := log.FromContext(ctx)
+
+	r.mu.RLock()
+	clusters := make([]*cluster, 0, len(r.clusters))
+	for _, cl := range r.clusters {
+		clusters = append(clusters, cl)
+	}
+	r.mu.RUnlock()
+
+	for _, cl := range clusters {
+		err := cl.checkHealth(ctx)
+		if err != nil {
+			logger.Error(err, "Cluster health check failed", "cluster", cl.name)
+		}
+	}
+}
+
+func (r *Registry) isKubeconfigSecret(obj client.Object) bool {
+	if r.opts.Namespace != "" && obj.GetNamespace() != r.opts.Namespace {
+		return false
+	}
+
+	if _, ok := obj.GetLabels()[ClusterNameLabel]; ok {
+		return true
+	}
+
+	return strings.HasSuffix(obj.GetName(), KubeconfigSecretSuffix)
+}
+
+func (r *Registry) removeBySecret(key client.ObjectKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, cl := range r.clusters {
+		if cl.secret == key {
+			delete(r.clusters, name)
+		}
+	}
+}
+
+func clusterName(secret *corev1.Secret) string {
+	if name, ok := secret.Labels[ClusterNameLabel]; ok && name != "" {
+		return name
+	}
+
+	return strings.TrimSuffix(secret.Name, KubeconfigSecretSuffix)
+}
+
+func checkServerVersion(ctx context.Context, cl Cluster) error {
+	dc, err := discovery.NewDiscoveryClientForConfig(cl.GetConfig())
+	if err != nil {
+		return fmt.Errorf("failed to create discovery client: %w", err)
+	}
+
+	// ServerVersion doesn't take a context, so query the endpoint directly
+	// (so that the health check timeout is honored).
+	if _, err := dc.RESTClient().Get().AbsPath("/version").Do(ctx).Raw(); err != nil {
+		return fmt.Errorf("failed to get server version: %w", err)
+	}
+
+	return nil
+}
+
+type cluster struct {
+	name       string
+	secret     client.ObjectKey
+	kubeconfig []byte
+	opts       *Options
+
+	mu        sync.Mutex
+	config    *rest.Config
+	client    client.Client
+	mapper    meta.RESTMapper
+	healthy   bool
+	healthErr error
+}
+
+func (cl *cluster) Name() string                   { return cl.name }
+func (cl *cluster) GetConfig() *rest.Config        { return cl.config }
+func (cl *cluster) GetClient() client.Client       { return cl.client }
+func (cl *cluster) GetRESTMapper() meta.RESTMapper { return cl.mapper }
+
+func (cl *cluster) sameKubeconfig(kubeconfig []byte) bool {
+	return bytes.Equal(cl.kubeconfig, kubeconfig)
+}
+
+// get returns a snapshot of the cluster, building the client if required.
+func (cl *cluster) get() (Cluster, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if !cl.healthy {
+		return nil, fmt.Errorf("%w: %s: %v", ErrClusterUnhealthy, cl.name, cl.healthErr)
+	}
+
+	if err := cl.build(); err != nil {
+		return nil, err
+	}
+
+	return &cluster{
+		name:   cl.name,
+		config: cl.config,
+		client: cl.client,
+		mapper: cl.mapper,
+	}, nil
+}
+
+func (cl *cluster) build() error {
+	if cl.client != nil {
+		return nil
+	}
+
+	config, err := clientcmd.RESTConfigFromKubeConfig(cl.kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse kubeconfig for cluster %q: %w", cl.name, err)
+	}
+
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return fmt.Errorf("failed to create http client for cluster %q: %w", cl.name, err)
+	}
+
+	mapper, err := apiutil.NewDynamicRESTMapper(config, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to create rest mapper for cluster %q: %w", cl.name, err)
+	}
+
+	c, err := cl.opts.NewClient(config, client.Options{
+		HTTPClient: httpClient,
+		Scheme:     cl.opts.Scheme,
+		Mapper:     mapper,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create client for cluster %q: %w", cl.name, err)
+	}
+
+	cl.config = config
+	cl.mapper = mapper
+	cl.client = c
+
+	return nil
+}
+
+func (cl *cluster) invalidate() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.config = nil
+	cl.client = nil
+	cl.mapper = nil
+}
+
+func (cl *cluster) checkHealth(ctx context.Context) error {
+	cl.mu.Lock()
+	err := cl.build()
+	snapshot := &cluster{name: cl.name, config: cl.config, client: cl.client, mapper: cl.mapper}
+	cl.mu.Unlock()
+
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ctx, cl.opts.HealthCheckTimeout)
+		err = cl.opts.HealthCheck(ctx, snapshot)
+		cancel()
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.healthy = err == nil
+	cl.healthErr = err
+	if err != nil {
+		cl.config = nil
+		cl.client = nil
+		cl.mapper = nil
+	}
+
+	return err
+}
@@ -0,0 +1,183 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clusters_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/clusters"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: workload
+  cluster:
+    server: https://workload.example.com:6443
+contexts:
+- name: workload
+  context:
+    cluster: workload
+    user: admin
+current-context: workload
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "workload-kubeconfig",
+		},
+		Data: map[string][]byte{
+			clusters.DefaultKubeconfigKey: []byte(kubeconfig),
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+
+	var builds int
+	var healthErr error
+	r := clusters.NewRegistry(c, clusters.Options{
+		NewClient: func(config *rest.Config, opts client.Options) (client.Client, error) {
+			builds++
+			assert.Equal(t, "https://workload.example.com:6443", config.Host)
+			return fake.NewClientBuilder().WithScheme(opts.Scheme).Build(), nil
+		},
+		HealthCheck: func(ctx context.Context, cluster clusters.Cluster) error {
+			return healthErr
+		},
+	})
+
+	_, err := r.GetCluster("workload")
+	require.ErrorIs(t, err, clusters.ErrClusterNotFound)
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secret)}
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"workload"}, r.Clusters())
+
+	cl, err := r.GetCluster("workload")
+	require.NoError(t, err)
+	assert.Equal(t, "workload", cl.Name())
+	assert.NotNil(t, cl.GetClient())
+	assert.NotNil(t, cl.GetRESTMapper())
+
+	// Cached.
+	_, err = r.GetCluster("workload")
+	require.NoError(t, err)
+	assert.Equal(t, 1, builds)
+
+	t.Run("Invalidate", func(t *testing.T) {
+		r.Invalidate("workload")
+
+		_, err := r.GetCluster("workload")
+		require.NoError(t, err)
+		assert.Equal(t, 2, builds)
+	})
+
+	t.Run("Unhealthy", func(t *testing.T) {
+		healthErr = errors.New("connection refused")
+		r.CheckHealth(ctx)
+
+		_, err := r.GetCluster("workload")
+		require.ErrorIs(t, err, clusters.ErrClusterUnhealthy)
+
+		healthErr = nil
+		r.CheckHealth(ctx)
+
+		_, err = r.GetCluster("workload")
+		require.NoError(t, err)
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		require.NoError(t, c.Delete(ctx, secret))
+
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+
+		_, err = r.GetCluster("workload")
+		require.ErrorIs(t, err, clusters.ErrClusterNotFound)
+	})
+}
+
+func TestRegistryHealthCheckTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	// The API server never responds.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "workload-kubeconfig",
+		},
+		Data: map[string][]byte{
+			clusters.DefaultKubeconfigKey: []byte(strings.ReplaceAll(kubeconfig, "https://workload.example.com:6443", srv.URL)),
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+
+	r := clusters.NewRegistry(c, clusters.Options{
+		HealthCheckTimeout: 50 * time.Millisecond,
+		NewClient: func(config *rest.Config, opts client.Options) (client.Client, error) {
+			return fake.NewClientBuilder().WithScheme(opts.Scheme).Build(), nil
+		},
+	})
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secret)})
+	require.NoError(t, err)
+
+	start := time.Now()
+	r.CheckHealth(ctx)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	_, err = r.GetCluster("workload")
+	require.ErrorIs(t, err, clusters.ErrClusterUnhealthy)
+}
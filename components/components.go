@@ -0,0 +1,417 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package components reconciles an object's children declared as a
+// dependency graph of components. Each component provides the templates of
+// its children, and a readiness check. Components are applied in dependency
+// order, and a component is not applied until all its dependencies are ready.
+package components
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/childstatus"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/updater"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ConditionTypeReady is the condition set on the parent.
+	ConditionTypeReady = "Ready"
+	// DefaultRequeueAfter is how long to wait before rechecking components that are not ready.
+	DefaultRequeueAfter = 5 * time.Second
+)
+
+// Condition reasons set on the parent.
+const (
+	ReasonAllComponentsReady = "AllComponentsReady"
+	ReasonComponentsNotReady = "ComponentsNotReady"
+	ReasonComponentFailed    = "ComponentFailed"
+)
+
+var (
+	// ErrCycle is returned when the components contain a dependency cycle.
+	ErrCycle = errors.New("dependency cycle")
+	// ErrUnknownDependency is returned when a component depends on an undeclared component.
+	ErrUnknownDependency = errors.New("unknown dependency")
+	// ErrDuplicateComponent is returned when a component is declared more than once.
+	ErrDuplicateComponent = errors.New("duplicate component")
+	// ErrComponentsNotReady is returned (as a retryable error) when components
+	// have been applied without failing, but are not all ready yet.
+	ErrComponentsNotReady = errors.New("components not ready")
+)
+
+// State is the state of a component.
+type State string
+
+const (
+	// StateReady indicates the component's children have been applied and are ready.
+	StateReady State = "Ready"
+	// StateProgressing indicates the component's children have been applied, but are not yet ready.
+	StateProgressing State = "Progressing"
+	// StateWaiting indicates the component is waiting for its dependencies to become ready.
+	StateWaiting State = "Waiting"
+	// StateFailed indicates the component could not be applied.
+	StateFailed State = "Failed"
+)
+
+// TemplatesFunc returns the templates of a component's children.
+type TemplatesFunc func(ctx context.Context) ([]client.Object, error)
+
+// ReadyFunc determines whether a component's (applied) children are ready,
+// along with a human readable message explaining why not.
+type ReadyFunc func(ctx context.Context, children []client.Object) (bool, string, error)
+
+// Component is a group of children that are applied together.
+type Component struct {
+	// Name is the unique name of the component.
+	Name string
+	// DependsOn are the names of the components that must be ready before
+	// this component is applied.
+	DependsOn []string
+	// Templates returns the templates of the component's children.
+	Templates TemplatesFunc
+	// Ready determines whether the component's children are ready. Defaults
+	// to the childstatus checkers for the children's kinds.
+	Ready ReadyFunc
+	// BeforeDelete, if set, is called during teardown before the
+	// component's children are deleted (eg. to drain them), until it
+	// reports that it is done.
+	BeforeDelete ReadyFunc
+}
+
+// Graph is a validated dependency graph of components.
+type Graph struct {
+	components []Component
+}
+
+// NewGraph validates the components and orders them by their dependencies
+// (components without an ordering constraint keep their declaration order).
+func NewGraph(components ...Component) (*Graph, error) {
+	byName := make(map[string]*Component, len(components))
+	for i := range components {
+		if _, ok := byName[components[i].Name]; ok {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateComponent, components[i].Name)
+		}
+
+		byName[components[i].Name] = &components[i]
+	}
+
+	for _, component := range components {
+		for _, dep := range component.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("%w: %q depends on %q", ErrUnknownDependency, component.Name, dep)
+			}
+		}
+	}
+
+	g := &Graph{}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	marks := make(map[string]int, len(components))
+
+	var visit func(component *Component, path []string) error
+	visit = func(component *Component, path []string) error {
+		switch marks[component.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrCycle, strings.Join(append(path, component.Name), " -> "))
+		}
+
+		marks[component.Name] = visiting
+		for _, dep := range component.DependsOn {
+			if err := visit(byName[dep], append(path, component.Name)); err != nil {
+				return err
+			}
+		}
+		marks[component.Name] = visited
+
+		g.components = append(g.components, *component)
+
+		return nil
+	}
+
+	for i := range components {
+		if err := visit(&components[i], nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// Order returns the names of the components in the order they are applied.
+func (g *Graph) Order() []string {
+	names := make([]string, 0, len(g.components))
+	for _, component := range g.components {
+		names = append(names, component.Name)
+	}
+
+	return names
+}
+
+// Options configures reconciliation of a graph.
+type Options struct {
+	// Scheme is used to set owner references and identify children kinds.
+	Scheme *runtime.Scheme
+	// Owner, if set, is set as the controller of every child.
+	Owner client.Object
+	// RequeueAfter is how long to wait before rechecking components that
+	// are not ready (see ErrComponentsNotReady). Defaults to
+	// DefaultRequeueAfter.
+	RequeueAfter time.Duration
+}
+
+// ComponentStatus is the status of a single component.
+type ComponentStatus struct {
+	// Name is the name of the component.
+	Name string
+	// State is the state of the component.
+	State State
+	// Message explains why the component is not ready.
+	Message string
+	// Children are the applied children of the component.
+	Children []client.Object
+}
+
+func (cs *ComponentStatus) String() string {
+	if cs.Message == "" {
+		return fmt.Sprintf("%s is %s", cs.Name, strings.ToLower(string(cs.State)))
+	}
+
+	return fmt.Sprintf("%s: %s", cs.Name, cs.Message)
+}
+
+// Status is the aggregated status of a graph's components.
+type Status struct {
+	// Components are the statuses of the components, in the order they were applied.
+	Components []ComponentStatus
+}
+
+// Reconcile applies the components in dependency order. Components whose
+// dependencies are not yet ready are skipped, while independent components
+// continue to be applied. An error is returned (along with the status) if
+// any component could not be applied. Otherwise, if any component is not yet
+// ready, a retryable error (wrapping ErrComponentsNotReady) is returned, so
+// that the components are rechecked after RequeueAfter.
+func (g *Graph) Reconcile(ctx context.Context, c client.Client, opts Options) (*Status, error) {
+	logger := log.FromContext(ctx)
+
+	if opts.RequeueAfter == 0 {
+		opts.RequeueAfter = DefaultRequeueAfter
+	}
+
+	aggregator := childstatus.NewAggregator(opts.Scheme)
+
+	status := &Status{}
+	states := make(map[string]State, len(g.components))
+
+	var errs []error
+	for _, component := range g.components {
+		cs := ComponentStatus{Name: component.Name}
+
+		var waitingOn []string
+		for _, dep := range component.DependsOn {
+			if states[dep] != StateReady {
+				waitingOn = append(waitingOn, dep)
+			}
+		}
+
+		if len(waitingOn) > 0 {
+			cs.State = StateWaiting
+			cs.Message = fmt.Sprintf("waiting for %s", strings.Join(waitingOn, ", "))
+		} else if err := g.reconcileComponent(ctx, c, aggregator, &component, &cs, &opts); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reconcile component %q: %w", component.Name, err))
+
+			cs.State = StateFailed
+			cs.Message = err.Error()
+		}
+
+		if cs.State != StateReady {
+			logger.V(1).Info("Component not ready", "component", cs.Name, "state", cs.State, "message", cs.Message)
+		}
+
+		states[component.Name] = cs.State
+		status.Components = append(status.Components, cs)
+	}
+
+	if len(errs) > 0 {
+		return status, errors.Join(errs...)
+	}
+
+	if !status.Ready() {
+		var notReady []string
+		for _, cs := range status.Components {
+			if cs.State != StateReady {
+				notReady = append(notReady, cs.Name)
+			}
+		}
+
+		return status, retryable.After(fmt.Errorf("%w: %s", ErrComponentsNotReady, strings.Join(notReady, ", ")), opts.RequeueAfter)
+	}
+
+	return status, nil
+}
+
+func (g *Graph) reconcileComponent(ctx context.Context, c client.Client, aggregator *childstatus.Aggregator, component *Component, cs *ComponentStatus, opts *Options) error {
+	templates, err := component.Templates(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get templates: %w", err)
+	}
+
+	for _, template := range templates {
+		if opts.Owner != nil {
+			if err := controllerutil.SetControllerReference(opts.Owner, template, opts.Scheme); err != nil {
+				return fmt.Errorf("failed to set controller reference on %q: %w", template.GetName(), err)
+			}
+		}
+
+		child, err := updater.CreateOrUpdateFromTemplate(ctx, c, template)
+		if err != nil {
+			return fmt.Errorf("failed to apply %q: %w", template.GetName(), err)
+		}
+
+		cs.Children = append(cs.Children, child)
+	}
+
+	ready := component.Ready
+	if ready == nil {
+		ready = func(_ context.Context, children []client.Object) (bool, string, error) {
+			summary, err := aggregator.Aggregate(children)
+			if err != nil {
+				return false, "", err
+			}
+
+			var details []string
+			for _, child := range summary.Children {
+				if child.State != childstatus.StateReady {
+					details = append(details, child.String())
+				}
+			}
+
+			return summary.Ready(), strings.Join(details, "; "), nil
+		}
+	}
+
+	isReady, message, err := ready(ctx, cs.Children)
+	if err != nil {
+		return fmt.Errorf("failed to check readiness: %w", err)
+	}
+
+	cs.State = StateProgressing
+	cs.Message = message
+	if isReady {
+		cs.State = StateReady
+		cs.Message = ""
+	}
+
+	return nil
+}
+
+// Get returns the status of the named component.
+func (s *Status) Get(name string) (*ComponentStatus, bool) {
+	for i := range s.Components {
+		if s.Components[i].Name == name {
+			return &s.Components[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// InState returns the components in the given state.
+func (s *Status) InState(state State) []ComponentStatus {
+	var components []ComponentStatus
+	for _, cs := range s.Components {
+		if cs.State == state {
+			components = append(components, cs)
+		}
+	}
+
+	return components
+}
+
+// Ready returns true if all the components are ready.
+func (s *Status) Ready() bool {
+	return len(s.InState(StateReady)) == len(s.Components)
+}
+
+// Condition returns the Ready condition for the parent.
+func (s *Status) Condition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             ReasonAllComponentsReady,
+		Message:            fmt.Sprintf("%d/%d components ready", len(s.Components), len(s.Components)),
+	}
+
+	if s.Ready() {
+		return condition
+	}
+
+	var notReady []ComponentStatus
+	for _, cs := range s.Components {
+		if cs.State != StateReady {
+			notReady = append(notReady, cs)
+		}
+	}
+
+	// Failed components are listed first.
+	sort.SliceStable(notReady, func(i, j int) bool {
+		return notReady[i].State == StateFailed && notReady[j].State != StateFailed
+	})
+
+	condition.Status = metav1.ConditionFalse
+	condition.Reason = ReasonComponentsNotReady
+	if notReady[0].State == StateFailed {
+		condition.Reason = ReasonComponentFailed
+	}
+
+	var details []string
+	for _, cs := range notReady {
+		details = append(details, cs.String())
+	}
+
+	condition.Message = fmt.Sprintf("%d/%d components ready: %s",
+		len(s.Components)-len(notReady), len(s.Components), strings.Join(details, "; "))
+
+	return condition
+}
+
+// SetCondition sets the Ready condition on the given conditions slice
+// (typically the parent's status.conditions).
+func (s *Status) SetCondition(conditions *[]metav1.Condition, generation int64) {
+	meta.SetStatusCondition(conditions, s.Condition(generation))
+}
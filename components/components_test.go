@@ -0,0 +1,320 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package components_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/components"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNewGraph(t *testing.T) {
+	g, err := components.NewGraph(
+		components.Component{Name: "app", DependsOn: []string{"database", "config"}},
+		components.Component{Name: "database", DependsOn: []string{"config"}},
+		components.Component{Name: "config"},
+		components.Component{Name: "metrics"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"config", "database", "app", "metrics"}, g.Order())
+
+	_, err = components.NewGraph(
+		components.Component{Name: "a", DependsOn: []string{"b"}},
+		components.Component{Name: "b", DependsOn: []string{"a"}},
+	)
+	assert.ErrorIs(t, err, components.ErrCycle)
+	assert.ErrorContains(t, err, "a -> b -> a")
+
+	_, err = components.NewGraph(components.Component{Name: "a", DependsOn: []string{"b"}})
+	assert.ErrorIs(t, err, components.ErrUnknownDependency)
+
+	_, err = components.NewGraph(components.Component{Name: "a"}, components.Component{Name: "a"})
+	assert.ErrorIs(t, err, components.ErrDuplicateComponent)
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	owner := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"},
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(owner).
+		WithStatusSubresource(&appsv1.Deployment{}).
+		Build()
+
+	templates := func(objs ...client.Object) components.TemplatesFunc {
+		return func(ctx context.Context) ([]client.Object, error) {
+			return objs, nil
+		}
+	}
+
+	deployment := func(name string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			},
+		}
+	}
+
+	g, err := components.NewGraph(
+		components.Component{
+			Name:      "config",
+			Templates: templates(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"}}),
+		},
+		components.Component{
+			Name:      "database",
+			DependsOn: []string{"config"},
+			Templates: templates(deployment("database")),
+		},
+		components.Component{
+			Name:      "app",
+			DependsOn: []string{"database"},
+			Templates: templates(deployment("app")),
+			Ready: func(ctx context.Context, children []client.Object) (bool, string, error) {
+				return true, "", nil
+			},
+		},
+		components.Component{
+			Name: "broken",
+			Templates: func(ctx context.Context) ([]client.Object, error) {
+				return nil, errors.New("missing secret")
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	opts := components.Options{Scheme: scheme, Owner: owner, RequeueAfter: time.Minute}
+
+	status, err := g.Reconcile(ctx, c, opts)
+	require.ErrorContains(t, err, `failed to reconcile component "broken"`)
+	assert.False(t, retryable.Is(err))
+
+	states := func(status *components.Status) map[string]components.State {
+		states := make(map[string]components.State)
+		for _, cs := range status.Components {
+			states[cs.Name] = cs.State
+		}
+		return states
+	}
+
+	assert.Equal(t, map[string]components.State{
+		"config":   components.StateReady,
+		"database": components.StateProgressing,
+		"app":      components.StateWaiting,
+		"broken":   components.StateFailed,
+	}, states(status))
+
+	assert.False(t, status.Ready())
+
+	condition := status.Condition(1)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, components.ReasonComponentFailed, condition.Reason)
+	assert.Equal(t, "1/4 components ready: broken: failed to get templates: missing secret; database: Deployment/database: 0/1 replicas updated; app: waiting for database", condition.Message)
+
+	var config corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "config"}, &config))
+	assert.True(t, metav1.IsControlledBy(&config, owner))
+
+	err = c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "app"}, &appsv1.Deployment{})
+	assert.Error(t, err)
+
+	// Components that aren't ready yet are retried, rather than failing.
+	healthy, err := components.NewGraph(components.Component{Name: "database", Templates: templates(deployment("database"))})
+	require.NoError(t, err)
+
+	_, err = healthy.Reconcile(ctx, c, opts)
+	require.ErrorIs(t, err, components.ErrComponentsNotReady)
+
+	retryAfter, ok := retryable.RetryAfter(err)
+	require.True(t, ok)
+	assert.Equal(t, time.Minute, retryAfter)
+
+	var database appsv1.Deployment
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "database"}, &database))
+
+	database.Status.UpdatedReplicas = 1
+	database.Status.AvailableReplicas = 1
+	require.NoError(t, c.Status().Update(ctx, &database))
+
+	status, err = g.Reconcile(ctx, c, opts)
+	require.Error(t, err)
+
+	assert.Equal(t, components.StateReady, states(status)["app"])
+
+	app, ok := status.Get("app")
+	require.True(t, ok)
+	require.Len(t, app.Children, 1)
+	assert.Equal(t, "app", app.Children[0].GetName())
+
+	status, err = healthy.Reconcile(ctx, c, opts)
+	require.NoError(t, err)
+	assert.True(t, status.Ready())
+}
+
+func TestTeardown(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	owner := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "owner-uid"},
+	}
+	ownerRef := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: "owner-uid"}
+
+	config := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config", OwnerReferences: []metav1.OwnerReference{ownerRef}}}
+	data := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       "default",
+		Name:            "data",
+		OwnerReferences: []metav1.OwnerReference{ownerRef},
+		Annotations:     map[string]string{components.DeletionProtectionAnnotation: "true"},
+	}}
+	database := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "database"}}
+	app := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(owner, config, data, database, app).
+		Build()
+
+	templates := func(objs ...client.Object) components.TemplatesFunc {
+		return func(ctx context.Context) ([]client.Object, error) {
+			var copies []client.Object
+			for _, obj := range objs {
+				copies = append(copies, obj.DeepCopyObject().(client.Object))
+			}
+			return copies, nil
+		}
+	}
+
+	drained := false
+	g, err := components.NewGraph(
+		components.Component{Name: "config", Templates: templates(config, data)},
+		components.Component{Name: "database", DependsOn: []string{"config"}, Templates: templates(database)},
+		components.Component{
+			Name:      "app",
+			DependsOn: []string{"database"},
+			Templates: templates(app),
+			BeforeDelete: func(ctx context.Context, children []client.Object) (bool, string, error) {
+				return drained, "draining connections", nil
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	opts := components.TeardownOptions{Owner: owner}
+
+	status, err := g.Teardown(ctx, c, opts)
+	require.NoError(t, err)
+	assert.False(t, status.Complete())
+
+	cs, _ := status.Get("app")
+	assert.Equal(t, components.StateDeleting, cs.State)
+	assert.Equal(t, "preparing for deletion: draining connections", cs.Message)
+
+	cs, _ = status.Get("database")
+	assert.Equal(t, components.StateWaiting, cs.State)
+	assert.Equal(t, "waiting for app to be deleted", cs.Message)
+
+	condition := status.Condition(1)
+	assert.Equal(t, components.ReasonTearingDown, condition.Reason)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Contains(t, condition.Message, "0/3 components deleted")
+	assert.Equal(t, 5*time.Second, status.Result().After())
+
+	drained = true
+
+	for i := 0; i < 4 && !status.Complete(); i++ {
+		status, err = g.Teardown(ctx, c, opts)
+		require.NoError(t, err)
+	}
+	require.True(t, status.Complete())
+	assert.Equal(t, components.ReasonTeardownComplete, status.Condition(1).Reason)
+
+	for _, obj := range []client.Object{config, database, app} {
+		err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		assert.True(t, apierrors.IsNotFound(err), obj.GetName())
+	}
+
+	// The protected child is orphaned rather than deleted.
+	var protected corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(data), &protected))
+	assert.Empty(t, protected.OwnerReferences)
+}
+
+func TestTeardownOrphansFromController(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	controller := true
+	data := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "data",
+		OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: "owner-uid", Controller: &controller},
+			{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "other-uid"},
+		},
+		Annotations: map[string]string{components.DeletionProtectionAnnotation: "true"},
+	}}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(data).
+		Build()
+
+	g, err := components.NewGraph(components.Component{
+		Name: "config",
+		Templates: func(ctx context.Context) ([]client.Object, error) {
+			return []client.Object{data.DeepCopy()}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	status, err := g.Teardown(ctx, c, components.TeardownOptions{})
+	require.NoError(t, err)
+	require.True(t, status.Complete())
+
+	// Without an owner, only the controller reference is removed.
+	var protected corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(data), &protected))
+	require.Len(t, protected.OwnerReferences, 1)
+	assert.Equal(t, "other", protected.OwnerReferences[0].Name)
+}
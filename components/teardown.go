@@ -0,0 +1,323 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package components
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/results"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// DeletionProtectionAnnotation, when set to "true" on a child, prevents
+	// it from being deleted during teardown. Protected children are orphaned
+	// instead (their owner reference to the parent is removed), so that they
+	// also survive garbage collection of the parent.
+	DeletionProtectionAnnotation = "gpu-ninja.com/deletion-protection"
+)
+
+const (
+	// StateDeleting indicates the component's children are being drained or deleted.
+	StateDeleting State = "Deleting"
+	// StateDeleted indicates the component's children have been deleted (or orphaned).
+	StateDeleted State = "Deleted"
+)
+
+// Condition reasons set on the parent during teardown.
+const (
+	ReasonTearingDown      = "TearingDown"
+	ReasonTeardownFailed   = "TeardownFailed"
+	ReasonTeardownComplete = "TeardownComplete"
+)
+
+// TeardownOptions configures teardown of a graph.
+type TeardownOptions struct {
+	// Owner is the parent whose owner references are removed from protected
+	// children. If unset, the children's controller references are removed
+	// instead.
+	Owner client.Object
+	// PropagationPolicy is used when deleting children. Defaults to
+	// foreground deletion, so that a component is only considered deleted
+	// once its children's dependents are also gone.
+	PropagationPolicy *metav1.DeletionPropagation
+	// RequeueAfter is how long to wait before rechecking components that
+	// are still being deleted. Defaults to DefaultRequeueAfter.
+	RequeueAfter time.Duration
+}
+
+// TeardownStatus is the aggregated teardown status of a graph's components.
+type TeardownStatus struct {
+	// Components are the statuses of the components, in the order they are deleted.
+	Components []ComponentStatus
+
+	requeueAfter time.Duration
+}
+
+// Teardown deletes the components' children in reverse dependency order
+// (a component is not deleted until every component that depends on it
+// has been deleted). It is intended to be called repeatedly (eg. while the
+// parent's finalizer is held), until the returned status is complete. An
+// error is returned (along with the status) if any component could not be
+// deleted.
+func (g *Graph) Teardown(ctx context.Context, c client.Client, opts TeardownOptions) (*TeardownStatus, error) {
+	logger := log.FromContext(ctx)
+
+	if opts.RequeueAfter == 0 {
+		opts.RequeueAfter = DefaultRequeueAfter
+	}
+
+	if opts.PropagationPolicy == nil {
+		foreground := metav1.DeletePropagationForeground
+		opts.PropagationPolicy = &foreground
+	}
+
+	dependents := make(map[string][]string)
+	for _, component := range g.components {
+		for _, dep := range component.DependsOn {
+			dependents[dep] = append(dependents[dep], component.Name)
+		}
+	}
+
+	status := &TeardownStatus{requeueAfter: opts.RequeueAfter}
+	states := make(map[string]State, len(g.components))
+
+	var errs []error
+	for i := len(g.components) - 1; i >= 0; i-- {
+		component := g.components[i]
+		cs := ComponentStatus{Name: component.Name}
+
+		var waitingOn []string
+		for _, dependent := range dependents[component.Name] {
+			if states[dependent] != StateDeleted {
+				waitingOn = append(waitingOn, dependent)
+			}
+		}
+
+		if len(waitingOn) > 0 {
+			cs.State = StateWaiting
+			cs.Message = fmt.Sprintf("waiting for %s to be deleted", strings.Join(waitingOn, ", "))
+		} else if err := g.teardownComponent(ctx, c, &component, &cs, &opts); err != nil {
+			errs = append(errs, fmt.Errorf("failed to tear down component %q: %w", component.Name, err))
+
+			cs.State = StateFailed
+			cs.Message = err.Error()
+		}
+
+		if cs.State != StateDeleted {
+			logger.V(1).Info("Component not deleted", "component", cs.Name, "state", cs.State, "message", cs.Message)
+		}
+
+		states[component.Name] = cs.State
+		status.Components = append(status.Components, cs)
+	}
+
+	return status, errors.Join(errs...)
+}
+
+func (g *Graph) teardownComponent(ctx context.Context, c client.Client, component *Component, cs *ComponentStatus, opts *TeardownOptions) error {
+	templates, err := component.Templates(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get templates: %w", err)
+	}
+
+	for _, template := range templates {
+		child := template.DeepCopyObject().(client.Object)
+		if err := c.Get(ctx, client.ObjectKeyFromObject(template), child); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+
+			return fmt.Errorf("failed to get %q: %w", template.GetName(), err)
+		}
+
+		if child.GetAnnotations()[DeletionProtectionAnnotation] == "true" {
+			if err := orphan(ctx, c, child, opts.Owner); err != nil {
+				return fmt.Errorf("failed to orphan protected %q: %w", child.GetName(), err)
+			}
+
+			continue
+		}
+
+		cs.Children = append(cs.Children, child)
+	}
+
+	if len(cs.Children) == 0 {
+		cs.State = StateDeleted
+		return nil
+	}
+
+	cs.State = StateDeleting
+
+	if component.BeforeDelete != nil && !anyDeleting(cs.Children) {
+		done, message, err := component.BeforeDelete(ctx, cs.Children)
+		if err != nil {
+			return fmt.Errorf("failed to prepare for deletion: %w", err)
+		}
+
+		if !done {
+			cs.Message = "preparing for deletion"
+			if message != "" {
+				cs.Message += ": " + message
+			}
+
+			return nil
+		}
+	}
+
+	var names []string
+	for _, child := range cs.Children {
+		names = append(names, child.GetName())
+
+		if child.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		if err := c.Delete(ctx, child, client.PropagationPolicy(*opts.PropagationPolicy)); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete %q: %w", child.GetName(), err)
+		}
+	}
+
+	cs.Message = fmt.Sprintf("waiting for %s to be deleted", strings.Join(names, ", "))
+
+	return nil
+}
+
+// Get returns the status of the named component.
+func (s *TeardownStatus) Get(name string) (*ComponentStatus, bool) {
+	for i := range s.Components {
+		if s.Components[i].Name == name {
+			return &s.Components[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Complete returns true if all the components have been deleted.
+func (s *TeardownStatus) Complete() bool {
+	for _, cs := range s.Components {
+		if cs.State != StateDeleted {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Result returns a result that requeues the parent until all the
+// components have been deleted.
+func (s *TeardownStatus) Result() results.Result {
+	if s.Complete() {
+		return results.Done()
+	}
+
+	return results.RequeueAfter(s.requeueAfter)
+}
+
+// Condition returns the Ready condition for the parent, reporting teardown progress.
+func (s *TeardownStatus) Condition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionTypeReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonTeardownComplete,
+		Message:            fmt.Sprintf("%d/%d components deleted", len(s.Components), len(s.Components)),
+	}
+
+	if s.Complete() {
+		return condition
+	}
+
+	var deleted int
+	var details []string
+	for _, cs := range s.Components {
+		if cs.State == StateDeleted {
+			deleted++
+			continue
+		}
+
+		if cs.State == StateFailed {
+			condition.Reason = ReasonTeardownFailed
+		}
+
+		details = append(details, cs.String())
+	}
+
+	if condition.Reason != ReasonTeardownFailed {
+		condition.Reason = ReasonTearingDown
+	}
+
+	condition.Message = fmt.Sprintf("%d/%d components deleted: %s", deleted, len(s.Components), strings.Join(details, "; "))
+
+	return condition
+}
+
+// SetCondition sets the Ready condition on the given conditions slice
+// (typically the parent's status.conditions).
+func (s *TeardownStatus) SetCondition(conditions *[]metav1.Condition, generation int64) {
+	meta.SetStatusCondition(conditions, s.Condition(generation))
+}
+
+func anyDeleting(objs []client.Object) bool {
+	for _, obj := range objs {
+		if obj.GetDeletionTimestamp() != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// orphan removes the owner's references (or, if the owner isn't known, the
+// controller reference) from the object.
+func orphan(ctx context.Context, c client.Client, obj client.Object, owner client.Object) error {
+	var ownerUID types.UID
+	if owner != nil {
+		ownerUID = owner.GetUID()
+	} else if controllerRef := metav1.GetControllerOfNoCopy(obj); controllerRef != nil {
+		ownerUID = controllerRef.UID
+	} else {
+		return nil
+	}
+
+	var ownerRefs []metav1.OwnerReference
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != ownerUID {
+			ownerRefs = append(ownerRefs, ref)
+		}
+	}
+
+	if len(ownerRefs) == len(obj.GetOwnerReferences()) {
+		return nil
+	}
+
+	patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+	obj.SetOwnerReferences(ownerRefs)
+
+	return c.Patch(ctx, obj, patch)
+}
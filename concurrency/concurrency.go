@@ -0,0 +1,190 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package concurrency limits the number of concurrent reconciles that share
+// an expensive dependency (eg. the same database server or GPU node), while
+// reconciles of unrelated objects still run in parallel.
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	DefaultRequeueAfter = time.Second
+)
+
+// KeyFunc returns the concurrency group of a reconcile (eg. the name of the
+// database server the object refers to). Reconciles with an empty key are
+// not limited.
+type KeyFunc func(ctx context.Context, req reconcile.Request) (string, error)
+
+// ReleaseFunc releases a concurrency slot, it is safe to call more than once.
+type ReleaseFunc func()
+
+// Options configures a concurrency limiter.
+type Options struct {
+	// MaxConcurrency is the maximum number of concurrent reconciles in a
+	// group (defaults to 1, ie. reconciles in a group are serialized).
+	MaxConcurrency int
+	// Limits overrides the maximum concurrency of specific groups.
+	Limits map[string]int
+	// RequeueAfter is how long to wait before retrying a reconcile whose
+	// group is at capacity.
+	RequeueAfter time.Duration
+}
+
+// Limiter limits concurrency by group.
+type Limiter struct {
+	opts   Options
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+type group struct {
+	slots chan struct{}
+	refs  int
+}
+
+// New returns a new concurrency limiter.
+func New(opts Options) *Limiter {
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = 1
+	}
+
+	if opts.RequeueAfter == 0 {
+		opts.RequeueAfter = DefaultRequeueAfter
+	}
+
+	return &Limiter{
+		opts:   opts,
+		groups: make(map[string]*group),
+	}
+}
+
+// TryAcquire acquires a slot in the group without waiting, returning false
+// if the group is at capacity.
+func (l *Limiter) TryAcquire(key string) (ReleaseFunc, bool) {
+	g := l.get(key)
+
+	select {
+	case g.slots <- struct{}{}:
+		return l.releaseFunc(key, g), true
+	default:
+		l.put(key, g)
+		return nil, false
+	}
+}
+
+// Acquire acquires a slot in the group, waiting until one is available or
+// the context is cancelled.
+func (l *Limiter) Acquire(ctx context.Context, key string) (ReleaseFunc, error) {
+	g := l.get(key)
+
+	select {
+	case g.slots <- struct{}{}:
+		return l.releaseFunc(key, g), nil
+	case <-ctx.Done():
+		l.put(key, g)
+		return nil, fmt.Errorf("failed to acquire concurrency slot for %q: %w", key, ctx.Err())
+	}
+}
+
+// InFlight returns the number of slots currently held in the group.
+func (l *Limiter) InFlight(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if g, ok := l.groups[key]; ok {
+		return len(g.slots)
+	}
+
+	return 0
+}
+
+// Wrap wraps a reconciler so that reconciles are limited by the group
+// returned by keyFn. Rather than tying up a worker waiting, reconciles whose
+// group is at capacity are requeued.
+func (l *Limiter) Wrap(r reconcile.Reconciler, keyFn KeyFunc) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		key, err := keyFn(ctx, req)
+		if err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to get concurrency group: %w", err)
+		}
+
+		if key == "" {
+			return r.Reconcile(ctx, req)
+		}
+
+		release, ok := l.TryAcquire(key)
+		if !ok {
+			log.FromContext(ctx).V(1).Info("Concurrency group at capacity, requeuing", "group", key)
+
+			return reconcile.Result{RequeueAfter: l.opts.RequeueAfter}, nil
+		}
+		defer release()
+
+		return r.Reconcile(ctx, req)
+	})
+}
+
+func (l *Limiter) get(key string) *group {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	g, ok := l.groups[key]
+	if !ok {
+		limit := l.opts.MaxConcurrency
+		if override, ok := l.opts.Limits[key]; ok && override > 0 {
+			limit = override
+		}
+
+		g = &group{slots: make(chan struct{}, limit)}
+		l.groups[key] = g
+	}
+	g.refs++
+
+	return g
+}
+
+// put drops a reference to a group, idle groups are removed so that the
+// limiter doesn't grow without bound.
+func (l *Limiter) put(key string, g *group) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	g.refs--
+	if g.refs == 0 {
+		delete(l.groups, key)
+	}
+}
+
+func (l *Limiter) releaseFunc(key string, g *group) ReleaseFunc {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-g.slots
+			l.put(key, g)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package concurrency_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/concurrency"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestLimiter(t *testing.T) {
+	l := concurrency.New(concurrency.Options{
+		Limits: map[string]int{"large": 2},
+	})
+
+	release, ok := l.TryAcquire("db-1")
+	require.True(t, ok)
+
+	_, ok = l.TryAcquire("db-1")
+	assert.False(t, ok)
+
+	// Unrelated groups aren't limited.
+	releaseOther, ok := l.TryAcquire("db-2")
+	require.True(t, ok)
+	releaseOther()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := l.Acquire(ctx, "db-1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release()
+	assert.Equal(t, 0, l.InFlight("db-1"))
+
+	release, err = l.Acquire(context.Background(), "db-1")
+	require.NoError(t, err)
+	release()
+
+	for i := 0; i < 2; i++ {
+		_, ok := l.TryAcquire("large")
+		require.True(t, ok)
+	}
+	assert.Equal(t, 2, l.InFlight("large"))
+
+	_, ok = l.TryAcquire("large")
+	assert.False(t, ok)
+}
+
+func TestWrap(t *testing.T) {
+	l := concurrency.New(concurrency.Options{RequeueAfter: time.Minute})
+
+	var inFlight, maxInFlight atomic.Int32
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+
+	r := l.Wrap(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		if n > maxInFlight.Load() {
+			maxInFlight.Store(n)
+		}
+
+		if req.Name == "blocking" {
+			close(started)
+			<-unblock
+		}
+
+		return reconcile.Result{}, nil
+	}), func(ctx context.Context, req reconcile.Request) (string, error) {
+		if req.Name == "unlimited" {
+			return "", nil
+		}
+
+		return "db-1", nil
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "blocking"}})
+		assert.NoError(t, err)
+	}()
+
+	<-started
+
+	res, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "other"}})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, res.RequeueAfter)
+
+	res, err = r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "unlimited"}})
+	require.NoError(t, err)
+	assert.Zero(t, res)
+	assert.Equal(t, int32(2), maxInFlight.Load())
+
+	close(unblock)
+	wg.Wait()
+
+	res, err = r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "other"}})
+	require.NoError(t, err)
+	assert.Zero(t, res)
+}
@@ -0,0 +1,380 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package crds provides a way to install CRDs bundled with an operator at startup.
+package crds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// VersionAnnotation is the annotation used to record the version of the
+	// operator that last applied a CRD.
+	VersionAnnotation = "gpu-ninja.com/crd-version"
+)
+
+const (
+	DefaultTimeout      = time.Minute
+	DefaultPollInterval = time.Second
+)
+
+// Options configures how CRDs are ensured.
+type Options struct {
+	// Version is the semantic version of the bundled CRDs (typically the
+	// operator version). It is recorded on applied CRDs and used to prevent
+	// downgrades.
+	Version string
+	// AllowDowngrade allows CRDs applied by a newer version to be replaced
+	// by older bundled CRDs.
+	AllowDowngrade bool
+	// AllowBreakingChanges allows updates that stop serving, or remove,
+	// versions that are currently served or stored in the cluster.
+	AllowBreakingChanges bool
+	// Discovery, if set, is used to wait for the CRDs to be served by the API server.
+	Discovery discovery.DiscoveryInterface
+	// Timeout is how long to wait for the CRDs to be established.
+	Timeout time.Duration
+	// PollInterval is how often to check if the CRDs have been established.
+	PollInterval time.Duration
+}
+
+// EnsureFromFS applies all the CRDs found in YAML files in the given
+// filesystem (eg. an embed.FS) and waits for them to become established.
+func EnsureFromFS(ctx context.Context, c client.Client, fsys fs.FS, opts Options) error {
+	crds, err := ReadFromFS(fsys)
+	if err != nil {
+		return err
+	}
+
+	return Ensure(ctx, c, crds, opts)
+}
+
+// Ensure applies the given CRDs and waits for them to become established.
+func Ensure(ctx context.Context, c client.Client, crds []apiextensionsv1.CustomResourceDefinition, opts Options) error {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	if opts.PollInterval == 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+
+	for i := range crds {
+		if err := apply(ctx, c, &crds[i], opts); err != nil {
+			return fmt.Errorf("failed to apply crd %q: %w", crds[i].Name, err)
+		}
+	}
+
+	for i := range crds {
+		if err := waitForEstablished(ctx, c, &crds[i], opts); err != nil {
+			return fmt.Errorf("crd %q not established: %w", crds[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ReadFromFS reads all the CRDs from YAML files in the given filesystem.
+func ReadFromFS(fsys fs.FS) ([]apiextensionsv1.CustomResourceDefinition, error) {
+	var crds []apiextensionsv1.CustomResourceDefinition
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+
+		f, err := fsys.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %q: %w", path, err)
+		}
+		defer f.Close()
+
+		dec := yaml.NewYAMLOrJSONDecoder(f, 4096)
+		for {
+			var crd apiextensionsv1.CustomResourceDefinition
+			if err := dec.Decode(&crd); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				return fmt.Errorf("failed to decode %q: %w", path, err)
+			}
+
+			// Skip empty documents.
+			if crd.Kind == "" {
+				continue
+			}
+
+			if crd.Kind != "CustomResourceDefinition" {
+				return fmt.Errorf("unexpected kind %q in %q", crd.Kind, path)
+			}
+
+			crds = append(crds, crd)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crds: %w", err)
+	}
+
+	return crds, nil
+}
+
+func apply(ctx context.Context, c client.Client, crd *apiextensionsv1.CustomResourceDefinition, opts Options) error {
+	logger := log.FromContext(ctx).WithValues("crd", crd.Name)
+
+	if opts.Version != "" {
+		annotations := crd.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[VersionAnnotation] = opts.Version
+		crd.SetAnnotations(annotations)
+	}
+
+	var existing apiextensionsv1.CustomResourceDefinition
+	if err := c.Get(ctx, client.ObjectKeyFromObject(crd), &existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get crd: %w", err)
+		}
+
+		logger.Info("Creating CRD")
+
+		if err := c.Create(ctx, crd); err != nil {
+			return fmt.Errorf("failed to create crd: %w", err)
+		}
+
+		return nil
+	}
+
+	if !opts.AllowDowngrade {
+		newer, err := isNewer(existing.GetAnnotations()[VersionAnnotation], opts.Version)
+		if err != nil {
+			return err
+		}
+
+		if newer {
+			logger.Info("Skipping CRD as it was applied by a newer version",
+				"version", opts.Version, "existingVersion", existing.GetAnnotations()[VersionAnnotation])
+
+			return nil
+		}
+	}
+
+	if !opts.AllowBreakingChanges {
+		if err := checkBreakingChanges(&existing, crd); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Updating CRD")
+
+	updated := existing.DeepCopy()
+	updated.Labels = mergeMaps(existing.Labels, crd.Labels)
+	updated.Annotations = mergeMaps(existing.Annotations, crd.Annotations)
+	updated.Spec = crd.Spec
+
+	// Keep the CA bundle injected into the conversion webhook (eg. by
+	// certs.Injector), so that conversion isn't broken until it is re-injected.
+	if caBundle := conversionCABundle(&existing); len(caBundle) > 0 && len(conversionCABundle(updated)) == 0 &&
+		updated.Spec.Conversion != nil && updated.Spec.Conversion.Webhook != nil {
+		if updated.Spec.Conversion.Webhook.ClientConfig == nil {
+			updated.Spec.Conversion.Webhook.ClientConfig = &apiextensionsv1.WebhookClientConfig{}
+		}
+
+		updated.Spec.Conversion.Webhook.ClientConfig.CABundle = caBundle
+	}
+
+	if err := c.Update(ctx, updated); err != nil {
+		return fmt.Errorf("failed to update crd: %w", err)
+	}
+
+	return nil
+}
+
+// mergeMaps returns the existing labels (or annotations) overlaid with the
+// bundled ones, so that metadata added by others is preserved.
+func mergeMaps(existing, bundled map[string]string) map[string]string {
+	if len(existing) == 0 && len(bundled) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]string, len(existing)+len(bundled))
+	for k, v := range existing {
+		merged[k] = v
+	}
+
+	for k, v := range bundled {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+func conversionCABundle(crd *apiextensionsv1.CustomResourceDefinition) []byte {
+	conversion := crd.Spec.Conversion
+	if conversion == nil || conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+		return nil
+	}
+
+	return conversion.Webhook.ClientConfig.CABundle
+}
+
+// isNewer returns true if the existing version is newer than the bundled version.
+func isNewer(existingVersion, bundledVersion string) (bool, error) {
+	if existingVersion == "" || bundledVersion == "" {
+		return false, nil
+	}
+
+	existing, err := version.ParseSemantic(existingVersion)
+	if err != nil {
+		// If we can't parse it, we can't reason about it.
+		return false, nil
+	}
+
+	bundled, err := version.ParseSemantic(bundledVersion)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse version %q: %w", bundledVersion, err)
+	}
+
+	return bundled.LessThan(existing), nil
+}
+
+// checkBreakingChanges returns an error if the updated CRD stops serving,
+// or removes, a version that is currently served or stored.
+func checkBreakingChanges(existing, updated *apiextensionsv1.CustomResourceDefinition) error {
+	present := make(map[string]bool)
+	served := make(map[string]bool)
+	for _, v := range updated.Spec.Versions {
+		present[v.Name] = true
+		if v.Served {
+			served[v.Name] = true
+		}
+	}
+
+	// Objects may be stored in any version listed in status.storedVersions
+	// (as well as the current storage version), which can't be removed
+	// until they have been migrated.
+	storedVersions := append([]string(nil), existing.Status.StoredVersions...)
+	for _, v := range existing.Spec.Versions {
+		if v.Storage {
+			storedVersions = append(storedVersions, v.Name)
+		}
+	}
+
+	for _, storedVersion := range storedVersions {
+		if !present[storedVersion] {
+			return fmt.Errorf("breaking change: stored version %q would be removed", storedVersion)
+		}
+
+		if !served[storedVersion] {
+			return fmt.Errorf("breaking change: stored version %q would no longer be served", storedVersion)
+		}
+	}
+
+	for _, v := range existing.Spec.Versions {
+		if v.Served && !served[v.Name] {
+			return fmt.Errorf("breaking change: version %q would no longer be served", v.Name)
+		}
+	}
+
+	return nil
+}
+
+func waitForEstablished(ctx context.Context, c client.Client, crd *apiextensionsv1.CustomResourceDefinition, opts Options) error {
+	return wait.PollUntilContextTimeout(ctx, opts.PollInterval, opts.Timeout, true, func(ctx context.Context) (bool, error) {
+		var current apiextensionsv1.CustomResourceDefinition
+		if err := c.Get(ctx, client.ObjectKeyFromObject(crd), &current); err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		if !isConditionTrue(&current, apiextensionsv1.Established) || !isConditionTrue(&current, apiextensionsv1.NamesAccepted) {
+			return false, nil
+		}
+
+		if opts.Discovery == nil {
+			return true, nil
+		}
+
+		return isDiscoverable(opts.Discovery, &current), nil
+	})
+}
+
+func isConditionTrue(crd *apiextensionsv1.CustomResourceDefinition, conditionType apiextensionsv1.CustomResourceDefinitionConditionType) bool {
+	for _, condition := range crd.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func isDiscoverable(d discovery.DiscoveryInterface, crd *apiextensionsv1.CustomResourceDefinition) bool {
+	for _, v := range crd.Spec.Versions {
+		if !v.Served {
+			continue
+		}
+
+		resources, err := d.ServerResourcesForGroupVersion(crd.Spec.Group + "/" + v.Name)
+		if err != nil {
+			return false
+		}
+
+		var found bool
+		for _, r := range resources.APIResources {
+			if r.Name == crd.Spec.Names.Plural {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,247 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crds_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/crds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const crdYAML = `---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: myobjects.example.com
+spec:
+  group: example.com
+  names:
+    kind: MyObject
+    listKind: MyObjectList
+    plural: myobjects
+    singular: myobject
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+---
+`
+
+func TestEnsureFromFS(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, apiextensionsv1.AddToScheme(scheme))
+
+	fsys := fstest.MapFS{
+		"crds/example.com_myobjects.yaml": &fstest.MapFile{Data: []byte(crdYAML)},
+		"crds/README.md":                  &fstest.MapFile{Data: []byte("# CRDs")},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Create", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+		d := &discoveryfake.FakeDiscovery{Fake: &clienttesting.Fake{}}
+		d.Resources = []*metav1.APIResourceList{{
+			GroupVersion: "example.com/v1",
+			APIResources: []metav1.APIResource{{Name: "myobjects"}},
+		}}
+
+		go markEstablished(ctx, t, c, "myobjects.example.com")
+
+		err := crds.EnsureFromFS(ctx, c, fsys, crds.Options{
+			Version:      "v1.0.0",
+			Discovery:    d,
+			Timeout:      5 * time.Second,
+			PollInterval: 10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		var crd apiextensionsv1.CustomResourceDefinition
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "myobjects.example.com"}, &crd))
+
+		assert.Equal(t, "v1.0.0", crd.Annotations[crds.VersionAnnotation])
+	})
+
+	t.Run("Never Downgrade", func(t *testing.T) {
+		existing := established("myobjects.example.com")
+		existing.Annotations = map[string]string{crds.VersionAnnotation: "v2.0.0"}
+		existing.Spec.Versions = append(existing.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{
+			Name:   "v2",
+			Served: true,
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+
+		err := crds.EnsureFromFS(ctx, c, fsys, crds.Options{
+			Version: "v1.0.0",
+			Timeout: time.Second,
+		})
+		require.NoError(t, err)
+
+		var crd apiextensionsv1.CustomResourceDefinition
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "myobjects.example.com"}, &crd))
+
+		assert.Equal(t, "v2.0.0", crd.Annotations[crds.VersionAnnotation])
+		assert.Len(t, crd.Spec.Versions, 2)
+	})
+
+	t.Run("Breaking Change", func(t *testing.T) {
+		existing := established("myobjects.example.com")
+		existing.Spec.Versions = append(existing.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{
+			Name:   "v1alpha1",
+			Served: true,
+		})
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+
+		err := crds.EnsureFromFS(ctx, c, fsys, crds.Options{
+			Version: "v1.0.0",
+			Timeout: time.Second,
+		})
+		require.ErrorContains(t, err, "breaking change")
+	})
+
+	t.Run("Stored Version Removed", func(t *testing.T) {
+		existing := established("myobjects.example.com")
+		existing.Spec.Versions = append(existing.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{
+			Name: "v1alpha1",
+		})
+		existing.Status.StoredVersions = []string{"v1alpha1", "v1"}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+
+		err := crds.EnsureFromFS(ctx, c, fsys, crds.Options{
+			Version: "v1.0.0",
+			Timeout: time.Second,
+		})
+		require.ErrorContains(t, err, `stored version "v1alpha1" would be removed`)
+	})
+
+	t.Run("Update Preserves Injected Metadata", func(t *testing.T) {
+		existing := established("myobjects.example.com")
+		existing.Labels = map[string]string{"team": "platform"}
+		existing.Annotations = map[string]string{"cert-manager.io/inject-ca-from": "default/webhook"}
+		existing.Spec.Conversion = &apiextensionsv1.CustomResourceConversion{
+			Strategy: apiextensionsv1.WebhookConverter,
+			Webhook: &apiextensionsv1.WebhookConversion{
+				ClientConfig: &apiextensionsv1.WebhookClientConfig{
+					CABundle: []byte("ca"),
+				},
+				ConversionReviewVersions: []string{"v1"},
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+
+		fsys := fstest.MapFS{"crds/example.com_myobjects.yaml": &fstest.MapFile{Data: []byte(`apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: myobjects.example.com
+  labels:
+    app.kubernetes.io/name: example
+spec:
+  group: example.com
+  names:
+    kind: MyObject
+    listKind: MyObjectList
+    plural: myobjects
+    singular: myobject
+  scope: Namespaced
+  conversion:
+    strategy: Webhook
+    webhook:
+      clientConfig:
+        service:
+          name: webhook
+          namespace: default
+      conversionReviewVersions: ["v1"]
+  versions:
+  - name: v1
+    served: true
+    storage: true
+`)}}
+
+		err := crds.EnsureFromFS(ctx, c, fsys, crds.Options{
+			Version: "v1.0.0",
+			Timeout: time.Second,
+		})
+		require.NoError(t, err)
+
+		var crd apiextensionsv1.CustomResourceDefinition
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "myobjects.example.com"}, &crd))
+
+		assert.Equal(t, "platform", crd.Labels["team"])
+		assert.Equal(t, "example", crd.Labels["app.kubernetes.io/name"])
+		assert.Equal(t, "default/webhook", crd.Annotations["cert-manager.io/inject-ca-from"])
+		assert.Equal(t, "v1.0.0", crd.Annotations[crds.VersionAnnotation])
+		require.NotNil(t, crd.Spec.Conversion.Webhook.ClientConfig.Service)
+		assert.Equal(t, []byte("ca"), crd.Spec.Conversion.Webhook.ClientConfig.CABundle)
+	})
+}
+
+func established(name string) *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    "v1",
+				Served:  true,
+				Storage: true,
+			}},
+		},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+				{Type: apiextensionsv1.NamesAccepted, Status: apiextensionsv1.ConditionTrue},
+			},
+		},
+	}
+}
+
+// markEstablished simulates the API server establishing the CRD.
+func markEstablished(ctx context.Context, t *testing.T, c client.Client, name string) {
+	for {
+		var crd apiextensionsv1.CustomResourceDefinition
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, &crd); err == nil {
+			crd.Status = established(name).Status
+			if err := c.Status().Update(ctx, &crd); err != nil {
+				t.Logf("failed to mark crd as established: %v", err)
+			}
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,384 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package diff produces compact, path based, and redacted diffs between
+// objects (eg. live vs. desired, or old vs. new in an admission webhook),
+// suitable for logging, drift reports, and admission denial messages.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Redacted replaces the values of redacted paths.
+const Redacted = "<redacted>"
+
+// DefaultIgnorePaths are server populated metadata fields that are ignored by default.
+var DefaultIgnorePaths = []string{
+	"metadata.managedFields",
+	"metadata.resourceVersion",
+	"metadata.generation",
+	"metadata.creationTimestamp",
+	"metadata.uid",
+	"metadata.selfLink",
+}
+
+// Operation is the kind of change.
+type Operation string
+
+const (
+	OperationAdded   Operation = "+"
+	OperationRemoved Operation = "-"
+	OperationChanged Operation = "~"
+)
+
+// Change is a single changed value.
+type Change struct {
+	// Path is the path of the value (eg. "spec.template.spec.containers[name=app].image").
+	Path string
+	// Operation is the kind of change.
+	Operation Operation
+	// Old is the old value (nil if added).
+	Old any
+	// New is the new value (nil if removed).
+	New any
+}
+
+// Diff is a list of changes, ordered by path.
+type Diff []Change
+
+// Empty returns true if there are no changes.
+func (d Diff) Empty() bool {
+	return len(d) == 0
+}
+
+// Paths returns the paths of the changed values.
+func (d Diff) Paths() []string {
+	paths := make([]string, 0, len(d))
+	for _, change := range d {
+		paths = append(paths, change.Path)
+	}
+
+	return paths
+}
+
+// String returns a compact, one change per line, representation of the diff.
+func (d Diff) String() string {
+	return d.Format(DefaultMaxValueLength)
+}
+
+// Format returns a one change per line representation of the diff, with
+// values truncated to the given length (or not truncated if zero).
+func (d Diff) Format(maxValueLength int) string {
+	lines := make([]string, 0, len(d))
+	for _, change := range d {
+		switch change.Operation {
+		case OperationAdded:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", change.Path, formatValue(change.New, maxValueLength)))
+		case OperationRemoved:
+			lines = append(lines, fmt.Sprintf("- %s: %s", change.Path, formatValue(change.Old, maxValueLength)))
+		default:
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", change.Path,
+				formatValue(change.Old, maxValueLength), formatValue(change.New, maxValueLength)))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// DefaultMaxValueLength is the default maximum length of formatted values.
+const DefaultMaxValueLength = 80
+
+// Option configures a diff.
+type Option func(*options)
+
+type options struct {
+	ignorePaths []string
+	redactPaths []string
+}
+
+// WithIgnorePaths ignores changes at (or beneath) the given paths, in
+// addition to DefaultIgnorePaths.
+func WithIgnorePaths(paths ...string) Option {
+	return func(o *options) {
+		o.ignorePaths = append(o.ignorePaths, paths...)
+	}
+}
+
+// WithRedactPaths redacts the values at (or beneath) the given paths. The
+// data (and last applied configuration) of Secrets is always redacted.
+func WithRedactPaths(paths ...string) Option {
+	return func(o *options) {
+		o.redactPaths = append(o.redactPaths, paths...)
+	}
+}
+
+// Objects returns the diff between two objects (either may be nil).
+func Objects(oldObj, newObj runtime.Object, opts ...Option) (Diff, error) {
+	oldMap, err := toMap(oldObj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert old object: %w", err)
+	}
+
+	newMap, err := toMap(newObj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert new object: %w", err)
+	}
+
+	if isSecret(oldObj, oldMap) || isSecret(newObj, newMap) {
+		// The last applied configuration holds the same values as the data.
+		opts = append(opts, WithRedactPaths("data", "stringData",
+			joinKey("metadata.annotations", corev1.LastAppliedConfigAnnotation)))
+	}
+
+	return Maps(oldMap, newMap, opts...), nil
+}
+
+// Maps returns the diff between two unstructured objects.
+func Maps(oldMap, newMap map[string]any, opts ...Option) Diff {
+	o := &options{
+		ignorePaths: append([]string(nil), DefaultIgnorePaths...),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var d Diff
+	compare(o, "", toAny(oldMap), toAny(newMap), &d)
+
+	sort.SliceStable(d, func(i, j int) bool {
+		return d[i].Path < d[j].Path
+	})
+
+	return d
+}
+
+func compare(o *options, path string, oldValue, newValue any, d *Diff) {
+	if path != "" && matchesAny(path, o.ignorePaths) {
+		return
+	}
+
+	oldMap, oldIsMap := oldValue.(map[string]any)
+	newMap, newIsMap := newValue.(map[string]any)
+	if oldIsMap && newIsMap {
+		keys := make(map[string]bool)
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+
+		for key := range keys {
+			oldChild, inOld := oldMap[key]
+			newChild, inNew := newMap[key]
+			childPath := joinKey(path, key)
+
+			_, oldChildIsMap := oldChild.(map[string]any)
+			_, newChildIsMap := newChild.(map[string]any)
+
+			switch {
+			case inOld && inNew:
+				compare(o, childPath, oldChild, newChild, d)
+			// Added and removed maps are expanded into their leaf values.
+			case inNew && newChildIsMap:
+				compare(o, childPath, map[string]any{}, newChild, d)
+			case inOld && oldChildIsMap:
+				compare(o, childPath, oldChild, map[string]any{}, d)
+			case inNew:
+				add(o, d, Change{Path: childPath, Operation: OperationAdded, New: newChild})
+			default:
+				add(o, d, Change{Path: childPath, Operation: OperationRemoved, Old: oldChild})
+			}
+		}
+
+		return
+	}
+
+	oldList, oldIsList := oldValue.([]any)
+	newList, newIsList := newValue.([]any)
+	if oldIsList && newIsList {
+		compareLists(o, path, oldList, newList, d)
+		return
+	}
+
+	if !reflect.DeepEqual(oldValue, newValue) {
+		add(o, d, Change{Path: path, Operation: OperationChanged, Old: oldValue, New: newValue})
+	}
+}
+
+// compareLists compares lists by the "name" of their items (eg. containers,
+// ports, env vars), or if the items aren't named, by index.
+func compareLists(o *options, path string, oldList, newList []any, d *Diff) {
+	oldNamed, oldOK := byName(oldList)
+	newNamed, newOK := byName(newList)
+	if oldOK && newOK {
+		for name, oldItem := range oldNamed {
+			childPath := fmt.Sprintf("%s[name=%s]", path, name)
+			if newItem, ok := newNamed[name]; ok {
+				compare(o, childPath, oldItem, newItem, d)
+			} else {
+				add(o, d, Change{Path: childPath, Operation: OperationRemoved, Old: oldItem})
+			}
+		}
+
+		for name, newItem := range newNamed {
+			if _, ok := oldNamed[name]; !ok {
+				add(o, d, Change{Path: fmt.Sprintf("%s[name=%s]", path, name), Operation: OperationAdded, New: newItem})
+			}
+		}
+
+		return
+	}
+
+	for i := 0; i < len(oldList) || i < len(newList); i++ {
+		childPath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i < len(oldList) && i < len(newList):
+			compare(o, childPath, oldList[i], newList[i], d)
+		case i < len(newList):
+			add(o, d, Change{Path: childPath, Operation: OperationAdded, New: newList[i]})
+		default:
+			add(o, d, Change{Path: childPath, Operation: OperationRemoved, Old: oldList[i]})
+		}
+	}
+}
+
+func add(o *options, d *Diff, change Change) {
+	if matchesAny(change.Path, o.ignorePaths) {
+		return
+	}
+
+	if matchesAny(change.Path, o.redactPaths) {
+		if change.Old != nil {
+			change.Old = Redacted
+		}
+		if change.New != nil {
+			change.New = Redacted
+		}
+	}
+
+	*d = append(*d, change)
+}
+
+// byName indexes the items of a list by their unique "name" field.
+func byName(list []any) (map[string]any, bool) {
+	if len(list) == 0 {
+		return map[string]any{}, true
+	}
+
+	named := make(map[string]any, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		name, ok := m["name"].(string)
+		if !ok {
+			return nil, false
+		}
+
+		if _, duplicate := named[name]; duplicate {
+			return nil, false
+		}
+
+		named[name] = item
+	}
+
+	return named, true
+}
+
+// matchesAny returns true if the path is, or is beneath, any of the given paths.
+func matchesAny(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix {
+			return true
+		}
+
+		if strings.HasPrefix(path, prefix) {
+			if next := path[len(prefix)]; next == '.' || next == '[' {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func joinKey(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+	}
+
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func formatValue(value any, maxLength int) string {
+	if s, ok := value.(string); ok && s == Redacted {
+		return s
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	formatted := string(data)
+	if maxLength > 0 && len(formatted) > maxLength {
+		formatted = formatted[:maxLength] + "..."
+	}
+
+	return formatted
+}
+
+func toMap(obj runtime.Object) (map[string]any, error) {
+	if obj == nil || reflect.ValueOf(obj).IsNil() {
+		return nil, nil
+	}
+
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// toAny avoids comparing a nil map with an empty map as a change of type.
+func toAny(m map[string]any) any {
+	if m == nil {
+		return map[string]any{}
+	}
+
+	return m
+}
+
+func isSecret(obj runtime.Object, m map[string]any) bool {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return true
+	}
+
+	return m != nil && m["kind"] == "Secret" && m["apiVersion"] == "v1"
+}
@@ -0,0 +1,127 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/diff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestObjects(t *testing.T) {
+	live := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"app.kubernetes.io/name": "web", "tier": "frontend"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(int32(1)),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "web", Image: "web:v1", Args: []string{"--port=80"}},
+						{Name: "sidecar", Image: "proxy:v1"},
+					},
+				},
+			},
+		},
+	}
+
+	desired := live.DeepCopy()
+	desired.ResourceVersion = "2"
+	desired.Labels["app.kubernetes.io/name"] = "website"
+	delete(desired.Labels, "tier")
+	desired.Spec.Replicas = ptr.To(int32(3))
+	desired.Spec.Template.Spec.Containers = []corev1.Container{
+		{Name: "sidecar", Image: "proxy:v1"},
+		{Name: "web", Image: "web:v2", Args: []string{"--port=80", "--verbose"}},
+	}
+
+	d, err := diff.Objects(live, desired)
+	require.NoError(t, err)
+
+	assert.Equal(t, `- metadata.labels.tier: "frontend"
+~ metadata.labels["app.kubernetes.io/name"]: "web" -> "website"
+~ spec.replicas: 1 -> 3
++ spec.template.spec.containers[name=web].args[1]: "--verbose"
+~ spec.template.spec.containers[name=web].image: "web:v1" -> "web:v2"`, d.String())
+
+	t.Run("Ignore", func(t *testing.T) {
+		d, err := diff.Objects(live, desired, diff.WithIgnorePaths("metadata.labels", "spec.template"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"spec.replicas"}, d.Paths())
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		d, err := diff.Objects(live, live.DeepCopy())
+		require.NoError(t, err)
+
+		assert.True(t, d.Empty())
+	})
+
+	t.Run("Redact", func(t *testing.T) {
+		old := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds"},
+			Data:       map[string][]byte{"password": []byte("hunter2")},
+		}
+
+		updated := old.DeepCopy()
+		updated.Data["password"] = []byte("correct-horse")
+		updated.Data["tls.key"] = []byte("secret")
+
+		d, err := diff.Objects(old, updated)
+		require.NoError(t, err)
+
+		assert.Equal(t, `~ data.password: <redacted> -> <redacted>
++ data["tls.key"]: <redacted>`, d.String())
+
+		// The last applied configuration holds the same values.
+		applied := updated.DeepCopy()
+		applied.Annotations = map[string]string{
+			corev1.LastAppliedConfigAnnotation: `{"data":{"password":"c2VjcmV0"}}`,
+		}
+
+		d, err = diff.Objects(updated, applied)
+		require.NoError(t, err)
+
+		assert.Equal(t, `+ metadata.annotations["kubectl.kubernetes.io/last-applied-configuration"]: <redacted>`, d.String())
+
+		d, err = diff.Objects(live, desired, diff.WithRedactPaths("spec.template.spec.containers"))
+		require.NoError(t, err)
+		assert.Contains(t, d.String(), "~ spec.template.spec.containers[name=web].image: <redacted> -> <redacted>")
+	})
+
+	t.Run("Created", func(t *testing.T) {
+		d, err := diff.Objects(nil, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config"},
+			Data:       map[string]string{"key": "a very long value that should be truncated when formatted"},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, `+ data.key: "a very long value that should...
++ metadata.name: "config"`, d.Format(30))
+	})
+}
@@ -0,0 +1,343 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package drain provides helpers to cordon, uncordon, and drain nodes (eg.
+// before driver or firmware updates).
+package drain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// NodeNameField is the pod field used to select the pods on a node. When
+	// using a cached client, it must be indexed (see IndexPodsByNodeName).
+	NodeNameField = "spec.nodeName"
+	// MirrorPodAnnotation marks static (mirror) pods, which can't be evicted.
+	MirrorPodAnnotation = corev1.MirrorPodAnnotationKey
+)
+
+var (
+	// ErrTimeout is returned when a node is not drained within the timeout.
+	ErrTimeout = errors.New("timed out draining node")
+)
+
+// BlockedError is returned when pods on a node can't be safely evicted.
+type BlockedError struct {
+	// Pods maps "namespace/name" to the reason each pod can't be evicted.
+	Pods map[string]string
+}
+
+func (e *BlockedError) Error() string {
+	keys := make([]string, 0, len(e.Pods))
+	for key := range e.Pods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	details := make([]string, 0, len(keys))
+	for _, key := range keys {
+		details = append(details, fmt.Sprintf("%s (%s)", key, e.Pods[key]))
+	}
+
+	return fmt.Sprintf("cannot evict pods: %s", strings.Join(details, ", "))
+}
+
+// Action is what a pod filter decides to do with a pod.
+type Action int
+
+const (
+	// ActionEvict evicts the pod.
+	ActionEvict Action = iota
+	// ActionSkip leaves the pod on the node.
+	ActionSkip
+	// ActionBlock prevents the drain (with a reason).
+	ActionBlock
+)
+
+// PodFilter decides what to do with a pod on a node being drained. Filters
+// are evaluated in order, and the first filter to return an action other
+// than ActionEvict wins.
+type PodFilter func(pod *corev1.Pod) (Action, string)
+
+// Options configures a drain.
+type Options struct {
+	// Filters are evaluated (before the default filters) for each pod.
+	Filters []PodFilter
+	// IgnoreDaemonSets leaves DaemonSet managed pods on the node (they would
+	// be immediately recreated). If false, DaemonSet pods block the drain.
+	IgnoreDaemonSets bool
+	// DeleteEmptyDirData allows evicting pods with emptyDir volumes (whose data is lost).
+	DeleteEmptyDirData bool
+	// Force allows evicting pods that are not managed by a controller (and
+	// so won't be recreated).
+	Force bool
+	// GracePeriodSeconds overrides the termination grace period of evicted pods.
+	GracePeriodSeconds *int64
+	// Timeout is the maximum time to wait for the node to drain. Defaults to 5 minutes.
+	Timeout time.Duration
+	// PollInterval is the interval between eviction retries (eg. when blocked by
+	// a PodDisruptionBudget), and pod deletion checks. Defaults to 5 seconds.
+	PollInterval time.Duration
+}
+
+// Cordon marks the node as unschedulable.
+func Cordon(ctx context.Context, c client.Client, node *corev1.Node) error {
+	return setUnschedulable(ctx, c, node, true)
+}
+
+// Uncordon marks the node as schedulable.
+func Uncordon(ctx context.Context, c client.Client, node *corev1.Node) error {
+	return setUnschedulable(ctx, c, node, false)
+}
+
+func setUnschedulable(ctx context.Context, c client.Client, node *corev1.Node, unschedulable bool) error {
+	if node.Spec.Unschedulable == unschedulable {
+		return nil
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Spec.Unschedulable = unschedulable
+
+	if err := c.Patch(ctx, node, patch); err != nil {
+		return fmt.Errorf("failed to patch node: %w", err)
+	}
+
+	return nil
+}
+
+// Drain cordons the node and evicts its pods (honoring PodDisruptionBudgets),
+// waiting until the evicted pods have terminated. If any pod can't be
+// safely evicted a *BlockedError is returned before anything is evicted.
+func Drain(ctx context.Context, c client.Client, node *corev1.Node, opts Options) error {
+	logger := log.FromContext(ctx).WithValues("node", node.Name)
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+
+	if err := Cordon(ctx, c, node); err != nil {
+		return err
+	}
+
+	pods, err := podsToEvict(ctx, c, node.Name, &opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	evicted := make(map[types.NamespacedName]bool)
+	err = wait.PollUntilContextCancel(ctx, opts.PollInterval, true, func(ctx context.Context) (bool, error) {
+		done := true
+		for i := range pods {
+			pod := &pods[i]
+
+			gone, err := isGone(ctx, c, pod)
+			if err != nil {
+				return false, err
+			}
+
+			if gone {
+				continue
+			}
+			done = false
+
+			key := client.ObjectKeyFromObject(pod)
+			if evicted[key] {
+				continue
+			}
+
+			if err := evict(ctx, c, pod, &opts); err != nil {
+				// Blocked by a disruption budget, retry later.
+				if apierrors.IsTooManyRequests(err) {
+					logger.Info("Eviction blocked by disruption budget, retrying", "pod", key.String())
+					continue
+				}
+
+				return false, err
+			}
+
+			logger.Info("Evicted pod", "pod", key.String())
+			evicted[key] = true
+		}
+
+		return done, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %s", ErrTimeout, node.Name)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// IndexPodsByNodeName returns an indexer function for pods by node name,
+// for use with a cache (eg. mgr.GetFieldIndexer().IndexField(ctx, &corev1.Pod{}, drain.NodeNameField, drain.IndexPodsByNodeName)).
+func IndexPodsByNodeName(obj client.Object) []string {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil
+	}
+
+	return []string{pod.Spec.NodeName}
+}
+
+func podsToEvict(ctx context.Context, c client.Client, nodeName string, opts *Options) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.MatchingFields{NodeNameField: nodeName}); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	filters := append(append([]PodFilter{}, opts.Filters...), defaultFilters(opts)...)
+
+	blocked := make(map[string]string)
+	var toEvict []corev1.Pod
+	for _, pod := range pods.Items {
+		action, reason := ActionEvict, ""
+		for _, filter := range filters {
+			if action, reason = filter(&pod); action != ActionEvict {
+				break
+			}
+		}
+
+		switch action {
+		case ActionEvict:
+			toEvict = append(toEvict, pod)
+		case ActionBlock:
+			blocked[client.ObjectKeyFromObject(&pod).String()] = reason
+		}
+	}
+
+	if len(blocked) > 0 {
+		return nil, &BlockedError{Pods: blocked}
+	}
+
+	return toEvict, nil
+}
+
+func defaultFilters(opts *Options) []PodFilter {
+	return []PodFilter{
+		func(pod *corev1.Pod) (Action, string) {
+			if _, ok := pod.Annotations[MirrorPodAnnotation]; ok {
+				return ActionSkip, "mirror pod"
+			}
+
+			return ActionEvict, ""
+		},
+		func(pod *corev1.Pod) (Action, string) {
+			if isFinished(pod) {
+				return ActionEvict, ""
+			}
+
+			controller := metav1.GetControllerOf(pod)
+			switch {
+			case controller == nil && !opts.Force:
+				return ActionBlock, "not managed by a controller"
+			case controller != nil && controller.Kind == "DaemonSet":
+				if opts.IgnoreDaemonSets {
+					return ActionSkip, "managed by a daemonset"
+				}
+
+				return ActionBlock, "managed by a daemonset"
+			}
+
+			return ActionEvict, ""
+		},
+		func(pod *corev1.Pod) (Action, string) {
+			// Finished pods have no data in use.
+			if opts.DeleteEmptyDirData || isFinished(pod) {
+				return ActionEvict, ""
+			}
+
+			for _, volume := range pod.Spec.Volumes {
+				if volume.EmptyDir != nil {
+					return ActionBlock, "has local (emptyDir) storage"
+				}
+			}
+
+			return ActionEvict, ""
+		},
+	}
+}
+
+func isFinished(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+func evict(ctx context.Context, c client.Client, pod *corev1.Pod, opts *Options) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+		},
+	}
+
+	if opts.GracePeriodSeconds != nil {
+		eviction.DeleteOptions = &metav1.DeleteOptions{GracePeriodSeconds: opts.GracePeriodSeconds}
+	}
+
+	if err := c.SubResource("eviction").Create(ctx, pod, eviction); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		if apierrors.IsTooManyRequests(err) {
+			return err
+		}
+
+		return fmt.Errorf("failed to evict pod %s: %w", client.ObjectKeyFromObject(pod), err)
+	}
+
+	return nil
+}
+
+// isGone returns true if the pod has been deleted (or replaced by a pod with the same name).
+func isGone(ctx context.Context, c client.Client, pod *corev1.Pod) (bool, error) {
+	var current corev1.Pod
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pod), &current); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+
+		return false, fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	return current.UID != pod.UID, nil
+}
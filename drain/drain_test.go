@@ -0,0 +1,135 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package drain_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/drain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-0"}}
+
+	controlledBy := func(kind string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       kind,
+			Name:       "owner",
+			UID:        "1234",
+			Controller: ptr.To(true),
+		}}
+	}
+
+	pod := func(name string, ownerRefs []metav1.OwnerReference, volumes ...corev1.Volume) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, OwnerReferences: ownerRefs},
+			Spec:       corev1.PodSpec{NodeName: "gpu-0", Volumes: volumes},
+		}
+	}
+
+	scratch := corev1.Volume{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+
+	// Finished pods never block the drain.
+	completed := pod("completed", nil, scratch)
+	completed.Status.Phase = corev1.PodSucceeded
+
+	c := fake.NewClientBuilder().
+		WithObjects(
+			node,
+			pod("web", controlledBy("ReplicaSet")),
+			pod("cache", controlledBy("ReplicaSet"), scratch),
+			pod("agent", controlledBy("DaemonSet")),
+			pod("debug", nil),
+			completed,
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other"},
+				Spec:       corev1.PodSpec{NodeName: "gpu-1"},
+			},
+		).
+		WithIndex(&corev1.Pod{}, drain.NodeNameField, drain.IndexPodsByNodeName).
+		Build()
+
+	opts := drain.Options{
+		Timeout:      time.Second,
+		PollInterval: 10 * time.Millisecond,
+	}
+
+	t.Run("Blocked", func(t *testing.T) {
+		err := drain.Drain(ctx, c, node, opts)
+		require.Error(t, err)
+
+		var blockedErr *drain.BlockedError
+		require.True(t, errors.As(err, &blockedErr))
+		assert.Equal(t, map[string]string{
+			"default/cache": "has local (emptyDir) storage",
+			"default/agent": "managed by a daemonset",
+			"default/debug": "not managed by a controller",
+		}, blockedErr.Pods)
+
+		// The node is still cordoned.
+		assert.True(t, node.Spec.Unschedulable)
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		opts := opts
+		opts.IgnoreDaemonSets = true
+		opts.DeleteEmptyDirData = true
+		opts.Filters = []drain.PodFilter{
+			func(pod *corev1.Pod) (drain.Action, string) {
+				if pod.Name == "debug" {
+					return drain.ActionSkip, "debug pod"
+				}
+
+				return drain.ActionEvict, ""
+			},
+		}
+
+		require.NoError(t, drain.Drain(ctx, c, node, opts))
+
+		var pods corev1.PodList
+		require.NoError(t, c.List(ctx, &pods))
+
+		var remaining []string
+		for _, pod := range pods.Items {
+			remaining = append(remaining, pod.Name)
+		}
+
+		assert.ElementsMatch(t, []string{"agent", "debug", "other"}, remaining)
+	})
+
+	t.Run("Uncordon", func(t *testing.T) {
+		require.NoError(t, drain.Uncordon(ctx, c, node))
+
+		var updated corev1.Node
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(node), &updated))
+		assert.False(t, updated.Spec.Unschedulable)
+	})
+}
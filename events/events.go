@@ -0,0 +1,205 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package events provides a consistent way to record Kubernetes events.
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reason is the machine readable reason for an event.
+type Reason string
+
+// Common reasons used across operators.
+const (
+	ReasonCreatedChild      Reason = "CreatedChild"
+	ReasonUpdatedChild      Reason = "UpdatedChild"
+	ReasonDeletedChild      Reason = "DeletedChild"
+	ReasonCreateFailed      Reason = "CreateFailed"
+	ReasonUpdateFailed      Reason = "UpdateFailed"
+	ReasonDeleteFailed      Reason = "DeleteFailed"
+	ReasonReferenceNotFound Reason = "ReferenceNotFound"
+	ReasonReconcileFailed   Reason = "ReconcileFailed"
+	ReasonReconcilePanicked Reason = "ReconcilePanicked"
+	ReasonReconcileTimedOut Reason = "ReconcileTimedOut"
+	ReasonStaleStatus       Reason = "StaleStatus"
+	ReasonInvalidExpiry     Reason = "InvalidExpiry"
+)
+
+const (
+	// ChildAnnotation is the event annotation used to identify the child
+	// object an event recorded against a parent relates to.
+	ChildAnnotation = "gpu-ninja.com/child"
+	// DefaultDedupInterval is the default interval during which identical
+	// events will be suppressed.
+	DefaultDedupInterval = 5 * time.Minute
+	// maxTrackedEvents is the number of events to track before expired
+	// entries are pruned.
+	maxTrackedEvents = 1024
+)
+
+// Recorder wraps a record.EventRecorder and suppresses duplicate events.
+type Recorder struct {
+	recorder      record.EventRecorder
+	clock         clock.PassiveClock
+	dedupInterval time.Duration
+	mu            sync.Mutex
+	lastRecorded  map[string]time.Time
+}
+
+// Option configures a Recorder.
+type Option func(*Recorder)
+
+// WithDedupInterval sets the interval during which identical events will be
+// suppressed. An interval of zero disables deduplication.
+func WithDedupInterval(interval time.Duration) Option {
+	return func(r *Recorder) {
+		r.dedupInterval = interval
+	}
+}
+
+// WithClock sets the clock used for deduplication (useful for testing).
+func WithClock(clock clock.PassiveClock) Option {
+	return func(r *Recorder) {
+		r.clock = clock
+	}
+}
+
+// NewRecorder returns a new Recorder wrapping the given event recorder.
+func NewRecorder(recorder record.EventRecorder, opts ...Option) *Recorder {
+	r := &Recorder{
+		recorder:      recorder,
+		clock:         clock.RealClock{},
+		dedupInterval: DefaultDedupInterval,
+		lastRecorded:  make(map[string]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Normal records a normal event against the given object.
+func (r *Recorder) Normal(obj client.Object, reason Reason, message string) {
+	r.record(obj, objectKey(obj), nil, corev1.EventTypeNormal, reason, message)
+}
+
+// Normalf records a normal event against the given object using a format string.
+func (r *Recorder) Normalf(obj client.Object, reason Reason, format string, args ...any) {
+	r.record(obj, objectKey(obj), nil, corev1.EventTypeNormal, reason, fmt.Sprintf(format, args...))
+}
+
+// Warning records a warning event against the given object.
+func (r *Recorder) Warning(obj client.Object, reason Reason, message string) {
+	r.record(obj, objectKey(obj), nil, corev1.EventTypeWarning, reason, message)
+}
+
+// Warningf records a warning event against the given object using a format string.
+func (r *Recorder) Warningf(obj client.Object, reason Reason, format string, args ...any) {
+	r.record(obj, objectKey(obj), nil, corev1.EventTypeWarning, reason, fmt.Sprintf(format, args...))
+}
+
+// ChildNormalf records a normal event against the controller of the given
+// child object. If the child has no controller the event is recorded against
+// the child itself.
+func (r *Recorder) ChildNormalf(child client.Object, reason Reason, format string, args ...any) {
+	r.recordForChild(child, corev1.EventTypeNormal, reason, fmt.Sprintf(format, args...))
+}
+
+// ChildWarningf records a warning event against the controller of the given
+// child object. If the child has no controller the event is recorded against
+// the child itself.
+func (r *Recorder) ChildWarningf(child client.Object, reason Reason, format string, args ...any) {
+	r.recordForChild(child, corev1.EventTypeWarning, reason, fmt.Sprintf(format, args...))
+}
+
+func (r *Recorder) recordForChild(child client.Object, eventType string, reason Reason, message string) {
+	ownerRef := metav1.GetControllerOfNoCopy(child)
+	if ownerRef == nil {
+		r.record(child, objectKey(child), nil, eventType, reason, message)
+		return
+	}
+
+	// A namespaced child can only be owned by an object in the same namespace
+	// (or a cluster scoped object, in which case the namespace is ignored).
+	parentRef := &corev1.ObjectReference{
+		APIVersion: ownerRef.APIVersion,
+		Kind:       ownerRef.Kind,
+		Name:       ownerRef.Name,
+		Namespace:  child.GetNamespace(),
+		UID:        ownerRef.UID,
+	}
+
+	annotations := map[string]string{
+		ChildAnnotation: client.ObjectKeyFromObject(child).String(),
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s", parentRef.Kind, parentRef.Namespace, parentRef.Name, parentRef.UID)
+
+	r.record(parentRef, key, annotations, eventType, reason, message)
+}
+
+func (r *Recorder) record(obj runtime.Object, objKey string, annotations map[string]string, eventType string, reason Reason, message string) {
+	if r.isDuplicate(fmt.Sprintf("%s/%s/%s/%s", objKey, eventType, reason, message)) {
+		return
+	}
+
+	r.recorder.AnnotatedEventf(obj, annotations, eventType, string(reason), "%s", message)
+}
+
+func (r *Recorder) isDuplicate(key string) bool {
+	if r.dedupInterval <= 0 {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+
+	if len(r.lastRecorded) > maxTrackedEvents {
+		for k, t := range r.lastRecorded {
+			if now.Sub(t) >= r.dedupInterval {
+				delete(r.lastRecorded, k)
+			}
+		}
+	}
+
+	if lastRecorded, ok := r.lastRecorded[key]; ok && now.Sub(lastRecorded) < r.dedupInterval {
+		return true
+	}
+
+	r.lastRecorded[key] = now
+
+	return false
+}
+
+func objectKey(obj client.Object) string {
+	return fmt.Sprintf("%T/%s/%s/%s", obj, obj.GetNamespace(), obj.GetName(), obj.GetUID())
+}
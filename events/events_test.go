@@ -0,0 +1,109 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+)
+
+func TestRecorder(t *testing.T) {
+	obj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			UID:       "1234",
+		},
+	}
+
+	t.Run("Dedup", func(t *testing.T) {
+		fakeRecorder := record.NewFakeRecorder(10)
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+
+		r := events.NewRecorder(fakeRecorder, events.WithClock(fakeClock), events.WithDedupInterval(time.Minute))
+
+		r.Normalf(obj, events.ReasonCreatedChild, "Created %s", "child")
+		r.Normalf(obj, events.ReasonCreatedChild, "Created %s", "child")
+		r.Warning(obj, events.ReasonUpdateFailed, "Failed to update")
+
+		assert.Equal(t, []string{
+			"Normal CreatedChild Created child",
+			"Warning UpdateFailed Failed to update",
+		}, drain(fakeRecorder))
+
+		fakeClock.Step(2 * time.Minute)
+
+		r.Normalf(obj, events.ReasonCreatedChild, "Created %s", "child")
+
+		assert.Equal(t, []string{"Normal CreatedChild Created child"}, drain(fakeRecorder))
+	})
+
+	t.Run("Dedup Disabled", func(t *testing.T) {
+		fakeRecorder := record.NewFakeRecorder(10)
+
+		r := events.NewRecorder(fakeRecorder, events.WithDedupInterval(0))
+
+		r.Warningf(obj, events.ReasonReferenceNotFound, "Secret %q not found", "demo")
+		r.Warningf(obj, events.ReasonReferenceNotFound, "Secret %q not found", "demo")
+
+		assert.Len(t, drain(fakeRecorder), 2)
+	})
+
+	t.Run("Child", func(t *testing.T) {
+		fakeRecorder := record.NewFakeRecorder(10)
+		fakeRecorder.IncludeObject = true
+
+		r := events.NewRecorder(fakeRecorder)
+
+		child := obj.DeepCopy()
+		child.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "example.com/v1",
+			Kind:       "MyObject",
+			Name:       "parent",
+			UID:        "5678",
+			Controller: ptr.To(true),
+		}}
+
+		r.ChildNormalf(child, events.ReasonCreatedChild, "Created config map")
+
+		recorded := drain(fakeRecorder)
+		assert.Len(t, recorded, 1)
+		assert.Contains(t, recorded[0], "involvedObject{kind=MyObject,apiVersion=example.com/v1}")
+		assert.Contains(t, recorded[0], "gpu-ninja.com/child:default/test")
+	})
+}
+
+func drain(r *record.FakeRecorder) []string {
+	var recorded []string
+	for {
+		select {
+		case e := <-r.Events:
+			recorded = append(recorded, e)
+		default:
+			return recorded
+		}
+	}
+}
@@ -0,0 +1,234 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package gpunode inspects nodes for GPU capacity (using the extended resources
+// advertised by device plugins, and the labels published by GPU Feature
+// Discovery and Node Feature Discovery), and builds scheduling constraints
+// for placing workloads onto suitable nodes.
+package gpunode
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Vendor is a GPU vendor.
+type Vendor string
+
+const (
+	VendorNVIDIA Vendor = "nvidia"
+	VendorAMD    Vendor = "amd"
+	VendorIntel  Vendor = "intel"
+)
+
+// Extended resources advertised by GPU device plugins.
+const (
+	ResourceNVIDIAGPU corev1.ResourceName = "nvidia.com/gpu"
+	ResourceAMDGPU    corev1.ResourceName = "amd.com/gpu"
+	ResourceIntelGPU  corev1.ResourceName = "gpu.intel.com/i915"
+)
+
+// Labels published by NVIDIA GPU Feature Discovery.
+const (
+	LabelNVIDIAProduct     = "nvidia.com/gpu.product"
+	LabelNVIDIACount       = "nvidia.com/gpu.count"
+	LabelNVIDIAMemory      = "nvidia.com/gpu.memory"
+	LabelNVIDIAFamily      = "nvidia.com/gpu.family"
+	LabelNVIDIADriverMajor = "nvidia.com/cuda.driver.major"
+	LabelNVIDIADriverMinor = "nvidia.com/cuda.driver.minor"
+	LabelNVIDIADriverRev   = "nvidia.com/cuda.driver.rev"
+)
+
+// Labels published by Node Feature Discovery for PCI devices by vendor id.
+const (
+	LabelNFDNVIDIAPresent = "feature.node.kubernetes.io/pci-10de.present"
+	LabelNFDAMDPresent    = "feature.node.kubernetes.io/pci-1002.present"
+	LabelNFDIntelPresent  = "feature.node.kubernetes.io/pci-8086.present"
+)
+
+var vendorResources = map[Vendor]corev1.ResourceName{
+	VendorNVIDIA: ResourceNVIDIAGPU,
+	VendorAMD:    ResourceAMDGPU,
+	VendorIntel:  ResourceIntelGPU,
+}
+
+var vendorNFDLabels = map[Vendor]string{
+	VendorNVIDIA: LabelNFDNVIDIAPresent,
+	VendorAMD:    LabelNFDAMDPresent,
+	VendorIntel:  LabelNFDIntelPresent,
+}
+
+// ResourceName returns the extended resource advertised for the vendor's GPUs.
+func ResourceName(vendor Vendor) corev1.ResourceName {
+	return vendorResources[vendor]
+}
+
+// Info is the GPU inventory of a node.
+type Info struct {
+	// Node is the name of the node.
+	Node string
+	// Vendor is the vendor of the node's GPUs.
+	Vendor Vendor
+	// Product is the GPU model (eg. "NVIDIA-A100-SXM4-80GB"), if known.
+	Product string
+	// Family is the GPU architecture family (eg. "ampere"), if known.
+	Family string
+	// MemoryMiB is the memory of each GPU in MiB, if known.
+	MemoryMiB int64
+	// DriverVersion is the driver version, if known.
+	DriverVersion string
+	// Capacity is the number of GPUs on the node.
+	Capacity int64
+	// Allocatable is the number of GPUs available for scheduling.
+	Allocatable int64
+	// Present is true if GPUs were detected (eg. by NFD) but are not yet
+	// advertised by a device plugin.
+	Present bool
+}
+
+// Inspect returns the GPU inventory of a node, and false if the node has no GPUs.
+func Inspect(node *corev1.Node) (*Info, bool) {
+	for _, vendor := range []Vendor{VendorNVIDIA, VendorAMD, VendorIntel} {
+		resourceName := vendorResources[vendor]
+
+		capacity := quantity(node.Status.Capacity, resourceName)
+		present := node.Labels[vendorNFDLabels[vendor]] == "true"
+		if capacity == 0 && !present {
+			continue
+		}
+
+		info := &Info{
+			Node:        node.Name,
+			Vendor:      vendor,
+			Capacity:    capacity,
+			Allocatable: quantity(node.Status.Allocatable, resourceName),
+			Present:     present,
+		}
+
+		if vendor == VendorNVIDIA {
+			info.Product = node.Labels[LabelNVIDIAProduct]
+			info.Family = node.Labels[LabelNVIDIAFamily]
+			info.MemoryMiB, _ = strconv.ParseInt(node.Labels[LabelNVIDIAMemory], 10, 64)
+
+			if major := node.Labels[LabelNVIDIADriverMajor]; major != "" {
+				info.DriverVersion = fmt.Sprintf("%s.%s.%s", major,
+					node.Labels[LabelNVIDIADriverMinor], node.Labels[LabelNVIDIADriverRev])
+			}
+
+			// GFD may label the count before the device plugin advertises capacity.
+			if info.Capacity == 0 {
+				info.Capacity, _ = strconv.ParseInt(node.Labels[LabelNVIDIACount], 10, 64)
+			}
+		}
+
+		return info, true
+	}
+
+	return nil, false
+}
+
+// List returns the GPU inventory of all nodes with GPUs.
+func List(ctx context.Context, c client.Client, opts ...client.ListOption) ([]Info, error) {
+	var nodes corev1.NodeList
+	if err := c.List(ctx, &nodes, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	var inventory []Info
+	for i := range nodes.Items {
+		if info, ok := Inspect(&nodes.Items[i]); ok {
+			inventory = append(inventory, *info)
+		}
+	}
+
+	sort.Slice(inventory, func(i, j int) bool {
+		return inventory[i].Node < inventory[j].Node
+	})
+
+	return inventory, nil
+}
+
+// ModelSummary is the GPU inventory of a model across nodes.
+type ModelSummary struct {
+	// Vendor is the vendor of the model.
+	Vendor Vendor
+	// Product is the GPU model (empty if unknown).
+	Product string
+	// Nodes is the number of nodes with the model.
+	Nodes int
+	// Capacity is the total number of GPUs of the model.
+	Capacity int64
+	// Allocatable is the total number of GPUs of the model available for scheduling.
+	Allocatable int64
+}
+
+// Summarize aggregates the inventory by vendor and model.
+func Summarize(inventory []Info) []ModelSummary {
+	type key struct {
+		vendor  Vendor
+		product string
+	}
+
+	byModel := make(map[key]*ModelSummary)
+	for _, info := range inventory {
+		k := key{vendor: info.Vendor, product: info.Product}
+
+		summary, ok := byModel[k]
+		if !ok {
+			summary = &ModelSummary{Vendor: info.Vendor, Product: info.Product}
+			byModel[k] = summary
+		}
+
+		summary.Nodes++
+		summary.Capacity += info.Capacity
+		summary.Allocatable += info.Allocatable
+	}
+
+	summaries := make([]ModelSummary, 0, len(byModel))
+	for _, summary := range byModel {
+		summaries = append(summaries, *summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Vendor != summaries[j].Vendor {
+			return summaries[i].Vendor < summaries[j].Vendor
+		}
+		return summaries[i].Product < summaries[j].Product
+	})
+
+	return summaries
+}
+
+func quantity(resources corev1.ResourceList, name corev1.ResourceName) int64 {
+	q, ok := resources[name]
+	if !ok {
+		return 0
+	}
+
+	return q.Value()
+}
+
+// resourceQuantity returns a quantity of whole devices.
+func resourceQuantity(count int64) resource.Quantity {
+	return *resource.NewQuantity(count, resource.DecimalSI)
+}
@@ -0,0 +1,131 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gpunode_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/gpunode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGPUNode(t *testing.T) {
+	a100 := func(name string, allocatable int64) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					gpunode.LabelNVIDIAProduct:     "NVIDIA-A100-SXM4-80GB",
+					gpunode.LabelNVIDIAFamily:      "ampere",
+					gpunode.LabelNVIDIAMemory:      "81920",
+					gpunode.LabelNVIDIACount:       "8",
+					gpunode.LabelNVIDIADriverMajor: "535",
+					gpunode.LabelNVIDIADriverMinor: "104",
+					gpunode.LabelNVIDIADriverRev:   "05",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Capacity:    corev1.ResourceList{gpunode.ResourceNVIDIAGPU: resource.MustParse("8")},
+				Allocatable: corev1.ResourceList{gpunode.ResourceNVIDIAGPU: *resource.NewQuantity(allocatable, resource.DecimalSI)},
+			},
+		}
+	}
+
+	amd := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "amd-0",
+			Labels: map[string]string{gpunode.LabelNFDAMDPresent: "true"},
+		},
+	}
+
+	cpu := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cpu-0"}}
+
+	c := fake.NewClientBuilder().WithObjects(a100("gpu-0", 8), a100("gpu-1", 4), amd, cpu).Build()
+
+	inventory, err := gpunode.List(context.Background(), c)
+	require.NoError(t, err)
+	require.Len(t, inventory, 3)
+
+	assert.Equal(t, "amd-0", inventory[0].Node)
+	assert.Equal(t, gpunode.VendorAMD, inventory[0].Vendor)
+	assert.True(t, inventory[0].Present)
+
+	assert.Equal(t, gpunode.Info{
+		Node:          "gpu-0",
+		Vendor:        gpunode.VendorNVIDIA,
+		Product:       "NVIDIA-A100-SXM4-80GB",
+		Family:        "ampere",
+		MemoryMiB:     81920,
+		DriverVersion: "535.104.05",
+		Capacity:      8,
+		Allocatable:   8,
+	}, inventory[1])
+
+	assert.Equal(t, []gpunode.ModelSummary{
+		{Vendor: gpunode.VendorAMD, Nodes: 1},
+		{Vendor: gpunode.VendorNVIDIA, Product: "NVIDIA-A100-SXM4-80GB", Nodes: 2, Capacity: 16, Allocatable: 12},
+	}, gpunode.Summarize(inventory))
+
+	t.Run("Scheduling", func(t *testing.T) {
+		req := gpunode.Requirements{
+			Count:        8,
+			Products:     []string{"NVIDIA-A100-SXM4-80GB"},
+			MinMemoryMiB: 40960,
+		}
+
+		assert.True(t, req.Matches(&inventory[1]))
+		assert.False(t, req.Matches(&inventory[2]))
+
+		// The node selector is evaluated against the node labels.
+		for _, nodeReq := range req.NodeSelectorRequirements() {
+			selector, err := labels.NewRequirement(nodeReq.Key, selectionOperator(nodeReq.Operator), nodeReq.Values)
+			require.NoError(t, err)
+			assert.True(t, selector.Matches(labels.Set(a100("gpu-0", 8).Labels)))
+		}
+
+		spec := corev1.PodSpec{Containers: []corev1.Container{{Name: "trainer"}}}
+		req.Apply(&spec, "trainer")
+		req.Apply(&spec, "trainer")
+
+		limit := spec.Containers[0].Resources.Limits[gpunode.ResourceNVIDIAGPU]
+		assert.Equal(t, int64(8), limit.Value())
+		assert.Len(t, spec.Tolerations, 1)
+		assert.NotNil(t, spec.Affinity.NodeAffinity)
+	})
+}
+
+func selectionOperator(op corev1.NodeSelectorOperator) selection.Operator {
+	switch op {
+	case corev1.NodeSelectorOpIn:
+		return selection.In
+	case corev1.NodeSelectorOpExists:
+		return selection.Exists
+	case corev1.NodeSelectorOpGt:
+		return selection.GreaterThan
+	default:
+		panic("unsupported operator")
+	}
+}
@@ -0,0 +1,185 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gpunode
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Requirements describes the GPUs a workload needs.
+type Requirements struct {
+	// Vendor is the required GPU vendor. Defaults to NVIDIA.
+	Vendor Vendor
+	// Count is the number of GPUs required by each pod.
+	Count int64
+	// Products, if set, restricts scheduling to nodes with one of the given GPU models.
+	Products []string
+	// MinMemoryMiB, if set, restricts scheduling to nodes with GPUs with at least this much memory.
+	MinMemoryMiB int64
+}
+
+func (r *Requirements) vendor() Vendor {
+	if r.Vendor == "" {
+		return VendorNVIDIA
+	}
+
+	return r.Vendor
+}
+
+// Matches returns true if the node can satisfy the requirements (ignoring
+// GPUs already allocated to other pods).
+func (r *Requirements) Matches(info *Info) bool {
+	if info.Vendor != r.vendor() || info.Allocatable < r.Count {
+		return false
+	}
+
+	if len(r.Products) > 0 {
+		var found bool
+		for _, product := range r.Products {
+			if info.Product == product {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return r.MinMemoryMiB == 0 || info.MemoryMiB >= r.MinMemoryMiB
+}
+
+// NodeSelectorRequirements returns node selector requirements that select
+// nodes with suitable GPUs. Model and memory constraints rely on GPU
+// Feature Discovery labels, so are only supported for NVIDIA GPUs.
+func (r *Requirements) NodeSelectorRequirements() []corev1.NodeSelectorRequirement {
+	var requirements []corev1.NodeSelectorRequirement
+
+	if r.vendor() != VendorNVIDIA {
+		return append(requirements, corev1.NodeSelectorRequirement{
+			Key:      vendorNFDLabels[r.vendor()],
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{"true"},
+		})
+	}
+
+	if len(r.Products) > 0 {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      LabelNVIDIAProduct,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   r.Products,
+		})
+	} else {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      LabelNVIDIAProduct,
+			Operator: corev1.NodeSelectorOpExists,
+		})
+	}
+
+	if r.MinMemoryMiB > 0 {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      LabelNVIDIAMemory,
+			Operator: corev1.NodeSelectorOpGt,
+			Values:   []string{strconv.FormatInt(r.MinMemoryMiB-1, 10)},
+		})
+	}
+
+	if r.Count > 1 {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      LabelNVIDIACount,
+			Operator: corev1.NodeSelectorOpGt,
+			Values:   []string{strconv.FormatInt(r.Count-1, 10)},
+		})
+	}
+
+	return requirements
+}
+
+// Affinity returns a required node affinity that selects nodes with suitable GPUs.
+func (r *Requirements) Affinity() *corev1.Affinity {
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: r.NodeSelectorRequirements(),
+				}},
+			},
+		},
+	}
+}
+
+// Tolerations returns the tolerations commonly required to schedule onto
+// GPU nodes (which are often tainted with the GPU resource name).
+func (r *Requirements) Tolerations() []corev1.Toleration {
+	return []corev1.Toleration{{
+		Key:      string(ResourceName(r.vendor())),
+		Operator: corev1.TolerationOpExists,
+		Effect:   corev1.TaintEffectNoSchedule,
+	}}
+}
+
+// ResourceRequirements returns the container resource requirements for the GPUs.
+func (r *Requirements) ResourceRequirements() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			ResourceName(r.vendor()): resourceQuantity(r.Count),
+		},
+	}
+}
+
+// Apply configures a pod spec to request the GPUs (in the given container)
+// and to be scheduled onto suitable nodes. Any existing node affinity is replaced.
+func (r *Requirements) Apply(spec *corev1.PodSpec, container string) {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name != container {
+			continue
+		}
+
+		if spec.Containers[i].Resources.Limits == nil {
+			spec.Containers[i].Resources.Limits = corev1.ResourceList{}
+		}
+
+		for name, quantity := range r.ResourceRequirements().Limits {
+			spec.Containers[i].Resources.Limits[name] = quantity
+		}
+	}
+
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{}
+	}
+	spec.Affinity.NodeAffinity = r.Affinity().NodeAffinity
+
+	for _, toleration := range r.Tolerations() {
+		if !hasToleration(spec.Tolerations, &toleration) {
+			spec.Tolerations = append(spec.Tolerations, toleration)
+		}
+	}
+}
+
+func hasToleration(tolerations []corev1.Toleration, toleration *corev1.Toleration) bool {
+	for i := range tolerations {
+		if tolerations[i].MatchToleration(toleration) {
+			return true
+		}
+	}
+
+	return false
+}
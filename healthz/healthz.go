@@ -0,0 +1,183 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package healthz provides pluggable health checks for an operator's
+// managed dependencies, suitable for the manager's healthz/readyz endpoints.
+package healthz
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	DefaultTimeout  = 5 * time.Second
+	DefaultCacheTTL = 10 * time.Second
+)
+
+// CheckFunc checks the health of a dependency.
+type CheckFunc func(ctx context.Context) error
+
+// Options configures a health check.
+type Options struct {
+	// Timeout is the maximum amount of time a single check can take.
+	Timeout time.Duration
+	// CacheTTL is how long the result of a check is cached for. Caching
+	// prevents frequent probes from hammering dependencies.
+	CacheTTL time.Duration
+	// Clock is used to determine the current time (useful for testing).
+	Clock clock.PassiveClock
+}
+
+// Check is a cached health check with a timeout.
+type Check struct {
+	fn        CheckFunc
+	opts      Options
+	mu        sync.Mutex
+	lastCheck time.Time
+	lastErr   error
+}
+
+// NewCheck returns a new cached health check.
+func NewCheck(fn CheckFunc, opts Options) *Check {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = DefaultCacheTTL
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &Check{
+		fn:   fn,
+		opts: opts,
+	}
+}
+
+// Run runs the check, returning a cached result if it is still fresh.
+func (c *Check) Run(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.lastCheck.IsZero() && c.opts.Clock.Since(c.lastCheck) < c.opts.CacheTTL {
+		return c.lastErr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	c.lastErr = c.fn(ctx)
+	c.lastCheck = c.opts.Clock.Now()
+
+	return c.lastErr
+}
+
+// Checker returns the check as a controller-runtime healthz.Checker.
+func (c *Check) Checker() healthz.Checker {
+	return func(req *http.Request) error {
+		return c.Run(req.Context())
+	}
+}
+
+// AddReadyzChecks registers the given checks with the manager's readyz endpoint.
+func AddReadyzChecks(mgr manager.Manager, checks map[string]CheckFunc, opts Options) error {
+	for name, fn := range checks {
+		if err := mgr.AddReadyzCheck(name, NewCheck(fn, opts).Checker()); err != nil {
+			return fmt.Errorf("failed to add readyz check %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// AddHealthzChecks registers the given checks with the manager's healthz endpoint.
+func AddHealthzChecks(mgr manager.Manager, checks map[string]CheckFunc, opts Options) error {
+	for name, fn := range checks {
+		if err := mgr.AddHealthzCheck(name, NewCheck(fn, opts).Checker()); err != nil {
+			return fmt.Errorf("failed to add healthz check %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// KindsInstalled checks that the given kinds are served by the API server
+// (eg. that the CRDs the operator references have been installed).
+func KindsInstalled(mapper meta.RESTMapper, gvks ...schema.GroupVersionKind) CheckFunc {
+	return func(_ context.Context) error {
+		for _, gvk := range gvks {
+			if _, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+				return fmt.Errorf("kind %s is not installed: %w", gvk, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// TCPDial checks that a TCP connection can be established to the given address.
+func TCPDial(address string) CheckFunc {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", address, err)
+		}
+
+		return conn.Close()
+	}
+}
+
+// HTTPGet checks that a GET request to the given URL returns a successful status code.
+func HTTPGet(client *http.Client, url string) CheckFunc {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %w", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("unexpected status code from %s: %d", url, resp.StatusCode)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package healthz_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/healthz"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Cached", func(t *testing.T) {
+		fakeClock := clocktesting.NewFakePassiveClock(time.Now())
+
+		var calls int
+		check := healthz.NewCheck(func(ctx context.Context) error {
+			calls++
+			return errors.New("unhealthy")
+		}, healthz.Options{
+			CacheTTL: time.Minute,
+			Clock:    fakeClock,
+		})
+
+		assert.Error(t, check.Run(ctx))
+		assert.Error(t, check.Run(ctx))
+		assert.Equal(t, 1, calls)
+
+		fakeClock.SetTime(fakeClock.Now().Add(2 * time.Minute))
+
+		assert.Error(t, check.Run(ctx))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		check := healthz.NewCheck(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, healthz.Options{
+			Timeout: 10 * time.Millisecond,
+		})
+
+		assert.ErrorIs(t, check.Run(ctx), context.DeadlineExceeded)
+	})
+}
+
+func TestKindsInstalled(t *testing.T) {
+	gv := schema.GroupVersion{Group: "example.com", Version: "v1"}
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gv})
+	mapper.Add(gv.WithKind("MyObject"), meta.RESTScopeNamespace)
+
+	ctx := context.Background()
+
+	assert.NoError(t, healthz.KindsInstalled(mapper, gv.WithKind("MyObject"))(ctx))
+	assert.Error(t, healthz.KindsInstalled(mapper, gv.WithKind("Missing"))(ctx))
+}
+
+func TestHTTPGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthy" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+
+	assert.NoError(t, healthz.HTTPGet(srv.Client(), srv.URL+"/healthy")(ctx))
+	assert.Error(t, healthz.HTTPGet(srv.Client(), srv.URL+"/unhealthy")(ctx))
+	assert.NoError(t, healthz.TCPDial(srv.Listener.Addr().String())(ctx))
+}
@@ -0,0 +1,297 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hooks
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/jobs"
+	"github.com/gpu-ninja/operator-utils/podutils"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrNoRunningPods is returned when an exec hook's target has no running pods.
+	ErrNoRunningPods = errors.New("no running pods")
+	// ErrNoClaims is returned when a snapshot hook's target has no persistent volume claims.
+	ErrNoClaims = errors.New("no persistent volume claims")
+	// ErrSnapshotNotReady is returned (wrapped in a retryable error) while a
+	// snapshot hook's snapshots are not ready to use.
+	ErrSnapshotNotReady = errors.New("snapshot not ready")
+	// ErrTimeout is returned when a snapshot is not ready within the timeout.
+	ErrTimeout = errors.New("timed out waiting for snapshot")
+)
+
+// VolumeSnapshotGroupVersionKind is the kind of CSI volume snapshots.
+var VolumeSnapshotGroupVersionKind = schema.GroupVersionKind{
+	Group:   "snapshot.storage.k8s.io",
+	Version: "v1",
+	Kind:    "VolumeSnapshot",
+}
+
+// ExecHook returns a hook that runs a command in a running pod of the
+// target (either the target itself, if it is a pod, or a pod selected by
+// the target's spec.selector, eg. a StatefulSet).
+func ExecHook(cfg *rest.Config, c client.Client, container string, cmd []string) Hook {
+	return HookFunc(func(ctx context.Context, target client.Object, _ Disruption) (string, error) {
+		pod, err := runningPod(ctx, c, target)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := podutils.Exec(ctx, cfg, pod, container, cmd, nil); err != nil {
+			return "", fmt.Errorf("failed to exec in pod %q: %w", pod.Name, err)
+		}
+
+		return fmt.Sprintf("Ran %q in pod %s", strings.Join(cmd, " "), pod.Name), nil
+	})
+}
+
+// JobTemplateFunc returns the job to run for the target.
+type JobTemplateFunc func(target client.Object, disruption Disruption) (*batchv1.Job, error)
+
+// JobHook returns a hook that runs a job (eg. a logical backup) to completion.
+func JobHook(c client.Client, template JobTemplateFunc, opts jobs.Options) Hook {
+	return HookFunc(func(ctx context.Context, target client.Object, disruption Disruption) (string, error) {
+		job, err := template(target, disruption)
+		if err != nil {
+			return "", fmt.Errorf("failed to build job: %w", err)
+		}
+
+		if _, err := jobs.Run(ctx, c, job, opts); err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("Job %s completed", job.Name), nil
+	})
+}
+
+// SnapshotOptions configures a snapshot hook.
+type SnapshotOptions struct {
+	// VolumeSnapshotClassName is the class of the snapshots. If empty, the
+	// default class is used.
+	VolumeSnapshotClassName string
+	// Claims returns the names of the claims to snapshot. Defaults to the
+	// target itself (if it is a claim), or the claims mounted by the target (if it is a pod).
+	Claims func(ctx context.Context, target client.Object) ([]string, error)
+	// Timeout is the maximum time (since they were created) for the snapshots
+	// to be ready. Defaults to 10 minutes.
+	Timeout time.Duration
+	// RequeueAfter is how long to wait before rechecking snapshots that are
+	// not yet ready. Defaults to retryable.DefaultRetryAfter.
+	RequeueAfter time.Duration
+	// Clock is used to time out snapshots, it should be the runner's clock
+	// (see Options.Clock).
+	Clock clock.PassiveClock
+}
+
+// SnapshotHook returns a hook that takes CSI volume snapshots of the
+// target's persistent volume claims. Snapshots are named after the claim
+// and the disruption (so that existing snapshots are reused when the hook
+// is retried), and a retryable ErrSnapshotNotReady is returned until they
+// are ready to use.
+func SnapshotHook(c client.Client, opts SnapshotOptions) Hook {
+	if opts.Claims == nil {
+		opts.Claims = defaultClaims
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Minute
+	}
+
+	if opts.RequeueAfter == 0 {
+		opts.RequeueAfter = retryable.DefaultRetryAfter
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return HookFunc(func(ctx context.Context, target client.Object, disruption Disruption) (string, error) {
+		claims, err := opts.Claims(ctx, target)
+		if err != nil {
+			return "", fmt.Errorf("failed to get claims: %w", err)
+		}
+
+		if len(claims) == 0 {
+			return "", ErrNoClaims
+		}
+
+		suffix := snapshotSuffix(target, disruption)
+
+		var names, pending []string
+		for _, claim := range claims {
+			snapshot, err := ensureSnapshot(ctx, c, target, claim, suffix, disruption, &opts)
+			if err != nil {
+				return "", err
+			}
+
+			if message, ok, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); ok && message != "" {
+				return "", fmt.Errorf("snapshot %q failed: %s", snapshot.GetName(), message)
+			}
+
+			if ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse"); !ready {
+				created := snapshot.GetCreationTimestamp()
+				if !created.IsZero() && opts.Clock.Since(created.Time) > opts.Timeout {
+					return "", fmt.Errorf("%w: %s", ErrTimeout, snapshot.GetName())
+				}
+
+				pending = append(pending, snapshot.GetName())
+			}
+
+			names = append(names, snapshot.GetName())
+		}
+
+		if len(pending) > 0 {
+			return "", retryable.After(fmt.Errorf("%w: %s", ErrSnapshotNotReady, strings.Join(pending, ", ")), opts.RequeueAfter)
+		}
+
+		return fmt.Sprintf("Created snapshots %s", strings.Join(names, ", ")), nil
+	})
+}
+
+// ensureSnapshot returns the snapshot of the claim for the disruption,
+// creating it if it doesn't exist.
+func ensureSnapshot(ctx context.Context, c client.Client, target client.Object, claim, suffix string, disruption Disruption, opts *SnapshotOptions) (*unstructured.Unstructured, error) {
+	name := claim
+	if maxLen := validation.DNS1123SubdomainMaxLength - len(suffix) - 1; len(name) > maxLen {
+		// Truncated names must still end with an alphanumeric character.
+		name = strings.TrimRight(name[:maxLen], "-.")
+	}
+
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(VolumeSnapshotGroupVersionKind)
+
+	key := client.ObjectKey{Namespace: target.GetNamespace(), Name: name + "-" + suffix}
+	if err := c.Get(ctx, key, snapshot); err == nil {
+		return snapshot, nil
+	} else if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get snapshot of %q: %w", claim, err)
+	}
+
+	snapshot.SetNamespace(key.Namespace)
+	snapshot.SetName(key.Name)
+	snapshot.SetAnnotations(map[string]string{
+		"gpu-ninja.com/disruption": disruption.String(),
+	})
+
+	if err := unstructured.SetNestedField(snapshot.Object, claim, "spec", "source", "persistentVolumeClaimName"); err != nil {
+		return nil, fmt.Errorf("failed to set snapshot source: %w", err)
+	}
+
+	if opts.VolumeSnapshotClassName != "" {
+		if err := unstructured.SetNestedField(snapshot.Object, opts.VolumeSnapshotClassName, "spec", "volumeSnapshotClassName"); err != nil {
+			return nil, fmt.Errorf("failed to set snapshot class: %w", err)
+		}
+	}
+
+	if err := c.Create(ctx, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot of %q: %w", claim, err)
+	}
+
+	return snapshot, nil
+}
+
+// snapshotSuffix returns a suffix identifying the snapshots taken of the
+// target for the disruption.
+func snapshotSuffix(target client.Object, disruption Disruption) string {
+	h := fnv.New32a()
+	_, _ = io.WriteString(h, string(target.GetUID())+"/"+string(disruption.Type)+"/"+disruption.ID)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func defaultClaims(_ context.Context, target client.Object) ([]string, error) {
+	switch t := target.(type) {
+	case *corev1.PersistentVolumeClaim:
+		return []string{t.Name}, nil
+	case *corev1.Pod:
+		var claims []string
+		for _, volume := range t.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+			}
+		}
+
+		return claims, nil
+	default:
+		return nil, fmt.Errorf("unsupported target %T, a claims function is required", target)
+	}
+}
+
+// runningPod returns a running pod of the target.
+func runningPod(ctx context.Context, c client.Client, target client.Object) (*corev1.Pod, error) {
+	if pod, ok := target.(*corev1.Pod); ok {
+		if pod.Status.Phase != corev1.PodRunning {
+			return nil, fmt.Errorf("%w: pod %q is %s", ErrNoRunningPods, pod.Name, pod.Status.Phase)
+		}
+
+		return pod, nil
+	}
+
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert to unstructured: %w", err)
+	}
+
+	rawSelector, ok, err := unstructured.NestedMap(u, "spec", "selector")
+	if err != nil || !ok {
+		return nil, fmt.Errorf("target %q does not have a selector", target.GetName())
+	}
+
+	var labelSelector metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSelector, &labelSelector); err != nil {
+		return nil, fmt.Errorf("failed to convert selector: %w", err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse selector: %w", err)
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(target.GetNamespace()), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp.IsZero() {
+			return pod, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoRunningPods, target.GetName())
+}
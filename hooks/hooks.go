@@ -0,0 +1,272 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package hooks runs pre-disruption hooks (eg. backups) before an operator
+// performs a disruptive change to a child (such as deleting and recreating
+// it, or a major version upgrade).
+//
+// Hooks are registered with a Runner by name, and are enabled for a child by
+// listing them in its "gpu-ninja.com/pre-disruption-hooks" annotation, eg.
+// `gpu-ninja.com/pre-disruption-hooks: "dump,snapshot"`. Hooks are run in the
+// listed order, and the outcome of each is recorded so that it can be
+// surfaced in the parent's status.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gpu-ninja/operator-utils/retryable"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// HooksAnnotation lists the (comma separated) names of the hooks to run
+	// before disrupting the annotated object.
+	HooksAnnotation = "gpu-ninja.com/pre-disruption-hooks"
+	// DefaultMaxOutcomes is the default number of outcomes retained by RecordOutcomes.
+	DefaultMaxOutcomes = 10
+)
+
+var (
+	// ErrUnknownHook is returned when an object references an unregistered hook.
+	ErrUnknownHook = errors.New("unknown hook")
+	// ErrHookFailed is returned when a hook fails (and the disruption should not proceed).
+	ErrHookFailed = errors.New("pre-disruption hook failed")
+)
+
+// DisruptionType is the type of a disruptive change.
+type DisruptionType string
+
+const (
+	// DisruptionRecreate is the deletion and recreation of an object.
+	DisruptionRecreate DisruptionType = "Recreate"
+	// DisruptionDelete is the deletion of an object.
+	DisruptionDelete DisruptionType = "Delete"
+	// DisruptionUpgrade is a major version upgrade.
+	DisruptionUpgrade DisruptionType = "Upgrade"
+)
+
+// Disruption describes a disruptive change to an object.
+type Disruption struct {
+	// Type is the type of the disruption.
+	Type DisruptionType
+	// ID identifies the specific disruption (eg. the target version, or a
+	// hash of the desired spec). Hooks that have already succeeded for the
+	// same target and disruption ID are not run again.
+	ID string
+}
+
+func (d Disruption) String() string {
+	if d.ID == "" {
+		return string(d.Type)
+	}
+
+	return fmt.Sprintf("%s (%s)", d.Type, d.ID)
+}
+
+// Hook is invoked before an object is disrupted.
+type Hook interface {
+	// Run runs the hook against the target object. A hook should return
+	// a human readable message describing what it did (eg. the name of a
+	// snapshot).
+	Run(ctx context.Context, target client.Object, disruption Disruption) (string, error)
+}
+
+// HookFunc is a function that implements Hook.
+type HookFunc func(ctx context.Context, target client.Object, disruption Disruption) (string, error)
+
+// Run calls f(ctx, target, disruption).
+func (f HookFunc) Run(ctx context.Context, target client.Object, disruption Disruption) (string, error) {
+	return f(ctx, target, disruption)
+}
+
+// Phase is the phase of a hook outcome.
+type Phase string
+
+const (
+	PhaseSucceeded Phase = "Succeeded"
+	PhaseFailed    Phase = "Failed"
+)
+
+// Outcome records the outcome of running a hook. It is suitable for
+// embedding in the status of a custom resource.
+// +kubebuilder:object:generate=true
+type Outcome struct {
+	// Hook is the name of the hook.
+	Hook string `json:"hook"`
+	// Target is the kind and name of the object the hook was run against.
+	Target string `json:"target"`
+	// TargetUID is the UID of the object the hook was run against.
+	TargetUID types.UID `json:"targetUID,omitempty"`
+	// Disruption is the type of disruption the hook was run before.
+	Disruption DisruptionType `json:"disruption"`
+	// DisruptionID identifies the specific disruption.
+	DisruptionID string `json:"disruptionID,omitempty"`
+	// Phase is the outcome of the hook.
+	Phase Phase `json:"phase"`
+	// Message is a human readable description of the outcome.
+	Message string `json:"message,omitempty"`
+	// StartTime is when the hook started.
+	StartTime metav1.Time `json:"startTime"`
+	// CompletionTime is when the hook completed.
+	CompletionTime metav1.Time `json:"completionTime"`
+}
+
+// Options configures a runner.
+type Options struct {
+	// Clock is used to timestamp outcomes.
+	Clock clock.PassiveClock
+}
+
+// Runner runs the registered hooks enabled for an object.
+type Runner struct {
+	hooks map[string]Hook
+	clock clock.PassiveClock
+}
+
+// NewRunner returns a new hook runner.
+func NewRunner(opts Options) *Runner {
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &Runner{
+		hooks: make(map[string]Hook),
+		clock: opts.Clock,
+	}
+}
+
+// Register registers (or replaces) a hook with the given name.
+func (r *Runner) Register(name string, hook Hook) {
+	r.hooks[name] = hook
+}
+
+// Enabled returns the names of the hooks enabled for the object.
+func Enabled(obj client.Object) []string {
+	value, ok := obj.GetAnnotations()[HooksAnnotation]
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// BeforeDisruption runs the hooks enabled for the target, in order, and
+// returns the outcomes of the hooks that were run. Hooks that previously
+// succeeded (according to previous, typically the outcomes recorded in the
+// parent's status) for the same target and disruption are skipped. If a
+// hook fails, the remaining hooks are not run and an error wrapping
+// ErrHookFailed is returned, in which case the disruption must not proceed.
+// Retryable errors (eg. while waiting for a snapshot to be ready) are
+// returned as is, without recording the hook as failed, so that it is run
+// again when the disruption is retried.
+func (r *Runner) BeforeDisruption(ctx context.Context, target client.Object, disruption Disruption, previous []Outcome) ([]Outcome, error) {
+	logger := log.FromContext(ctx)
+
+	names := Enabled(target)
+	for _, name := range names {
+		if _, ok := r.hooks[name]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownHook, name)
+		}
+	}
+
+	targetRef := fmt.Sprintf("%s/%s", target.GetObjectKind().GroupVersionKind().Kind, target.GetName())
+	if target.GetObjectKind().GroupVersionKind().Kind == "" {
+		targetRef = target.GetName()
+	}
+
+	var outcomes []Outcome
+	for _, name := range names {
+		if succeeded(previous, name, target, disruption) {
+			logger.V(1).Info("Skipping already succeeded hook", "hook", name)
+			continue
+		}
+
+		outcome := Outcome{
+			Hook:         name,
+			Target:       targetRef,
+			TargetUID:    target.GetUID(),
+			Disruption:   disruption.Type,
+			DisruptionID: disruption.ID,
+			StartTime:    metav1.NewTime(r.clock.Now()),
+		}
+
+		logger.Info("Running pre-disruption hook", "hook", name, "disruption", disruption.String())
+
+		message, err := r.hooks[name].Run(ctx, target, disruption)
+		if retryable.Is(err) {
+			logger.Info("Pre-disruption hook is not ready", "hook", name, "reason", err.Error())
+			return outcomes, fmt.Errorf("%s: %w", name, err)
+		}
+
+		outcome.CompletionTime = metav1.NewTime(r.clock.Now())
+		outcome.Phase = PhaseSucceeded
+		outcome.Message = message
+		if err != nil {
+			outcome.Phase = PhaseFailed
+			outcome.Message = err.Error()
+		}
+
+		outcomes = append(outcomes, outcome)
+
+		if err != nil {
+			return outcomes, fmt.Errorf("%w: %s: %w", ErrHookFailed, name, err)
+		}
+	}
+
+	return outcomes, nil
+}
+
+func succeeded(previous []Outcome, name string, target client.Object, disruption Disruption) bool {
+	for _, outcome := range previous {
+		if outcome.Hook == name && outcome.TargetUID == target.GetUID() &&
+			outcome.Disruption == disruption.Type && outcome.DisruptionID == disruption.ID &&
+			outcome.Phase == PhaseSucceeded {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RecordOutcomes appends the outcomes to the given slice (typically a
+// field of the parent's status), retaining at most limit outcomes (the most
+// recent). If limit is zero or less, DefaultMaxOutcomes is used.
+func RecordOutcomes(recorded *[]Outcome, outcomes []Outcome, limit int) {
+	if limit <= 0 {
+		limit = DefaultMaxOutcomes
+	}
+
+	*recorded = append(*recorded, outcomes...)
+	if len(*recorded) > limit {
+		*recorded = append([]Outcome(nil), (*recorded)[len(*recorded)-limit:]...)
+	}
+}
@@ -0,0 +1,233 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hooks_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/hooks"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRunner(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := hooks.NewRunner(hooks.Options{Clock: clocktesting.NewFakePassiveClock(now)})
+
+	var ran []string
+	fail := true
+
+	r.Register("dump", hooks.HookFunc(func(ctx context.Context, target client.Object, disruption hooks.Disruption) (string, error) {
+		ran = append(ran, "dump")
+		return "Dumped database", nil
+	}))
+
+	r.Register("flaky", hooks.HookFunc(func(ctx context.Context, target client.Object, disruption hooks.Disruption) (string, error) {
+		ran = append(ran, "flaky")
+		if fail {
+			return "", errors.New("connection refused")
+		}
+		return "", nil
+	}))
+
+	r.Register("notify", hooks.HookFunc(func(ctx context.Context, target client.Object, disruption hooks.Disruption) (string, error) {
+		ran = append(ran, "notify")
+		return "", nil
+	}))
+
+	target := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "data",
+			UID:         "data-uid",
+			Annotations: map[string]string{hooks.HooksAnnotation: "dump, flaky,notify"},
+		},
+	}
+
+	disruption := hooks.Disruption{Type: hooks.DisruptionUpgrade, ID: "16"}
+
+	var recorded []hooks.Outcome
+
+	outcomes, err := r.BeforeDisruption(ctx, target, disruption, recorded)
+	require.ErrorIs(t, err, hooks.ErrHookFailed)
+	assert.ErrorContains(t, err, "flaky: connection refused")
+	assert.Equal(t, []string{"dump", "flaky"}, ran)
+
+	require.Len(t, outcomes, 2)
+	assert.Equal(t, hooks.Outcome{
+		Hook:           "dump",
+		Target:         "data",
+		TargetUID:      "data-uid",
+		Disruption:     hooks.DisruptionUpgrade,
+		DisruptionID:   "16",
+		Phase:          hooks.PhaseSucceeded,
+		Message:        "Dumped database",
+		StartTime:      metav1.NewTime(now),
+		CompletionTime: metav1.NewTime(now),
+	}, outcomes[0])
+	assert.Equal(t, hooks.PhaseFailed, outcomes[1].Phase)
+
+	hooks.RecordOutcomes(&recorded, outcomes, 0)
+
+	// The dump has already succeeded, so isn't run again.
+	fail = false
+	ran = nil
+
+	outcomes, err = r.BeforeDisruption(ctx, target, disruption, recorded)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flaky", "notify"}, ran)
+
+	hooks.RecordOutcomes(&recorded, outcomes, 3)
+	require.Len(t, recorded, 3)
+	assert.Equal(t, "flaky", recorded[0].Hook)
+
+	// Negative limits use the default.
+	hooks.RecordOutcomes(&recorded, outcomes, -1)
+	require.Len(t, recorded, 5)
+
+	// A different disruption runs all the hooks.
+	ran = nil
+	_, err = r.BeforeDisruption(ctx, target, hooks.Disruption{Type: hooks.DisruptionUpgrade, ID: "17"}, recorded)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dump", "flaky", "notify"}, ran)
+
+	t.Run("Retryable", func(t *testing.T) {
+		r.Register("pending", hooks.HookFunc(func(ctx context.Context, target client.Object, disruption hooks.Disruption) (string, error) {
+			return "", retryable.New(errors.New("not ready"))
+		}))
+
+		target.Annotations[hooks.HooksAnnotation] = "pending"
+
+		outcomes, err := r.BeforeDisruption(ctx, target, disruption, nil)
+		require.Error(t, err)
+		assert.True(t, retryable.Is(err))
+		assert.NotErrorIs(t, err, hooks.ErrHookFailed)
+		assert.Empty(t, outcomes)
+	})
+
+	t.Run("UnknownHook", func(t *testing.T) {
+		target.Annotations[hooks.HooksAnnotation] = "missing"
+
+		_, err := r.BeforeDisruption(ctx, target, disruption, nil)
+		assert.ErrorIs(t, err, hooks.ErrUnknownHook)
+	})
+}
+
+func TestSnapshotHook(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(hooks.VolumeSnapshotGroupVersionKind, meta.RESTScopeNamespace)
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(mapper).
+		Build()
+
+	hook := hooks.SnapshotHook(c, hooks.SnapshotOptions{
+		VolumeSnapshotClassName: "csi-snapclass",
+	})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "database-0"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-database-0"},
+				},
+			}},
+		},
+	}
+
+	disruption := hooks.Disruption{Type: hooks.DisruptionRecreate}
+
+	_, err := hook.Run(ctx, pod, disruption)
+	require.ErrorIs(t, err, hooks.ErrSnapshotNotReady)
+	assert.True(t, retryable.Is(err))
+
+	var snapshots unstructured.UnstructuredList
+	snapshots.SetGroupVersionKind(hooks.VolumeSnapshotGroupVersionKind.GroupVersion().WithKind("VolumeSnapshotList"))
+	require.NoError(t, c.List(ctx, &snapshots))
+	require.Len(t, snapshots.Items, 1)
+
+	// Stands in for the snapshot controller.
+	snapshot := snapshots.Items[0]
+	require.NoError(t, unstructured.SetNestedField(snapshot.Object, true, "status", "readyToUse"))
+	require.NoError(t, c.Update(ctx, &snapshot))
+
+	// The existing snapshot is reused.
+	message, err := hook.Run(ctx, pod, disruption)
+	require.NoError(t, err)
+	assert.Equal(t, "Created snapshots "+snapshot.GetName(), message)
+	assert.Contains(t, snapshot.GetName(), "data-database-0-")
+
+	require.NoError(t, c.List(ctx, &snapshots))
+	require.Len(t, snapshots.Items, 1)
+
+	claim, _, _ := unstructured.NestedString(snapshots.Items[0].Object, "spec", "source", "persistentVolumeClaimName")
+	assert.Equal(t, "data-database-0", claim)
+
+	class, _, _ := unstructured.NestedString(snapshots.Items[0].Object, "spec", "volumeSnapshotClassName")
+	assert.Equal(t, "csi-snapclass", class)
+
+	_, err = hook.Run(ctx, &corev1.Pod{}, hooks.Disruption{Type: hooks.DisruptionRecreate})
+	assert.ErrorIs(t, err, hooks.ErrNoClaims)
+
+	t.Run("LongClaimNames", func(t *testing.T) {
+		// One of the claims is truncated just after a dot.
+		claim := strings.Repeat("a.", 126) + "a"
+
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "database-1"}}
+		for i, name := range []string{claim, "b" + claim[:len(claim)-1]} {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+				Name: fmt.Sprintf("data-%d", i),
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+				},
+			})
+		}
+
+		_, err := hook.Run(ctx, pod, disruption)
+		require.ErrorIs(t, err, hooks.ErrSnapshotNotReady)
+
+		require.NoError(t, c.List(ctx, &snapshots))
+		for _, snapshot := range snapshots.Items {
+			assert.Empty(t, validation.IsDNS1123Subdomain(snapshot.GetName()), snapshot.GetName())
+		}
+	})
+}
@@ -0,0 +1,187 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package images provides utilities for working with container image
+// references, eg. registry overrides and digest pinning.
+package images
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reference is a parsed container image reference.
+type Reference struct {
+	// Registry is the registry host (eg. "docker.io", "nvcr.io").
+	Registry string
+	// Repository is the repository within the registry (eg. "library/busybox").
+	Repository string
+	// Tag is the tag of the image (empty if not specified).
+	Tag string
+	// Digest is the digest of the image (empty if not specified).
+	Digest string
+}
+
+// Parse parses and normalizes an image reference, images without a
+// registry default to Docker Hub.
+func Parse(image string) (Reference, error) {
+	var ref Reference
+
+	rest := image
+	if i := strings.Index(rest, "@"); i >= 0 {
+		digest, err := name.NewDigest(rest)
+		if err != nil {
+			return ref, fmt.Errorf("failed to parse image %q: %w", image, err)
+		}
+
+		ref.Digest = digest.DigestStr()
+		rest = rest[:i]
+	}
+
+	tag, err := name.NewTag(rest)
+	if err != nil {
+		return ref, fmt.Errorf("failed to parse image %q: %w", image, err)
+	}
+
+	ref.Registry = tag.RegistryStr()
+	ref.Repository = tag.RepositoryStr()
+
+	// Only record the tag if it was explicitly specified (or there is no digest).
+	if tag.Identifier() != name.DefaultTag || strings.HasSuffix(rest, ":"+name.DefaultTag) || ref.Digest == "" {
+		ref.Tag = tag.TagStr()
+	}
+
+	return ref, nil
+}
+
+// Name returns the fully qualified repository name (without tag or digest).
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the fully qualified image reference.
+func (r Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+
+	return s
+}
+
+// WithDigest returns the reference pinned to the given digest.
+func (r Reference) WithDigest(digest string) Reference {
+	r.Digest = digest
+	return r
+}
+
+// Overrides rewrites image references to use alternative registries (eg.
+// an air-gapped mirror), typically populated from operator configuration.
+type Overrides struct {
+	// Registry, if set, replaces the registry of every image.
+	Registry string
+	// Mirrors maps registries (or registry/repository prefixes) to their
+	// replacement, the longest matching prefix wins.
+	Mirrors map[string]string
+}
+
+// Apply applies the overrides to the given image reference.
+func (o *Overrides) Apply(image string) (string, error) {
+	ref, err := Parse(image)
+	if err != nil {
+		return "", err
+	}
+
+	if o.Registry != "" {
+		ref.Registry = o.Registry
+		return ref.String(), nil
+	}
+
+	name := ref.Name()
+
+	var match string
+	for prefix := range o.Mirrors {
+		if (name == prefix || strings.HasPrefix(name, prefix+"/")) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+
+	if match == "" {
+		return ref.String(), nil
+	}
+
+	replaced, err := Parse(o.Mirrors[match] + strings.TrimPrefix(name, match))
+	if err != nil {
+		return "", fmt.Errorf("invalid mirror for %q: %w", match, err)
+	}
+
+	ref.Registry = replaced.Registry
+	ref.Repository = replaced.Repository
+
+	return ref.String(), nil
+}
+
+// Equal returns true if the two image references refer to the same image.
+// If both references have digests only the digests are compared, otherwise
+// the normalized references are compared.
+func Equal(a, b string) bool {
+	refA, err := Parse(a)
+	if err != nil {
+		return a == b
+	}
+
+	refB, err := Parse(b)
+	if err != nil {
+		return a == b
+	}
+
+	if refA.Digest != "" && refB.Digest != "" {
+		return refA.Digest == refB.Digest
+	}
+
+	return refA == refB
+}
+
+// IsRunning returns true if the container is running the desired image. If
+// the desired image is pinned to a digest, it is compared against the
+// digest of the image actually running (which may differ from the image in
+// the pod spec if a tag was used).
+func IsRunning(desired string, status *corev1.ContainerStatus) bool {
+	desiredRef, err := Parse(desired)
+	if err != nil {
+		return false
+	}
+
+	if desiredRef.Digest != "" {
+		// ImageID is of the form "docker-pullable://registry/repo@sha256:..."
+		// or "sha256:..." depending on the container runtime.
+		if i := strings.LastIndex(status.ImageID, "@"); i >= 0 {
+			return status.ImageID[i+1:] == desiredRef.Digest
+		}
+
+		return status.ImageID == desiredRef.Digest || Equal(desired, status.Image)
+	}
+
+	return Equal(desired, status.Image)
+}
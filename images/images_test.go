@@ -0,0 +1,229 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package images_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/gpu-ninja/operator-utils/images"
+	"github.com/gpu-ninja/operator-utils/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParse(t *testing.T) {
+	ref, err := images.Parse("busybox")
+	require.NoError(t, err)
+	assert.Equal(t, "index.docker.io/library/busybox:latest", ref.String())
+
+	ref, err = images.Parse("nvcr.io/nvidia/k8s-device-plugin:v0.14.1@" + digest)
+	require.NoError(t, err)
+	assert.Equal(t, images.Reference{
+		Registry:   "nvcr.io",
+		Repository: "nvidia/k8s-device-plugin",
+		Tag:        "v0.14.1",
+		Digest:     digest,
+	}, ref)
+
+	ref, err = images.Parse("localhost:5000/driver@" + digest)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:5000/driver@"+digest, ref.String())
+
+	_, err = images.Parse("UPPERCASE/Invalid")
+	require.Error(t, err)
+}
+
+func TestOverrides(t *testing.T) {
+	o := images.Overrides{
+		Mirrors: map[string]string{
+			"nvcr.io":        "mirror.example.com/nvcr",
+			"nvcr.io/nvidia": "mirror.example.com/nvidia",
+		},
+	}
+
+	image, err := o.Apply("nvcr.io/nvidia/driver:535")
+	require.NoError(t, err)
+	assert.Equal(t, "mirror.example.com/nvidia/driver:535", image)
+
+	image, err = o.Apply("nvcr.io/other/driver:535")
+	require.NoError(t, err)
+	assert.Equal(t, "mirror.example.com/nvcr/other/driver:535", image)
+
+	image, err = o.Apply("busybox")
+	require.NoError(t, err)
+	assert.Equal(t, "index.docker.io/library/busybox:latest", image)
+
+	o.Registry = "airgap.local"
+	image, err = o.Apply("nvcr.io/nvidia/driver:535")
+	require.NoError(t, err)
+	assert.Equal(t, "airgap.local/nvidia/driver:535", image)
+}
+
+func TestEqual(t *testing.T) {
+	assert.True(t, images.Equal("busybox", "docker.io/library/busybox:latest"))
+	assert.False(t, images.Equal("busybox:1.36", "busybox:1.35"))
+	assert.True(t, images.Equal("busybox:1.36@"+digest, "mirror.local/busybox@"+digest))
+
+	assert.True(t, images.IsRunning("busybox:1.36@"+digest, &corev1.ContainerStatus{
+		Image:   "docker.io/library/busybox:1.36",
+		ImageID: "docker-pullable://busybox@" + digest,
+	}))
+	assert.False(t, images.IsRunning("busybox:1.36@"+digest, &corev1.ContainerStatus{
+		Image:   "docker.io/library/busybox:1.36",
+		ImageID: "docker-pullable://busybox@sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+	}))
+	assert.True(t, images.IsRunning("busybox:1.36", &corev1.ContainerStatus{Image: "docker.io/library/busybox:1.36"}))
+}
+
+func TestResolveDigest(t *testing.T) {
+	ctx := context.Background()
+
+	srv := httptest.NewServer(registry.New())
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+
+	tag, err := name.NewTag(u.Host + "/gpu/driver:535")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(tag, img))
+
+	expected, err := img.Digest()
+	require.NoError(t, err)
+
+	pullSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull-secret"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"` + u.Host + `":{"auth":"` +
+				base64.StdEncoding.EncodeToString([]byte("user:pass")) + `"}}}`),
+		},
+	}
+
+	pinned, err := images.ResolveDigest(ctx, tag.String(), []corev1.Secret{pullSecret})
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(pinned, ":535@"+expected.String()))
+
+	keychain, err := images.KeychainFromSecrets([]corev1.Secret{pullSecret})
+	require.NoError(t, err)
+
+	auth, err := keychain.Resolve(tag.Context())
+	require.NoError(t, err)
+
+	config, err := auth.Authorization()
+	require.NoError(t, err)
+	assert.Equal(t, &authn.AuthConfig{Username: "user", Password: "pass"}, config)
+}
+
+func TestPullSecrets(t *testing.T) {
+	ctx := context.Background()
+
+	source := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "operator", Name: "registry-credentials"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"https://index.docker.io/v1/":{"username":"hub","password":"hub-pass"}}}`),
+		},
+	}
+
+	merged, err := images.NewPullSecret("workloads", "merged", []corev1.Secret{source}, images.Credential{
+		Registry: "nvcr.io",
+		Username: "$oauthtoken",
+		Password: "ngc-key",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, corev1.SecretTypeDockerConfigJson, merged.Type)
+
+	keychain, err := images.KeychainFromSecrets([]corev1.Secret{*merged})
+	require.NoError(t, err)
+
+	for registry, expected := range map[string]authn.AuthConfig{
+		"nvcr.io":   {Username: "$oauthtoken", Password: "ngc-key"},
+		"docker.io": {Username: "hub", Password: "hub-pass"},
+	} {
+		reg, err := name.NewRegistry(registry)
+		require.NoError(t, err)
+
+		auth, err := keychain.Resolve(reg)
+		require.NoError(t, err)
+
+		config, err := auth.Authorization()
+		require.NoError(t, err)
+		assert.Equal(t, expected.Username, config.Username)
+		assert.Equal(t, expected.Password, config.Password)
+	}
+
+	var spec corev1.PodSpec
+	images.AttachPullSecrets(&spec, "merged", "merged", "registry-credentials")
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "merged"}, {Name: "registry-credentials"}}, spec.ImagePullSecrets)
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	conflicting := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "registry-credentials"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&source, conflicting).Build()
+
+	copied, err := images.CopyPullSecrets(ctx, c, "operator", []string{"registry-credentials"}, "workloads")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry-credentials"}, copied)
+
+	var secret corev1.Secret
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "workloads", Name: "registry-credentials"}, &secret))
+	assert.Equal(t, source.Data, secret.Data)
+
+	key, ok := syncer.SourceOf(&secret)
+	require.True(t, ok)
+	assert.Equal(t, client.ObjectKeyFromObject(&source), key)
+
+	// Copying again is idempotent.
+	_, err = images.CopyPullSecrets(ctx, c, "operator", []string{"registry-credentials"}, "workloads")
+	require.NoError(t, err)
+
+	_, err = images.CopyPullSecrets(ctx, c, "operator", []string{"registry-credentials"}, "other")
+	require.ErrorIs(t, err, syncer.ErrConflict)
+
+	require.NoError(t, images.DeletePullSecretCopies(ctx, c, "operator", []string{"registry-credentials"}, "workloads"))
+	require.NoError(t, images.DeletePullSecretCopies(ctx, c, "operator", []string{"registry-credentials"}, "other"))
+
+	err = c.Get(ctx, client.ObjectKeyFromObject(&secret), &secret)
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// The conflicting secret is left untouched.
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(conflicting), conflicting))
+}
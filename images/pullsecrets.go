@@ -0,0 +1,159 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package images
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gpu-ninja/operator-utils/syncer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Credential is a set of registry credentials.
+type Credential struct {
+	// Registry is the registry host (eg. "nvcr.io").
+	Registry string
+	// Username is the registry username.
+	Username string
+	// Password is the registry password (or token).
+	Password string
+}
+
+// NewPullSecret builds a kubernetes.io/dockerconfigjson Secret by merging the
+// credentials from the given pull secrets and explicit credentials. When
+// multiple sources have credentials for the same registry, the explicit
+// credentials win, followed by the secrets in order.
+func NewPullSecret(namespace, name string, secrets []corev1.Secret, creds ...Credential) (*corev1.Secret, error) {
+	config := dockerConfig{Auths: make(map[string]dockerAuth)}
+
+	for i := range secrets {
+		auths, err := parseAuths(&secrets[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for server, auth := range auths {
+			registry := normalizeServer(server)
+			if _, ok := config.Auths[registry]; !ok {
+				config.Auths[registry] = auth
+			}
+		}
+	}
+
+	for _, cred := range creds {
+		config.Auths[normalizeServer(cred.Registry)] = dockerAuth{
+			Username: cred.Username,
+			Password: cred.Password,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(cred.Username + ":" + cred.Password)),
+		}
+	}
+
+	data, err := json.Marshal(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal docker config: %w", err)
+	}
+
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: data,
+		},
+	}, nil
+}
+
+// AttachPullSecrets adds the named pull secrets to the pod spec (skipping
+// any that are already attached).
+func AttachPullSecrets(spec *corev1.PodSpec, names ...string) {
+	existing := make(map[string]bool)
+	for _, ref := range spec.ImagePullSecrets {
+		existing[ref.Name] = true
+	}
+
+	for _, name := range names {
+		if name == "" || existing[name] {
+			continue
+		}
+
+		existing[name] = true
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+}
+
+// CopyPullSecrets copies the named pull secrets from the source namespace
+// into the target namespace (eg. when children run in a different namespace
+// to the operator), returning the names of the copies. Copies are updated
+// whenever the source secrets change, and should be deleted with
+// DeletePullSecretCopies (or syncer.Cleanup) once no longer needed.
+func CopyPullSecrets(ctx context.Context, c client.Client, sourceNamespace string, names []string, targetNamespace string) ([]string, error) {
+	var copied []string
+	for _, name := range names {
+		if sourceNamespace == targetNamespace {
+			copied = append(copied, name)
+			continue
+		}
+
+		var source corev1.Secret
+		if err := c.Get(ctx, client.ObjectKey{Namespace: sourceNamespace, Name: name}, &source); err != nil {
+			return nil, fmt.Errorf("failed to get pull secret %q: %w", name, err)
+		}
+
+		if source.Type != corev1.SecretTypeDockerConfigJson && source.Type != corev1.SecretTypeDockercfg {
+			return nil, fmt.Errorf("secret %q is not an image pull secret", name)
+		}
+
+		// Secrets that were not copied by us are never overwritten.
+		if _, err := syncer.Copy(ctx, c, &source, targetNamespace); err != nil {
+			return nil, fmt.Errorf("failed to copy pull secret %q: %w", name, err)
+		}
+
+		copied = append(copied, name)
+	}
+
+	return copied, nil
+}
+
+// DeletePullSecretCopies deletes the copies of the named pull secrets from
+// the target namespace (see CopyPullSecrets). Secrets that were not copied
+// by us are left untouched.
+func DeletePullSecretCopies(ctx context.Context, c client.Client, sourceNamespace string, names []string, targetNamespace string) error {
+	if sourceNamespace == targetNamespace {
+		return nil
+	}
+
+	for _, name := range names {
+		source := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: sourceNamespace, Name: name}}
+		if err := syncer.Delete(ctx, c, source, targetNamespace); err != nil {
+			return fmt.Errorf("failed to delete copy of pull secret %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package images
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ResolveDigest resolves the tag of an image to a digest using the registry
+// API, authenticating with the given pull secrets (if any). The returned
+// reference is pinned to the digest. Images already pinned to a digest are
+// returned as is.
+func ResolveDigest(ctx context.Context, image string, pullSecrets []corev1.Secret, opts ...remote.Option) (string, error) {
+	ref, err := Parse(image)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.Digest != "" {
+		return ref.String(), nil
+	}
+
+	keychain, err := KeychainFromSecrets(pullSecrets)
+	if err != nil {
+		return "", err
+	}
+
+	tag, err := name.NewTag(ref.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image %q: %w", image, err)
+	}
+
+	opts = append([]remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain)}, opts...)
+
+	desc, err := remote.Head(tag, opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve digest of %q: %w", image, err)
+	}
+
+	return ref.WithDigest(desc.Digest.String()).String(), nil
+}
+
+// dockerConfig is the format of a .dockerconfigjson (and the auths section of a .dockercfg).
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// KeychainFromSecrets returns a keychain using the credentials in the given
+// image pull secrets (of type kubernetes.io/dockerconfigjson or
+// kubernetes.io/dockercfg).
+func KeychainFromSecrets(secrets []corev1.Secret) (authn.Keychain, error) {
+	keychain := make(secretKeychain)
+
+	for i := range secrets {
+		auths, err := parseAuths(&secrets[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for server, auth := range auths {
+			config := authn.AuthConfig{Username: auth.Username, Password: auth.Password}
+			if auth.Auth != "" && config.Username == "" {
+				decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode auth for %q in pull secret %q: %w", server, secrets[i].Name, err)
+				}
+
+				config.Username, config.Password, _ = strings.Cut(string(decoded), ":")
+			}
+
+			// The first secret with credentials for a registry wins (as with the kubelet).
+			registry := normalizeServer(server)
+			if _, ok := keychain[registry]; !ok {
+				keychain[registry] = config
+			}
+		}
+	}
+
+	return keychain, nil
+}
+
+// parseAuths returns the credentials in an image pull secret, secrets of
+// other types have no credentials.
+func parseAuths(secret *corev1.Secret) (map[string]dockerAuth, error) {
+	var auths map[string]dockerAuth
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		var config dockerConfig
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+			return nil, fmt.Errorf("failed to parse pull secret %q: %w", secret.Name, err)
+		}
+		auths = config.Auths
+	case corev1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &auths); err != nil {
+			return nil, fmt.Errorf("failed to parse pull secret %q: %w", secret.Name, err)
+		}
+	}
+
+	return auths, nil
+}
+
+type secretKeychain map[string]authn.AuthConfig
+
+func (k secretKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if config, ok := k[normalizeServer(target.RegistryStr())]; ok {
+		return authn.FromConfig(config), nil
+	}
+
+	return authn.Anonymous, nil
+}
+
+// normalizeServer normalizes a docker config server (which may be a URL) to a registry host.
+func normalizeServer(server string) string {
+	server = strings.TrimPrefix(server, "https://")
+	server = strings.TrimPrefix(server, "http://")
+	server, _, _ = strings.Cut(server, "/")
+
+	switch server {
+	case "index.docker.io", "registry-1.docker.io", "docker.io":
+		return name.DefaultRegistry
+	}
+
+	return server
+}
@@ -0,0 +1,239 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package impersonation provides a factory for clients that impersonate
+// tenant users or service accounts, so that the API server enforces the
+// tenant's own RBAC on operations performed on their behalf.
+package impersonation
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	// DefaultMaxClients is the default maximum number of cached clients.
+	DefaultMaxClients = 128
+)
+
+// Identity is the user (or service account) to impersonate.
+type Identity struct {
+	// UserName is the user to impersonate.
+	UserName string
+	// UID is the UID of the user to impersonate.
+	UID string
+	// Groups are the groups to impersonate.
+	Groups []string
+	// Extra is additional information about the user to impersonate.
+	Extra map[string][]string
+}
+
+// User returns the identity of a user.
+func User(name string, groups ...string) Identity {
+	return Identity{UserName: name, Groups: groups}
+}
+
+// ServiceAccount returns the identity of a service account (including the
+// groups the API server would assign it).
+func ServiceAccount(namespace, name string) Identity {
+	return Identity{
+		UserName: fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name),
+		Groups: []string{
+			"system:serviceaccounts",
+			"system:serviceaccounts:" + namespace,
+			"system:authenticated",
+		},
+	}
+}
+
+// String returns a stable string representation of the identity.
+func (id Identity) String() string {
+	var sb strings.Builder
+	sb.WriteString(id.UserName)
+
+	if id.UID != "" {
+		sb.WriteString("|uid=" + id.UID)
+	}
+
+	groups := append([]string(nil), id.Groups...)
+	sort.Strings(groups)
+	for _, group := range groups {
+		sb.WriteString("|group=" + group)
+	}
+
+	keys := make([]string, 0, len(id.Extra))
+	for key := range id.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := append([]string(nil), id.Extra[key]...)
+		sort.Strings(values)
+		sb.WriteString("|" + key + "=" + strings.Join(values, ","))
+	}
+
+	return sb.String()
+}
+
+// Options configures a client factory.
+type Options struct {
+	// Scheme is the scheme used by clients. Defaults to the client-go scheme.
+	Scheme *runtime.Scheme
+	// Mapper is the REST mapper shared by clients. Defaults to a dynamic REST
+	// mapper for the base config (using the operator's own identity).
+	Mapper meta.RESTMapper
+	// MaxClients is the maximum number of cached clients (least recently
+	// used clients are evicted). Defaults to DefaultMaxClients.
+	MaxClients int
+}
+
+// Factory builds (and caches) clients that impersonate identities. All the
+// clients share a single rate limiter (derived from the base config's QPS
+// and burst), so that impersonated requests can't exceed the operator's
+// overall client side rate limit.
+type Factory struct {
+	base       *rest.Config
+	scheme     *runtime.Scheme
+	mapper     meta.RESTMapper
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	lru     *list.List
+}
+
+type cachedClient struct {
+	key    string
+	client client.Client
+}
+
+// NewFactory returns a new impersonating client factory.
+func NewFactory(cfg *rest.Config, opts Options) (*Factory, error) {
+	base := rest.CopyConfig(cfg)
+
+	if base.RateLimiter == nil {
+		qps, burst := base.QPS, base.Burst
+		if qps == 0 {
+			qps = rest.DefaultQPS
+		}
+		if burst == 0 {
+			burst = rest.DefaultBurst
+		}
+
+		base.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	}
+
+	if opts.Scheme == nil {
+		opts.Scheme = scheme.Scheme
+	}
+
+	if opts.Mapper == nil {
+		httpClient, err := rest.HTTPClientFor(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create http client: %w", err)
+		}
+
+		opts.Mapper, err = apiutil.NewDynamicRESTMapper(cfg, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rest mapper: %w", err)
+		}
+	}
+
+	if opts.MaxClients == 0 {
+		opts.MaxClients = DefaultMaxClients
+	}
+
+	return &Factory{
+		base:       base,
+		scheme:     opts.Scheme,
+		mapper:     opts.Mapper,
+		maxClients: opts.MaxClients,
+		clients:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}, nil
+}
+
+// ConfigFor returns a copy of the base config that impersonates the identity.
+func (f *Factory) ConfigFor(id Identity) *rest.Config {
+	cfg := rest.CopyConfig(f.base)
+	cfg.Impersonate = rest.ImpersonationConfig{
+		UserName: id.UserName,
+		UID:      id.UID,
+		Groups:   append([]string(nil), id.Groups...),
+		Extra:    id.Extra,
+	}
+
+	return cfg
+}
+
+// ClientFor returns a client that impersonates the identity.
+func (f *Factory) ClientFor(id Identity) (client.Client, error) {
+	if id.UserName == "" {
+		return nil, fmt.Errorf("identity must have a user name")
+	}
+
+	key := id.String()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if elem, ok := f.clients[key]; ok {
+		f.lru.MoveToFront(elem)
+		return elem.Value.(*cachedClient).client, nil
+	}
+
+	c, err := client.New(f.ConfigFor(id), client.Options{
+		Scheme: f.scheme,
+		Mapper: f.mapper,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for %q: %w", id.UserName, err)
+	}
+
+	f.clients[key] = f.lru.PushFront(&cachedClient{key: key, client: c})
+
+	for f.lru.Len() > f.maxClients {
+		oldest := f.lru.Back()
+		f.lru.Remove(oldest)
+		delete(f.clients, oldest.Value.(*cachedClient).key)
+	}
+
+	return c, nil
+}
+
+// Forget removes the identity's client from the cache (eg. when a tenant is deleted).
+func (f *Factory) Forget(id Identity) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := id.String()
+	if elem, ok := f.clients[key]; ok {
+		f.lru.Remove(elem)
+		delete(f.clients, key)
+	}
+}
@@ -0,0 +1,109 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package impersonation_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/impersonation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestFactory(t *testing.T) {
+	var mu sync.Mutex
+	var users []string
+	var groups [][]string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		users = append(users, r.Header.Get("Impersonate-User"))
+		groups = append(groups, r.Header.Values("Impersonate-Group"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "config"},
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+
+	f, err := impersonation.NewFactory(&rest.Config{Host: srv.URL}, impersonation.Options{
+		Mapper:     mapper,
+		MaxClients: 2,
+	})
+	require.NoError(t, err)
+
+	id := impersonation.ServiceAccount("tenant-a", "deployer")
+
+	c, err := f.ClientFor(id)
+	require.NoError(t, err)
+
+	var cm corev1.ConfigMap
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "tenant-a", Name: "config"}, &cm))
+
+	assert.Equal(t, []string{"system:serviceaccount:tenant-a:deployer"}, users)
+	assert.Equal(t, [][]string{{"system:serviceaccounts", "system:serviceaccounts:tenant-a", "system:authenticated"}}, groups)
+
+	t.Run("Cached", func(t *testing.T) {
+		cached, err := f.ClientFor(impersonation.ServiceAccount("tenant-a", "deployer"))
+		require.NoError(t, err)
+		assert.Same(t, c, cached)
+
+		_, err = f.ClientFor(impersonation.User("alice"))
+		require.NoError(t, err)
+		_, err = f.ClientFor(impersonation.User("bob"))
+		require.NoError(t, err)
+
+		// The least recently used client was evicted.
+		evicted, err := f.ClientFor(id)
+		require.NoError(t, err)
+		assert.NotSame(t, c, evicted)
+	})
+
+	t.Run("Shared Rate Limiter", func(t *testing.T) {
+		alice := f.ConfigFor(impersonation.User("alice"))
+		bob := f.ConfigFor(impersonation.User("bob"))
+
+		require.NotNil(t, alice.RateLimiter)
+		assert.Same(t, alice.RateLimiter, bob.RateLimiter)
+	})
+
+	t.Run("Identity", func(t *testing.T) {
+		a := impersonation.Identity{UserName: "alice", Groups: []string{"b", "a"}, Extra: map[string][]string{"scope": {"x"}}}
+		b := impersonation.Identity{UserName: "alice", Groups: []string{"a", "b"}, Extra: map[string][]string{"scope": {"x"}}}
+		assert.Equal(t, a.String(), b.String())
+
+		_, err := f.ClientFor(impersonation.Identity{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,319 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package jobs provides helpers for running one-shot (eg. migration or
+// maintenance) Jobs to completion.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/updater"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// JobNameLabel is the label set by the Job controller on a Job's pods.
+	JobNameLabel = "job-name"
+)
+
+var (
+	// ErrJobRunning is returned when a job with a different spec is still running.
+	ErrJobRunning = errors.New("job with a different spec is still running")
+	// ErrJobDeleting is returned (wrapped in a retryable error) while a
+	// previous job is being deleted.
+	ErrJobDeleting = errors.New("waiting for previous job to be deleted")
+	// ErrTimeout is returned when a job does not finish within the timeout.
+	ErrTimeout = errors.New("timed out waiting for job")
+)
+
+// FailedError is returned when a job fails.
+type FailedError struct {
+	// Reason is the reason of the job's failed condition.
+	Reason string
+	// Message is the message of the job's failed condition.
+	Message string
+	// Logs are the (tail of the) logs of the job's most recent pod.
+	Logs string
+}
+
+func (e *FailedError) Error() string {
+	msg := fmt.Sprintf("job failed: %s: %s", e.Reason, e.Message)
+	if logs := strings.TrimSpace(e.Logs); logs != "" {
+		msg += "\n" + logs
+	}
+
+	return msg
+}
+
+// Options configures how jobs are run.
+type Options struct {
+	// TTLSecondsAfterFinished is set on jobs that don't specify their own,
+	// so that finished jobs are cleaned up. Defaults to 300 (5 minutes).
+	// Once a job has been cleaned up, applying it again will run it again.
+	TTLSecondsAfterFinished *int32
+	// Timeout is the maximum time to wait for a job to finish. Defaults to 10 minutes.
+	Timeout time.Duration
+	// PollInterval is the interval between job status checks. Defaults to 2 seconds.
+	PollInterval time.Duration
+	// LogClient is used to capture pod logs. If nil, logs are not captured.
+	LogClient kubernetes.Interface
+	// LogTailLines is the number of lines of logs to capture from each container.
+	// Defaults to 50.
+	LogTailLines int64
+}
+
+// Result is the result of a successful job.
+type Result struct {
+	// Job is the finished job.
+	Job *batchv1.Job
+	// Logs are the (tail of the) logs of the job's most recent pod.
+	Logs string
+}
+
+// FromTemplate builds a job that runs the given pod template once.
+func FromTemplate(key types.NamespacedName, template corev1.PodTemplateSpec) *batchv1.Job {
+	return FromSpec(key, batchv1.JobSpec{
+		BackoffLimit: ptr.To(int32(0)),
+		Template:     template,
+	})
+}
+
+// FromSpec builds a job from the given spec. The restart policy of the
+// pod template defaults to Never.
+func FromSpec(key types.NamespacedName, spec batchv1.JobSpec) *batchv1.Job {
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.String(),
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+		},
+		Spec: *spec.DeepCopy(),
+	}
+
+	if job.Spec.Template.Spec.RestartPolicy == "" {
+		job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+
+	return job
+}
+
+// Run applies the job and waits for it to finish. Jobs are only remembered
+// until they are cleaned up (see Options.TTLSecondsAfterFinished), after
+// which Run will execute the job again. Callers that must only run a job
+// once should record its completion (eg. the revision in their status) and
+// not call Run again.
+func Run(ctx context.Context, c client.Client, job *batchv1.Job, opts Options) (*Result, error) {
+	applied, err := Apply(ctx, c, job, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return Wait(ctx, c, client.ObjectKeyFromObject(applied), opts)
+}
+
+// Apply creates the job (or returns the existing job if it is unchanged).
+// As a job's pod template is immutable, a finished job with a different
+// spec is deleted (returning a retryable ErrJobDeleting until it is gone,
+// after which it is recreated), and ErrJobRunning is returned if it is
+// still running.
+func Apply(ctx context.Context, c client.Client, job *batchv1.Job, opts Options) (*batchv1.Job, error) {
+	setDefaults(&opts)
+
+	job = job.DeepCopy()
+	if job.Spec.TTLSecondsAfterFinished == nil {
+		job.Spec.TTLSecondsAfterFinished = opts.TTLSecondsAfterFinished
+	}
+
+	var existing batchv1.Job
+	if err := c.Get(ctx, client.ObjectKeyFromObject(job), &existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get job: %w", err)
+		}
+	} else {
+		if existing.DeletionTimestamp != nil {
+			return nil, retryable.New(fmt.Errorf("%w: %s", ErrJobDeleting, existing.Name))
+		}
+
+		existingHash, err := updater.GetHash(&existing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hash from job: %w", err)
+		}
+
+		if existingHash != updater.HashObject(job) {
+			if finished, _ := IsFinished(&existing); !finished {
+				return nil, ErrJobRunning
+			}
+
+			if err := c.Delete(ctx, &existing, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to delete finished job: %w", err)
+			}
+
+			return nil, retryable.New(fmt.Errorf("%w: %s", ErrJobDeleting, existing.Name))
+		}
+	}
+
+	obj, err := updater.CreateOrUpdateFromTemplate(ctx, c, job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply job: %w", err)
+	}
+
+	return obj.(*batchv1.Job), nil
+}
+
+// Wait waits for the job to finish. If the job fails a *FailedError is
+// returned, and if it does not finish within the timeout ErrTimeout is
+// returned. Logs are captured on a best effort basis.
+func Wait(ctx context.Context, c client.Client, key types.NamespacedName, opts Options) (*Result, error) {
+	setDefaults(&opts)
+
+	var job batchv1.Job
+	var failed *batchv1.JobCondition
+	err := wait.PollUntilContextTimeout(ctx, opts.PollInterval, opts.Timeout, true, func(ctx context.Context) (bool, error) {
+		if err := c.Get(ctx, key, &job); err != nil {
+			return false, fmt.Errorf("failed to get job: %w", err)
+		}
+
+		var finished bool
+		finished, failed = IsFinished(&job)
+		return finished, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) && ctx.Err() == nil {
+			return nil, fmt.Errorf("%w: %s", ErrTimeout, key)
+		}
+
+		return nil, err
+	}
+
+	logs, err := captureLogs(ctx, c, &job, &opts)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to capture job logs", "job", key)
+	}
+
+	if failed != nil {
+		return nil, &FailedError{
+			Reason:  failed.Reason,
+			Message: failed.Message,
+			Logs:    logs,
+		}
+	}
+
+	return &Result{Job: &job, Logs: logs}, nil
+}
+
+// IsFinished returns whether the job has finished, and if it failed, the
+// failed condition.
+func IsFinished(job *batchv1.Job) (bool, *batchv1.JobCondition) {
+	for i := range job.Status.Conditions {
+		cond := &job.Status.Conditions[i]
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return true, cond
+		}
+	}
+
+	return false, nil
+}
+
+func captureLogs(ctx context.Context, c client.Client, job *batchv1.Job, opts *Options) (string, error) {
+	if opts.LogClient == nil {
+		return "", nil
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{JobNameLabel: job.Name}); err != nil {
+		return "", fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	if len(pods.Items) == 0 {
+		return "", nil
+	}
+
+	// The most recent pod is the most relevant (eg. the final failed attempt).
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[j].CreationTimestamp.Before(&pods.Items[i].CreationTimestamp)
+	})
+	pod := &pods.Items[0]
+
+	var sb strings.Builder
+	for _, container := range pod.Spec.Containers {
+		stream, err := opts.LogClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: container.Name,
+			TailLines: ptr.To(opts.LogTailLines),
+		}).Stream(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to get logs of container %q: %w", container.Name, err)
+		}
+
+		logs, err := io.ReadAll(stream)
+		_ = stream.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read logs of container %q: %w", container.Name, err)
+		}
+
+		if len(pod.Spec.Containers) > 1 {
+			fmt.Fprintf(&sb, "==> %s <==\n", container.Name)
+		}
+		sb.Write(logs)
+	}
+
+	return sb.String(), nil
+}
+
+func setDefaults(opts *Options) {
+	if opts.TTLSecondsAfterFinished == nil {
+		opts.TTLSecondsAfterFinished = ptr.To(int32(300))
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Minute
+	}
+
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+
+	if opts.LogTailLines == 0 {
+		opts.LogTailLines = 50
+	}
+}
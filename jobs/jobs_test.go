@@ -0,0 +1,151 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/jobs"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestJobs(t *testing.T) {
+	ctx := context.Background()
+
+	key := types.NamespacedName{Namespace: "default", Name: "migrate"}
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "migrate", Image: "migrate:v1"}},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "migrate-abcde",
+			Labels:    map[string]string{jobs.JobNameLabel: "migrate"},
+		},
+		Spec: template.Spec,
+	}
+
+	c := fake.NewClientBuilder().WithObjects(pod).WithStatusSubresource(&batchv1.Job{}).Build()
+
+	opts := jobs.Options{
+		Timeout:      time.Second,
+		PollInterval: 10 * time.Millisecond,
+		LogClient:    kubefake.NewSimpleClientset(pod),
+	}
+
+	job := jobs.FromTemplate(key, template)
+	assert.Equal(t, corev1.RestartPolicyNever, job.Spec.Template.Spec.RestartPolicy)
+
+	applied, err := jobs.Apply(ctx, c, job, opts)
+	require.NoError(t, err)
+	assert.Equal(t, int32(300), *applied.Spec.TTLSecondsAfterFinished)
+
+	t.Run("Timeout", func(t *testing.T) {
+		_, err := jobs.Wait(ctx, c, key, jobs.Options{Timeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond})
+		assert.ErrorIs(t, err, jobs.ErrTimeout)
+	})
+
+	t.Run("Still Running", func(t *testing.T) {
+		changed := jobs.FromTemplate(key, *template.DeepCopy())
+		changed.Spec.Template.Spec.Containers[0].Image = "migrate:v2"
+
+		_, err := jobs.Apply(ctx, c, changed, opts)
+		assert.ErrorIs(t, err, jobs.ErrJobRunning)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		setCondition(t, c, key, batchv1.JobFailed, "BackoffLimitExceeded")
+
+		_, err := jobs.Wait(ctx, c, key, opts)
+		require.Error(t, err)
+
+		var failedErr *jobs.FailedError
+		require.True(t, errors.As(err, &failedErr))
+		assert.Equal(t, "BackoffLimitExceeded", failedErr.Reason)
+		assert.Equal(t, "fake logs", failedErr.Logs)
+	})
+
+	t.Run("Recreate", func(t *testing.T) {
+		changed := jobs.FromTemplate(key, *template.DeepCopy())
+		changed.Spec.Template.Spec.Containers[0].Image = "migrate:v2"
+
+		// The finished job is deleted first.
+		_, err := jobs.Apply(ctx, c, changed, opts)
+		require.ErrorIs(t, err, jobs.ErrJobDeleting)
+		assert.True(t, retryable.Is(err))
+
+		applied, err := jobs.Apply(ctx, c, changed, opts)
+		require.NoError(t, err)
+		assert.Equal(t, "migrate:v2", applied.Spec.Template.Spec.Containers[0].Image)
+		assert.Empty(t, applied.Status.Conditions)
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		setCondition(t, c, key, batchv1.JobComplete, "")
+
+		res, err := jobs.Run(ctx, c, jobs.FromTemplate(key, func() corev1.PodTemplateSpec {
+			tmpl := template.DeepCopy()
+			tmpl.Spec.Containers[0].Image = "migrate:v2"
+			return *tmpl
+		}()), opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, "fake logs", res.Logs)
+	})
+
+	t.Run("Logs Unavailable", func(t *testing.T) {
+		c := interceptor.NewClient(c, interceptor.Funcs{
+			List: func(_ context.Context, _ client.WithWatch, _ client.ObjectList, _ ...client.ListOption) error {
+				return errors.New("unavailable")
+			},
+		})
+
+		res, err := jobs.Wait(ctx, c, key, opts)
+		require.NoError(t, err)
+		assert.Empty(t, res.Logs)
+	})
+}
+
+func setCondition(t *testing.T, c client.Client, key types.NamespacedName, condType batchv1.JobConditionType, reason string) {
+	var job batchv1.Job
+	require.NoError(t, c.Get(context.Background(), key, &job))
+
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type:   condType,
+		Status: corev1.ConditionTrue,
+		Reason: reason,
+	}}
+
+	require.NoError(t, c.Status().Update(context.Background(), &job))
+}
@@ -0,0 +1,215 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package leader provides runnables that only execute while this replica
+// holds leadership (eg. background sweepers).
+package leader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	DefaultHandoverTimeout = 10 * time.Second
+)
+
+// Task is a unit of work run by a leader-only runnable.
+type Task func(ctx context.Context) error
+
+// Option configures a leader-only runnable.
+type Option func(*Runnable)
+
+// WithOnStartedLeading sets a callback that is invoked when leadership is
+// acquired, before the task starts.
+func WithOnStartedLeading(fn func(ctx context.Context) error) Option {
+	return func(r *Runnable) {
+		r.onStartedLeading = fn
+	}
+}
+
+// WithOnStoppedLeading sets a callback that is invoked after the task
+// returns (eg. when leadership is lost, or the manager is shutting down),
+// so that any in-flight state can be handed over to the next leader.
+func WithOnStoppedLeading(fn func(ctx context.Context) error) Option {
+	return func(r *Runnable) {
+		r.onStoppedLeading = fn
+	}
+}
+
+// WithHandoverTimeout sets the maximum amount of time the stopped leading
+// callback can take.
+func WithHandoverTimeout(d time.Duration) Option {
+	return func(r *Runnable) {
+		r.handoverTimeout = d
+	}
+}
+
+// Runnable is a manager runnable that only runs while this replica holds leadership.
+type Runnable struct {
+	name             string
+	task             Task
+	onStartedLeading func(ctx context.Context) error
+	onStoppedLeading func(ctx context.Context) error
+	handoverTimeout  time.Duration
+}
+
+var (
+	_ manager.Runnable               = (*Runnable)(nil)
+	_ manager.LeaderElectionRunnable = (*Runnable)(nil)
+)
+
+// NewRunnable returns a new leader-only runnable.
+func NewRunnable(name string, task Task, opts ...Option) *Runnable {
+	r := &Runnable{
+		name:            name,
+		task:            task,
+		handoverTimeout: DefaultHandoverTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Add registers a leader-only task with the manager.
+func Add(mgr manager.Manager, name string, task Task, opts ...Option) error {
+	if err := mgr.Add(NewRunnable(name, task, opts...)); err != nil {
+		return fmt.Errorf("failed to add leader-only task %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// Start runs the task, it is called by the manager once leadership is acquired.
+func (r *Runnable) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("task", r.name)
+	ctx = log.IntoContext(ctx, logger)
+
+	logger.Info("Started leading")
+
+	if r.onStartedLeading != nil {
+		if err := r.onStartedLeading(ctx); err != nil {
+			return fmt.Errorf("failed to start leading task %q: %w", r.name, err)
+		}
+	}
+
+	taskErr := r.task(ctx)
+	if taskErr != nil {
+		taskErr = fmt.Errorf("leader-only task %q failed: %w", r.name, taskErr)
+	}
+
+	logger.Info("Stopped leading")
+
+	if r.onStoppedLeading != nil {
+		// The task context has likely been cancelled, so the handover gets
+		// its own (bounded) context.
+		handoverCtx, cancel := context.WithTimeout(log.IntoContext(context.Background(), logger), r.handoverTimeout)
+		defer cancel()
+
+		if err := r.onStoppedLeading(handoverCtx); err != nil && taskErr == nil {
+			taskErr = fmt.Errorf("failed to hand over task %q: %w", r.name, err)
+		}
+	}
+
+	return taskErr
+}
+
+// NeedLeaderElection returns true, so the manager only starts the runnable
+// once leadership has been acquired.
+func (r *Runnable) NeedLeaderElection() bool {
+	return true
+}
+
+// PeriodicOption configures a periodic task.
+type PeriodicOption func(*periodicOptions)
+
+type periodicOptions struct {
+	jitterFactor   float64
+	runImmediately bool
+	clock          clock.WithTicker
+}
+
+// WithJitter adds up to the given fraction of the interval as random jitter.
+func WithJitter(maxFactor float64) PeriodicOption {
+	return func(o *periodicOptions) {
+		o.jitterFactor = maxFactor
+	}
+}
+
+// WithRunImmediately runs the task immediately, rather than after the first interval.
+func WithRunImmediately() PeriodicOption {
+	return func(o *periodicOptions) {
+		o.runImmediately = true
+	}
+}
+
+// WithClock sets the clock used to schedule the task (useful for testing).
+func WithClock(clock clock.WithTicker) PeriodicOption {
+	return func(o *periodicOptions) {
+		o.clock = clock
+	}
+}
+
+// Periodic returns a task that runs the given task every interval until the
+// context is cancelled. Errors are logged, and do not stop the task.
+func Periodic(interval time.Duration, task Task, opts ...PeriodicOption) Task {
+	o := &periodicOptions{
+		clock: clock.RealClock{},
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(ctx context.Context) error {
+		logger := log.FromContext(ctx)
+
+		if o.runImmediately {
+			if err := task(ctx); err != nil {
+				logger.Error(err, "Periodic task failed")
+			}
+		}
+
+		for {
+			next := interval
+			if o.jitterFactor > 0 {
+				next = wait.Jitter(interval, o.jitterFactor)
+			}
+
+			timer := o.clock.NewTimer(next)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C():
+			}
+
+			if err := task(ctx); err != nil {
+				logger.Error(err, "Periodic task failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package leader_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/leader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestRunnable(t *testing.T) {
+	var events []string
+	r := leader.NewRunnable("sweeper", func(ctx context.Context) error {
+		events = append(events, "task")
+		<-ctx.Done()
+		return nil
+	},
+		leader.WithOnStartedLeading(func(ctx context.Context) error {
+			events = append(events, "started")
+			return nil
+		}),
+		leader.WithOnStoppedLeading(func(ctx context.Context) error {
+			require.NoError(t, ctx.Err())
+			events = append(events, "stopped")
+			return errors.New("handover failed")
+		}))
+
+	assert.True(t, r.NeedLeaderElection())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := r.Start(ctx)
+	require.ErrorContains(t, err, "handover failed")
+
+	assert.Equal(t, []string{"started", "task", "stopped"}, events)
+}
+
+func TestPeriodic(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Now())
+
+	var runs atomic.Int32
+	task := leader.Periodic(time.Minute, func(ctx context.Context) error {
+		runs.Add(1)
+		return errors.New("errors don't stop the task")
+	}, leader.WithJitter(0.1), leader.WithRunImmediately(), leader.WithClock(clock))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- task(ctx)
+	}()
+
+	require.Eventually(t, func() bool { return runs.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	for i := 2; i <= 3; i++ {
+		require.Eventually(t, clock.HasWaiters, time.Second, 10*time.Millisecond)
+		clock.Step(66 * time.Second)
+
+		want := int32(i)
+		require.Eventually(t, func() bool { return runs.Load() == want }, time.Second, 10*time.Millisecond)
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,214 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package locking
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// LeaseHolderAnnotation records the holder of an object lease.
+	LeaseHolderAnnotation = "gpu-ninja.com/lease-holder"
+	// LeaseExpiresAnnotation records when an object lease expires (in RFC 3339 format).
+	LeaseExpiresAnnotation = "gpu-ninja.com/lease-expires"
+	// LeaseTokenAnnotation records the fencing token of the most recent object lease.
+	LeaseTokenAnnotation = "gpu-ninja.com/lease-token"
+)
+
+// ObjectLease is a held lease on a shared object, recorded in the object's
+// annotations. Object leases are intended for brief periods of exclusive
+// ownership (eg. when two operators both need to mutate the same object),
+// and unlike locks, are not renewed in the background.
+type ObjectLease struct {
+	c       client.Client
+	holder  string
+	ttl     time.Duration
+	token   int64
+	expires time.Time
+	opts    *options
+}
+
+// ClaimObject claims a lease on the object, returning ErrLocked if it is
+// held by another holder. The object is refreshed from the API server (so
+// any unsaved changes are discarded), and is left with the lease recorded,
+// ready for the holder to mutate. Claiming a lease already held by the
+// same holder starts a new lease (with a new fencing token).
+func ClaimObject(ctx context.Context, c client.Client, obj client.Object, holder string, ttl time.Duration, opts ...Option) (*ObjectLease, error) {
+	o := newOptions(ttl, opts)
+
+	l := &ObjectLease{
+		c:      c,
+		holder: holder,
+		ttl:    ttl,
+		opts:   o,
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return fmt.Errorf("failed to get object: %w", err)
+		}
+
+		annotations := obj.GetAnnotations()
+		if currentHolder := annotations[LeaseHolderAnnotation]; currentHolder != "" && currentHolder != holder &&
+			o.clock.Now().Before(parseExpiry(annotations)) {
+			return fmt.Errorf("%w: %s", ErrLocked, currentHolder)
+		}
+
+		// Every claim increments the token, which is used as a fencing token.
+		token := parseToken(annotations) + 1
+		expires := o.clock.Now().Add(ttl)
+
+		if err := l.patch(ctx, obj, func(annotations map[string]string) {
+			annotations[LeaseHolderAnnotation] = holder
+			annotations[LeaseExpiresAnnotation] = expires.UTC().Format(time.RFC3339Nano)
+			annotations[LeaseTokenAnnotation] = strconv.FormatInt(token, 10)
+		}); err != nil {
+			return err
+		}
+
+		l.token = token
+		l.expires = expires
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Token returns the fencing token of the lease. The token increases
+// monotonically with each claim, so it can be used by downstream systems to
+// reject operations from previous holders.
+func (l *ObjectLease) Token() int64 {
+	return l.token
+}
+
+// Expires returns when the lease expires (unless renewed).
+func (l *ObjectLease) Expires() time.Time {
+	return l.expires
+}
+
+// Check returns ErrLockLost if the (locally cached) object no longer records
+// this lease, or the lease has expired. Updates to the object should be
+// made with its resource version, so that the check can't be raced.
+func (l *ObjectLease) Check(obj client.Object) error {
+	if !l.isHolder(obj) || !l.opts.clock.Now().Before(l.expires) {
+		return ErrLockLost
+	}
+
+	return nil
+}
+
+// Renew extends the lease by its ttl, refreshing the object.
+func (l *ObjectLease) Renew(ctx context.Context, obj client.Object) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := l.c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return fmt.Errorf("failed to get object: %w", err)
+		}
+
+		if err := l.Check(obj); err != nil {
+			return err
+		}
+
+		expires := l.opts.clock.Now().Add(l.ttl)
+		if err := l.patch(ctx, obj, func(annotations map[string]string) {
+			annotations[LeaseExpiresAnnotation] = expires.UTC().Format(time.RFC3339Nano)
+		}); err != nil {
+			return err
+		}
+
+		l.expires = expires
+
+		return nil
+	})
+}
+
+// Release releases the lease, refreshing the object. Releasing a lease that
+// is no longer held is a no-op.
+func (l *ObjectLease) Release(ctx context.Context, obj client.Object) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := l.c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to get object: %w", err)
+		}
+
+		if !l.isHolder(obj) {
+			return nil
+		}
+
+		// The token is kept so that it keeps increasing.
+		return l.patch(ctx, obj, func(annotations map[string]string) {
+			delete(annotations, LeaseHolderAnnotation)
+			delete(annotations, LeaseExpiresAnnotation)
+		})
+	})
+}
+
+// patch updates the object's lease annotations, guarded by its resource
+// version so that concurrent claims fail with a conflict.
+func (l *ObjectLease) patch(ctx context.Context, obj client.Object, mutate func(annotations map[string]string)) error {
+	patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+
+	annotations := make(map[string]string)
+	for k, v := range obj.GetAnnotations() {
+		annotations[k] = v
+	}
+	mutate(annotations)
+	obj.SetAnnotations(annotations)
+
+	if err := l.c.Patch(ctx, obj, patch); err != nil {
+		return fmt.Errorf("failed to patch object lease: %w", err)
+	}
+
+	return nil
+}
+
+func (l *ObjectLease) isHolder(obj client.Object) bool {
+	annotations := obj.GetAnnotations()
+	return annotations[LeaseHolderAnnotation] == l.holder && parseToken(annotations) == l.token
+}
+
+func parseExpiry(annotations map[string]string) time.Time {
+	expires, err := time.Parse(time.RFC3339Nano, annotations[LeaseExpiresAnnotation])
+	if err != nil {
+		return time.Time{}
+	}
+
+	return expires
+}
+
+func parseToken(annotations map[string]string) int64 {
+	token, err := strconv.ParseInt(annotations[LeaseTokenAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return token
+}
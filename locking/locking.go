@@ -0,0 +1,400 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package locking provides distributed locks built on coordination.k8s.io
+// Leases, for serializing operations that multiple controllers or operator
+// replicas must not perform concurrently (eg. database schema migrations).
+// It also provides short lived leases on shared objects, recorded in the
+// objects' own annotations.
+package locking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	// ErrLocked is returned when the lock is held by another holder.
+	ErrLocked = errors.New("lock is held by another holder")
+	// ErrLockLost is returned when a lock is no longer held (eg. it expired
+	// and was acquired by another holder).
+	ErrLockLost = errors.New("lock lost")
+)
+
+// Option configures a lock.
+type Option func(*options)
+
+type options struct {
+	retryPeriod time.Duration
+	renewPeriod time.Duration
+	clock       clock.WithTicker
+}
+
+// WithRetryPeriod sets how often to retry acquiring a held lock (defaults to ttl/4).
+func WithRetryPeriod(d time.Duration) Option {
+	return func(o *options) {
+		o.retryPeriod = d
+	}
+}
+
+// WithRenewPeriod sets how often the lock is renewed (defaults to ttl/3).
+func WithRenewPeriod(d time.Duration) Option {
+	return func(o *options) {
+		o.renewPeriod = d
+	}
+}
+
+// WithClock sets the clock used for expiry and renewal (useful for testing).
+func WithClock(clock clock.WithTicker) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// Lock is a held distributed lock.
+type Lock struct {
+	c      client.Client
+	name   types.NamespacedName
+	holder string
+	ttl    time.Duration
+	token  int32
+	opts   *options
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// AcquireLock acquires the named lock, blocking until it is acquired or the
+// context is cancelled. The lock is renewed in the background until it is
+// released. If the lock can't be renewed, the lock's context is cancelled a
+// safety margin (the renew period) before the lock expires.
+func AcquireLock(ctx context.Context, c client.Client, name types.NamespacedName, holder string, ttl time.Duration, opts ...Option) (*Lock, error) {
+	o := newOptions(ttl, opts)
+
+	ticker := o.clock.NewTicker(o.retryPeriod)
+	defer ticker.Stop()
+
+	for {
+		lock, err := TryAcquireLock(ctx, c, name, holder, ttl, opts...)
+		if err == nil {
+			return lock, nil
+		}
+
+		if !errors.Is(err, ErrLocked) && !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to acquire lock %s: %w", name, ctx.Err())
+		case <-ticker.C():
+		}
+	}
+}
+
+// TryAcquireLock attempts to acquire the named lock, returning ErrLocked if
+// it is held by another holder.
+func TryAcquireLock(ctx context.Context, c client.Client, name types.NamespacedName, holder string, ttl time.Duration, opts ...Option) (*Lock, error) {
+	o := newOptions(ttl, opts)
+
+	now := metav1.NewMicroTime(o.clock.Now())
+	ttlSeconds := int32(ttl.Round(time.Second) / time.Second)
+	if ttlSeconds < 1 {
+		ttlSeconds = 1
+	}
+
+	var lease coordinationv1.Lease
+	if err := c.Get(ctx, name, &lease); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get lease: %w", err)
+		}
+
+		lease = coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: name.Namespace,
+				Name:      name.Name,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &ttlSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+				LeaseTransitions:     new(int32),
+			},
+		}
+
+		if err := c.Create(ctx, &lease); err != nil {
+			return nil, fmt.Errorf("failed to create lease: %w", err)
+		}
+
+		return newLock(ctx, c, name, holder, ttl, 0, now.Time, o), nil
+	}
+
+	if isHeld(&lease, o.clock.Now()) && (lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder) {
+		return nil, fmt.Errorf("%w: %s", ErrLocked, ptrValue(lease.Spec.HolderIdentity))
+	}
+
+	// Every acquisition increments the transition count, which is used as a
+	// fencing token.
+	token := ptrValue(lease.Spec.LeaseTransitions) + 1
+
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &ttlSeconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseTransitions = &token
+
+	// The update is guarded by the resource version, so concurrent
+	// acquisitions will fail with a conflict.
+	if err := c.Update(ctx, &lease); err != nil {
+		return nil, fmt.Errorf("failed to update lease: %w", err)
+	}
+
+	return newLock(ctx, c, name, holder, ttl, token, now.Time, o), nil
+}
+
+// Token returns the fencing token of the lock. The token increases
+// monotonically with each acquisition, so it can be used by downstream
+// systems to reject operations from previous holders.
+func (l *Lock) Token() int32 {
+	return l.token
+}
+
+// Context returns a context that is cancelled when the lock is lost or released.
+func (l *Lock) Context() context.Context {
+	return l.ctx
+}
+
+// Check returns an error if the lock is no longer held, it should be called
+// before performing any operations that require the lock.
+func (l *Lock) Check(ctx context.Context) error {
+	if err := context.Cause(l.ctx); err != nil {
+		return err
+	}
+
+	var lease coordinationv1.Lease
+	if err := l.c.Get(ctx, l.name, &lease); err != nil {
+		return fmt.Errorf("failed to get lease: %w", err)
+	}
+
+	if !l.isHolder(&lease) {
+		return ErrLockLost
+	}
+
+	return nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release(ctx context.Context) error {
+	l.stop(context.Canceled)
+
+	var lease coordinationv1.Lease
+	if err := l.c.Get(ctx, l.name, &lease); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to get lease: %w", err)
+	}
+
+	if !l.isHolder(&lease) {
+		return nil
+	}
+
+	// Expire the lease (rather than deleting it) so that the fencing token
+	// keeps increasing.
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = nil
+	lease.Spec.AcquireTime = nil
+
+	if err := l.c.Update(ctx, &lease); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+
+	return nil
+}
+
+func newLock(ctx context.Context, c client.Client, name types.NamespacedName, holder string, ttl time.Duration, token int32, renewedAt time.Time, o *options) *Lock {
+	// The lock outlives the acquiring context (but keeps its logger).
+	lockCtx, cancel := context.WithCancelCause(log.IntoContext(context.Background(), log.FromContext(ctx)))
+
+	l := &Lock{
+		c:      c,
+		name:   name,
+		holder: holder,
+		ttl:    ttl,
+		token:  token,
+		opts:   o,
+		ctx:    lockCtx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	// The ticker is created before the renewal goroutine starts, so that no
+	// renewals are missed.
+	go l.renew(o.clock.NewTicker(o.renewPeriod), renewedAt)
+
+	return l
+}
+
+func (l *Lock) renew(ticker clock.Ticker, renewedAt time.Time) {
+	logger := log.FromContext(l.ctx).WithValues("lock", l.name)
+
+	defer ticker.Stop()
+
+	deadline := renewedAt.Add(l.validity())
+	expiry := l.opts.clock.NewTimer(deadline.Sub(l.opts.clock.Now()))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-expiry.C():
+			l.stop(fmt.Errorf("%w: failed to renew before expiry", ErrLockLost))
+			return
+		case <-ticker.C():
+		}
+
+		renewedAt, err := l.renewOnce(deadline)
+		if err == nil {
+			deadline = renewedAt.Add(l.validity())
+
+			if !expiry.Stop() {
+				select {
+				case <-expiry.C():
+				default:
+				}
+			}
+			expiry.Reset(deadline.Sub(l.opts.clock.Now()))
+
+			continue
+		}
+
+		if errors.Is(err, ErrLockLost) {
+			logger.Info("Lock lost")
+			l.stop(ErrLockLost)
+			return
+		}
+
+		logger.Error(err, "Failed to renew lock")
+	}
+}
+
+// validity is how long the lock is considered held after it was renewed. It
+// is a safety margin shorter than the ttl, so that work guarded by the lock's
+// context stops before another holder can acquire the lock.
+func (l *Lock) validity() time.Duration {
+	margin := l.opts.renewPeriod
+	if margin > l.ttl/2 {
+		margin = l.ttl / 2
+	}
+
+	return l.ttl - margin
+}
+
+// renewOnce renews the lease (giving up at the deadline), returning the
+// renew time.
+func (l *Lock) renewOnce(deadline time.Time) (time.Time, error) {
+	timeout := l.opts.renewPeriod
+	if untilDeadline := deadline.Sub(l.opts.clock.Now()); untilDeadline < timeout {
+		timeout = untilDeadline
+	}
+
+	ctx, cancel := context.WithTimeout(l.ctx, timeout)
+	defer cancel()
+
+	var lease coordinationv1.Lease
+	if err := l.c.Get(ctx, l.name, &lease); err != nil {
+		if apierrors.IsNotFound(err) {
+			return time.Time{}, ErrLockLost
+		}
+
+		return time.Time{}, fmt.Errorf("failed to get lease: %w", err)
+	}
+
+	if !l.isHolder(&lease) {
+		return time.Time{}, ErrLockLost
+	}
+
+	now := metav1.NewMicroTime(l.opts.clock.Now())
+	lease.Spec.RenewTime = &now
+
+	if err := l.c.Update(ctx, &lease); err != nil {
+		return time.Time{}, fmt.Errorf("failed to renew lease: %w", err)
+	}
+
+	return now.Time, nil
+}
+
+func (l *Lock) isHolder(lease *coordinationv1.Lease) bool {
+	return ptrValue(lease.Spec.HolderIdentity) == l.holder && ptrValue(lease.Spec.LeaseTransitions) == l.token
+}
+
+func (l *Lock) stop(cause error) {
+	l.once.Do(func() {
+		close(l.done)
+		l.cancel(cause)
+	})
+}
+
+func newOptions(ttl time.Duration, opts []Option) *options {
+	o := &options{
+		retryPeriod: ttl / 4,
+		renewPeriod: ttl / 3,
+		clock:       clock.RealClock{},
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// isHeld returns true if the lease is held and has not expired.
+func isHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	if ptrValue(lease.Spec.HolderIdentity) == "" || lease.Spec.RenewTime == nil {
+		return false
+	}
+
+	expiry := lease.Spec.RenewTime.Add(time.Duration(ptrValue(lease.Spec.LeaseDurationSeconds)) * time.Second)
+
+	return now.Before(expiry)
+}
+
+func ptrValue[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+
+	return v
+}
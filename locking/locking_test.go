@@ -0,0 +1,197 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package locking_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/locking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestLocking(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, coordinationv1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	name := types.NamespacedName{Namespace: "default", Name: "schema-migration"}
+	ttl := 30 * time.Second
+
+	t.Run("Exclusive", func(t *testing.T) {
+		clock := clocktesting.NewFakeClock(time.Now())
+
+		a, err := locking.TryAcquireLock(ctx, c, name, "a", ttl, locking.WithClock(clock))
+		require.NoError(t, err)
+		assert.Equal(t, int32(0), a.Token())
+
+		_, err = locking.TryAcquireLock(ctx, c, name, "b", ttl, locking.WithClock(clock))
+		require.ErrorIs(t, err, locking.ErrLocked)
+
+		require.NoError(t, a.Release(ctx))
+		assert.Error(t, a.Context().Err())
+
+		b, err := locking.TryAcquireLock(ctx, c, name, "b", ttl, locking.WithClock(clock))
+		require.NoError(t, err)
+		assert.Greater(t, b.Token(), a.Token())
+
+		require.NoError(t, b.Check(ctx))
+		require.NoError(t, b.Release(ctx))
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		// Renewal is effectively disabled so that the lock expires.
+		clockA := clocktesting.NewFakeClock(time.Now())
+		a, err := locking.TryAcquireLock(ctx, c, name, "a", ttl, locking.WithClock(clockA))
+		require.NoError(t, err)
+
+		clockB := clocktesting.NewFakeClock(clockA.Now().Add(time.Minute))
+		b, err := locking.AcquireLock(ctx, c, name, "b", ttl, locking.WithClock(clockB))
+		require.NoError(t, err)
+		assert.Greater(t, b.Token(), a.Token())
+
+		require.ErrorIs(t, a.Check(ctx), locking.ErrLockLost)
+
+		// The next renewal notices the lock has been lost.
+		clockA.Step(ttl)
+		require.Eventually(t, func() bool {
+			return a.Context().Err() != nil
+		}, time.Second, 10*time.Millisecond)
+		assert.ErrorIs(t, context.Cause(a.Context()), locking.ErrLockLost)
+
+		require.NoError(t, b.Check(ctx))
+		require.NoError(t, b.Release(ctx))
+	})
+
+	t.Run("Renewal", func(t *testing.T) {
+		clock := clocktesting.NewFakeClock(time.Now())
+		a, err := locking.TryAcquireLock(ctx, c, name, "a", ttl, locking.WithClock(clock))
+		require.NoError(t, err)
+
+		var lease coordinationv1.Lease
+		require.NoError(t, c.Get(ctx, name, &lease))
+		acquired := lease.Spec.RenewTime.Time
+
+		clock.Step(ttl / 3)
+		require.Eventually(t, func() bool {
+			require.NoError(t, c.Get(ctx, name, &lease))
+			return lease.Spec.RenewTime.Time.After(acquired)
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, a.Check(ctx))
+		require.NoError(t, a.Release(ctx))
+	})
+
+	t.Run("Renewal Failure", func(t *testing.T) {
+		c := interceptor.NewClient(c, interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if lease, ok := obj.(*coordinationv1.Lease); ok && lease.Spec.AcquireTime != nil && lease.Spec.RenewTime.After(lease.Spec.AcquireTime.Time) {
+					return errors.New("unavailable")
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		})
+
+		clock := clocktesting.NewFakeClock(time.Now())
+		a, err := locking.TryAcquireLock(ctx, c, name, "a", ttl, locking.WithClock(clock))
+		require.NoError(t, err)
+
+		clock.Step(ttl / 3)
+		require.Never(t, func() bool {
+			return a.Context().Err() != nil
+		}, 100*time.Millisecond, 10*time.Millisecond)
+
+		// The lock is given up before the lease expires.
+		clock.Step(ttl / 3)
+		require.Eventually(t, func() bool {
+			return a.Context().Err() != nil
+		}, time.Second, 10*time.Millisecond)
+		assert.ErrorIs(t, context.Cause(a.Context()), locking.ErrLockLost)
+
+		require.NoError(t, a.Release(ctx))
+	})
+}
+
+func TestObjectLease(t *testing.T) {
+	ctx := context.Background()
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "shared"}}
+	c := fake.NewClientBuilder().WithObjects(cm).Build()
+
+	clock := clocktesting.NewFakeClock(time.Now())
+	ttl := 30 * time.Second
+
+	objA := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "shared"}}
+	a, err := locking.ClaimObject(ctx, c, objA, "operator-a", ttl, locking.WithClock(clock))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), a.Token())
+	assert.Equal(t, "operator-a", objA.Annotations[locking.LeaseHolderAnnotation])
+	require.NoError(t, a.Check(objA))
+
+	// The holder can mutate the object.
+	objA.Data = map[string]string{"owner": "a"}
+	require.NoError(t, c.Update(ctx, objA))
+
+	objB := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "shared"}}
+	_, err = locking.ClaimObject(ctx, c, objB, "operator-b", ttl, locking.WithClock(clock))
+	require.ErrorIs(t, err, locking.ErrLocked)
+
+	clock.Step(20 * time.Second)
+	require.NoError(t, a.Renew(ctx, objA))
+	assert.Equal(t, "a", objA.Data["owner"])
+
+	clock.Step(20 * time.Second)
+	_, err = locking.ClaimObject(ctx, c, objB, "operator-b", ttl, locking.WithClock(clock))
+	require.ErrorIs(t, err, locking.ErrLocked)
+
+	require.NoError(t, a.Release(ctx, objA))
+	assert.NotContains(t, objA.Annotations, locking.LeaseHolderAnnotation)
+
+	b, err := locking.ClaimObject(ctx, c, objB, "operator-b", ttl, locking.WithClock(clock))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), b.Token())
+
+	// The previous holder's lease is fenced off.
+	assert.ErrorIs(t, a.Renew(ctx, objA), locking.ErrLockLost)
+	assert.ErrorIs(t, a.Check(objB), locking.ErrLockLost)
+
+	t.Run("Expired", func(t *testing.T) {
+		clock.Step(ttl)
+		assert.ErrorIs(t, b.Check(objB), locking.ErrLockLost)
+
+		objA := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "shared"}}
+		a, err := locking.ClaimObject(ctx, c, objA, "operator-a", ttl, locking.WithClock(clock))
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), a.Token())
+	})
+}
@@ -0,0 +1,413 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package manifests fetches bundles of manifests from HTTPS URLs or OCI
+// artifacts, so that versioned components don't need to be baked into the
+// operator image. Bundles are verified against an expected digest and/or a
+// signature, cached by digest, and decoded into objects that can be applied
+// with updater.ApplyAll.
+package manifests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/gpu-ninja/operator-utils/updater"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// SignatureAnnotation is the OCI manifest annotation containing the base64
+	// encoded signature of an artifact's bundle.
+	SignatureAnnotation = "gpu-ninja.com/bundle-signature"
+	// DefaultMaxSize is the default maximum size of a bundle.
+	DefaultMaxSize = 16 << 20
+	// DefaultMaxCacheEntries is the default number of bundles cached.
+	DefaultMaxCacheEntries = 16
+)
+
+var (
+	// ErrUnsupportedSource is returned when a source URL is neither https:// nor oci://.
+	ErrUnsupportedSource = errors.New("unsupported source")
+	// ErrDigestMismatch is returned when a bundle doesn't match its expected digest.
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrUnsigned is returned when a verifier is configured but a bundle has no signature.
+	ErrUnsigned = errors.New("bundle is not signed")
+	// ErrTooLarge is returned when a bundle exceeds the maximum size.
+	ErrTooLarge = errors.New("bundle too large")
+)
+
+// Source identifies a bundle of manifests.
+type Source struct {
+	// URL is the location of the bundle, either an https:// URL of a YAML (or
+	// JSON) file, or an oci:// reference to an artifact whose layers are
+	// YAML (or JSON) files (eg. oci://ghcr.io/gpu-ninja/bundles/foo:v1.0.0).
+	URL string
+	// Digest is the expected digest of the bundle contents (eg.
+	// "sha256:<hex>"). Bundles with a digest are only fetched once.
+	Digest string
+	// SignatureURL is the https:// URL of the bundle's base64 encoded
+	// signature. OCI artifacts carry their signature in the
+	// SignatureAnnotation instead.
+	SignatureURL string
+}
+
+func (s *Source) String() string {
+	if s.Digest != "" {
+		return s.URL + "@" + s.Digest
+	}
+
+	return s.URL
+}
+
+// Bundle is a fetched bundle of manifests.
+type Bundle struct {
+	// Source is where the bundle was fetched from.
+	Source Source
+	// Digest is the digest of the bundle contents.
+	Digest string
+	// Data is the raw bundle contents.
+	Data []byte
+	// Signature is the bundle's signature, if any.
+	Signature []byte
+	// Objects are the decoded objects.
+	Objects []*unstructured.Unstructured
+}
+
+// Templates returns copies of the bundle's objects, suitable for applying.
+func (b *Bundle) Templates() []client.Object {
+	templates := make([]client.Object, 0, len(b.Objects))
+	for _, obj := range b.Objects {
+		templates = append(templates, obj.DeepCopy())
+	}
+
+	return templates
+}
+
+// Verifier verifies the signature of a bundle.
+type Verifier interface {
+	Verify(ctx context.Context, bundle *Bundle) error
+}
+
+// Options configures a Fetcher.
+type Options struct {
+	// HTTPClient is used to fetch https:// sources.
+	HTTPClient *http.Client
+	// RemoteOptions are used to fetch oci:// sources (eg. for authentication).
+	RemoteOptions []remote.Option
+	// Verifier, if set, verifies the signature of every bundle (unsigned
+	// bundles are rejected).
+	Verifier Verifier
+	// RequireDigest rejects sources without an expected digest.
+	RequireDigest bool
+	// MaxSize is the maximum size of a bundle.
+	MaxSize int64
+	// MaxCacheEntries is the maximum number of bundles cached.
+	MaxCacheEntries int
+}
+
+// Fetcher fetches, verifies, and caches bundles.
+type Fetcher struct {
+	opts Options
+
+	mu    sync.Mutex
+	cache map[string]*Bundle
+	order []string
+}
+
+// NewFetcher returns a new bundle fetcher.
+func NewFetcher(opts Options) *Fetcher {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+
+	if opts.MaxSize == 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+
+	if opts.MaxCacheEntries == 0 {
+		opts.MaxCacheEntries = DefaultMaxCacheEntries
+	}
+
+	return &Fetcher{
+		opts:  opts,
+		cache: make(map[string]*Bundle),
+	}
+}
+
+// Fetch fetches, verifies, and decodes the given bundle. Bundles are cached
+// by digest, so sources with an expected digest are only fetched once.
+func (f *Fetcher) Fetch(ctx context.Context, src Source) (*Bundle, error) {
+	if src.Digest == "" && f.opts.RequireDigest {
+		return nil, fmt.Errorf("source %q has no digest", src.URL)
+	}
+
+	if src.Digest != "" {
+		if bundle, ok := f.cached(src.Digest); ok {
+			return bundle, nil
+		}
+	}
+
+	var (
+		bundle *Bundle
+		err    error
+	)
+	switch {
+	case strings.HasPrefix(src.URL, "https://"):
+		bundle, err = f.fetchHTTPS(ctx, src)
+	case strings.HasPrefix(src.URL, "oci://"):
+		bundle, err = f.fetchOCI(ctx, src)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedSource, src.URL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %q: %w", src.URL, err)
+	}
+
+	sum := sha256.Sum256(bundle.Data)
+	bundle.Digest = "sha256:" + hex.EncodeToString(sum[:])
+
+	if src.Digest != "" && src.Digest != bundle.Digest {
+		return nil, fmt.Errorf("%w: %q has digest %s, expected %s", ErrDigestMismatch, src.URL, bundle.Digest, src.Digest)
+	}
+
+	if f.opts.Verifier != nil {
+		if len(bundle.Signature) == 0 {
+			return nil, fmt.Errorf("%w: %q", ErrUnsigned, src.URL)
+		}
+
+		if err := f.opts.Verifier.Verify(ctx, bundle); err != nil {
+			return nil, fmt.Errorf("failed to verify %q: %w", src.URL, err)
+		}
+	}
+
+	bundle.Objects, err = Decode(bundle.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %q: %w", src.URL, err)
+	}
+
+	f.store(bundle)
+
+	return bundle, nil
+}
+
+// Apply fetches the given bundle and creates or updates its objects.
+func (f *Fetcher) Apply(ctx context.Context, c client.Client, src Source, hooks ...updater.PreApplyHook) ([]client.Object, error) {
+	bundle, err := f.Fetch(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return updater.ApplyAll(ctx, c, bundle.Templates(), hooks...)
+}
+
+func (f *Fetcher) fetchHTTPS(ctx context.Context, src Source) (*Bundle, error) {
+	data, err := f.get(ctx, src.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{Source: src, Data: data}
+
+	if src.SignatureURL != "" {
+		if !strings.HasPrefix(src.SignatureURL, "https://") {
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedSource, src.SignatureURL)
+		}
+
+		encoded, err := f.get(ctx, src.SignatureURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch signature: %w", err)
+		}
+
+		bundle.Signature, err = decodeSignature(string(encoded))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bundle, nil
+}
+
+func (f *Fetcher) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := f.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status getting %q: %s", url, resp.Status)
+	}
+
+	return f.readAll(resp.Body)
+}
+
+func (f *Fetcher) fetchOCI(ctx context.Context, src Source) (*Bundle, error) {
+	ref, err := name.ParseReference(strings.TrimPrefix(src.URL, "oci://"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference: %w", err)
+	}
+
+	opts := append([]remote.Option{remote.WithContext(ctx)}, f.opts.RemoteOptions...)
+
+	img, err := remote.Image(ref, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artifact: %w", err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artifact manifest: %w", err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artifact layers: %w", err)
+	}
+
+	var data bytes.Buffer
+	for i, layer := range layers {
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer %d: %w", i, err)
+		}
+
+		layerData, err := f.readAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %d: %w", i, err)
+		}
+
+		// Layers are separate documents.
+		if data.Len() > 0 {
+			data.WriteString("\n---\n")
+		}
+		data.Write(layerData)
+
+		if int64(data.Len()) > f.opts.MaxSize {
+			return nil, ErrTooLarge
+		}
+	}
+
+	bundle := &Bundle{Source: src, Data: data.Bytes()}
+
+	if encoded, ok := manifest.Annotations[SignatureAnnotation]; ok {
+		bundle.Signature, err = decodeSignature(encoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bundle, nil
+}
+
+func (f *Fetcher) readAll(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, f.opts.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read: %w", err)
+	}
+
+	if int64(len(data)) > f.opts.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	return data, nil
+}
+
+func (f *Fetcher) cached(digest string) (*Bundle, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bundle, ok := f.cache[digest]
+	return bundle, ok
+}
+
+func (f *Fetcher) store(bundle *Bundle) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.cache[bundle.Digest]; ok {
+		return
+	}
+
+	// Evict the oldest bundles.
+	for len(f.order) >= f.opts.MaxCacheEntries {
+		delete(f.cache, f.order[0])
+		f.order = f.order[1:]
+	}
+
+	f.cache[bundle.Digest] = bundle
+	f.order = append(f.order, bundle.Digest)
+}
+
+// Decode decodes the objects in a multi-document YAML (or JSON) bundle,
+// empty documents are skipped.
+func Decode(data []byte) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+
+	dec := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, fmt.Errorf("failed to decode object: %w", err)
+		}
+
+		if len(obj) == 0 {
+			continue
+		}
+
+		u := &unstructured.Unstructured{Object: obj}
+		if u.GetAPIVersion() == "" || u.GetKind() == "" {
+			return nil, fmt.Errorf("object %d is missing apiVersion or kind", len(objs))
+		}
+
+		objs = append(objs, u)
+	}
+
+	return objs, nil
+}
+
+func decodeSignature(encoded string) ([]byte, error) {
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	return signature, nil
+}
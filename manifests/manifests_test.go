@@ -0,0 +1,197 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifests_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/gpu-ninja/operator-utils/manifests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const bundleYAML = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: first
+  namespace: default
+data:
+  foo: bar
+---
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: second
+  namespace: default
+`
+
+func TestFetcherHTTPS(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte(bundleYAML))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+
+	var requests atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+
+		switch req.URL.Path {
+		case "/bundle.yaml":
+			_, _ = w.Write([]byte(bundleYAML))
+		case "/bundle.yaml.sig":
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(signature)))
+		case "/bad.yaml.sig":
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte("not a signature"))))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	verifier, err := manifests.NewPublicKeyVerifier(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	require.NoError(t, err)
+
+	f := manifests.NewFetcher(manifests.Options{
+		HTTPClient: srv.Client(),
+		Verifier:   verifier,
+	})
+
+	src := manifests.Source{
+		URL:          srv.URL + "/bundle.yaml",
+		Digest:       "sha256:" + hex.EncodeToString(digest[:]),
+		SignatureURL: srv.URL + "/bundle.yaml.sig",
+	}
+
+	bundle, err := f.Fetch(ctx, src)
+	require.NoError(t, err)
+	assert.Equal(t, src.Digest, bundle.Digest)
+	require.Len(t, bundle.Objects, 2)
+	assert.Equal(t, "first", bundle.Objects[0].GetName())
+	assert.Equal(t, "second", bundle.Objects[1].GetName())
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Cached by digest.
+	c := fake.NewClientBuilder().Build()
+
+	objs, err := f.Apply(ctx, c, src)
+	require.NoError(t, err)
+	assert.Len(t, objs, 2)
+	assert.Equal(t, int32(2), requests.Load())
+
+	var cm corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "first"}, &cm))
+	assert.Equal(t, "bar", cm.Data["foo"])
+
+	t.Run("DigestMismatch", func(t *testing.T) {
+		src := src
+		src.Digest = "sha256:" + strings.Repeat("0", 64)
+
+		_, err := f.Fetch(ctx, src)
+		assert.ErrorIs(t, err, manifests.ErrDigestMismatch)
+	})
+
+	t.Run("InvalidSignature", func(t *testing.T) {
+		_, err := f.Fetch(ctx, manifests.Source{
+			URL:          srv.URL + "/bundle.yaml",
+			SignatureURL: srv.URL + "/bad.yaml.sig",
+		})
+		assert.ErrorIs(t, err, manifests.ErrInvalidSignature)
+
+		_, err = f.Fetch(ctx, manifests.Source{URL: srv.URL + "/bundle.yaml"})
+		assert.ErrorIs(t, err, manifests.ErrUnsigned)
+	})
+
+	t.Run("UnsupportedSource", func(t *testing.T) {
+		_, err := f.Fetch(ctx, manifests.Source{URL: "http://example.com/bundle.yaml"})
+		assert.ErrorIs(t, err, manifests.ErrUnsupportedSource)
+	})
+}
+
+func TestFetcherOCI(t *testing.T) {
+	ctx := context.Background()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	documents := strings.SplitAfterN(bundleYAML, "---\n---\n", 2)
+	data := []byte(documents[0] + "\n---\n" + documents[1])
+
+	srv := httptest.NewServer(registry.New())
+	t.Cleanup(srv.Close)
+
+	ref, err := name.ParseReference(strings.TrimPrefix(srv.URL, "http://") + "/bundles/test:v1.0.0")
+	require.NoError(t, err)
+
+	img, err := mutate.AppendLayers(empty.Image,
+		static.NewLayer([]byte(documents[0]), "application/yaml"),
+		static.NewLayer([]byte(documents[1]), "application/yaml"))
+	require.NoError(t, err)
+
+	img = mutate.Annotations(img, map[string]string{
+		manifests.SignatureAnnotation: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data)),
+	}).(v1.Image)
+
+	require.NoError(t, remote.Write(ref, img))
+
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	verifier, err := manifests.NewPublicKeyVerifier(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	require.NoError(t, err)
+
+	f := manifests.NewFetcher(manifests.Options{Verifier: verifier})
+
+	bundle, err := f.Fetch(ctx, manifests.Source{URL: "oci://" + ref.String()})
+	require.NoError(t, err)
+	require.Len(t, bundle.Objects, 2)
+	assert.Equal(t, "first", bundle.Objects[0].GetName())
+	assert.Equal(t, "second", bundle.Objects[1].GetName())
+}
@@ -0,0 +1,99 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifests
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidSignature is returned when a bundle's signature doesn't verify.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// PublicKeyVerifier verifies bundles signed with any of a set of public keys.
+// ECDSA and RSA (PKCS #1 v1.5) signatures are of the SHA-256 digest of the
+// bundle, while Ed25519 signatures are of the bundle itself (as produced by
+// eg. `openssl pkeyutl -sign`).
+type PublicKeyVerifier struct {
+	keys []crypto.PublicKey
+}
+
+// NewPublicKeyVerifier returns a verifier for the PEM encoded (PKIX) public keys.
+func NewPublicKeyVerifier(publicKeysPEM []byte) (*PublicKeyVerifier, error) {
+	v := &PublicKeyVerifier{}
+
+	rest := publicKeysPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+
+		v.keys = append(v.keys, key)
+	}
+
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("no public keys found")
+	}
+
+	return v, nil
+}
+
+// Verify implements Verifier.
+func (v *PublicKeyVerifier) Verify(_ context.Context, bundle *Bundle) error {
+	digest := sha256.Sum256(bundle.Data)
+
+	for _, key := range v.keys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest[:], bundle.Signature) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], bundle.Signature) == nil {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, bundle.Data, bundle.Signature) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidSignature
+}
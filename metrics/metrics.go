@@ -0,0 +1,280 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metrics provides standardized Prometheus collectors for operators,
+// registered into controller-runtime's metrics registry.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "operator"
+
+// Reconcile outcome classes.
+const (
+	OutcomeSuccess      = "success"
+	OutcomeRequeue      = "requeue"
+	OutcomeRequeueAfter = "requeue_after"
+	OutcomeError        = "error"
+)
+
+// Child apply operations.
+const (
+	OperationCreated   = "created"
+	OperationUpdated   = "updated"
+	OperationUnchanged = "unchanged"
+	OperationDeleted   = "deleted"
+)
+
+// Reference resolution results.
+const (
+	ResolutionResolved = "resolved"
+	ResolutionNotFound = "not_found"
+	ResolutionError    = "error"
+)
+
+// Error classes.
+const (
+	ErrorClassNotFound      = "not_found"
+	ErrorClassConflict      = "conflict"
+	ErrorClassAlreadyExists = "already_exists"
+	ErrorClassForbidden     = "forbidden"
+	ErrorClassInvalid       = "invalid"
+	ErrorClassTimeout       = "timeout"
+	ErrorClassThrottled     = "throttled"
+	ErrorClassServer        = "server"
+	ErrorClassCanceled      = "canceled"
+	ErrorClassOther         = "other"
+)
+
+var (
+	// ReconcileTotal counts reconciles by controller and outcome class.
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Total number of reconciles by controller and outcome.",
+	}, []string{"controller", "outcome"})
+
+	// ChildApplyTotal counts child object operations by controller, kind, and operation.
+	ChildApplyTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "child_apply_total",
+		Help:      "Total number of child object operations by controller, kind, and operation.",
+	}, []string{"controller", "kind", "operation"})
+
+	// ReferenceResolutionFailuresTotal counts failed reference resolutions by referenced kind and reason.
+	ReferenceResolutionFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reference_resolution_failures_total",
+		Help:      "Total number of failed reference resolutions by referenced kind and reason.",
+	}, []string{"kind", "reason"})
+
+	// ReferenceResolutionsTotal counts reference resolutions by referenced kind and result.
+	ReferenceResolutionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reference_resolutions_total",
+		Help:      "Total number of reference resolutions by referenced kind and result.",
+	}, []string{"kind", "result"})
+
+	// ReferenceDecodeFallbacksTotal counts references resolved as unstructured objects
+	// (as their kind isn't registered with the scheme) by referenced kind.
+	ReferenceDecodeFallbacksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reference_decode_fallbacks_total",
+		Help:      "Total number of references resolved as unstructured objects by referenced kind.",
+	}, []string{"kind"})
+
+	// ReferenceResolutionDurationSeconds is the latency of reference resolutions by referenced kind.
+	ReferenceResolutionDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reference_resolution_duration_seconds",
+		Help:      "Latency of reference resolutions by referenced kind.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"kind"})
+
+	// ErrorsTotal counts reconcile errors by controller and error class.
+	ErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Total number of reconcile errors by controller and class.",
+	}, []string{"controller", "class"})
+
+	// PanicsTotal counts recovered reconcile panics by controller.
+	PanicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_panics_total",
+		Help:      "Total number of recovered reconcile panics by controller.",
+	}, []string{"controller"})
+
+	// TimeoutsTotal counts reconciles that exceeded their deadline by controller.
+	TimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_timeouts_total",
+		Help:      "Total number of reconciles that exceeded their deadline by controller.",
+	}, []string{"controller"})
+
+	// StaleObjects is the number of objects with a stale status by kind and reason.
+	StaleObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stale_objects",
+		Help:      "Number of objects with a stale status by kind and reason.",
+	}, []string{"kind", "reason"})
+
+	// VersionSkew is the number of detected operator version skews by type.
+	VersionSkew = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "version_skew",
+		Help:      "Number of detected operator version skews by type.",
+	}, []string{"type"})
+
+	// BootstrapDependencyReady is whether each startup dependency is ready (1) or not (0).
+	BootstrapDependencyReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bootstrap_dependency_ready",
+		Help:      "Whether each startup dependency is ready (1) or not (0).",
+	}, []string{"dependency"})
+
+	// BootstrapDependencyWaitSeconds is how long it took each startup dependency to become ready.
+	BootstrapDependencyWaitSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bootstrap_dependency_wait_seconds",
+		Help:      "How long it took each startup dependency to become ready.",
+	}, []string{"dependency"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		ReconcileTotal,
+		ChildApplyTotal,
+		ReferenceResolutionFailuresTotal,
+		ReferenceResolutionsTotal,
+		ReferenceDecodeFallbacksTotal,
+		ReferenceResolutionDurationSeconds,
+		ErrorsTotal,
+		PanicsTotal,
+		TimeoutsTotal,
+		StaleObjects,
+		VersionSkew,
+		BootstrapDependencyReady,
+		BootstrapDependencyWaitSeconds,
+	)
+}
+
+// RecordReconcile records the outcome of a reconcile (and classifies the
+// error, if any).
+func RecordReconcile(controller string, res ctrl.Result, err error) {
+	ReconcileTotal.WithLabelValues(controller, Outcome(res, err)).Inc()
+
+	if err != nil {
+		ErrorsTotal.WithLabelValues(controller, ClassifyError(err)).Inc()
+	}
+}
+
+// RecordPanic records a recovered reconcile panic.
+func RecordPanic(controller string) {
+	PanicsTotal.WithLabelValues(controller).Inc()
+}
+
+// RecordTimeout records a reconcile that exceeded its deadline.
+func RecordTimeout(controller string) {
+	TimeoutsTotal.WithLabelValues(controller).Inc()
+}
+
+// RecordChildApply records an operation on a child object.
+func RecordChildApply(controller, kind, operation string) {
+	ChildApplyTotal.WithLabelValues(controller, kind, operation).Inc()
+}
+
+// RecordReferenceResolutionFailure records a failure to resolve a reference.
+// A nil error indicates the referenced object was not found.
+func RecordReferenceResolutionFailure(kind string, err error) {
+	reason := ErrorClassNotFound
+	if err != nil {
+		reason = ClassifyError(err)
+	}
+
+	ReferenceResolutionFailuresTotal.WithLabelValues(kind, reason).Inc()
+}
+
+// RecordReferenceResolution records the result and latency of resolving a
+// reference (failures are also recorded as resolution failures).
+func RecordReferenceResolution(kind string, ok bool, err error, decodeFallback bool, d time.Duration) {
+	ReferenceResolutionDurationSeconds.WithLabelValues(kind).Observe(d.Seconds())
+
+	switch {
+	case err != nil:
+		ReferenceResolutionsTotal.WithLabelValues(kind, ResolutionError).Inc()
+		RecordReferenceResolutionFailure(kind, err)
+	case !ok:
+		ReferenceResolutionsTotal.WithLabelValues(kind, ResolutionNotFound).Inc()
+		RecordReferenceResolutionFailure(kind, nil)
+	default:
+		ReferenceResolutionsTotal.WithLabelValues(kind, ResolutionResolved).Inc()
+
+		if decodeFallback {
+			ReferenceDecodeFallbacksTotal.WithLabelValues(kind).Inc()
+		}
+	}
+}
+
+// Outcome returns the outcome class of a reconcile.
+func Outcome(res ctrl.Result, err error) string {
+	switch {
+	case err != nil:
+		return OutcomeError
+	case res.RequeueAfter > 0:
+		return OutcomeRequeueAfter
+	case res.Requeue:
+		return OutcomeRequeue
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// ClassifyError returns a low cardinality class for an error.
+func ClassifyError(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded), apierrors.IsTimeout(err), apierrors.IsServerTimeout(err):
+		return ErrorClassTimeout
+	case apierrors.IsNotFound(err):
+		return ErrorClassNotFound
+	case apierrors.IsConflict(err):
+		return ErrorClassConflict
+	case apierrors.IsAlreadyExists(err):
+		return ErrorClassAlreadyExists
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return ErrorClassForbidden
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return ErrorClassInvalid
+	case apierrors.IsTooManyRequests(err):
+		return ErrorClassThrottled
+	case apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return ErrorClassServer
+	default:
+		return ErrorClassOther
+	}
+}
@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestRecordReconcile(t *testing.T) {
+	metrics.RecordReconcile("test", ctrl.Result{}, nil)
+	metrics.RecordReconcile("test", ctrl.Result{RequeueAfter: time.Minute}, nil)
+	metrics.RecordReconcile("test", ctrl.Result{}, apierrors.NewConflict(schema.GroupResource{}, "test", errors.New("conflict")))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ReconcileTotal.WithLabelValues("test", metrics.OutcomeSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ReconcileTotal.WithLabelValues("test", metrics.OutcomeRequeueAfter)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ReconcileTotal.WithLabelValues("test", metrics.OutcomeError)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ErrorsTotal.WithLabelValues("test", metrics.ErrorClassConflict)))
+}
+
+func TestClassifyError(t *testing.T) {
+	gr := schema.GroupResource{Resource: "configmaps"}
+
+	assert.Equal(t, metrics.ErrorClassNotFound, metrics.ClassifyError(fmt.Errorf("wrapped: %w", apierrors.NewNotFound(gr, "test"))))
+	assert.Equal(t, metrics.ErrorClassForbidden, metrics.ClassifyError(apierrors.NewForbidden(gr, "test", errors.New("denied"))))
+	assert.Equal(t, metrics.ErrorClassThrottled, metrics.ClassifyError(apierrors.NewTooManyRequests("slow down", 1)))
+	assert.Equal(t, metrics.ErrorClassTimeout, metrics.ClassifyError(context.DeadlineExceeded))
+	assert.Equal(t, metrics.ErrorClassOther, metrics.ClassifyError(errors.New("bang")))
+}
+
+func TestObjectGaugeVec(t *testing.T) {
+	g := metrics.NewObjectGaugeVec(prometheus.GaugeOpts{
+		Name: "test_object_replicas",
+		Help: "Test gauge.",
+	}, []string{"component"})
+
+	a := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}
+	b := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b"}}
+
+	g.Set(a, 1, "server")
+	g.Set(a, 2, "worker")
+	g.Set(b, 3, "server")
+
+	assert.Equal(t, 3, testutil.CollectAndCount(g))
+
+	metrics.ForgetObject(a)
+
+	assert.Equal(t, 1, testutil.CollectAndCount(g))
+	assert.Equal(t, 3.0, testutil.ToFloat64(g.WithLabelValues("default", "b", "server")))
+}
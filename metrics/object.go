@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	objectGaugesMu sync.Mutex
+	objectGauges   []*ObjectGaugeVec
+)
+
+// ObjectGaugeVec is a gauge vector with series keyed by object (namespace
+// and name), so that all of an object's series can be deleted when the
+// object is deleted.
+type ObjectGaugeVec struct {
+	*prometheus.GaugeVec
+}
+
+// NewObjectGaugeVec creates a gauge vector with "namespace" and "name"
+// labels prepended to the given labels. The gauge is registered into
+// controller-runtime's metrics registry, and is cleaned up by ForgetObject.
+func NewObjectGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *ObjectGaugeVec {
+	g := &ObjectGaugeVec{
+		GaugeVec: prometheus.NewGaugeVec(opts, append([]string{"namespace", "name"}, labelNames...)),
+	}
+
+	ctrlmetrics.Registry.MustRegister(g.GaugeVec)
+
+	objectGaugesMu.Lock()
+	objectGauges = append(objectGauges, g)
+	objectGaugesMu.Unlock()
+
+	return g
+}
+
+// Set sets the value of the object's series with the given label values.
+func (g *ObjectGaugeVec) Set(obj client.Object, value float64, labelValues ...string) {
+	g.WithLabelValues(append([]string{obj.GetNamespace(), obj.GetName()}, labelValues...)...).Set(value)
+}
+
+// Forget deletes all of the object's series.
+func (g *ObjectGaugeVec) Forget(obj client.Object) int {
+	return g.DeletePartialMatch(prometheus.Labels{
+		"namespace": obj.GetNamespace(),
+		"name":      obj.GetName(),
+	})
+}
+
+// ForgetObject deletes the object's series from every object gauge. It
+// should be called when the object is deleted (eg. when removing its finalizer).
+func ForgetObject(obj client.Object) {
+	objectGaugesMu.Lock()
+	defer objectGaugesMu.Unlock()
+
+	for _, g := range objectGauges {
+		g.Forget(obj)
+	}
+}
@@ -0,0 +1,285 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package namespaces provides helpers for namespaces created and managed by operators.
+package namespaces
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/applabels"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ManagedByLabel is the recommended label identifying the tool managing an object.
+	ManagedByLabel = applabels.ManagedByLabel
+	// OwnerUIDLabel records the UID of the object that owns a namespace.
+	OwnerUIDLabel = "gpu-ninja.com/owner-uid"
+	// OwnerAnnotation records the object that owns a namespace (as kind/namespace/name).
+	OwnerAnnotation = "gpu-ninja.com/owner"
+)
+
+// Pod Security Admission labels.
+const (
+	PodSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	PodSecurityAuditLabel   = "pod-security.kubernetes.io/audit"
+	PodSecurityWarnLabel    = "pod-security.kubernetes.io/warn"
+)
+
+var (
+	// ErrOwnedByOther is returned when a namespace is owned by a different object.
+	ErrOwnedByOther = errors.New("namespace is owned by another object")
+	// ErrTimeout is returned when a namespace is not ready within the timeout.
+	ErrTimeout = errors.New("timed out waiting for namespace to be ready")
+)
+
+// Option configures Ensure.
+type Option func(*options)
+
+type options struct {
+	owner             client.Object
+	ownerKind         string
+	managedBy         string
+	podSecurityLevel  string
+	waitReady         bool
+	waitReadyTimeout  time.Duration
+	waitReadyInterval time.Duration
+}
+
+// WithOwner marks a created namespace as owned by the given object (which
+// may be namespaced). Namespaces can't be garbage collected by namespaced
+// owners, so ownership is recorded with labels and annotations and the
+// namespace must be deleted with Delete.
+func WithOwner(owner client.Object, kind string) Option {
+	return func(o *options) {
+		o.owner = owner
+		o.ownerKind = kind
+	}
+}
+
+// WithManagedBy sets the app.kubernetes.io/managed-by label.
+func WithManagedBy(name string) Option {
+	return func(o *options) {
+		o.managedBy = name
+	}
+}
+
+// WithPodSecurityLevel sets the Pod Security Admission enforce, audit, and
+// warn levels (eg. "restricted", "baseline", or "privileged").
+func WithPodSecurityLevel(level string) Option {
+	return func(o *options) {
+		o.podSecurityLevel = level
+	}
+}
+
+// WithWaitReady waits (up to the timeout) for the namespace's default
+// ServiceAccount to be created, and for any ResourceQuotas to be calculated,
+// so that pods can be created in the namespace immediately.
+func WithWaitReady(timeout time.Duration) Option {
+	return func(o *options) {
+		o.waitReady = true
+		o.waitReadyTimeout = timeout
+	}
+}
+
+// Ensure creates the namespace if it doesn't exist, and reconciles its labels
+// and annotations (labels and annotations set by others are preserved).
+// Pre-existing namespaces are never marked as owned, so they won't be deleted
+// by Delete.
+func Ensure(ctx context.Context, c client.Client, name string, labels, annotations map[string]string, opts ...Option) (*corev1.Namespace, error) {
+	o := &options{
+		waitReadyInterval: time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	desiredLabels := copyMap(labels)
+	if o.managedBy != "" {
+		desiredLabels[ManagedByLabel] = o.managedBy
+	}
+	if o.podSecurityLevel != "" {
+		desiredLabels[PodSecurityEnforceLabel] = o.podSecurityLevel
+		desiredLabels[PodSecurityAuditLabel] = o.podSecurityLevel
+		desiredLabels[PodSecurityWarnLabel] = o.podSecurityLevel
+	}
+
+	desiredAnnotations := copyMap(annotations)
+
+	var ns corev1.Namespace
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, &ns); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get namespace: %w", err)
+		}
+
+		ns = corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      desiredLabels,
+				Annotations: desiredAnnotations,
+			},
+		}
+
+		if o.owner != nil {
+			ns.Labels[OwnerUIDLabel] = string(o.owner.GetUID())
+			ns.Annotations[OwnerAnnotation] = ownerRef(o.owner, o.ownerKind)
+		}
+
+		if err := c.Create(ctx, &ns); err != nil {
+			return nil, fmt.Errorf("failed to create namespace: %w", err)
+		}
+
+		log.FromContext(ctx).Info("Created namespace", "name", name)
+	} else {
+		if o.owner != nil {
+			if uid, ok := ns.Labels[OwnerUIDLabel]; ok && uid != string(o.owner.GetUID()) {
+				return nil, fmt.Errorf("%w: %s is owned by %s", ErrOwnedByOther, name, ns.Annotations[OwnerAnnotation])
+			}
+		}
+
+		patch := client.MergeFrom(ns.DeepCopy())
+
+		var changed bool
+		ns.Labels, changed = mergeMap(ns.Labels, desiredLabels)
+		var annotationsChanged bool
+		ns.Annotations, annotationsChanged = mergeMap(ns.Annotations, desiredAnnotations)
+
+		if changed || annotationsChanged {
+			if err := c.Patch(ctx, &ns, patch); err != nil {
+				return nil, fmt.Errorf("failed to patch namespace: %w", err)
+			}
+		}
+	}
+
+	if o.waitReady {
+		if err := waitReady(ctx, c, name, o); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ns, nil
+}
+
+// IsOwnedBy returns true if the namespace was created with the given owner.
+func IsOwnedBy(ns *corev1.Namespace, owner client.Object) bool {
+	return ns.Labels[OwnerUIDLabel] == string(owner.GetUID()) && owner.GetUID() != ""
+}
+
+// Delete deletes the namespace if it is owned by the given owner. It returns
+// true if the namespace is gone (or was never owned by the owner), so that
+// callers can remove their finalizer.
+func Delete(ctx context.Context, c client.Client, name string, owner client.Object) (bool, error) {
+	var ns corev1.Namespace
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, &ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+
+		return false, fmt.Errorf("failed to get namespace: %w", err)
+	}
+
+	if !IsOwnedBy(&ns, owner) {
+		return true, nil
+	}
+
+	if ns.DeletionTimestamp.IsZero() {
+		if err := c.Delete(ctx, &ns); err != nil && !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to delete namespace: %w", err)
+		}
+
+		log.FromContext(ctx).Info("Deleted namespace", "name", name)
+	}
+
+	return false, nil
+}
+
+func waitReady(ctx context.Context, c client.Client, name string, o *options) error {
+	err := wait.PollUntilContextTimeout(ctx, o.waitReadyInterval, o.waitReadyTimeout, true, func(ctx context.Context) (bool, error) {
+		var sa corev1.ServiceAccount
+		if err := c.Get(ctx, client.ObjectKey{Namespace: name, Name: "default"}, &sa); err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+
+			return false, fmt.Errorf("failed to get default service account: %w", err)
+		}
+
+		var quotas corev1.ResourceQuotaList
+		if err := c.List(ctx, &quotas, client.InNamespace(name)); err != nil {
+			return false, fmt.Errorf("failed to list resource quotas: %w", err)
+		}
+
+		for _, quota := range quotas.Items {
+			if len(quota.Status.Hard) == 0 {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) && ctx.Err() == nil {
+			return fmt.Errorf("%w: %s", ErrTimeout, name)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func ownerRef(owner client.Object, kind string) string {
+	if owner.GetNamespace() == "" {
+		return fmt.Sprintf("%s/%s", kind, owner.GetName())
+	}
+
+	return fmt.Sprintf("%s/%s/%s", kind, owner.GetNamespace(), owner.GetName())
+}
+
+func copyMap(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+func mergeMap(existing, desired map[string]string) (map[string]string, bool) {
+	var changed bool
+	for k, v := range desired {
+		if existing == nil {
+			existing = make(map[string]string)
+		}
+
+		if current, ok := existing[k]; !ok || current != v {
+			existing[k] = v
+			changed = true
+		}
+	}
+
+	return existing, changed
+}
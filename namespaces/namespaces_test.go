@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package namespaces_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/namespaces"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNamespaces(t *testing.T) {
+	ctx := context.Background()
+
+	existing := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "existing",
+			Labels: map[string]string{"team": "ml"},
+		},
+	}
+
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "owner", UID: "1234"}}
+	other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other", UID: "5678"}}
+
+	c := fake.NewClientBuilder().WithObjects(existing).Build()
+
+	t.Run("Create", func(t *testing.T) {
+		ns, err := namespaces.Ensure(ctx, c, "tenant-a", map[string]string{"tenant": "a"}, nil,
+			namespaces.WithOwner(owner, "Tenant"),
+			namespaces.WithManagedBy("tenant-operator"),
+			namespaces.WithPodSecurityLevel("restricted"))
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]string{
+			"tenant":                           "a",
+			namespaces.ManagedByLabel:          "tenant-operator",
+			namespaces.OwnerUIDLabel:           "1234",
+			namespaces.PodSecurityEnforceLabel: "restricted",
+			namespaces.PodSecurityAuditLabel:   "restricted",
+			namespaces.PodSecurityWarnLabel:    "restricted",
+		}, ns.Labels)
+		assert.Equal(t, "Tenant/default/owner", ns.Annotations[namespaces.OwnerAnnotation])
+		assert.True(t, namespaces.IsOwnedBy(ns, owner))
+	})
+
+	t.Run("Owned By Other", func(t *testing.T) {
+		_, err := namespaces.Ensure(ctx, c, "tenant-a", nil, nil, namespaces.WithOwner(other, "Tenant"))
+		assert.ErrorIs(t, err, namespaces.ErrOwnedByOther)
+	})
+
+	t.Run("Existing", func(t *testing.T) {
+		ns, err := namespaces.Ensure(ctx, c, "existing", map[string]string{"tenant": "b"}, map[string]string{"note": "shared"},
+			namespaces.WithOwner(owner, "Tenant"))
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]string{"team": "ml", "tenant": "b"}, ns.Labels)
+		assert.Equal(t, map[string]string{"note": "shared"}, ns.Annotations)
+		assert.False(t, namespaces.IsOwnedBy(ns, owner))
+
+		// Pre-existing namespaces are never deleted.
+		gone, err := namespaces.Delete(ctx, c, "existing", owner)
+		require.NoError(t, err)
+		assert.True(t, gone)
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "existing"}, &corev1.Namespace{}))
+	})
+
+	t.Run("Wait Ready", func(t *testing.T) {
+		_, err := namespaces.Ensure(ctx, c, "tenant-b", nil, nil, namespaces.WithWaitReady(50*time.Millisecond))
+		assert.ErrorIs(t, err, namespaces.ErrTimeout)
+
+		require.NoError(t, c.Create(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-b", Name: "default"}}))
+
+		_, err = namespaces.Ensure(ctx, c, "tenant-b", nil, nil, namespaces.WithWaitReady(time.Second))
+		assert.NoError(t, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, err := namespaces.Delete(ctx, c, "tenant-a", owner)
+		require.NoError(t, err)
+
+		err = c.Get(ctx, client.ObjectKey{Name: "tenant-a"}, &corev1.Namespace{})
+		assert.True(t, apierrors.IsNotFound(err))
+
+		gone, err := namespaces.Delete(ctx, c, "tenant-a", owner)
+		require.NoError(t, err)
+		assert.True(t, gone)
+	})
+}
@@ -0,0 +1,380 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package podsecurity validates pod specs against the Pod Security
+// Standards (baseline and restricted), returning violations as field
+// errors so they can be used both in validating webhooks and as an updater
+// pre-apply hook.
+//
+// See: https://kubernetes.io/docs/concepts/security/pod-security-standards/
+package podsecurity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gpu-ninja/operator-utils/updater"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Level is a Pod Security Standard level.
+type Level string
+
+const (
+	LevelPrivileged Level = "privileged"
+	LevelBaseline   Level = "baseline"
+	LevelRestricted Level = "restricted"
+)
+
+var (
+	baselineCapabilities = set(
+		"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+		"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+	)
+	restrictedCapabilities = set("NET_BIND_SERVICE")
+	baselineSELinuxTypes   = set("", "container_t", "container_init_t", "container_kvm_t")
+	baselineSysctls        = set(
+		"kernel.shm_rmid_forced", "net.ipv4.ip_local_port_range", "net.ipv4.ip_unprivileged_port_start",
+		"net.ipv4.tcp_syncookies", "net.ipv4.ping_group_range",
+	)
+)
+
+// ValidatePodSpec validates a pod spec against the given level.
+func ValidatePodSpec(spec *corev1.PodSpec, level Level, fldPath *field.Path) field.ErrorList {
+	if level == LevelPrivileged {
+		return nil
+	}
+
+	errs := validateBaseline(spec, fldPath)
+	if level == LevelRestricted {
+		errs = append(errs, validateRestricted(spec, fldPath)...)
+	}
+
+	return errs
+}
+
+// ValidateObject validates the pod spec of a Pod, PodTemplate, or built-in
+// workload (eg. a Deployment or CronJob) against the given level. Other
+// kinds of objects have no pod spec, and are always valid.
+func ValidateObject(obj client.Object, level Level) field.ErrorList {
+	spec, fldPath := podSpecOf(obj)
+	if spec == nil {
+		return nil
+	}
+
+	return ValidatePodSpec(spec, level, fldPath)
+}
+
+// Validate validates a set of objects (eg. all of an operator's children)
+// against the given level, returning an Invalid error for the first
+// object with violations.
+func Validate(objs []client.Object, level Level) error {
+	for _, obj := range objs {
+		if errs := ValidateObject(obj, level); len(errs) > 0 {
+			gk := obj.GetObjectKind().GroupVersionKind().GroupKind()
+			if gk.Empty() {
+				gk = schema.GroupKind{Kind: fmt.Sprintf("%T", obj)}
+			}
+
+			return apierrors.NewInvalid(gk, obj.GetName(), errs)
+		}
+	}
+
+	return nil
+}
+
+// PreApplyHook returns an updater pre-apply hook that refuses to apply
+// templates that violate the given level.
+func PreApplyHook(level Level) updater.PreApplyHook {
+	return func(_ context.Context, template client.Object) error {
+		return Validate([]client.Object{template}, level)
+	}
+}
+
+func podSpecOf(obj client.Object) (*corev1.PodSpec, *field.Path) {
+	templatePath := field.NewPath("spec", "template", "spec")
+
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return &o.Spec, field.NewPath("spec")
+	case *corev1.PodTemplate:
+		return &o.Template.Spec, field.NewPath("template", "spec")
+	case *corev1.ReplicationController:
+		if o.Spec.Template == nil {
+			return nil, nil
+		}
+		return &o.Spec.Template.Spec, templatePath
+	case *appsv1.Deployment:
+		return &o.Spec.Template.Spec, templatePath
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.Spec, templatePath
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template.Spec, templatePath
+	case *appsv1.ReplicaSet:
+		return &o.Spec.Template.Spec, templatePath
+	case *batchv1.Job:
+		return &o.Spec.Template.Spec, templatePath
+	case *batchv1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template.Spec, field.NewPath("spec", "jobTemplate", "spec", "template", "spec")
+	default:
+		return nil, nil
+	}
+}
+
+// containerRef is a container along with its field path.
+type containerRef struct {
+	container *corev1.Container
+	path      *field.Path
+}
+
+func containers(spec *corev1.PodSpec, fldPath *field.Path) []containerRef {
+	var refs []containerRef
+	for i := range spec.InitContainers {
+		refs = append(refs, containerRef{&spec.InitContainers[i], fldPath.Child("initContainers").Index(i)})
+	}
+
+	for i := range spec.Containers {
+		refs = append(refs, containerRef{&spec.Containers[i], fldPath.Child("containers").Index(i)})
+	}
+
+	for i := range spec.EphemeralContainers {
+		refs = append(refs, containerRef{
+			(*corev1.Container)(&spec.EphemeralContainers[i].EphemeralContainerCommon),
+			fldPath.Child("ephemeralContainers").Index(i),
+		})
+	}
+
+	return refs
+}
+
+func validateBaseline(spec *corev1.PodSpec, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if spec.HostNetwork {
+		errs = append(errs, field.Forbidden(fldPath.Child("hostNetwork"), "host networking is not allowed"))
+	}
+
+	if spec.HostPID {
+		errs = append(errs, field.Forbidden(fldPath.Child("hostPID"), "sharing the host PID namespace is not allowed"))
+	}
+
+	if spec.HostIPC {
+		errs = append(errs, field.Forbidden(fldPath.Child("hostIPC"), "sharing the host IPC namespace is not allowed"))
+	}
+
+	for i, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			errs = append(errs, field.Forbidden(fldPath.Child("volumes").Index(i).Child("hostPath"), "host path volumes are not allowed"))
+		}
+	}
+
+	if sc := spec.SecurityContext; sc != nil {
+		scPath := fldPath.Child("securityContext")
+
+		errs = append(errs, validateSELinux(sc.SELinuxOptions, scPath.Child("seLinuxOptions"))...)
+		errs = append(errs, validateSeccompNotUnconfined(sc.SeccompProfile, scPath.Child("seccompProfile"))...)
+		errs = append(errs, validateHostProcess(sc.WindowsOptions, scPath.Child("windowsOptions"))...)
+
+		for i, sysctl := range sc.Sysctls {
+			if !baselineSysctls[sysctl.Name] {
+				errs = append(errs, field.Forbidden(scPath.Child("sysctls").Index(i), fmt.Sprintf("sysctl %q is not allowed", sysctl.Name)))
+			}
+		}
+	}
+
+	for _, ref := range containers(spec, fldPath) {
+		for i, port := range ref.container.Ports {
+			if port.HostPort != 0 {
+				errs = append(errs, field.Forbidden(ref.path.Child("ports").Index(i).Child("hostPort"), "host ports are not allowed"))
+			}
+		}
+
+		sc := ref.container.SecurityContext
+		if sc == nil {
+			continue
+		}
+
+		scPath := ref.path.Child("securityContext")
+
+		if sc.Privileged != nil && *sc.Privileged {
+			errs = append(errs, field.Forbidden(scPath.Child("privileged"), "privileged containers are not allowed"))
+		}
+
+		if sc.Capabilities != nil {
+			errs = append(errs, validateAddedCapabilities(sc.Capabilities.Add, baselineCapabilities, scPath.Child("capabilities", "add"))...)
+		}
+
+		if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+			errs = append(errs, field.Forbidden(scPath.Child("procMount"), "only the default proc mount is allowed"))
+		}
+
+		errs = append(errs, validateSELinux(sc.SELinuxOptions, scPath.Child("seLinuxOptions"))...)
+		errs = append(errs, validateSeccompNotUnconfined(sc.SeccompProfile, scPath.Child("seccompProfile"))...)
+		errs = append(errs, validateHostProcess(sc.WindowsOptions, scPath.Child("windowsOptions"))...)
+	}
+
+	return errs
+}
+
+func validateRestricted(spec *corev1.PodSpec, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for i, volume := range spec.Volumes {
+		vs := volume.VolumeSource
+		if vs.ConfigMap == nil && vs.CSI == nil && vs.DownwardAPI == nil && vs.EmptyDir == nil &&
+			vs.Ephemeral == nil && vs.PersistentVolumeClaim == nil && vs.Projected == nil && vs.Secret == nil &&
+			vs.HostPath == nil {
+			errs = append(errs, field.Forbidden(fldPath.Child("volumes").Index(i),
+				"only configMap, csi, downwardAPI, emptyDir, ephemeral, persistentVolumeClaim, projected, and secret volumes are allowed"))
+		}
+	}
+
+	podSC := spec.SecurityContext
+	if podSC == nil {
+		podSC = &corev1.PodSecurityContext{}
+	}
+	podSCPath := fldPath.Child("securityContext")
+
+	if podSC.RunAsUser != nil && *podSC.RunAsUser == 0 {
+		errs = append(errs, field.Forbidden(podSCPath.Child("runAsUser"), "running as root is not allowed"))
+	}
+
+	if podSC.RunAsNonRoot != nil && !*podSC.RunAsNonRoot {
+		errs = append(errs, field.Forbidden(podSCPath.Child("runAsNonRoot"), "must not be false"))
+	}
+
+	podNonRoot := podSC.RunAsNonRoot != nil && *podSC.RunAsNonRoot
+	podSeccomp := validSeccomp(podSC.SeccompProfile)
+
+	for _, ref := range containers(spec, fldPath) {
+		sc := ref.container.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+
+		scPath := ref.path.Child("securityContext")
+
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			errs = append(errs, field.Required(scPath.Child("allowPrivilegeEscalation"), "must be set to false"))
+		}
+
+		if sc.RunAsNonRoot != nil && !*sc.RunAsNonRoot {
+			errs = append(errs, field.Forbidden(scPath.Child("runAsNonRoot"), "must not be false"))
+		} else if sc.RunAsNonRoot == nil && !podNonRoot {
+			errs = append(errs, field.Required(scPath.Child("runAsNonRoot"), "must be set to true (on the container or pod)"))
+		}
+
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			errs = append(errs, field.Forbidden(scPath.Child("runAsUser"), "running as root is not allowed"))
+		}
+
+		if sc.SeccompProfile != nil {
+			if !validSeccomp(sc.SeccompProfile) {
+				errs = append(errs, field.NotSupported(scPath.Child("seccompProfile", "type"), sc.SeccompProfile.Type,
+					[]string{string(corev1.SeccompProfileTypeRuntimeDefault), string(corev1.SeccompProfileTypeLocalhost)}))
+			}
+		} else if !podSeccomp {
+			errs = append(errs, field.Required(scPath.Child("seccompProfile"), "must be set to RuntimeDefault or Localhost (on the container or pod)"))
+		}
+
+		var dropsAll bool
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Drop {
+				if strings.EqualFold(string(capability), "ALL") {
+					dropsAll = true
+				}
+			}
+
+			errs = append(errs, validateAddedCapabilities(sc.Capabilities.Add, restrictedCapabilities, scPath.Child("capabilities", "add"))...)
+		}
+
+		if !dropsAll {
+			errs = append(errs, field.Required(scPath.Child("capabilities", "drop"), `must drop "ALL" capabilities`))
+		}
+	}
+
+	return errs
+}
+
+func validateAddedCapabilities(capabilities []corev1.Capability, allowed map[string]bool, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, capability := range capabilities {
+		if !allowed[string(capability)] {
+			errs = append(errs, field.Forbidden(fldPath.Index(i), fmt.Sprintf("capability %q is not allowed", capability)))
+		}
+	}
+
+	return errs
+}
+
+func validateSELinux(opts *corev1.SELinuxOptions, fldPath *field.Path) field.ErrorList {
+	if opts == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if !baselineSELinuxTypes[opts.Type] {
+		errs = append(errs, field.Forbidden(fldPath.Child("type"), fmt.Sprintf("SELinux type %q is not allowed", opts.Type)))
+	}
+
+	if opts.User != "" {
+		errs = append(errs, field.Forbidden(fldPath.Child("user"), "setting the SELinux user is not allowed"))
+	}
+
+	if opts.Role != "" {
+		errs = append(errs, field.Forbidden(fldPath.Child("role"), "setting the SELinux role is not allowed"))
+	}
+
+	return errs
+}
+
+func validateSeccompNotUnconfined(profile *corev1.SeccompProfile, fldPath *field.Path) field.ErrorList {
+	if profile != nil && profile.Type == corev1.SeccompProfileTypeUnconfined {
+		return field.ErrorList{field.Forbidden(fldPath.Child("type"), "the Unconfined seccomp profile is not allowed")}
+	}
+
+	return nil
+}
+
+func validateHostProcess(opts *corev1.WindowsSecurityContextOptions, fldPath *field.Path) field.ErrorList {
+	if opts != nil && opts.HostProcess != nil && *opts.HostProcess {
+		return field.ErrorList{field.Forbidden(fldPath.Child("hostProcess"), "host process containers are not allowed")}
+	}
+
+	return nil
+}
+
+func validSeccomp(profile *corev1.SeccompProfile) bool {
+	return profile != nil &&
+		(profile.Type == corev1.SeccompProfileTypeRuntimeDefault || profile.Type == corev1.SeccompProfileTypeLocalhost)
+}
+
+func set(values ...string) map[string]bool {
+	m := make(map[string]bool, len(values))
+	for _, value := range values {
+		m[value] = true
+	}
+
+	return m
+}
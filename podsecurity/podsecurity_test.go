@@ -0,0 +1,163 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package podsecurity_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/podsecurity"
+	"github.com/gpu-ninja/operator-utils/podtemplate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestValidatePodSpec(t *testing.T) {
+	fldPath := field.NewPath("spec")
+
+	t.Run("Hardened", func(t *testing.T) {
+		spec := podtemplate.New().
+			AddContainer(corev1.Container{Name: "server"}).
+			AddCapabilities("server", "NET_BIND_SERVICE").
+			BuildSpec()
+
+		assert.Empty(t, podsecurity.ValidatePodSpec(&spec, podsecurity.LevelRestricted, fldPath))
+	})
+
+	t.Run("Baseline", func(t *testing.T) {
+		spec := corev1.PodSpec{
+			HostNetwork: true,
+			Volumes: []corev1.Volume{{
+				Name:         "host",
+				VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}},
+			}},
+			Containers: []corev1.Container{{
+				Name:  "server",
+				Ports: []corev1.ContainerPort{{ContainerPort: 80, HostPort: 80}},
+				SecurityContext: &corev1.SecurityContext{
+					Privileged:   ptr.To(true),
+					Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN", "CHOWN"}},
+				},
+			}},
+		}
+
+		errs := podsecurity.ValidatePodSpec(&spec, podsecurity.LevelBaseline, fldPath)
+
+		var fields []string
+		for _, err := range errs {
+			assert.Equal(t, field.ErrorTypeForbidden, err.Type)
+			fields = append(fields, err.Field)
+		}
+
+		assert.ElementsMatch(t, []string{
+			"spec.hostNetwork",
+			"spec.volumes[0].hostPath",
+			"spec.containers[0].ports[0].hostPort",
+			"spec.containers[0].securityContext.privileged",
+			"spec.containers[0].securityContext.capabilities.add[0]",
+		}, fields)
+
+		assert.Empty(t, podsecurity.ValidatePodSpec(&spec, podsecurity.LevelPrivileged, fldPath))
+	})
+
+	t.Run("Restricted", func(t *testing.T) {
+		spec := corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot:   ptr.To(true),
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Volumes: []corev1.Volume{{
+				Name:         "nfs",
+				VolumeSource: corev1.VolumeSource{NFS: &corev1.NFSVolumeSource{Server: "nfs", Path: "/"}},
+			}},
+			Containers: []corev1.Container{{
+				Name: "server",
+				SecurityContext: &corev1.SecurityContext{
+					RunAsUser:    ptr.To(int64(0)),
+					Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"CHOWN"}},
+				},
+			}},
+		}
+
+		assert.Empty(t, podsecurity.ValidatePodSpec(&spec, podsecurity.LevelBaseline, fldPath))
+
+		var fields []string
+		for _, err := range podsecurity.ValidatePodSpec(&spec, podsecurity.LevelRestricted, fldPath) {
+			fields = append(fields, err.Field)
+		}
+
+		assert.ElementsMatch(t, []string{
+			"spec.volumes[0]",
+			"spec.containers[0].securityContext.allowPrivilegeEscalation",
+			"spec.containers[0].securityContext.runAsUser",
+			"spec.containers[0].securityContext.capabilities.add[0]",
+			"spec.containers[0].securityContext.capabilities.drop",
+		}, fields)
+	})
+}
+
+func TestValidateObject(t *testing.T) {
+	spec := corev1.PodSpec{HostPID: true}
+
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: spec}},
+	}
+
+	errs := podsecurity.ValidateObject(deployment, podsecurity.LevelBaseline)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "spec.template.spec.hostPID", errs[0].Field)
+
+	cronJob := &batchv1.CronJob{
+		Spec: batchv1.CronJobSpec{
+			JobTemplate: batchv1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: spec}},
+			},
+		},
+	}
+
+	errs = podsecurity.ValidateObject(cronJob, podsecurity.LevelBaseline)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "spec.jobTemplate.spec.template.spec.hostPID", errs[0].Field)
+
+	assert.Empty(t, podsecurity.ValidateObject(&corev1.ConfigMap{}, podsecurity.LevelRestricted))
+}
+
+func TestPreApplyHook(t *testing.T) {
+	hook := podsecurity.PreApplyHook(podsecurity.LevelBaseline)
+
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec:       corev1.PodSpec{HostIPC: true},
+	}
+
+	err := hook(context.Background(), pod)
+	require.Error(t, err)
+	assert.True(t, apierrors.IsInvalid(err))
+
+	pod.Spec.HostIPC = false
+	assert.NoError(t, podsecurity.Validate([]client.Object{pod, &corev1.ConfigMap{}}, podsecurity.LevelBaseline))
+}
@@ -0,0 +1,287 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package podtemplate builds pod templates with security hardened defaults
+// that satisfy the "restricted" Pod Security Standard (non-root, read-only
+// root filesystem, all capabilities dropped, no privilege escalation, and
+// the RuntimeDefault seccomp profile).
+//
+// Defaults are only applied to fields that have not been set explicitly,
+// and each can be relaxed with an escape hatch, eg.
+//
+//	template := podtemplate.New().
+//		WithStandardLabels("widget", instance.Name, "server").
+//		AddContainer(corev1.Container{Name: "server", Image: image}).
+//		WithDefaultResources(resources).
+//		WithWritableTmp().
+//		Build()
+package podtemplate
+
+import (
+	"github.com/gpu-ninja/operator-utils/applabels"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// Standard labels.
+const (
+	NameLabel      = applabels.NameLabel
+	InstanceLabel  = applabels.InstanceLabel
+	ComponentLabel = applabels.ComponentLabel
+)
+
+// DefaultUserID is the (non-root) user and group pods run as by default
+// (the "nonroot" user of distroless images).
+const DefaultUserID int64 = 65532
+
+// tmpVolumeName is the name of the writable /tmp volume.
+const tmpVolumeName = "tmp"
+
+// Builder builds hardened pod templates.
+type Builder struct {
+	template         corev1.PodTemplateSpec
+	defaultResources *corev1.ResourceRequirements
+	addCapabilities  map[string][]corev1.Capability
+	allowRoot        bool
+	writableRootFS   bool
+	writableTmp      bool
+	mountToken       bool
+	mutations        []func(*corev1.PodTemplateSpec)
+}
+
+// New returns a new pod template builder.
+func New() *Builder {
+	return &Builder{
+		addCapabilities: make(map[string][]corev1.Capability),
+	}
+}
+
+// WithLabels adds labels to the pod template.
+func (b *Builder) WithLabels(labels map[string]string) *Builder {
+	if b.template.Labels == nil {
+		b.template.Labels = make(map[string]string, len(labels))
+	}
+
+	for key, value := range labels {
+		b.template.Labels[key] = value
+	}
+
+	return b
+}
+
+// WithStandardLabels sets the standard name, instance, and (if not empty)
+// component labels on the pod template.
+func (b *Builder) WithStandardLabels(name, instance, component string) *Builder {
+	labels := map[string]string{
+		NameLabel:     name,
+		InstanceLabel: instance,
+	}
+
+	if component != "" {
+		labels[ComponentLabel] = component
+	}
+
+	return b.WithLabels(labels)
+}
+
+// WithAnnotations adds annotations to the pod template.
+func (b *Builder) WithAnnotations(annotations map[string]string) *Builder {
+	if b.template.Annotations == nil {
+		b.template.Annotations = make(map[string]string, len(annotations))
+	}
+
+	for key, value := range annotations {
+		b.template.Annotations[key] = value
+	}
+
+	return b
+}
+
+// AddContainer adds a container.
+func (b *Builder) AddContainer(container corev1.Container) *Builder {
+	b.template.Spec.Containers = append(b.template.Spec.Containers, container)
+	return b
+}
+
+// AddInitContainer adds an init container.
+func (b *Builder) AddInitContainer(container corev1.Container) *Builder {
+	b.template.Spec.InitContainers = append(b.template.Spec.InitContainers, container)
+	return b
+}
+
+// AddVolume adds a volume.
+func (b *Builder) AddVolume(volume corev1.Volume) *Builder {
+	b.template.Spec.Volumes = append(b.template.Spec.Volumes, volume)
+	return b
+}
+
+// WithServiceAccount runs the pod as the given service account, and mounts
+// its token (which is otherwise not mounted).
+func (b *Builder) WithServiceAccount(name string) *Builder {
+	b.template.Spec.ServiceAccountName = name
+	b.mountToken = true
+	return b
+}
+
+// WithDefaultResources sets the resource requirements of containers that
+// don't specify their own.
+func (b *Builder) WithDefaultResources(resources corev1.ResourceRequirements) *Builder {
+	b.defaultResources = &resources
+	return b
+}
+
+// WithWritableTmp mounts a writable emptyDir at /tmp in every container
+// (as the root filesystem is read-only).
+func (b *Builder) WithWritableTmp() *Builder {
+	b.writableTmp = true
+	return b
+}
+
+// AllowWritableRootFilesystem does not make the root filesystem read-only.
+func (b *Builder) AllowWritableRootFilesystem() *Builder {
+	b.writableRootFS = true
+	return b
+}
+
+// AllowRoot does not require the pod to run as a non-root user. Note that
+// this violates the restricted Pod Security Standard.
+func (b *Builder) AllowRoot() *Builder {
+	b.allowRoot = true
+	return b
+}
+
+// AddCapabilities adds capabilities to the named container (eg.
+// NET_BIND_SERVICE, the only capability permitted by the restricted Pod
+// Security Standard).
+func (b *Builder) AddCapabilities(container string, capabilities ...corev1.Capability) *Builder {
+	b.addCapabilities[container] = append(b.addCapabilities[container], capabilities...)
+	return b
+}
+
+// Mutate registers a function that modifies the template after the
+// defaults are applied (the escape hatch of last resort).
+func (b *Builder) Mutate(fn func(template *corev1.PodTemplateSpec)) *Builder {
+	b.mutations = append(b.mutations, fn)
+	return b
+}
+
+// Build returns the pod template.
+func (b *Builder) Build() corev1.PodTemplateSpec {
+	template := *b.template.DeepCopy()
+	spec := &template.Spec
+
+	if spec.AutomountServiceAccountToken == nil {
+		spec.AutomountServiceAccountToken = ptr.To(b.mountToken)
+	}
+
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+
+	podSecurityContext := spec.SecurityContext
+	if !b.allowRoot {
+		if podSecurityContext.RunAsNonRoot == nil {
+			podSecurityContext.RunAsNonRoot = ptr.To(true)
+		}
+
+		if podSecurityContext.RunAsUser == nil {
+			podSecurityContext.RunAsUser = ptr.To(DefaultUserID)
+		}
+
+		if podSecurityContext.RunAsGroup == nil {
+			podSecurityContext.RunAsGroup = ptr.To(DefaultUserID)
+		}
+
+		if podSecurityContext.FSGroup == nil {
+			podSecurityContext.FSGroup = ptr.To(DefaultUserID)
+		}
+	}
+
+	if podSecurityContext.SeccompProfile == nil {
+		podSecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	}
+
+	if b.writableTmp {
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name:         tmpVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+
+	for i := range spec.InitContainers {
+		b.harden(&spec.InitContainers[i])
+	}
+
+	for i := range spec.Containers {
+		b.harden(&spec.Containers[i])
+	}
+
+	for _, fn := range b.mutations {
+		fn(&template)
+	}
+
+	return template
+}
+
+// BuildSpec returns the pod spec.
+func (b *Builder) BuildSpec() corev1.PodSpec {
+	return b.Build().Spec
+}
+
+// ObjectMeta returns the pod template's metadata (eg. for use as a
+// workload's selector labels).
+func (b *Builder) ObjectMeta() metav1.ObjectMeta {
+	return *b.template.ObjectMeta.DeepCopy()
+}
+
+func (b *Builder) harden(container *corev1.Container) {
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+
+	securityContext := container.SecurityContext
+
+	if securityContext.AllowPrivilegeEscalation == nil {
+		securityContext.AllowPrivilegeEscalation = ptr.To(false)
+	}
+
+	if securityContext.ReadOnlyRootFilesystem == nil {
+		securityContext.ReadOnlyRootFilesystem = ptr.To(!b.writableRootFS)
+	}
+
+	if securityContext.RunAsNonRoot == nil && !b.allowRoot {
+		securityContext.RunAsNonRoot = ptr.To(true)
+	}
+
+	if securityContext.Capabilities == nil {
+		securityContext.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+	}
+
+	securityContext.Capabilities.Add = append(securityContext.Capabilities.Add, b.addCapabilities[container.Name]...)
+
+	if b.defaultResources != nil && container.Resources.Limits == nil && container.Resources.Requests == nil {
+		container.Resources = *b.defaultResources.DeepCopy()
+	}
+
+	if b.writableTmp {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      tmpVolumeName,
+			MountPath: "/tmp",
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package podtemplate_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/podtemplate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+)
+
+func TestBuilder(t *testing.T) {
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+	}
+
+	template := podtemplate.New().
+		WithStandardLabels("widget", "test", "server").
+		WithAnnotations(map[string]string{"example.com/note": "true"}).
+		AddInitContainer(corev1.Container{Name: "init"}).
+		AddContainer(corev1.Container{Name: "server"}).
+		AddContainer(corev1.Container{
+			Name: "sidecar",
+			Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+			SecurityContext: &corev1.SecurityContext{ReadOnlyRootFilesystem: ptr.To(false)},
+		}).
+		WithDefaultResources(resources).
+		WithWritableTmp().
+		AddCapabilities("server", "NET_BIND_SERVICE").
+		Build()
+
+	assert.Equal(t, map[string]string{
+		podtemplate.NameLabel:      "widget",
+		podtemplate.InstanceLabel:  "test",
+		podtemplate.ComponentLabel: "server",
+	}, template.Labels)
+	assert.Equal(t, "true", template.Annotations["example.com/note"])
+
+	spec := template.Spec
+	assert.False(t, *spec.AutomountServiceAccountToken)
+	assert.True(t, *spec.SecurityContext.RunAsNonRoot)
+	assert.Equal(t, podtemplate.DefaultUserID, *spec.SecurityContext.RunAsUser)
+	assert.Equal(t, corev1.SeccompProfileTypeRuntimeDefault, spec.SecurityContext.SeccompProfile.Type)
+
+	require.Len(t, spec.Volumes, 1)
+	assert.NotNil(t, spec.Volumes[0].EmptyDir)
+
+	containers := append(spec.InitContainers, spec.Containers...)
+	require.Len(t, containers, 3)
+
+	for _, container := range containers {
+		securityContext := container.SecurityContext
+		assert.False(t, *securityContext.AllowPrivilegeEscalation, container.Name)
+		assert.True(t, *securityContext.RunAsNonRoot, container.Name)
+		assert.Equal(t, []corev1.Capability{"ALL"}, securityContext.Capabilities.Drop, container.Name)
+		assert.Equal(t, "/tmp", container.VolumeMounts[0].MountPath, container.Name)
+	}
+
+	server := spec.Containers[0]
+	assert.True(t, *server.SecurityContext.ReadOnlyRootFilesystem)
+	assert.Equal(t, []corev1.Capability{"NET_BIND_SERVICE"}, server.SecurityContext.Capabilities.Add)
+	assert.Equal(t, resources, server.Resources)
+
+	// Explicitly set fields are respected.
+	sidecar := spec.Containers[1]
+	assert.False(t, *sidecar.SecurityContext.ReadOnlyRootFilesystem)
+	assert.Empty(t, sidecar.SecurityContext.Capabilities.Add)
+	assert.Nil(t, sidecar.Resources.Requests)
+}
+
+func TestEscapeHatches(t *testing.T) {
+	spec := podtemplate.New().
+		AddContainer(corev1.Container{Name: "legacy"}).
+		WithServiceAccount("legacy").
+		AllowRoot().
+		AllowWritableRootFilesystem().
+		Mutate(func(template *corev1.PodTemplateSpec) {
+			template.Spec.HostNetwork = true
+		}).
+		BuildSpec()
+
+	assert.True(t, *spec.AutomountServiceAccountToken)
+	assert.Equal(t, "legacy", spec.ServiceAccountName)
+	assert.Nil(t, spec.SecurityContext.RunAsNonRoot)
+	assert.Nil(t, spec.SecurityContext.RunAsUser)
+	assert.True(t, spec.HostNetwork)
+
+	container := spec.Containers[0]
+	assert.Nil(t, container.SecurityContext.RunAsNonRoot)
+	assert.False(t, *container.SecurityContext.ReadOnlyRootFilesystem)
+	assert.False(t, *container.SecurityContext.AllowPrivilegeEscalation)
+}
@@ -0,0 +1,158 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package podutils provides helpers for interacting with running pods.
+package podutils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+var (
+	// ErrPodNotRunning is returned when the pod is not running.
+	ErrPodNotRunning = errors.New("pod is not running")
+	// ErrContainerNotFound is returned when the container does not exist in the pod.
+	ErrContainerNotFound = errors.New("container not found")
+	// ErrContainerNotRunning is returned when the container is not running.
+	ErrContainerNotRunning = errors.New("container is not running")
+)
+
+// ExecResult is the captured output of a command.
+type ExecResult struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// ExitError is returned when a command exits with a non-zero exit code.
+type ExitError struct {
+	// Code is the exit code of the command.
+	Code int
+	// Stderr is the captured stderr of the command.
+	Stderr []byte
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("command exited with code %d", e.Code)
+	if stderr := strings.TrimSpace(string(e.Stderr)); stderr != "" {
+		msg += ": " + stderr
+	}
+
+	return msg
+}
+
+// Exec runs a command in a container of a running pod, capturing its output.
+// The command is aborted when the context is cancelled (eg. use
+// context.WithTimeout to bound long running commands). If the command
+// exits with a non-zero exit code an *ExitError is returned (along with the
+// captured output).
+func Exec(ctx context.Context, cfg *rest.Config, pod *corev1.Pod, container string, cmd []string, stdin io.Reader) (*ExecResult, error) {
+	if err := checkContainerRunning(pod, container); err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   cmd,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(cfg, "POST", req.URL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	result := &ExecResult{
+		Stdout: stdout.Bytes(),
+		Stderr: stderr.Bytes(),
+	}
+
+	if err != nil {
+		var exitErr utilexec.ExitError
+		if errors.As(err, &exitErr) && exitErr.Exited() {
+			return result, &ExitError{Code: exitErr.ExitStatus(), Stderr: result.Stderr}
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return result, fmt.Errorf("failed to exec command: %w", ctxErr)
+		}
+
+		return result, fmt.Errorf("failed to exec command: %w", err)
+	}
+
+	return result, nil
+}
+
+func checkContainerRunning(pod *corev1.Pod, container string) error {
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("%w: %s/%s is %s", ErrPodNotRunning, pod.Namespace, pod.Name, pod.Status.Phase)
+	}
+
+	var found bool
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("%w: %q in pod %s/%s", ErrContainerNotFound, container, pod.Namespace, pod.Name)
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container {
+			if status.State.Running == nil {
+				return fmt.Errorf("%w: %q in pod %s/%s", ErrContainerNotRunning, container, pod.Namespace, pod.Name)
+			}
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %q in pod %s/%s", ErrContainerNotRunning, container, pod.Namespace, pod.Name)
+}
@@ -0,0 +1,155 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package podutils_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/podutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExec(t *testing.T) {
+	ctx := context.Background()
+	cfg := &rest.Config{Host: "http://127.0.0.1:0"}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-0"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "db"}},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "db",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}},
+			}},
+		},
+	}
+
+	t.Run("Pod Not Running", func(t *testing.T) {
+		_, err := podutils.Exec(ctx, cfg, pod, "db", []string{"true"}, nil)
+		assert.ErrorIs(t, err, podutils.ErrPodNotRunning)
+	})
+
+	pod.Status.Phase = corev1.PodRunning
+
+	t.Run("Container Not Found", func(t *testing.T) {
+		_, err := podutils.Exec(ctx, cfg, pod, "cache", []string{"true"}, nil)
+		assert.ErrorIs(t, err, podutils.ErrContainerNotFound)
+	})
+
+	t.Run("Container Not Running", func(t *testing.T) {
+		_, err := podutils.Exec(ctx, cfg, pod, "db", []string{"true"}, nil)
+		assert.ErrorIs(t, err, podutils.ErrContainerNotRunning)
+	})
+
+	pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+
+	t.Run("Unreachable", func(t *testing.T) {
+		_, err := podutils.Exec(ctx, cfg, pod, "db", []string{"true"}, nil)
+		require.Error(t, err)
+
+		var exitErr *podutils.ExitError
+		assert.False(t, errors.As(err, &exitErr))
+	})
+}
+
+func TestExitError(t *testing.T) {
+	err := &podutils.ExitError{Code: 2, Stderr: []byte("permission denied\n")}
+	assert.Equal(t, "command exited with code 2: permission denied", err.Error())
+}
+
+func TestPortForwardService(t *testing.T) {
+	ctx := context.Background()
+	cfg := &rest.Config{Host: "http://127.0.0.1:0"}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "db"},
+			Ports: []corev1.ServicePort{{
+				Name:       "admin",
+				Port:       80,
+				TargetPort: intstr.FromString("admin"),
+			}},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-0", Labels: map[string]string{"app": "db"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "db",
+				Ports: []corev1.ContainerPort{{Name: "admin", ContainerPort: 8080}},
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithObjects(pod).WithStatusSubresource(pod).Build()
+
+	t.Run("Port Not Found", func(t *testing.T) {
+		_, err := podutils.PortForwardService(ctx, cfg, c, svc, 443, podutils.PortForwardOptions{})
+		assert.ErrorIs(t, err, podutils.ErrPortNotFound)
+	})
+
+	t.Run("No Ready Pods", func(t *testing.T) {
+		_, err := podutils.PortForwardService(ctx, cfg, c, svc, 80, podutils.PortForwardOptions{})
+		assert.ErrorIs(t, err, podutils.ErrNoReadyPods)
+	})
+
+	pod.Status.Conditions[0].Status = corev1.ConditionTrue
+	require.NoError(t, c.Status().Update(ctx, pod))
+
+	t.Run("Forward", func(t *testing.T) {
+		pf, err := podutils.PortForwardService(ctx, cfg, c, svc, 80, podutils.PortForwardOptions{
+			RetryInterval: time.Millisecond,
+			MaxRetries:    1,
+		})
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, pf.Addr())
+
+		// The api server is unreachable so the local connection is closed
+		// once the retries are exhausted.
+		conn, err := net.Dial("tcp", pf.Addr())
+		require.NoError(t, err)
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+		_ = conn.Close()
+
+		require.NoError(t, pf.Close())
+	})
+}
@@ -0,0 +1,423 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package podutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	// ErrNoReadyPods is returned when a service has no ready pods to forward to.
+	ErrNoReadyPods = errors.New("no ready pods")
+	// ErrPortNotFound is returned when a service does not expose the requested port.
+	ErrPortNotFound = errors.New("port not found")
+)
+
+// PortForwardOptions configures a port-forward.
+type PortForwardOptions struct {
+	// LocalAddress is the local address to listen on. Defaults to "127.0.0.1:0"
+	// (an ephemeral port on the loopback interface).
+	LocalAddress string
+	// RetryInterval is the interval between reconnection attempts. Defaults to 1s.
+	RetryInterval time.Duration
+	// MaxRetries is the maximum number of reconnection attempts per local
+	// connection. Defaults to 5.
+	MaxRetries int
+}
+
+// resolveFunc returns the pod and container port to forward to.
+type resolveFunc func(ctx context.Context) (*corev1.Pod, int32, error)
+
+// PortForward forwards connections accepted on a local address to a port of
+// a pod. The upstream connection is re-established (and for services, the
+// target pod is re-resolved) when it is lost.
+type PortForward struct {
+	cfg       *rest.Config
+	clientset kubernetes.Interface
+	resolve   resolveFunc
+	opts      PortForwardOptions
+	listener  net.Listener
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu        sync.Mutex
+	conn      httpstream.Connection
+	pod       *corev1.Pod
+	port      int32
+	requestID int
+}
+
+// PortForwardPod starts forwarding a local address to a port of a pod.
+// Call Close to stop forwarding.
+func PortForwardPod(ctx context.Context, cfg *rest.Config, pod *corev1.Pod, port int32, opts PortForwardOptions) (*PortForward, error) {
+	resolve := func(_ context.Context) (*corev1.Pod, int32, error) {
+		if pod.Status.Phase != corev1.PodRunning {
+			return nil, 0, fmt.Errorf("%w: %s/%s is %s", ErrPodNotRunning, pod.Namespace, pod.Name, pod.Status.Phase)
+		}
+
+		return pod, port, nil
+	}
+
+	return newPortForward(ctx, cfg, resolve, opts)
+}
+
+// PortForwardService starts forwarding a local address to a port of a
+// service (by way of one of its ready pods). Call Close to stop forwarding.
+func PortForwardService(ctx context.Context, cfg *rest.Config, c client.Client, svc *corev1.Service, port int32, opts PortForwardOptions) (*PortForward, error) {
+	var servicePort *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		if svc.Spec.Ports[i].Port == port {
+			servicePort = &svc.Spec.Ports[i]
+			break
+		}
+	}
+
+	if servicePort == nil {
+		return nil, fmt.Errorf("%w: %d in service %s/%s", ErrPortNotFound, port, svc.Namespace, svc.Name)
+	}
+
+	resolve := func(ctx context.Context) (*corev1.Pod, int32, error) {
+		var pods corev1.PodList
+		if err := c.List(ctx, &pods, client.InNamespace(svc.Namespace), client.MatchingLabels(svc.Spec.Selector)); err != nil {
+			return nil, 0, fmt.Errorf("failed to list pods: %w", err)
+		}
+
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !isPodReady(pod) {
+				continue
+			}
+
+			containerPort, err := resolveTargetPort(pod, servicePort)
+			if err != nil {
+				continue
+			}
+
+			return pod, containerPort, nil
+		}
+
+		return nil, 0, fmt.Errorf("%w: service %s/%s", ErrNoReadyPods, svc.Namespace, svc.Name)
+	}
+
+	return newPortForward(ctx, cfg, resolve, opts)
+}
+
+func newPortForward(ctx context.Context, cfg *rest.Config, resolve resolveFunc, opts PortForwardOptions) (*PortForward, error) {
+	if opts.LocalAddress == "" {
+		opts.LocalAddress = "127.0.0.1:0"
+	}
+
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = time.Second
+	}
+
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 5
+	}
+
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+
+	// Fail fast if there is nothing to forward to.
+	pod, port, err := resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", opts.LocalAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	pf := &PortForward{
+		cfg:       cfg,
+		clientset: clientset,
+		resolve:   resolve,
+		opts:      opts,
+		listener:  listener,
+		pod:       pod,
+		port:      port,
+	}
+
+	pf.ctx, pf.cancel = context.WithCancel(log.IntoContext(context.Background(), log.FromContext(ctx)))
+
+	pf.wg.Add(1)
+	go pf.serve()
+
+	return pf, nil
+}
+
+// Addr returns the local address that is being forwarded (eg. "127.0.0.1:41235").
+func (pf *PortForward) Addr() string {
+	return pf.listener.Addr().String()
+}
+
+// Close stops forwarding and closes all connections.
+func (pf *PortForward) Close() error {
+	pf.cancel()
+	err := pf.listener.Close()
+
+	pf.mu.Lock()
+	if pf.conn != nil {
+		_ = pf.conn.Close()
+		pf.conn = nil
+	}
+	pf.mu.Unlock()
+
+	pf.wg.Wait()
+
+	return err
+}
+
+func (pf *PortForward) serve() {
+	defer pf.wg.Done()
+
+	logger := log.FromContext(pf.ctx)
+
+	for {
+		conn, err := pf.listener.Accept()
+		if err != nil {
+			if pf.ctx.Err() == nil {
+				logger.Error(err, "Failed to accept connection")
+			}
+
+			return
+		}
+
+		pf.wg.Add(1)
+		go func() {
+			defer pf.wg.Done()
+			defer conn.Close()
+
+			if err := pf.handle(conn); err != nil && pf.ctx.Err() == nil {
+				logger.Error(err, "Failed to forward connection")
+			}
+		}()
+	}
+}
+
+func (pf *PortForward) handle(conn net.Conn) error {
+	var errorStream, dataStream httpstream.Stream
+	var err error
+	for attempt := 0; attempt <= pf.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-pf.ctx.Done():
+				return pf.ctx.Err()
+			case <-time.After(pf.opts.RetryInterval):
+			}
+		}
+
+		errorStream, dataStream, err = pf.createStreams()
+		if err == nil {
+			break
+		}
+
+		log.FromContext(pf.ctx).Info("Failed to establish port-forward, retrying", "attempt", attempt+1, "error", err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	defer dataStream.Close()
+
+	remoteErr := make(chan error, 1)
+	go func() {
+		msg, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			remoteErr <- fmt.Errorf("failed to read error stream: %w", err)
+		case len(msg) > 0:
+			remoteErr <- fmt.Errorf("port-forward failed: %s", string(msg))
+		}
+		close(remoteErr)
+	}()
+
+	localErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, dataStream)
+		localErr <- err
+	}()
+
+	go func() {
+		_, _ = io.Copy(dataStream, conn)
+		// Signal to the remote end that there is nothing more to send.
+		_ = dataStream.Close()
+	}()
+
+	select {
+	case err := <-localErr:
+		if err != nil {
+			return fmt.Errorf("failed to copy from remote: %w", err)
+		}
+	case <-pf.ctx.Done():
+	}
+
+	return <-remoteErr
+}
+
+// createStreams creates a pair of error and data streams, (re)connecting
+// to the target pod if necessary.
+func (pf *PortForward) createStreams() (httpstream.Stream, httpstream.Stream, error) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	if pf.conn != nil {
+		select {
+		case <-pf.conn.CloseChan():
+			pf.conn = nil
+		default:
+		}
+	}
+
+	if pf.conn == nil {
+		if pf.pod == nil {
+			pod, port, err := pf.resolve(pf.ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			pf.pod, pf.port = pod, port
+		}
+
+		conn, err := pf.dial(pf.pod)
+		if err != nil {
+			// Re-resolve the target pod on the next attempt.
+			pf.pod = nil
+			return nil, nil, err
+		}
+
+		pf.conn = conn
+	}
+
+	pf.requestID++
+
+	headers := http.Header{}
+	headers.Set(corev1.PortHeader, strconv.Itoa(int(pf.port)))
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.Itoa(pf.requestID))
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	errorStream, err := pf.conn.CreateStream(headers)
+	if err != nil {
+		pf.reset()
+		return nil, nil, fmt.Errorf("failed to create error stream: %w", err)
+	}
+	// We only read from the error stream.
+	_ = errorStream.Close()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := pf.conn.CreateStream(headers)
+	if err != nil {
+		pf.reset()
+		return nil, nil, fmt.Errorf("failed to create data stream: %w", err)
+	}
+
+	return errorStream, dataStream, nil
+}
+
+func (pf *PortForward) reset() {
+	_ = pf.conn.Close()
+	pf.conn = nil
+	pf.pod = nil
+}
+
+func (pf *PortForward) dial(pod *corev1.Pod) (httpstream.Connection, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(pf.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create round tripper: %w", err)
+	}
+
+	url := pf.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward").
+		URL()
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	return conn, nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func resolveTargetPort(pod *corev1.Pod, servicePort *corev1.ServicePort) (int32, error) {
+	switch servicePort.TargetPort.Type {
+	case intstr.Int:
+		if servicePort.TargetPort.IntVal == 0 {
+			return servicePort.Port, nil
+		}
+
+		return servicePort.TargetPort.IntVal, nil
+	default:
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				if port.Name == servicePort.TargetPort.StrVal && protocolOrDefault(port.Protocol) == protocolOrDefault(servicePort.Protocol) {
+					return port.ContainerPort, nil
+				}
+			}
+		}
+
+		return 0, fmt.Errorf("%w: named port %q in pod %s/%s", ErrPortNotFound, servicePort.TargetPort.StrVal, pod.Namespace, pod.Name)
+	}
+}
+
+func protocolOrDefault(protocol corev1.Protocol) corev1.Protocol {
+	if protocol == "" {
+		return corev1.ProtocolTCP
+	}
+
+	return protocol
+}
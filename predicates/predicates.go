@@ -0,0 +1,136 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package predicates provides common predicates for filtering watch events.
+package predicates
+
+import (
+	"fmt"
+
+	"github.com/gpu-ninja/operator-utils/updater"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// PausedAnnotation is the annotation used to pause reconciliation of an object.
+	PausedAnnotation = "gpu-ninja.com/paused"
+)
+
+// GenerationOrAnnotationChanged passes update events where either the
+// generation (eg. the spec) or the annotations of the object have changed.
+// Status only updates are filtered out.
+func GenerationOrAnnotationChanged() predicate.Predicate {
+	return predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+}
+
+// TemplateHashChanged passes update events where the template hash annotation
+// stored by the updater has changed. This is useful for watching owned objects
+// and ignoring the noise from status updates and defaulted fields.
+func TemplateHashChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+
+			oldHash, err := updater.GetHash(e.ObjectOld)
+			if err != nil {
+				return false
+			}
+
+			newHash, err := updater.GetHash(e.ObjectNew)
+			if err != nil {
+				return false
+			}
+
+			return oldHash != newHash
+		},
+	}
+}
+
+// LabelSelector passes events for objects matching the given label selector.
+func LabelSelector(selector metav1.LabelSelector) (predicate.Predicate, error) {
+	p, err := predicate.LabelSelectorPredicate(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create label selector predicate: %w", err)
+	}
+
+	return p, nil
+}
+
+// NamespaceAllowlist passes events for objects in one of the given namespaces.
+// Cluster scoped objects are always passed.
+func NamespaceAllowlist(namespaces ...string) predicate.Predicate {
+	allowed := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		allowed[ns] = true
+	}
+
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetNamespace() == "" || allowed[obj.GetNamespace()]
+	})
+}
+
+// IsPaused returns true if the given object has the paused annotation set.
+func IsPaused(obj client.Object) bool {
+	return obj.GetAnnotations()[PausedAnnotation] == "true"
+}
+
+// NotPaused filters out events for objects that have been paused.
+// Delete events are always passed so that cleanup is never blocked.
+func NotPaused() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return e.Object != nil && !IsPaused(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectNew != nil && !IsPaused(e.ObjectNew)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return e.Object != nil && !IsPaused(e.Object)
+		},
+	}
+}
+
+// OwnedByController passes events for objects that are controlled by an
+// object of the same group and kind as the given owner type.
+func OwnedByController(scheme *runtime.Scheme, ownerType client.Object) (predicate.Predicate, error) {
+	gvk, err := apiutil.GVKForObject(ownerType, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get owner gvk: %w", err)
+	}
+
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		ownerRef := metav1.GetControllerOfNoCopy(obj)
+		if ownerRef == nil || ownerRef.Kind != gvk.Kind {
+			return false
+		}
+
+		ownerGV, err := schema.ParseGroupVersion(ownerRef.APIVersion)
+		if err != nil {
+			return false
+		}
+
+		return ownerGV.Group == gvk.Group
+	}), nil
+}
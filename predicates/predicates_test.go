@@ -0,0 +1,166 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package predicates_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/predicates"
+	"github.com/gpu-ninja/operator-utils/updater"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestGenerationOrAnnotationChanged(t *testing.T) {
+	p := predicates.GenerationOrAnnotationChanged()
+
+	oldObj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 1},
+	}
+
+	t.Run("Status Only", func(t *testing.T) {
+		newObj := oldObj.DeepCopy()
+		newObj.ResourceVersion = "2"
+
+		assert.False(t, p.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj}))
+	})
+
+	t.Run("Generation Changed", func(t *testing.T) {
+		newObj := oldObj.DeepCopy()
+		newObj.Generation = 2
+
+		assert.True(t, p.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj}))
+	})
+
+	t.Run("Annotation Changed", func(t *testing.T) {
+		newObj := oldObj.DeepCopy()
+		newObj.Annotations = map[string]string{"foo": "bar"}
+
+		assert.True(t, p.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj}))
+	})
+}
+
+func TestTemplateHashChanged(t *testing.T) {
+	p := predicates.TemplateHashChanged()
+
+	oldObj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+	}
+	require.NoError(t, updater.StoreHash(oldObj, "a"))
+
+	newObj := oldObj.DeepCopy()
+	newObj.Labels = map[string]string{"foo": "bar"}
+
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj}))
+
+	require.NoError(t, updater.StoreHash(newObj, "b"))
+
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: newObj}))
+	assert.True(t, p.Create(event.CreateEvent{Object: newObj}))
+}
+
+func TestLabelSelector(t *testing.T) {
+	p, err := predicates.LabelSelector(metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "test"},
+	})
+	require.NoError(t, err)
+
+	matching := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Labels: map[string]string{"app": "test"}},
+	}
+
+	assert.True(t, p.Create(event.CreateEvent{Object: matching}))
+	assert.False(t, p.Create(event.CreateEvent{Object: &corev1.ConfigMap{}}))
+}
+
+func TestNamespaceAllowlist(t *testing.T) {
+	p := predicates.NamespaceAllowlist("default", "other")
+
+	assert.True(t, p.Create(event.CreateEvent{Object: &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "other"},
+	}}))
+
+	assert.False(t, p.Create(event.CreateEvent{Object: &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "kube-system"},
+	}}))
+
+	assert.True(t, p.Create(event.CreateEvent{Object: &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+	}}))
+}
+
+func TestNotPaused(t *testing.T) {
+	p := predicates.NotPaused()
+
+	paused := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "default",
+			Annotations: map[string]string{predicates.PausedAnnotation: "true"},
+		},
+	}
+
+	assert.False(t, p.Create(event.CreateEvent{Object: paused}))
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: paused, ObjectNew: paused}))
+	assert.True(t, p.Delete(event.DeleteEvent{Object: paused}))
+
+	resumed := paused.DeepCopy()
+	resumed.Annotations = nil
+
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: paused, ObjectNew: resumed}))
+}
+
+func TestOwnedByController(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	p, err := predicates.OwnedByController(scheme, &appsv1.Deployment{})
+	require.NoError(t, err)
+
+	owned := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       "test",
+				Controller: ptr.To(true),
+			}},
+		},
+	}
+
+	assert.True(t, p.Create(event.CreateEvent{Object: owned}))
+
+	notController := owned.DeepCopy()
+	notController.OwnerReferences[0].Controller = nil
+
+	assert.False(t, p.Create(event.CreateEvent{Object: notController}))
+
+	otherKind := owned.DeepCopy()
+	otherKind.OwnerReferences[0].Kind = "StatefulSet"
+
+	assert.False(t, p.Create(event.CreateEvent{Object: otherKind}))
+}
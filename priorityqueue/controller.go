@@ -0,0 +1,181 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package priorityqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Controller is a minimal controller backed by a priority queue. The
+// controller-runtime controller does not allow its queue to be replaced, so
+// reconcilers opting into prioritization are run by this controller instead.
+type Controller struct {
+	name       string
+	reconciler reconcile.Reconciler
+	queue      *Queue
+	workers    int
+
+	mu      sync.Mutex
+	started bool
+	watches []watch
+}
+
+type watch struct {
+	src        source.Source
+	handler    handler.EventHandler
+	predicates []predicate.Predicate
+}
+
+var (
+	_ manager.Runnable               = (*Controller)(nil)
+	_ manager.LeaderElectionRunnable = (*Controller)(nil)
+)
+
+// NewController returns a new priority queue backed controller.
+func NewController(name string, r reconcile.Reconciler, workers int, opts Options) *Controller {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Controller{
+		name:       name,
+		reconciler: r,
+		queue:      New(opts),
+		workers:    workers,
+	}
+}
+
+// Queue returns the controller's queue.
+func (c *Controller) Queue() *Queue {
+	return c.queue
+}
+
+// Watch registers a source, the handler is wrapped with the classifier so
+// that events are prioritized.
+func (c *Controller) Watch(src source.Source, h handler.EventHandler, classifier Classifier, predicates ...predicate.Predicate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.started {
+		return errors.New("cannot add watches after the controller has started")
+	}
+
+	c.watches = append(c.watches, watch{src: src, handler: WithPriority(h, classifier), predicates: predicates})
+
+	return nil
+}
+
+// Start starts the watches and workers, blocking until the context is cancelled.
+func (c *Controller) Start(ctx context.Context) error {
+	c.mu.Lock()
+	if c.started {
+		c.mu.Unlock()
+		return errors.New("controller already started")
+	}
+	c.started = true
+	watches := c.watches
+	c.mu.Unlock()
+
+	logger := log.FromContext(ctx).WithValues("controller", c.name)
+	ctx = log.IntoContext(ctx, logger)
+
+	defer c.queue.ShutDownWithDrain()
+
+	for _, w := range watches {
+		if err := w.src.Start(ctx, w.handler, c.queue, w.predicates...); err != nil {
+			return fmt.Errorf("failed to start watch: %w", err)
+		}
+	}
+
+	for _, w := range watches {
+		if syncingSource, ok := w.src.(source.SyncingSource); ok {
+			if err := syncingSource.WaitForSync(ctx); err != nil {
+				return fmt.Errorf("failed to wait for caches to sync: %w", err)
+			}
+		}
+	}
+
+	logger.Info("Starting workers", "workers", c.workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNextItem(ctx) {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	c.queue.ShutDown()
+	wg.Wait()
+
+	return nil
+}
+
+// NeedLeaderElection returns true, as controllers should only run on the leader.
+func (c *Controller) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	req, ok := item.(reconcile.Request)
+	if !ok {
+		c.queue.Forget(item)
+		return true
+	}
+
+	logger := log.FromContext(ctx).WithValues("namespace", req.Namespace, "name", req.Name)
+
+	res, err := c.reconciler.Reconcile(log.IntoContext(ctx, logger), req)
+	switch {
+	case errors.Is(err, reconcile.TerminalError(nil)):
+		// Terminal errors won't resolve themselves, so aren't retried.
+		logger.Error(err, "Reconciler error, not retrying")
+		c.queue.Forget(req)
+	case err != nil:
+		logger.Error(err, "Reconciler error")
+		c.queue.AddRateLimited(req)
+	case res.RequeueAfter > 0:
+		c.queue.Forget(req)
+		c.queue.AddAfter(req, res.RequeueAfter)
+	case res.Requeue:
+		c.queue.AddRateLimited(req)
+	default:
+		c.queue.Forget(req)
+	}
+
+	return true
+}
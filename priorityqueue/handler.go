@@ -0,0 +1,116 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package priorityqueue
+
+import (
+	"context"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// Classifier assigns priorities to events.
+type Classifier interface {
+	Create(e event.CreateEvent) Priority
+	Update(e event.UpdateEvent) Priority
+	Delete(e event.DeleteEvent) Priority
+	Generic(e event.GenericEvent) Priority
+}
+
+// DefaultClassifier prioritizes deletions and user edits (generation
+// changes) above creations, and creations above other updates (eg. status
+// changes and periodic resyncs).
+type DefaultClassifier struct{}
+
+func (DefaultClassifier) Create(_ event.CreateEvent) Priority {
+	return PriorityNormal
+}
+
+func (DefaultClassifier) Update(e event.UpdateEvent) Priority {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return PriorityLow
+	}
+
+	if e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() ||
+		(e.ObjectOld.GetDeletionTimestamp().IsZero() && !e.ObjectNew.GetDeletionTimestamp().IsZero()) {
+		return PriorityHigh
+	}
+
+	return PriorityLow
+}
+
+func (DefaultClassifier) Delete(_ event.DeleteEvent) Priority {
+	return PriorityHigh
+}
+
+func (DefaultClassifier) Generic(_ event.GenericEvent) Priority {
+	return PriorityLow
+}
+
+// WithPriority wraps an event handler so that the items it enqueues are
+// prioritized by the classifier. If the queue does not support priorities,
+// items are enqueued as normal.
+func WithPriority(h handler.EventHandler, classifier Classifier) handler.EventHandler {
+	if classifier == nil {
+		classifier = DefaultClassifier{}
+	}
+
+	return &priorityHandler{handler: h, classifier: classifier}
+}
+
+type priorityHandler struct {
+	handler    handler.EventHandler
+	classifier Classifier
+}
+
+func (h *priorityHandler) Create(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Create(ctx, e, withPriority(q, h.classifier.Create(e)))
+}
+
+func (h *priorityHandler) Update(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Update(ctx, e, withPriority(q, h.classifier.Update(e)))
+}
+
+func (h *priorityHandler) Delete(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Delete(ctx, e, withPriority(q, h.classifier.Delete(e)))
+}
+
+func (h *priorityHandler) Generic(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Generic(ctx, e, withPriority(q, h.classifier.Generic(e)))
+}
+
+// priorityQueue intercepts Add calls, adding items with a fixed priority.
+type priorityQueue struct {
+	workqueue.RateLimitingInterface
+	adder    PriorityAdder
+	priority Priority
+}
+
+func (q *priorityQueue) Add(item any) {
+	q.adder.AddWithPriority(item, q.priority)
+}
+
+func withPriority(q workqueue.RateLimitingInterface, priority Priority) workqueue.RateLimitingInterface {
+	adder, ok := q.(PriorityAdder)
+	if !ok {
+		return q
+	}
+
+	return &priorityQueue{RateLimitingInterface: q, adder: adder, priority: priority}
+}
@@ -0,0 +1,221 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package priorityqueue_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/priorityqueue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+func TestQueue(t *testing.T) {
+	clock := clocktesting.NewFakeClock(time.Now())
+
+	q := priorityqueue.New(priorityqueue.Options{
+		AgingInterval: time.Second,
+		Clock:         clock,
+	})
+	defer q.ShutDown()
+
+	q.AddWithPriority("resync-a", priorityqueue.PriorityLow)
+	clock.Step(time.Millisecond)
+	q.AddWithPriority("resync-b", priorityqueue.PriorityLow)
+	clock.Step(time.Millisecond)
+	q.Add("create")
+	clock.Step(time.Millisecond)
+	q.AddWithPriority("delete", priorityqueue.PriorityHigh)
+
+	// Raises the priority of an already queued item.
+	q.AddWithPriority("resync-b", priorityqueue.PriorityHigh)
+	assert.Equal(t, 4, q.Len())
+
+	var order []string
+	for q.Len() > 0 {
+		item, shutdown := q.Get()
+		require.False(t, shutdown)
+		order = append(order, item.(string))
+		q.Done(item)
+	}
+
+	// Items of equal priority are processed in the order they were queued.
+	assert.Equal(t, []string{"resync-b", "delete", "create", "resync-a"}, order)
+
+	t.Run("Starvation", func(t *testing.T) {
+		q.AddWithPriority("resync", priorityqueue.PriorityLow)
+		clock.Step(11 * time.Second)
+		q.AddWithPriority("edit", priorityqueue.PriorityNormal)
+
+		item, _ := q.Get()
+		assert.Equal(t, "resync", item)
+		q.Done(item)
+
+		item, _ = q.Get()
+		q.Done(item)
+	})
+
+	t.Run("Dirty", func(t *testing.T) {
+		q.Add("item")
+
+		item, _ := q.Get()
+		q.Add("item")
+		assert.Equal(t, 0, q.Len())
+
+		q.Done(item)
+		assert.Equal(t, 1, q.Len())
+
+		item, _ = q.Get()
+		q.Done(item)
+	})
+
+	t.Run("AddAfter", func(t *testing.T) {
+		q.AddAfter("later", time.Minute)
+		assert.Equal(t, 0, q.Len())
+
+		clock.Step(time.Minute)
+		require.Eventually(t, func() bool { return q.Len() == 1 }, time.Second, 10*time.Millisecond)
+
+		item, _ := q.Get()
+		q.Done(item)
+	})
+}
+
+func TestShutDownWithDrain(t *testing.T) {
+	q := priorityqueue.New(priorityqueue.Options{})
+
+	q.Add("item")
+	item, _ := q.Get()
+
+	// Requeued once done.
+	q.Add("item")
+
+	drained := make(chan struct{})
+	go func() {
+		q.ShutDownWithDrain()
+		close(drained)
+	}()
+
+	require.Eventually(t, q.ShuttingDown, time.Second, 10*time.Millisecond)
+
+	select {
+	case <-drained:
+		t.Fatal("drained while an item was being processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.Done(item)
+
+	// The requeued item is still handed to a worker.
+	item, shutdown := q.Get()
+	require.False(t, shutdown)
+	assert.Equal(t, "item", item)
+
+	q.Done(item)
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the queue to drain")
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	q := priorityqueue.New(priorityqueue.Options{})
+	defer q.ShutDown()
+
+	h := priorityqueue.WithPriority(&handler.EnqueueRequestForObject{}, nil)
+
+	ctx := context.Background()
+	resynced := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "resynced", Generation: 1}}
+	edited := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "edited", Generation: 2}}
+
+	h.Update(ctx, event.UpdateEvent{ObjectOld: resynced, ObjectNew: resynced}, q)
+	h.Update(ctx, event.UpdateEvent{ObjectOld: resynced, ObjectNew: edited}, q)
+
+	item, _ := q.Get()
+	assert.Equal(t, "edited", item.(reconcile.Request).Name)
+	q.Done(item)
+}
+
+func TestController(t *testing.T) {
+	var mu sync.Mutex
+	var reconciled []string
+
+	c := priorityqueue.NewController("test", reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		reconciled = append(reconciled, req.Name)
+		if req.Name == "invalid" {
+			return reconcile.Result{}, reconcile.TerminalError(errors.New("invalid spec"))
+		}
+
+		return reconcile.Result{}, nil
+	}), 1, priorityqueue.Options{})
+
+	events := make(chan event.GenericEvent, 1)
+	require.NoError(t, c.Watch(&source.Channel{Source: events}, &handler.EnqueueRequestForObject{}, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- c.Start(ctx)
+	}()
+
+	events <- event.GenericEvent{Object: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(reconciled) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Terminal errors are not retried.
+	events <- event.GenericEvent{Object: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "invalid"}}}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(reconciled) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []string{"test", "invalid"}, reconciled)
+	mu.Unlock()
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,329 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package priorityqueue provides an opt-in priority aware reconcile queue,
+// so that urgent events (eg. deletions and user edits) are not delayed
+// behind periodic resyncs of a large number of objects.
+package priorityqueue
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
+)
+
+// Priority is the priority of a queued item, higher priority items are
+// processed first.
+type Priority int
+
+const (
+	PriorityLow    Priority = 0
+	PriorityNormal Priority = 10
+	PriorityHigh   Priority = 20
+)
+
+const (
+	// DefaultAgingInterval is how long an item waits before its priority is
+	// raised by one level.
+	DefaultAgingInterval = time.Second
+)
+
+// PriorityAdder is implemented by queues that support priorities.
+type PriorityAdder interface {
+	AddWithPriority(item any, priority Priority)
+}
+
+// Options configures a priority queue.
+type Options struct {
+	// RateLimiter is used for rate limited requeues.
+	RateLimiter workqueue.RateLimiter
+	// AgingInterval is how long an item waits before its effective priority
+	// is raised by one, this prevents starvation of low priority items.
+	AgingInterval time.Duration
+	// Clock is used to determine the current time (useful for testing).
+	Clock clock.WithDelayedExecution
+}
+
+type entry struct {
+	item     any
+	priority Priority
+	queuedAt time.Time
+	// rank orders entries (lowest first). As priorities age at a constant
+	// rate, the relative order of queued entries never changes: an entry
+	// ranks the same as one with a priority a level higher queued an aging
+	// interval later.
+	rank  time.Time
+	index int
+}
+
+func newEntry(item any, priority Priority, now time.Time, agingInterval time.Duration) *entry {
+	e := &entry{item: item, queuedAt: now}
+	e.setPriority(priority, agingInterval)
+
+	return e
+}
+
+func (e *entry) setPriority(priority Priority, agingInterval time.Duration) {
+	e.priority = priority
+	e.rank = e.queuedAt.Add(-time.Duration(priority) * agingInterval)
+}
+
+// entryHeap is a heap of queued entries, implementing heap.Interface.
+type entryHeap []*entry
+
+func (h entryHeap) Len() int {
+	return len(h)
+}
+
+func (h entryHeap) Less(i, j int) bool {
+	if !h[i].rank.Equal(h[j].rank) {
+		return h[i].rank.Before(h[j].rank)
+	}
+
+	return h[i].queuedAt.Before(h[j].queuedAt)
+}
+
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return e
+}
+
+// Queue is a priority aware implementation of workqueue.RateLimitingInterface.
+// Like the standard workqueue, an item is never processed concurrently and
+// items added while being processed are requeued once done.
+type Queue struct {
+	opts Options
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// drained is signalled when no items are being processed (it is
+	// separate from cond, so that waking a worker can't wake the drain
+	// waiter instead).
+	drained *sync.Cond
+
+	queued       map[any]*entry
+	heap         entryHeap
+	processing   map[any]bool
+	dirty        map[any]*entry
+	shuttingDown bool
+}
+
+var (
+	_ workqueue.RateLimitingInterface = (*Queue)(nil)
+	_ PriorityAdder                   = (*Queue)(nil)
+)
+
+// New returns a new priority queue.
+func New(opts Options) *Queue {
+	if opts.RateLimiter == nil {
+		opts.RateLimiter = workqueue.DefaultControllerRateLimiter()
+	}
+
+	if opts.AgingInterval == 0 {
+		opts.AgingInterval = DefaultAgingInterval
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	q := &Queue{
+		opts:       opts,
+		queued:     make(map[any]*entry),
+		processing: make(map[any]bool),
+		dirty:      make(map[any]*entry),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	q.drained = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// Add adds an item with normal priority.
+func (q *Queue) Add(item any) {
+	q.AddWithPriority(item, PriorityNormal)
+}
+
+// AddWithPriority adds an item with the given priority. If the item is
+// already queued, its priority is raised (but never lowered).
+func (q *Queue) AddWithPriority(item any, priority Priority) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.shuttingDown {
+		return
+	}
+
+	now := q.opts.Clock.Now()
+
+	if q.processing[item] {
+		if e, ok := q.dirty[item]; ok {
+			if priority > e.priority {
+				e.setPriority(priority, q.opts.AgingInterval)
+			}
+		} else {
+			q.dirty[item] = newEntry(item, priority, now, q.opts.AgingInterval)
+		}
+
+		return
+	}
+
+	if e, ok := q.queued[item]; ok {
+		if priority > e.priority {
+			e.setPriority(priority, q.opts.AgingInterval)
+			heap.Fix(&q.heap, e.index)
+		}
+
+		return
+	}
+
+	q.push(newEntry(item, priority, now, q.opts.AgingInterval))
+}
+
+// AddAfter adds an item with normal priority after the given delay.
+func (q *Queue) AddAfter(item any, duration time.Duration) {
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+
+	q.opts.Clock.AfterFunc(duration, func() {
+		// Some clocks invoke the callback synchronously while locked, so
+		// the item is added from a separate goroutine.
+		go q.Add(item)
+	})
+}
+
+// AddRateLimited adds an item after the rate limiter says it's ok.
+func (q *Queue) AddRateLimited(item any) {
+	q.AddAfter(item, q.opts.RateLimiter.When(item))
+}
+
+// Forget indicates an item is finished being retried.
+func (q *Queue) Forget(item any) {
+	q.opts.RateLimiter.Forget(item)
+}
+
+// NumRequeues returns the number of times an item has been requeued.
+func (q *Queue) NumRequeues(item any) int {
+	return q.opts.RateLimiter.NumRequeues(item)
+}
+
+// Len returns the number of queued items.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.queued)
+}
+
+// Get blocks until it can return the highest priority item. Items that
+// have been waiting are given a priority boost of one level per aging
+// interval, so that low priority items are eventually processed.
+func (q *Queue) Get() (any, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.queued) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+
+	if len(q.queued) == 0 {
+		return nil, true
+	}
+
+	e := heap.Pop(&q.heap).(*entry)
+	delete(q.queued, e.item)
+	q.processing[e.item] = true
+
+	return e.item, false
+}
+
+// Done marks an item as done processing, requeuing it if it was added
+// while being processed.
+func (q *Queue) Done(item any) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.processing, item)
+
+	if e, ok := q.dirty[item]; ok {
+		delete(q.dirty, item)
+		q.push(e)
+	}
+
+	if len(q.processing) == 0 {
+		q.drained.Broadcast()
+	}
+}
+
+// ShutDown stops the queue, waking any waiting workers.
+func (q *Queue) ShutDown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShutDownWithDrain stops the queue and waits for all items being processed
+// to be done.
+func (q *Queue) ShutDownWithDrain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+
+	for len(q.processing) > 0 {
+		q.drained.Wait()
+	}
+}
+
+// ShuttingDown returns true if the queue is shutting down.
+func (q *Queue) ShuttingDown() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.shuttingDown
+}
+
+// push queues an entry, waking a waiting worker.
+func (q *Queue) push(e *entry) {
+	q.queued[e.item] = e
+	heap.Push(&q.heap, e)
+	q.cond.Signal()
+}
@@ -0,0 +1,192 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package quantity provides arithmetic, validation, and formatting helpers
+// for resource quantities (eg. when computing the resource requirements of
+// child objects from fields of a custom resource).
+package quantity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ErrRequestExceedsLimit is returned when a resource request is greater than its limit.
+var ErrRequestExceedsLimit = errors.New("request exceeds limit")
+
+// Sum returns the sum of the quantities (in the format of the first quantity).
+func Sum(quantities ...resource.Quantity) resource.Quantity {
+	var sum resource.Quantity
+	for i, q := range quantities {
+		if i == 0 {
+			sum = q.DeepCopy()
+			continue
+		}
+
+		sum.Add(q)
+	}
+
+	return sum
+}
+
+// Scale returns the quantity multiplied by the factor, rounded up to the
+// nearest milli unit.
+func Scale(q resource.Quantity, factor float64) resource.Quantity {
+	f, ok := new(inf.Dec).SetString(strconv.FormatFloat(factor, 'f', -1, 64))
+	if !ok {
+		return resource.Quantity{Format: q.Format}
+	}
+
+	scaled := new(inf.Dec).Mul(q.AsDec(), f)
+	scaled.Round(scaled, 3, inf.RoundCeil)
+
+	return *resource.NewDecimalQuantity(*scaled, q.Format)
+}
+
+// PercentOf returns the given percentage (eg. 75 for 75%) of the quantity.
+func PercentOf(q resource.Quantity, percent float64) resource.Quantity {
+	return Scale(q, percent/100)
+}
+
+// Min returns the smallest of the quantities.
+func Min(q resource.Quantity, quantities ...resource.Quantity) resource.Quantity {
+	min := q
+	for _, other := range quantities {
+		if other.Cmp(min) < 0 {
+			min = other
+		}
+	}
+
+	return min.DeepCopy()
+}
+
+// Max returns the largest of the quantities.
+func Max(q resource.Quantity, quantities ...resource.Quantity) resource.Quantity {
+	max := q
+	for _, other := range quantities {
+		if other.Cmp(max) > 0 {
+			max = other
+		}
+	}
+
+	return max.DeepCopy()
+}
+
+// SumLists returns the sum of the resource lists (eg. to compute the total
+// requests of a pod's containers).
+func SumLists(lists ...corev1.ResourceList) corev1.ResourceList {
+	sum := corev1.ResourceList{}
+	for _, list := range lists {
+		for name, q := range list {
+			if existing, ok := sum[name]; ok {
+				existing.Add(q)
+				sum[name] = existing
+			} else {
+				sum[name] = q.DeepCopy()
+			}
+		}
+	}
+
+	return sum
+}
+
+// ScaleList returns the resource list with each quantity multiplied by the factor.
+func ScaleList(list corev1.ResourceList, factor float64) corev1.ResourceList {
+	scaled := make(corev1.ResourceList, len(list))
+	for name, q := range list {
+		scaled[name] = Scale(q, factor)
+	}
+
+	return scaled
+}
+
+// ValidateRequirements checks that no resource request exceeds its limit.
+func ValidateRequirements(req *corev1.ResourceRequirements) error {
+	names := make([]string, 0, len(req.Requests))
+	for name := range req.Requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		request := req.Requests[corev1.ResourceName(name)]
+
+		limit, ok := req.Limits[corev1.ResourceName(name)]
+		if !ok || request.Cmp(limit) <= 0 {
+			continue
+		}
+
+		errs = append(errs, fmt.Errorf("%w: %s request %s is greater than limit %s", ErrRequestExceedsLimit,
+			name, Format(corev1.ResourceName(name), request), Format(corev1.ResourceName(name), limit)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Format returns a human friendly representation of a quantity of the named
+// resource (eg. "0.5 cores", or "1.5Gi").
+func Format(name corev1.ResourceName, q resource.Quantity) string {
+	switch name {
+	case corev1.ResourceCPU, corev1.ResourceRequestsCPU, corev1.ResourceLimitsCPU:
+		return FormatCPU(q)
+	case corev1.ResourceMemory, corev1.ResourceRequestsMemory, corev1.ResourceLimitsMemory,
+		corev1.ResourceStorage, corev1.ResourceRequestsStorage, corev1.ResourceEphemeralStorage,
+		corev1.ResourceRequestsEphemeralStorage, corev1.ResourceLimitsEphemeralStorage:
+		return FormatBytes(q)
+	default:
+		return q.String()
+	}
+}
+
+// FormatCPU formats a CPU quantity as cores (eg. "500m" is "0.5 cores").
+func FormatCPU(q resource.Quantity) string {
+	cores := float64(q.MilliValue()) / 1000
+	if cores == 1 {
+		return "1 core"
+	}
+
+	return strconv.FormatFloat(cores, 'f', -1, 64) + " cores"
+}
+
+var binaryUnits = []string{"Ki", "Mi", "Gi", "Ti", "Pi", "Ei"}
+
+// FormatBytes formats a quantity of bytes using the largest binary unit
+// with at most one decimal place (eg. "1536Mi" is "1.5Gi").
+func FormatBytes(q resource.Quantity) string {
+	bytes := q.AsApproximateFloat64()
+
+	unit := ""
+	value := bytes
+	for _, u := range binaryUnits {
+		if math.Abs(value) < 1024 {
+			break
+		}
+
+		value /= 1024
+		unit = u
+	}
+
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64) + unit
+}
@@ -0,0 +1,91 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quantity_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/quantity"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestArithmetic(t *testing.T) {
+	mustParse := resource.MustParse
+
+	sum := quantity.Sum(mustParse("1Gi"), mustParse("512Mi"))
+	assert.Equal(t, "1536Mi", sum.String())
+
+	scaled := quantity.Scale(mustParse("2"), 0.75)
+	assert.Equal(t, "1500m", scaled.String())
+
+	// Rounds up to the nearest milli unit.
+	scaled = quantity.Scale(mustParse("1m"), 0.5)
+	assert.Equal(t, "1m", scaled.String())
+
+	percent := quantity.PercentOf(mustParse("4Gi"), 25)
+	assert.Equal(t, "1Gi", percent.String())
+
+	min := quantity.Min(mustParse("2Gi"), mustParse("1500Mi"), mustParse("3Gi"))
+	assert.Equal(t, "1500Mi", min.String())
+
+	max := quantity.Max(mustParse("100m"), mustParse("1"), mustParse("250m"))
+	assert.Equal(t, "1", max.String())
+
+	total := quantity.SumLists(
+		corev1.ResourceList{corev1.ResourceCPU: mustParse("500m"), corev1.ResourceMemory: mustParse("1Gi")},
+		corev1.ResourceList{corev1.ResourceCPU: mustParse("250m")},
+	)
+	assert.Equal(t, "750m", total.Cpu().String())
+	assert.Equal(t, "1Gi", total.Memory().String())
+
+	halved := quantity.ScaleList(total, 0.5)
+	assert.Equal(t, "375m", halved.Cpu().String())
+	assert.Equal(t, "512Mi", halved.Memory().String())
+}
+
+func TestValidateRequirements(t *testing.T) {
+	assert.NoError(t, quantity.ValidateRequirements(&corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+	}))
+
+	err := quantity.ValidateRequirements(&corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2"),
+			corev1.ResourceMemory: resource.MustParse("2Gi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("1536Mi"),
+		},
+	})
+	assert.ErrorIs(t, err, quantity.ErrRequestExceedsLimit)
+	assert.Equal(t, "request exceeds limit: cpu request 2 cores is greater than limit 0.5 cores\n"+
+		"request exceeds limit: memory request 2Gi is greater than limit 1.5Gi", err.Error())
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "1 core", quantity.Format(corev1.ResourceCPU, resource.MustParse("1000m")))
+	assert.Equal(t, "0.25 cores", quantity.Format(corev1.ResourceCPU, resource.MustParse("250m")))
+	assert.Equal(t, "512", quantity.Format(corev1.ResourceMemory, resource.MustParse("512")))
+	assert.Equal(t, "1.5Gi", quantity.Format(corev1.ResourceMemory, resource.MustParse("1536Mi")))
+	assert.Equal(t, "2Ti", quantity.Format(corev1.ResourceStorage, resource.MustParse("2Ti")))
+	assert.Equal(t, "4", quantity.Format("nvidia.com/gpu", resource.MustParse("4")))
+}
@@ -0,0 +1,331 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package quota checks whether creating a child would exceed its namespace's
+// ResourceQuotas, so that operators can report a clear condition instead of
+// repeatedly failing to create the child with an opaque Forbidden error.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gpu-ninja/operator-utils/quantity"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// ConditionTypeQuotaExceeded is the condition set on the parent when a
+	// child can't be created within the namespace's quota.
+	ConditionTypeQuotaExceeded = "QuotaExceeded"
+	// ReasonInsufficientQuota is the reason set when quota is exceeded.
+	ReasonInsufficientQuota = "InsufficientQuota"
+	// ReasonWithinQuota is the reason set once children fit within the quota.
+	ReasonWithinQuota = "WithinQuota"
+)
+
+// ErrQuotaExceeded is returned when creating an object would exceed a quota.
+var ErrQuotaExceeded = errors.New("would exceed quota")
+
+// Exceeded is a quota resource that would be exceeded.
+type Exceeded struct {
+	// Quota is the name of the ResourceQuota.
+	Quota string
+	// Resource is the name of the quota resource (eg. "requests.cpu").
+	Resource corev1.ResourceName
+	// Requested is the amount the object would consume.
+	Requested resource.Quantity
+	// Used is the amount already consumed.
+	Used resource.Quantity
+	// Hard is the quota limit.
+	Hard resource.Quantity
+}
+
+func (e *Exceeded) String() string {
+	return fmt.Sprintf("%s: %s (requested %s, used %s, limited %s)",
+		e.Quota, e.Resource, e.Requested.String(), e.Used.String(), e.Hard.String())
+}
+
+// ExceededError is returned when creating an object would exceed one or more quotas.
+type ExceededError struct {
+	// Object is the name of the object that would exceed the quota.
+	Object string
+	// Exceeded are the quota resources that would be exceeded.
+	Exceeded []Exceeded
+}
+
+func (e *ExceededError) Error() string {
+	var details []string
+	for i := range e.Exceeded {
+		details = append(details, e.Exceeded[i].String())
+	}
+
+	return fmt.Sprintf("creating %q would exceed quota: %s", e.Object, strings.Join(details, ", "))
+}
+
+func (e *ExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Usage returns the quota resources the object would consume once created
+// (eg. "requests.cpu", "pods", and "persistentvolumeclaims"). Workloads
+// consume the resources of all their replicas (DaemonSets are not supported,
+// as their usage depends on the number of nodes).
+func Usage(obj client.Object) corev1.ResourceList {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return podUsage(&o.Spec)
+	case *appsv1.Deployment:
+		return replicatedUsage(o.Spec.Replicas, &o.Spec.Template.Spec)
+	case *appsv1.StatefulSet:
+		usage := replicatedUsage(o.Spec.Replicas, &o.Spec.Template.Spec)
+		for i := range o.Spec.VolumeClaimTemplates {
+			claims := replicatedList(o.Spec.Replicas, claimUsage(&o.Spec.VolumeClaimTemplates[i]))
+			usage = quantity.SumLists(usage, claims)
+		}
+		return usage
+	case *appsv1.ReplicaSet:
+		return replicatedUsage(o.Spec.Replicas, &o.Spec.Template.Spec)
+	case *batchv1.Job:
+		return replicatedUsage(o.Spec.Parallelism, &o.Spec.Template.Spec)
+	case *corev1.PersistentVolumeClaim:
+		return claimUsage(o)
+	case *corev1.Service:
+		usage := corev1.ResourceList{corev1.ResourceServices: resource.MustParse("1")}
+		switch o.Spec.Type {
+		case corev1.ServiceTypeLoadBalancer:
+			usage[corev1.ResourceServicesLoadBalancers] = resource.MustParse("1")
+			usage[corev1.ResourceServicesNodePorts] = *resource.NewQuantity(int64(len(o.Spec.Ports)), resource.DecimalSI)
+		case corev1.ServiceTypeNodePort:
+			usage[corev1.ResourceServicesNodePorts] = *resource.NewQuantity(int64(len(o.Spec.Ports)), resource.DecimalSI)
+		}
+		return usage
+	case *corev1.ConfigMap:
+		return corev1.ResourceList{corev1.ResourceConfigMaps: resource.MustParse("1")}
+	case *corev1.Secret:
+		return corev1.ResourceList{corev1.ResourceSecrets: resource.MustParse("1")}
+	default:
+		return corev1.ResourceList{}
+	}
+}
+
+// Check returns an *ExceededError if creating the object would exceed any
+// of the (unscoped) ResourceQuotas in its namespace. Quotas with scopes are
+// not evaluated. Check should only be called before creating an object, as
+// updates only consume the difference in usage.
+func Check(ctx context.Context, c client.Client, obj client.Object) error {
+	usage := Usage(obj)
+	if len(usage) == 0 {
+		return nil
+	}
+
+	var quotas corev1.ResourceQuotaList
+	if err := c.List(ctx, &quotas, client.InNamespace(obj.GetNamespace())); err != nil {
+		return fmt.Errorf("failed to list resource quotas: %w", err)
+	}
+
+	exceededErr := &ExceededError{Object: obj.GetName()}
+	for _, rq := range quotas.Items {
+		if len(rq.Spec.Scopes) > 0 || rq.Spec.ScopeSelector != nil {
+			continue
+		}
+
+		for name, requested := range usage {
+			hard, ok := rq.Status.Hard[name]
+			if !ok {
+				continue
+			}
+
+			used := rq.Status.Used[name]
+
+			total := used.DeepCopy()
+			total.Add(requested)
+			if total.Cmp(hard) > 0 {
+				exceededErr.Exceeded = append(exceededErr.Exceeded, Exceeded{
+					Quota:     rq.Name,
+					Resource:  name,
+					Requested: requested,
+					Used:      used,
+					Hard:      hard,
+				})
+			}
+		}
+	}
+
+	if len(exceededErr.Exceeded) == 0 {
+		return nil
+	}
+
+	sort.Slice(exceededErr.Exceeded, func(i, j int) bool {
+		if exceededErr.Exceeded[i].Quota != exceededErr.Exceeded[j].Quota {
+			return exceededErr.Exceeded[i].Quota < exceededErr.Exceeded[j].Quota
+		}
+
+		return exceededErr.Exceeded[i].Resource < exceededErr.Exceeded[j].Resource
+	})
+
+	return exceededErr
+}
+
+// CreateIfWithinQuota creates the object if it would not exceed any quotas,
+// otherwise a terminal *ExceededError is returned (as retrying won't help
+// until the quota or the object changes).
+func CreateIfWithinQuota(ctx context.Context, c client.Client, obj client.Object, opts ...client.CreateOption) error {
+	if err := Check(ctx, c, obj); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			return reconcile.TerminalError(err)
+		}
+
+		return err
+	}
+
+	if err := c.Create(ctx, obj, opts...); err != nil {
+		return fmt.Errorf("failed to create object: %w", err)
+	}
+
+	return nil
+}
+
+// SetCondition sets the QuotaExceeded condition on the given conditions
+// slice (typically the parent's status.conditions) from the outcome of a
+// quota check.
+func SetCondition(conditions *[]metav1.Condition, err error, generation int64) {
+	condition := metav1.Condition{
+		Type:               ConditionTypeQuotaExceeded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonWithinQuota,
+	}
+
+	var exceededErr *ExceededError
+	if errors.As(err, &exceededErr) {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonInsufficientQuota
+		condition.Message = exceededErr.Error()
+	}
+
+	meta.SetStatusCondition(conditions, condition)
+}
+
+func replicatedUsage(replicas *int32, spec *corev1.PodSpec) corev1.ResourceList {
+	return replicatedList(replicas, podUsage(spec))
+}
+
+func replicatedList(replicas *int32, list corev1.ResourceList) corev1.ResourceList {
+	n := int32(1)
+	if replicas != nil {
+		n = *replicas
+	}
+
+	return quantity.ScaleList(list, float64(n))
+}
+
+// podUsage mirrors the quota evaluator's accounting of a pod's resources.
+func podUsage(spec *corev1.PodSpec) corev1.ResourceList {
+	requests := effectiveResources(spec, true)
+	limits := effectiveResources(spec, false)
+
+	usage := corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")}
+	for name, q := range requests {
+		if isStandardComputeResource(name) {
+			usage[name] = q
+		}
+		usage[corev1.ResourceName("requests."+string(name))] = q
+	}
+
+	for name, q := range limits {
+		if isStandardComputeResource(name) {
+			usage[corev1.ResourceName("limits."+string(name))] = q
+		}
+	}
+
+	return usage
+}
+
+// effectiveResources returns the greater of the sum of the containers'
+// resources, and the largest init container's resources, plus overhead.
+func effectiveResources(spec *corev1.PodSpec, requests bool) corev1.ResourceList {
+	resourcesOf := func(c *corev1.Container) corev1.ResourceList {
+		if !requests {
+			return c.Resources.Limits
+		}
+
+		// Requests default to limits.
+		list := corev1.ResourceList{}
+		for name, q := range c.Resources.Limits {
+			list[name] = q
+		}
+		for name, q := range c.Resources.Requests {
+			list[name] = q
+		}
+		return list
+	}
+
+	var containers []corev1.ResourceList
+	for i := range spec.Containers {
+		containers = append(containers, resourcesOf(&spec.Containers[i]))
+	}
+	total := quantity.SumLists(containers...)
+
+	for i := range spec.InitContainers {
+		for name, q := range resourcesOf(&spec.InitContainers[i]) {
+			if existing, ok := total[name]; !ok || q.Cmp(existing) > 0 {
+				total[name] = q.DeepCopy()
+			}
+		}
+	}
+
+	return quantity.SumLists(total, spec.Overhead)
+}
+
+func claimUsage(pvc *corev1.PersistentVolumeClaim) corev1.ResourceList {
+	usage := corev1.ResourceList{corev1.ResourcePersistentVolumeClaims: resource.MustParse("1")}
+
+	storage, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if ok {
+		usage[corev1.ResourceRequestsStorage] = storage
+	}
+
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		prefix := *pvc.Spec.StorageClassName + ".storageclass.storage.k8s.io/"
+		usage[corev1.ResourceName(prefix+string(corev1.ResourcePersistentVolumeClaims))] = resource.MustParse("1")
+		if ok {
+			usage[corev1.ResourceName(prefix+string(corev1.ResourceRequestsStorage))] = storage
+		}
+	}
+
+	return usage
+}
+
+func isStandardComputeResource(name corev1.ResourceName) bool {
+	switch name {
+	case corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+		return true
+	default:
+		return false
+	}
+}
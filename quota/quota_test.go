@@ -0,0 +1,163 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quota_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestUsage(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(int32(3)),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+						},
+					}},
+					Containers: []corev1.Container{
+						{Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+							Limits: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("1Gi"),
+								"nvidia.com/gpu":      resource.MustParse("1"),
+							},
+						}},
+						{Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	usage := quota.Usage(deployment)
+
+	assertQuantity(t, "3", usage[corev1.ResourcePods])
+	// The init container requests more than the containers combined.
+	assertQuantity(t, "6", usage[corev1.ResourceRequestsCPU])
+	assertQuantity(t, "6", usage[corev1.ResourceCPU])
+	// Requests default to limits.
+	assertQuantity(t, "3Gi", usage[corev1.ResourceRequestsMemory])
+	assertQuantity(t, "3Gi", usage[corev1.ResourceLimitsMemory])
+	assertQuantity(t, "3", usage["requests.nvidia.com/gpu"])
+
+	pvc := &corev1.PersistentVolumeClaim{
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: ptr.To("fast"),
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		},
+	}
+
+	usage = quota.Usage(pvc)
+	assertQuantity(t, "1", usage[corev1.ResourcePersistentVolumeClaims])
+	assertQuantity(t, "10Gi", usage["fast.storageclass.storage.k8s.io/requests.storage"])
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+
+	rq := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "compute"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{
+				corev1.ResourceRequestsCPU: resource.MustParse("4"),
+				corev1.ResourcePods:        resource.MustParse("10"),
+			},
+			Used: corev1.ResourceList{
+				corev1.ResourceRequestsCPU: resource.MustParse("3"),
+				corev1.ResourcePods:        resource.MustParse("2"),
+			},
+		},
+	}
+
+	scoped := rq.DeepCopy()
+	scoped.Name = "best-effort"
+	scoped.Spec.Scopes = []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort}
+	scoped.Status.Hard[corev1.ResourcePods] = resource.MustParse("0")
+
+	c := fake.NewClientBuilder().WithObjects(rq, scoped).Build()
+
+	newPod := func(name, cpu string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+				},
+			}}},
+		}
+	}
+
+	require.NoError(t, quota.Check(ctx, c, newPod("small", "1")))
+
+	err := quota.Check(ctx, c, newPod("large", "2"))
+	require.ErrorIs(t, err, quota.ErrQuotaExceeded)
+
+	var exceededErr *quota.ExceededError
+	require.True(t, errors.As(err, &exceededErr))
+	require.Len(t, exceededErr.Exceeded, 1)
+	assert.Equal(t, corev1.ResourceRequestsCPU, exceededErr.Exceeded[0].Resource)
+	assert.EqualError(t, err, `creating "large" would exceed quota: compute: requests.cpu (requested 2, used 3, limited 4)`)
+
+	var conditions []metav1.Condition
+	quota.SetCondition(&conditions, err, 1)
+	require.Len(t, conditions, 1)
+	assert.Equal(t, metav1.ConditionTrue, conditions[0].Status)
+	assert.Equal(t, quota.ReasonInsufficientQuota, conditions[0].Reason)
+
+	quota.SetCondition(&conditions, nil, 1)
+	assert.Equal(t, metav1.ConditionFalse, conditions[0].Status)
+
+	t.Run("CreateIfWithinQuota", func(t *testing.T) {
+		err := quota.CreateIfWithinQuota(ctx, c, newPod("large", "2"))
+		assert.ErrorIs(t, err, reconcile.TerminalError(nil))
+		assert.ErrorIs(t, err, quota.ErrQuotaExceeded)
+
+		pod := newPod("small", "1")
+		require.NoError(t, quota.CreateIfWithinQuota(ctx, c, pod))
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(pod), pod))
+	})
+}
+
+func assertQuantity(t *testing.T, expected string, actual resource.Quantity) {
+	t.Helper()
+
+	q := resource.MustParse(expected)
+	assert.Zero(t, q.Cmp(actual), "expected %s, got %s", expected, actual.String())
+}
@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package ratelimit provides tuned workqueue rate limiters for controllers.
+package ratelimit
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	DefaultBaseDelay = 500 * time.Millisecond
+	DefaultMaxDelay  = 5 * time.Minute
+	DefaultQPS       = 10
+	DefaultBurst     = 100
+)
+
+// Options configures a rate limiter.
+type Options struct {
+	// BaseDelay is the delay before the first retry of a failing item, it
+	// doubles with each subsequent failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the per-item retry delay.
+	MaxDelay time.Duration
+	// QPS is the overall rate of retries allowed across all items.
+	QPS float64
+	// Burst is the overall number of retries allowed in a burst.
+	Burst int
+	// MaxConcurrentReconciles is the maximum number of concurrent reconciles
+	// (used when wiring controller options).
+	MaxConcurrentReconciles int
+}
+
+// Limiter is a workqueue rate limiter combining per-item exponential
+// backoff with an overall token bucket.
+type Limiter struct {
+	workqueue.RateLimiter
+	opts Options
+}
+
+// New returns a new rate limiter. Zero valued options are defaulted.
+func New(opts Options) *Limiter {
+	if opts.BaseDelay == 0 {
+		opts.BaseDelay = DefaultBaseDelay
+	}
+
+	if opts.MaxDelay == 0 {
+		opts.MaxDelay = DefaultMaxDelay
+	}
+
+	if opts.QPS == 0 {
+		opts.QPS = DefaultQPS
+	}
+
+	if opts.Burst == 0 {
+		opts.Burst = DefaultBurst
+	}
+
+	return &Limiter{
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(opts.BaseDelay, opts.MaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(opts.QPS), opts.Burst)},
+		),
+		opts: opts,
+	}
+}
+
+// ControllerOptions returns controller options using the rate limiter.
+func (l *Limiter) ControllerOptions() controller.Options {
+	return controller.Options{
+		RateLimiter:             l,
+		MaxConcurrentReconciles: l.opts.MaxConcurrentReconciles,
+	}
+}
+
+// ResetOnGenerationChange returns a predicate that resets the backoff of
+// an object whenever its spec changes (ie. its generation is bumped), so
+// that user initiated changes are retried quickly rather than waiting out
+// the backoff accumulated by earlier failures. The predicate never filters
+// events and can be combined with other predicates.
+func (l *Limiter) ResetOnGenerationChange() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld != nil && e.ObjectNew != nil && e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() {
+				l.Forget(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(e.ObjectNew)})
+			}
+
+			return true
+		},
+	}
+}
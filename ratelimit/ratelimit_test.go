@@ -0,0 +1,67 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/ratelimit"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestLimiter(t *testing.T) {
+	l := ratelimit.New(ratelimit.Options{
+		BaseDelay:               time.Second,
+		MaxDelay:                4 * time.Second,
+		MaxConcurrentReconciles: 4,
+	})
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "test"}}
+
+	assert.Equal(t, time.Second, l.When(req))
+	assert.Equal(t, 2*time.Second, l.When(req))
+	assert.Equal(t, 4*time.Second, l.When(req))
+	assert.Equal(t, 4*time.Second, l.When(req))
+	assert.Equal(t, 4, l.NumRequeues(req))
+
+	opts := l.ControllerOptions()
+	assert.Equal(t, 4, opts.MaxConcurrentReconciles)
+	assert.Equal(t, l, opts.RateLimiter)
+
+	t.Run("ResetOnGenerationChange", func(t *testing.T) {
+		old := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+
+		p := l.ResetOnGenerationChange()
+
+		// Status only changes (eg. resyncs) don't reset the backoff.
+		assert.True(t, p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: old.DeepCopy()}))
+		assert.Equal(t, 4, l.NumRequeues(req))
+
+		updated := old.DeepCopy()
+		updated.Generation = 2
+		assert.True(t, p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated}))
+		assert.Equal(t, 0, l.NumRequeues(req))
+		assert.Equal(t, time.Second, l.When(req))
+	})
+}
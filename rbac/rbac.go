@@ -0,0 +1,254 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package rbac derives the minimal RBAC rules an operator needs to manage
+// a set of objects (eg. the templates of its child objects), so that its
+// roles can be kept in sync with what it actually applies.
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultVerbs are the verbs needed to manage (create, update, delete, and watch) an object.
+var DefaultVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+
+// Options configures rule derivation.
+type Options struct {
+	// Verbs are the verbs granted on each resource. Defaults to DefaultVerbs.
+	Verbs []string
+	// Subresources are additional subresources (eg. "status") the operator
+	// updates, for each resource.
+	Subresources []string
+}
+
+// Rules are the derived RBAC rules, split by the scope of the resources.
+type Rules struct {
+	// Namespaced are the rules for namespaced resources.
+	Namespaced []rbacv1.PolicyRule
+	// ClusterScoped are the rules for cluster scoped resources.
+	ClusterScoped []rbacv1.PolicyRule
+}
+
+type ruleKey struct {
+	group      string
+	namespaced bool
+}
+
+// Derive returns the minimal rules needed to manage the given objects. Objects
+// may be typed (registered in the scheme) or unstructured. Managing roles and
+// role bindings also requires the "escalate" and "bind" verbs (as the operator
+// would otherwise need to hold every permission it grants).
+func Derive(mapper meta.RESTMapper, scheme *runtime.Scheme, objs []client.Object, opts Options) (*Rules, error) {
+	if len(opts.Verbs) == 0 {
+		opts.Verbs = DefaultVerbs
+	}
+
+	// group -> resource -> verbs
+	resources := make(map[ruleKey]map[string]map[string]bool)
+	add := func(key ruleKey, resource string, verbs ...string) {
+		if resources[key] == nil {
+			resources[key] = make(map[string]map[string]bool)
+		}
+		if resources[key][resource] == nil {
+			resources[key][resource] = make(map[string]bool)
+		}
+		for _, verb := range verbs {
+			resources[key][resource][verb] = true
+		}
+	}
+
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get gvk of %q: %w", obj.GetName(), err)
+		}
+
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get rest mapping for %s: %w", gvk.Kind, err)
+		}
+
+		key := ruleKey{
+			group:      gvk.Group,
+			namespaced: mapping.Scope.Name() == meta.RESTScopeNameNamespace,
+		}
+
+		resource := mapping.Resource.Resource
+		add(key, resource, opts.Verbs...)
+
+		for _, subresource := range opts.Subresources {
+			add(key, resource+"/"+subresource, "get", "update", "patch")
+		}
+
+		if gvk.Group == rbacv1.GroupName {
+			switch gvk.Kind {
+			case "Role", "ClusterRole":
+				add(key, resource, "escalate")
+			case "RoleBinding", "ClusterRoleBinding":
+				add(key, resource, "bind")
+			}
+		}
+	}
+
+	rules := &Rules{}
+	for key, byResource := range resources {
+		// Resources with identical verbs are combined into a single rule.
+		byVerbs := make(map[string][]string)
+		for resource, verbs := range byResource {
+			verbsKey := strings.Join(sortedVerbs(verbs), ",")
+			byVerbs[verbsKey] = append(byVerbs[verbsKey], resource)
+		}
+
+		for verbsKey, resourceNames := range byVerbs {
+			sort.Strings(resourceNames)
+
+			rule := rbacv1.PolicyRule{
+				APIGroups: []string{key.group},
+				Resources: resourceNames,
+				Verbs:     strings.Split(verbsKey, ","),
+			}
+
+			if key.namespaced {
+				rules.Namespaced = append(rules.Namespaced, rule)
+			} else {
+				rules.ClusterScoped = append(rules.ClusterScoped, rule)
+			}
+		}
+	}
+
+	sortRules(rules.Namespaced)
+	sortRules(rules.ClusterScoped)
+
+	return rules, nil
+}
+
+// All returns all the rules (eg. for a ClusterRole when the operator manages
+// objects in every namespace).
+func (r *Rules) All() []rbacv1.PolicyRule {
+	all := append(append([]rbacv1.PolicyRule(nil), r.ClusterScoped...), r.Namespaced...)
+	sortRules(all)
+
+	return all
+}
+
+// ClusterRole returns a ClusterRole granting all the rules.
+func (r *Rules) ClusterRole(name string) *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "ClusterRole",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Rules: r.All(),
+	}
+}
+
+// Role returns a Role granting the namespaced rules.
+func (r *Rules) Role(namespace, name string) *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "Role",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Rules: append([]rbacv1.PolicyRule(nil), r.Namespaced...),
+	}
+}
+
+// KubebuilderMarkers returns kubebuilder RBAC markers for all the rules.
+func (r *Rules) KubebuilderMarkers() []string {
+	var markers []string
+	for _, rule := range r.All() {
+		for _, group := range rule.APIGroups {
+			if group == "" {
+				group = "core"
+			}
+
+			markers = append(markers, fmt.Sprintf("//+kubebuilder:rbac:groups=%s,resources=%s,verbs=%s",
+				group, strings.Join(rule.Resources, ";"), strings.Join(rule.Verbs, ";")))
+		}
+	}
+
+	return markers
+}
+
+// ToYAML marshals an object (eg. a derived ClusterRole) to YAML.
+func ToYAML(obj runtime.Object) ([]byte, error) {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal object: %w", err)
+	}
+
+	return data, nil
+}
+
+var verbOrder = map[string]int{}
+
+func init() {
+	for i, verb := range append(append([]string{}, DefaultVerbs...), "deletecollection", "escalate", "bind") {
+		verbOrder[verb] = i
+	}
+}
+
+// sortedVerbs returns the verbs in their conventional order (followed by
+// any unknown verbs, sorted alphabetically).
+func sortedVerbs(verbs map[string]bool) []string {
+	sorted := make([]string, 0, len(verbs))
+	for verb := range verbs {
+		sorted = append(sorted, verb)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		oi, iok := verbOrder[sorted[i]]
+		oj, jok := verbOrder[sorted[j]]
+		switch {
+		case iok && jok:
+			return oi < oj
+		case iok != jok:
+			return iok
+		default:
+			return sorted[i] < sorted[j]
+		}
+	})
+
+	return sorted
+}
+
+func sortRules(rules []rbacv1.PolicyRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].APIGroups[0] != rules[j].APIGroups[0] {
+			return rules[i].APIGroups[0] < rules[j].APIGroups[0]
+		}
+		return rules[i].Resources[0] < rules[j].Resources[0]
+	})
+}
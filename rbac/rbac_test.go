@@ -0,0 +1,95 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rbac_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestDerive(t *testing.T) {
+	widgetGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Service"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(rbacv1.SchemeGroupVersion.WithKind("ClusterRole"), meta.RESTScopeRoot)
+	mapper.Add(widgetGVK, meta.RESTScopeNamespace)
+
+	widget := &unstructured.Unstructured{}
+	widget.SetGroupVersionKind(widgetGVK)
+
+	objs := []client.Object{
+		&appsv1.Deployment{},
+		&corev1.Service{},
+		&corev1.ConfigMap{},
+		&rbacv1.ClusterRole{},
+		widget,
+	}
+
+	rules, err := rbac.Derive(mapper, scheme.Scheme, objs, rbac.Options{Subresources: []string{"status"}})
+	require.NoError(t, err)
+
+	manage := rbac.DefaultVerbs
+	updateStatus := []string{"get", "update", "patch"}
+
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps", "services"}, Verbs: manage},
+		{APIGroups: []string{""}, Resources: []string{"configmaps/status", "services/status"}, Verbs: updateStatus},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: manage},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments/status"}, Verbs: updateStatus},
+		{APIGroups: []string{"example.com"}, Resources: []string{"widgets"}, Verbs: manage},
+		{APIGroups: []string{"example.com"}, Resources: []string{"widgets/status"}, Verbs: updateStatus},
+	}, rules.Namespaced)
+
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"clusterroles"}, Verbs: append(append([]string{}, manage...), "escalate")},
+		{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"clusterroles/status"}, Verbs: updateStatus},
+	}, rules.ClusterScoped)
+
+	role := rules.Role("default", "operator")
+	assert.Len(t, role.Rules, 6)
+
+	clusterRole := rules.ClusterRole("operator")
+	assert.Len(t, clusterRole.Rules, 8)
+
+	markers := rules.KubebuilderMarkers()
+	assert.Contains(t, markers, "//+kubebuilder:rbac:groups=core,resources=configmaps;services,verbs=get;list;watch;create;update;patch;delete")
+	assert.Contains(t, markers, "//+kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get;update;patch")
+
+	data, err := rbac.ToYAML(clusterRole)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "kind: ClusterRole")
+
+	t.Run("Unknown Kind", func(t *testing.T) {
+		_, err := rbac.Derive(mapper, scheme.Scheme, []client.Object{&corev1.Secret{}}, rbac.Options{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,218 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package reachability checks the network readiness of Services and
+// Ingresses (eg. that a Service has ready endpoints, or a LoadBalancer has
+// been assigned an address). Checks return retryable errors while the
+// object is not yet reachable, so they can be returned directly from a
+// reconciler, or polled with Wait outside of a reconcile loop.
+package reachability
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/waitutil"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrNoEndpoints is returned when a Service has no ready endpoints.
+	ErrNoEndpoints = errors.New("service has no ready endpoints")
+	// ErrNoAddress is returned when a LoadBalancer Service or Ingress has no address.
+	ErrNoAddress = errors.New("no address assigned")
+	// ErrNotLoadBalancer is returned when the Service is not a LoadBalancer.
+	ErrNotLoadBalancer = errors.New("service is not a load balancer")
+	// ErrUnexpectedStatus is returned when a probe receives an unexpected status code.
+	ErrUnexpectedStatus = errors.New("unexpected status code")
+	// ErrTimeout is returned when a check does not pass within the timeout.
+	ErrTimeout = waitutil.ErrTimeout
+)
+
+// CheckFunc checks reachability, returning a retryable error if the check
+// should be retried.
+type CheckFunc func(ctx context.Context) error
+
+// ServiceHasEndpoints checks that the Service has at least one ready
+// endpoint (ExternalName Services are always considered reachable).
+func ServiceHasEndpoints(ctx context.Context, c client.Client, svc *corev1.Service) error {
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		return nil
+	}
+
+	var slices discoveryv1.EndpointSliceList
+	if err := c.List(ctx, &slices, client.InNamespace(svc.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: svc.Name}); err != nil {
+		return fmt.Errorf("failed to list endpoint slices: %w", err)
+	}
+
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				return nil
+			}
+		}
+	}
+
+	return retryable.New(fmt.Errorf("%w: %s", ErrNoEndpoints, svc.Name))
+}
+
+// LoadBalancerAddress returns the external IP (or hostname) of a
+// LoadBalancer Service, or a retryable error if it has not been assigned.
+func LoadBalancerAddress(ctx context.Context, c client.Client, svc *corev1.Service) (string, error) {
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return "", fmt.Errorf("%w: %s", ErrNotLoadBalancer, svc.Name)
+	}
+
+	var current corev1.Service
+	if err := c.Get(ctx, client.ObjectKeyFromObject(svc), &current); err != nil {
+		return "", fmt.Errorf("failed to get service: %w", err)
+	}
+
+	if address := loadBalancerAddress(current.Status.LoadBalancer.Ingress); address != "" {
+		return address, nil
+	}
+
+	return "", retryable.New(fmt.Errorf("%w: service %s", ErrNoAddress, svc.Name))
+}
+
+// IngressAddress returns the address (IP or hostname) assigned to an
+// Ingress, or a retryable error if it has not been assigned.
+func IngressAddress(ctx context.Context, c client.Client, ing *networkingv1.Ingress) (string, error) {
+	var current networkingv1.Ingress
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ing), &current); err != nil {
+		return "", fmt.Errorf("failed to get ingress: %w", err)
+	}
+
+	for _, ingress := range current.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP, nil
+		}
+
+		if ingress.Hostname != "" {
+			return ingress.Hostname, nil
+		}
+	}
+
+	return "", retryable.New(fmt.Errorf("%w: ingress %s", ErrNoAddress, ing.Name))
+}
+
+func loadBalancerAddress(ingresses []corev1.LoadBalancerIngress) string {
+	for _, ingress := range ingresses {
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+	}
+
+	return ""
+}
+
+// ProbeOptions configures an HTTP(S) probe.
+type ProbeOptions struct {
+	// Client is the HTTP client used to probe. Defaults to a client with
+	// a 5 second timeout.
+	Client *http.Client
+	// InsecureSkipVerify disables TLS verification of the default client
+	// (eg. for self-signed certificates).
+	InsecureSkipVerify bool
+	// Host, if set, overrides the Host header (eg. when probing an
+	// Ingress by its address).
+	Host string
+	// ExpectedStatus returns true if the status code indicates the endpoint
+	// is reachable. Defaults to any 2xx or 3xx status.
+	ExpectedStatus func(code int) bool
+}
+
+// Probe sends a GET request to the URL, returning a retryable error if the
+// request fails, or receives an unexpected status code.
+func Probe(ctx context.Context, url string, opts ProbeOptions) error {
+	httpClient := opts.Client
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify},
+			},
+		}
+	}
+
+	expectedStatus := opts.ExpectedStatus
+	if expectedStatus == nil {
+		expectedStatus = func(code int) bool { return code >= 200 && code < 400 }
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if opts.Host != "" {
+		req.Host = opts.Host
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return retryable.New(fmt.Errorf("failed to probe %s: %w", url, err))
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if !expectedStatus(resp.StatusCode) {
+		return retryable.New(fmt.Errorf("%w: %s returned %d", ErrUnexpectedStatus, url, resp.StatusCode))
+	}
+
+	return nil
+}
+
+// All returns a check that runs each check in turn (eg. an address check
+// followed by a probe), stopping at the first failure.
+func All(checks ...CheckFunc) CheckFunc {
+	return func(ctx context.Context) error {
+		for _, check := range checks {
+			if err := check(ctx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Wait polls the check until it passes, fails with a non-retryable error,
+// or the timeout is reached (in which case an error wrapping ErrTimeout and
+// the last retryable error is returned).
+func Wait(ctx context.Context, check CheckFunc, interval, timeout time.Duration) error {
+	return waitutil.Poll(ctx, waitutil.CheckFunc(check), waitutil.Options{
+		Interval: interval,
+		Timeout:  timeout,
+	})
+}
@@ -0,0 +1,141 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reachability_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/reachability"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReachability(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+	}
+
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "api-abc",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "api"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{{
+			Addresses:  []string{"10.0.0.1"},
+			Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false)},
+		}},
+	}
+
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api"},
+		Status: networkingv1.IngressStatus{
+			LoadBalancer: networkingv1.IngressLoadBalancerStatus{
+				Ingress: []networkingv1.IngressLoadBalancerIngress{{Hostname: "api.example.com"}},
+			},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(svc, slice, ing).Build()
+
+	t.Run("Endpoints", func(t *testing.T) {
+		err := reachability.ServiceHasEndpoints(ctx, c, svc)
+		assert.ErrorIs(t, err, reachability.ErrNoEndpoints)
+		assert.True(t, retryable.Is(err))
+
+		slice.Endpoints[0].Conditions.Ready = ptr.To(true)
+		require.NoError(t, c.Update(ctx, slice))
+
+		assert.NoError(t, reachability.ServiceHasEndpoints(ctx, c, svc))
+	})
+
+	t.Run("LoadBalancer", func(t *testing.T) {
+		_, err := reachability.LoadBalancerAddress(ctx, c, svc)
+		assert.ErrorIs(t, err, reachability.ErrNoAddress)
+		assert.True(t, retryable.Is(err))
+
+		svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "192.0.2.1"}}
+		require.NoError(t, c.Status().Update(ctx, svc))
+
+		address, err := reachability.LoadBalancerAddress(ctx, c, svc)
+		require.NoError(t, err)
+		assert.Equal(t, "192.0.2.1", address)
+
+		_, err = reachability.LoadBalancerAddress(ctx, c, &corev1.Service{})
+		assert.ErrorIs(t, err, reachability.ErrNotLoadBalancer)
+		assert.False(t, retryable.Is(err))
+	})
+
+	t.Run("Ingress", func(t *testing.T) {
+		address, err := reachability.IngressAddress(ctx, c, ing)
+		require.NoError(t, err)
+		assert.Equal(t, "api.example.com", address)
+	})
+
+	t.Run("Probe", func(t *testing.T) {
+		healthy := false
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "api.example.com", r.Host)
+
+			if !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		opts := reachability.ProbeOptions{Host: "api.example.com"}
+
+		err := reachability.Probe(ctx, srv.URL, opts)
+		assert.ErrorIs(t, err, reachability.ErrUnexpectedStatus)
+		assert.True(t, retryable.Is(err))
+
+		err = reachability.Wait(ctx, func(ctx context.Context) error {
+			return reachability.Probe(ctx, srv.URL, opts)
+		}, 10*time.Millisecond, 50*time.Millisecond)
+		assert.ErrorIs(t, err, reachability.ErrTimeout)
+		assert.ErrorIs(t, err, reachability.ErrUnexpectedStatus)
+
+		healthy = true
+
+		err = reachability.Wait(ctx, reachability.All(
+			func(ctx context.Context) error { return reachability.ServiceHasEndpoints(ctx, c, svc) },
+			func(ctx context.Context) error { return reachability.Probe(ctx, srv.URL, opts) },
+		), 10*time.Millisecond, time.Second)
+		assert.NoError(t, err)
+	})
+}
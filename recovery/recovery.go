@@ -0,0 +1,107 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package recovery provides a reconciler wrapper that recovers panics, so a
+// single malformed object can't crash the operator (and stop reconciliation
+// of every other object).
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// PanicError is the error a recovered panic is converted into.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("reconcile panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+// Options configures panic recovery.
+type Options struct {
+	// Recorder, if set, is used to record a Warning event on the reconciled object.
+	Recorder *events.Recorder
+	// Client is used to get the reconciled object (to record events against).
+	Client client.Reader
+	// NewObject returns a new, empty, object of the reconciled kind.
+	NewObject func() client.Object
+	// RetryAfter is how long to wait before retrying a reconcile that
+	// panicked (defaults to retryable.DefaultRetryAfter).
+	RetryAfter time.Duration
+}
+
+// NewReconciler wraps a reconciler so that panics are recovered. A recovered
+// panic is logged (with its stack), counted in the reconcile panics metric,
+// recorded as a Warning event on the object, and returned as a retryable
+// *PanicError.
+func NewReconciler(name string, r reconcile.Reconciler, opts Options) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+		defer func() {
+			if value := recover(); value != nil {
+				panicErr := &PanicError{Value: value, Stack: debug.Stack()}
+
+				log.FromContext(ctx).Error(panicErr, "Recovered from reconcile panic",
+					"controller", name, "request", req.NamespacedName, "stack", string(panicErr.Stack))
+
+				metrics.RecordPanic(name)
+				recordEvent(ctx, req, panicErr, &opts)
+
+				res, err = reconcile.Result{}, retryable.After(panicErr, opts.RetryAfter)
+			}
+		}()
+
+		return r.Reconcile(ctx, req)
+	})
+}
+
+func recordEvent(ctx context.Context, req reconcile.Request, panicErr *PanicError, opts *Options) {
+	if opts.Recorder == nil || opts.Client == nil || opts.NewObject == nil {
+		return
+	}
+
+	obj := opts.NewObject()
+	if err := opts.Client.Get(ctx, req.NamespacedName, obj); err != nil {
+		log.FromContext(ctx).V(1).Info("Failed to get object to record panic event", "error", err.Error())
+		return
+	}
+
+	opts.Recorder.Warningf(obj, events.ReasonReconcilePanicked, "Reconcile panicked: %v", panicErr.Value)
+}
@@ -0,0 +1,88 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package recovery_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"github.com/gpu-ninja/operator-utils/recovery"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestNewReconciler(t *testing.T) {
+	ctx := context.Background()
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	c := fake.NewClientBuilder().WithObjects(cm).Build()
+
+	fakeRecorder := record.NewFakeRecorder(10)
+
+	r := recovery.NewReconciler("test-recovery", reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		if req.Name == "test" {
+			var m map[string]string
+			m["boom"] = "true"
+		}
+
+		return reconcile.Result{RequeueAfter: time.Minute}, nil
+	}), recovery.Options{
+		Recorder:   events.NewRecorder(fakeRecorder),
+		Client:     c,
+		NewObject:  func() client.Object { return &corev1.ConfigMap{} },
+		RetryAfter: 10 * time.Second,
+	})
+
+	res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "test"}})
+	require.Error(t, err)
+	assert.Zero(t, res)
+
+	var panicErr *recovery.PanicError
+	require.ErrorAs(t, err, &panicErr)
+	assert.Contains(t, string(panicErr.Stack), "recovery_test")
+
+	var runtimeErr interface{ RuntimeError() }
+	assert.True(t, errors.As(err, &runtimeErr))
+
+	retryAfter, ok := retryable.RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Second, retryAfter)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.PanicsTotal.WithLabelValues("test-recovery")))
+
+	require.Len(t, fakeRecorder.Events, 1)
+	assert.Contains(t, <-fakeRecorder.Events, "Warning ReconcilePanicked Reconcile panicked: assignment to entry in nil map")
+
+	// Reconciles that don't panic are unaffected.
+	res, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "other"}})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, res.RequeueAfter)
+}
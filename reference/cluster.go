@@ -0,0 +1,188 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gpu-ninja/operator-utils/clusters"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrClusterNotFound is returned when a referenced cluster is not known.
+var ErrClusterNotFound = clusters.ErrClusterNotFound
+
+// ClusterReaders maps cluster names to readers for those clusters.
+type ClusterReaders map[string]client.Reader
+
+// ClusterGetter returns clusters by name (eg. a clusters.Registry).
+type ClusterGetter interface {
+	GetCluster(name string) (clusters.Cluster, error)
+}
+
+// clusterReaderFunc returns the reader for the named cluster.
+type clusterReaderFunc func(name string) (client.Reader, error)
+
+type clusterReadersKey struct{}
+
+// WithClusterReaders returns a context using the given readers to resolve
+// references to other clusters.
+func WithClusterReaders(ctx context.Context, readers ClusterReaders) context.Context {
+	return context.WithValue(ctx, clusterReadersKey{}, clusterReaderFunc(readers.reader))
+}
+
+// WithClusters returns a context resolving references to other clusters
+// using the clients of the given clusters (eg. from a clusters.Registry).
+func WithClusters(ctx context.Context, getter ClusterGetter) context.Context {
+	return context.WithValue(ctx, clusterReadersKey{}, clusterReaderFunc(func(name string) (client.Reader, error) {
+		cl, err := getter.GetCluster(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return cl.GetClient(), nil
+	}))
+}
+
+func (readers ClusterReaders) reader(name string) (client.Reader, error) {
+	reader, ok := readers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClusterNotFound, name)
+	}
+
+	return reader, nil
+}
+
+// ClusterObjectReference is a reference to a resource in a (possibly remote)
+// cluster.
+// +kubebuilder:object:generate=true
+type ClusterObjectReference struct {
+	// Cluster is the name of the cluster containing the resource (defaults
+	// to the local cluster).
+	Cluster string `json:"cluster,omitempty"`
+	// Name is the name of the resource.
+	Name string `json:"name,omitempty"`
+	// Namespace is the namespace of the resource.
+	Namespace string `json:"namespace,omitempty"`
+	// APIVersion is the API version of the resource.
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind is the kind of the resource.
+	Kind string `json:"kind,omitempty"`
+	// MinGeneration, if set, is the minimum generation the resource must have
+	// reached (and observed, if it reports a status.observedGeneration).
+	MinGeneration int64 `json:"minGeneration,omitempty"`
+	// ResourceVersion, if set, is the minimum resource version the resource
+	// must have reached.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Optional indicates that the referenced resource may not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Resolve resolves the reference to its underlying resource. Resources in
+// other clusters are resolved using the readers from the context (see
+// WithClusterReaders and WithClusters), a retryable error (eg. wrapping
+// ErrClusterNotFound) is returned if there is no reader for the cluster (eg.
+// as it hasn't been registered yet, or is unhealthy).
+func (ref *ClusterObjectReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	if ref.Cluster != "" {
+		clusterReader, _ := ctx.Value(clusterReadersKey{}).(clusterReaderFunc)
+		if clusterReader == nil {
+			return nil, false, retryable.New(fmt.Errorf("%w: %s", ErrClusterNotFound, ref.Cluster))
+		}
+
+		var err error
+		if reader, err = clusterReader(ref.Cluster); err != nil {
+			return nil, false, retryable.New(fmt.Errorf("failed to get cluster: %w", err))
+		}
+	}
+
+	return ref.objectReference().Resolve(ctx, reader, scheme, parent)
+}
+
+// Target returns the kind and key of the referenced resource, within its
+// cluster (see ObjectReference.Target). References to other clusters are not
+// indexed (see IndexFunc), as changes to them can't be watched locally.
+func (ref *ClusterObjectReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	return ref.objectReference().Target(scheme, parent)
+}
+
+// IsOptional returns true if the referenced resource may not exist.
+func (ref *ClusterObjectReference) IsOptional() bool {
+	return ref.Optional
+}
+
+func (ref *ClusterObjectReference) objectReference() *ObjectReference {
+	return &ObjectReference{
+		Name:            ref.Name,
+		Namespace:       ref.Namespace,
+		APIVersion:      ref.APIVersion,
+		Kind:            ref.Kind,
+		MinGeneration:   ref.MinGeneration,
+		ResourceVersion: ref.ResourceVersion,
+		Optional:        ref.Optional,
+	}
+}
+
+// ClusterResolver resolves references across clusters.
+type ClusterResolver struct {
+	local       client.Reader
+	withReaders func(ctx context.Context) context.Context
+	scheme      *runtime.Scheme
+}
+
+// NewClusterResolver returns a resolver using the local reader for
+// references without a cluster, and the given readers for references to
+// other clusters.
+func NewClusterResolver(local client.Reader, readers ClusterReaders, scheme *runtime.Scheme) *ClusterResolver {
+	return &ClusterResolver{
+		local: local,
+		withReaders: func(ctx context.Context) context.Context {
+			return WithClusterReaders(ctx, readers)
+		},
+		scheme: scheme,
+	}
+}
+
+// NewClusterResolverForClusters returns a resolver using the local reader
+// for references without a cluster, and the clients of the given clusters
+// (eg. a clusters.Registry) for references to other clusters.
+func NewClusterResolverForClusters(local client.Reader, getter ClusterGetter, scheme *runtime.Scheme) *ClusterResolver {
+	return &ClusterResolver{
+		local: local,
+		withReaders: func(ctx context.Context) context.Context {
+			return WithClusters(ctx, getter)
+		},
+		scheme: scheme,
+	}
+}
+
+// Resolve resolves the reference to its underlying resource. Any reference
+// can be resolved (references other than ClusterObjectReference are resolved
+// in the local cluster).
+func (r *ClusterResolver) Resolve(ctx context.Context, ref Reference, parent runtime.Object) (runtime.Object, bool, error) {
+	return ref.Resolve(r.withReaders(ctx), r.local, r.scheme, parent)
+}
+
+// ResolveAll resolves every reference embedded in the object (see ResolveAll).
+func (r *ClusterResolver) ResolveAll(ctx context.Context, obj runtime.Object) (bool, error) {
+	return ResolveAllWith(ctx, r, obj)
+}
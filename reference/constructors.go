@@ -0,0 +1,60 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"fmt"
+
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// NewObjectReference returns a reference to the object (eg. for populating
+// status fields that point at managed children), with its API version and
+// kind looked up in the scheme.
+func NewObjectReference(obj client.Object, scheme *runtime.Scheme) (*ObjectReference, error) {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object kind: %w", err)
+	}
+
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+
+	return &ObjectReference{
+		Name:       obj.GetName(),
+		Namespace:  obj.GetNamespace(),
+		APIVersion: apiVersion,
+		Kind:       kind,
+	}, nil
+}
+
+// NewLocalObjectReference returns a reference to the object, from another
+// object in the same namespace.
+func NewLocalObjectReference(obj client.Object, scheme *runtime.Scheme) (*LocalObjectReference, error) {
+	ref, err := NewObjectReference(obj, scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalObjectReference{
+		Name:       ref.Name,
+		APIVersion: ref.APIVersion,
+		Kind:       ref.Kind,
+	}, nil
+}
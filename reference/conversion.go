@@ -0,0 +1,134 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+)
+
+// ErrIncompleteReference is returned when a reference is missing fields
+// required for a conversion.
+var ErrIncompleteReference = errors.New("incomplete reference")
+
+// FromCoreObjectReference converts a core ObjectReference (eg. from an
+// Event, or the status of an upstream API).
+func FromCoreObjectReference(ref *corev1.ObjectReference) *ObjectReference {
+	return &ObjectReference{
+		Name:            ref.Name,
+		Namespace:       ref.Namespace,
+		APIVersion:      ref.APIVersion,
+		Kind:            ref.Kind,
+		UID:             ref.UID,
+		ResourceVersion: ref.ResourceVersion,
+	}
+}
+
+// ToCoreObjectReference converts the reference to a core ObjectReference.
+func (ref *ObjectReference) ToCoreObjectReference() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Name:            ref.Name,
+		Namespace:       ref.Namespace,
+		APIVersion:      ref.APIVersion,
+		Kind:            ref.Kind,
+		UID:             ref.UID,
+		ResourceVersion: ref.ResourceVersion,
+	}
+}
+
+// FromOwnerReference converts an owner reference of an object in the given
+// namespace (owners are always in the same namespace, or cluster scoped).
+func FromOwnerReference(owner *metav1.OwnerReference, namespace string) *ObjectReference {
+	return &ObjectReference{
+		Name:       owner.Name,
+		Namespace:  namespace,
+		APIVersion: owner.APIVersion,
+		Kind:       owner.Kind,
+		UID:        owner.UID,
+	}
+}
+
+// ToOwnerReference converts the reference to an owner reference (the
+// namespace is dropped). The reference must have an API version, kind, and
+// UID.
+func (ref *ObjectReference) ToOwnerReference() (*metav1.OwnerReference, error) {
+	if ref.APIVersion == "" || ref.Kind == "" || ref.UID == "" {
+		return nil, fmt.Errorf("%w: owner references require an api version, kind, and uid", ErrIncompleteReference)
+	}
+
+	return &metav1.OwnerReference{
+		APIVersion: ref.APIVersion,
+		Kind:       ref.Kind,
+		Name:       ref.Name,
+		UID:        ref.UID,
+	}, nil
+}
+
+// FromTypedLocalObjectReference converts a typed local object reference of
+// an object in the given namespace. As typed local object references only
+// specify an API group, the version is the scheme's preferred version of
+// the group (or v1 for the core group).
+func FromTypedLocalObjectReference(ref *corev1.TypedLocalObjectReference, namespace string, scheme *runtime.Scheme) (*ObjectReference, error) {
+	gv := corev1.SchemeGroupVersion
+	if group := ptr.Deref(ref.APIGroup, ""); group != "" {
+		versions := scheme.PrioritizedVersionsForGroup(group)
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("no versions registered for group %q", group)
+		}
+
+		gv = versions[0]
+	}
+
+	return &ObjectReference{
+		Name:       ref.Name,
+		Namespace:  namespace,
+		APIVersion: gv.String(),
+		Kind:       ref.Kind,
+	}, nil
+}
+
+// ToTypedLocalObjectReference converts the reference to a typed local object
+// reference (the namespace and version are dropped). The reference must
+// have an API version.
+func (ref *ObjectReference) ToTypedLocalObjectReference() (*corev1.TypedLocalObjectReference, error) {
+	if ref.APIVersion == "" {
+		return nil, fmt.Errorf("%w: typed local object references require an api version", ErrIncompleteReference)
+	}
+
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse api version: %w", err)
+	}
+
+	typedRef := &corev1.TypedLocalObjectReference{
+		Kind: ref.Kind,
+		Name: ref.Name,
+	}
+
+	if gv.Group != "" {
+		typedRef.APIGroup = ptr.To(gv.Group)
+	}
+
+	return typedRef, nil
+}
@@ -0,0 +1,175 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type defaultGVKKey struct{}
+
+type fieldPathKey struct{}
+
+// DefaultGVK is the default kind of object references that omit their kind
+// (or API version), rather than inheriting the API version of the parent
+// (which is wrong for references to core types).
+type DefaultGVK struct {
+	// GVK is the default kind.
+	GVK schema.GroupVersionKind
+	// FieldPaths, if set, are the paths (eg. spec.secretRef) of the
+	// references the default applies to, otherwise it applies to every
+	// reference. Indices and map keys are ignored, so spec.secrets matches
+	// spec.secrets[0].
+	FieldPaths []string
+}
+
+// WithDefaultGVK returns a context in which references that omit their kind
+// are defaulted from the given kind (only for references at the given field
+// paths, if any), taking precedence over defaults already in the context.
+func WithDefaultGVK(ctx context.Context, gvk schema.GroupVersionKind, fieldPaths ...string) context.Context {
+	return WithDefaultGVKs(ctx, DefaultGVK{
+		GVK:        gvk,
+		FieldPaths: fieldPaths,
+	})
+}
+
+// WithDefaultGVKs returns a context in which references that omit their
+// kind are defaulted from the given defaults (later defaults taking
+// precedence). Field specific defaults are only applied when the path of a
+// reference is known (eg. when walking an object). Defaults that affect
+// which objects are referenced should also be passed to IndexFunc (eg. by
+// sharing them with a DefaultingResolver), so that every path sees the
+// same defaults.
+func WithDefaultGVKs(ctx context.Context, defaults ...DefaultGVK) context.Context {
+	if len(defaults) == 0 {
+		return ctx
+	}
+
+	existing, _ := ctx.Value(defaultGVKKey{}).([]DefaultGVK)
+	return context.WithValue(ctx, defaultGVKKey{}, append(append([]DefaultGVK(nil), existing...), defaults...))
+}
+
+// DefaultingResolver resolves references, defaulting the kinds of
+// references that omit them (see WithDefaultGVKs).
+type DefaultingResolver struct {
+	next     ReferenceResolver
+	defaults []DefaultGVK
+}
+
+// NewDefaultingResolver returns a new reference resolver applying the
+// defaults, before resolving references with the next resolver.
+func NewDefaultingResolver(next ReferenceResolver, defaults ...DefaultGVK) *DefaultingResolver {
+	return &DefaultingResolver{
+		next:     next,
+		defaults: append([]DefaultGVK(nil), defaults...),
+	}
+}
+
+// Resolve resolves the reference to its underlying resource.
+func (r *DefaultingResolver) Resolve(ctx context.Context, ref Reference, parent runtime.Object) (runtime.Object, bool, error) {
+	return r.next.Resolve(WithDefaultGVKs(ctx, r.defaults...), ref, parent)
+}
+
+// ResolveAll resolves every reference embedded in the object (see ResolveAll).
+func (r *DefaultingResolver) ResolveAll(ctx context.Context, obj runtime.Object) (bool, error) {
+	return ResolveAllWith(ctx, r, obj)
+}
+
+// withFieldPath returns a context recording the path of the reference being
+// resolved (so that field specific defaults can be applied).
+func withFieldPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, fieldPathKey{}, path)
+}
+
+var indexPattern = regexp.MustCompile(`\[[^\]]*\]`)
+
+// defaultGVKFromContext returns the default kind for the reference being
+// resolved, and false if there isn't one. Field specific defaults take
+// precedence, and then later defaults.
+func defaultGVKFromContext(ctx context.Context) (schema.GroupVersionKind, bool) {
+	defaults, _ := ctx.Value(defaultGVKKey{}).([]DefaultGVK)
+	if len(defaults) == 0 {
+		return schema.GroupVersionKind{}, false
+	}
+
+	path, _ := ctx.Value(fieldPathKey{}).(string)
+	path = indexPattern.ReplaceAllString(path, "")
+
+	if path != "" {
+		for i := len(defaults) - 1; i >= 0; i-- {
+			for _, fieldPath := range defaults[i].FieldPaths {
+				if fieldPath == path {
+					return defaults[i].GVK, true
+				}
+			}
+		}
+	}
+
+	for i := len(defaults) - 1; i >= 0; i-- {
+		if len(defaults[i].FieldPaths) == 0 {
+			return defaults[i].GVK, true
+		}
+	}
+
+	return schema.GroupVersionKind{}, false
+}
+
+// withDefaults returns the reference with its kind and API version
+// defaulted from the context (if they are omitted). The API version is only
+// defaulted if the kind is omitted, or matches the default.
+func (ref *ObjectReference) withDefaults(ctx context.Context) *ObjectReference {
+	if ref.Kind != "" && ref.APIVersion != "" {
+		return ref
+	}
+
+	gvk, ok := defaultGVKFromContext(ctx)
+	if !ok {
+		return ref
+	}
+
+	defaulted := *ref
+	if defaulted.Kind == "" {
+		defaulted.Kind = gvk.Kind
+	}
+
+	if defaulted.APIVersion == "" && defaulted.Kind == gvk.Kind {
+		defaulted.APIVersion = gvk.GroupVersion().String()
+	}
+
+	return &defaulted
+}
+
+// targetWithDefaults returns the target of the reference, with its kind and
+// API version defaulted from the context.
+func targetWithDefaults(ctx context.Context, ref TargetedReference, scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	switch r := ref.(type) {
+	case *ObjectReference:
+		return r.withDefaults(ctx).Target(scheme, parent)
+	case *LocalObjectReference:
+		return r.objectReference().withDefaults(ctx).Target(scheme, parent)
+	case *ClusterObjectReference:
+		return r.objectReference().withDefaults(ctx).Target(scheme, parent)
+	default:
+		return ref.Target(scheme, parent)
+	}
+}
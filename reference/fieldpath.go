@@ -0,0 +1,110 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gpu-ninja/operator-utils/retryable"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrNoFieldPath is returned when resolving the field of a reference without a field path.
+	ErrNoFieldPath = errors.New("reference has no field path")
+	// ErrFieldNotPopulated is returned when a referenced field isn't (yet) populated.
+	ErrFieldNotPopulated = errors.New("field not populated")
+)
+
+// ResolveField resolves the reference to the value of its field path.
+// Scalars are formatted as strings, and other values are JSON encoded. A
+// retryable error (wrapping ErrFieldNotPopulated) is returned if the object
+// doesn't exist yet, or the field isn't yet populated (eg. a load balancer
+// address that hasn't been assigned). If the reference is optional, an
+// empty value is returned if the object doesn't exist.
+func (ref *ObjectReference) ResolveField(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (string, error) {
+	if ref.FieldPath == "" {
+		return "", ErrNoFieldPath
+	}
+
+	path := jsonpath.New("fieldPath")
+	if err := path.Parse(fieldPathTemplate(ref.FieldPath)); err != nil {
+		return "", fmt.Errorf("failed to parse field path %q: %w", ref.FieldPath, err)
+	}
+
+	obj, ok, err := ref.Resolve(ctx, reader, scheme, parent)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		if ref.Optional {
+			return "", nil
+		}
+
+		return "", retryable.New(fmt.Errorf("%w: %s not found", ErrFieldNotPopulated, ref.Name))
+	}
+
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert object to unstructured: %w", err)
+	}
+
+	results, err := path.FindResults(u)
+	if err != nil || len(results) == 0 || len(results[0]) == 0 {
+		return "", retryable.New(fmt.Errorf("%w: %s in %s", ErrFieldNotPopulated, ref.FieldPath, ref.Name))
+	}
+
+	value := results[0][0]
+	if value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return "", retryable.New(fmt.Errorf("%w: %s in %s", ErrFieldNotPopulated, ref.FieldPath, ref.Name))
+		}
+
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Map, reflect.Slice:
+		data, err := json.Marshal(value.Interface())
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal field: %w", err)
+		}
+
+		return string(data), nil
+	default:
+		return fmt.Sprint(value.Interface()), nil
+	}
+}
+
+// fieldPathTemplate returns the JSONPath template for the field path, which
+// may omit the enclosing braces.
+func fieldPathTemplate(fieldPath string) string {
+	if strings.HasPrefix(fieldPath, "{") {
+		return fieldPath
+	}
+
+	return "{" + fieldPath + "}"
+}
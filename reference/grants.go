@@ -0,0 +1,262 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrCrossNamespaceReferenceDenied is returned when a reference to another
+// namespace isn't permitted by the configured NamespacePolicy.
+var ErrCrossNamespaceReferenceDenied = errors.New("cross namespace reference not permitted")
+
+// ReferenceGrantGroupVersionKind is the kind of Gateway API reference grants.
+var ReferenceGrantGroupVersionKind = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1beta1",
+	Kind:    "ReferenceGrant",
+}
+
+// CrossNamespaceReference describes a reference from an object in one
+// namespace to an object in another.
+type CrossNamespaceReference struct {
+	// From is the kind of the referencing object.
+	From schema.GroupKind
+	// FromNamespace is the namespace of the referencing object.
+	FromNamespace string
+	// To is the kind of the referenced object.
+	To schema.GroupKind
+	// ToNamespace is the namespace of the referenced object.
+	ToNamespace string
+	// ToName is the name of the referenced object.
+	ToName string
+}
+
+// NamespacePolicy decides whether cross namespace references are permitted.
+// References within a namespace (or from cluster scoped objects) are always
+// permitted.
+type NamespacePolicy interface {
+	// Allowed returns true if the reference is permitted.
+	Allowed(ctx context.Context, reader client.Reader, ref CrossNamespaceReference) (bool, error)
+}
+
+var (
+	namespacePolicyMu sync.RWMutex
+	namespacePolicy   NamespacePolicy
+)
+
+// SetNamespacePolicy sets the operator wide namespace policy (nil permits
+// all cross namespace references).
+func SetNamespacePolicy(p NamespacePolicy) {
+	namespacePolicyMu.Lock()
+	defer namespacePolicyMu.Unlock()
+
+	namespacePolicy = p
+}
+
+type namespacePolicyKey struct{}
+
+// WithNamespacePolicy returns a context using the given namespace policy,
+// overriding the operator wide policy.
+func WithNamespacePolicy(ctx context.Context, p NamespacePolicy) context.Context {
+	return context.WithValue(ctx, namespacePolicyKey{}, p)
+}
+
+func namespacePolicyFromContext(ctx context.Context) NamespacePolicy {
+	if p, ok := ctx.Value(namespacePolicyKey{}).(NamespacePolicy); ok {
+		return p
+	}
+
+	namespacePolicyMu.RLock()
+	defer namespacePolicyMu.RUnlock()
+
+	return namespacePolicy
+}
+
+// checkNamespacePolicy returns an error if the reference crosses namespaces
+// and isn't permitted by the configured policy. References to cluster scoped
+// objects are always permitted.
+func checkNamespacePolicy(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object, to schema.GroupVersionKind, key client.ObjectKey) error {
+	p := namespacePolicyFromContext(ctx)
+	if p == nil {
+		return nil
+	}
+
+	parentMeta, err := meta.Accessor(parent)
+	if err != nil {
+		return fmt.Errorf("failed to get accessor: %w", err)
+	}
+
+	// Cluster scoped targets (eg. ClusterIssuers) aren't in any namespace.
+	if key.Namespace == "" || parentMeta.GetNamespace() == "" || parentMeta.GetNamespace() == key.Namespace {
+		return nil
+	}
+
+	gvks, _, err := scheme.ObjectKinds(parent)
+	if err != nil {
+		return fmt.Errorf("failed to get object kinds: %w", err)
+	}
+
+	if len(gvks) == 0 {
+		return fmt.Errorf("no object kinds found")
+	}
+
+	ref := CrossNamespaceReference{
+		From:          gvks[0].GroupKind(),
+		FromNamespace: parentMeta.GetNamespace(),
+		To:            to.GroupKind(),
+		ToNamespace:   key.Namespace,
+		ToName:        key.Name,
+	}
+
+	allowed, err := p.Allowed(ctx, reader, ref)
+	if err != nil {
+		return fmt.Errorf("failed to check namespace policy: %w", err)
+	}
+
+	if !allowed {
+		return fmt.Errorf("%w: %s %s/%s from namespace %s", ErrCrossNamespaceReferenceDenied,
+			ref.To.String(), ref.ToNamespace, ref.ToName, ref.FromNamespace)
+	}
+
+	return nil
+}
+
+// AllowRule permits references from one namespace to another. Empty fields
+// match anything.
+type AllowRule struct {
+	// FromNamespace is the namespace of the referencing object.
+	FromNamespace string
+	// ToNamespace is the namespace of the referenced object.
+	ToNamespace string
+	// To, if set, restricts the rule to references to objects of the kind.
+	To *schema.GroupKind
+}
+
+// AllowList is a static NamespacePolicy permitting cross namespace
+// references matching any of its rules.
+type AllowList []AllowRule
+
+// Allowed returns true if the reference matches any of the rules.
+func (l AllowList) Allowed(_ context.Context, _ client.Reader, ref CrossNamespaceReference) (bool, error) {
+	for _, rule := range l {
+		if (rule.FromNamespace == "" || rule.FromNamespace == ref.FromNamespace) &&
+			(rule.ToNamespace == "" || rule.ToNamespace == ref.ToNamespace) &&
+			(rule.To == nil || *rule.To == ref.To) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ReferenceGrants is a NamespacePolicy that permits cross namespace
+// references only if a Gateway API ReferenceGrant in the referenced
+// namespace allows it. If the ReferenceGrant kind isn't installed, no
+// references are permitted.
+type ReferenceGrants struct{}
+
+// Allowed returns true if a ReferenceGrant permits the reference.
+func (ReferenceGrants) Allowed(ctx context.Context, reader client.Reader, ref CrossNamespaceReference) (bool, error) {
+	var grants unstructured.UnstructuredList
+	grants.SetGroupVersionKind(ReferenceGrantGroupVersionKind.GroupVersion().WithKind(ReferenceGrantGroupVersionKind.Kind + "List"))
+
+	if err := reader.List(ctx, &grants, client.InNamespace(ref.ToNamespace)); err != nil {
+		if meta.IsNoMatchError(err) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("failed to list reference grants: %w", err)
+	}
+
+	for _, grant := range grants.Items {
+		if grantMatchesFrom(grant.Object, ref) && grantMatchesTo(grant.Object, ref) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func grantMatchesFrom(grant map[string]any, ref CrossNamespaceReference) bool {
+	from, _, _ := unstructured.NestedSlice(grant, "spec", "from")
+	for _, f := range from {
+		f, ok := f.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		group, _, _ := unstructured.NestedString(f, "group")
+		kind, _, _ := unstructured.NestedString(f, "kind")
+		namespace, _, _ := unstructured.NestedString(f, "namespace")
+
+		if group == ref.From.Group && kind == ref.From.Kind && namespace == ref.FromNamespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+func grantMatchesTo(grant map[string]any, ref CrossNamespaceReference) bool {
+	to, _, _ := unstructured.NestedSlice(grant, "spec", "to")
+	for _, t := range to {
+		t, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		group, _, _ := unstructured.NestedString(t, "group")
+		kind, _, _ := unstructured.NestedString(t, "kind")
+		name, _, _ := unstructured.NestedString(t, "name")
+
+		if group == ref.To.Group && kind == ref.To.Kind && (name == "" || name == ref.ToName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AnyOf returns a NamespacePolicy permitting references permitted by any
+// of the policies.
+func AnyOf(policies ...NamespacePolicy) NamespacePolicy {
+	return anyOf(policies)
+}
+
+type anyOf []NamespacePolicy
+
+func (policies anyOf) Allowed(ctx context.Context, reader client.Reader, ref CrossNamespaceReference) (bool, error) {
+	for _, p := range policies {
+		allowed, err := p.Allowed(ctx, reader, ref)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,123 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ReferenceIndexField is the field used to index objects by the resources
+// they reference (see IndexReferences).
+const ReferenceIndexField = "gpu-ninja.com/references"
+
+// ReferenceIndexValue returns the index value for a referenced resource.
+func ReferenceIndexValue(gk schema.GroupKind, key client.ObjectKey) string {
+	return gk.String() + "/" + key.String()
+}
+
+// IndexFunc returns an indexer function extracting the resources referenced
+// by an object (found by walking its spec, see ResolveAll). Only references
+// implementing TargetedReference (and not to other clusters) are indexed,
+// with their kinds defaulted from the given defaults (which should match
+// those used when resolving, see WithDefaultGVKs).
+func IndexFunc(scheme *runtime.Scheme, defaults ...DefaultGVK) client.IndexerFunc {
+	ctx := WithDefaultGVKs(context.Background(), defaults...)
+
+	return func(obj client.Object) []string {
+		var values []string
+		_, _ = walkReferences(obj, func(ref Reference, path string) (bool, error) {
+			targeted, ok := ref.(TargetedReference)
+			if !ok {
+				return true, nil
+			}
+
+			// Changes in other clusters can't be watched locally.
+			if clusterRef, ok := ref.(*ClusterObjectReference); ok && clusterRef.Cluster != "" {
+				return true, nil
+			}
+
+			gvk, key, err := targetWithDefaults(withFieldPath(ctx, path), targeted, scheme, obj)
+			if err != nil {
+				return true, nil
+			}
+
+			values = append(values, ReferenceIndexValue(gvk.GroupKind(), key))
+
+			return true, nil
+		})
+
+		return values
+	}
+}
+
+// IndexReferences registers an index of objects of the given type by the
+// resources they reference, for use with EnqueueRequestsFromReference (eg.
+// IndexReferences(ctx, mgr.GetFieldIndexer(), mgr.GetScheme(), &MyObject{})).
+// Kinds are defaulted from the given defaults (see IndexFunc).
+func IndexReferences(ctx context.Context, indexer client.FieldIndexer, scheme *runtime.Scheme, obj client.Object, defaults ...DefaultGVK) error {
+	if err := indexer.IndexField(ctx, obj, ReferenceIndexField, IndexFunc(scheme, defaults...)); err != nil {
+		return fmt.Errorf("failed to index references: %w", err)
+	}
+
+	return nil
+}
+
+// EnqueueRequestsFromReference returns an event handler that maps changes to
+// a referenced resource (eg. a Secret) to requests for the objects (of the
+// given list type) referencing it. The objects must be indexed using
+// IndexReferences.
+func EnqueueRequestsFromReference(reader client.Reader, scheme *runtime.Scheme, list client.ObjectList) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+		logger := log.FromContext(ctx)
+
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			logger.Error(err, "Failed to get kind of referenced object")
+			return nil
+		}
+
+		referencing := list.DeepCopyObject().(client.ObjectList)
+		if err := reader.List(ctx, referencing, client.MatchingFields{
+			ReferenceIndexField: ReferenceIndexValue(gvk.GroupKind(), client.ObjectKeyFromObject(obj)),
+		}); err != nil {
+			logger.Error(err, "Failed to list referencing objects")
+			return nil
+		}
+
+		var requests []reconcile.Request
+		_ = meta.EachListItem(referencing, func(item runtime.Object) error {
+			if o, ok := item.(client.Object); ok {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(o)})
+			}
+
+			return nil
+		})
+
+		return requests
+	})
+}
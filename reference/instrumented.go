@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// InstrumentedResolver resolves references, recording metrics about each
+// resolution (see metrics.RecordReferenceResolution), so that chronically
+// unresolvable references can be spotted.
+type InstrumentedResolver struct {
+	next   ReferenceResolver
+	scheme *runtime.Scheme
+}
+
+// NewInstrumentedResolver returns a new reference resolver recording
+// metrics about the resolutions of the next resolver.
+func NewInstrumentedResolver(next ReferenceResolver, scheme *runtime.Scheme) *InstrumentedResolver {
+	return &InstrumentedResolver{
+		next:   next,
+		scheme: scheme,
+	}
+}
+
+// Resolve resolves the reference to its underlying resource.
+func (r *InstrumentedResolver) Resolve(ctx context.Context, ref Reference, parent runtime.Object) (runtime.Object, bool, error) {
+	start := time.Now()
+	obj, ok, err := r.next.Resolve(ctx, ref, parent)
+
+	_, decodeFallback := obj.(*unstructured.Unstructured)
+	metrics.RecordReferenceResolution(r.kind(ctx, ref, parent, obj), ok, err, decodeFallback, time.Since(start))
+
+	return obj, ok, err
+}
+
+// ResolveAll resolves every reference embedded in the object (see ResolveAll).
+func (r *InstrumentedResolver) ResolveAll(ctx context.Context, obj runtime.Object) (bool, error) {
+	return ResolveAllWith(ctx, r, obj)
+}
+
+// kind returns the referenced kind, preferring the reference's declared
+// target, so that failed resolutions are attributed.
+func (r *InstrumentedResolver) kind(ctx context.Context, ref Reference, parent, obj runtime.Object) string {
+	if targeted, ok := ref.(TargetedReference); ok {
+		if gvk, _, err := targetWithDefaults(ctx, targeted, r.scheme, parent); err == nil && gvk.Kind != "" {
+			return gvk.Kind
+		}
+	}
+
+	if obj != nil {
+		if gvk := obj.GetObjectKind().GroupVersionKind(); gvk.Kind != "" {
+			return gvk.Kind
+		}
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/retryable"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MaxConcurrentResolves is the maximum number of references ResolveList
+// resolves concurrently.
+const MaxConcurrentResolves = 8
+
+// ErrReferenceNotFound is returned by ResolveList when a referenced object doesn't exist.
+var ErrReferenceNotFound = errors.New("referenced object not found")
+
+// ObjectReferenceList is a list of references to arbitrary Kubernetes resources.
+// +kubebuilder:object:generate=true
+type ObjectReferenceList []ObjectReference
+
+// Resolve resolves all of the references (see ResolveList).
+func (l ObjectReferenceList) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) ([]runtime.Object, error) {
+	refs := make([]Reference, len(l))
+	for i := range l {
+		refs[i] = &l[i]
+	}
+
+	return ResolveList(ctx, reader, scheme, parent, refs)
+}
+
+// ResolveList resolves the references concurrently, returning the resolved
+// objects in the same order as the references. Failures (including missing
+// objects, which wrap ErrReferenceNotFound) are aggregated into a single
+// error, which is retryable if any of the failures are. The objects of
+// failed (or missing optional) references are nil.
+func ResolveList(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object, refs []Reference) ([]runtime.Object, error) {
+	objs := make([]runtime.Object, len(refs))
+	errs := make([]error, len(refs))
+
+	sem := make(chan struct{}, MaxConcurrentResolves)
+
+	var wg sync.WaitGroup
+	for i, ref := range refs {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, ref Reference) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			obj, ok, err := ref.Resolve(ctx, reader, scheme, parent)
+			switch {
+			case err != nil:
+				errs[i] = fmt.Errorf("failed to resolve reference %d: %w", i, err)
+			case !ok && !IsOptional(ref):
+				errs[i] = retryable.New(fmt.Errorf("%w: reference %d", ErrReferenceNotFound, i))
+			default:
+				objs[i] = obj
+			}
+		}(i, ref)
+	}
+	wg.Wait()
+
+	return objs, joinErrors(errs)
+}
+
+// joinErrors joins the errors, the result is retryable (after the shortest
+// retry interval) if any of the errors are.
+func joinErrors(errs []error) error {
+	err := errors.Join(errs...)
+	if err == nil {
+		return nil
+	}
+
+	var retryAfter time.Duration
+	for _, err := range errs {
+		if after, ok := retryable.RetryAfter(err); ok && (retryAfter == 0 || after < retryAfter) {
+			retryAfter = after
+		}
+	}
+
+	if retryAfter == 0 {
+		return err
+	}
+
+	return retryable.After(err, retryAfter)
+}
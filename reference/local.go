@@ -0,0 +1,201 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LocalServiceAccountReference is a reference to a service account in the same namespace.
+// +kubebuilder:object:generate=true
+type LocalServiceAccountReference struct {
+	// Name is the name of the service account.
+	Name string `json:"name"`
+	// Optional indicates that the referenced service account may not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Target returns the kind and key of the referenced service account.
+func (ref *LocalServiceAccountReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	objRef := ObjectReference{Name: ref.Name, APIVersion: "v1", Kind: "ServiceAccount"}
+	return objRef.Target(scheme, parent)
+}
+
+// Resolve resolves the reference to its underlying service account.
+func (ref *LocalServiceAccountReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	objRef := ObjectReference{Name: ref.Name, APIVersion: "v1", Kind: "ServiceAccount"}
+
+	serviceAccount, ok, err := objRef.Resolve(ctx, reader, scheme, parent)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+
+	return serviceAccount.(*corev1.ServiceAccount), true, nil
+}
+
+// IsOptional returns true if the referenced service account may not exist.
+func (ref *LocalServiceAccountReference) IsOptional() bool {
+	return ref.Optional
+}
+
+// LocalServiceReference is a reference to a service in the same namespace.
+// +kubebuilder:object:generate=true
+type LocalServiceReference struct {
+	// Name is the name of the service.
+	Name string `json:"name"`
+	// Optional indicates that the referenced service may not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Target returns the kind and key of the referenced service.
+func (ref *LocalServiceReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	objRef := ObjectReference{Name: ref.Name, APIVersion: "v1", Kind: "Service"}
+	return objRef.Target(scheme, parent)
+}
+
+// Resolve resolves the reference to its underlying service.
+func (ref *LocalServiceReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	objRef := ObjectReference{Name: ref.Name, APIVersion: "v1", Kind: "Service"}
+
+	svc, ok, err := objRef.Resolve(ctx, reader, scheme, parent)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+
+	return svc.(*corev1.Service), true, nil
+}
+
+// IsOptional returns true if the referenced service may not exist.
+func (ref *LocalServiceReference) IsOptional() bool {
+	return ref.Optional
+}
+
+// LocalPVCReference is a reference to a persistent volume claim in the same namespace.
+// +kubebuilder:object:generate=true
+type LocalPVCReference struct {
+	// Name is the name of the persistent volume claim.
+	Name string `json:"name"`
+	// Optional indicates that the referenced persistent volume claim may not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Target returns the kind and key of the referenced persistent volume claim.
+func (ref *LocalPVCReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	objRef := ObjectReference{Name: ref.Name, APIVersion: "v1", Kind: "PersistentVolumeClaim"}
+	return objRef.Target(scheme, parent)
+}
+
+// Resolve resolves the reference to its underlying persistent volume claim.
+func (ref *LocalPVCReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	objRef := ObjectReference{Name: ref.Name, APIVersion: "v1", Kind: "PersistentVolumeClaim"}
+
+	pvc, ok, err := objRef.Resolve(ctx, reader, scheme, parent)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+
+	return pvc.(*corev1.PersistentVolumeClaim), true, nil
+}
+
+// IsOptional returns true if the referenced persistent volume claim may not exist.
+func (ref *LocalPVCReference) IsOptional() bool {
+	return ref.Optional
+}
+
+const (
+	// DefaultIssuerGroup is the API group of cert-manager issuers.
+	DefaultIssuerGroup = "cert-manager.io"
+	// DefaultIssuerVersion is the API version of cert-manager issuers.
+	DefaultIssuerVersion = "v1"
+	// DefaultIssuerKind is the kind of namespaced cert-manager issuers.
+	DefaultIssuerKind = "Issuer"
+	// ClusterIssuerKind is the kind of cluster scoped cert-manager issuers.
+	ClusterIssuerKind = "ClusterIssuer"
+)
+
+// LocalIssuerReference is a reference to a certificate issuer (eg. a
+// cert-manager Issuer in the same namespace, or a ClusterIssuer), in the
+// style of cert-manager's issuer references.
+// +kubebuilder:object:generate=true
+type LocalIssuerReference struct {
+	// Name is the name of the issuer.
+	Name string `json:"name"`
+	// Kind is the kind of the issuer (defaults to Issuer).
+	Kind string `json:"kind,omitempty"`
+	// Group is the API group of the issuer (defaults to cert-manager.io).
+	Group string `json:"group,omitempty"`
+	// Optional indicates that the referenced issuer may not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Target returns the kind and key of the referenced issuer.
+func (ref *LocalIssuerReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	gvk, key, err := ref.objectReference(scheme).Target(scheme, parent)
+	if err == nil && gvk.Kind == ClusterIssuerKind {
+		key.Namespace = ""
+	}
+
+	return gvk, key, err
+}
+
+// Resolve resolves the reference to its underlying issuer. Issuers are
+// resolved as unstructured objects unless their kind is registered with the
+// scheme.
+func (ref *LocalIssuerReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	gvk, key, err := ref.Target(scheme, parent)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return resolveTarget(ctx, reader, scheme, parent, gvk, key, targetConstraints{})
+}
+
+// IsOptional returns true if the referenced issuer may not exist.
+func (ref *LocalIssuerReference) IsOptional() bool {
+	return ref.Optional
+}
+
+func (ref *LocalIssuerReference) objectReference(scheme *runtime.Scheme) *ObjectReference {
+	group := ref.Group
+	if group == "" {
+		group = DefaultIssuerGroup
+	}
+
+	kind := ref.Kind
+	if kind == "" {
+		kind = DefaultIssuerKind
+	}
+
+	// Use the version registered with the scheme, if any.
+	version := DefaultIssuerVersion
+	if versions := scheme.PrioritizedVersionsForGroup(group); len(versions) > 0 {
+		version = versions[0].Version
+	}
+
+	return &ObjectReference{
+		Name:       ref.Name,
+		APIVersion: schema.GroupVersion{Group: group, Version: version}.String(),
+		Kind:       kind,
+		Optional:   ref.Optional,
+	}
+}
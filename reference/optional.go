@@ -0,0 +1,72 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IsOptional returns true if the referenced resource may not exist.
+func (ref *ObjectReference) IsOptional() bool {
+	return ref.Optional
+}
+
+// IsOptional returns true if the referenced resource may not exist.
+func (ref *LocalObjectReference) IsOptional() bool {
+	return ref.Optional
+}
+
+// IsOptional returns true if the referenced secret may not exist.
+func (ref *LocalSecretReference) IsOptional() bool {
+	return ref.Optional
+}
+
+// IsOptional returns true if the referenced config map may not exist.
+func (ref *LocalConfigMapReference) IsOptional() bool {
+	return ref.Optional
+}
+
+// IsOptional returns true if the referenced secret (or its key) may not exist.
+func (ref *SecretKeyReference) IsOptional() bool {
+	return ref.Optional
+}
+
+// IsOptional returns true if the referenced config map (or its key) may not exist.
+func (ref *ConfigMapKeyReference) IsOptional() bool {
+	return ref.Optional
+}
+
+// IsOptional returns true if the reference is optional.
+func IsOptional(ref Reference) bool {
+	optional, ok := ref.(OptionalReference)
+	return ok && optional.IsOptional()
+}
+
+// ResolveOptional resolves a reference that may not exist, returning a nil
+// object (and no error) if the referenced resource is not found.
+func ResolveOptional(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object, ref Reference) (runtime.Object, error) {
+	obj, ok, err := ref.Resolve(ctx, reader, scheme, parent)
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,103 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OwnerFilter selects owner references to resolve.
+type OwnerFilter func(owner metav1.OwnerReference) bool
+
+// ControllerOwner selects the managing controller of the object.
+func ControllerOwner() OwnerFilter {
+	return func(owner metav1.OwnerReference) bool {
+		return owner.Controller != nil && *owner.Controller
+	}
+}
+
+// OwnerOfKind selects owners of the given kind.
+func OwnerOfKind(gk schema.GroupKind) OwnerFilter {
+	return func(owner metav1.OwnerReference) bool {
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		return err == nil && gv.Group == gk.Group && owner.Kind == gk.Kind
+	}
+}
+
+// ResolveOwners resolves the owners of the object (matching all of the
+// filters) into typed objects, falling back to unstructured objects for
+// kinds the scheme doesn't recognize. Owners that no longer exist (or have
+// since been recreated, with a different UID) are omitted.
+func ResolveOwners(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, obj client.Object, filters ...OwnerFilter) ([]runtime.Object, error) {
+	var owners []runtime.Object
+	for _, owner := range obj.GetOwnerReferences() {
+		if !matchesOwnerFilters(owner, filters) {
+			continue
+		}
+
+		// Owners must be in the same namespace (or cluster scoped).
+		ref := ObjectReference{
+			Name:       owner.Name,
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			UID:        owner.UID,
+		}
+
+		resolved, ok, err := ref.Resolve(ctx, reader, scheme, obj)
+		if err != nil {
+			if errors.Is(err, ErrUIDMismatch) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if ok {
+			owners = append(owners, resolved)
+		}
+	}
+
+	return owners, nil
+}
+
+// ResolveController resolves the managing controller of the object, returning
+// false if it has none (or it no longer exists).
+func ResolveController(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, obj client.Object) (runtime.Object, bool, error) {
+	owners, err := ResolveOwners(ctx, reader, scheme, obj, ControllerOwner())
+	if err != nil || len(owners) == 0 {
+		return nil, false, err
+	}
+
+	return owners[0], true, nil
+}
+
+func matchesOwnerFilters(owner metav1.OwnerReference, filters []OwnerFilter) bool {
+	for _, filter := range filters {
+		if !filter(owner) {
+			return false
+		}
+	}
+
+	return true
+}
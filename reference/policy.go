@@ -0,0 +1,102 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ErrKindNotAllowed is returned when a reference to a kind that isn't
+// permitted by a KindPolicy is resolved.
+var ErrKindNotAllowed = errors.New("kind not allowed")
+
+// KindPolicy is an allowlist and/or denylist of the kinds references may be
+// resolved to. A kind of "*" matches every kind in a group.
+type KindPolicy struct {
+	// Allow, if not empty, are the only kinds that may be resolved.
+	Allow []schema.GroupKind
+	// Deny are the kinds that may not be resolved (taking precedence over Allow).
+	Deny []schema.GroupKind
+}
+
+// Allowed returns true if references to the kind may be resolved.
+func (p *KindPolicy) Allowed(gk schema.GroupKind) bool {
+	if matchesGroupKind(p.Deny, gk) {
+		return false
+	}
+
+	return len(p.Allow) == 0 || matchesGroupKind(p.Allow, gk)
+}
+
+func matchesGroupKind(kinds []schema.GroupKind, gk schema.GroupKind) bool {
+	for _, k := range kinds {
+		if k.Group == gk.Group && (k.Kind == "*" || k.Kind == gk.Kind) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// KindPolicyResolver resolves references, returning a terminal error
+// (wrapping ErrKindNotAllowed) for references to kinds that aren't permitted
+// by its policy, so that platform teams can constrain what user supplied
+// references may point to. Only references reporting their target (see
+// TargetedReference) are checked.
+type KindPolicyResolver struct {
+	next   ReferenceResolver
+	scheme *runtime.Scheme
+	policy KindPolicy
+}
+
+// NewKindPolicyResolver returns a new reference resolver enforcing the
+// policy, before resolving references with the next resolver.
+func NewKindPolicyResolver(next ReferenceResolver, scheme *runtime.Scheme, policy KindPolicy) *KindPolicyResolver {
+	return &KindPolicyResolver{
+		next:   next,
+		scheme: scheme,
+		policy: policy,
+	}
+}
+
+// Resolve resolves the reference to its underlying resource.
+func (r *KindPolicyResolver) Resolve(ctx context.Context, ref Reference, parent runtime.Object) (runtime.Object, bool, error) {
+	if targeted, ok := ref.(TargetedReference); ok {
+		gvk, _, err := targetWithDefaults(ctx, targeted, r.scheme, parent)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !r.policy.Allowed(gvk.GroupKind()) {
+			return nil, false, reconcile.TerminalError(fmt.Errorf("%w: %s", ErrKindNotAllowed, gvk.GroupKind()))
+		}
+	}
+
+	return r.next.Resolve(ctx, ref, parent)
+}
+
+// ResolveAll resolves every reference embedded in the object (see ResolveAll).
+func (r *KindPolicyResolver) ResolveAll(ctx context.Context, obj runtime.Object) (bool, error) {
+	return ResolveAllWith(ctx, r, obj)
+}
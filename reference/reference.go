@@ -20,15 +20,42 @@ package reference
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
 
+	"github.com/gpu-ninja/operator-utils/retryable"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var (
+	// ErrSecretNotFound is returned when a referenced secret doesn't exist.
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrConfigMapNotFound is returned when a referenced config map doesn't exist.
+	ErrConfigMapNotFound = errors.New("config map not found")
+	// ErrServiceNotFound is returned when a referenced service doesn't exist.
+	ErrServiceNotFound = errors.New("service not found")
+	// ErrPortNotFound is returned when a referenced service port doesn't exist.
+	ErrPortNotFound = errors.New("port not found")
+	// ErrUIDMismatch is returned when a referenced resource has been recreated.
+	ErrUIDMismatch = errors.New("uid mismatch")
+	// ErrKeyNotFound is returned when a referenced key doesn't exist.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrNotCaughtUp is returned when a referenced resource hasn't yet reached
+	// the generation or resource version required by the reference.
+	ErrNotCaughtUp = errors.New("referenced resource not caught up")
 )
 
 type Reference interface {
@@ -36,6 +63,21 @@ type Reference interface {
 	Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (obj runtime.Object, ok bool, err error)
 }
 
+// TargetedReference is a reference that can report the kind and key of the
+// resource it refers to without resolving it (eg. for indexing).
+type TargetedReference interface {
+	Reference
+	// Target returns the kind and key of the referenced resource.
+	Target(scheme *runtime.Scheme, parent runtime.Object) (gvk schema.GroupVersionKind, key client.ObjectKey, err error)
+}
+
+// OptionalReference is a reference that may be optional.
+type OptionalReference interface {
+	Reference
+	// IsOptional returns true if the referenced resource may not exist.
+	IsOptional() bool
+}
+
 type ObjectWithReferences interface {
 	// ResolveReferences resolves all references in the object.
 	ResolveReferences(ctx context.Context, reader client.Reader, scheme *runtime.Scheme) (bool, error)
@@ -52,47 +94,116 @@ type ObjectReference struct {
 	APIVersion string `json:"apiVersion,omitempty"`
 	// Kind is the kind of the resource.
 	Kind string `json:"kind,omitempty"`
+	// FieldPath is an optional JSONPath (eg. .status.loadBalancer.ingress[0].ip)
+	// of a field of the resource, resolved using ResolveField.
+	FieldPath string `json:"fieldPath,omitempty"`
+	// UID, if set, pins the reference to a specific instance of the resource
+	// (so that it fails to resolve if the resource has been recreated).
+	UID types.UID `json:"uid,omitempty"`
+	// MinGeneration, if set, is the minimum generation the resource must have
+	// reached (and observed, if it reports a status.observedGeneration).
+	MinGeneration int64 `json:"minGeneration,omitempty"`
+	// ResourceVersion, if set, is the minimum resource version the resource
+	// must have reached.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Optional indicates that the referenced resource may not exist.
+	Optional bool `json:"optional,omitempty"`
 }
 
-// Resolve resolves the reference to its underlying resource.
-func (ref *ObjectReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
-	var u unstructured.Unstructured
+// Target returns the kind and key of the referenced resource (defaulting to
+// the API version and namespace of the parent). An omitted kind is left
+// empty, as defaults are only known when resolving or indexing (see
+// WithDefaultGVKs and IndexFunc). Templated names (eg. {{ .parent.Name }}-tls)
+// are evaluated against the parent.
+func (ref *ObjectReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
 	apiVersion := ref.APIVersion
 	if apiVersion == "" {
 		gvks, _, err := scheme.ObjectKinds(parent)
 		if err != nil {
-			return nil, false, fmt.Errorf("failed to get object kinds: %w", err)
+			return schema.GroupVersionKind{}, client.ObjectKey{}, fmt.Errorf("failed to get object kinds: %w", err)
 		}
 
 		if len(gvks) == 0 {
-			return nil, false, fmt.Errorf("no object kinds found")
+			return schema.GroupVersionKind{}, client.ObjectKey{}, fmt.Errorf("no object kinds found")
 		}
 
 		apiVersion = gvks[0].GroupVersion().String()
 	}
 
-	u.SetAPIVersion(apiVersion)
-	u.SetKind(ref.Kind)
-
 	namespace := ref.Namespace
 	if namespace == "" {
 		parentMeta, err := meta.Accessor(parent)
 		if err != nil {
-			return nil, false, fmt.Errorf("failed to get accessor: %w", err)
+			return schema.GroupVersionKind{}, client.ObjectKey{}, fmt.Errorf("failed to get accessor: %w", err)
 		}
 
 		namespace = parentMeta.GetNamespace()
 	}
 
-	err := reader.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: namespace}, &u)
+	name, err := renderName(ref.Name, parent)
+	if err != nil {
+		return schema.GroupVersionKind{}, client.ObjectKey{}, err
+	}
+
+	return schema.FromAPIVersionAndKind(apiVersion, ref.Kind), client.ObjectKey{Name: name, Namespace: namespace}, nil
+}
+
+// Resolve resolves the reference to its underlying resource. References to
+// other namespaces must be permitted by the configured NamespacePolicy (if
+// any). If the reference is pinned to a UID, a terminal error (wrapping
+// ErrUIDMismatch) is returned if the resource has been recreated. If the
+// reference requires a minimum generation or resource version, a retryable
+// error (wrapping ErrNotCaughtUp) is returned until the resource reaches it.
+// An omitted kind or API version is defaulted using WithDefaultGVK (if set).
+func (ref *ObjectReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	ref = ref.withDefaults(ctx)
+
+	gvk, key, err := ref.Target(scheme, parent)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return resolveTarget(ctx, reader, scheme, parent, gvk, key, targetConstraints{
+		uid:             ref.UID,
+		minGeneration:   ref.MinGeneration,
+		resourceVersion: ref.ResourceVersion,
+	})
+}
+
+// targetConstraints are the requirements a resolved resource must meet.
+type targetConstraints struct {
+	uid             types.UID
+	minGeneration   int64
+	resourceVersion string
+}
+
+// resolveTarget resolves the resource with the given kind and key.
+func resolveTarget(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object, gvk schema.GroupVersionKind, key client.ObjectKey, constraints targetConstraints) (runtime.Object, bool, error) {
+	if err := checkNamespacePolicy(ctx, reader, scheme, parent, gvk, key); err != nil {
+		return nil, false, err
+	}
+
+	var u unstructured.Unstructured
+	u.SetGroupVersionKind(gvk)
+
+	err := reader.Get(ctx, key, &u)
 	if err != nil {
-		if errors.IsNotFound(err) {
+		if apierrors.IsNotFound(err) {
 			return nil, false, nil
 		}
 
 		return nil, false, fmt.Errorf("failed to resolve reference: %w", err)
 	}
 
+	if constraints.uid != "" && u.GetUID() != constraints.uid {
+		return nil, false, reconcile.TerminalError(fmt.Errorf("%w: %s %s has uid %s, expected %s",
+			ErrUIDMismatch, gvk.Kind, key, u.GetUID(), constraints.uid))
+	}
+
+	if err := checkCaughtUp(&u, constraints); err != nil {
+		return nil, false, retryable.New(fmt.Errorf("%w: %s %s %v", ErrNotCaughtUp, gvk.Kind, key, err))
+	}
+
 	unstructuredBytes, err := u.MarshalJSON()
 	if err != nil {
 		return nil, false, fmt.Errorf("failed to marshal unstructured object: %w", err)
@@ -111,6 +222,39 @@ func (ref *ObjectReference) Resolve(ctx context.Context, reader client.Reader, s
 	return obj, true, nil
 }
 
+// checkCaughtUp returns an error if the resource hasn't reached the minimum
+// generation or resource version.
+func checkCaughtUp(u *unstructured.Unstructured, constraints targetConstraints) error {
+	if constraints.minGeneration > 0 {
+		if u.GetGeneration() < constraints.minGeneration {
+			return fmt.Errorf("has generation %d, expected at least %d",
+				u.GetGeneration(), constraints.minGeneration)
+		}
+
+		observedGeneration, found, err := unstructured.NestedInt64(u.Object, "status", "observedGeneration")
+		if err == nil && found && observedGeneration < constraints.minGeneration {
+			return fmt.Errorf("has observed generation %d, expected at least %d",
+				observedGeneration, constraints.minGeneration)
+		}
+	}
+
+	if constraints.resourceVersion != "" && u.GetResourceVersion() != constraints.resourceVersion {
+		// Resource versions are opaque, but in practice (etcd) they are
+		// integers, so they are compared as such when possible.
+		current, err := strconv.ParseUint(u.GetResourceVersion(), 10, 64)
+		if err != nil {
+			return fmt.Errorf("has resource version %s, expected %s", u.GetResourceVersion(), constraints.resourceVersion)
+		}
+
+		expected, err := strconv.ParseUint(constraints.resourceVersion, 10, 64)
+		if err != nil || current < expected {
+			return fmt.Errorf("has resource version %s, expected at least %s", u.GetResourceVersion(), constraints.resourceVersion)
+		}
+	}
+
+	return nil
+}
+
 // LocalObjectReference is a reference to a resource in the same namespace.
 // +kubebuilder:object:generate=true
 type LocalObjectReference struct {
@@ -120,17 +264,44 @@ type LocalObjectReference struct {
 	APIVersion string `json:"apiVersion,omitempty"`
 	// Kind is the kind of the resource.
 	Kind string `json:"kind,omitempty"`
+	// FieldPath is an optional JSONPath (eg. .status.loadBalancer.ingress[0].ip)
+	// of a field of the resource, resolved using ResolveField.
+	FieldPath string `json:"fieldPath,omitempty"`
+	// MinGeneration, if set, is the minimum generation the resource must have
+	// reached (and observed, if it reports a status.observedGeneration).
+	MinGeneration int64 `json:"minGeneration,omitempty"`
+	// ResourceVersion, if set, is the minimum resource version the resource
+	// must have reached.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Optional indicates that the referenced resource may not exist.
+	Optional bool `json:"optional,omitempty"`
 }
 
 // Resolve resolves the reference to its underlying resource.
 func (ref *LocalObjectReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
-	objRef := ObjectReference{
-		Name:       ref.Name,
-		APIVersion: ref.APIVersion,
-		Kind:       ref.Kind,
-	}
+	return ref.objectReference().Resolve(ctx, reader, scheme, parent)
+}
+
+// Target returns the kind and key of the referenced resource.
+func (ref *LocalObjectReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	return ref.objectReference().Target(scheme, parent)
+}
 
-	return objRef.Resolve(ctx, reader, scheme, parent)
+// ResolveField resolves the reference to the value of its field path.
+func (ref *LocalObjectReference) ResolveField(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (string, error) {
+	return ref.objectReference().ResolveField(ctx, reader, scheme, parent)
+}
+
+func (ref *LocalObjectReference) objectReference() *ObjectReference {
+	return &ObjectReference{
+		Name:            ref.Name,
+		APIVersion:      ref.APIVersion,
+		Kind:            ref.Kind,
+		FieldPath:       ref.FieldPath,
+		MinGeneration:   ref.MinGeneration,
+		ResourceVersion: ref.ResourceVersion,
+		Optional:        ref.Optional,
+	}
 }
 
 // LocalSecretReference is a reference to a secret in the same namespace.
@@ -138,9 +309,19 @@ func (ref *LocalObjectReference) Resolve(ctx context.Context, reader client.Read
 type LocalSecretReference struct {
 	// Name is the name of the secret.
 	Name string `json:"name"`
+	// Optional indicates that the referenced resource may not exist.
+	Optional bool `json:"optional,omitempty"`
 }
 
-// Resolve resolves the reference to its underlying secret.
+// Target returns the kind and key of the referenced secret.
+func (ref *LocalSecretReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	objRef := ObjectReference{Name: ref.Name, APIVersion: "v1", Kind: "Secret"}
+	return objRef.Target(scheme, parent)
+}
+
+// Resolve resolves the reference to its underlying secret. If the secret
+// doesn't exist in the cluster, it is resolved using the configured
+// SecretProvider (if any).
 func (ref *LocalSecretReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
 	objRef := ObjectReference{
 		Name:       ref.Name,
@@ -149,8 +330,22 @@ func (ref *LocalSecretReference) Resolve(ctx context.Context, reader client.Read
 	}
 
 	secret, ok, err := objRef.Resolve(ctx, reader, scheme, parent)
-	if !ok || err != nil {
-		return nil, ok, err
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !ok {
+		_, key, err := objRef.Target(scheme, parent)
+		if err != nil {
+			return nil, false, err
+		}
+
+		secret, ok, err := resolveExternalSecret(ctx, key)
+		if !ok || err != nil {
+			return nil, ok, err
+		}
+
+		return secret, true, nil
 	}
 
 	return secret.(*corev1.Secret), true, nil
@@ -161,6 +356,14 @@ func (ref *LocalSecretReference) Resolve(ctx context.Context, reader client.Read
 type LocalConfigMapReference struct {
 	// Name is the name of the config map.
 	Name string `json:"name"`
+	// Optional indicates that the referenced resource may not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Target returns the kind and key of the referenced config map.
+func (ref *LocalConfigMapReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	objRef := ObjectReference{Name: ref.Name, APIVersion: "v1", Kind: "ConfigMap"}
+	return objRef.Target(scheme, parent)
 }
 
 // Resolve resolves the reference to its underlying config map.
@@ -186,3 +389,203 @@ type LocalKeyedSecretReference struct {
 	// Key is the key of the value in the secret.
 	Key string `json:"key"`
 }
+
+// SecretKeyReference is a reference to a single key of a secret in the same namespace.
+// +kubebuilder:object:generate=true
+type SecretKeyReference struct {
+	// Name is the name of the secret.
+	Name string `json:"name"`
+	// Key is the key of the value in the secret.
+	Key string `json:"key"`
+	// Optional indicates that the secret (or its key) may not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Target returns the kind and key of the referenced secret.
+func (ref *SecretKeyReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	secretRef := LocalSecretReference{Name: ref.Name}
+	return secretRef.Target(scheme, parent)
+}
+
+// Resolve resolves the reference to its underlying secret.
+func (ref *SecretKeyReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	secretRef := LocalSecretReference{Name: ref.Name}
+	return secretRef.Resolve(ctx, reader, scheme, parent)
+}
+
+// ResolveValue resolves the reference to the value of the key. A retryable
+// error (wrapping ErrSecretNotFound or ErrKeyNotFound) is returned if the
+// secret, or the key, doesn't exist yet (unless the reference is optional,
+// in which case a nil value is returned).
+func (ref *SecretKeyReference) ResolveValue(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) ([]byte, error) {
+	obj, ok, err := ref.Resolve(ctx, reader, scheme, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		if ref.Optional {
+			return nil, nil
+		}
+
+		return nil, retryable.New(fmt.Errorf("%w: %s", ErrSecretNotFound, ref.Name))
+	}
+
+	value, ok := obj.(*corev1.Secret).Data[ref.Key]
+	if !ok {
+		if ref.Optional {
+			return nil, nil
+		}
+
+		return nil, retryable.New(fmt.Errorf("%w: %s in secret %s", ErrKeyNotFound, ref.Key, ref.Name))
+	}
+
+	return value, nil
+}
+
+// ConfigMapKeyReference is a reference to a single key of a config map in the same namespace.
+// +kubebuilder:object:generate=true
+type ConfigMapKeyReference struct {
+	// Name is the name of the config map.
+	Name string `json:"name"`
+	// Key is the key of the value in the config map.
+	Key string `json:"key"`
+	// Optional indicates that the config map (or its key) may not exist.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Target returns the kind and key of the referenced config map.
+func (ref *ConfigMapKeyReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	configMapRef := LocalConfigMapReference{Name: ref.Name}
+	return configMapRef.Target(scheme, parent)
+}
+
+// Resolve resolves the reference to its underlying config map.
+func (ref *ConfigMapKeyReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	configMapRef := LocalConfigMapReference{Name: ref.Name}
+	return configMapRef.Resolve(ctx, reader, scheme, parent)
+}
+
+// ResolveValue resolves the reference to the value of the key (binary data
+// is used if the key isn't present in the config map's data). A retryable
+// error (wrapping ErrConfigMapNotFound or ErrKeyNotFound) is returned if the
+// config map, or the key, doesn't exist yet (unless the reference is
+// optional, in which case an empty value is returned).
+func (ref *ConfigMapKeyReference) ResolveValue(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (string, error) {
+	obj, ok, err := ref.Resolve(ctx, reader, scheme, parent)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		if ref.Optional {
+			return "", nil
+		}
+
+		return "", retryable.New(fmt.Errorf("%w: %s", ErrConfigMapNotFound, ref.Name))
+	}
+
+	configMap := obj.(*corev1.ConfigMap)
+	if value, ok := configMap.Data[ref.Key]; ok {
+		return value, nil
+	}
+
+	if value, ok := configMap.BinaryData[ref.Key]; ok {
+		return string(value), nil
+	}
+
+	if ref.Optional {
+		return "", nil
+	}
+
+	return "", retryable.New(fmt.Errorf("%w: %s in config map %s", ErrKeyNotFound, ref.Key, ref.Name))
+}
+
+// ServiceReference is a reference to a port of a service.
+// +kubebuilder:object:generate=true
+type ServiceReference struct {
+	// Name is the name of the service.
+	Name string `json:"name"`
+	// Namespace is the namespace of the service (defaults to the namespace of the parent).
+	Namespace string `json:"namespace,omitempty"`
+	// Port is the name or number of the service port. Optional if the
+	// service has a single port.
+	Port intstr.IntOrString `json:"port,omitempty"`
+	// Scheme is the URL scheme used to connect to the service (defaults to https).
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// Target returns the kind and key of the referenced service.
+func (ref *ServiceReference) Target(scheme *runtime.Scheme, parent runtime.Object) (schema.GroupVersionKind, client.ObjectKey, error) {
+	return ref.objectReference().Target(scheme, parent)
+}
+
+// Resolve resolves the reference to its underlying service.
+func (ref *ServiceReference) Resolve(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (runtime.Object, bool, error) {
+	svc, ok, err := ref.objectReference().Resolve(ctx, reader, scheme, parent)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+
+	return svc.(*corev1.Service), true, nil
+}
+
+// ResolveURL resolves the reference to the cluster DNS URL of the service
+// port (eg. https://name.namespace.svc:443). A retryable error (wrapping
+// ErrServiceNotFound) is returned if the service doesn't exist yet, and an
+// error wrapping ErrPortNotFound if the port doesn't exist.
+func (ref *ServiceReference) ResolveURL(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object) (*url.URL, error) {
+	obj, ok, err := ref.Resolve(ctx, reader, scheme, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, retryable.New(fmt.Errorf("%w: %s", ErrServiceNotFound, ref.Name))
+	}
+
+	svc := obj.(*corev1.Service)
+
+	port, err := ref.servicePort(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	urlScheme := ref.Scheme
+	if urlScheme == "" {
+		urlScheme = "https"
+	}
+
+	return &url.URL{
+		Scheme: urlScheme,
+		Host:   net.JoinHostPort(svc.Name+"."+svc.Namespace+".svc", strconv.Itoa(int(port.Port))),
+	}, nil
+}
+
+func (ref *ServiceReference) objectReference() *ObjectReference {
+	return &ObjectReference{
+		Name:       ref.Name,
+		Namespace:  ref.Namespace,
+		APIVersion: "v1",
+		Kind:       "Service",
+	}
+}
+
+func (ref *ServiceReference) servicePort(svc *corev1.Service) (*corev1.ServicePort, error) {
+	if ref.Port.IntVal == 0 && ref.Port.StrVal == "" {
+		if len(svc.Spec.Ports) != 1 {
+			return nil, fmt.Errorf("%w: service %s has %d ports, a port must be specified", ErrPortNotFound, svc.Name, len(svc.Spec.Ports))
+		}
+
+		return &svc.Spec.Ports[0], nil
+	}
+
+	for i, port := range svc.Spec.Ports {
+		if (ref.Port.Type == intstr.String && port.Name == ref.Port.StrVal) ||
+			(ref.Port.Type == intstr.Int && port.Port == ref.Port.IntVal) {
+			return &svc.Spec.Ports[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s in service %s", ErrPortNotFound, ref.Port.String(), svc.Name)
+}
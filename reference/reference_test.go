@@ -19,17 +19,46 @@ package reference_test
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gpu-ninja/operator-utils/clusters"
+	"github.com/gpu-ninja/operator-utils/metrics"
 	"github.com/gpu-ninja/operator-utils/reference"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/tracing"
+	"github.com/gpu-ninja/operator-utils/waitutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 func TestResolveReference(t *testing.T) {
@@ -107,11 +136,2195 @@ func TestResolveReference(t *testing.T) {
 	})
 }
 
+func TestUIDPinnedReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+			UID:       "first-uid",
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	ref := reference.ObjectReference{Name: "demo", APIVersion: "v1", Kind: "Secret", UID: "first-uid"}
+
+	_, ok, err := ref.Resolve(ctx, c, scheme, parent)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ref.UID = "second-uid"
+
+	_, _, err = ref.Resolve(ctx, c, scheme, parent)
+	require.ErrorIs(t, err, reference.ErrUIDMismatch)
+	assert.ErrorIs(t, err, reconcile.TerminalError(nil))
+	assert.False(t, retryable.Is(err))
+}
+
+func TestConstrainedReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, appsv1.AddToScheme(scheme))
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "demo",
+			Namespace:  "default",
+			Generation: 2,
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 1,
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment).WithStatusSubresource(deployment).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Generation", func(t *testing.T) {
+		ref := reference.LocalObjectReference{Name: "demo", APIVersion: "apps/v1", Kind: "Deployment", MinGeneration: 2}
+
+		_, _, err := ref.Resolve(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrNotCaughtUp)
+		assert.True(t, retryable.Is(err))
+		assert.Contains(t, err.Error(), "observed generation 1")
+
+		var current appsv1.Deployment
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(deployment), &current))
+
+		current.Status.ObservedGeneration = 2
+		require.NoError(t, c.Status().Update(ctx, &current))
+
+		_, ok, err := ref.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ref.MinGeneration = 3
+
+		_, _, err = ref.Resolve(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrNotCaughtUp)
+		assert.Contains(t, err.Error(), "generation 2, expected at least 3")
+	})
+
+	t.Run("Resource Version", func(t *testing.T) {
+		var current appsv1.Deployment
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(deployment), &current))
+
+		resourceVersion, err := strconv.ParseUint(current.ResourceVersion, 10, 64)
+		require.NoError(t, err)
+
+		ref := reference.ObjectReference{Name: "demo", APIVersion: "apps/v1", Kind: "Deployment"}
+
+		ref.ResourceVersion = strconv.FormatUint(resourceVersion-1, 10)
+		_, ok, err := ref.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ref.ResourceVersion = strconv.FormatUint(resourceVersion+1, 10)
+		_, _, err = ref.Resolve(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrNotCaughtUp)
+		assert.True(t, retryable.Is(err))
+
+		ref.ResourceVersion = "opaque"
+		_, _, err = ref.Resolve(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrNotCaughtUp)
+	})
+}
+
+func TestSecretProvider(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+			UID:       "parent-uid",
+		},
+	}
+
+	now := time.Now()
+	fakeClock := clocktesting.NewFakePassiveClock(now)
+
+	provider := &fakeSecretProvider{
+		secrets: map[client.ObjectKey]*reference.ExternalSecret{
+			{Namespace: "default", Name: "vault-creds"}: {
+				Data:      map[string][]byte{"password": []byte("hunter2")},
+				ExpiresAt: now.Add(time.Hour),
+			},
+		},
+	}
+
+	cachingProvider := reference.NewCachingSecretProvider(provider, reference.CacheOptions{
+		RenewBefore: 10 * time.Minute,
+		Clock:       fakeClock,
+	})
+
+	ctx := reference.WithSecretProvider(context.Background(), cachingProvider)
+
+	ref := reference.LocalKeyedSecretReference{
+		LocalSecretReference: &reference.LocalSecretReference{Name: "vault-creds"},
+		Key:                  "password",
+	}
+
+	obj, ok, err := ref.Resolve(ctx, c, scheme, parent)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	secret := obj.(*corev1.Secret)
+	assert.Equal(t, "hunter2", string(secret.Data["password"]))
+	assert.Equal(t, "true", secret.Annotations[reference.ExternalSecretAnnotation])
+	assert.Equal(t, 1, provider.calls)
+
+	// Cached until shortly before expiry.
+	_, _, err = ref.Resolve(ctx, c, scheme, parent)
+	require.NoError(t, err)
+	assert.Equal(t, 1, provider.calls)
+
+	// Renewal failures fall back to the cached secret until it expires.
+	fakeClock.SetTime(now.Add(55 * time.Minute))
+	provider.err = errors.New("vault sealed")
+
+	_, ok, err = ref.Resolve(ctx, c, scheme, parent)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, provider.calls)
+
+	fakeClock.SetTime(now.Add(2 * time.Hour))
+
+	_, _, err = ref.Resolve(ctx, c, scheme, parent)
+	assert.ErrorContains(t, err, "vault sealed")
+
+	provider.err = nil
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, ok, err := (&reference.LocalSecretReference{Name: "missing"}).Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		// Without a provider.
+		_, ok, err = ref.Resolve(context.Background(), c, scheme, parent)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Materialize", func(t *testing.T) {
+		materialized, err := reference.MaterializeSecret(ctx, c, scheme, parent, secret)
+		require.NoError(t, err)
+		assert.Equal(t, "hunter2", string(materialized.Data["password"]))
+		require.Len(t, materialized.OwnerReferences, 1)
+		assert.Equal(t, parent.UID, materialized.OwnerReferences[0].UID)
+
+		// Secrets in the cluster take precedence.
+		provider.err = errors.New("should not be called")
+
+		obj, ok, err := ref.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "hunter2", string(obj.(*corev1.Secret).Data["password"]))
+	})
+}
+
+func TestSecretKeyReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			"password": []byte("change-me"),
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Resolved", func(t *testing.T) {
+		ref := reference.SecretKeyReference{Name: "demo", Key: "password"}
+
+		value, err := ref.ResolveValue(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, "change-me", string(value))
+	})
+
+	t.Run("Missing Secret", func(t *testing.T) {
+		ref := reference.SecretKeyReference{Name: "missing", Key: "password"}
+
+		_, err := ref.ResolveValue(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrSecretNotFound)
+		assert.True(t, retryable.Is(err))
+	})
+
+	t.Run("Missing Key", func(t *testing.T) {
+		ref := reference.SecretKeyReference{Name: "demo", Key: "token"}
+
+		_, err := ref.ResolveValue(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrKeyNotFound)
+		assert.True(t, retryable.Is(err))
+	})
+}
+
+func TestConfigMapKeyReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+		Data: map[string]string{
+			"endpoint": "https://example.com",
+		},
+		BinaryData: map[string][]byte{
+			"ca.crt": []byte("certificate"),
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Resolved", func(t *testing.T) {
+		ref := reference.ConfigMapKeyReference{Name: "demo", Key: "endpoint"}
+
+		value, err := ref.ResolveValue(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", value)
+	})
+
+	t.Run("Binary Data", func(t *testing.T) {
+		ref := reference.ConfigMapKeyReference{Name: "demo", Key: "ca.crt"}
+
+		value, err := ref.ResolveValue(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, "certificate", value)
+	})
+
+	t.Run("Missing ConfigMap", func(t *testing.T) {
+		ref := reference.ConfigMapKeyReference{Name: "missing", Key: "endpoint"}
+
+		_, err := ref.ResolveValue(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrConfigMapNotFound)
+		assert.True(t, retryable.Is(err))
+	})
+
+	t.Run("Missing Key", func(t *testing.T) {
+		ref := reference.ConfigMapKeyReference{Name: "demo", Key: "token"}
+
+		_, err := ref.ResolveValue(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrKeyNotFound)
+		assert.True(t, retryable.Is(err))
+	})
+}
+
+func TestServiceReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "single",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "https", Port: 443}},
+		},
+	}, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "multi",
+			Namespace: "other",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80}, {Name: "metrics", Port: 9090}},
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Single Port", func(t *testing.T) {
+		ref := reference.ServiceReference{Name: "single"}
+
+		u, err := ref.ResolveURL(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, "https://single.default.svc:443", u.String())
+	})
+
+	t.Run("Named Port", func(t *testing.T) {
+		ref := reference.ServiceReference{Name: "multi", Namespace: "other", Port: intstr.FromString("metrics"), Scheme: "http"}
+
+		u, err := ref.ResolveURL(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, "http://multi.other.svc:9090", u.String())
+	})
+
+	t.Run("Port Number", func(t *testing.T) {
+		ref := reference.ServiceReference{Name: "multi", Namespace: "other", Port: intstr.FromInt32(80), Scheme: "http"}
+
+		u, err := ref.ResolveURL(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, "http://multi.other.svc:80", u.String())
+	})
+
+	t.Run("Missing Port", func(t *testing.T) {
+		ref := reference.ServiceReference{Name: "multi", Namespace: "other", Port: intstr.FromString("grpc")}
+
+		_, err := ref.ResolveURL(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrPortNotFound)
+
+		ref = reference.ServiceReference{Name: "multi", Namespace: "other"}
+
+		_, err = ref.ResolveURL(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrPortNotFound)
+	})
+
+	t.Run("Missing Service", func(t *testing.T) {
+		ref := reference.ServiceReference{Name: "missing"}
+
+		_, err := ref.ResolveURL(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrServiceNotFound)
+		assert.True(t, retryable.Is(err))
+	})
+}
+
+func TestResolveField(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{{Name: "https", Port: 443}},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(svc).WithStatusSubresource(svc).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	ref := reference.LocalObjectReference{
+		Name:       "demo",
+		APIVersion: "v1",
+		Kind:       "Service",
+		FieldPath:  ".status.loadBalancer.ingress[0].ip",
+	}
+
+	t.Run("Not Populated", func(t *testing.T) {
+		_, err := ref.ResolveField(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrFieldNotPopulated)
+		assert.True(t, retryable.Is(err))
+	})
+
+	t.Run("Populated", func(t *testing.T) {
+		svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+		require.NoError(t, c.Status().Update(ctx, svc))
+
+		value, err := ref.ResolveField(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", value)
+	})
+
+	t.Run("Non Scalar", func(t *testing.T) {
+		ref := reference.ObjectReference{Name: "demo", APIVersion: "v1", Kind: "Service", FieldPath: "{.spec.ports[0]}"}
+
+		value, err := ref.ResolveField(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"name":"https","port":443,"targetPort":0}`, value)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		ref := reference.ObjectReference{Name: "demo", APIVersion: "v1", Kind: "Service", FieldPath: ".spec.ports[0"}
+
+		_, err := ref.ResolveField(ctx, c, scheme, parent)
+		require.Error(t, err)
+		assert.False(t, retryable.Is(err))
+	})
+}
+
+func TestWaitFor(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	opts := waitutil.Options{Interval: 10 * time.Millisecond}
+
+	t.Run("Resolved", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+
+			_ = c.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "demo",
+					Namespace: "default",
+				},
+			})
+		}()
+
+		obj, err := reference.WaitFor(ctx, c, scheme, parent, &reference.LocalSecretReference{Name: "demo"}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, "demo", obj.(*corev1.Secret).Name)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		t.Cleanup(cancel)
+
+		_, err := reference.WaitFor(ctx, c, scheme, parent, &reference.LocalSecretReference{Name: "missing"}, opts)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		_, err := reference.WaitFor(context.Background(), c, scheme, parent, &reference.LocalSecretReference{Name: "missing"},
+			waitutil.Options{Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond})
+		require.ErrorIs(t, err, waitutil.ErrTimeout)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		errBroken := errors.New("broken")
+		broken := interceptor.NewClient(c, interceptor.Funcs{
+			Get: func(_ context.Context, _ client.WithWatch, _ client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+				return errBroken
+			},
+		})
+
+		_, err := reference.WaitFor(context.Background(), broken, scheme, parent, &reference.LocalSecretReference{Name: "demo"}, opts)
+		require.ErrorIs(t, err, errBroken)
+	})
+}
+
+func TestLocalTypedReferences(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	issuerGVK := schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Issuer"}
+	clusterIssuerGVK := issuerGVK.GroupVersion().WithKind("ClusterIssuer")
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(issuerGVK, meta.RESTScopeNamespace)
+	mapper.Add(clusterIssuerGVK, meta.RESTScopeRoot)
+
+	issuer := &unstructured.Unstructured{}
+	issuer.SetGroupVersionKind(issuerGVK)
+	issuer.SetName("demo")
+	issuer.SetNamespace("default")
+
+	clusterIssuer := &unstructured.Unstructured{}
+	clusterIssuer.SetGroupVersionKind(clusterIssuerGVK)
+	clusterIssuer.SetName("demo")
+
+	objectMeta := metav1.ObjectMeta{Name: "demo", Namespace: "default"}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(
+		&corev1.ServiceAccount{ObjectMeta: objectMeta},
+		&corev1.Service{ObjectMeta: objectMeta},
+		&corev1.PersistentVolumeClaim{ObjectMeta: objectMeta},
+		issuer,
+		clusterIssuer,
+	).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		ref      reference.Reference
+		expected runtime.Object
+	}{
+		{&reference.LocalServiceAccountReference{Name: "demo"}, &corev1.ServiceAccount{}},
+		{&reference.LocalServiceReference{Name: "demo"}, &corev1.Service{}},
+		{&reference.LocalPVCReference{Name: "demo"}, &corev1.PersistentVolumeClaim{}},
+		{&reference.LocalIssuerReference{Name: "demo"}, &unstructured.Unstructured{}},
+		{&reference.LocalIssuerReference{Name: "demo", Kind: "ClusterIssuer"}, &unstructured.Unstructured{}},
+	}
+
+	for _, tt := range tests {
+		obj, ok, err := tt.ref.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.IsType(t, tt.expected, obj)
+	}
+
+	gvk, key, err := (&reference.LocalIssuerReference{Name: "demo", Kind: "ClusterIssuer"}).Target(scheme, parent)
+	require.NoError(t, err)
+	assert.Equal(t, clusterIssuerGVK, gvk)
+	assert.Equal(t, client.ObjectKey{Name: "demo"}, key)
+
+	// Cluster scoped issuers aren't cross namespace references.
+	policyCtx := reference.WithNamespacePolicy(ctx, reference.AllowList{})
+
+	obj, ok, err := (&reference.LocalIssuerReference{Name: "demo", Kind: "ClusterIssuer"}).Resolve(policyCtx, c, scheme, parent)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "ClusterIssuer", obj.GetObjectKind().GroupVersionKind().Kind)
+}
+
+func TestResolveTyped(t *testing.T) {
+	clientScheme := runtime.NewScheme()
+	clientScheme.AddKnownTypes(testGV, &MyObject{})
+	require.NoError(t, corev1.AddToScheme(clientScheme))
+
+	reader := fake.NewClientBuilder().WithScheme(clientScheme).WithObjects(&MyObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "first",
+			Namespace: "default",
+		},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			"secret": []byte("change-me"),
+		},
+	}).Build()
+
+	ctx := context.Background()
+
+	// Intentionally don't register the secret type.
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(testGV, &MyObject{})
+
+	parent := &MyObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "second",
+			Namespace: "default",
+		},
+	}
+
+	t.Run("Registered Type", func(t *testing.T) {
+		ref := &reference.LocalObjectReference{Name: "first", Kind: "MyObject"}
+
+		obj, ok, err := reference.ResolveTyped[*MyObject](ctx, reader, scheme, parent, ref)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "first", obj.Name)
+	})
+
+	t.Run("Unregistered Type", func(t *testing.T) {
+		ref := &reference.ObjectReference{Name: "demo", APIVersion: "v1", Kind: "Secret"}
+
+		secret, ok, err := reference.ResolveTyped[*corev1.Secret](ctx, reader, scheme, parent, ref)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "change-me", string(secret.Data["secret"]))
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		ref := &reference.LocalObjectReference{Name: "missing", Kind: "MyObject"}
+
+		_, ok, err := reference.ResolveTyped[*MyObject](ctx, reader, scheme, parent, ref)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Mismatched Type", func(t *testing.T) {
+		ref := &reference.ObjectReference{Name: "demo", APIVersion: "v1", Kind: "Secret"}
+
+		_, _, err := reference.ResolveTyped[*corev1.ConfigMap](ctx, reader, scheme, parent, ref)
+		require.ErrorIs(t, err, reference.ErrUnexpectedType)
+		assert.Contains(t, err.Error(), "resolved Secret.v1, expected ConfigMap.v1")
+	})
+}
+
+func TestResolveAll(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}).Build()
+
+	ctx := context.Background()
+
+	newObject := func() *objectWithSpec {
+		return &objectWithSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "parent",
+				Namespace: "default",
+			},
+			Spec: objectSpec{
+				Secret:   reference.LocalSecretReference{Name: "demo"},
+				Optional: &reference.LocalKeyedSecretReference{Key: "unset"},
+				ConfigMaps: []reference.LocalConfigMapReference{
+					{Name: "demo"},
+				},
+				Objects: map[string]*reference.ObjectReference{
+					"first": {Name: "demo", APIVersion: "v1", Kind: "ConfigMap"},
+				},
+			},
+		}
+	}
+
+	t.Run("Resolved", func(t *testing.T) {
+		ok, err := reference.ResolveAll(ctx, c, scheme, newObject())
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		obj := newObject()
+		obj.Spec.Nested.ConfigMap = &reference.LocalConfigMapReference{Name: "missing"}
+
+		ok, err := reference.ResolveAll(ctx, c, scheme, obj)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		obj = newObject()
+		obj.Spec.Objects["second"] = &reference.ObjectReference{Name: "missing", APIVersion: "v1", Kind: "Secret"}
+
+		ok, err = reference.ResolveAll(ctx, c, scheme, obj)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		errBroken := errors.New("broken")
+		broken := interceptor.NewClient(c, interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if key.Name == "broken" {
+					return errBroken
+				}
+
+				return c.Get(ctx, key, obj, opts...)
+			},
+		})
+
+		obj := newObject()
+		obj.Spec.Objects["second"] = &reference.ObjectReference{Name: "broken", APIVersion: "v1", Kind: "Secret"}
+
+		_, err := reference.ResolveAll(ctx, broken, scheme, obj)
+		require.ErrorIs(t, err, errBroken)
+		assert.Contains(t, err.Error(), "failed to resolve spec.objects[second]")
+	})
+}
+
+func TestResolveAllWithReport(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	errBroken := errors.New("broken")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if key.Name == "broken" {
+				return errBroken
+			}
+
+			return c.Get(ctx, key, obj, opts...)
+		},
+	}).Build()
+
+	ctx := context.Background()
+
+	t.Run("Resolved", func(t *testing.T) {
+		obj := &objectWithSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "parent",
+				Namespace:  "default",
+				Generation: 2,
+			},
+			Spec: objectSpec{
+				Secret: reference.LocalSecretReference{Name: "demo"},
+				ConfigMaps: []reference.LocalConfigMapReference{
+					{Name: "missing", Optional: true},
+				},
+			},
+		}
+
+		report, err := reference.ResolveAllWithReport(ctx, c, scheme, obj)
+		require.NoError(t, err)
+		assert.True(t, report.Resolved())
+		assert.NoError(t, report.Err())
+
+		var conditions []metav1.Condition
+		report.SetCondition(&conditions, obj.Generation)
+
+		require.Len(t, conditions, 1)
+		assert.Equal(t, reference.ConditionTypeMissingDependencies, conditions[0].Type)
+		assert.Equal(t, metav1.ConditionFalse, conditions[0].Status)
+		assert.Equal(t, reference.ReasonAllReferencesResolved, conditions[0].Reason)
+		assert.Equal(t, int64(2), conditions[0].ObservedGeneration)
+	})
+
+	t.Run("Unresolved", func(t *testing.T) {
+		obj := &objectWithSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "parent",
+				Namespace: "default",
+			},
+			Spec: objectSpec{
+				Secret: reference.LocalSecretReference{Name: "missing"},
+				ConfigMaps: []reference.LocalConfigMapReference{
+					{Name: "demo"},
+				},
+				Objects: map[string]*reference.ObjectReference{
+					"first": {Name: "broken", APIVersion: "v1", Kind: "Secret"},
+				},
+			},
+		}
+
+		report, err := reference.ResolveAllWithReport(ctx, c, scheme, obj)
+		require.NoError(t, err)
+		assert.False(t, report.Resolved())
+
+		require.Len(t, report.Unresolved, 3)
+
+		assert.Equal(t, "spec.secret", report.Unresolved[0].Path)
+		assert.Equal(t, "Secret", report.Unresolved[0].GroupVersionKind.Kind)
+		assert.Equal(t, client.ObjectKey{Name: "missing", Namespace: "default"}, report.Unresolved[0].Key)
+		assert.Equal(t, reference.UnresolvedReasonNotFound, report.Unresolved[0].Reason)
+		assert.NoError(t, report.Unresolved[0].Err)
+
+		assert.Equal(t, "spec.configMaps[0]", report.Unresolved[1].Path)
+		assert.Equal(t, reference.UnresolvedReasonNotFound, report.Unresolved[1].Reason)
+
+		assert.Equal(t, "spec.objects[first]", report.Unresolved[2].Path)
+		assert.Equal(t, reference.UnresolvedReasonError, report.Unresolved[2].Reason)
+		assert.ErrorIs(t, report.Unresolved[2].Err, errBroken)
+
+		err = report.Err()
+		require.ErrorIs(t, err, errBroken)
+		assert.Contains(t, err.Error(), "failed to resolve spec.objects[first]")
+
+		condition := report.Condition(1)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, reference.ReasonReferenceResolveError, condition.Reason)
+		assert.Contains(t, condition.Message, "3 unresolved references")
+		assert.Contains(t, condition.Message, "spec.secret (Secret default/missing): not found")
+	})
+}
+
+func TestDefaultGVK(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	scheme.AddKnownTypes(testGV, &MyObject{})
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}).Build()
+
+	parent := &MyObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Resolve", func(t *testing.T) {
+		ref := reference.LocalObjectReference{Name: "demo", Kind: "Secret"}
+
+		// Without a default, the API version of the parent is inherited.
+		gvk, _, err := ref.Target(scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, testGV.WithKind("Secret"), gvk)
+
+		ctx := reference.WithDefaultGVK(ctx, corev1.SchemeGroupVersion.WithKind("Secret"))
+
+		obj, ok, err := ref.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.IsType(t, &corev1.Secret{}, obj)
+
+		ref.Kind = ""
+
+		obj, ok, err = ref.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.IsType(t, &corev1.Secret{}, obj)
+
+		// The API version isn't defaulted for other kinds.
+		ref.Kind = "ConfigMap"
+
+		_, ok, err = ref.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Per Field", func(t *testing.T) {
+		obj := &objectWithSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "parent",
+				Namespace: "default",
+			},
+			Spec: objectSpec{
+				Secret: reference.LocalSecretReference{Name: "demo"},
+				Objects: map[string]*reference.ObjectReference{
+					"first": {Name: "demo"},
+				},
+			},
+		}
+
+		ctx := reference.WithDefaultGVK(ctx, corev1.SchemeGroupVersion.WithKind("Secret"))
+		ctx = reference.WithDefaultGVK(ctx, corev1.SchemeGroupVersion.WithKind("ConfigMap"), "spec.objects")
+
+		var resolved []runtime.Object
+		recorder := interceptor.NewClient(c.(client.WithWatch), interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if err := c.Get(ctx, key, obj, opts...); err != nil {
+					return err
+				}
+
+				resolved = append(resolved, obj)
+				return nil
+			},
+		})
+
+		ok, err := reference.ResolveAll(ctx, recorder, scheme, obj)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		require.Len(t, resolved, 2)
+		assert.Equal(t, "ConfigMap", resolved[1].GetObjectKind().GroupVersionKind().Kind)
+
+		obj.Spec.Objects["first"].Name = "missing"
+
+		report, err := reference.ResolveAllWithReport(ctx, c, scheme, obj)
+		require.NoError(t, err)
+		require.Len(t, report.Unresolved, 1)
+		assert.Equal(t, corev1.SchemeGroupVersion.WithKind("ConfigMap"), report.Unresolved[0].GroupVersionKind)
+	})
+}
+
+func TestTracingResolver(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	errBroken := errors.New("broken")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if key.Name == "broken" {
+				return errBroken
+			}
+
+			return c.Get(ctx, key, obj, opts...)
+		},
+	}).Build()
+
+	resolver := reference.NewTracingResolver(reference.NewReaderResolver(c, scheme), scheme, tracing.WithTracerProvider(tp))
+
+	obj := &objectWithSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+		Spec: objectSpec{
+			Secret: reference.LocalSecretReference{Name: "demo"},
+			ConfigMaps: []reference.LocalConfigMapReference{
+				{Name: "missing"},
+			},
+		},
+	}
+
+	ctx := context.Background()
+
+	ok, err := resolver.ResolveAll(ctx, obj)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "Resolve Secret", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), tracing.AttributeKind.String("Secret"))
+	assert.Contains(t, spans[0].Attributes(), tracing.AttributeNamespace.String("default"))
+	assert.Contains(t, spans[0].Attributes(), tracing.AttributeName.String("demo"))
+	assert.Contains(t, spans[0].Attributes(), reference.AttributeFieldPath.String("spec.secret"))
+	assert.Contains(t, spans[0].Attributes(), reference.AttributeResolved.Bool(true))
+	assert.Contains(t, spans[0].Attributes(), reference.AttributeDecode.String(reference.DecodeTyped))
+
+	assert.Equal(t, "Resolve ConfigMap", spans[1].Name())
+	assert.Contains(t, spans[1].Attributes(), reference.AttributeResolved.Bool(false))
+
+	_, _, err = resolver.Resolve(ctx, &reference.ObjectReference{Name: "broken", APIVersion: "v1", Kind: "Secret"}, obj)
+	require.ErrorIs(t, err, errBroken)
+
+	spans = recorder.Ended()
+	require.Len(t, spans, 3)
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+}
+
+func TestResolver(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			"password": []byte("change-me"),
+		},
+	}
+
+	var gets int
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			gets++
+			return c.Get(ctx, key, obj, opts...)
+		},
+	}).Build()
+
+	informer := &controllertest.FakeInformer{}
+	clock := clocktesting.NewFakePassiveClock(time.Now())
+
+	resolver := reference.NewResolver(c, scheme, reference.ResolverOptions{
+		Informers: &fakeInformers{informer: informer},
+		TTL:       time.Minute,
+		Clock:     clock,
+	})
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	require.NoError(t, resolver.Watch(ctx, &corev1.Secret{}))
+
+	resolvePassword := func(t *testing.T) string {
+		ref := reference.SecretKeyReference{Name: "demo", Key: "password"}
+
+		value, err := ref.ResolveValue(ctx, resolver, scheme, parent)
+		require.NoError(t, err)
+
+		return string(value)
+	}
+
+	t.Run("Cached", func(t *testing.T) {
+		assert.Equal(t, "change-me", resolvePassword(t))
+		assert.Equal(t, "change-me", resolvePassword(t))
+		assert.Equal(t, 1, gets)
+	})
+
+	t.Run("Invalidated", func(t *testing.T) {
+		updated := secret.DeepCopy()
+		updated.Data["password"] = []byte("hunter2")
+		require.NoError(t, c.Update(ctx, updated))
+
+		informer.Update(secret, updated)
+
+		assert.Equal(t, "hunter2", resolvePassword(t))
+		assert.Equal(t, 2, gets)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		clock.SetTime(clock.Now().Add(2 * time.Minute))
+
+		assert.Equal(t, "hunter2", resolvePassword(t))
+		assert.Equal(t, 3, gets)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		ref := &reference.LocalSecretReference{Name: "missing"}
+
+		_, ok, err := resolver.Resolve(ctx, ref, parent)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		_, ok, err = resolver.Resolve(ctx, ref, parent)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 4, gets)
+
+		missing := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "missing",
+				Namespace: "default",
+			},
+		}
+		require.NoError(t, c.Create(ctx, missing))
+
+		informer.Add(missing)
+
+		_, ok, err = resolver.Resolve(ctx, ref, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 5, gets)
+	})
+
+	t.Run("Unwatched", func(t *testing.T) {
+		informers := &fakeInformers{informer: &controllertest.FakeInformer{}}
+		resolver := reference.NewResolver(c, scheme, reference.ResolverOptions{
+			Informers: informers,
+			Clock:     clock,
+		})
+
+		ref := &reference.LocalConfigMapReference{Name: "missing"}
+
+		// Misses aren't cached until the kind is watched.
+		gets = 0
+		for i := 0; i < 2; i++ {
+			_, ok, err := resolver.Resolve(ctx, ref, parent)
+			require.NoError(t, err)
+			assert.False(t, ok)
+		}
+		assert.Equal(t, 2, gets)
+		assert.Zero(t, informers.calls.Load())
+
+		// Once started, the kind is watched in the background.
+		ctx, cancel := context.WithCancel(ctx)
+		t.Cleanup(cancel)
+
+		go func() {
+			_ = resolver.Start(ctx)
+		}()
+
+		require.Eventually(t, func() bool {
+			if _, _, err := resolver.Resolve(ctx, ref, parent); err != nil {
+				return false
+			}
+
+			gets = 0
+			_, _, err := resolver.Resolve(ctx, ref, parent)
+
+			return err == nil && gets == 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, int32(1), informers.calls.Load())
+	})
+
+	t.Run("Watch Failed", func(t *testing.T) {
+		informers := &fakeInformers{err: errors.New("forbidden")}
+		resolver := reference.NewResolver(c, scheme, reference.ResolverOptions{
+			Informers: informers,
+		})
+
+		require.Error(t, resolver.Watch(ctx, &corev1.ConfigMap{}))
+
+		ctx, cancel := context.WithCancel(ctx)
+		t.Cleanup(cancel)
+
+		go func() {
+			_ = resolver.Start(ctx)
+		}()
+
+		ref := &reference.LocalConfigMapReference{Name: "missing"}
+
+		// Failed watches are retried.
+		require.Eventually(t, func() bool {
+			_, _, err := resolver.Resolve(ctx, ref, parent)
+
+			return err == nil && informers.calls.Load() > 2
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestNamespacePolicy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(reference.ReferenceGrantGroupVersionKind, meta.RESTScopeNamespace)
+
+	grant := &unstructured.Unstructured{}
+	grant.SetGroupVersionKind(reference.ReferenceGrantGroupVersionKind)
+	grant.SetName("allow-parent")
+	grant.SetNamespace("granted")
+	require.NoError(t, unstructured.SetNestedSlice(grant.Object, []any{
+		map[string]any{"group": "", "kind": "ConfigMap", "namespace": "default"},
+	}, "spec", "from"))
+	require.NoError(t, unstructured.SetNestedSlice(grant.Object, []any{
+		map[string]any{"group": "", "kind": "Secret", "name": "demo"},
+	}, "spec", "to"))
+
+	objs := []client.Object{grant}
+	for _, namespace := range []string{"default", "granted", "other"} {
+		objs = append(objs, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "demo",
+				Namespace: namespace,
+			},
+		})
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).WithObjects(objs...).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	resolve := func(ctx context.Context, namespace string) error {
+		ref := reference.ObjectReference{Name: "demo", Namespace: namespace, APIVersion: "v1", Kind: "Secret"}
+
+		_, ok, err := ref.Resolve(ctx, c, scheme, parent)
+		if err == nil {
+			assert.True(t, ok)
+		}
+
+		return err
+	}
+
+	t.Run("No Policy", func(t *testing.T) {
+		require.NoError(t, resolve(context.Background(), "other"))
+	})
+
+	t.Run("Reference Grants", func(t *testing.T) {
+		ctx := reference.WithNamespacePolicy(context.Background(), reference.ReferenceGrants{})
+
+		require.NoError(t, resolve(ctx, ""))
+		require.NoError(t, resolve(ctx, "default"))
+		require.NoError(t, resolve(ctx, "granted"))
+		require.ErrorIs(t, resolve(ctx, "other"), reference.ErrCrossNamespaceReferenceDenied)
+	})
+
+	t.Run("Allow List", func(t *testing.T) {
+		ctx := reference.WithNamespacePolicy(context.Background(), reference.AnyOf(
+			reference.ReferenceGrants{},
+			reference.AllowList{{FromNamespace: "default", ToNamespace: "other", To: &schema.GroupKind{Kind: "Secret"}}},
+		))
+
+		require.NoError(t, resolve(ctx, "granted"))
+		require.NoError(t, resolve(ctx, "other"))
+
+		ctx = reference.WithNamespacePolicy(context.Background(), reference.AllowList{{ToNamespace: "other", To: &schema.GroupKind{Kind: "ConfigMap"}}})
+		require.ErrorIs(t, resolve(ctx, "other"), reference.ErrCrossNamespaceReferenceDenied)
+	})
+}
+
+func TestEnqueueRequestsFromReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	scheme.AddKnownTypes(testGV, &Widget{}, &WidgetList{})
+
+	// References to objects default to config maps.
+	defaults := []reference.DefaultGVK{{
+		GVK:        corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+		FieldPaths: []string{"spec.objects"},
+	}}
+
+	widgets := []client.Object{
+		&Widget{
+			ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "default"},
+			Spec: WidgetSpec{
+				Secret: &reference.LocalSecretReference{Name: "demo"},
+			},
+		},
+		&Widget{
+			ObjectMeta: metav1.ObjectMeta{Name: "second", Namespace: "default"},
+			Spec: WidgetSpec{
+				Objects: []reference.ObjectReference{
+					{Name: "demo", Namespace: "other", APIVersion: "v1", Kind: "Secret"},
+					{Name: "demo", APIVersion: "v1", Kind: "ConfigMap"},
+				},
+			},
+		},
+		&Widget{
+			ObjectMeta: metav1.ObjectMeta{Name: "third", Namespace: "default"},
+			Spec: WidgetSpec{
+				Secret: &reference.LocalSecretReference{Name: "unrelated"},
+			},
+		},
+		&Widget{
+			ObjectMeta: metav1.ObjectMeta{Name: "fourth", Namespace: "default"},
+			Spec: WidgetSpec{
+				Objects: []reference.ObjectReference{
+					{Name: "defaulted"},
+				},
+			},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).
+		WithIndex(&Widget{}, reference.ReferenceIndexField, reference.IndexFunc(scheme, defaults...)).
+		WithObjects(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "defaulted", Namespace: "default"}}).
+		WithObjects(widgets...).Build()
+
+	h := reference.EnqueueRequestsFromReference(c, scheme, &WidgetList{})
+
+	enqueued := func(obj client.Object) []string {
+		q := &controllertest.Queue{Interface: workqueue.New()}
+		h.Create(context.Background(), event.CreateEvent{Object: obj}, q)
+
+		var names []string
+		for q.Len() > 0 {
+			item, _ := q.Get()
+			names = append(names, item.(reconcile.Request).Name)
+			q.Done(item)
+		}
+
+		return names
+	}
+
+	assert.ElementsMatch(t, []string{"first"}, enqueued(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+	}))
+
+	assert.ElementsMatch(t, []string{"second"}, enqueued(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "other"},
+	}))
+
+	assert.ElementsMatch(t, []string{"second"}, enqueued(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+	}))
+
+	assert.Empty(t, enqueued(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"},
+	}))
+
+	assert.ElementsMatch(t, []string{"fourth"}, enqueued(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "defaulted", Namespace: "default"},
+	}))
+
+	// Resolving sees the same defaults as indexing.
+	resolver := reference.NewDefaultingResolver(reference.NewReaderResolver(c, scheme), defaults...)
+
+	ok, err := resolver.ResolveAll(context.Background(), widgets[3])
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Without the defaults, the kind of the parent is assumed.
+	_, err = reference.ResolveAll(context.Background(), c, scheme, widgets[3])
+	assert.Error(t, err)
+}
+
+func TestNameTemplate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent-tls",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			"tls.crt": []byte("certificate"),
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Resolve", func(t *testing.T) {
+		ref := reference.LocalSecretReference{Name: "{{ .parent.Name }}-tls"}
+		assert.Empty(t, reference.ValidateLocalSecretReference(&ref, field.NewPath("spec", "secretRef")))
+
+		_, key, err := ref.Target(scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, client.ObjectKey{Name: "parent-tls", Namespace: "default"}, key)
+
+		obj, ok, err := ref.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "parent-tls", obj.(*corev1.Secret).Name)
+
+		keyRef := reference.SecretKeyReference{Name: "{{ .parent.GetName }}-tls", Key: "tls.crt"}
+
+		value, err := keyRef.ResolveValue(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("certificate"), value)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		ref := reference.LocalSecretReference{Name: "{{ .parent.Name"}
+		assert.Len(t, reference.ValidateLocalSecretReference(&ref, field.NewPath("spec", "secretRef")), 1)
+
+		_, _, err := ref.Resolve(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrInvalidNameTemplate)
+		assert.ErrorIs(t, err, reconcile.TerminalError(nil))
+
+		ref.Name = "{{ .owner.Name }}-tls"
+
+		_, _, err = ref.Resolve(ctx, c, scheme, parent)
+		require.ErrorIs(t, err, reference.ErrInvalidNameTemplate)
+	})
+}
+
+func TestKindPolicyResolver(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			"password": []byte("change-me"),
+		},
+	}, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Allow", func(t *testing.T) {
+		resolver := reference.NewKindPolicyResolver(reference.NewReaderResolver(c, scheme), scheme, reference.KindPolicy{
+			Allow: []schema.GroupKind{{Kind: "ConfigMap"}},
+		})
+
+		_, ok, err := resolver.Resolve(ctx, &reference.LocalConfigMapReference{Name: "demo"}, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		_, _, err = resolver.Resolve(ctx, &reference.LocalSecretReference{Name: "demo"}, parent)
+		require.ErrorIs(t, err, reference.ErrKindNotAllowed)
+		assert.ErrorIs(t, err, reconcile.TerminalError(nil))
+		assert.False(t, retryable.Is(err))
+
+		// The policy is also enforced when stacked with other resolvers.
+		stacked := reference.NewTracingResolver(resolver, scheme)
+		_, _, err = stacked.Resolve(ctx, &reference.SecretKeyReference{Name: "demo", Key: "password"}, parent)
+		require.ErrorIs(t, err, reference.ErrKindNotAllowed)
+	})
+
+	t.Run("Deny", func(t *testing.T) {
+		resolver := reference.NewKindPolicyResolver(reference.NewReaderResolver(c, scheme), scheme, reference.KindPolicy{
+			Allow: []schema.GroupKind{{Kind: "*"}},
+			Deny:  []schema.GroupKind{{Kind: "Secret"}},
+		})
+
+		obj := &objectWithSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "parent",
+				Namespace: "default",
+			},
+			Spec: objectSpec{
+				ConfigMaps: []reference.LocalConfigMapReference{
+					{Name: "demo"},
+				},
+			},
+		}
+
+		ok, err := resolver.ResolveAll(ctx, obj)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		obj.Spec.Secret = reference.LocalSecretReference{Name: "demo"}
+
+		_, err = resolver.ResolveAll(ctx, obj)
+		require.ErrorIs(t, err, reference.ErrKindNotAllowed)
+		assert.Contains(t, err.Error(), "failed to resolve spec.secret")
+
+		// Wildcards only match kinds in the same group.
+		_, _, err = resolver.Resolve(ctx, &reference.ObjectReference{Name: "demo", APIVersion: "apps/v1", Kind: "Deployment"}, parent)
+		require.ErrorIs(t, err, reference.ErrKindNotAllowed)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	fldPath := field.NewPath("spec", "ref")
+
+	t.Run("ObjectReference", func(t *testing.T) {
+		ref := &reference.ObjectReference{Name: "demo", Namespace: "default", APIVersion: "apps/v1", Kind: "Deployment", FieldPath: ".status.replicas"}
+		assert.Empty(t, reference.ValidateObjectReference(ref, fldPath))
+		assert.Empty(t, reference.ValidateObjectReference(ref, fldPath, schema.GroupKind{Group: "apps", Kind: "Deployment"}))
+
+		errs := reference.ValidateObjectReference(ref, fldPath, schema.GroupKind{Kind: "Secret"})
+		require.Len(t, errs, 1)
+		assert.Equal(t, field.ErrorTypeNotSupported, errs[0].Type)
+		assert.Equal(t, "spec.ref.kind", errs[0].Field)
+
+		errs = reference.ValidateObjectReference(&reference.ObjectReference{
+			Namespace:  "Not_Valid",
+			APIVersion: "a/b/c",
+			Kind:       "secret",
+			FieldPath:  ".status[0",
+		}, fldPath)
+
+		var fields []string
+		for _, err := range errs {
+			fields = append(fields, err.Field)
+		}
+
+		assert.ElementsMatch(t, []string{"spec.ref.name", "spec.ref.namespace", "spec.ref.apiVersion", "spec.ref.kind", "spec.ref.fieldPath"}, fields)
+	})
+
+	t.Run("LocalObjectReference", func(t *testing.T) {
+		ref := &reference.LocalObjectReference{Name: "demo", APIVersion: "v1", Kind: "Secret"}
+		assert.Empty(t, reference.ValidateLocalObjectReference(ref, fldPath, schema.GroupKind{Kind: "Secret"}))
+
+		// Without an API version, the group of the kind isn't known.
+		errs := reference.ValidateLocalObjectReference(&reference.LocalObjectReference{Name: "demo", Kind: "Secret"}, fldPath, schema.GroupKind{Kind: "Secret"})
+		require.Len(t, errs, 1)
+		assert.Equal(t, field.ErrorTypeRequired, errs[0].Type)
+		assert.Equal(t, "spec.ref.apiVersion", errs[0].Field)
+
+		errs = reference.ValidateLocalObjectReference(&reference.LocalObjectReference{Name: "demo"}, fldPath)
+		require.Len(t, errs, 1)
+		assert.Equal(t, field.ErrorTypeRequired, errs[0].Type)
+	})
+
+	t.Run("Default Kind", func(t *testing.T) {
+		defaults := []reference.DefaultGVK{{
+			GVK:        corev1.SchemeGroupVersion.WithKind("Secret"),
+			FieldPaths: []string{"spec.ref"},
+		}}
+
+		ref := &reference.ObjectReference{Name: "demo"}
+		assert.Empty(t, reference.ValidateObjectReferenceWithDefaults(ref, fldPath, defaults, schema.GroupKind{Kind: "Secret"}))
+
+		// Only references at the given path are defaulted.
+		errs := reference.ValidateObjectReferenceWithDefaults(ref, field.NewPath("spec", "other"), defaults)
+		require.Len(t, errs, 1)
+		assert.Equal(t, field.ErrorTypeRequired, errs[0].Type)
+	})
+
+	t.Run("Keyed References", func(t *testing.T) {
+		assert.Empty(t, reference.ValidateSecretKeyReference(&reference.SecretKeyReference{Name: "demo", Key: "tls.crt"}, fldPath))
+		assert.Len(t, reference.ValidateConfigMapKeyReference(&reference.ConfigMapKeyReference{Name: "demo", Key: "not/valid"}, fldPath), 1)
+		assert.Len(t, reference.ValidateLocalKeyedSecretReference(&reference.LocalKeyedSecretReference{}, fldPath), 2)
+	})
+
+	t.Run("ServiceReference", func(t *testing.T) {
+		assert.Empty(t, reference.ValidateServiceReference(&reference.ServiceReference{Name: "demo", Port: intstr.FromString("https")}, fldPath))
+
+		errs := reference.ValidateServiceReference(&reference.ServiceReference{Name: "demo", Port: intstr.FromInt32(70000), Scheme: "ftp"}, fldPath)
+		assert.Len(t, errs, 2)
+	})
+}
+
+func TestOptionalReferences(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Key References", func(t *testing.T) {
+		secretRef := reference.SecretKeyReference{Name: "missing", Key: "password", Optional: true}
+
+		value, err := secretRef.ResolveValue(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Nil(t, value)
+
+		secretRef.Name = "demo"
+
+		value, err = secretRef.ResolveValue(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Nil(t, value)
+
+		configMapRef := reference.ConfigMapKeyReference{Name: "missing", Key: "endpoint", Optional: true}
+
+		s, err := configMapRef.ResolveValue(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		assert.Empty(t, s)
+	})
+
+	t.Run("ResolveOptional", func(t *testing.T) {
+		obj, err := reference.ResolveOptional(ctx, c, scheme, parent, &reference.LocalSecretReference{Name: "missing"})
+		require.NoError(t, err)
+		assert.Nil(t, obj)
+
+		obj, err = reference.ResolveOptional(ctx, c, scheme, parent, &reference.LocalSecretReference{Name: "demo"})
+		require.NoError(t, err)
+		assert.NotNil(t, obj)
+	})
+
+	t.Run("ResolveAll", func(t *testing.T) {
+		obj := &objectWithSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "parent",
+				Namespace: "default",
+			},
+			Spec: objectSpec{
+				Secret: reference.LocalSecretReference{Name: "missing", Optional: true},
+			},
+		}
+
+		ok, err := reference.ResolveAll(ctx, c, scheme, obj)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		obj.Spec.Secret.Optional = false
+
+		ok, err = reference.ResolveAll(ctx, c, scheme, obj)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestClusterObjectReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	newSecret := func(name string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+		}
+	}
+
+	local := fake.NewClientBuilder().WithScheme(scheme).WithObjects(newSecret("local")).Build()
+	remote := fake.NewClientBuilder().WithScheme(scheme).WithObjects(newSecret("remote")).Build()
+
+	resolver := reference.NewClusterResolver(local, reference.ClusterReaders{"member": remote}, scheme)
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Local", func(t *testing.T) {
+		ref := &reference.ClusterObjectReference{Name: "local", APIVersion: "v1", Kind: "Secret"}
+
+		obj, ok, err := resolver.Resolve(ctx, ref, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "local", obj.(*corev1.Secret).Name)
+	})
+
+	t.Run("Remote", func(t *testing.T) {
+		ref := &reference.ClusterObjectReference{Cluster: "member", Name: "remote", APIVersion: "v1", Kind: "Secret"}
+
+		obj, ok, err := resolver.Resolve(ctx, ref, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "remote", obj.(*corev1.Secret).Name)
+
+		ref.Name = "local"
+
+		_, ok, err = resolver.Resolve(ctx, ref, parent)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Unknown Cluster", func(t *testing.T) {
+		ref := &reference.ClusterObjectReference{Cluster: "unknown", Name: "remote", APIVersion: "v1", Kind: "Secret"}
+
+		_, _, err := resolver.Resolve(ctx, ref, parent)
+		require.ErrorIs(t, err, reference.ErrClusterNotFound)
+		assert.True(t, retryable.Is(err))
+	})
+
+	t.Run("Constraints", func(t *testing.T) {
+		ref := &reference.ClusterObjectReference{Cluster: "member", Name: "remote", APIVersion: "v1", Kind: "Secret", MinGeneration: 5}
+
+		_, _, err := resolver.Resolve(ctx, ref, parent)
+		require.Error(t, err)
+		assert.True(t, retryable.Is(err))
+	})
+
+	t.Run("Target", func(t *testing.T) {
+		ref := &reference.ClusterObjectReference{Cluster: "member", Name: "remote", APIVersion: "v1", Kind: "Secret"}
+
+		gvk, key, err := ref.Target(scheme, parent)
+		require.NoError(t, err)
+		assert.Equal(t, "Secret", gvk.Kind)
+		assert.Equal(t, client.ObjectKey{Namespace: "default", Name: "remote"}, key)
+
+		// References to other clusters aren't indexed.
+		obj := &objectWithClusterRef{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+		obj.Spec.Ref = ref
+		assert.Empty(t, reference.IndexFunc(scheme)(obj))
+
+		obj.Spec.Ref = &reference.ClusterObjectReference{Name: "local", APIVersion: "v1", Kind: "Secret"}
+		assert.Equal(t, []string{"Secret/default/local"}, reference.IndexFunc(scheme)(obj))
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		kubeconfig := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "member-kubeconfig",
+				Namespace: "default",
+			},
+			Data: map[string][]byte{
+				clusters.DefaultKubeconfigKey: []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: member
+  cluster:
+    server: https://member.example.com:6443
+contexts:
+- name: member
+  context:
+    cluster: member
+current-context: member
+`),
+			},
+		}
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(kubeconfig).Build()
+		registry := clusters.NewRegistry(c, clusters.Options{
+			NewClient: func(config *rest.Config, opts client.Options) (client.Client, error) {
+				return remote, nil
+			},
+			HealthCheck: func(ctx context.Context, cluster clusters.Cluster) error {
+				return nil
+			},
+		})
+
+		resolver := reference.NewClusterResolverForClusters(local, registry, scheme)
+
+		ref := &reference.ClusterObjectReference{Cluster: "member", Name: "remote", APIVersion: "v1", Kind: "Secret"}
+
+		// The cluster hasn't been registered yet.
+		_, _, err := resolver.Resolve(ctx, ref, parent)
+		require.ErrorIs(t, err, reference.ErrClusterNotFound)
+		assert.True(t, retryable.Is(err))
+
+		_, err = registry.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(kubeconfig)})
+		require.NoError(t, err)
+
+		obj, ok, err := resolver.Resolve(ctx, ref, parent)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "remote", obj.(*corev1.Secret).Name)
+	})
+}
+
+func TestNewObjectReference(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	scheme.AddKnownTypes(testGV, &MyObject{})
+
+	ref, err := reference.NewObjectReference(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}, scheme)
+	require.NoError(t, err)
+	assert.Equal(t, &reference.ObjectReference{Name: "demo", Namespace: "default", APIVersion: "v1", Kind: "Secret"}, ref)
+
+	localRef, err := reference.NewLocalObjectReference(&MyObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "first",
+			Namespace: "default",
+		},
+	}, scheme)
+	require.NoError(t, err)
+	assert.Equal(t, &reference.LocalObjectReference{Name: "first", APIVersion: "example.com/v1", Kind: "MyObject"}, localRef)
+
+	_, err = reference.NewObjectReference(&corev1.Secret{}, runtime.NewScheme())
+	require.Error(t, err)
+}
+
+func TestResolveOwners(t *testing.T) {
+	clientScheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(clientScheme))
+	clientScheme.AddKnownTypes(testGV, &MyObject{})
+
+	owner := &MyObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "default",
+			UID:       "owner-uid",
+		},
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret",
+			Namespace: "default",
+			UID:       "secret-uid",
+		},
+	}
+
+	reader := fake.NewClientBuilder().WithScheme(clientScheme).WithObjects(owner, secret).Build()
+
+	// Intentionally don't register the secret type.
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(testGV, &MyObject{})
+
+	obj := &MyObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "child",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "example.com/v1", Kind: "MyObject", Name: "owner", UID: "owner-uid", Controller: ptr.To(true)},
+				{APIVersion: "v1", Kind: "Secret", Name: "secret", UID: "secret-uid"},
+				{APIVersion: "v1", Kind: "Secret", Name: "missing", UID: "missing-uid"},
+				{APIVersion: "v1", Kind: "Secret", Name: "secret", UID: "recreated-uid"},
+			},
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("All", func(t *testing.T) {
+		owners, err := reference.ResolveOwners(ctx, reader, scheme, obj)
+		require.NoError(t, err)
+		require.Len(t, owners, 2)
+
+		assert.IsType(t, &MyObject{}, owners[0])
+		assert.IsType(t, &unstructured.Unstructured{}, owners[1])
+	})
+
+	t.Run("Kind", func(t *testing.T) {
+		owners, err := reference.ResolveOwners(ctx, reader, scheme, obj, reference.OwnerOfKind(schema.GroupKind{Kind: "Secret"}))
+		require.NoError(t, err)
+		require.Len(t, owners, 1)
+		assert.Equal(t, "secret", owners[0].(*unstructured.Unstructured).GetName())
+	})
+
+	t.Run("Controller", func(t *testing.T) {
+		controller, ok, err := reference.ResolveController(ctx, reader, scheme, obj)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "owner", controller.(*MyObject).Name)
+
+		_, ok, err = reference.ResolveController(ctx, reader, scheme, secret)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestResolveList(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	var objs []client.Object
+	for _, name := range []string{"first", "second", "third"} {
+		objs = append(objs, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+		})
+	}
+
+	errBroken := errors.New("broken")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if key.Name == "broken" {
+				return errBroken
+			}
+
+			return c.Get(ctx, key, obj, opts...)
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	t.Run("Resolved", func(t *testing.T) {
+		refs := reference.ObjectReferenceList{
+			{Name: "first", APIVersion: "v1", Kind: "Secret"},
+			{Name: "second", APIVersion: "v1", Kind: "Secret"},
+			{Name: "third", APIVersion: "v1", Kind: "Secret"},
+			{Name: "missing", APIVersion: "v1", Kind: "Secret", Optional: true},
+		}
+
+		resolved, err := refs.Resolve(ctx, c, scheme, parent)
+		require.NoError(t, err)
+		require.Len(t, resolved, 4)
+
+		for i, name := range []string{"first", "second", "third"} {
+			assert.Equal(t, name, resolved[i].(*corev1.Secret).Name)
+		}
+		assert.Nil(t, resolved[3])
+	})
+
+	t.Run("Aggregated", func(t *testing.T) {
+		refs := []reference.Reference{
+			&reference.LocalSecretReference{Name: "first"},
+			&reference.LocalSecretReference{Name: "missing"},
+			&reference.LocalSecretReference{Name: "broken"},
+		}
+
+		resolved, err := reference.ResolveList(ctx, c, scheme, parent, refs)
+		require.ErrorIs(t, err, reference.ErrReferenceNotFound)
+		require.ErrorIs(t, err, errBroken)
+		assert.True(t, retryable.Is(err))
+
+		assert.NotNil(t, resolved[0])
+		assert.Nil(t, resolved[1])
+		assert.Nil(t, resolved[2])
+
+		_, err = reference.ResolveList(ctx, c, scheme, parent, refs[2:])
+		require.ErrorIs(t, err, errBroken)
+		assert.False(t, retryable.Is(err))
+	})
+}
+
+func TestConversion(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, appsv1.AddToScheme(scheme))
+
+	ref := &reference.ObjectReference{
+		Name:       "demo",
+		Namespace:  "default",
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		UID:        "demo-uid",
+	}
+
+	t.Run("Core ObjectReference", func(t *testing.T) {
+		coreRef := ref.ToCoreObjectReference()
+		assert.Equal(t, &corev1.ObjectReference{Name: "demo", Namespace: "default", APIVersion: "apps/v1", Kind: "Deployment", UID: "demo-uid"}, coreRef)
+		assert.Equal(t, ref, reference.FromCoreObjectReference(coreRef))
+	})
+
+	t.Run("OwnerReference", func(t *testing.T) {
+		owner, err := ref.ToOwnerReference()
+		require.NoError(t, err)
+		assert.Equal(t, &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "demo", UID: "demo-uid"}, owner)
+		assert.Equal(t, ref, reference.FromOwnerReference(owner, "default"))
+
+		_, err = (&reference.ObjectReference{Name: "demo", Kind: "Deployment"}).ToOwnerReference()
+		require.ErrorIs(t, err, reference.ErrIncompleteReference)
+	})
+
+	t.Run("TypedLocalObjectReference", func(t *testing.T) {
+		typedRef, err := ref.ToTypedLocalObjectReference()
+		require.NoError(t, err)
+		assert.Equal(t, &corev1.TypedLocalObjectReference{APIGroup: ptr.To("apps"), Kind: "Deployment", Name: "demo"}, typedRef)
+
+		converted, err := reference.FromTypedLocalObjectReference(typedRef, "default", scheme)
+		require.NoError(t, err)
+		assert.Equal(t, &reference.ObjectReference{Name: "demo", Namespace: "default", APIVersion: "apps/v1", Kind: "Deployment"}, converted)
+
+		converted, err = reference.FromTypedLocalObjectReference(&corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "data"}, "default", scheme)
+		require.NoError(t, err)
+		assert.Equal(t, "v1", converted.APIVersion)
+
+		_, err = reference.FromTypedLocalObjectReference(&corev1.TypedLocalObjectReference{APIGroup: ptr.To("example.com"), Kind: "Unknown", Name: "demo"}, "default", scheme)
+		require.Error(t, err)
+	})
+}
+
+func TestInstrumentedResolver(t *testing.T) {
+	clientScheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(clientScheme))
+
+	c := fake.NewClientBuilder().WithScheme(clientScheme).WithObjects(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}).Build()
+
+	// Intentionally don't register the service account type.
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(testGV, &MyObject{})
+
+	resolver := reference.NewInstrumentedResolver(reference.NewReaderResolver(c, scheme), scheme)
+
+	parent := &MyObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	resolved := metrics.ReferenceResolutionsTotal.WithLabelValues("ServiceAccount", metrics.ResolutionResolved)
+	notFound := metrics.ReferenceResolutionsTotal.WithLabelValues("ServiceAccount", metrics.ResolutionNotFound)
+	fallbacks := metrics.ReferenceDecodeFallbacksTotal.WithLabelValues("ServiceAccount")
+
+	_, ok, err := resolver.Resolve(ctx, &reference.ObjectReference{Name: "demo", APIVersion: "v1", Kind: "ServiceAccount"}, parent)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = resolver.Resolve(ctx, &reference.ObjectReference{Name: "missing", APIVersion: "v1", Kind: "ServiceAccount"}, parent)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(resolved))
+	assert.Equal(t, float64(1), testutil.ToFloat64(notFound))
+	assert.Equal(t, float64(1), testutil.ToFloat64(fallbacks))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ReferenceResolutionFailuresTotal.WithLabelValues("ServiceAccount", metrics.ErrorClassNotFound)))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.ReferenceResolutionDurationSeconds.WithLabelValues("ServiceAccount").(prometheus.Histogram)))
+
+	// Kinds defaulted from the context are attributed.
+	_, ok, err = resolver.Resolve(reference.WithDefaultGVK(ctx, corev1.SchemeGroupVersion.WithKind("ServiceAccount")), &reference.ObjectReference{Name: "other"}, parent)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(notFound))
+}
+
+func TestResolverAPIReaderFallback(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	// The cache hasn't yet observed the secret.
+	cached := fake.NewClientBuilder().WithScheme(scheme).Build()
+	apiReader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "demo",
+			Namespace: "default",
+		},
+	}).Build()
+
+	parent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "parent",
+			Namespace: "default",
+		},
+	}
+
+	ctx := context.Background()
+
+	resolver := reference.NewResolver(cached, scheme, reference.ResolverOptions{})
+
+	_, ok, err := resolver.Resolve(ctx, &reference.LocalSecretReference{Name: "demo"}, parent)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	resolver = reference.NewResolver(cached, scheme, reference.ResolverOptions{APIReader: apiReader})
+
+	_, ok, err = resolver.Resolve(ctx, &reference.LocalSecretReference{Name: "demo"}, parent)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = resolver.Resolve(ctx, &reference.LocalSecretReference{Name: "missing"}, parent)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+type fakeInformers struct {
+	informer *controllertest.FakeInformer
+	err      error
+	calls    atomic.Int32
+}
+
+func (f *fakeInformers) GetInformer(_ context.Context, _ client.Object, _ ...cache.InformerGetOption) (cache.Informer, error) {
+	f.calls.Add(1)
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return f.informer, nil
+}
+
+type objectWithSpec struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              objectSpec `json:"spec"`
+}
+
+func (in *objectWithSpec) DeepCopyObject() runtime.Object {
+	panic("not implemented")
+}
+
+type objectSpec struct {
+	Secret     reference.LocalSecretReference        `json:"secret"`
+	Optional   *reference.LocalKeyedSecretReference  `json:"optional,omitempty"`
+	ConfigMaps []reference.LocalConfigMapReference   `json:"configMaps,omitempty"`
+	Objects    map[string]*reference.ObjectReference `json:"objects,omitempty"`
+	Nested     struct {
+		ConfigMap *reference.LocalConfigMapReference `json:"configMap,omitempty"`
+	} `json:"nested"`
+}
+
+type objectWithClusterRef struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Ref *reference.ClusterObjectReference `json:"ref,omitempty"`
+	} `json:"spec"`
+}
+
+func (in *objectWithClusterRef) DeepCopyObject() runtime.Object {
+	panic("not implemented")
+}
+
+type fakeSecretProvider struct {
+	secrets map[client.ObjectKey]*reference.ExternalSecret
+	err     error
+	calls   int
+}
+
+func (p *fakeSecretProvider) GetSecret(_ context.Context, key client.ObjectKey) (*reference.ExternalSecret, error) {
+	p.calls++
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	secret, ok := p.secrets[key]
+	if !ok {
+		return nil, reference.ErrExternalSecretNotFound
+	}
+
+	return secret, nil
+}
+
 var testGV = schema.GroupVersion{
 	Group:   "example.com",
 	Version: "v1",
 }
 
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              WidgetSpec `json:"spec"`
+}
+
+type WidgetSpec struct {
+	Secret  *reference.LocalSecretReference `json:"secret,omitempty"`
+	Objects []reference.ObjectReference     `json:"objects,omitempty"`
+}
+
+func (in *Widget) DeepCopyObject() runtime.Object {
+	out := &Widget{
+		TypeMeta: in.TypeMeta,
+		Spec: WidgetSpec{
+			Secret:  in.Spec.Secret.DeepCopy(),
+			Objects: append([]reference.ObjectReference(nil), in.Spec.Objects...),
+		},
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+
+	return out
+}
+
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []Widget `json:"items"`
+}
+
+func (in *WidgetList) DeepCopyObject() runtime.Object {
+	out := &WidgetList{TypeMeta: in.TypeMeta}
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	for i := range in.Items {
+		out.Items = append(out.Items, *in.Items[i].DeepCopyObject().(*Widget))
+	}
+
+	return out
+}
+
 type MyObject struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
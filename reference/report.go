@@ -0,0 +1,181 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConditionTypeMissingDependencies is the condition set on objects with
+// unresolved references.
+const ConditionTypeMissingDependencies = "MissingDependencies"
+
+// Condition reasons.
+const (
+	ReasonAllReferencesResolved = "AllReferencesResolved"
+	ReasonReferencesNotFound    = "ReferencesNotFound"
+	ReasonReferenceResolveError = "ReferenceResolveError"
+)
+
+// UnresolvedReason is why a reference couldn't be resolved.
+type UnresolvedReason string
+
+const (
+	// UnresolvedReasonNotFound means the referenced object doesn't exist.
+	UnresolvedReasonNotFound UnresolvedReason = "NotFound"
+	// UnresolvedReasonError means resolving the reference failed.
+	UnresolvedReasonError UnresolvedReason = "Error"
+)
+
+// UnresolvedReference describes a reference that couldn't be resolved.
+type UnresolvedReference struct {
+	// Path is the JSON path of the reference (eg. spec.secretRef).
+	Path string
+	// GroupVersionKind is the kind of the referenced object (empty if the
+	// reference doesn't implement TargetedReference).
+	GroupVersionKind schema.GroupVersionKind
+	// Key is the key of the referenced object (empty if the reference
+	// doesn't implement TargetedReference).
+	Key client.ObjectKey
+	// Reason is why the reference couldn't be resolved.
+	Reason UnresolvedReason
+	// Err is the error returned when resolving the reference (nil if the
+	// referenced object was not found).
+	Err error
+}
+
+func (u UnresolvedReference) String() string {
+	var target string
+	if u.GroupVersionKind.Kind != "" {
+		target = " (" + u.GroupVersionKind.Kind + " " + u.Key.String() + ")"
+	}
+
+	if u.Err != nil {
+		return fmt.Sprintf("%s%s: %v", u.Path, target, u.Err)
+	}
+
+	return fmt.Sprintf("%s%s: not found", u.Path, target)
+}
+
+// Report is the outcome of resolving every reference of an object.
+type Report struct {
+	// Unresolved are the references that couldn't be resolved, in field order.
+	Unresolved []UnresolvedReference
+}
+
+// Resolved returns true if every (non optional) reference was resolved.
+func (r *Report) Resolved() bool {
+	return len(r.Unresolved) == 0
+}
+
+// Err returns the errors of the references that failed to resolve (as
+// opposed to those that were not found), or nil if there were none.
+func (r *Report) Err() error {
+	var errs []error
+	for _, u := range r.Unresolved {
+		if u.Err != nil {
+			errs = append(errs, fmt.Errorf("failed to resolve %s: %w", u.Path, u.Err))
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// Condition returns the MissingDependencies condition for the object.
+func (r *Report) Condition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionTypeMissingDependencies,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonAllReferencesResolved,
+		Message:            "All references resolved",
+	}
+
+	if r.Resolved() {
+		return condition
+	}
+
+	condition.Status = metav1.ConditionTrue
+	condition.Reason = ReasonReferencesNotFound
+	if r.Err() != nil {
+		condition.Reason = ReasonReferenceResolveError
+	}
+
+	var details []string
+	for _, u := range r.Unresolved {
+		details = append(details, u.String())
+	}
+
+	condition.Message = fmt.Sprintf("%d unresolved references: %s",
+		len(r.Unresolved), strings.Join(details, "; "))
+
+	return condition
+}
+
+// SetCondition sets the MissingDependencies condition on the given
+// conditions slice (typically the object's status.conditions).
+func (r *Report) SetCondition(conditions *[]metav1.Condition, generation int64) {
+	meta.SetStatusCondition(conditions, r.Condition(generation))
+}
+
+// ResolveAllWithReport is like ResolveAll, but rather than stopping at the
+// first reference that can't be resolved, it attempts every reference and
+// returns a report of those that couldn't be (eg. so that controllers can
+// publish every missing dependency in one pass). An error is only returned
+// if the object couldn't be walked.
+func ResolveAllWithReport(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, obj runtime.Object) (*Report, error) {
+	var report Report
+	_, err := walkReferences(obj, func(ref Reference, path string) (bool, error) {
+		_, ok, err := ref.Resolve(withFieldPath(ctx, path), reader, scheme, obj)
+		if err == nil && (ok || IsOptional(ref)) {
+			return true, nil
+		}
+
+		unresolved := UnresolvedReference{
+			Path:   path,
+			Reason: UnresolvedReasonNotFound,
+			Err:    err,
+		}
+		if err != nil {
+			unresolved.Reason = UnresolvedReasonError
+		}
+
+		if targeted, ok := ref.(TargetedReference); ok {
+			if gvk, key, err := targetWithDefaults(withFieldPath(ctx, path), targeted, scheme, obj); err == nil {
+				unresolved.GroupVersionKind, unresolved.Key = gvk, key
+			}
+		}
+
+		report.Unresolved = append(report.Unresolved, unresolved)
+
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
@@ -0,0 +1,225 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var referenceType = reflect.TypeOf((*Reference)(nil)).Elem()
+
+// ResolveAll resolves every reference embedded in the object's spec (or the
+// whole object, if it has no spec), found by walking its fields. It can be
+// used to implement ObjectWithReferences without hand written resolution.
+// Unset (zero valued) references are skipped. It returns false (and no
+// error) if any referenced object (that isn't optional) is not found. See
+// ResolveAllWithReport to collect every unresolved reference instead.
+func ResolveAll(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, obj runtime.Object) (bool, error) {
+	return ResolveAllWith(ctx, NewReaderResolver(reader, scheme), obj)
+}
+
+// ResolveAllWith resolves every reference embedded in the object using the
+// resolver (see ResolveAll).
+func ResolveAllWith(ctx context.Context, resolver ReferenceResolver, obj runtime.Object) (bool, error) {
+	return walkReferences(obj, func(ref Reference, path string) (bool, error) {
+		_, ok, err := resolver.Resolve(withFieldPath(ctx, path), ref, obj)
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+
+		return ok || IsOptional(ref), nil
+	})
+}
+
+// ReferenceResolver resolves references. Resolvers adding behaviour (eg.
+// KindPolicyResolver, TracingResolver, and InstrumentedResolver) wrap another
+// ReferenceResolver, so that they can be stacked.
+type ReferenceResolver interface {
+	// Resolve resolves the reference to its underlying resource.
+	Resolve(ctx context.Context, ref Reference, parent runtime.Object) (runtime.Object, bool, error)
+}
+
+// ReaderResolver resolves references using a reader.
+type ReaderResolver struct {
+	reader client.Reader
+	scheme *runtime.Scheme
+}
+
+// NewReaderResolver returns a new reference resolver using the reader.
+func NewReaderResolver(reader client.Reader, scheme *runtime.Scheme) *ReaderResolver {
+	return &ReaderResolver{
+		reader: reader,
+		scheme: scheme,
+	}
+}
+
+// Resolve resolves the reference to its underlying resource.
+func (r *ReaderResolver) Resolve(ctx context.Context, ref Reference, parent runtime.Object) (runtime.Object, bool, error) {
+	return ref.Resolve(ctx, r.reader, r.scheme, parent)
+}
+
+// walkReferences calls visit for every reference embedded in the object's
+// spec (or the whole object, if it has no spec), stopping if visit returns
+// false or an error.
+func walkReferences(obj runtime.Object, visit func(ref Reference, path string) (bool, error)) (bool, error) {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return false, fmt.Errorf("expected a non-nil pointer, got %T", obj)
+	}
+
+	path := ""
+	if spec := v.Elem().FieldByName("Spec"); v.Elem().Kind() == reflect.Struct && spec.IsValid() {
+		v, path = spec, "spec"
+	}
+
+	w := walker{
+		visit:   visit,
+		visited: make(map[uintptr]bool),
+	}
+
+	return w.walk(v, path)
+}
+
+type walker struct {
+	visit   func(ref Reference, path string) (bool, error)
+	visited map[uintptr]bool
+}
+
+func (w *walker) walk(v reflect.Value, path string) (bool, error) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return true, nil
+		}
+
+		if v.Kind() == reflect.Pointer {
+			if w.visited[v.Pointer()] {
+				return true, nil
+			}
+			w.visited[v.Pointer()] = true
+		}
+
+		// Structs are checked for references when walked (so that unset
+		// embedded references are handled).
+		if v.Elem().Kind() != reflect.Struct && v.Type().Implements(referenceType) && v.CanInterface() {
+			return w.visit(v.Interface().(Reference), path)
+		}
+
+		return w.walk(v.Elem(), path)
+	case reflect.Struct:
+		if isReference(v) {
+			// Unset references (eg. optional fields) are skipped.
+			if v.IsZero() || hasNilEmbeddedReference(v) {
+				return true, nil
+			}
+
+			if v.CanAddr() {
+				return w.visit(v.Addr().Interface().(Reference), path)
+			}
+
+			return w.visit(v.Interface().(Reference), path)
+		}
+
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			ok, err := w.walk(v.Field(i), joinPath(path, fieldName(field)))
+			if !ok || err != nil {
+				return ok, err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			ok, err := w.walk(v.Index(i), path+"["+strconv.Itoa(i)+"]")
+			if !ok || err != nil {
+				return ok, err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// Map values aren't addressable, so resolve a copy.
+			value := reflect.New(iter.Value().Type()).Elem()
+			value.Set(iter.Value())
+
+			ok, err := w.walk(value, fmt.Sprintf("%s[%v]", path, iter.Key()))
+			if !ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func isReference(v reflect.Value) bool {
+	if v.Type().Implements(referenceType) {
+		return v.CanInterface()
+	}
+
+	return v.CanAddr() && v.Addr().Type().Implements(referenceType)
+}
+
+// hasNilEmbeddedReference returns true if the struct embeds a nil reference
+// (which its Resolve method is likely promoted from).
+func hasNilEmbeddedReference(v reflect.Value) bool {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Pointer &&
+			field.Type.Implements(referenceType) && v.Field(i).IsNil() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fieldName returns the JSON name of a field (embedded fields are inlined).
+func fieldName(field reflect.StructField) string {
+	if field.Anonymous {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+
+	return name
+}
+
+func joinPath(path, name string) string {
+	switch {
+	case name == "":
+		return path
+	case path == "":
+		return name
+	default:
+		return path + "." + name
+	}
+}
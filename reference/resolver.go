@@ -0,0 +1,333 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const (
+	// DefaultResolverTTL is how long resolved objects are cached for.
+	DefaultResolverTTL = 10 * time.Minute
+)
+
+// InformerGetter gets informers for objects (eg. a manager's cache).
+type InformerGetter interface {
+	GetInformer(ctx context.Context, obj client.Object, opts ...cache.InformerGetOption) (cache.Informer, error)
+}
+
+// ResolverOptions configures a Resolver.
+type ResolverOptions struct {
+	// APIReader, if set, is an uncached reader (eg. mgr.GetAPIReader()) that
+	// objects not found by the reader are read from, to avoid false
+	// negatives when a cache hasn't yet observed a newly created object.
+	APIReader client.Reader
+	// Informers, if set, are used to invalidate cached objects when they
+	// are added, updated, or deleted. Otherwise objects are only expired,
+	// and misses aren't cached. Kinds can be watched up front with Watch,
+	// otherwise they are watched in the background once the resolver has
+	// been started (eg. by adding it to a manager).
+	Informers InformerGetter
+	// TTL is how long resolved objects are cached for.
+	TTL time.Duration
+	// Clock is used to expire cached objects (useful for testing).
+	Clock clock.PassiveClock
+}
+
+// Resolver resolves references, caching the resolved objects by kind,
+// namespace, and name, so that hot reconcile loops don't re-read the same
+// secrets and config maps on every pass. Misses are only cached for kinds
+// that are watched (so that they are invalidated once the object is created).
+type Resolver struct {
+	reader client.Reader
+	scheme *runtime.Scheme
+	opts   ResolverOptions
+
+	mu      sync.Mutex
+	entries map[resolverKey]resolverEntry
+	// epoch is incremented on every invalidation, so that reads racing
+	// with an invalidation aren't cached.
+	epoch uint64
+
+	watchMu sync.Mutex
+	// watchCtx is the context watches are started with, set once started.
+	watchCtx context.Context
+	watched  map[schema.GroupVersionKind]bool
+	// pending are the kinds waiting to be (or being) watched.
+	pending map[schema.GroupVersionKind]client.Object
+}
+
+type resolverKey struct {
+	gvk          schema.GroupVersionKind
+	unstructured bool
+	key          client.ObjectKey
+}
+
+type resolverEntry struct {
+	obj       client.Object
+	expiresAt time.Time
+}
+
+// NewResolver returns a new caching reference resolver.
+func NewResolver(reader client.Reader, scheme *runtime.Scheme, opts ResolverOptions) *Resolver {
+	if opts.TTL == 0 {
+		opts.TTL = DefaultResolverTTL
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &Resolver{
+		reader:  reader,
+		scheme:  scheme,
+		opts:    opts,
+		entries: make(map[resolverKey]resolverEntry),
+		watched: make(map[schema.GroupVersionKind]bool),
+		pending: make(map[schema.GroupVersionKind]client.Object),
+	}
+}
+
+// Start implements manager.Runnable, starting watches for the kinds that
+// have been resolved (and those resolved later) in the background.
+func (r *Resolver) Start(ctx context.Context) error {
+	r.watchMu.Lock()
+	r.watchCtx = ctx
+	for gvk, obj := range r.pending {
+		go r.startWatch(ctx, gvk, obj)
+	}
+	r.watchMu.Unlock()
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the cache
+// is used by every replica.
+func (r *Resolver) NeedLeaderElection() bool {
+	return false
+}
+
+// Watch registers event handlers to invalidate cached objects of the given
+// kinds (eg. during manager setup), rather than waiting for them to be
+// watched in the background.
+func (r *Resolver) Watch(ctx context.Context, objs ...client.Object) error {
+	if r.opts.Informers == nil {
+		return fmt.Errorf("resolver has no informers")
+	}
+
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, r.scheme)
+		if err != nil {
+			return fmt.Errorf("failed to get object kind: %w", err)
+		}
+
+		if err := r.addEventHandler(ctx, gvk, obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resolve resolves the reference, using cached objects where possible.
+func (r *Resolver) Resolve(ctx context.Context, ref Reference, parent runtime.Object) (runtime.Object, bool, error) {
+	return ref.Resolve(ctx, r, r.scheme, parent)
+}
+
+// Get implements client.Reader, so the resolver can be passed to Resolve
+// methods (and ObjectWithReferences) directly.
+func (r *Resolver) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	gvk, err := apiutil.GVKForObject(obj, r.scheme)
+	if err != nil {
+		return fmt.Errorf("failed to get object kind: %w", err)
+	}
+
+	_, isUnstructured := obj.(*unstructured.Unstructured)
+	k := resolverKey{gvk: gvk, unstructured: isUnstructured, key: key}
+
+	r.mu.Lock()
+	entry, ok := r.entries[k]
+	if ok && r.opts.Clock.Now().After(entry.expiresAt) {
+		delete(r.entries, k)
+		ok = false
+	}
+	epoch := r.epoch
+	r.mu.Unlock()
+
+	if ok {
+		if entry.obj == nil {
+			return apierrors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind}, key.Name)
+		}
+
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(entry.obj.DeepCopyObject()).Elem())
+		return nil
+	}
+
+	watched := r.opts.Informers != nil && r.watch(gvk, obj)
+
+	err = r.reader.Get(ctx, key, obj, opts...)
+	if apierrors.IsNotFound(err) && r.opts.APIReader != nil {
+		err = r.opts.APIReader.Get(ctx, key, obj, opts...)
+	}
+	// Misses are only cached if they will be invalidated (otherwise a newly
+	// created object would be unresolvable until the miss expires).
+	if err != nil && (!apierrors.IsNotFound(err) || !watched) {
+		return err
+	}
+
+	entry = resolverEntry{expiresAt: r.opts.Clock.Now().Add(r.opts.TTL)}
+	if err == nil {
+		entry.obj = obj.DeepCopyObject().(client.Object)
+	}
+
+	r.mu.Lock()
+	if r.epoch == epoch {
+		r.entries[k] = entry
+	}
+	r.mu.Unlock()
+
+	return err
+}
+
+// List implements client.Reader, lists are not cached.
+func (r *Resolver) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return r.reader.List(ctx, list, opts...)
+}
+
+// Invalidate clears all cached objects.
+func (r *Resolver) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = make(map[resolverKey]resolverEntry)
+	r.epoch++
+}
+
+func (r *Resolver) invalidate(gvk schema.GroupVersionKind, obj any) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	o, ok := obj.(client.Object)
+	if !ok {
+		r.Invalidate()
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := client.ObjectKeyFromObject(o)
+	delete(r.entries, resolverKey{gvk: gvk, key: key})
+	delete(r.entries, resolverKey{gvk: gvk, unstructured: true, key: key})
+	r.epoch++
+}
+
+// watch returns true if cached objects of the kind are invalidated by an
+// event handler. Otherwise, the kind is watched in the background (once the
+// resolver has been started).
+func (r *Resolver) watch(gvk schema.GroupVersionKind, obj client.Object) bool {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	if r.watched[gvk] {
+		return true
+	}
+
+	if _, ok := r.pending[gvk]; ok {
+		return false
+	}
+
+	obj = obj.DeepCopyObject().(client.Object)
+	r.pending[gvk] = obj
+
+	if r.watchCtx != nil {
+		go r.startWatch(r.watchCtx, gvk, obj)
+	}
+
+	return false
+}
+
+// startWatch watches the kind. If no informer is available (eg. the kind
+// can't be watched), it is retried the next time an object of the kind is
+// resolved.
+func (r *Resolver) startWatch(ctx context.Context, gvk schema.GroupVersionKind, obj client.Object) {
+	err := r.addEventHandler(ctx, gvk, obj)
+
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	if err != nil {
+		delete(r.pending, gvk)
+	}
+}
+
+// addEventHandler registers an event handler to invalidate cached objects
+// of the kind.
+func (r *Resolver) addEventHandler(ctx context.Context, gvk schema.GroupVersionKind, obj client.Object) error {
+	r.watchMu.Lock()
+	watched := r.watched[gvk]
+	r.watchMu.Unlock()
+
+	if watched {
+		return nil
+	}
+
+	informer, err := r.opts.Informers.GetInformer(ctx, obj)
+	if err != nil {
+		return fmt.Errorf("failed to get informer: %w", err)
+	}
+
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	// Another watch may have raced us.
+	if r.watched[gvk] {
+		return nil
+	}
+
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { r.invalidate(gvk, obj) },
+		UpdateFunc: func(_, obj any) { r.invalidate(gvk, obj) },
+		DeleteFunc: func(obj any) { r.invalidate(gvk, obj) },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add event handler: %w", err)
+	}
+
+	r.watched[gvk] = true
+	delete(r.pending, gvk)
+
+	return nil
+}
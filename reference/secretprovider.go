@@ -0,0 +1,242 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/updater"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// ExternalSecretAnnotation is set on secrets resolved from an external
+	// provider (they are not stored in the cluster unless materialized).
+	ExternalSecretAnnotation = "gpu-ninja.com/external-secret"
+	// DefaultSecretCacheTTL is how long external secrets without an expiry are cached.
+	DefaultSecretCacheTTL = 5 * time.Minute
+	// DefaultSecretRenewBefore is how long before expiry cached external secrets are renewed.
+	DefaultSecretRenewBefore = time.Minute
+)
+
+// ErrExternalSecretNotFound is returned by a SecretProvider when a secret doesn't exist.
+var ErrExternalSecretNotFound = errors.New("external secret not found")
+
+// ExternalSecret is a secret from an external store.
+type ExternalSecret struct {
+	// Data is the secret's data.
+	Data map[string][]byte
+	// ExpiresAt is when the secret expires (eg. the end of a Vault lease),
+	// zero if it doesn't.
+	ExpiresAt time.Time
+}
+
+// SecretProvider resolves secrets from an external store (eg. Vault, or a
+// cloud secret manager). Secret references that can't be resolved in the
+// cluster fall through to the configured provider, if any.
+type SecretProvider interface {
+	// GetSecret returns the secret for the given key (the namespace and name
+	// of the referenced secret), or ErrExternalSecretNotFound.
+	GetSecret(ctx context.Context, key client.ObjectKey) (*ExternalSecret, error)
+}
+
+var (
+	secretProviderMu sync.RWMutex
+	secretProvider   SecretProvider
+)
+
+// SetSecretProvider sets the operator wide secret provider (nil to disable).
+func SetSecretProvider(p SecretProvider) {
+	secretProviderMu.Lock()
+	defer secretProviderMu.Unlock()
+
+	secretProvider = p
+}
+
+type secretProviderKey struct{}
+
+// WithSecretProvider returns a context using the given secret provider,
+// overriding the operator wide provider.
+func WithSecretProvider(ctx context.Context, p SecretProvider) context.Context {
+	return context.WithValue(ctx, secretProviderKey{}, p)
+}
+
+func secretProviderFromContext(ctx context.Context) SecretProvider {
+	if p, ok := ctx.Value(secretProviderKey{}).(SecretProvider); ok {
+		return p
+	}
+
+	secretProviderMu.RLock()
+	defer secretProviderMu.RUnlock()
+
+	return secretProvider
+}
+
+// resolveExternalSecret resolves a secret using the configured provider.
+func resolveExternalSecret(ctx context.Context, key client.ObjectKey) (*corev1.Secret, bool, error) {
+	p := secretProviderFromContext(ctx)
+	if p == nil {
+		return nil, false, nil
+	}
+
+	external, err := p.GetSecret(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrExternalSecretNotFound) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("failed to get external secret: %w", err)
+	}
+
+	data := make(map[string][]byte, len(external.Data))
+	for k, v := range external.Data {
+		data[k] = append([]byte(nil), v...)
+	}
+
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        key.Name,
+			Namespace:   key.Namespace,
+			Annotations: map[string]string{ExternalSecretAnnotation: "true"},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}, true, nil
+}
+
+// MaterializeSecret stores a secret resolved from an external provider in
+// the cluster (eg. so that it can be mounted by pods), owned by the parent.
+func MaterializeSecret(ctx context.Context, c client.Client, scheme *runtime.Scheme, parent client.Object, secret *corev1.Secret) (*corev1.Secret, error) {
+	template := secret.DeepCopy()
+	template.ResourceVersion = ""
+
+	if err := controllerutil.SetControllerReference(parent, template, scheme); err != nil {
+		return nil, fmt.Errorf("failed to set controller reference: %w", err)
+	}
+
+	obj, err := updater.CreateOrUpdateFromTemplate(ctx, c, template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to materialize secret: %w", err)
+	}
+
+	return obj.(*corev1.Secret), nil
+}
+
+// CacheOptions configures a caching secret provider.
+type CacheOptions struct {
+	// TTL is how long secrets without an expiry are cached.
+	TTL time.Duration
+	// RenewBefore is how long before expiry secrets are renewed.
+	RenewBefore time.Duration
+	// Clock is used to determine expiry (useful for testing).
+	Clock clock.PassiveClock
+}
+
+type cachedSecret struct {
+	secret  *ExternalSecret
+	renewAt time.Time
+}
+
+// CachingSecretProvider caches the secrets returned by another provider,
+// renewing them shortly before they expire. If renewal fails, the cached
+// secret continues to be used until it expires.
+type CachingSecretProvider struct {
+	p    SecretProvider
+	opts CacheOptions
+
+	mu    sync.Mutex
+	cache map[client.ObjectKey]*cachedSecret
+}
+
+// NewCachingSecretProvider returns a provider caching the secrets of p.
+func NewCachingSecretProvider(p SecretProvider, opts CacheOptions) *CachingSecretProvider {
+	if opts.TTL == 0 {
+		opts.TTL = DefaultSecretCacheTTL
+	}
+
+	if opts.RenewBefore == 0 {
+		opts.RenewBefore = DefaultSecretRenewBefore
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &CachingSecretProvider{
+		p:     p,
+		opts:  opts,
+		cache: make(map[client.ObjectKey]*cachedSecret),
+	}
+}
+
+// GetSecret implements SecretProvider.
+func (cp *CachingSecretProvider) GetSecret(ctx context.Context, key client.ObjectKey) (*ExternalSecret, error) {
+	now := cp.opts.Clock.Now()
+
+	cp.mu.Lock()
+	cached, ok := cp.cache[key]
+	cp.mu.Unlock()
+
+	if ok && now.Before(cached.renewAt) {
+		return cached.secret, nil
+	}
+
+	secret, err := cp.p.GetSecret(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrExternalSecretNotFound) {
+			cp.Invalidate(key)
+			return nil, err
+		}
+
+		// Keep using the cached secret until it actually expires.
+		if ok && (cached.secret.ExpiresAt.IsZero() || now.Before(cached.secret.ExpiresAt)) {
+			return cached.secret, nil
+		}
+
+		return nil, err
+	}
+
+	renewAt := now.Add(cp.opts.TTL)
+	if !secret.ExpiresAt.IsZero() {
+		renewAt = secret.ExpiresAt.Add(-cp.opts.RenewBefore)
+	}
+
+	cp.mu.Lock()
+	cp.cache[key] = &cachedSecret{secret: secret, renewAt: renewAt}
+	cp.mu.Unlock()
+
+	return secret, nil
+}
+
+// Invalidate removes a secret from the cache (eg. after it was rejected).
+func (cp *CachingSecretProvider) Invalidate(key client.ObjectKey) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	delete(cp.cache, key)
+}
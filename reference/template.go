@@ -0,0 +1,71 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ErrInvalidNameTemplate is returned when a templated reference name can't
+// be evaluated.
+var ErrInvalidNameTemplate = errors.New("invalid name template")
+
+// IsNameTemplate returns true if the reference name is a template (eg.
+// {{ .parent.Name }}-tls), evaluated against the parent when resolved.
+func IsNameTemplate(name string) bool {
+	return strings.Contains(name, "{{")
+}
+
+// parseNameTemplate parses a templated reference name.
+func parseNameTemplate(name string) (*template.Template, error) {
+	return template.New("name").Option("missingkey=error").Parse(name)
+}
+
+// renderName evaluates a templated reference name against the parent (as
+// .parent, eg. {{ .parent.Name }} for typed parents, or
+// {{ .parent.GetName }} for any parent). Names that aren't templates are
+// returned as is. A terminal error (wrapping ErrInvalidNameTemplate) is
+// returned if the template can't be evaluated.
+func renderName(name string, parent runtime.Object) (string, error) {
+	if !IsNameTemplate(name) {
+		return name, nil
+	}
+
+	tmpl, err := parseNameTemplate(name)
+	if err != nil {
+		return "", reconcile.TerminalError(fmt.Errorf("%w: %w", ErrInvalidNameTemplate, err))
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, map[string]any{"parent": parent}); err != nil {
+		return "", reconcile.TerminalError(fmt.Errorf("%w: %w", ErrInvalidNameTemplate, err))
+	}
+
+	rendered := strings.TrimSpace(sb.String())
+	if rendered == "" {
+		return "", reconcile.TerminalError(fmt.Errorf("%w: %q evaluated to an empty name", ErrInvalidNameTemplate, name))
+	}
+
+	return rendered, nil
+}
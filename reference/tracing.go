@@ -0,0 +1,117 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+
+	"github.com/gpu-ninja/operator-utils/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// InstrumentationName is the name of the tracer used by TracingResolver.
+const InstrumentationName = "github.com/gpu-ninja/operator-utils/reference"
+
+// Attribute keys recorded on reference resolution spans (in addition to the
+// kind, namespace, and name of the referenced object).
+const (
+	AttributeResolved  = attribute.Key("reference.resolved")
+	AttributeDecode    = attribute.Key("reference.decode")
+	AttributeFieldPath = attribute.Key("reference.field_path")
+)
+
+// Decode paths recorded on reference resolution spans.
+const (
+	DecodeTyped        = "typed"
+	DecodeUnstructured = "unstructured"
+)
+
+// TracingResolver resolves references, recording each resolution as a child
+// span of the span in the request context (eg. the reconcile span), so that
+// slow dependency resolution shows up in operator traces.
+type TracingResolver struct {
+	next   ReferenceResolver
+	scheme *runtime.Scheme
+	tracer trace.Tracer
+}
+
+// NewTracingResolver returns a new reference resolver tracing the
+// resolutions of the next resolver.
+func NewTracingResolver(next ReferenceResolver, scheme *runtime.Scheme, opts ...tracing.Option) *TracingResolver {
+	return &TracingResolver{
+		next:   next,
+		scheme: scheme,
+		tracer: tracing.NewTracer(InstrumentationName, opts...),
+	}
+}
+
+// Resolve resolves the reference to its underlying resource.
+func (r *TracingResolver) Resolve(ctx context.Context, ref Reference, parent runtime.Object) (runtime.Object, bool, error) {
+	kind := "Unknown"
+
+	var attrs []attribute.KeyValue
+	if targeted, ok := ref.(TargetedReference); ok {
+		if gvk, key, err := targetWithDefaults(ctx, targeted, r.scheme, parent); err == nil {
+			kind = gvk.Kind
+			attrs = append(attrs,
+				tracing.AttributeGroup.String(gvk.Group),
+				tracing.AttributeVersion.String(gvk.Version),
+				tracing.AttributeKind.String(gvk.Kind),
+				tracing.AttributeNamespace.String(key.Namespace),
+				tracing.AttributeName.String(key.Name),
+			)
+		}
+	}
+
+	if path, ok := ctx.Value(fieldPathKey{}).(string); ok && path != "" {
+		attrs = append(attrs, AttributeFieldPath.String(path))
+	}
+
+	ctx, span := r.tracer.Start(ctx, "Resolve "+kind, trace.WithAttributes(attrs...))
+	defer span.End()
+
+	obj, ok, err := r.next.Resolve(ctx, ref, parent)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return obj, ok, err
+	}
+
+	span.SetAttributes(AttributeResolved.Bool(ok))
+
+	if obj != nil {
+		decode := DecodeTyped
+		if _, isUnstructured := obj.(*unstructured.Unstructured); isUnstructured {
+			decode = DecodeUnstructured
+		}
+
+		span.SetAttributes(AttributeDecode.String(decode))
+	}
+
+	return obj, ok, nil
+}
+
+// ResolveAll resolves every reference embedded in the object (see ResolveAll).
+func (r *TracingResolver) ResolveAll(ctx context.Context, obj runtime.Object) (bool, error) {
+	return ResolveAllWith(ctx, r, obj)
+}
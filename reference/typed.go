@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// ErrUnexpectedType is returned when a resolved object isn't of the expected type.
+var ErrUnexpectedType = errors.New("unexpected type")
+
+// ResolveTyped resolves the reference, returning the underlying resource as
+// T (eg. *appsv1.Deployment). Objects the scheme doesn't recognize (and that
+// were resolved as unstructured) are converted into T if their kind matches
+// (built-in types needn't be registered with the scheme).
+func ResolveTyped[T client.Object](ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object, ref Reference) (T, bool, error) {
+	var zero T
+
+	obj, ok, err := ref.Resolve(ctx, reader, scheme, parent)
+	if !ok || err != nil {
+		return zero, ok, err
+	}
+
+	if typed, ok := obj.(T); ok {
+		return typed, true, nil
+	}
+
+	gvk := obj.GetObjectKind().GroupVersionKind()
+
+	typed := reflect.New(reflect.TypeOf(zero).Elem()).Interface().(T)
+	expectedGVK, err := apiutil.GVKForObject(typed, scheme)
+	if err != nil {
+		expectedGVK, err = apiutil.GVKForObject(typed, clientgoscheme.Scheme)
+	}
+	if err != nil {
+		return zero, false, fmt.Errorf("%w: resolved %s, expected %T", ErrUnexpectedType, kindString(gvk), zero)
+	}
+
+	u, isUnstructured := obj.(*unstructured.Unstructured)
+	if !isUnstructured || gvk != expectedGVK {
+		return zero, false, fmt.Errorf("%w: resolved %s, expected %s", ErrUnexpectedType, kindString(gvk), kindString(expectedGVK))
+	}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed); err != nil {
+		return zero, false, fmt.Errorf("failed to convert unstructured object: %w", err)
+	}
+
+	return typed, true, nil
+}
+
+func kindString(gvk schema.GroupVersionKind) string {
+	return gvk.Kind + "." + gvk.GroupVersion().String()
+}
@@ -0,0 +1,234 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+var kindRegexp = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// ValidateObjectReference statically validates an object reference (eg. in
+// a validating webhook). If any allowed kinds are given, the reference must
+// be to one of them, so it must have an API version (as otherwise its group
+// is that of the parent).
+func ValidateObjectReference(ref *ObjectReference, fldPath *field.Path, allowedKinds ...schema.GroupKind) field.ErrorList {
+	return ValidateObjectReferenceWithDefaults(ref, fldPath, nil, allowedKinds...)
+}
+
+// ValidateObjectReferenceWithDefaults statically validates an object
+// reference (see ValidateObjectReference), with an omitted kind defaulted
+// from the given defaults (which should match those used when resolving,
+// see WithDefaultGVKs).
+func ValidateObjectReferenceWithDefaults(ref *ObjectReference, fldPath *field.Path, defaults []DefaultGVK, allowedKinds ...schema.GroupKind) field.ErrorList {
+	var errs field.ErrorList
+
+	ref = ref.withDefaults(withFieldPath(WithDefaultGVKs(context.Background(), defaults...), fldPath.String()))
+
+	errs = append(errs, validateName(ref.Name, fldPath.Child("name"))...)
+
+	if ref.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(ref.Namespace) {
+			errs = append(errs, field.Invalid(fldPath.Child("namespace"), ref.Namespace, msg))
+		}
+	}
+
+	errs = append(errs, validateKind(ref.APIVersion, ref.Kind, fldPath, allowedKinds)...)
+	errs = append(errs, validateFieldPath(ref.FieldPath, fldPath.Child("fieldPath"))...)
+
+	if ref.MinGeneration < 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("minGeneration"), ref.MinGeneration, "must be non-negative"))
+	}
+
+	return errs
+}
+
+// ValidateLocalObjectReference statically validates a local object reference.
+func ValidateLocalObjectReference(ref *LocalObjectReference, fldPath *field.Path, allowedKinds ...schema.GroupKind) field.ErrorList {
+	return ValidateObjectReference(ref.objectReference(), fldPath, allowedKinds...)
+}
+
+// ValidateLocalObjectReferenceWithDefaults statically validates a local
+// object reference, with an omitted kind defaulted from the given defaults.
+func ValidateLocalObjectReferenceWithDefaults(ref *LocalObjectReference, fldPath *field.Path, defaults []DefaultGVK, allowedKinds ...schema.GroupKind) field.ErrorList {
+	return ValidateObjectReferenceWithDefaults(ref.objectReference(), fldPath, defaults, allowedKinds...)
+}
+
+// ValidateLocalSecretReference statically validates a local secret reference.
+func ValidateLocalSecretReference(ref *LocalSecretReference, fldPath *field.Path) field.ErrorList {
+	return validateName(ref.Name, fldPath.Child("name"))
+}
+
+// ValidateLocalConfigMapReference statically validates a local config map reference.
+func ValidateLocalConfigMapReference(ref *LocalConfigMapReference, fldPath *field.Path) field.ErrorList {
+	return validateName(ref.Name, fldPath.Child("name"))
+}
+
+// ValidateLocalKeyedSecretReference statically validates a local keyed secret reference.
+func ValidateLocalKeyedSecretReference(ref *LocalKeyedSecretReference, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if ref.LocalSecretReference == nil {
+		errs = append(errs, field.Required(fldPath.Child("name"), ""))
+	} else {
+		errs = append(errs, ValidateLocalSecretReference(ref.LocalSecretReference, fldPath)...)
+	}
+
+	return append(errs, validateKey(ref.Key, fldPath.Child("key"))...)
+}
+
+// ValidateSecretKeyReference statically validates a secret key reference.
+func ValidateSecretKeyReference(ref *SecretKeyReference, fldPath *field.Path) field.ErrorList {
+	errs := validateName(ref.Name, fldPath.Child("name"))
+	return append(errs, validateKey(ref.Key, fldPath.Child("key"))...)
+}
+
+// ValidateConfigMapKeyReference statically validates a config map key reference.
+func ValidateConfigMapKeyReference(ref *ConfigMapKeyReference, fldPath *field.Path) field.ErrorList {
+	errs := validateName(ref.Name, fldPath.Child("name"))
+	return append(errs, validateKey(ref.Key, fldPath.Child("key"))...)
+}
+
+// ValidateServiceReference statically validates a service reference.
+func ValidateServiceReference(ref *ServiceReference, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if IsNameTemplate(ref.Name) {
+		errs = append(errs, validateName(ref.Name, fldPath.Child("name"))...)
+	} else {
+		for _, msg := range validation.IsDNS1035Label(ref.Name) {
+			errs = append(errs, field.Invalid(fldPath.Child("name"), ref.Name, msg))
+		}
+	}
+
+	if ref.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(ref.Namespace) {
+			errs = append(errs, field.Invalid(fldPath.Child("namespace"), ref.Namespace, msg))
+		}
+	}
+
+	switch {
+	case ref.Port.Type == intstr.String && ref.Port.StrVal != "":
+		for _, msg := range validation.IsValidPortName(ref.Port.StrVal) {
+			errs = append(errs, field.Invalid(fldPath.Child("port"), ref.Port.StrVal, msg))
+		}
+	case ref.Port.Type == intstr.Int && ref.Port.IntVal != 0:
+		for _, msg := range validation.IsValidPortNum(int(ref.Port.IntVal)) {
+			errs = append(errs, field.Invalid(fldPath.Child("port"), ref.Port.IntVal, msg))
+		}
+	}
+
+	if ref.Scheme != "" && ref.Scheme != "http" && ref.Scheme != "https" {
+		errs = append(errs, field.NotSupported(fldPath.Child("scheme"), ref.Scheme, []string{"http", "https"}))
+	}
+
+	return errs
+}
+
+func validateName(name string, fldPath *field.Path) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(fldPath, "")}
+	}
+
+	// Templated names can only be validated once evaluated.
+	if IsNameTemplate(name) {
+		if _, err := parseNameTemplate(name); err != nil {
+			return field.ErrorList{field.Invalid(fldPath, name, err.Error())}
+		}
+
+		return nil
+	}
+
+	var errs field.ErrorList
+	for _, msg := range validation.IsDNS1123Subdomain(name) {
+		errs = append(errs, field.Invalid(fldPath, name, msg))
+	}
+
+	return errs
+}
+
+func validateKey(key string, fldPath *field.Path) field.ErrorList {
+	if key == "" {
+		return field.ErrorList{field.Required(fldPath, "")}
+	}
+
+	var errs field.ErrorList
+	for _, msg := range validation.IsConfigMapKey(key) {
+		errs = append(errs, field.Invalid(fldPath, key, msg))
+	}
+
+	return errs
+}
+
+func validateKind(apiVersion, kind string, fldPath *field.Path, allowedKinds []schema.GroupKind) field.ErrorList {
+	var errs field.ErrorList
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		errs = append(errs, field.Invalid(fldPath.Child("apiVersion"), apiVersion, err.Error()))
+	} else if apiVersion != "" && gv.Version == "" {
+		errs = append(errs, field.Invalid(fldPath.Child("apiVersion"), apiVersion, "must include a version"))
+	}
+
+	if kind == "" {
+		return append(errs, field.Required(fldPath.Child("kind"), ""))
+	}
+
+	if !kindRegexp.MatchString(kind) {
+		return append(errs, field.Invalid(fldPath.Child("kind"), kind, "must be an upper camel case alphanumeric kind (eg. 'Secret')"))
+	}
+
+	if len(allowedKinds) == 0 || err != nil {
+		return errs
+	}
+
+	// The group can't be checked without an API version.
+	if apiVersion == "" {
+		return append(errs, field.Required(fldPath.Child("apiVersion"), "must be set when only some kinds are allowed"))
+	}
+
+	var supported []string
+	for _, allowed := range allowedKinds {
+		if allowed.Kind == kind && allowed.Group == gv.Group {
+			return errs
+		}
+
+		supported = append(supported, allowed.String())
+	}
+
+	return append(errs, field.NotSupported(fldPath.Child("kind"), schema.GroupKind{Group: gv.Group, Kind: kind}.String(), supported))
+}
+
+func validateFieldPath(fieldPath string, fldPath *field.Path) field.ErrorList {
+	if fieldPath == "" {
+		return nil
+	}
+
+	if err := jsonpath.New("fieldPath").Parse(fieldPathTemplate(fieldPath)); err != nil {
+		return field.ErrorList{field.Invalid(fldPath, fieldPath, err.Error())}
+	}
+
+	return nil
+}
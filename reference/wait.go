@@ -0,0 +1,47 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reference
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gpu-ninja/operator-utils/waitutil"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WaitFor polls until the reference resolves, returning the resolved
+// object, for use outside of a reconcile loop (eg. in jobs, or during
+// startup). Missing objects and retryable errors are retried (see
+// waitutil.PollUntilContext) until the timeout expires, or the context is
+// cancelled. Other errors are returned immediately.
+func WaitFor(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, parent runtime.Object, ref Reference, opts waitutil.Options) (runtime.Object, error) {
+	var obj runtime.Object
+	err := waitutil.PollUntilContext(ctx, func(ctx context.Context) (bool, error) {
+		var ok bool
+		var err error
+		obj, ok, err = ref.Resolve(ctx, reader, scheme, parent)
+		return ok, err
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for reference: %w", err)
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,217 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package restmapper provides a RESTMapper that resets itself when it
+// encounters kinds it doesn't know about, or when CRDs change. This avoids
+// stale "no matches for kind" errors after CRDs are installed.
+package restmapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// DefaultMinResetInterval is the minimum interval between resets triggered
+	// by "no matches for kind" errors (so that lookups of kinds that really
+	// don't exist don't hammer discovery).
+	DefaultMinResetInterval = 5 * time.Second
+)
+
+// Factory builds a new delegate RESTMapper.
+type Factory func() (meta.RESTMapper, error)
+
+// Options configures a resettable RESTMapper.
+type Options struct {
+	// MinResetInterval is the minimum interval between resets triggered by
+	// "no matches for kind" errors.
+	MinResetInterval time.Duration
+	// Clock is used to rate limit resets (useful for testing).
+	Clock clock.PassiveClock
+}
+
+// Mapper is a RESTMapper that rebuilds its delegate when it is reset.
+type Mapper struct {
+	factory Factory
+	opts    Options
+
+	mu        sync.RWMutex
+	delegate  meta.RESTMapper
+	lastReset time.Time
+}
+
+var _ meta.ResettableRESTMapper = (*Mapper)(nil)
+
+// New returns a new resettable RESTMapper, the delegate is built immediately.
+func New(factory Factory, opts Options) (*Mapper, error) {
+	if opts.MinResetInterval == 0 {
+		opts.MinResetInterval = DefaultMinResetInterval
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	delegate, err := factory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rest mapper: %w", err)
+	}
+
+	return &Mapper{
+		factory:   factory,
+		opts:      opts,
+		delegate:  delegate,
+		lastReset: opts.Clock.Now(),
+	}, nil
+}
+
+// Provider returns a manager MapperProvider that builds resettable dynamic
+// RESTMappers, eg. `manager.Options{MapperProvider: restmapper.Provider(restmapper.Options{})}`.
+func Provider(opts Options) func(cfg *rest.Config, httpClient *http.Client) (meta.RESTMapper, error) {
+	return func(cfg *rest.Config, httpClient *http.Client) (meta.RESTMapper, error) {
+		return New(func() (meta.RESTMapper, error) {
+			return apiutil.NewDynamicRESTMapper(cfg, httpClient)
+		}, opts)
+	}
+}
+
+// SetupWithManager resets the mapper whenever a CRD is added, updated, or
+// removed. The CRDs are watched on every replica, not just the leader.
+func (m *Mapper) SetupWithManager(mgr manager.Manager) error {
+	return mgr.Add(&crdWatcher{m: m, cache: mgr.GetCache()})
+}
+
+// crdWatcher resets a mapper when CRDs change.
+type crdWatcher struct {
+	m     *Mapper
+	cache cache.Cache
+}
+
+func (w *crdWatcher) Start(ctx context.Context) error {
+	informer, err := w.cache.GetInformer(ctx, &apiextensionsv1.CustomResourceDefinition{})
+	if err != nil {
+		return fmt.Errorf("failed to get crd informer: %w", err)
+	}
+
+	registration, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { w.m.Reset() },
+		UpdateFunc: func(_, _ any) { w.m.Reset() },
+		DeleteFunc: func(any) { w.m.Reset() },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add crd event handler: %w", err)
+	}
+
+	<-ctx.Done()
+
+	return informer.RemoveEventHandler(registration)
+}
+
+// NeedLeaderElection returns false, as every replica uses the mapper.
+func (w *crdWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Reset rebuilds the delegate mapper. If the delegate can't be rebuilt the
+// existing delegate is retained.
+func (m *Mapper) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reset()
+}
+
+func (m *Mapper) reset() {
+	m.lastReset = m.opts.Clock.Now()
+
+	if resettable, ok := m.delegate.(meta.ResettableRESTMapper); ok {
+		resettable.Reset()
+		return
+	}
+
+	delegate, err := m.factory()
+	if err != nil {
+		log.Log.Error(err, "Failed to reset rest mapper")
+		return
+	}
+
+	m.delegate = delegate
+}
+
+// do calls fn with the delegate, resetting and retrying once if the kind is unknown.
+func do[T any](m *Mapper, fn func(delegate meta.RESTMapper) (T, error)) (T, error) {
+	m.mu.RLock()
+	delegate := m.delegate
+	m.mu.RUnlock()
+
+	result, err := fn(delegate)
+	if err == nil || !meta.IsNoMatchError(err) {
+		return result, err
+	}
+
+	m.mu.Lock()
+	// Another caller may have already reset the delegate.
+	if m.delegate == delegate && m.opts.Clock.Since(m.lastReset) >= m.opts.MinResetInterval {
+		m.reset()
+	}
+	delegate = m.delegate
+	m.mu.Unlock()
+
+	return fn(delegate)
+}
+
+func (m *Mapper) KindFor(resource schema.GroupVersionResource) (schema.GroupVersionKind, error) {
+	return do(m, func(d meta.RESTMapper) (schema.GroupVersionKind, error) { return d.KindFor(resource) })
+}
+
+func (m *Mapper) KindsFor(resource schema.GroupVersionResource) ([]schema.GroupVersionKind, error) {
+	return do(m, func(d meta.RESTMapper) ([]schema.GroupVersionKind, error) { return d.KindsFor(resource) })
+}
+
+func (m *Mapper) ResourceFor(input schema.GroupVersionResource) (schema.GroupVersionResource, error) {
+	return do(m, func(d meta.RESTMapper) (schema.GroupVersionResource, error) { return d.ResourceFor(input) })
+}
+
+func (m *Mapper) ResourcesFor(input schema.GroupVersionResource) ([]schema.GroupVersionResource, error) {
+	return do(m, func(d meta.RESTMapper) ([]schema.GroupVersionResource, error) { return d.ResourcesFor(input) })
+}
+
+func (m *Mapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	return do(m, func(d meta.RESTMapper) (*meta.RESTMapping, error) { return d.RESTMapping(gk, versions...) })
+}
+
+func (m *Mapper) RESTMappings(gk schema.GroupKind, versions ...string) ([]*meta.RESTMapping, error) {
+	return do(m, func(d meta.RESTMapper) ([]*meta.RESTMapping, error) { return d.RESTMappings(gk, versions...) })
+}
+
+func (m *Mapper) ResourceSingularizer(resource string) (string, error) {
+	return do(m, func(d meta.RESTMapper) (string, error) { return d.ResourceSingularizer(resource) })
+}
@@ -0,0 +1,75 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package restmapper_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/restmapper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestMapper(t *testing.T) {
+	clock := clocktesting.NewFakePassiveClock(time.Now())
+
+	widget := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+
+	var installed []schema.GroupVersionKind
+	var builds int
+	m, err := restmapper.New(func() (meta.RESTMapper, error) {
+		builds++
+
+		mapper := meta.NewDefaultRESTMapper(nil)
+		for _, gvk := range installed {
+			mapper.Add(gvk, meta.RESTScopeNamespace)
+		}
+
+		return mapper, nil
+	}, restmapper.Options{
+		MinResetInterval: time.Minute,
+		Clock:            clock,
+	})
+	require.NoError(t, err)
+
+	_, err = m.RESTMapping(widget.GroupKind(), widget.Version)
+	require.True(t, meta.IsNoMatchError(err))
+
+	// The CRD is installed.
+	installed = append(installed, widget)
+
+	// Too soon to reset.
+	_, err = m.RESTMapping(widget.GroupKind(), widget.Version)
+	require.True(t, meta.IsNoMatchError(err))
+	assert.Equal(t, 1, builds)
+
+	clock.SetTime(clock.Now().Add(time.Minute))
+
+	mapping, err := m.RESTMapping(widget.GroupKind(), widget.Version)
+	require.NoError(t, err)
+	assert.Equal(t, "widgets", mapping.Resource.Resource)
+	assert.Equal(t, 2, builds)
+
+	// Explicit resets are not rate limited.
+	m.Reset()
+	assert.Equal(t, 3, builds)
+}
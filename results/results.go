@@ -0,0 +1,120 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package results provides a uniform way to build and combine reconcile results.
+package results
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Result is a reconcile result builder.
+type Result struct {
+	requeue bool
+	after   time.Duration
+	jitter  float64
+}
+
+// Done returns a result indicating reconciliation is complete.
+func Done() Result {
+	return Result{}
+}
+
+// Requeue returns a result indicating the request should be requeued
+// immediately (subject to the rate limiter).
+func Requeue() Result {
+	return Result{requeue: true}
+}
+
+// RequeueAfter returns a result indicating the request should be requeued
+// after the given duration.
+func RequeueAfter(after time.Duration) Result {
+	if after <= 0 {
+		return Requeue()
+	}
+
+	return Result{requeue: true, after: after}
+}
+
+// FromResult converts a controller-runtime result into a Result.
+func FromResult(res ctrl.Result) Result {
+	if res.RequeueAfter > 0 {
+		return RequeueAfter(res.RequeueAfter)
+	}
+
+	if res.Requeue {
+		return Requeue()
+	}
+
+	return Done()
+}
+
+// WithJitter adds up to the given fraction of the requeue duration as
+// random jitter, so that objects reconciled together don't resync together.
+func (r Result) WithJitter(maxFactor float64) Result {
+	r.jitter = maxFactor
+	return r
+}
+
+// IsDone returns true if the result does not requeue.
+func (r Result) IsDone() bool {
+	return !r.requeue
+}
+
+// After returns the requeue duration (before jitter is applied).
+func (r Result) After() time.Duration {
+	return r.after
+}
+
+// Result returns the controller-runtime result, applying any jitter.
+func (r Result) Result() ctrl.Result {
+	if !r.requeue {
+		return ctrl.Result{}
+	}
+
+	if r.after == 0 {
+		return ctrl.Result{Requeue: true}
+	}
+
+	after := r.after
+	if r.jitter > 0 {
+		after = wait.Jitter(after, r.jitter)
+	}
+
+	return ctrl.Result{RequeueAfter: after}
+}
+
+// Merge combines the results of multiple sub-reconcilers. The shortest
+// requeue wins, an immediate requeue beats any delayed requeue, and the
+// result is only done if every result is done.
+func Merge(results ...Result) Result {
+	merged := Done()
+	for _, r := range results {
+		if !r.requeue {
+			continue
+		}
+
+		if merged.IsDone() || r.after < merged.after {
+			merged = r
+		}
+	}
+
+	return merged
+}
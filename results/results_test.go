@@ -0,0 +1,52 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package results_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/results"
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestResult(t *testing.T) {
+	assert.Equal(t, ctrl.Result{}, results.Done().Result())
+	assert.Equal(t, ctrl.Result{Requeue: true}, results.Requeue().Result())
+	assert.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, results.RequeueAfter(time.Minute).Result())
+
+	for i := 0; i < 100; i++ {
+		res := results.RequeueAfter(time.Minute).WithJitter(0.1).Result()
+		assert.GreaterOrEqual(t, res.RequeueAfter, time.Minute)
+		assert.LessOrEqual(t, res.RequeueAfter, time.Minute+6*time.Second)
+	}
+
+	assert.Equal(t, results.RequeueAfter(time.Second), results.FromResult(ctrl.Result{RequeueAfter: time.Second}))
+}
+
+func TestMerge(t *testing.T) {
+	assert.True(t, results.Merge().IsDone())
+	assert.True(t, results.Merge(results.Done(), results.Done()).IsDone())
+
+	merged := results.Merge(results.Done(), results.RequeueAfter(time.Hour), results.RequeueAfter(time.Minute))
+	assert.Equal(t, time.Minute, merged.After())
+
+	merged = results.Merge(results.RequeueAfter(time.Minute), results.Requeue())
+	assert.Equal(t, ctrl.Result{Requeue: true}, merged.Result())
+}
@@ -0,0 +1,107 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package retryable marks errors that are expected to resolve themselves
+// (eg. a dependency that is not ready yet), so that reconcilers can requeue
+// instead of reporting them as failures.
+package retryable
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// DefaultRetryAfter is how long to wait before retrying, if not specified by the error.
+const DefaultRetryAfter = 5 * time.Second
+
+// Error is a retryable error.
+type Error struct {
+	// Err is the underlying error.
+	Err error
+	// RetryAfter is how long to wait before retrying (zero for the default).
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New marks an error as retryable.
+func New(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Err: err}
+}
+
+// After marks an error as retryable after the given duration.
+func After(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Err: err, RetryAfter: d}
+}
+
+// Errorf formats a retryable error.
+func Errorf(format string, args ...any) error {
+	return &Error{Err: fmt.Errorf(format, args...)}
+}
+
+// Is returns true if the error (or any error it wraps) is retryable.
+func Is(err error) bool {
+	var retryableErr *Error
+	return errors.As(err, &retryableErr)
+}
+
+// RetryAfter returns how long to wait before retrying, and false if the
+// error is not retryable.
+func RetryAfter(err error) (time.Duration, bool) {
+	var retryableErr *Error
+	if !errors.As(err, &retryableErr) {
+		return 0, false
+	}
+
+	if retryableErr.RetryAfter == 0 {
+		return DefaultRetryAfter, true
+	}
+
+	return retryableErr.RetryAfter, true
+}
+
+// Result converts an error into a reconcile result. Retryable errors are
+// requeued (without being reported as errors), while any other error is
+// returned as is.
+func Result(err error) (ctrl.Result, error) {
+	if err == nil {
+		return ctrl.Result{}, nil
+	}
+
+	if after, ok := RetryAfter(err); ok {
+		return ctrl.Result{RequeueAfter: after}, nil
+	}
+
+	return ctrl.Result{}, err
+}
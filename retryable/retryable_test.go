@@ -0,0 +1,54 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package retryable_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestRetryable(t *testing.T) {
+	assert.Nil(t, retryable.New(nil))
+
+	errNotReady := errors.New("not ready")
+
+	err := fmt.Errorf("failed to get endpoint: %w", retryable.New(errNotReady))
+	assert.True(t, retryable.Is(err))
+	assert.ErrorIs(t, err, errNotReady)
+
+	after, ok := retryable.RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, retryable.DefaultRetryAfter, after)
+
+	res, err := retryable.Result(retryable.After(errNotReady, time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, res)
+
+	res, err = retryable.Result(errNotReady)
+	assert.ErrorIs(t, err, errNotReady)
+	assert.Zero(t, res)
+
+	assert.False(t, retryable.Is(errNotReady))
+	assert.True(t, retryable.Is(retryable.Errorf("waiting for %s", "database")))
+}
@@ -0,0 +1,367 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package rollout reports the rollout progress of workloads (Deployments,
+// StatefulSets, and DaemonSets), including why a stalled rollout is stuck.
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Phase is the phase of a rollout.
+type Phase string
+
+const (
+	PhaseComplete    Phase = "Complete"
+	PhaseProgressing Phase = "Progressing"
+	PhaseStalled     Phase = "Stalled"
+)
+
+// Condition reasons.
+const (
+	ReasonRolloutComplete    = "RolloutComplete"
+	ReasonRolloutProgressing = "RolloutProgressing"
+	ReasonRolloutStalled     = "RolloutStalled"
+)
+
+// ErrStalled is returned when waiting for a stalled rollout.
+var ErrStalled = errors.New("rollout stalled")
+
+// StallReasons are the container waiting reasons that indicate a rollout
+// will not make progress without intervention.
+var StallReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// Status is the rollout status of a workload.
+type Status struct {
+	// Kind is the kind of the workload.
+	Kind string
+	// Name is the name of the workload.
+	Name string
+	// Phase is the phase of the rollout.
+	Phase Phase
+	// Replicas is the desired number of replicas (or scheduled pods for DaemonSets).
+	Replicas int32
+	// UpdatedReplicas is the number of replicas running the latest revision.
+	UpdatedReplicas int32
+	// ReadyReplicas is the number of ready replicas.
+	ReadyReplicas int32
+	// Reason is a machine readable reason for a stall (eg. ImagePullBackOff).
+	Reason string
+	// Message is a human readable explanation of the rollout's progress.
+	Message string
+}
+
+func (s *Status) String() string {
+	if s.Reason != "" {
+		return fmt.Sprintf("%s/%s is %s (%s): %s", s.Kind, s.Name, s.Phase, s.Reason, s.Message)
+	}
+
+	return fmt.Sprintf("%s/%s is %s: %s", s.Kind, s.Name, s.Phase, s.Message)
+}
+
+// Complete returns true if the rollout is complete.
+func (s *Status) Complete() bool {
+	return s.Phase == PhaseComplete
+}
+
+// Condition returns a condition of the given type that reflects the status
+// of the rollout, suitable for surfacing into a parent's status.
+func (s *Status) Condition(conditionType string, generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonRolloutProgressing,
+		Message:            s.String(),
+	}
+
+	switch s.Phase {
+	case PhaseComplete:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonRolloutComplete
+	case PhaseStalled:
+		condition.Reason = ReasonRolloutStalled
+	}
+
+	return condition
+}
+
+// Inspect returns the rollout status of a Deployment, StatefulSet, or
+// DaemonSet. If the rollout is not complete, the workload's pods are
+// inspected to detect stalls (eg. image pull failures or crash loops).
+func Inspect(ctx context.Context, c client.Client, obj client.Object) (*Status, error) {
+	status, err := InspectStatus(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var selector *metav1.LabelSelector
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		selector = workload.Spec.Selector
+	case *appsv1.StatefulSet:
+		selector = workload.Spec.Selector
+	case *appsv1.DaemonSet:
+		selector = workload.Spec.Selector
+	}
+
+	if status.Phase == PhaseComplete || status.Phase == PhaseStalled || selector == nil {
+		return status, nil
+	}
+
+	podSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse selector: %w", err)
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(obj.GetNamespace()), client.MatchingLabelsSelector{Selector: podSelector}); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+
+	for i := range pods.Items {
+		if reason, message, stalled := podStalled(&pods.Items[i]); stalled {
+			status.Phase = PhaseStalled
+			status.Reason = reason
+			status.Message = fmt.Sprintf("pod %s: %s", pods.Items[i].Name, message)
+			break
+		}
+	}
+
+	return status, nil
+}
+
+// InspectStatus returns the rollout status of a Deployment, StatefulSet, or
+// DaemonSet from the workload's status alone (without inspecting its pods).
+func InspectStatus(obj client.Object) (*Status, error) {
+	var status *Status
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		status = inspectDeployment(workload)
+	case *appsv1.StatefulSet:
+		status = inspectStatefulSet(workload)
+	case *appsv1.DaemonSet:
+		status = inspectDaemonSet(workload)
+	default:
+		return nil, fmt.Errorf("unsupported workload type %T", obj)
+	}
+
+	status.Name = obj.GetName()
+
+	return status, nil
+}
+
+// Wait polls the rollout status of the workload until the rollout is
+// complete. If the rollout stalls ErrStalled is returned (along with the
+// status explaining why).
+func Wait(ctx context.Context, c client.Client, obj client.Object, interval time.Duration) (*Status, error) {
+	var status *Status
+	err := wait.PollUntilContextCancel(ctx, interval, true, func(ctx context.Context) (bool, error) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return false, fmt.Errorf("failed to get workload: %w", err)
+		}
+
+		var err error
+		status, err = Inspect(ctx, c, obj)
+		if err != nil {
+			return false, err
+		}
+
+		switch status.Phase {
+		case PhaseComplete:
+			return true, nil
+		case PhaseStalled:
+			return false, fmt.Errorf("%w: %s", ErrStalled, status)
+		default:
+			return false, nil
+		}
+	})
+
+	return status, err
+}
+
+func inspectDeployment(deploy *appsv1.Deployment) *Status {
+	status := &Status{
+		Kind:            "Deployment",
+		Replicas:        replicasOrDefault(deploy.Spec.Replicas),
+		UpdatedReplicas: deploy.Status.UpdatedReplicas,
+		ReadyReplicas:   deploy.Status.ReadyReplicas,
+	}
+
+	for _, condition := range deploy.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse &&
+			condition.Reason == "ProgressDeadlineExceeded" {
+			return stalled(status, condition.Reason, condition.Message)
+		}
+
+		if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue {
+			return stalled(status, condition.Reason, condition.Message)
+		}
+	}
+
+	if deploy.Status.ObservedGeneration < deploy.Generation {
+		return progressing(status, "waiting for rollout to be observed")
+	}
+
+	if deploy.Status.UpdatedReplicas < status.Replicas {
+		return progressing(status, fmt.Sprintf("%d/%d replicas updated", deploy.Status.UpdatedReplicas, status.Replicas))
+	}
+
+	if deploy.Status.Replicas > deploy.Status.UpdatedReplicas {
+		return progressing(status, fmt.Sprintf("%d old replicas pending termination", deploy.Status.Replicas-deploy.Status.UpdatedReplicas))
+	}
+
+	if deploy.Status.AvailableReplicas < status.Replicas {
+		return progressing(status, fmt.Sprintf("%d/%d replicas available", deploy.Status.AvailableReplicas, status.Replicas))
+	}
+
+	return complete(status)
+}
+
+func inspectStatefulSet(sts *appsv1.StatefulSet) *Status {
+	status := &Status{
+		Kind:            "StatefulSet",
+		Replicas:        replicasOrDefault(sts.Spec.Replicas),
+		UpdatedReplicas: sts.Status.UpdatedReplicas,
+		ReadyReplicas:   sts.Status.ReadyReplicas,
+	}
+
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return progressing(status, "waiting for rollout to be observed")
+	}
+
+	var partition int32
+	if rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+		partition = *rollingUpdate.Partition
+	}
+
+	switch {
+	case sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType:
+		// Pods are only updated once they are deleted, so the rollout
+		// completes once the existing pods are ready.
+	case partition > 0:
+		// Only the pods with an ordinal at or above the partition are updated.
+		if partitioned := status.Replicas - partition; sts.Status.UpdatedReplicas < partitioned {
+			return progressing(status, fmt.Sprintf("%d/%d replicas updated (partitioned at %d)", sts.Status.UpdatedReplicas, partitioned, partition))
+		}
+	case sts.Status.UpdatedReplicas < status.Replicas || sts.Status.CurrentRevision != sts.Status.UpdateRevision:
+		return progressing(status, fmt.Sprintf("%d/%d replicas updated", sts.Status.UpdatedReplicas, status.Replicas))
+	}
+
+	if sts.Status.ReadyReplicas < status.Replicas {
+		return progressing(status, fmt.Sprintf("%d/%d replicas ready", sts.Status.ReadyReplicas, status.Replicas))
+	}
+
+	return complete(status)
+}
+
+func inspectDaemonSet(ds *appsv1.DaemonSet) *Status {
+	status := &Status{
+		Kind:            "DaemonSet",
+		Replicas:        ds.Status.DesiredNumberScheduled,
+		UpdatedReplicas: ds.Status.UpdatedNumberScheduled,
+		ReadyReplicas:   ds.Status.NumberReady,
+	}
+
+	if ds.Status.ObservedGeneration < ds.Generation {
+		return progressing(status, "waiting for rollout to be observed")
+	}
+
+	if ds.Status.UpdatedNumberScheduled < status.Replicas {
+		return progressing(status, fmt.Sprintf("%d/%d pods updated", ds.Status.UpdatedNumberScheduled, status.Replicas))
+	}
+
+	if ds.Status.NumberAvailable < status.Replicas {
+		return progressing(status, fmt.Sprintf("%d/%d pods available", ds.Status.NumberAvailable, status.Replicas))
+	}
+
+	return complete(status)
+}
+
+// podStalled returns the reason a pod will not become ready without intervention.
+func podStalled(pod *corev1.Pod) (string, string, bool) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse &&
+			condition.Reason == corev1.PodReasonUnschedulable {
+			return condition.Reason, condition.Message, true
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, containerStatus := range statuses {
+		if waiting := containerStatus.State.Waiting; waiting != nil && StallReasons[waiting.Reason] {
+			message := fmt.Sprintf("container %s is waiting: %s", containerStatus.Name, waiting.Reason)
+			if waiting.Message != "" {
+				message += ": " + waiting.Message
+			}
+
+			return waiting.Reason, message, true
+		}
+	}
+
+	return "", "", false
+}
+
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+
+	return *replicas
+}
+
+func complete(status *Status) *Status {
+	status.Phase = PhaseComplete
+	status.Message = fmt.Sprintf("%d/%d replicas ready", status.ReadyReplicas, status.Replicas)
+	return status
+}
+
+func progressing(status *Status, message string) *Status {
+	status.Phase = PhaseProgressing
+	status.Message = message
+	return status
+}
+
+func stalled(status *Status, reason, message string) *Status {
+	status.Phase = PhaseStalled
+	status.Reason = reason
+	status.Message = message
+	return status
+}
@@ -0,0 +1,168 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rollout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/rollout"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Generation: 2},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(int32(2)),
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 2,
+			Replicas:           2,
+			UpdatedReplicas:    1,
+			ReadyReplicas:      1,
+			AvailableReplicas:  1,
+		},
+	}
+
+	healthy := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-a", Labels: map[string]string{"app": "web"}},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "web",
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithObjects(deploy, healthy).Build()
+
+	t.Run("Progressing", func(t *testing.T) {
+		status, err := rollout.Inspect(ctx, c, deploy)
+		require.NoError(t, err)
+
+		assert.Equal(t, rollout.PhaseProgressing, status.Phase)
+		assert.Equal(t, "1/2 replicas updated", status.Message)
+	})
+
+	t.Run("Stalled", func(t *testing.T) {
+		stuck := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-b", Labels: map[string]string{"app": "web"}},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name: "web",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+						Reason:  "ImagePullBackOff",
+						Message: "Back-off pulling image \"web:v2\"",
+					}},
+				}},
+			},
+		}
+		require.NoError(t, c.Create(ctx, stuck))
+
+		status, err := rollout.Inspect(ctx, c, deploy)
+		require.NoError(t, err)
+
+		assert.Equal(t, rollout.PhaseStalled, status.Phase)
+		assert.Equal(t, "ImagePullBackOff", status.Reason)
+		assert.Contains(t, status.Message, "pod web-b: container web is waiting")
+
+		condition := status.Condition("Ready", 3)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, rollout.ReasonRolloutStalled, condition.Reason)
+
+		_, err = rollout.Wait(ctx, c, deploy, 10*time.Millisecond)
+		assert.ErrorIs(t, err, rollout.ErrStalled)
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db", Generation: 1},
+			Spec:       appsv1.StatefulSetSpec{Replicas: ptr.To(int32(3))},
+			Status: appsv1.StatefulSetStatus{
+				ObservedGeneration: 1,
+				UpdatedReplicas:    3,
+				ReadyReplicas:      3,
+				CurrentRevision:    "db-1",
+				UpdateRevision:     "db-1",
+			},
+		}
+
+		status, err := rollout.Inspect(ctx, c, sts)
+		require.NoError(t, err)
+
+		assert.True(t, status.Complete())
+		assert.Equal(t, metav1.ConditionTrue, status.Condition("Ready", 1).Status)
+	})
+
+	t.Run("StatefulSet Update Strategies", func(t *testing.T) {
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db", Generation: 2},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: ptr.To(int32(3)),
+				UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+					Type: appsv1.RollingUpdateStatefulSetStrategyType,
+					RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+						Partition: ptr.To(int32(2)),
+					},
+				},
+			},
+			Status: appsv1.StatefulSetStatus{
+				ObservedGeneration: 2,
+				UpdatedReplicas:    1,
+				ReadyReplicas:      3,
+				CurrentRevision:    "db-1",
+				UpdateRevision:     "db-2",
+			},
+		}
+
+		// Only the pods at or above the partition are updated.
+		status, err := rollout.InspectStatus(sts)
+		require.NoError(t, err)
+		assert.True(t, status.Complete())
+
+		sts.Spec.UpdateStrategy.RollingUpdate.Partition = ptr.To(int32(1))
+
+		status, err = rollout.InspectStatus(sts)
+		require.NoError(t, err)
+		assert.Equal(t, rollout.PhaseProgressing, status.Phase)
+		assert.Equal(t, "1/2 replicas updated (partitioned at 1)", status.Message)
+
+		// Pods are only updated when they are deleted.
+		sts.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+
+		status, err = rollout.InspectStatus(sts)
+		require.NoError(t, err)
+		assert.True(t, status.Complete())
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := rollout.Inspect(ctx, c, &corev1.Pod{})
+		assert.Error(t, err)
+	})
+}
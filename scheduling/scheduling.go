@@ -0,0 +1,255 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package scheduling provides a builder for pod scheduling constraints
+// (tolerations, node and pod affinity, and topology spread constraints).
+package scheduling
+
+import (
+	"github.com/gpu-ninja/operator-utils/gpunode"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Builder builds pod scheduling constraints from high level inputs, eg.
+//
+//	scheduling.New().
+//		RequireGPUs(gpunode.Requirements{Count: 1, Products: []string{"NVIDIA-A100-SXM4-80GB"}}).
+//		SpreadAcrossZones(podLabels, 1).
+//		Apply(&podSpec)
+type Builder struct {
+	required                  []corev1.NodeSelectorRequirement
+	preferred                 []corev1.PreferredSchedulingTerm
+	podAntiAffinity           []corev1.WeightedPodAffinityTerm
+	requiredPodAntiAffinity   []corev1.PodAffinityTerm
+	tolerations               []corev1.Toleration
+	topologySpreadConstraints []corev1.TopologySpreadConstraint
+}
+
+// New returns a new scheduling constraint builder.
+func New() *Builder {
+	return &Builder{}
+}
+
+// RequireNodeLabel requires nodes with the label set to one of the given
+// values (or if no values are given, nodes with the label).
+func (b *Builder) RequireNodeLabel(key string, values ...string) *Builder {
+	b.required = append(b.required, labelRequirement(key, values))
+	return b
+}
+
+// RequireNodeLabelNotIn requires nodes without the label set to any of the given values.
+func (b *Builder) RequireNodeLabelNotIn(key string, values ...string) *Builder {
+	b.required = append(b.required, corev1.NodeSelectorRequirement{
+		Key:      key,
+		Operator: corev1.NodeSelectorOpNotIn,
+		Values:   values,
+	})
+	return b
+}
+
+// PreferNodeLabel prefers (with the given weight, 1-100) nodes with the
+// label set to one of the given values (or if no values are given, nodes
+// with the label).
+func (b *Builder) PreferNodeLabel(weight int32, key string, values ...string) *Builder {
+	b.preferred = append(b.preferred, corev1.PreferredSchedulingTerm{
+		Weight: weight,
+		Preference: corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{labelRequirement(key, values)},
+		},
+	})
+	return b
+}
+
+// RequireZones requires nodes in one of the given zones.
+func (b *Builder) RequireZones(zones ...string) *Builder {
+	return b.RequireNodeLabel(corev1.LabelTopologyZone, zones...)
+}
+
+// RequireGPUs requires nodes with suitable GPUs, and tolerates the GPU taint.
+func (b *Builder) RequireGPUs(req gpunode.Requirements) *Builder {
+	b.required = append(b.required, req.NodeSelectorRequirements()...)
+	for _, toleration := range req.Tolerations() {
+		b.tolerate(toleration)
+	}
+	return b
+}
+
+// Tolerate tolerates any taint with the given key (and any value and effect).
+func (b *Builder) Tolerate(key string) *Builder {
+	b.tolerate(corev1.Toleration{
+		Key:      key,
+		Operator: corev1.TolerationOpExists,
+	})
+	return b
+}
+
+// TolerateTaint tolerates the taint with the given key, value, and effect.
+func (b *Builder) TolerateTaint(key, value string, effect corev1.TaintEffect) *Builder {
+	b.tolerate(corev1.Toleration{
+		Key:      key,
+		Operator: corev1.TolerationOpEqual,
+		Value:    value,
+		Effect:   effect,
+	})
+	return b
+}
+
+// SpreadAcross spreads the pods matching the given labels across the topology
+// domains (eg. zones or nodes) identified by the topology key. If required is
+// false pods are scheduled even when the spread can't be satisfied.
+func (b *Builder) SpreadAcross(topologyKey string, podLabels map[string]string, maxSkew int32, required bool) *Builder {
+	whenUnsatisfiable := corev1.ScheduleAnyway
+	if required {
+		whenUnsatisfiable = corev1.DoNotSchedule
+	}
+
+	b.topologySpreadConstraints = append(b.topologySpreadConstraints, corev1.TopologySpreadConstraint{
+		MaxSkew:           maxSkew,
+		TopologyKey:       topologyKey,
+		WhenUnsatisfiable: whenUnsatisfiable,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: copyLabels(podLabels)},
+	})
+	return b
+}
+
+// SpreadAcrossZones spreads the pods matching the given labels across zones
+// (on a best effort basis).
+func (b *Builder) SpreadAcrossZones(podLabels map[string]string, maxSkew int32) *Builder {
+	return b.SpreadAcross(corev1.LabelTopologyZone, podLabels, maxSkew, false)
+}
+
+// SpreadAcrossNodes spreads the pods matching the given labels across nodes
+// (on a best effort basis).
+func (b *Builder) SpreadAcrossNodes(podLabels map[string]string, maxSkew int32) *Builder {
+	return b.SpreadAcross(corev1.LabelHostname, podLabels, maxSkew, false)
+}
+
+// AvoidColocation prefers (or if required, requires) that pods matching the
+// given labels are not scheduled into the same topology domain (eg. node).
+func (b *Builder) AvoidColocation(topologyKey string, podLabels map[string]string, required bool) *Builder {
+	term := corev1.PodAffinityTerm{
+		TopologyKey:   topologyKey,
+		LabelSelector: &metav1.LabelSelector{MatchLabels: copyLabels(podLabels)},
+	}
+
+	if required {
+		b.requiredPodAntiAffinity = append(b.requiredPodAntiAffinity, term)
+	} else {
+		b.podAntiAffinity = append(b.podAntiAffinity, corev1.WeightedPodAffinityTerm{
+			Weight:          100,
+			PodAffinityTerm: term,
+		})
+	}
+	return b
+}
+
+// Affinity returns the built affinity (or nil if there are no affinity constraints).
+func (b *Builder) Affinity() *corev1.Affinity {
+	if len(b.required) == 0 && len(b.preferred) == 0 &&
+		len(b.podAntiAffinity) == 0 && len(b.requiredPodAntiAffinity) == 0 {
+		return nil
+	}
+
+	affinity := &corev1.Affinity{}
+
+	if len(b.required) > 0 || len(b.preferred) > 0 {
+		affinity.NodeAffinity = &corev1.NodeAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: append([]corev1.PreferredSchedulingTerm(nil), b.preferred...),
+		}
+
+		if len(b.required) > 0 {
+			affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: append([]corev1.NodeSelectorRequirement(nil), b.required...),
+				}},
+			}
+		}
+	}
+
+	if len(b.podAntiAffinity) > 0 || len(b.requiredPodAntiAffinity) > 0 {
+		affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution:  append([]corev1.PodAffinityTerm(nil), b.requiredPodAntiAffinity...),
+			PreferredDuringSchedulingIgnoredDuringExecution: append([]corev1.WeightedPodAffinityTerm(nil), b.podAntiAffinity...),
+		}
+	}
+
+	return affinity
+}
+
+// Tolerations returns the built tolerations.
+func (b *Builder) Tolerations() []corev1.Toleration {
+	return append([]corev1.Toleration(nil), b.tolerations...)
+}
+
+// TopologySpreadConstraints returns the built topology spread constraints.
+func (b *Builder) TopologySpreadConstraints() []corev1.TopologySpreadConstraint {
+	return append([]corev1.TopologySpreadConstraint(nil), b.topologySpreadConstraints...)
+}
+
+// Apply sets the built constraints on a pod spec. Any existing affinity and
+// topology spread constraints are replaced, and tolerations are merged.
+func (b *Builder) Apply(spec *corev1.PodSpec) {
+	spec.Affinity = b.Affinity()
+	spec.TopologySpreadConstraints = b.TopologySpreadConstraints()
+
+	for _, toleration := range b.tolerations {
+		if !hasToleration(spec.Tolerations, &toleration) {
+			spec.Tolerations = append(spec.Tolerations, toleration)
+		}
+	}
+}
+
+func (b *Builder) tolerate(toleration corev1.Toleration) {
+	if !hasToleration(b.tolerations, &toleration) {
+		b.tolerations = append(b.tolerations, toleration)
+	}
+}
+
+func hasToleration(tolerations []corev1.Toleration, toleration *corev1.Toleration) bool {
+	for i := range tolerations {
+		if tolerations[i].MatchToleration(toleration) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func labelRequirement(key string, values []string) corev1.NodeSelectorRequirement {
+	if len(values) == 0 {
+		return corev1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: corev1.NodeSelectorOpExists,
+		}
+	}
+
+	return corev1.NodeSelectorRequirement{
+		Key:      key,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   values,
+	}
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+
+	return copied
+}
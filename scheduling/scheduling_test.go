@@ -0,0 +1,96 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduling_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/gpunode"
+	"github.com/gpu-ninja/operator-utils/scheduling"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuilder(t *testing.T) {
+	podLabels := map[string]string{"app": "trainer"}
+
+	spec := corev1.PodSpec{
+		Tolerations: []corev1.Toleration{{
+			Key:      "nvidia.com/gpu",
+			Operator: corev1.TolerationOpExists,
+			Effect:   corev1.TaintEffectNoSchedule,
+		}},
+	}
+
+	scheduling.New().
+		RequireGPUs(gpunode.Requirements{Count: 1, Products: []string{"NVIDIA-A100-SXM4-80GB"}}).
+		RequireZones("us-east-1a", "us-east-1b").
+		PreferNodeLabel(50, "node.kubernetes.io/instance-type", "p4d.24xlarge").
+		TolerateTaint("dedicated", "ml", corev1.TaintEffectNoSchedule).
+		SpreadAcrossZones(podLabels, 1).
+		AvoidColocation(corev1.LabelHostname, podLabels, false).
+		Apply(&spec)
+
+	assert.Equal(t, &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{
+						{Key: gpunode.LabelNVIDIAProduct, Operator: corev1.NodeSelectorOpIn, Values: []string{"NVIDIA-A100-SXM4-80GB"}},
+						{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"us-east-1a", "us-east-1b"}},
+					},
+				}},
+			},
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{{
+				Weight: 50,
+				Preference: corev1.NodeSelectorTerm{
+					MatchExpressions: []corev1.NodeSelectorRequirement{
+						{Key: "node.kubernetes.io/instance-type", Operator: corev1.NodeSelectorOpIn, Values: []string{"p4d.24xlarge"}},
+					},
+				},
+			}},
+		},
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					TopologyKey:   corev1.LabelHostname,
+					LabelSelector: &metav1.LabelSelector{MatchLabels: podLabels},
+				},
+			}},
+		},
+	}, spec.Affinity)
+
+	assert.Equal(t, []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelTopologyZone,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: podLabels},
+	}}, spec.TopologySpreadConstraints)
+
+	// The existing GPU toleration is not duplicated.
+	assert.Len(t, spec.Tolerations, 2)
+
+	t.Run("Empty", func(t *testing.T) {
+		var spec corev1.PodSpec
+		scheduling.New().Apply(&spec)
+
+		assert.Equal(t, corev1.PodSpec{}, spec)
+	})
+}
@@ -0,0 +1,153 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package schemeutil composes schemes from multiple AddToScheme functions,
+// detecting conflicting registrations with clear errors (rather than a
+// panic deep inside the scheme).
+package schemeutil
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+// AddToSchemeFunc registers types with a scheme.
+type AddToSchemeFunc func(*runtime.Scheme) error
+
+// DefaultAddToSchemeFuncs register the API groups that operators almost
+// always need (all of the built-in groups, eg. core, apps, batch, and rbac,
+// and apiextensions).
+var DefaultAddToSchemeFuncs = []AddToSchemeFunc{
+	clientgoscheme.AddToScheme,
+	apiextensionsv1.AddToScheme,
+}
+
+// ConflictError is returned when a kind is registered with different Go types.
+type ConflictError struct {
+	// GroupVersionKind is the conflicting kind.
+	GroupVersionKind schema.GroupVersionKind
+	// Existing is the type already registered for the kind.
+	Existing reflect.Type
+	// New is the type that was being registered.
+	New reflect.Type
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflicting registrations for %s: %s and %s",
+		e.GroupVersionKind, typeName(e.Existing), typeName(e.New))
+}
+
+// New returns a scheme containing the default API groups, and those
+// registered by the given functions.
+func New(funcs ...AddToSchemeFunc) (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme, append(append([]AddToSchemeFunc{}, DefaultAddToSchemeFuncs...), funcs...)...); err != nil {
+		return nil, err
+	}
+
+	return scheme, nil
+}
+
+// MustNew is like New but panics on error (eg. for package level scheme variables).
+func MustNew(funcs ...AddToSchemeFunc) *runtime.Scheme {
+	scheme, err := New(funcs...)
+	if err != nil {
+		panic(err)
+	}
+
+	return scheme
+}
+
+// AddToScheme registers types with the scheme using each of the functions
+// in turn. Before each function is applied, its registrations are checked
+// for conflicts with those already in the scheme. Registering the same
+// type for a kind more than once is allowed.
+func AddToScheme(scheme *runtime.Scheme, funcs ...AddToSchemeFunc) error {
+	for i, fn := range funcs {
+		probe := runtime.NewScheme()
+		if err := apply(fn, probe); err != nil {
+			return fmt.Errorf("failed to add to scheme (function %d): %w", i, err)
+		}
+
+		if err := checkConflicts(scheme, probe); err != nil {
+			return fmt.Errorf("failed to add to scheme (function %d): %w", i, err)
+		}
+
+		if err := apply(fn, scheme); err != nil {
+			return fmt.Errorf("failed to add to scheme (function %d): %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Compose returns a single function that registers types using each of the
+// functions (with conflict detection).
+func Compose(funcs ...AddToSchemeFunc) AddToSchemeFunc {
+	return func(scheme *runtime.Scheme) error {
+		return AddToScheme(scheme, funcs...)
+	}
+}
+
+func checkConflicts(scheme, probe *runtime.Scheme) error {
+	existingTypes := scheme.AllKnownTypes()
+
+	var conflicts []*ConflictError
+	for gvk, newType := range probe.AllKnownTypes() {
+		if existing, ok := existingTypes[gvk]; ok && existing != newType {
+			conflicts = append(conflicts, &ConflictError{GroupVersionKind: gvk, Existing: existing, New: newType})
+		}
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	// Report the first conflict deterministically.
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].GroupVersionKind.String() < conflicts[j].GroupVersionKind.String()
+	})
+
+	return conflicts[0]
+}
+
+// apply calls the function, converting scheme registration panics (eg. a
+// single function registering conflicting types) into errors.
+func apply(fn AddToSchemeFunc, scheme *runtime.Scheme) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return fn(scheme)
+}
+
+func typeName(t reflect.Type) string {
+	if t.PkgPath() == "" {
+		return t.String()
+	}
+
+	return strings.Join([]string{t.PkgPath(), t.Name()}, ".")
+}
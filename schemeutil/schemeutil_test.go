@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schemeutil_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/schemeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSchemeUtil(t *testing.T) {
+	widgetGVK := corev1.SchemeGroupVersion.WithKind("Widget")
+	addWidget := func(scheme *runtime.Scheme) error {
+		scheme.AddKnownTypeWithName(widgetGVK, &corev1.ConfigMap{})
+		return nil
+	}
+
+	scheme, err := schemeutil.New(addWidget)
+	require.NoError(t, err)
+
+	for _, obj := range []runtime.Object{&corev1.Pod{}, &appsv1.Deployment{}, &apiextensionsv1.CustomResourceDefinition{}} {
+		gvks, _, err := scheme.ObjectKinds(obj)
+		require.NoError(t, err)
+		assert.NotEmpty(t, gvks)
+	}
+	assert.True(t, scheme.Recognizes(widgetGVK))
+
+	t.Run("Duplicate", func(t *testing.T) {
+		// Registering the same types again is harmless.
+		assert.NoError(t, schemeutil.AddToScheme(scheme, appsv1.AddToScheme, addWidget))
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		conflicting := func(scheme *runtime.Scheme) error {
+			scheme.AddKnownTypeWithName(appsv1.SchemeGroupVersion.WithKind("Deployment"), &corev1.ConfigMap{})
+			return nil
+		}
+
+		_, err := schemeutil.New(schemeutil.Compose(conflicting))
+		require.Error(t, err)
+
+		var conflictErr *schemeutil.ConflictError
+		require.True(t, errors.As(err, &conflictErr))
+		assert.Equal(t, "conflicting registrations for apps/v1, Kind=Deployment: "+
+			"k8s.io/api/apps/v1.Deployment and k8s.io/api/core/v1.ConfigMap", conflictErr.Error())
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		conflicting := func(scheme *runtime.Scheme) error {
+			scheme.AddKnownTypeWithName(widgetGVK, &corev1.ConfigMap{})
+			scheme.AddKnownTypeWithName(widgetGVK, &corev1.Secret{})
+			return nil
+		}
+
+		assert.Error(t, schemeutil.AddToScheme(runtime.NewScheme(), conflicting))
+	})
+}
@@ -0,0 +1,280 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package scopedclient provides a client that restricts all operations to
+// a set of allowed namespaces. It is a client side safety net for
+// multi-tenant deployments (the API server's RBAC remains authoritative).
+package scopedclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Option configures a scoped client.
+type Option func(*scopedClient)
+
+// WithDefaultNamespace sets the namespace that is injected into operations
+// on namespaced objects that don't specify a namespace (including lists).
+func WithDefaultNamespace(namespace string) Option {
+	return func(c *scopedClient) {
+		c.defaultNamespace = namespace
+	}
+}
+
+// WithClusterScopedReads allows reading (getting and listing) cluster scoped
+// objects (eg. nodes, or storage classes). Writes to cluster scoped objects
+// are always rejected.
+func WithClusterScopedReads() Option {
+	return func(c *scopedClient) {
+		c.allowClusterScopedReads = true
+	}
+}
+
+type scopedClient struct {
+	client.Client
+	allowed                 map[string]bool
+	defaultNamespace        string
+	allowClusterScopedReads bool
+}
+
+// New returns a client that rejects operations outside of the allowed
+// namespaces with a Forbidden error (without contacting the API server).
+func New(c client.Client, namespaces []string, opts ...Option) client.Client {
+	sc := &scopedClient{
+		Client:  c,
+		allowed: make(map[string]bool),
+	}
+
+	for _, namespace := range namespaces {
+		sc.allowed[namespace] = true
+	}
+
+	for _, opt := range opts {
+		opt(sc)
+	}
+
+	if sc.defaultNamespace == "" && len(namespaces) == 1 {
+		sc.defaultNamespace = namespaces[0]
+	}
+
+	return sc
+}
+
+func (c *scopedClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	namespace, err := c.check(obj, key.Namespace, key.Name, true)
+	if err != nil {
+		return err
+	}
+	key.Namespace = namespace
+
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *scopedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	namespace, err := c.check(list, listOpts.Namespace, "", true)
+	if err != nil {
+		return err
+	}
+
+	if namespace != listOpts.Namespace {
+		opts = append(opts, client.InNamespace(namespace))
+	}
+
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *scopedClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.checkObject(obj); err != nil {
+		return err
+	}
+
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *scopedClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.checkObject(obj); err != nil {
+		return err
+	}
+
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *scopedClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.checkObject(obj); err != nil {
+		return err
+	}
+
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *scopedClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := c.checkObject(obj); err != nil {
+		return err
+	}
+
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *scopedClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	deleteAllOfOpts := client.DeleteAllOfOptions{}
+	deleteAllOfOpts.ApplyOptions(opts)
+
+	namespace, err := c.check(obj, deleteAllOfOpts.Namespace, "", false)
+	if err != nil {
+		return err
+	}
+
+	if namespace != deleteAllOfOpts.Namespace {
+		opts = append(opts, client.InNamespace(namespace))
+	}
+
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *scopedClient) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *scopedClient) SubResource(subResource string) client.SubResourceClient {
+	return &scopedSubResourceClient{
+		SubResourceClient: c.Client.SubResource(subResource),
+		parent:            c,
+	}
+}
+
+// checkObject checks (and defaults) the namespace of an object being written.
+func (c *scopedClient) checkObject(obj client.Object) error {
+	namespace, err := c.check(obj, obj.GetNamespace(), obj.GetName(), false)
+	if err != nil {
+		return err
+	}
+
+	obj.SetNamespace(namespace)
+
+	return nil
+}
+
+// check returns the (defaulted) namespace of an operation, or a Forbidden
+// error if the operation is not allowed.
+func (c *scopedClient) check(obj runtime.Object, namespace, name string, read bool) (string, error) {
+	namespaced, err := c.isNamespaced(obj)
+	if err != nil {
+		return "", err
+	}
+
+	if !namespaced {
+		if read && c.allowClusterScopedReads {
+			return namespace, nil
+		}
+
+		return "", c.forbidden(obj, name, fmt.Errorf("cluster scoped resources are not allowed"))
+	}
+
+	if namespace == "" {
+		if c.defaultNamespace == "" {
+			return "", c.forbidden(obj, name, fmt.Errorf("operations across all namespaces are not allowed"))
+		}
+
+		namespace = c.defaultNamespace
+	}
+
+	if !c.allowed[namespace] {
+		return "", c.forbidden(obj, name, fmt.Errorf("namespace %q is not allowed", namespace))
+	}
+
+	return namespace, nil
+}
+
+func (c *scopedClient) isNamespaced(obj runtime.Object) (bool, error) {
+	mapping, err := c.restMapping(obj)
+	if err != nil {
+		return false, fmt.Errorf("failed to determine if object is namespaced: %w", err)
+	}
+
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// restMapping returns the rest mapping of an object (or of the items of a list).
+func (c *scopedClient) restMapping(obj runtime.Object) (*meta.RESTMapping, error) {
+	gvk, err := c.Client.GroupVersionKindFor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.IsListType(obj) {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+
+	return c.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+func (c *scopedClient) forbidden(obj runtime.Object, name string, err error) error {
+	gr := schema.GroupResource{}
+	if mapping, mappingErr := c.restMapping(obj); mappingErr == nil {
+		gr = mapping.Resource.GroupResource()
+	}
+
+	return apierrors.NewForbidden(gr, name, err)
+}
+
+type scopedSubResourceClient struct {
+	client.SubResourceClient
+	parent *scopedClient
+}
+
+func (c *scopedSubResourceClient) Get(ctx context.Context, obj, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	if err := c.parent.checkObject(obj); err != nil {
+		return err
+	}
+
+	return c.SubResourceClient.Get(ctx, obj, subResource, opts...)
+}
+
+func (c *scopedSubResourceClient) Create(ctx context.Context, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	if err := c.parent.checkObject(obj); err != nil {
+		return err
+	}
+
+	return c.SubResourceClient.Create(ctx, obj, subResource, opts...)
+}
+
+func (c *scopedSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if err := c.parent.checkObject(obj); err != nil {
+		return err
+	}
+
+	return c.SubResourceClient.Update(ctx, obj, opts...)
+}
+
+func (c *scopedSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	if err := c.parent.checkObject(obj); err != nil {
+		return err
+	}
+
+	return c.SubResourceClient.Patch(ctx, obj, patch, opts...)
+}
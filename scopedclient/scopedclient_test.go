@@ -0,0 +1,100 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scopedclient_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/scopedclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScopedClient(t *testing.T) {
+	ctx := context.Background()
+
+	allowed := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "config"}}
+	denied := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-b", Name: "config"}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Node"), meta.RESTScopeRoot)
+
+	base := fake.NewClientBuilder().WithRESTMapper(mapper).WithObjects(allowed, denied, node).WithStatusSubresource(allowed).Build()
+
+	c := scopedclient.New(base, []string{"tenant-a"})
+
+	t.Run("Get", func(t *testing.T) {
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(allowed), &corev1.ConfigMap{}))
+
+		err := c.Get(ctx, client.ObjectKeyFromObject(denied), &corev1.ConfigMap{})
+		assert.True(t, apierrors.IsForbidden(err))
+		assert.Contains(t, err.Error(), `configmaps "config" is forbidden: namespace "tenant-b" is not allowed`)
+
+		// The default namespace is injected.
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "config"}, &corev1.ConfigMap{}))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		var configMaps corev1.ConfigMapList
+		require.NoError(t, c.List(ctx, &configMaps))
+		require.Len(t, configMaps.Items, 1)
+		assert.Equal(t, "tenant-a", configMaps.Items[0].Namespace)
+
+		err := c.List(ctx, &configMaps, client.InNamespace("tenant-b"))
+		assert.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		created := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "created"}}
+		require.NoError(t, c.Create(ctx, created))
+		assert.Equal(t, "tenant-a", created.Namespace)
+
+		err := c.Delete(ctx, denied.DeepCopy())
+		assert.True(t, apierrors.IsForbidden(err))
+
+		err = c.Status().Update(ctx, denied.DeepCopy())
+		assert.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("Cluster Scoped", func(t *testing.T) {
+		err := c.Get(ctx, client.ObjectKeyFromObject(node), &corev1.Node{})
+		assert.True(t, apierrors.IsForbidden(err))
+
+		c := scopedclient.New(base, []string{"tenant-a"}, scopedclient.WithClusterScopedReads())
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(node), &corev1.Node{}))
+
+		err = c.Delete(ctx, node.DeepCopy())
+		assert.True(t, apierrors.IsForbidden(err))
+	})
+
+	t.Run("All Namespaces", func(t *testing.T) {
+		c := scopedclient.New(base, []string{"tenant-a", "tenant-b"})
+
+		err := c.List(ctx, &corev1.ConfigMapList{})
+		assert.True(t, apierrors.IsForbidden(err))
+	})
+}
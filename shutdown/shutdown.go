@@ -0,0 +1,295 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package shutdown coordinates the graceful shutdown of an operator, so that
+// in-flight reconciles are allowed to complete rather than being abandoned
+// part way through (eg. leaving half applied sets of children).
+//
+// Typical usage:
+//
+//	coordinator := shutdown.NewCoordinator(shutdown.Options{GracePeriod: 30 * time.Second})
+//	coordinator.OnShutdown("flush-logs", func(ctx context.Context) error { return logger.Sync() })
+//
+//	err := ctrl.NewControllerManagedBy(mgr).For(&v1.Foo{}).Complete(coordinator.Wrap(reconciler))
+//	...
+//	_ = mgr.AddMetricsExtraHandler("/prestop", coordinator.PreStopHandler())
+//
+//	if err := mgr.Start(coordinator.SetupSignalHandler()); err != nil { ... }
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	DefaultGracePeriod = 25 * time.Second
+	DefaultHookTimeout = 5 * time.Second
+)
+
+// Hook is a cleanup hook run during shutdown.
+type Hook func(ctx context.Context) error
+
+// Options configures the coordinator.
+type Options struct {
+	// GracePeriod is how long to wait for in-flight reconciles to complete.
+	// It should be less than the pod's terminationGracePeriodSeconds.
+	GracePeriod time.Duration
+	// HookTimeout is how long cleanup hooks are given to complete.
+	HookTimeout time.Duration
+	// RequeueAfter is the delay used to requeue work rejected while shutting
+	// down (it will be picked up by the next leader, if any).
+	RequeueAfter time.Duration
+}
+
+type hook struct {
+	name string
+	fn   Hook
+}
+
+// Coordinator coordinates a graceful shutdown.
+type Coordinator struct {
+	opts Options
+
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+	hooks    []hook
+
+	// abort is closed when the grace period expires, cancelling any
+	// remaining in-flight reconciles.
+	abort     chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	cancel    context.CancelFunc
+}
+
+// NewCoordinator returns a new shutdown coordinator.
+func NewCoordinator(opts Options) *Coordinator {
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = DefaultGracePeriod
+	}
+
+	if opts.HookTimeout == 0 {
+		opts.HookTimeout = DefaultHookTimeout
+	}
+
+	if opts.RequeueAfter == 0 {
+		opts.RequeueAfter = time.Second
+	}
+
+	return &Coordinator{
+		opts:  opts,
+		abort: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// OnShutdown registers a cleanup hook, hooks are run in registration order
+// once in-flight reconciles have completed (eg. to flush events or logs).
+func (c *Coordinator) OnShutdown(name string, fn Hook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks = append(c.hooks, hook{name: name, fn: fn})
+}
+
+// Wrap wraps a reconciler so that its in-flight reconciles are tracked. Once
+// shutdown begins new reconciles are rejected (and requeued), and in-flight
+// reconciles are shielded from manager cancellation until the grace period
+// expires (although deadlines, eg. reconcile timeouts, still apply).
+func (c *Coordinator) Wrap(r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		if !c.acquire() {
+			return reconcile.Result{RequeueAfter: c.opts.RequeueAfter}, nil
+		}
+		defer c.release()
+
+		reconcileCtx, cancel := detach(ctx)
+		defer cancel()
+
+		go func() {
+			select {
+			case <-c.abort:
+				cancel()
+			case <-reconcileCtx.Done():
+			}
+		}()
+
+		return r.Reconcile(reconcileCtx, req)
+	})
+}
+
+// SetupSignalHandler returns a context for the manager that is cancelled
+// once a graceful shutdown triggered by SIGTERM or SIGINT completes. A
+// second signal exits immediately.
+func (c *Coordinator) SetupSignalHandler() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.mu.Lock()
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		<-signals
+		go c.Shutdown(ctx)
+		<-signals
+		os.Exit(1)
+	}()
+
+	return ctx
+}
+
+// PreStopHandler returns an HTTP handler suitable for a preStop hook, it
+// triggers a graceful shutdown and blocks until it has completed.
+func (c *Coordinator) PreStopHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := c.Shutdown(req.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Shutdown stops new work from being accepted, waits for in-flight
+// reconciles to complete (up to the grace period), runs the cleanup hooks,
+// and finally cancels the manager context (if SetupSignalHandler was used).
+// It is safe to call multiple times, subsequent calls wait for the first to
+// complete.
+func (c *Coordinator) Shutdown(ctx context.Context) error {
+	c.startOnce.Do(func() {
+		go c.shutdown(log.IntoContext(context.Background(), log.FromContext(ctx)))
+	})
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed waiting for shutdown: %w", ctx.Err())
+	}
+}
+
+// Done returns a channel that is closed once shutdown has completed.
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Coordinator) shutdown(ctx context.Context) {
+	defer close(c.done)
+
+	logger := log.FromContext(ctx)
+
+	c.mu.Lock()
+	c.draining = true
+	inFlight := c.inFlight
+	idle := c.idle
+	c.mu.Unlock()
+
+	logger.Info("Shutting down, waiting for in-flight reconciles", "inFlight", inFlight)
+
+	if idle != nil {
+		timer := time.NewTimer(c.opts.GracePeriod)
+		select {
+		case <-idle:
+			timer.Stop()
+		case <-timer.C:
+			logger.Info("Grace period expired, cancelling in-flight reconciles")
+			close(c.abort)
+		}
+	}
+
+	c.mu.Lock()
+	hooks := c.hooks
+	cancel := c.cancel
+	c.mu.Unlock()
+
+	for _, h := range hooks {
+		hookCtx, cancelHook := context.WithTimeout(ctx, c.opts.HookTimeout)
+		if err := h.fn(hookCtx); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error(err, "Shutdown hook failed", "hook", h.name)
+		}
+		cancelHook()
+	}
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (c *Coordinator) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.draining {
+		return false
+	}
+
+	if c.inFlight == 0 {
+		c.idle = make(chan struct{})
+	}
+	c.inFlight++
+
+	return true
+}
+
+func (c *Coordinator) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inFlight--
+	if c.inFlight == 0 {
+		close(c.idle)
+		if !c.draining {
+			c.idle = nil
+		}
+	}
+}
+
+// detach returns a context that carries the values (eg. the logger) and the
+// deadline of the given context, but not its cancellation.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detachedContext{ctx}, deadline)
+	}
+
+	return context.WithCancel(detachedContext{ctx})
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (d detachedContext) Value(key any) any         { return d.parent.Value(key) }
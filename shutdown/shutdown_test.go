@@ -0,0 +1,126 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shutdown_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/shutdown"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestCoordinator(t *testing.T) {
+	c := shutdown.NewCoordinator(shutdown.Options{GracePeriod: 5 * time.Second})
+
+	var hooksRun []string
+	c.OnShutdown("flush", func(ctx context.Context) error {
+		hooksRun = append(hooksRun, "flush")
+		return nil
+	})
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	r := c.Wrap(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		close(started)
+		<-finish
+		// The reconcile is shielded from the manager's cancellation.
+		return reconcile.Result{}, ctx.Err()
+	}))
+
+	managerCtx, cancelManager := context.WithCancel(context.Background())
+
+	reconcileErr := make(chan error)
+	go func() {
+		_, err := r.Reconcile(managerCtx, reconcile.Request{})
+		reconcileErr <- err
+	}()
+	<-started
+
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownErr <- c.Shutdown(context.Background())
+	}()
+
+	// New work is rejected once shutting down.
+	require.Eventually(t, func() bool {
+		res, err := c.Wrap(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+			return reconcile.Result{}, nil
+		})).Reconcile(context.Background(), reconcile.Request{})
+		return err == nil && res.RequeueAfter > 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancelManager()
+
+	select {
+	case <-c.Done():
+		t.Fatal("shutdown completed before in-flight reconcile")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(finish)
+	require.NoError(t, <-reconcileErr)
+	require.NoError(t, <-shutdownErr)
+
+	assert.Equal(t, []string{"flush"}, hooksRun)
+}
+
+func TestDeadline(t *testing.T) {
+	c := shutdown.NewCoordinator(shutdown.Options{})
+
+	r := c.Wrap(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		<-ctx.Done()
+		return reconcile.Result{}, ctx.Err()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Deadlines aren't detached.
+	_, err := r.Reconcile(ctx, reconcile.Request{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestGracePeriodExpired(t *testing.T) {
+	c := shutdown.NewCoordinator(shutdown.Options{GracePeriod: 50 * time.Millisecond})
+
+	started := make(chan struct{})
+	r := c.Wrap(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		close(started)
+		<-ctx.Done()
+		return reconcile.Result{}, ctx.Err()
+	}))
+
+	reconcileErr := make(chan error)
+	go func() {
+		_, err := r.Reconcile(context.Background(), reconcile.Request{})
+		reconcileErr <- err
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	c.PreStopHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/prestop", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	require.ErrorIs(t, <-reconcileErr, context.Canceled)
+}
@@ -0,0 +1,361 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package stalestatus detects objects whose status has gone stale, ie. whose
+// status.observedGeneration has lagged metadata.generation for too long, or
+// whose Ready condition has been stuck false. Stale objects usually indicate
+// a silently wedged controller.
+package stalestatus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	DefaultThreshold     = 10 * time.Minute
+	DefaultCheckInterval = time.Minute
+)
+
+// Reasons an object's status is considered stale.
+const (
+	// ReasonGenerationNotObserved indicates status.observedGeneration has
+	// lagged metadata.generation for longer than the threshold.
+	ReasonGenerationNotObserved = "GenerationNotObserved"
+	// ReasonNotReady indicates the Ready condition has not been true for
+	// longer than the not ready threshold.
+	ReasonNotReady = "NotReady"
+)
+
+// Options configures a stale status detector.
+type Options struct {
+	// Threshold is how long the observed generation can lag before the
+	// object is considered stale.
+	Threshold time.Duration
+	// NotReadyThreshold, if set, is how long the Ready condition can be
+	// false (or unknown) without transitioning before the object is
+	// considered stale.
+	NotReadyThreshold time.Duration
+	// ConditionType is the type of the ready condition (defaults to "Ready").
+	ConditionType string
+	// CheckInterval is how often objects are checked.
+	CheckInterval time.Duration
+	// Recorder, if set, is used to record Warning events on stale objects.
+	Recorder *events.Recorder
+	// Scheme, if set, is used to list objects of kinds it recognizes as
+	// typed objects, so that they share the informer of any controllers
+	// watching them (rather than starting a second, unstructured, one).
+	// Defaults to the scheme of the reader (if it is a client).
+	Scheme *runtime.Scheme
+	// Clock is used to determine the current time (useful for testing).
+	Clock clock.WithTicker
+}
+
+// Stale is an object with a stale status.
+type Stale struct {
+	// Object is the stale object.
+	Object types.NamespacedName
+	// Reason is why the object is considered stale.
+	Reason string
+	// Since is when the object's status started lagging.
+	Since time.Time
+	// Message is a human readable description.
+	Message string
+}
+
+// Detector periodically checks objects of a kind for stale statuses.
+type Detector struct {
+	c    client.Reader
+	gvk  schema.GroupVersionKind
+	opts Options
+
+	mu sync.Mutex
+	// lagging tracks when each object was first seen with an unobserved generation.
+	lagging map[types.UID]lag
+}
+
+type lag struct {
+	generation int64
+	since      time.Time
+}
+
+var (
+	_ manager.Runnable               = (*Detector)(nil)
+	_ manager.LeaderElectionRunnable = (*Detector)(nil)
+)
+
+// NewDetector returns a new stale status detector for objects of the given kind.
+func NewDetector(c client.Reader, gvk schema.GroupVersionKind, opts Options) *Detector {
+	if opts.Threshold == 0 {
+		opts.Threshold = DefaultThreshold
+	}
+
+	if opts.ConditionType == "" {
+		opts.ConditionType = "Ready"
+	}
+
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = DefaultCheckInterval
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	if opts.Scheme == nil {
+		if c, ok := c.(client.Client); ok {
+			opts.Scheme = c.Scheme()
+		}
+	}
+
+	return &Detector{
+		c:       c,
+		gvk:     gvk,
+		opts:    opts,
+		lagging: make(map[types.UID]lag),
+	}
+}
+
+// Start implements manager.Runnable, checking objects every check interval.
+func (d *Detector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("kind", d.gvk.Kind)
+
+	ticker := d.opts.Clock.NewTicker(d.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			stale, err := d.Check(ctx)
+			if err != nil {
+				logger.Error(err, "Failed to check for stale statuses")
+				continue
+			}
+
+			for _, s := range stale {
+				logger.Info("Object status is stale", "object", s.Object, "reason", s.Reason, "since", s.Since)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, as only the
+// leader reconciles objects.
+func (d *Detector) NeedLeaderElection() bool {
+	return true
+}
+
+// Check checks all objects of the kind, updating metrics and recording
+// events for any that are stale.
+func (d *Detector) Check(ctx context.Context) ([]Stale, error) {
+	items, err := d.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", d.gvk.Kind, err)
+	}
+
+	now := d.opts.Clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := make(map[types.UID]bool)
+	counts := map[string]int{
+		ReasonGenerationNotObserved: 0,
+		ReasonNotReady:              0,
+	}
+
+	var stale []Stale
+	for _, obj := range items {
+		seen[obj.GetUID()] = true
+
+		for _, s := range d.check(obj, now) {
+			counts[s.Reason]++
+			stale = append(stale, s)
+
+			if d.opts.Recorder != nil {
+				d.opts.Recorder.Warning(obj, events.ReasonStaleStatus, s.Message)
+			}
+		}
+	}
+
+	// Forget deleted objects.
+	for uid := range d.lagging {
+		if !seen[uid] {
+			delete(d.lagging, uid)
+		}
+	}
+
+	for reason, count := range counts {
+		metrics.StaleObjects.WithLabelValues(d.gvk.Kind, reason).Set(float64(count))
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].Object != stale[j].Object {
+			return stale[i].Object.String() < stale[j].Object.String()
+		}
+
+		return stale[i].Reason < stale[j].Reason
+	})
+
+	return stale, nil
+}
+
+// list returns all objects of the kind, listed as typed objects if the
+// scheme recognizes the kind.
+func (d *Detector) list(ctx context.Context) ([]*unstructured.Unstructured, error) {
+	listGVK := d.gvk.GroupVersion().WithKind(d.gvk.Kind + "List")
+
+	list := d.newTypedList(listGVK)
+	if list == nil {
+		var list unstructured.UnstructuredList
+		list.SetGroupVersionKind(listGVK)
+
+		if err := d.c.List(ctx, &list); err != nil {
+			return nil, err
+		}
+
+		items := make([]*unstructured.Unstructured, 0, len(list.Items))
+		for i := range list.Items {
+			items = append(items, &list.Items[i])
+		}
+
+		return items, nil
+	}
+
+	if err := d.c.List(ctx, list); err != nil {
+		return nil, err
+	}
+
+	var items []*unstructured.Unstructured
+	err := meta.EachListItem(list, func(item runtime.Object) error {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(item)
+		if err != nil {
+			return fmt.Errorf("failed to convert to unstructured: %w", err)
+		}
+
+		u := &unstructured.Unstructured{Object: content}
+		u.SetGroupVersionKind(d.gvk)
+		items = append(items, u)
+
+		return nil
+	})
+
+	return items, err
+}
+
+// newTypedList returns a new typed list of the given kind, or nil if the
+// scheme doesn't have a typed list for it.
+func (d *Detector) newTypedList(listGVK schema.GroupVersionKind) client.ObjectList {
+	if d.opts.Scheme == nil || !d.opts.Scheme.Recognizes(listGVK) {
+		return nil
+	}
+
+	obj, err := d.opts.Scheme.New(listGVK)
+	if err != nil {
+		return nil
+	}
+
+	if _, ok := obj.(runtime.Unstructured); ok {
+		return nil
+	}
+
+	list, _ := obj.(client.ObjectList)
+	return list
+}
+
+func (d *Detector) check(obj *unstructured.Unstructured, now time.Time) []Stale {
+	key := client.ObjectKeyFromObject(obj)
+
+	var stale []Stale
+
+	generation := obj.GetGeneration()
+	// Objects that don't report an observed generation can't lag.
+	observedGeneration, reported, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+
+	if reported && generation > observedGeneration && obj.GetDeletionTimestamp() == nil {
+		l, ok := d.lagging[obj.GetUID()]
+		if !ok || l.generation != generation {
+			// Measure from when the current generation was first seen.
+			l = lag{generation: generation, since: now}
+			d.lagging[obj.GetUID()] = l
+		}
+
+		if now.Sub(l.since) >= d.opts.Threshold {
+			stale = append(stale, Stale{
+				Object:  key,
+				Reason:  ReasonGenerationNotObserved,
+				Since:   l.since,
+				Message: fmt.Sprintf("Generation %d has not been observed (observed generation %d) since %s", generation, observedGeneration, l.since.UTC().Format(time.RFC3339)),
+			})
+		}
+	} else {
+		delete(d.lagging, obj.GetUID())
+	}
+
+	if d.opts.NotReadyThreshold > 0 {
+		if cond := findCondition(obj, d.opts.ConditionType); cond != nil && cond.Status != metav1.ConditionTrue {
+			since := cond.LastTransitionTime.Time
+			if !since.IsZero() && now.Sub(since) >= d.opts.NotReadyThreshold {
+				stale = append(stale, Stale{
+					Object:  key,
+					Reason:  ReasonNotReady,
+					Since:   since,
+					Message: fmt.Sprintf("%s condition has been %s since %s: %s", d.opts.ConditionType, cond.Status, since.UTC().Format(time.RFC3339), cond.Message),
+				})
+			}
+		}
+	}
+
+	return stale
+}
+
+func findCondition(obj *unstructured.Unstructured, conditionType string) *metav1.Condition {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		m, ok := c.(map[string]any)
+		if !ok || m["type"] != conditionType {
+			continue
+		}
+
+		var cond metav1.Condition
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &cond); err != nil {
+			return nil
+		}
+
+		return &cond
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package stalestatus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"github.com/gpu-ninja/operator-utils/stalestatus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var widgetGVK = schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+
+func TestDetector(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakeClock(now)
+
+	wedged := newWidget("wedged", 3, 2, "False", now.Add(-time.Hour))
+	healthy := newWidget("healthy", 2, 2, "True", now.Add(-time.Hour))
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(widgetGVK, meta.RESTScopeNamespace)
+
+	c := fake.NewClientBuilder().
+		WithRESTMapper(mapper).
+		WithObjects(wedged, healthy).
+		Build()
+
+	fakeRecorder := record.NewFakeRecorder(10)
+
+	d := stalestatus.NewDetector(c, widgetGVK, stalestatus.Options{
+		Threshold:         10 * time.Minute,
+		NotReadyThreshold: 30 * time.Minute,
+		Recorder:          events.NewRecorder(fakeRecorder),
+		Clock:             clock,
+	})
+
+	// The generation lag is measured from when it was first seen.
+	stale, err := d.Check(ctx)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	assert.Equal(t, stalestatus.ReasonNotReady, stale[0].Reason)
+	assert.Equal(t, types.NamespacedName{Namespace: "default", Name: "wedged"}, stale[0].Object)
+
+	clock.Step(11 * time.Minute)
+
+	stale, err = d.Check(ctx)
+	require.NoError(t, err)
+	require.Len(t, stale, 2)
+	assert.Equal(t, stalestatus.ReasonGenerationNotObserved, stale[0].Reason)
+	assert.Equal(t, now, stale[0].Since)
+	assert.Contains(t, stale[0].Message, "Generation 3 has not been observed (observed generation 2) since 2023-10-01T12:00:00Z")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.StaleObjects.WithLabelValues("Widget", stalestatus.ReasonGenerationNotObserved)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.StaleObjects.WithLabelValues("Widget", stalestatus.ReasonNotReady)))
+
+	// Events are deduplicated by the recorder.
+	assert.Len(t, fakeRecorder.Events, 2)
+	assert.Contains(t, <-fakeRecorder.Events, "Warning StaleStatus Ready condition has been False since 2023-10-01T11:00:00Z: reconciling")
+
+	// The controller catches up.
+	require.NoError(t, unstructured.SetNestedField(wedged.Object, int64(3), "status", "observedGeneration"))
+	require.NoError(t, unstructured.SetNestedSlice(wedged.Object, []any{map[string]any{
+		"type": "Ready", "status": "True", "reason": "Ready", "lastTransitionTime": now.Format(time.RFC3339),
+	}}, "status", "conditions"))
+	require.NoError(t, c.Update(ctx, wedged))
+
+	stale, err = d.Check(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.StaleObjects.WithLabelValues("Widget", stalestatus.ReasonGenerationNotObserved)))
+}
+
+func TestDetectorSkipsUnreportedGenerations(t *testing.T) {
+	ctx := context.Background()
+
+	clock := clocktesting.NewFakeClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+
+	// Objects without a status.observedGeneration can't lag.
+	unreported := newWidget("unreported", 5, 0, "True", clock.Now())
+	unstructured.RemoveNestedField(unreported.Object, "status", "observedGeneration")
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(widgetGVK, meta.RESTScopeNamespace)
+
+	c := fake.NewClientBuilder().
+		WithRESTMapper(mapper).
+		WithObjects(unreported).
+		Build()
+
+	d := stalestatus.NewDetector(c, widgetGVK, stalestatus.Options{Clock: clock})
+
+	_, err := d.Check(ctx)
+	require.NoError(t, err)
+
+	clock.Step(time.Hour)
+
+	stale, err := d.Check(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+}
+
+func TestDetectorTypedObjects(t *testing.T) {
+	ctx := context.Background()
+
+	clock := clocktesting.NewFakeClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+
+	wedged := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "wedged", UID: "wedged", Generation: 2},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1},
+	}
+
+	var listed []client.ObjectList
+	c := interceptor.NewClient(fake.NewClientBuilder().WithObjects(wedged).Build(), interceptor.Funcs{
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			listed = append(listed, list)
+			return c.List(ctx, list, opts...)
+		},
+	})
+
+	d := stalestatus.NewDetector(c, appsv1.SchemeGroupVersion.WithKind("Deployment"), stalestatus.Options{Clock: clock})
+
+	_, err := d.Check(ctx)
+	require.NoError(t, err)
+
+	clock.Step(time.Hour)
+
+	stale, err := d.Check(ctx)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	assert.Equal(t, stalestatus.ReasonGenerationNotObserved, stale[0].Reason)
+
+	// Typed objects share the informer of controllers watching them.
+	require.NotEmpty(t, listed)
+	assert.IsType(t, &appsv1.DeploymentList{}, listed[0])
+}
+
+func newWidget(name string, generation, observedGeneration int64, ready string, lastTransitionTime time.Time) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(widgetGVK)
+	u.SetNamespace("default")
+	u.SetName(name)
+	u.SetUID(types.UID(name))
+	u.SetGeneration(generation)
+
+	_ = unstructured.SetNestedField(u.Object, observedGeneration, "status", "observedGeneration")
+	_ = unstructured.SetNestedSlice(u.Object, []any{map[string]any{
+		"type":               "Ready",
+		"status":             ready,
+		"reason":             "Reconciled",
+		"message":            "reconciling",
+		"lastTransitionTime": lastTransitionTime.Format(time.RFC3339),
+	}}, "status", "conditions")
+
+	return u
+}
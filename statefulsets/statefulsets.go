@@ -0,0 +1,319 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package statefulsets orchestrates per-ordinal operations on StatefulSets
+// (eg. acting on pod N, waiting for it to be ready, then proceeding to pod
+// N-1), and controlled rolling updates using the update partition.
+package statefulsets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	// ErrTimeout is returned when a pod is not ready within the timeout.
+	ErrTimeout = errors.New("timed out waiting for pod to be ready")
+	// ErrNotRollingUpdate is returned when partitioning a StatefulSet that
+	// does not use the RollingUpdate strategy.
+	ErrNotRollingUpdate = errors.New("statefulset does not use the rolling update strategy")
+)
+
+// Order is the order in which ordinals are visited.
+type Order int
+
+const (
+	// Descending visits the highest ordinal first (the order in which a
+	// StatefulSet performs rolling updates).
+	Descending Order = iota
+	// Ascending visits the lowest ordinal first.
+	Ascending
+)
+
+// Ordinal returns the ordinal of a StatefulSet's pod, and false if the pod
+// is not a member of the StatefulSet.
+func Ordinal(sts *appsv1.StatefulSet, pod *corev1.Pod) (int, bool) {
+	prefix := sts.Name + "-"
+	if !strings.HasPrefix(pod.Name, prefix) {
+		return 0, false
+	}
+
+	ordinal, err := strconv.Atoi(strings.TrimPrefix(pod.Name, prefix))
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+
+	return ordinal, true
+}
+
+// PodName returns the name of the pod with the given ordinal.
+func PodName(sts *appsv1.StatefulSet, ordinal int) string {
+	return fmt.Sprintf("%s-%d", sts.Name, ordinal)
+}
+
+// Replicas returns the desired number of replicas.
+func Replicas(sts *appsv1.StatefulSet) int {
+	if sts.Spec.Replicas == nil {
+		return 1
+	}
+
+	return int(*sts.Spec.Replicas)
+}
+
+// IsPodReady returns true if the pod is running, not being deleted, and ready.
+func IsPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || !pod.DeletionTimestamp.IsZero() {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// IsPodUpdated returns true if the pod is at the StatefulSet's update revision.
+func IsPodUpdated(sts *appsv1.StatefulSet, pod *corev1.Pod) bool {
+	return sts.Status.UpdateRevision != "" &&
+		pod.Labels[appsv1.ControllerRevisionHashLabelKey] == sts.Status.UpdateRevision
+}
+
+// Options configures per-ordinal operations.
+type Options struct {
+	// Order is the order in which ordinals are visited. Defaults to Descending.
+	Order Order
+	// Timeout is the maximum time to wait for each pod to be ready. Defaults to 10 minutes.
+	Timeout time.Duration
+	// PollInterval is the interval between pod status checks. Defaults to 2 seconds.
+	PollInterval time.Duration
+}
+
+// OrdinalFunc is an operation on the pod with the given ordinal.
+type OrdinalFunc func(ctx context.Context, ordinal int, pod *corev1.Pod) error
+
+// ForEachOrdinal performs the operation on each of the StatefulSet's pods
+// in turn, waiting for each pod to be ready again before proceeding to
+// the next. If an operation fails, or a pod does not become ready, the
+// remaining ordinals are not visited.
+func ForEachOrdinal(ctx context.Context, c client.Client, sts *appsv1.StatefulSet, fn OrdinalFunc, opts Options) error {
+	logger := log.FromContext(ctx)
+
+	setDefaults(&opts)
+
+	replicas := Replicas(sts)
+	for i := 0; i < replicas; i++ {
+		ordinal := replicas - 1 - i
+		if opts.Order == Ascending {
+			ordinal = i
+		}
+
+		key := types.NamespacedName{Namespace: sts.Namespace, Name: PodName(sts, ordinal)}
+
+		var pod corev1.Pod
+		if err := c.Get(ctx, key, &pod); err != nil {
+			return fmt.Errorf("failed to get pod %q: %w", key.Name, err)
+		}
+
+		logger.Info("Running operation on ordinal", "ordinal", ordinal)
+
+		if err := fn(ctx, ordinal, &pod); err != nil {
+			return fmt.Errorf("failed to run operation on ordinal %d: %w", ordinal, err)
+		}
+
+		if err := WaitForPodReady(ctx, c, key, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WaitForPodReady waits for the pod to exist and be ready (eg. after it
+// has been deleted and recreated by the StatefulSet controller).
+func WaitForPodReady(ctx context.Context, c client.Client, key types.NamespacedName, opts Options) error {
+	setDefaults(&opts)
+
+	err := wait.PollUntilContextTimeout(ctx, opts.PollInterval, opts.Timeout, true, func(ctx context.Context) (bool, error) {
+		var pod corev1.Pod
+		if err := c.Get(ctx, key, &pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+
+			return false, fmt.Errorf("failed to get pod %q: %w", key.Name, err)
+		}
+
+		return IsPodReady(&pod), nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) && ctx.Err() == nil {
+			return fmt.Errorf("%w: %s", ErrTimeout, key.Name)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Partition returns the StatefulSet's update partition.
+func Partition(sts *appsv1.StatefulSet) int {
+	if sts.Spec.UpdateStrategy.RollingUpdate == nil || sts.Spec.UpdateStrategy.RollingUpdate.Partition == nil {
+		return 0
+	}
+
+	return int(*sts.Spec.UpdateStrategy.RollingUpdate.Partition)
+}
+
+// SetPartition sets the update partition of a StatefulSet (template). Only
+// pods with an ordinal greater than or equal to the partition are updated.
+func SetPartition(sts *appsv1.StatefulSet, partition int) error {
+	if sts.Spec.UpdateStrategy.Type == "" {
+		sts.Spec.UpdateStrategy.Type = appsv1.RollingUpdateStatefulSetStrategyType
+	}
+
+	if sts.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		return ErrNotRollingUpdate
+	}
+
+	if sts.Spec.UpdateStrategy.RollingUpdate == nil {
+		sts.Spec.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{}
+	}
+
+	sts.Spec.UpdateStrategy.RollingUpdate.Partition = ptr.To(int32(partition))
+
+	return nil
+}
+
+// HoldUpdates sets the partition of a StatefulSet (template) to its
+// replicas, so that changes to the pod template are not rolled out until
+// released by StepRollout.
+func HoldUpdates(sts *appsv1.StatefulSet) error {
+	return SetPartition(sts, Replicas(sts))
+}
+
+// RolloutStatus is the status of a partitioned rolling update.
+type RolloutStatus struct {
+	// Partition is the current partition.
+	Partition int
+	// Complete is true if every pod is updated and ready.
+	Complete bool
+	// Waiting is the ordinal being waited on (if any).
+	Waiting *int
+}
+
+// StepRollout advances a controlled rolling update by at most one ordinal.
+// If every pod at or above the current partition is updated and ready, the
+// partition is lowered by one (releasing the next ordinal). It does not
+// block, so it should be called on each reconcile until the rollout is
+// complete. The optional gate is called before each ordinal is released
+// (eg. to check cluster health), and the step is skipped if it returns false.
+func StepRollout(ctx context.Context, c client.Client, sts *appsv1.StatefulSet, gate func(ctx context.Context, ordinal int) (bool, error)) (*RolloutStatus, error) {
+	if sts.Spec.UpdateStrategy.Type != "" && sts.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		return nil, ErrNotRollingUpdate
+	}
+
+	partition := Partition(sts)
+	replicas := Replicas(sts)
+	if partition > replicas {
+		partition = replicas
+	}
+
+	status := &RolloutStatus{Partition: partition}
+
+	if sts.Status.ObservedGeneration < sts.Generation {
+		status.Waiting = ptr.To(partition)
+		return status, nil
+	}
+
+	for ordinal := replicas - 1; ordinal >= partition; ordinal-- {
+		var pod corev1.Pod
+		if err := c.Get(ctx, types.NamespacedName{Namespace: sts.Namespace, Name: PodName(sts, ordinal)}, &pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				status.Waiting = ptr.To(ordinal)
+				return status, nil
+			}
+
+			return nil, fmt.Errorf("failed to get pod: %w", err)
+		}
+
+		if !IsPodUpdated(sts, &pod) || !IsPodReady(&pod) {
+			status.Waiting = ptr.To(ordinal)
+			return status, nil
+		}
+	}
+
+	if partition == 0 {
+		status.Complete = true
+		return status, nil
+	}
+
+	next := partition - 1
+	if gate != nil {
+		ok, err := gate(ctx, next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check gate for ordinal %d: %w", next, err)
+		}
+
+		if !ok {
+			status.Waiting = ptr.To(next)
+			return status, nil
+		}
+	}
+
+	patch := client.MergeFrom(sts.DeepCopy())
+	if err := SetPartition(sts, next); err != nil {
+		return nil, err
+	}
+
+	if err := c.Patch(ctx, sts, patch); err != nil {
+		return nil, fmt.Errorf("failed to update partition: %w", err)
+	}
+
+	log.FromContext(ctx).Info("Released ordinal for update", "ordinal", next)
+
+	status.Partition = next
+	status.Waiting = ptr.To(next)
+
+	return status, nil
+}
+
+func setDefaults(opts *Options) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Minute
+	}
+
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+}
@@ -0,0 +1,184 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package statefulsets_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/statefulsets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPod(ordinal int, revision string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      fmt.Sprintf("db-%d", ordinal),
+			Labels:    map[string]string{appsv1.ControllerRevisionHashLabelKey: revision},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func newStatefulSet() *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: ptr.To(int32(3))},
+		Status:     appsv1.StatefulSetStatus{UpdateRevision: "v2"},
+	}
+}
+
+func TestOrdinal(t *testing.T) {
+	sts := newStatefulSet()
+
+	ordinal, ok := statefulsets.Ordinal(sts, newPod(2, "", false))
+	assert.True(t, ok)
+	assert.Equal(t, 2, ordinal)
+
+	_, ok = statefulsets.Ordinal(sts, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-backup-0"}})
+	assert.False(t, ok)
+
+	assert.Equal(t, "db-1", statefulsets.PodName(sts, 1))
+}
+
+func TestForEachOrdinal(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(newPod(0, "v1", true), newPod(1, "v1", true), newPod(2, "v1", true)).
+		Build()
+
+	sts := newStatefulSet()
+	opts := statefulsets.Options{Timeout: 100 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+
+	var visited []int
+	err := statefulsets.ForEachOrdinal(ctx, c, sts, func(ctx context.Context, ordinal int, pod *corev1.Pod) error {
+		visited = append(visited, ordinal)
+		return nil
+	}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1, 0}, visited)
+
+	visited = nil
+	opts.Order = statefulsets.Ascending
+	err = statefulsets.ForEachOrdinal(ctx, c, sts, func(ctx context.Context, ordinal int, pod *corev1.Pod) error {
+		visited = append(visited, ordinal)
+
+		// Ordinal 1 never becomes ready again.
+		if ordinal == 1 {
+			pod.Status.Conditions[0].Status = corev1.ConditionFalse
+			return c.Status().Update(ctx, pod)
+		}
+
+		return nil
+	}, opts)
+	require.ErrorIs(t, err, statefulsets.ErrTimeout)
+	assert.Equal(t, []int{0, 1}, visited)
+}
+
+func TestStepRollout(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	sts := newStatefulSet()
+	require.NoError(t, statefulsets.HoldUpdates(sts))
+	assert.Equal(t, 3, statefulsets.Partition(sts))
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(sts, newPod(0, "v1", true), newPod(1, "v1", true), newPod(2, "v1", true)).
+		Build()
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(sts), sts))
+
+	// Releases ordinal 2.
+	status, err := statefulsets.StepRollout(ctx, c, sts, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Partition)
+	assert.Equal(t, 2, *status.Waiting)
+
+	// Ordinal 2 has not been updated yet.
+	status, err = statefulsets.StepRollout(ctx, c, sts, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Partition)
+	assert.Equal(t, 2, *status.Waiting)
+
+	require.NoError(t, c.Update(ctx, newPod(2, "v2", true)))
+
+	// The gate holds ordinal 1.
+	status, err = statefulsets.StepRollout(ctx, c, sts, func(ctx context.Context, ordinal int) (bool, error) {
+		return false, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Partition)
+	assert.Equal(t, 1, *status.Waiting)
+
+	status, err = statefulsets.StepRollout(ctx, c, sts, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Partition)
+
+	var updated appsv1.StatefulSet
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(sts), &updated))
+	assert.Equal(t, 1, statefulsets.Partition(&updated))
+
+	require.NoError(t, c.Update(ctx, newPod(1, "v2", true)))
+
+	status, err = statefulsets.StepRollout(ctx, c, sts, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Partition)
+
+	require.NoError(t, c.Update(ctx, newPod(0, "v2", true)))
+
+	status, err = statefulsets.StepRollout(ctx, c, sts, nil)
+	require.NoError(t, err)
+	assert.True(t, status.Complete)
+	assert.Nil(t, status.Waiting)
+
+	t.Run("OnDelete", func(t *testing.T) {
+		sts := newStatefulSet()
+		sts.Spec.UpdateStrategy.Type = appsv1.OnDeleteStatefulSetStrategyType
+
+		assert.ErrorIs(t, statefulsets.HoldUpdates(sts), statefulsets.ErrNotRollingUpdate)
+	})
+}
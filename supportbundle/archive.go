@@ -0,0 +1,270 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supportbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// RequestAnnotation requests that a support bundle be collected for the
+	// annotated object (eg. kubectl annotate widget/foo gpu-ninja.com/support-bundle=true).
+	RequestAnnotation = "gpu-ninja.com/support-bundle"
+	// BundleKey is the key of the bundle archive in the bundle ConfigMap.
+	BundleKey = "bundle.tar.gz"
+	// BundleLabel labels bundle ConfigMaps, so that they are left out of
+	// later bundles.
+	BundleLabel = "gpu-ninja.com/support-bundle"
+	// MaxArchiveSize is the maximum size of a bundle archive (leaving room
+	// for metadata within the 1MiB ConfigMap limit).
+	MaxArchiveSize = 1000 * 1024
+)
+
+// ErrBundleTooLarge is returned when a bundle archive exceeds MaxArchiveSize
+// (even without logs).
+var ErrBundleTooLarge = errors.New("support bundle is too large")
+
+// WriteYAML writes the objects and events as a multi-document YAML stream.
+func (b *Bundle) WriteYAML(w io.Writer) error {
+	for _, obj := range b.Objects {
+		if err := writeDocument(w, obj.Object); err != nil {
+			return err
+		}
+	}
+
+	for i := range b.Events {
+		if err := writeDocument(w, &b.Events[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteTar writes the bundle as a gzipped tar archive (with a file per
+// object, an events file, and a file per container log).
+func (b *Bundle) WriteTar(w io.Writer, modTime time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: modTime,
+		}); err != nil {
+			return fmt.Errorf("failed to write header for %q: %w", name, err)
+		}
+
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write %q: %w", name, err)
+		}
+
+		return nil
+	}
+
+	for _, obj := range b.Objects {
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return fmt.Errorf("failed to marshal object: %w", err)
+		}
+
+		if err := writeFile(objectPath(obj), data); err != nil {
+			return err
+		}
+	}
+
+	var events bytes.Buffer
+	for i := range b.Events {
+		if err := writeDocument(&events, &b.Events[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFile("events.yaml", events.Bytes()); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(b.Logs))
+	for key := range b.Logs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := writeFile("logs/"+key+".log", []byte(b.Logs[key])); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+
+	return nil
+}
+
+// IsRequested returns true if a support bundle has been requested for the object.
+func IsRequested(obj client.Object) bool {
+	_, ok := obj.GetAnnotations()[RequestAnnotation]
+	return ok
+}
+
+// HandleRequest collects a support bundle if one has been requested for the
+// parent (by the request annotation), stores it in a ConfigMap named
+// "<parent>-support-bundle" (owned by the parent), and removes the annotation.
+// It returns true if a bundle was collected. If the archive would exceed
+// MaxArchiveSize, logs are left out, and if it still would, the request is
+// removed and an error wrapping ErrBundleTooLarge is returned.
+func (c *Collector) HandleRequest(ctx context.Context, parent client.Object) (bool, error) {
+	if !IsRequested(parent) {
+		return false, nil
+	}
+
+	bundle, err := c.Collect(ctx, parent)
+	if err != nil {
+		return false, fmt.Errorf("failed to collect support bundle: %w", err)
+	}
+
+	archive, err := bundle.writeTarWithinLimit(time.Now())
+	if err != nil {
+		if errors.Is(err, ErrBundleTooLarge) {
+			// Retrying won't make the bundle any smaller.
+			if err := c.removeRequest(ctx, parent); err != nil {
+				return false, err
+			}
+		}
+
+		return false, err
+	}
+
+	parentObj, err := c.toUnstructured(parent)
+	if err != nil {
+		return false, err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: parent.GetNamespace(),
+			Name:      parent.GetName() + "-support-bundle",
+			Labels:    map[string]string{BundleLabel: "true"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: parentObj.GetAPIVersion(),
+				Kind:       parentObj.GetKind(),
+				Name:       parent.GetName(),
+				UID:        parent.GetUID(),
+			}},
+		},
+		BinaryData: map[string][]byte{BundleKey: archive.Bytes()},
+	}
+
+	if err := c.client.Create(ctx, cm); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("failed to create support bundle config map: %w", err)
+		}
+
+		if err := c.client.Update(ctx, cm); err != nil {
+			return false, fmt.Errorf("failed to update support bundle config map: %w", err)
+		}
+	}
+
+	if err := c.removeRequest(ctx, parent); err != nil {
+		return false, err
+	}
+
+	log.FromContext(ctx).Info("Collected support bundle", "configMap", cm.Name, "size", archive.Len())
+
+	return true, nil
+}
+
+func (c *Collector) removeRequest(ctx context.Context, parent client.Object) error {
+	patch := client.MergeFrom(parent.DeepCopyObject().(client.Object))
+
+	annotations := parent.GetAnnotations()
+	delete(annotations, RequestAnnotation)
+	parent.SetAnnotations(annotations)
+
+	if err := c.client.Patch(ctx, parent, patch); err != nil {
+		return fmt.Errorf("failed to remove support bundle request annotation: %w", err)
+	}
+
+	return nil
+}
+
+// writeTarWithinLimit writes the bundle archive, leaving out logs if it
+// would otherwise exceed MaxArchiveSize.
+func (b *Bundle) writeTarWithinLimit(modTime time.Time) (*bytes.Buffer, error) {
+	var archive bytes.Buffer
+	if err := b.WriteTar(&archive, modTime); err != nil {
+		return nil, fmt.Errorf("failed to write support bundle: %w", err)
+	}
+
+	if archive.Len() <= MaxArchiveSize {
+		return &archive, nil
+	}
+
+	withoutLogs := *b
+	withoutLogs.Logs = make(map[string]string, len(b.Logs))
+	for key := range b.Logs {
+		withoutLogs.Logs[key] = "logs omitted: support bundle exceeded the size limit"
+	}
+
+	archive.Reset()
+	if err := withoutLogs.WriteTar(&archive, modTime); err != nil {
+		return nil, fmt.Errorf("failed to write support bundle: %w", err)
+	}
+
+	if archive.Len() > MaxArchiveSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrBundleTooLarge, archive.Len())
+	}
+
+	return &archive, nil
+}
+
+func writeDocument(w io.Writer, obj any) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object: %w", err)
+	}
+
+	if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+
+	return nil
+}
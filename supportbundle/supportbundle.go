@@ -0,0 +1,338 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package supportbundle collects a custom resource, its managed children,
+// their recent events, and relevant pod logs into a redacted bundle for
+// troubleshooting.
+package supportbundle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Redacted replaces redacted values.
+const Redacted = "<redacted>"
+
+// DefaultChildKinds are the kinds searched for children (and grandchildren,
+// eg. the ReplicaSets and Pods of a Deployment) by default.
+var DefaultChildKinds = []schema.GroupVersionKind{
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+	{Group: "batch", Version: "v1", Kind: "Job"},
+	{Group: "", Version: "v1", Kind: "Pod"},
+	{Group: "", Version: "v1", Kind: "Service"},
+	{Group: "", Version: "v1", Kind: "ConfigMap"},
+	{Group: "", Version: "v1", Kind: "Secret"},
+	{Group: "", Version: "v1", Kind: "PersistentVolumeClaim"},
+}
+
+// Options configures a collector.
+type Options struct {
+	// ChildKinds are the kinds searched for children. Defaults to DefaultChildKinds.
+	ChildKinds []schema.GroupVersionKind
+	// Selector, if set, also includes objects (of the child kinds) with
+	// matching labels, in addition to those owned by the parent.
+	Selector labels.Selector
+	// LogClient is used to collect pod logs. If nil, logs are not collected.
+	LogClient kubernetes.Interface
+	// LogTailLines is the number of lines of logs collected from each container. Defaults to 500.
+	LogTailLines int64
+	// RedactKeys are additional keys (of ConfigMap data, or container env
+	// vars) whose values are redacted. Secret data is always redacted.
+	RedactKeys []string
+}
+
+// Bundle is a collection of troubleshooting information.
+type Bundle struct {
+	// Objects are the parent and its children.
+	Objects []*unstructured.Unstructured
+	// Events are the events involving the objects.
+	Events []corev1.Event
+	// Logs are the pod logs, keyed by "pod/container".
+	Logs map[string]string
+}
+
+// Collector collects support bundles.
+type Collector struct {
+	client client.Client
+	scheme *runtime.Scheme
+	opts   Options
+}
+
+// NewCollector returns a new support bundle collector.
+func NewCollector(c client.Client, scheme *runtime.Scheme, opts Options) *Collector {
+	if opts.ChildKinds == nil {
+		opts.ChildKinds = DefaultChildKinds
+	}
+
+	if opts.LogTailLines == 0 {
+		opts.LogTailLines = 500
+	}
+
+	return &Collector{
+		client: c,
+		scheme: scheme,
+		opts:   opts,
+	}
+}
+
+// Collect collects a support bundle for the parent. Children are found by
+// following owner references (transitively) from the parent. Previously
+// collected bundles (labeled with BundleLabel) are left out.
+func (c *Collector) Collect(ctx context.Context, parent client.Object) (*Bundle, error) {
+	parentObj, err := c.toUnstructured(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*unstructured.Unstructured
+	for _, gvk := range c.opts.ChildKinds {
+		var list unstructured.UnstructuredList
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+
+		if err := c.client.List(ctx, &list, client.InNamespace(parent.GetNamespace())); err != nil {
+			log.FromContext(ctx).Info("Skipping kind in support bundle", "kind", gvk.Kind, "error", err.Error())
+			continue
+		}
+
+		for i := range list.Items {
+			if _, ok := list.Items[i].GetLabels()[BundleLabel]; ok {
+				continue
+			}
+
+			candidates = append(candidates, &list.Items[i])
+		}
+	}
+
+	bundle := &Bundle{
+		Objects: []*unstructured.Unstructured{parentObj},
+		Logs:    make(map[string]string),
+	}
+
+	included := map[types.UID]bool{parent.GetUID(): true}
+	for _, obj := range candidates {
+		if c.opts.Selector != nil && c.opts.Selector.Matches(labels.Set(obj.GetLabels())) {
+			included[obj.GetUID()] = true
+		}
+	}
+
+	// Follow owner references until no more children are found.
+	for changed := true; changed; {
+		changed = false
+		for _, obj := range candidates {
+			if included[obj.GetUID()] {
+				continue
+			}
+
+			for _, ref := range obj.GetOwnerReferences() {
+				if included[ref.UID] {
+					included[obj.GetUID()] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	for _, obj := range candidates {
+		if included[obj.GetUID()] && obj.GetUID() != parent.GetUID() {
+			bundle.Objects = append(bundle.Objects, obj)
+		}
+	}
+
+	var events corev1.EventList
+	if err := c.client.List(ctx, &events, client.InNamespace(parent.GetNamespace())); err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	for _, event := range events.Items {
+		if included[event.InvolvedObject.UID] {
+			bundle.Events = append(bundle.Events, event)
+		}
+	}
+
+	sort.SliceStable(bundle.Events, func(i, j int) bool {
+		return bundle.Events[i].LastTimestamp.Before(&bundle.Events[j].LastTimestamp)
+	})
+
+	if c.opts.LogClient != nil {
+		for _, obj := range bundle.Objects {
+			if obj.GetKind() == "Pod" && obj.GetAPIVersion() == "v1" {
+				if err := c.collectLogs(ctx, obj, bundle); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for _, obj := range bundle.Objects {
+		c.redact(obj)
+	}
+
+	return bundle, nil
+}
+
+func (c *Collector) collectLogs(ctx context.Context, obj *unstructured.Unstructured, bundle *Bundle) error {
+	var pod corev1.Pod
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pod); err != nil {
+		return fmt.Errorf("failed to convert pod: %w", err)
+	}
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		key := pod.Name + "/" + container.Name
+
+		stream, err := c.opts.LogClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: container.Name,
+			TailLines: ptr.To(c.opts.LogTailLines),
+		}).Stream(ctx)
+		if err != nil {
+			// Eg. the container hasn't started yet.
+			bundle.Logs[key] = fmt.Sprintf("failed to get logs: %s", err)
+			continue
+		}
+
+		logs, err := io.ReadAll(stream)
+		_ = stream.Close()
+		if err != nil {
+			bundle.Logs[key] = fmt.Sprintf("failed to read logs: %s", err)
+			continue
+		}
+
+		bundle.Logs[key] = string(logs)
+	}
+
+	return nil
+}
+
+// redact removes secret values (and noisy metadata) from an object.
+func (c *Collector) redact(obj *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+
+	// The last applied configuration would otherwise leak redacted values
+	// (eg. Secret data, or env vars).
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[corev1.LastAppliedConfigAnnotation]; ok {
+		annotations[corev1.LastAppliedConfigAnnotation] = Redacted
+		obj.SetAnnotations(annotations)
+	}
+
+	redactKeys := make(map[string]bool)
+	for _, key := range c.opts.RedactKeys {
+		redactKeys[key] = true
+	}
+
+	switch {
+	case obj.GetAPIVersion() == "v1" && obj.GetKind() == "Secret":
+		for _, field := range []string{"data", "stringData"} {
+			redactMap(obj.Object, func(string) bool { return true }, field)
+		}
+	case obj.GetAPIVersion() == "v1" && obj.GetKind() == "ConfigMap":
+		redactMap(obj.Object, func(key string) bool { return redactKeys[key] }, "data")
+	}
+
+	if len(redactKeys) > 0 {
+		redactEnv(obj.Object, redactKeys)
+	}
+}
+
+func redactMap(obj map[string]any, shouldRedact func(key string) bool, fields ...string) {
+	m, ok, _ := unstructured.NestedMap(obj, fields...)
+	if !ok {
+		return
+	}
+
+	for key := range m {
+		if shouldRedact(key) {
+			m[key] = Redacted
+		}
+	}
+
+	_ = unstructured.SetNestedMap(obj, m, fields...)
+}
+
+// redactEnv redacts the values of matching env vars anywhere in the object
+// (eg. in pod templates).
+func redactEnv(value any, redactKeys map[string]bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		if env, ok := v["env"].([]any); ok {
+			for _, item := range env {
+				if envVar, ok := item.(map[string]any); ok {
+					if name, ok := envVar["name"].(string); ok && redactKeys[name] {
+						if _, hasValue := envVar["value"]; hasValue {
+							envVar["value"] = Redacted
+						}
+					}
+				}
+			}
+		}
+
+		for _, child := range v {
+			redactEnv(child, redactKeys)
+		}
+	case []any:
+		for _, child := range v {
+			redactEnv(child, redactKeys)
+		}
+	}
+}
+
+func (c *Collector) toUnstructured(obj client.Object) (*unstructured.Unstructured, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gvk: %w", err)
+	}
+
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert to unstructured: %w", err)
+	}
+
+	u := &unstructured.Unstructured{Object: m}
+	u.SetGroupVersionKind(gvk)
+
+	return u, nil
+}
+
+// objectPath returns the path of an object in a bundle archive.
+func objectPath(obj *unstructured.Unstructured) string {
+	group := obj.GroupVersionKind().Group
+	if group == "" {
+		group = "core"
+	}
+
+	return fmt.Sprintf("objects/%s/%s/%s.yaml", group, strings.ToLower(obj.GetKind()), obj.GetName())
+}
@@ -0,0 +1,274 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supportbundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/supportbundle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCollector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	// Stands in for a custom resource.
+	parent := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "parent",
+			UID:         "parent-uid",
+			Annotations: map[string]string{supportbundle.RequestAnnotation: "true"},
+		},
+	}
+
+	ownedBy := func(kind, name string, uid types.UID) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, UID: uid}}
+	}
+
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "parent-abc",
+			UID:             "rs-uid",
+			OwnerReferences: ownedBy("Deployment", "parent", "parent-uid"),
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "parent-abc-xyz",
+			UID:             "pod-uid",
+			OwnerReferences: ownedBy("ReplicaSet", "parent-abc", "rs-uid"),
+			Annotations: map[string]string{
+				corev1.LastAppliedConfigAnnotation: `{"spec":{"containers":[{"env":[{"name":"PASSWORD","value":"hunter2"}]}]}}`,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "app",
+				Env: []corev1.EnvVar{
+					{Name: "PASSWORD", Value: "hunter2"},
+					{Name: "LOG_LEVEL", Value: "debug"},
+				},
+			}},
+		},
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "parent-credentials",
+			UID:             "secret-uid",
+			OwnerReferences: ownedBy("Deployment", "parent", "parent-uid"),
+		},
+		Data: map[string][]byte{"password": []byte("hunter2")},
+	}
+
+	unrelated := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "unrelated",
+			UID:       "unrelated-uid",
+		},
+	}
+
+	podEvent := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "pod-event"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod.Name, UID: pod.UID},
+		Reason:         "BackOff",
+	}
+
+	unrelatedEvent := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "unrelated-event"},
+		InvolvedObject: corev1.ObjectReference{Kind: "ConfigMap", Name: unrelated.Name, UID: unrelated.UID},
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(parent, replicaSet, pod, secret, unrelated, podEvent, unrelatedEvent).
+		Build()
+
+	collector := supportbundle.NewCollector(c, scheme, supportbundle.Options{
+		LogClient:  kubefake.NewSimpleClientset(pod),
+		RedactKeys: []string{"PASSWORD"},
+	})
+
+	ctx := context.Background()
+
+	bundle, err := collector.Collect(ctx, parent)
+	require.NoError(t, err)
+
+	var names []string
+	for _, obj := range bundle.Objects {
+		names = append(names, obj.GetKind()+"/"+obj.GetName())
+	}
+	assert.ElementsMatch(t, []string{
+		"Deployment/parent",
+		"ReplicaSet/parent-abc",
+		"Pod/parent-abc-xyz",
+		"Secret/parent-credentials",
+	}, names)
+
+	require.Len(t, bundle.Events, 1)
+	assert.Equal(t, "BackOff", bundle.Events[0].Reason)
+
+	assert.Equal(t, map[string]string{"parent-abc-xyz/app": "fake logs"}, bundle.Logs)
+
+	var yamlBuf bytes.Buffer
+	require.NoError(t, bundle.WriteYAML(&yamlBuf))
+
+	assert.NotContains(t, yamlBuf.String(), "hunter2")
+	assert.NotContains(t, yamlBuf.String(), "aHVudGVyMg==")
+	assert.Contains(t, yamlBuf.String(), "password: <redacted>")
+	assert.Contains(t, yamlBuf.String(), "value: debug")
+
+	t.Run("Archive", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, bundle.WriteTar(&buf, time.Now()))
+
+		files := readArchive(t, &buf)
+
+		assert.Contains(t, files, "objects/apps/deployment/parent.yaml")
+		assert.Contains(t, files, "objects/core/secret/parent-credentials.yaml")
+		assert.Contains(t, files["events.yaml"], "reason: BackOff")
+		assert.Equal(t, "fake logs", files["logs/parent-abc-xyz/app.log"])
+	})
+
+	t.Run("HandleRequest", func(t *testing.T) {
+		collected, err := collector.HandleRequest(ctx, parent)
+		require.NoError(t, err)
+		assert.True(t, collected)
+
+		var updated appsv1.Deployment
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(parent), &updated))
+		assert.False(t, supportbundle.IsRequested(&updated))
+
+		var cm corev1.ConfigMap
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "parent-support-bundle"}, &cm))
+
+		files := readArchive(t, bytes.NewReader(cm.BinaryData[supportbundle.BundleKey]))
+		assert.Contains(t, files, "objects/apps/replicaset/parent-abc.yaml")
+
+		collected, err = collector.HandleRequest(ctx, &updated)
+		require.NoError(t, err)
+		assert.False(t, collected)
+
+		// Later bundles don't include earlier ones.
+		updated.Annotations = map[string]string{supportbundle.RequestAnnotation: "true"}
+		require.NoError(t, c.Update(ctx, &updated))
+
+		collected, err = collector.HandleRequest(ctx, &updated)
+		require.NoError(t, err)
+		assert.True(t, collected)
+
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "parent-support-bundle"}, &cm))
+
+		files = readArchive(t, bytes.NewReader(cm.BinaryData[supportbundle.BundleKey]))
+		assert.NotContains(t, files, "objects/core/configmap/parent-support-bundle.yaml")
+	})
+}
+
+func TestHandleRequestTooLarge(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	parent := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "parent",
+			UID:         "parent-uid",
+			Annotations: map[string]string{supportbundle.RequestAnnotation: "true"},
+		},
+	}
+
+	// Incompressible data, larger than a ConfigMap can hold.
+	data := make([]byte, supportbundle.MaxArchiveSize)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	large := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "large",
+			UID:             "large-uid",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "parent", UID: "parent-uid"}},
+		},
+		Data: map[string]string{"data": base64.StdEncoding.EncodeToString(data)},
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(parent, large).
+		Build()
+
+	collector := supportbundle.NewCollector(c, scheme, supportbundle.Options{})
+
+	_, err = collector.HandleRequest(ctx, parent)
+	require.ErrorIs(t, err, supportbundle.ErrBundleTooLarge)
+
+	// The request isn't retried forever.
+	var updated appsv1.Deployment
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(parent), &updated))
+	assert.False(t, supportbundle.IsRequested(&updated))
+}
+
+func readArchive(t *testing.T, r io.Reader) map[string]string {
+	gr, err := gzip.NewReader(r)
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+
+		files[hdr.Name] = string(data)
+	}
+
+	return files
+}
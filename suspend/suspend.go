@@ -0,0 +1,142 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package suspend provides a consistent kill-switch for pausing and
+// resuming the reconciliation of an object.
+//
+// An object is paused by setting the "gpu-ninja.com/paused" annotation to
+// "true" (eg. `kubectl annotate <kind> <name> gpu-ninja.com/paused=true`),
+// and resumed by removing the annotation. While paused, update events are
+// filtered out of the workqueue (other than the update that pauses the
+// object) and the object's ReconciliationPaused condition is set. Delete events are never filtered so that cleanup is
+// never blocked.
+package suspend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gpu-ninja/operator-utils/predicates"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// PausedAnnotation is the annotation used to pause reconciliation of an object.
+	PausedAnnotation = predicates.PausedAnnotation
+	// ConditionTypeReconciliationPaused is the condition set on paused objects.
+	ConditionTypeReconciliationPaused = "ReconciliationPaused"
+)
+
+// Condition reasons.
+const (
+	ReasonPaused    = "Paused"
+	ReasonNotPaused = "NotPaused"
+)
+
+// IsPaused returns true if the given object has been paused.
+func IsPaused(obj client.Object) bool {
+	return predicates.IsPaused(obj)
+}
+
+// Predicate filters out events for objects that remain paused. Updates that
+// pause or resume an object (and creates, eg. on startup) are passed, so that
+// the reconciler can set the ReconciliationPaused condition. Delete events
+// are always passed so that cleanup is never blocked.
+func Predicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return e.ObjectNew != nil
+			}
+
+			return !IsPaused(e.ObjectOld) || !IsPaused(e.ObjectNew)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return e.Object != nil && !IsPaused(e.Object)
+		},
+	}
+}
+
+// SetCondition sets the ReconciliationPaused condition on the given
+// conditions slice (typically the object's status.conditions) to reflect
+// whether the object is paused. It returns true if the object is paused,
+// in which case the reconciler should update the status and return.
+func SetCondition(conditions *[]metav1.Condition, obj client.Object) bool {
+	condition := metav1.Condition{
+		Type:               ConditionTypeReconciliationPaused,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: obj.GetGeneration(),
+		Reason:             ReasonNotPaused,
+		Message:            "Reconciliation is active",
+	}
+
+	paused := IsPaused(obj)
+	if paused {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonPaused
+		condition.Message = fmt.Sprintf("Reconciliation is paused, remove the %q annotation to resume", PausedAnnotation)
+	}
+
+	meta.SetStatusCondition(conditions, condition)
+
+	return paused
+}
+
+// Pause pauses reconciliation of the given object.
+func Pause(ctx context.Context, c client.Client, obj client.Object) error {
+	if IsPaused(obj) {
+		return nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[PausedAnnotation] = "true"
+	obj.SetAnnotations(annotations)
+
+	if err := c.Patch(ctx, obj, patch); err != nil {
+		return fmt.Errorf("failed to pause object: %w", err)
+	}
+
+	return nil
+}
+
+// Resume resumes reconciliation of the given object.
+func Resume(ctx context.Context, c client.Client, obj client.Object) error {
+	if _, ok := obj.GetAnnotations()[PausedAnnotation]; !ok {
+		return nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+
+	annotations := obj.GetAnnotations()
+	delete(annotations, PausedAnnotation)
+	obj.SetAnnotations(annotations)
+
+	if err := c.Patch(ctx, obj, patch); err != nil {
+		return fmt.Errorf("failed to resume object: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package suspend_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/suspend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestSuspend(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(obj).Build()
+
+	var conditions []metav1.Condition
+	assert.False(t, suspend.SetCondition(&conditions, obj))
+	assert.True(t, meta.IsStatusConditionFalse(conditions, suspend.ConditionTypeReconciliationPaused))
+
+	unpaused := obj.DeepCopy()
+	require.NoError(t, suspend.Pause(ctx, c, obj))
+
+	var updated corev1.ConfigMap
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(obj), &updated))
+	assert.True(t, suspend.IsPaused(&updated))
+
+	assert.True(t, suspend.SetCondition(&conditions, &updated))
+	assert.True(t, meta.IsStatusConditionTrue(conditions, suspend.ConditionTypeReconciliationPaused))
+
+	p := suspend.Predicate()
+	// The update pausing the object is passed, so the condition can be set.
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: unpaused, ObjectNew: &updated}))
+	// Updates while it remains paused are filtered.
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: &updated, ObjectNew: updated.DeepCopy()}))
+	assert.False(t, p.Generic(event.GenericEvent{Object: &updated}))
+	assert.True(t, p.Delete(event.DeleteEvent{Object: &updated}))
+
+	require.NoError(t, suspend.Resume(ctx, c, &updated))
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(obj), &updated))
+	assert.False(t, suspend.IsPaused(&updated))
+	assert.NotContains(t, updated.Annotations, suspend.PausedAnnotation)
+
+	// The update resuming the object is passed.
+	paused := updated.DeepCopy()
+	paused.Annotations = map[string]string{suspend.PausedAnnotation: "true"}
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: paused, ObjectNew: &updated}))
+}
@@ -0,0 +1,352 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package syncer replicates a source Secret or ConfigMap (eg. an image
+// pull secret or CA bundle) into a set of target namespaces.
+//
+// Copies are labeled with their source, so that they are updated when the
+// source changes, and deleted when their namespace is no longer a target
+// (or the source is deleted). As object names can be longer than label
+// values allow, the source name is recorded in an annotation, and only a
+// hash of it in a label.
+package syncer
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+
+	"github.com/gpu-ninja/operator-utils/updater"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// SourceNamespaceLabel records the namespace of a copy's source.
+	SourceNamespaceLabel = "gpu-ninja.com/sync-source-namespace"
+	// SourceNameHashLabel records a hash of the name of a copy's source.
+	SourceNameHashLabel = "gpu-ninja.com/sync-source-name-hash"
+	// SourceNameAnnotation records the name of a copy's source.
+	SourceNameAnnotation = "gpu-ninja.com/sync-source-name"
+)
+
+var (
+	// ErrUnsupportedSource is returned when the source is not a Secret or ConfigMap.
+	ErrUnsupportedSource = errors.New("source must be a secret or config map")
+	// ErrConflict is returned when a target namespace contains an object
+	// with the same name that is not a copy of the source.
+	ErrConflict = errors.New("object exists and is not a copy of the source")
+)
+
+// Targets selects the namespaces a source is replicated into. The source's
+// own namespace is never a target.
+type Targets struct {
+	// Namespaces are the names of target namespaces. Namespaces that don't
+	// exist (or are terminating) are skipped.
+	Namespaces []string
+	// NamespaceSelector, if set, selects target namespaces by label.
+	NamespaceSelector labels.Selector
+}
+
+// Result is the result of a sync.
+type Result struct {
+	// Synced are the namespaces the source was replicated into.
+	Synced []string
+	// Deleted are the namespaces copies were deleted from.
+	Deleted []string
+}
+
+// Sync replicates the source into the target namespaces, updating any stale
+// copies, and deletes copies from namespaces that are no longer targets.
+// Conflicting objects (with the same name, but not copies of the source)
+// are left untouched, and reported in the returned error (which wraps
+// ErrConflict) after all other namespaces have been synced.
+func Sync(ctx context.Context, c client.Client, source client.Object, targets Targets) (*Result, error) {
+	logger := log.FromContext(ctx)
+
+	namespaces, err := resolveTargets(ctx, c, source.GetNamespace(), &targets)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+
+	var errs []error
+	for _, namespace := range namespaces {
+		if _, err := Copy(ctx, c, source, namespace); err != nil {
+			if errors.Is(err, ErrConflict) {
+				errs = append(errs, err)
+				continue
+			}
+
+			return nil, err
+		}
+
+		result.Synced = append(result.Synced, namespace)
+	}
+
+	targetNamespaces := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		targetNamespaces[namespace] = true
+	}
+
+	copies, err := listCopies(ctx, c, source)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range copies {
+		if targetNamespaces[obj.GetNamespace()] {
+			continue
+		}
+
+		if err := c.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to delete copy in namespace %q: %w", obj.GetNamespace(), err)
+		}
+
+		logger.Info("Deleted stale copy", "namespace", obj.GetNamespace(), "name", obj.GetName())
+
+		result.Deleted = append(result.Deleted, obj.GetNamespace())
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// Copy replicates the source into a single namespace (which needn't be
+// tracked as a target), creating or updating its copy. If the namespace
+// contains a conflicting object, an error wrapping ErrConflict is returned.
+// Copies are deleted by Cleanup (or Delete).
+func Copy(ctx context.Context, c client.Client, source client.Object, namespace string) (client.Object, error) {
+	template, err := copyOf(source, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := template.DeepCopyObject().(client.Object)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(template), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get copy in namespace %q: %w", namespace, err)
+		}
+	} else if !isCopyOf(existing, source) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrConflict, namespace, template.GetName())
+	}
+
+	obj, err := updater.CreateOrUpdateFromTemplate(ctx, c, template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync copy in namespace %q: %w", namespace, err)
+	}
+
+	return obj, nil
+}
+
+// Delete deletes the copy of the source from a single namespace (if there is
+// one), leaving conflicting objects untouched.
+func Delete(ctx context.Context, c client.Client, source client.Object, namespace string) error {
+	template, err := copyOf(source, namespace)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Get(ctx, client.ObjectKeyFromObject(template), template); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to get copy in namespace %q: %w", namespace, err)
+	}
+
+	if !isCopyOf(template, source) {
+		return nil
+	}
+
+	if err := c.Delete(ctx, template); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete copy in namespace %q: %w", namespace, err)
+	}
+
+	return nil
+}
+
+// Cleanup deletes all copies of the source (eg. when the source is being
+// deleted). It returns the namespaces copies were deleted from.
+func Cleanup(ctx context.Context, c client.Client, source client.Object) ([]string, error) {
+	copies, err := listCopies(ctx, c, source)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for _, obj := range copies {
+		if err := c.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to delete copy in namespace %q: %w", obj.GetNamespace(), err)
+		}
+
+		deleted = append(deleted, obj.GetNamespace())
+	}
+
+	return deleted, nil
+}
+
+// SourceOf returns the key of a copy's source, and false if the object is not a copy.
+func SourceOf(obj client.Object) (types.NamespacedName, bool) {
+	namespace, ok := obj.GetLabels()[SourceNamespaceLabel]
+	if !ok {
+		return types.NamespacedName{}, false
+	}
+
+	name, ok := obj.GetAnnotations()[SourceNameAnnotation]
+	if !ok {
+		return types.NamespacedName{}, false
+	}
+
+	return types.NamespacedName{Namespace: namespace, Name: name}, true
+}
+
+// EnqueueSource returns an event handler that enqueues the source of a
+// copy, so that modified or deleted copies are repaired.
+func EnqueueSource() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+		key, ok := SourceOf(obj)
+		if !ok {
+			return nil
+		}
+
+		return []reconcile.Request{{NamespacedName: key}}
+	})
+}
+
+func resolveTargets(ctx context.Context, c client.Client, sourceNamespace string, targets *Targets) ([]string, error) {
+	var namespaces corev1.NamespaceList
+	if err := c.List(ctx, &namespaces); err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	listed := make(map[string]bool, len(targets.Namespaces))
+	for _, name := range targets.Namespaces {
+		listed[name] = true
+	}
+
+	var resolved []string
+	for _, namespace := range namespaces.Items {
+		if namespace.Name == sourceNamespace || namespace.Status.Phase == corev1.NamespaceTerminating ||
+			!namespace.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		if listed[namespace.Name] ||
+			(targets.NamespaceSelector != nil && targets.NamespaceSelector.Matches(labels.Set(namespace.Labels))) {
+			resolved = append(resolved, namespace.Name)
+		}
+	}
+
+	sort.Strings(resolved)
+
+	return resolved, nil
+}
+
+func copyOf(source client.Object, namespace string) (client.Object, error) {
+	objectMeta := metav1.ObjectMeta{
+		Namespace: namespace,
+		Name:      source.GetName(),
+		Labels: map[string]string{
+			SourceNamespaceLabel: source.GetNamespace(),
+			SourceNameHashLabel:  nameHash(source.GetName()),
+		},
+		Annotations: map[string]string{
+			SourceNameAnnotation: source.GetName(),
+		},
+	}
+
+	switch s := source.(type) {
+	case *corev1.Secret:
+		return &corev1.Secret{
+			ObjectMeta: objectMeta,
+			Type:       s.Type,
+			Data:       s.Data,
+		}, nil
+	case *corev1.ConfigMap:
+		return &corev1.ConfigMap{
+			ObjectMeta: objectMeta,
+			Data:       s.Data,
+			BinaryData: s.BinaryData,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedSource, source)
+	}
+}
+
+func isCopyOf(obj, source client.Object) bool {
+	key, ok := SourceOf(obj)
+	return ok && key == client.ObjectKeyFromObject(source)
+}
+
+func listCopies(ctx context.Context, c client.Client, source client.Object) ([]client.Object, error) {
+	selector := client.MatchingLabels{
+		SourceNamespaceLabel: source.GetNamespace(),
+		SourceNameHashLabel:  nameHash(source.GetName()),
+	}
+
+	var listed []client.Object
+	switch source.(type) {
+	case *corev1.Secret:
+		var list corev1.SecretList
+		if err := c.List(ctx, &list, selector); err != nil {
+			return nil, fmt.Errorf("failed to list copies: %w", err)
+		}
+
+		for i := range list.Items {
+			listed = append(listed, &list.Items[i])
+		}
+	case *corev1.ConfigMap:
+		var list corev1.ConfigMapList
+		if err := c.List(ctx, &list, selector); err != nil {
+			return nil, fmt.Errorf("failed to list copies: %w", err)
+		}
+
+		for i := range list.Items {
+			listed = append(listed, &list.Items[i])
+		}
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedSource, source)
+	}
+
+	// Skip copies of other sources whose names hash the same.
+	var copies []client.Object
+	for _, obj := range listed {
+		if isCopyOf(obj, source) {
+			copies = append(copies, obj)
+		}
+	}
+
+	return copies, nil
+}
+
+func nameHash(name string) string {
+	h := fnv.New32a()
+	_, _ = io.WriteString(h, name)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,167 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syncer_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	namespace := func(name string, workloads bool) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if workloads {
+			ns.Labels = map[string]string{"workloads": "true"}
+		}
+		return ns
+	}
+
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "operator", Name: "pull-secret"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	}
+
+	conflicting := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-c", Name: "pull-secret"},
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			namespace("operator", true),
+			namespace("team-a", true),
+			namespace("team-b", false),
+			namespace("team-c", true),
+			namespace("kube-system", false),
+			source,
+			conflicting,
+		).
+		Build()
+
+	targets := syncer.Targets{
+		Namespaces:        []string{"team-b", "missing"},
+		NamespaceSelector: labels.SelectorFromSet(labels.Set{"workloads": "true"}),
+	}
+
+	result, err := syncer.Sync(ctx, c, source, targets)
+	require.ErrorIs(t, err, syncer.ErrConflict)
+	assert.ErrorContains(t, err, "team-c/pull-secret")
+	assert.Equal(t, []string{"team-a", "team-b"}, result.Synced)
+
+	var copied corev1.Secret
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "team-a", Name: "pull-secret"}, &copied))
+	assert.Equal(t, corev1.SecretTypeDockerConfigJson, copied.Type)
+	assert.Equal(t, source.Data, copied.Data)
+
+	key, ok := syncer.SourceOf(&copied)
+	require.True(t, ok)
+	assert.Equal(t, client.ObjectKeyFromObject(source), key)
+
+	// The conflicting secret is left untouched.
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(conflicting), conflicting))
+	_, ok = syncer.SourceOf(conflicting)
+	assert.False(t, ok)
+
+	t.Run("Update", func(t *testing.T) {
+		source.Data[corev1.DockerConfigJsonKey] = []byte(`{"auths":{"registry.example.com":{}}}`)
+		require.NoError(t, c.Update(ctx, source))
+
+		targets.Namespaces = nil
+
+		result, err := syncer.Sync(ctx, c, source, targets)
+		require.Error(t, err)
+		assert.Equal(t, []string{"team-a"}, result.Synced)
+		assert.Equal(t, []string{"team-b"}, result.Deleted)
+
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "team-a", Name: "pull-secret"}, &copied))
+		assert.Equal(t, source.Data, copied.Data)
+	})
+
+	t.Run("Cleanup", func(t *testing.T) {
+		deleted, err := syncer.Cleanup(ctx, c, source)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"team-a"}, deleted)
+
+		var secrets corev1.SecretList
+		require.NoError(t, c.List(ctx, &secrets))
+		assert.Len(t, secrets.Items, 2)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := syncer.Sync(ctx, c, &corev1.Pod{}, targets)
+		assert.ErrorIs(t, err, syncer.ErrUnsupportedSource)
+	})
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	// Names may be longer than label values allow.
+	source := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "operator", Name: strings.Repeat("ca-bundle", 10)},
+		Data:       map[string]string{"ca.crt": "test"},
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(source).
+		Build()
+
+	obj, err := syncer.Copy(ctx, c, source, "workloads")
+	require.NoError(t, err)
+
+	for _, value := range obj.GetLabels() {
+		assert.Empty(t, validation.IsValidLabelValue(value))
+	}
+
+	key, ok := syncer.SourceOf(obj)
+	require.True(t, ok)
+	assert.Equal(t, client.ObjectKeyFromObject(source), key)
+
+	require.NoError(t, syncer.Delete(ctx, c, source, "workloads"))
+
+	var copied corev1.ConfigMap
+	err = c.Get(ctx, client.ObjectKeyFromObject(obj), &copied)
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// Deleting a missing copy is a no-op.
+	require.NoError(t, syncer.Delete(ctx, c, source, "workloads"))
+}
@@ -0,0 +1,308 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package telemetry provides an opt-in reporter of anonymous operator usage
+// (the operator version, custom resource counts by kind, and the Kubernetes
+// version) to a configurable endpoint. Nothing is reported unless the
+// reporter is explicitly enabled.
+//
+// Reports are built from an allowlist of fields, and never include object
+// names, namespaces, labels, or other potentially identifying information.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/clusterid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	DefaultInterval = 24 * time.Hour
+	DefaultTimeout  = 10 * time.Second
+)
+
+// DefaultBackoff is the default backoff between attempts to send a report.
+var DefaultBackoff = wait.Backoff{
+	Duration: 5 * time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    5,
+}
+
+var (
+	// ErrNoEndpoint is returned when the reporter is enabled without an endpoint.
+	ErrNoEndpoint = errors.New("telemetry endpoint is required")
+	// ErrRejected is returned when the endpoint rejects a report.
+	ErrRejected = errors.New("telemetry report rejected")
+)
+
+// versionRegexp matches the release portion of a version (eg. "v1.28.2" of
+// "v1.28.2-eks-a5df82a"), build metadata and vendor suffixes are discarded.
+var versionRegexp = regexp.MustCompile(`^v?\d+\.\d+\.\d+`)
+
+// ServerVersionInterface returns the Kubernetes server version (eg. a discovery client).
+type ServerVersionInterface interface {
+	ServerVersion() (*version.Info, error)
+}
+
+// Options configures a telemetry reporter.
+type Options struct {
+	// Enabled must be set for anything to be reported.
+	Enabled bool
+	// Endpoint is the URL reports are POSTed to (as JSON).
+	Endpoint string
+	// Operator is the name of the operator.
+	Operator string
+	// Version is the version of the operator.
+	Version string
+	// Kinds are the custom resource kinds to count.
+	Kinds []schema.GroupVersionKind
+	// ServerVersion, if set, is used to report the Kubernetes version.
+	ServerVersion ServerVersionInterface
+	// Interval is how often reports are sent.
+	Interval time.Duration
+	// Backoff is the backoff between attempts to send a report.
+	Backoff *wait.Backoff
+	// HTTPClient is used to send reports.
+	HTTPClient *http.Client
+	// Clock is used to determine the current time (useful for testing).
+	Clock clock.WithTicker
+}
+
+// Report is an anonymous usage report.
+type Report struct {
+	// InstallationID is an opaque identifier of the operator installation
+	// (derived from a hash of the cluster's identity, see clusterid).
+	InstallationID string `json:"installationID,omitempty"`
+	// Operator is the name of the operator.
+	Operator string `json:"operator"`
+	// Version is the version of the operator.
+	Version string `json:"version,omitempty"`
+	// KubernetesVersion is the release version of Kubernetes (eg. "v1.28.2").
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// Counts are the number of custom resources, by kind.
+	Counts map[string]int `json:"counts,omitempty"`
+	// Timestamp is when the report was collected.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Reporter periodically sends anonymous usage reports.
+type Reporter struct {
+	c         client.Reader
+	opts      Options
+	clusterID *clusterid.Resolver
+}
+
+var (
+	_ manager.Runnable               = (*Reporter)(nil)
+	_ manager.LeaderElectionRunnable = (*Reporter)(nil)
+)
+
+// NewReporter returns a new telemetry reporter.
+func NewReporter(c client.Reader, opts Options) (*Reporter, error) {
+	if opts.Enabled && opts.Endpoint == "" {
+		return nil, ErrNoEndpoint
+	}
+
+	if opts.Interval == 0 {
+		opts.Interval = DefaultInterval
+	}
+
+	if opts.Backoff == nil {
+		opts.Backoff = &DefaultBackoff
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	return &Reporter{
+		c:         c,
+		opts:      opts,
+		clusterID: clusterid.NewResolver(c, clusterid.Options{}),
+	}, nil
+}
+
+// Start implements manager.Runnable, sending a report immediately and then
+// every interval. It returns immediately if the reporter is not enabled.
+// Failures are logged, they never stop the operator.
+func (r *Reporter) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("telemetry")
+
+	if !r.opts.Enabled {
+		logger.V(1).Info("Telemetry is disabled")
+		return nil
+	}
+
+	ticker := r.opts.Clock.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.report(ctx); err != nil && ctx.Err() == nil {
+			logger.V(1).Info("Failed to send telemetry report", "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, so that
+// only one replica reports.
+func (r *Reporter) NeedLeaderElection() bool {
+	return true
+}
+
+// Collect builds a usage report.
+func (r *Reporter) Collect(ctx context.Context) (*Report, error) {
+	report := &Report{
+		Operator:  r.opts.Operator,
+		Version:   r.opts.Version,
+		Timestamp: r.opts.Clock.Now().UTC().Truncate(time.Second),
+	}
+
+	if identity, err := r.clusterID.Get(ctx); err == nil {
+		report.InstallationID = InstallationID(identity.ID, r.opts.Operator)
+	}
+
+	if r.opts.ServerVersion != nil {
+		info, err := r.opts.ServerVersion.ServerVersion()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get server version: %w", err)
+		}
+
+		report.KubernetesVersion = versionRegexp.FindString(info.GitVersion)
+	}
+
+	for _, gvk := range r.opts.Kinds {
+		var list metav1.PartialObjectMetadataList
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+
+		if err := r.c.List(ctx, &list); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", gvk.Kind, err)
+		}
+
+		if report.Counts == nil {
+			report.Counts = make(map[string]int)
+		}
+		report.Counts[gvk.GroupKind().String()] = len(list.Items)
+	}
+
+	return report, nil
+}
+
+// Send sends a report to the endpoint, retrying with backoff on failure.
+func (r *Reporter) Send(ctx context.Context, report *Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+
+	var lastErr error
+	err = wait.ExponentialBackoffWithContext(ctx, *r.opts.Backoff, func(ctx context.Context) (bool, error) {
+		lastErr = r.send(ctx, body)
+		if lastErr == nil {
+			return true, nil
+		}
+
+		// Don't retry reports that were rejected outright.
+		if errors.Is(lastErr, ErrRejected) {
+			return false, lastErr
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) && lastErr != nil {
+			return fmt.Errorf("failed to send report: %w", lastErr)
+		}
+
+		return fmt.Errorf("failed to send report: %w", err)
+	}
+
+	return nil
+}
+
+// InstallationID returns an opaque identifier derived from a cluster UID and
+// the operator name, that can't be reversed to the cluster UID.
+func InstallationID(clusterUID, operator string) string {
+	if clusterUID == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(operator + "/" + clusterUID))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (r *Reporter) report(ctx context.Context) error {
+	report, err := r.Collect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to collect report: %w", err)
+	}
+
+	return r.Send(ctx, report)
+}
+
+func (r *Reporter) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d", ErrRejected, resp.StatusCode)
+	default:
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,132 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package telemetry_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/version"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReporter(t *testing.T) {
+	ctx := context.Background()
+
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "cluster-uid"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "secret-name", Namespace: "a"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "b"}},
+	).Build()
+
+	var attempts atomic.Int32
+	var received []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var report map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+		received, _ = json.Marshal(report)
+	}))
+	t.Cleanup(srv.Close)
+
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	r, err := telemetry.NewReporter(c, telemetry.Options{
+		Enabled:       true,
+		Endpoint:      srv.URL,
+		Operator:      "widget-operator",
+		Version:       "v0.1.0",
+		Kinds:         []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("ConfigMap")},
+		ServerVersion: serverVersion("v1.28.2-eks-a5df82a"),
+		Backoff:       &wait.Backoff{Duration: time.Millisecond, Steps: 3},
+		Clock:         clocktesting.NewFakeClock(now),
+	})
+	require.NoError(t, err)
+
+	report, err := r.Collect(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, &telemetry.Report{
+		InstallationID:    telemetry.InstallationID("cluster-uid", "widget-operator"),
+		Operator:          "widget-operator",
+		Version:           "v0.1.0",
+		KubernetesVersion: "v1.28.2",
+		Counts:            map[string]int{"ConfigMap": 2},
+		Timestamp:         now,
+	}, report)
+	assert.NotContains(t, report.InstallationID, "cluster-uid")
+
+	require.NoError(t, r.Send(ctx, report))
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.NotContains(t, string(received), "secret-name")
+	assert.Contains(t, string(received), `"kubernetesVersion":"v1.28.2"`)
+
+	t.Run("Rejected", func(t *testing.T) {
+		var attempts atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		t.Cleanup(srv.Close)
+
+		r, err := telemetry.NewReporter(c, telemetry.Options{
+			Enabled:  true,
+			Endpoint: srv.URL,
+			Backoff:  &wait.Backoff{Duration: time.Millisecond, Steps: 3},
+		})
+		require.NoError(t, err)
+
+		err = r.Send(ctx, &telemetry.Report{})
+		assert.ErrorIs(t, err, telemetry.ErrRejected)
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		r, err := telemetry.NewReporter(c, telemetry.Options{})
+		require.NoError(t, err)
+
+		// Returns immediately without reporting.
+		require.NoError(t, r.Start(ctx))
+
+		_, err = telemetry.NewReporter(c, telemetry.Options{Enabled: true})
+		assert.ErrorIs(t, err, telemetry.ErrNoEndpoint)
+	})
+}
+
+type serverVersion string
+
+func (v serverVersion) ServerVersion() (*version.Info, error) {
+	return &version.Info{GitVersion: string(v)}, nil
+}
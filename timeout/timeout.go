@@ -0,0 +1,125 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package timeout provides a reconciler wrapper that enforces a deadline on
+// each reconcile, so that a hung call (eg. to an external API) can't tie up
+// a controller's workers indefinitely.
+package timeout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	DefaultTimeout = 5 * time.Minute
+	// DefaultGracePeriod is how long a reconcile may keep running after its
+	// deadline before it is reported as not honoring its context.
+	DefaultGracePeriod = 30 * time.Second
+)
+
+// ErrTimeout is returned when a reconcile exceeds its deadline.
+var ErrTimeout = errors.New("reconcile timed out")
+
+// Options configures reconcile timeouts.
+type Options struct {
+	// Timeout is the deadline for each reconcile.
+	Timeout time.Duration
+	// Recorder, if set, is used to record a Warning event on the reconciled object.
+	Recorder *events.Recorder
+	// Client is used to get the reconciled object (to record events against).
+	Client client.Reader
+	// NewObject returns a new, empty, object of the reconciled kind.
+	NewObject func() client.Object
+	// RetryAfter is how long to wait before retrying a reconcile that
+	// timed out (defaults to retryable.DefaultRetryAfter).
+	RetryAfter time.Duration
+	// GracePeriod is how long a reconcile may keep running after its
+	// deadline before it is reported as not honoring its context.
+	GracePeriod time.Duration
+}
+
+// NewReconciler wraps a reconciler so that each reconcile is given a context
+// with a deadline. A reconcile that exceeds its deadline is counted in the
+// reconcile timeouts metric, recorded as a Warning event on the object, and
+// returns a retryable ErrTimeout.
+//
+// The wrapped reconcile is always waited for (so that a key is never
+// reconciled concurrently, and panics reach any recovery wrapper). If it
+// doesn't honor its context, it is reported once the grace period passes.
+func NewReconciler(name string, r reconcile.Reconciler, opts Options) reconcile.Reconciler {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = DefaultGracePeriod
+	}
+
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		reconcileCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+
+		stuck := time.AfterFunc(opts.Timeout+opts.GracePeriod, func() {
+			log.FromContext(ctx).Error(ErrTimeout, "Reconcile is not honoring its deadline",
+				"controller", name, "request", req.NamespacedName, "timeout", opts.Timeout, "gracePeriod", opts.GracePeriod)
+		})
+
+		res, err := r.Reconcile(reconcileCtx, req)
+		stuck.Stop()
+
+		if err == nil || !errors.Is(reconcileCtx.Err(), context.DeadlineExceeded) || ctx.Err() != nil {
+			return res, err
+		}
+
+		log.FromContext(ctx).Info("Reconcile timed out",
+			"controller", name, "request", req.NamespacedName, "timeout", opts.Timeout)
+
+		metrics.RecordTimeout(name)
+		recordEvent(ctx, req, &opts)
+
+		return reconcile.Result{}, retryable.After(fmt.Errorf("%w after %s", ErrTimeout, opts.Timeout), opts.RetryAfter)
+	})
+}
+
+func recordEvent(ctx context.Context, req reconcile.Request, opts *Options) {
+	if opts.Recorder == nil || opts.Client == nil || opts.NewObject == nil {
+		return
+	}
+
+	// The reconcile context has expired, so fetching the object gets a
+	// (short) context of its own.
+	getCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	obj := opts.NewObject()
+	if err := opts.Client.Get(getCtx, req.NamespacedName, obj); err != nil {
+		log.FromContext(ctx).V(1).Info("Failed to get object to record timeout event", "error", err.Error())
+		return
+	}
+
+	opts.Recorder.Warningf(obj, events.ReasonReconcileTimedOut, "Reconcile timed out after %s", opts.Timeout)
+}
@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package timeout_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/timeout"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestNewReconciler(t *testing.T) {
+	ctx := context.Background()
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	c := fake.NewClientBuilder().WithObjects(cm).Build()
+
+	fakeRecorder := record.NewFakeRecorder(10)
+
+	var hungReturned atomic.Bool
+
+	r := timeout.NewReconciler("test-timeout", reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		switch req.Name {
+		case "hung":
+			// Ignores its context.
+			time.Sleep(200 * time.Millisecond)
+			hungReturned.Store(true)
+
+			return reconcile.Result{}, errors.New("interrupted")
+		case "test":
+			<-ctx.Done()
+			return reconcile.Result{}, ctx.Err()
+		}
+
+		return reconcile.Result{RequeueAfter: time.Minute}, nil
+	}), timeout.Options{
+		Timeout:     50 * time.Millisecond,
+		GracePeriod: 50 * time.Millisecond,
+		Recorder:    events.NewRecorder(fakeRecorder),
+		Client:      c,
+		NewObject:   func() client.Object { return &corev1.ConfigMap{} },
+	})
+
+	res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "test"}})
+	assert.ErrorIs(t, err, timeout.ErrTimeout)
+	assert.True(t, retryable.Is(err))
+	assert.Zero(t, res)
+
+	require.Len(t, fakeRecorder.Events, 1)
+	assert.Contains(t, <-fakeRecorder.Events, "Warning ReconcileTimedOut Reconcile timed out after 50ms")
+
+	// Reconciles that don't honor their context are still waited for.
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "hung"}})
+	assert.ErrorIs(t, err, timeout.ErrTimeout)
+	assert.True(t, hungReturned.Load())
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.TimeoutsTotal.WithLabelValues("test-timeout")))
+
+	res, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "other"}})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, res.RequeueAfter)
+
+	t.Run("Panic", func(t *testing.T) {
+		r := timeout.NewReconciler("test-timeout", reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+			panic("boom")
+		}), timeout.Options{Timeout: 50 * time.Millisecond})
+
+		// Panics are raised in the caller, so recovery wrappers can handle them.
+		assert.PanicsWithValue(t, "boom", func() {
+			_, _ = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+		})
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := r.Reconcile(cancelledCtx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "test"}})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, timeout.ErrTimeout)
+	})
+}
@@ -0,0 +1,169 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type tracingClient struct {
+	client.Client
+	tracer trace.Tracer
+}
+
+// NewClient wraps a client so that each API call is recorded as a child
+// span of the span in the request context (eg. the reconcile span).
+func NewClient(c client.Client, opts ...Option) client.Client {
+	return &tracingClient{
+		Client: c,
+		tracer: newTracer(opts),
+	}
+}
+
+func (c *tracingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	ctx, span := c.start(ctx, "Get", "", obj, AttributeNamespace.String(key.Namespace), AttributeName.String(key.Name))
+	return end(span, c.Client.Get(ctx, key, obj, opts...))
+}
+
+func (c *tracingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	ctx, span := c.start(ctx, "List", "", list, AttributeNamespace.String(listOpts.Namespace))
+	return end(span, c.Client.List(ctx, list, opts...))
+}
+
+func (c *tracingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	ctx, span := c.start(ctx, "Create", "", obj, objectAttributes(obj)...)
+	return end(span, c.Client.Create(ctx, obj, opts...))
+}
+
+func (c *tracingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	ctx, span := c.start(ctx, "Delete", "", obj, objectAttributes(obj)...)
+	return end(span, c.Client.Delete(ctx, obj, opts...))
+}
+
+func (c *tracingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	ctx, span := c.start(ctx, "Update", "", obj, objectAttributes(obj)...)
+	return end(span, c.Client.Update(ctx, obj, opts...))
+}
+
+func (c *tracingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, span := c.start(ctx, "Patch", "", obj, objectAttributes(obj)...)
+	return end(span, c.Client.Patch(ctx, obj, patch, opts...))
+}
+
+func (c *tracingClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	deleteAllOfOpts := client.DeleteAllOfOptions{}
+	deleteAllOfOpts.ApplyOptions(opts)
+
+	ctx, span := c.start(ctx, "DeleteAllOf", "", obj, AttributeNamespace.String(deleteAllOfOpts.Namespace))
+	return end(span, c.Client.DeleteAllOf(ctx, obj, opts...))
+}
+
+func (c *tracingClient) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *tracingClient) SubResource(subResource string) client.SubResourceClient {
+	return &tracingSubResourceClient{
+		SubResourceClient: c.Client.SubResource(subResource),
+		parent:            c,
+		subResource:       subResource,
+	}
+}
+
+func (c *tracingClient) start(ctx context.Context, operation, subResource string, obj runtime.Object, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	kind := "Unknown"
+	if gvk, err := c.Client.GroupVersionKindFor(obj); err == nil {
+		kind = gvk.Kind
+		if meta.IsListType(obj) {
+			kind = strings.TrimSuffix(kind, "List")
+		}
+
+		attrs = append(attrs,
+			AttributeGroup.String(gvk.Group),
+			AttributeVersion.String(gvk.Version),
+			AttributeKind.String(kind),
+		)
+	}
+
+	spanName := operation + " " + kind
+	if subResource != "" {
+		spanName += "/" + subResource
+		attrs = append(attrs, AttributeSubResource.String(subResource))
+	}
+
+	attrs = append(attrs, AttributeOperation.String(operation))
+
+	return c.tracer.Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+type tracingSubResourceClient struct {
+	client.SubResourceClient
+	parent      *tracingClient
+	subResource string
+}
+
+func (c *tracingSubResourceClient) Get(ctx context.Context, obj, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	ctx, span := c.parent.start(ctx, "Get", c.subResource, obj, objectAttributes(obj)...)
+	return end(span, c.SubResourceClient.Get(ctx, obj, subResource, opts...))
+}
+
+func (c *tracingSubResourceClient) Create(ctx context.Context, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	ctx, span := c.parent.start(ctx, "Create", c.subResource, obj, objectAttributes(obj)...)
+	return end(span, c.SubResourceClient.Create(ctx, obj, subResource, opts...))
+}
+
+func (c *tracingSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	ctx, span := c.parent.start(ctx, "Update", c.subResource, obj, objectAttributes(obj)...)
+	return end(span, c.SubResourceClient.Update(ctx, obj, opts...))
+}
+
+func (c *tracingSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	ctx, span := c.parent.start(ctx, "Patch", c.subResource, obj, objectAttributes(obj)...)
+	return end(span, c.SubResourceClient.Patch(ctx, obj, patch, opts...))
+}
+
+func objectAttributes(obj client.Object) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		AttributeNamespace.String(obj.GetNamespace()),
+		AttributeName.String(obj.GetName()),
+	}
+}
+
+// end records the result of an API call and ends the span.
+func end(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	return err
+}
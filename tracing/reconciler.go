@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Reconcile outcomes recorded on reconcile spans.
+const (
+	OutcomeSuccess      = "success"
+	OutcomeRequeue      = "requeue"
+	OutcomeRequeueAfter = "requeue_after"
+	OutcomeError        = "error"
+)
+
+type tracingReconciler struct {
+	name       string
+	tracer     trace.Tracer
+	reconciler reconcile.Reconciler
+}
+
+// NewReconciler wraps a reconciler so that each reconcile is recorded as a
+// span, annotated with the object key, outcome, and requeue decision.
+func NewReconciler(name string, r reconcile.Reconciler, opts ...Option) reconcile.Reconciler {
+	return &tracingReconciler{
+		name:       name,
+		tracer:     newTracer(opts),
+		reconciler: r,
+	}
+}
+
+func (r *tracingReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx, span := r.tracer.Start(ctx, "Reconcile "+r.name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			AttributeNamespace.String(req.Namespace),
+			AttributeName.String(req.Name),
+		))
+	defer span.End()
+
+	res, err := r.reconciler.Reconcile(ctx, req)
+	switch {
+	case err != nil:
+		span.SetAttributes(AttributeOutcome.String(OutcomeError))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case res.RequeueAfter > 0:
+		span.SetAttributes(
+			AttributeOutcome.String(OutcomeRequeueAfter),
+			AttributeRequeueAfter.String(res.RequeueAfter.String()),
+		)
+	case res.Requeue:
+		span.SetAttributes(AttributeOutcome.String(OutcomeRequeue))
+	default:
+		span.SetAttributes(AttributeOutcome.String(OutcomeSuccess))
+	}
+
+	return res, err
+}
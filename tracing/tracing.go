@@ -0,0 +1,112 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tracing provides OpenTelemetry instrumentation for reconcilers
+// and Kubernetes API clients.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer used by this package.
+const InstrumentationName = "github.com/gpu-ninja/operator-utils/tracing"
+
+// Attribute keys recorded on spans.
+const (
+	AttributeNamespace    = attribute.Key("k8s.namespace.name")
+	AttributeName         = attribute.Key("k8s.object.name")
+	AttributeGroup        = attribute.Key("k8s.object.group")
+	AttributeVersion      = attribute.Key("k8s.object.version")
+	AttributeKind         = attribute.Key("k8s.object.kind")
+	AttributeOperation    = attribute.Key("k8s.operation")
+	AttributeSubResource  = attribute.Key("k8s.subresource")
+	AttributeOutcome      = attribute.Key("reconcile.outcome")
+	AttributeRequeueAfter = attribute.Key("reconcile.requeue_after")
+)
+
+// Option configures the tracing wrappers.
+type Option func(*options)
+
+type options struct {
+	tracerProvider trace.TracerProvider
+}
+
+// WithTracerProvider sets the tracer provider used to create spans (defaults
+// to the global tracer provider).
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+func newTracer(opts []Option) trace.Tracer {
+	return NewTracer(InstrumentationName, opts...)
+}
+
+// NewTracer returns a tracer for the given instrumentation (eg. a package
+// path), so that other packages can record spans using the same options.
+func NewTracer(instrumentationName string, opts ...Option) trace.Tracer {
+	o := &options{
+		tracerProvider: otel.GetTracerProvider(),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o.tracerProvider.Tracer(instrumentationName)
+}
+
+// NewTracerProvider returns a tracer provider that exports spans via OTLP
+// over gRPC and registers it (along with a W3C trace context propagator) as
+// the global tracer provider. The exporter is configured using the standard
+// OTEL_EXPORTER_OTLP_* environment variables, unless overridden by the given
+// options. The caller is responsible for shutting down the provider.
+func NewTracerProvider(ctx context.Context, serviceName string, opts ...otlptracegrpc.Option) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tp, nil
+}
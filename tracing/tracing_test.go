@@ -0,0 +1,120 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconciler(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := tracing.NewClient(fake.NewClientBuilder().WithScheme(scheme).Build(), tracing.WithTracerProvider(tp))
+
+	var reconcileErr error
+	r := tracing.NewReconciler("configmap", reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		var cm corev1.ConfigMap
+		_ = c.Get(ctx, req.NamespacedName, &cm)
+
+		if reconcileErr != nil {
+			return reconcile.Result{}, reconcileErr
+		}
+
+		return reconcile.Result{RequeueAfter: time.Minute}, nil
+	}), tracing.WithTracerProvider(tp))
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "test"}}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	getSpan, reconcileSpan := spans[0], spans[1]
+	assert.Equal(t, "Get ConfigMap", getSpan.Name())
+	assert.Equal(t, codes.Error, getSpan.Status().Code)
+	assert.Equal(t, reconcileSpan.SpanContext().SpanID(), getSpan.Parent().SpanID())
+
+	assert.Equal(t, "Reconcile configmap", reconcileSpan.Name())
+	assert.Contains(t, reconcileSpan.Attributes(), tracing.AttributeOutcome.String(tracing.OutcomeRequeueAfter))
+	assert.Contains(t, reconcileSpan.Attributes(), tracing.AttributeRequeueAfter.String("1m0s"))
+	assert.Contains(t, reconcileSpan.Attributes(), attribute.String("k8s.object.name", "test"))
+
+	reconcileErr = errors.New("bang")
+	_, err = r.Reconcile(context.Background(), req)
+	require.Error(t, err)
+
+	spans = recorder.Ended()
+	require.Len(t, spans, 4)
+	assert.Equal(t, codes.Error, spans[3].Status().Code)
+	assert.Contains(t, spans[3].Attributes(), tracing.AttributeOutcome.String(tracing.OutcomeError))
+}
+
+func TestClient(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := tracing.NewClient(fake.NewClientBuilder().WithScheme(scheme).Build(), tracing.WithTracerProvider(tp))
+
+	ctx := context.Background()
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	require.NoError(t, c.Create(ctx, cm))
+
+	var cms corev1.ConfigMapList
+	require.NoError(t, c.List(ctx, &cms, client.InNamespace("default")))
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	require.NoError(t, c.Create(ctx, pod))
+	require.NoError(t, c.Status().Update(ctx, pod))
+
+	require.NoError(t, c.Delete(ctx, cm))
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+
+	assert.Equal(t, []string{"Create ConfigMap", "List ConfigMap", "Create Pod", "Update Pod/status", "Delete ConfigMap"}, names)
+}
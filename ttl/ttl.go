@@ -0,0 +1,236 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package ttl provides automatic cleanup of expired objects (eg. ephemeral
+// debug or preview resources).
+//
+// An object expires at the time given by the "gpu-ninja.com/expires-at"
+// annotation (RFC3339), or once the duration given by the "gpu-ninja.com/ttl"
+// annotation has elapsed since its creation. Operators can instead derive
+// the expiry from a spec field with a custom ExpiryFunc.
+package ttl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// ExpiresAtAnnotation is the (RFC3339) time at which an object expires.
+	ExpiresAtAnnotation = "gpu-ninja.com/expires-at"
+	// TTLAnnotation is the duration (eg. "24h") after its creation at which an object expires.
+	TTLAnnotation = "gpu-ninja.com/ttl"
+)
+
+// ExpiryFunc returns the time at which an object expires, and false if the
+// object never expires.
+type ExpiryFunc func(obj client.Object) (time.Time, bool, error)
+
+// AnnotationExpiry returns the expiry of an object given by its expiry
+// annotations. If both are set, the earliest expiry wins.
+func AnnotationExpiry(obj client.Object) (time.Time, bool, error) {
+	var expiresAt time.Time
+	var found bool
+
+	annotations := obj.GetAnnotations()
+
+	if value, ok := annotations[ExpiresAtAnnotation]; ok {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("failed to parse %s annotation: %w", ExpiresAtAnnotation, err)
+		}
+
+		expiresAt, found = t, true
+	}
+
+	if value, ok := annotations[TTLAnnotation]; ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("failed to parse %s annotation: %w", TTLAnnotation, err)
+		}
+
+		t := obj.GetCreationTimestamp().Add(d)
+		if !found || t.Before(expiresAt) {
+			expiresAt, found = t, true
+		}
+	}
+
+	return expiresAt, found, nil
+}
+
+// SetExpiresAt sets the time at which an object expires.
+func SetExpiresAt(obj client.Object, t time.Time) {
+	setAnnotation(obj, ExpiresAtAnnotation, t.UTC().Format(time.RFC3339))
+}
+
+// SetTTL sets the duration after its creation at which an object expires.
+func SetTTL(obj client.Object, d time.Duration) {
+	setAnnotation(obj, TTLAnnotation, d.String())
+}
+
+func setAnnotation(obj client.Object, key, value string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[key] = value
+	obj.SetAnnotations(annotations)
+}
+
+// Options configures expiry.
+type Options struct {
+	// Expiry returns the expiry of an object. Defaults to AnnotationExpiry.
+	Expiry ExpiryFunc
+	// PropagationPolicy is used when deleting expired objects. Defaults to background.
+	PropagationPolicy metav1.DeletionPropagation
+	// Clock is used to determine whether objects have expired.
+	Clock clock.PassiveClock
+	// Recorder, if set, is used to record Warning events on objects with
+	// malformed expiries.
+	Recorder *events.Recorder
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Expiry == nil {
+		opts.Expiry = AnnotationExpiry
+	}
+
+	if opts.PropagationPolicy == "" {
+		opts.PropagationPolicy = metav1.DeletePropagationBackground
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+}
+
+// Check deletes the object if it has expired. Otherwise, it returns a
+// result that requeues the object at its expiry (if it has one). It
+// returns true if the object was deleted. Malformed expiries (eg. an
+// unparseable annotation) won't be fixed by retrying, so a terminal error is
+// returned (and a Warning event recorded), the object is reconciled again
+// once it is updated. Check can be called from an existing reconciler, or
+// used via the standalone Reconciler.
+func Check(ctx context.Context, c client.Client, obj client.Object, opts Options) (ctrl.Result, bool, error) {
+	opts.setDefaults()
+
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, false, nil
+	}
+
+	expiresAt, ok, err := opts.Expiry(obj)
+	if err != nil {
+		if opts.Recorder != nil {
+			opts.Recorder.Warningf(obj, events.ReasonInvalidExpiry, "Invalid expiry: %v", err)
+		}
+
+		return ctrl.Result{}, false, reconcile.TerminalError(fmt.Errorf("failed to get expiry: %w", err))
+	}
+
+	if !ok {
+		return ctrl.Result{}, false, nil
+	}
+
+	if remaining := expiresAt.Sub(opts.Clock.Now()); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, false, nil
+	}
+
+	log.FromContext(ctx).Info("Deleting expired object", "expiresAt", expiresAt)
+
+	// Guard against deleting a recreated object with the same name.
+	if err := c.Delete(ctx, obj, &client.DeleteOptions{
+		PropagationPolicy: ptr.To(opts.PropagationPolicy),
+		Preconditions:     &metav1.Preconditions{UID: ptr.To(obj.GetUID())},
+	}); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, false, fmt.Errorf("failed to delete expired object: %w", err)
+	}
+
+	return ctrl.Result{}, true, nil
+}
+
+// Reconciler deletes expired objects of a single kind.
+type Reconciler struct {
+	client client.Client
+	newObj func() client.Object
+	opts   Options
+}
+
+var _ reconcile.Reconciler = (*Reconciler)(nil)
+
+// NewReconciler returns a reconciler that deletes expired objects. newObj
+// returns an empty object of the reconciled kind.
+func NewReconciler(c client.Client, newObj func() client.Object, opts Options) *Reconciler {
+	opts.setDefaults()
+
+	return &Reconciler{
+		client: c,
+		newObj: newObj,
+		opts:   opts,
+	}
+}
+
+// Reconcile deletes the object if it has expired, and otherwise requeues
+// it at its expiry.
+func (r *Reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	obj := r.newObj()
+	if err := r.client.Get(ctx, req.NamespacedName, obj); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	res, _, err := Check(ctx, r.client, obj, r.opts)
+	return res, err
+}
+
+// SetupWithManager registers the reconciler with the manager, as a
+// controller with the given name.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager, name string) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(name).
+		For(r.newObj(), builder.WithPredicates(HasExpiry(r.opts.Expiry))).
+		Complete(r)
+}
+
+// HasExpiry filters out events for objects without an expiry.
+func HasExpiry(expiry ExpiryFunc) predicate.Predicate {
+	hasExpiry := func(obj client.Object) bool {
+		// Objects with malformed expiries are passed through, so the
+		// error is surfaced by the reconciler.
+		_, ok, err := expiry(obj)
+		return ok || err != nil
+	}
+
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return hasExpiry(e.Object) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return hasExpiry(e.ObjectNew) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+		GenericFunc: func(e event.GenericEvent) bool { return hasExpiry(e.Object) },
+	}
+}
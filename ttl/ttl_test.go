@@ -0,0 +1,163 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ttl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/events"
+	"github.com/gpu-ninja/operator-utils/ttl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestAnnotationExpiry(t *testing.T) {
+	created := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)}}
+
+	_, ok, err := ttl.AnnotationExpiry(obj)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ttl.SetTTL(obj, time.Hour)
+
+	expiresAt, ok, err := ttl.AnnotationExpiry(obj)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, created.Add(time.Hour), expiresAt)
+
+	// The earliest expiry wins.
+	ttl.SetExpiresAt(obj, created.Add(time.Minute))
+
+	expiresAt, _, err = ttl.AnnotationExpiry(obj)
+	require.NoError(t, err)
+	assert.Equal(t, created.Add(time.Minute), expiresAt)
+
+	obj.Annotations[ttl.TTLAnnotation] = "forever"
+	_, _, err = ttl.AnnotationExpiry(obj)
+	assert.Error(t, err)
+}
+
+func TestReconciler(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakePassiveClock(now)
+
+	preview := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "preview",
+			UID:       "preview-uid",
+		},
+	}
+	ttl.SetExpiresAt(preview, now.Add(30*time.Minute))
+
+	permanent := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "permanent",
+			UID:       "permanent-uid",
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(preview, permanent).Build()
+
+	r := ttl.NewReconciler(c, func() client.Object { return &corev1.ConfigMap{} }, ttl.Options{Clock: clock})
+
+	res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(preview)})
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, res.RequeueAfter)
+
+	res, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(permanent)})
+	require.NoError(t, err)
+	assert.Zero(t, res)
+
+	clock.SetTime(now.Add(31 * time.Minute))
+
+	res, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(preview)})
+	require.NoError(t, err)
+	assert.Zero(t, res)
+
+	err = c.Get(ctx, client.ObjectKeyFromObject(preview), &corev1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(permanent), &corev1.ConfigMap{}))
+
+	// Already deleted.
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(preview)})
+	require.NoError(t, err)
+}
+
+func TestReconcilerMalformedExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	malformed := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "malformed",
+			Annotations: map[string]string{ttl.TTLAnnotation: "forever"},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(malformed).Build()
+
+	fakeRecorder := record.NewFakeRecorder(10)
+	r := ttl.NewReconciler(c, func() client.Object { return &corev1.ConfigMap{} }, ttl.Options{
+		Recorder: events.NewRecorder(fakeRecorder),
+	})
+
+	// Retrying won't fix the annotation.
+	_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(malformed)})
+	require.ErrorIs(t, err, reconcile.TerminalError(nil))
+
+	require.Len(t, fakeRecorder.Events, 1)
+	assert.Contains(t, <-fakeRecorder.Events, string(events.ReasonInvalidExpiry))
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(malformed), &corev1.ConfigMap{}))
+}
+
+func TestHasExpiry(t *testing.T) {
+	p := ttl.HasExpiry(ttl.AnnotationExpiry)
+
+	obj := &corev1.ConfigMap{}
+	assert.False(t, p.Create(event.CreateEvent{Object: obj}))
+
+	ttl.SetTTL(obj, time.Hour)
+	assert.True(t, p.Create(event.CreateEvent{Object: obj}))
+	assert.False(t, p.Delete(event.DeleteEvent{Object: obj}))
+}
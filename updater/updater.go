@@ -31,15 +31,33 @@ import (
 
 type MutateFunc func() error
 
+// PreApplyHook is called with the template before an object is created or
+// updated (eg. to validate it). If it returns an error, the object is not
+// applied. Hooks are passed a copy of the template, which they may mutate
+// (eg. to add audit annotations), such changes are written along with the
+// template, but don't by themselves cause the object to be updated.
+type PreApplyHook func(ctx context.Context, template client.Object) error
+
 // CreateOrUpdateFromTemplate creates or updates the given object using the given template.
-func CreateOrUpdateFromTemplate(ctx context.Context, c client.Client, template client.Object) (client.Object, error) {
+func CreateOrUpdateFromTemplate(ctx context.Context, c client.Client, template client.Object, hooks ...PreApplyHook) (client.Object, error) {
+	// Hooks are run on a copy, so the caller's template isn't mutated, and
+	// the hash is taken before they run, so their changes don't by themselves
+	// cause an update.
 	templateHash := HashObject(template)
 
-	obj, ok := template.DeepCopyObject().(client.Object)
+	template, ok := template.DeepCopyObject().(client.Object)
 	if !ok {
 		return nil, fmt.Errorf("expected client object")
 	}
 
+	for _, hook := range hooks {
+		if err := hook(ctx, template); err != nil {
+			return nil, fmt.Errorf("pre-apply hook failed: %w", err)
+		}
+	}
+
+	obj := template.DeepCopyObject().(client.Object)
+
 	key := client.ObjectKeyFromObject(obj)
 	if err := c.Get(ctx, key, obj); err != nil {
 		if !apierrors.IsNotFound(err) {
@@ -85,6 +103,23 @@ func CreateOrUpdateFromTemplate(ctx context.Context, c client.Client, template c
 	return obj, nil
 }
 
+// ApplyAll creates or updates each of the given templates in order, stopping
+// at the first that fails. It returns the applied objects.
+func ApplyAll(ctx context.Context, c client.Client, templates []client.Object, hooks ...PreApplyHook) ([]client.Object, error) {
+	objs := make([]client.Object, 0, len(templates))
+	for _, template := range templates {
+		obj, err := CreateOrUpdateFromTemplate(ctx, c, template, hooks...)
+		if err != nil {
+			return objs, fmt.Errorf("failed to apply %s %q: %w",
+				template.GetObjectKind().GroupVersionKind().Kind, template.GetName(), err)
+		}
+
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
+
 // UpdateStatus updates the status of the given object using a mutating function.
 func UpdateStatus(ctx context.Context, c client.Client, key client.ObjectKey, obj client.Object, f MutateFunc) error {
 	if err := c.Get(ctx, key, obj); err != nil {
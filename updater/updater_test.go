@@ -19,6 +19,7 @@ package updater_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/gpu-ninja/operator-utils/updater"
@@ -28,6 +29,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
@@ -68,4 +70,32 @@ func TestCreateOrUpdateFromTemplate(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, "c2f1de77", hash)
+
+	t.Run("PreApplyHook", func(t *testing.T) {
+		rejectedTemplate := template.DeepCopy()
+		rejectedTemplate.Spec.Replicas = ptr.To(int32(3))
+
+		errRejected := errors.New("rejected")
+		_, err := updater.CreateOrUpdateFromTemplate(ctx, c, rejectedTemplate, func(ctx context.Context, template client.Object) error {
+			return errRejected
+		})
+		require.ErrorIs(t, err, errRejected)
+
+		var current appsv1.Deployment
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(&template), &current))
+		assert.Equal(t, int32(2), *current.Spec.Replicas)
+
+		// Hooks mutate a copy, not the caller's template.
+		annotatedTemplate := template.DeepCopy()
+		annotatedTemplate.Spec.Replicas = ptr.To(int32(4))
+
+		obj, err := updater.CreateOrUpdateFromTemplate(ctx, c, annotatedTemplate, func(ctx context.Context, template client.Object) error {
+			template.SetAnnotations(map[string]string{"audit": "true"})
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "true", obj.GetAnnotations()["audit"])
+		assert.NotContains(t, annotatedTemplate.Annotations, "audit")
+	})
 }
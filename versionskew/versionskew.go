@@ -0,0 +1,360 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package versionskew detects skew between the running operator and the
+// cluster, ie. when the operator's version differs from the desired version
+// (eg. mid upgrade or after a rollback), or when the operator's CRDs were
+// applied by (or serve API versions only understood by) a newer operator.
+// Skew is surfaced through a condition and metrics, and reconciliation of
+// objects requiring a newer operator can optionally be blocked.
+package versionskew
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gpu-ninja/operator-utils/crds"
+	"github.com/gpu-ninja/operator-utils/metrics"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// MinOperatorVersionAnnotation records the minimum operator version
+	// required to reconcile an object (eg. set by a newer operator or by
+	// tooling using features the running operator may not understand).
+	MinOperatorVersionAnnotation = "gpu-ninja.com/min-operator-version"
+	// ConditionTypeVersionSkew is the type of the version skew condition.
+	ConditionTypeVersionSkew = "VersionSkew"
+	// ReasonNoSkew is the condition reason when no skew was detected.
+	ReasonNoSkew = "NoSkew"
+)
+
+// ErrObjectTooNew is returned when an object requires a newer operator.
+var ErrObjectTooNew = errors.New("object requires a newer operator")
+
+// Type is a type of version skew.
+type Type string
+
+const (
+	// TypeDesiredVersion indicates the operator is not running the desired version.
+	TypeDesiredVersion Type = "DesiredVersionMismatch"
+	// TypeCRDVersion indicates a CRD was applied by a newer operator.
+	TypeCRDVersion Type = "CRDVersionNewer"
+	// TypeUnknownAPIVersion indicates a CRD serves an API version the operator doesn't understand.
+	TypeUnknownAPIVersion Type = "UnknownAPIVersion"
+)
+
+var allTypes = []Type{TypeDesiredVersion, TypeCRDVersion, TypeUnknownAPIVersion}
+
+// Skew is a detected version skew.
+type Skew struct {
+	// Type is the type of skew.
+	Type Type
+	// Message is a human readable description of the skew.
+	Message string
+}
+
+// VersionFunc returns a desired version, or an empty string if there is none.
+type VersionFunc func(ctx context.Context) (string, error)
+
+// Options configures a Checker.
+type Options struct {
+	// Version is the semantic version of the running operator.
+	Version string
+	// DesiredVersion, if set, returns the desired operator version (see
+	// ConfigMapVersion and FieldVersion).
+	DesiredVersion VersionFunc
+	// CRDs are the names of the operator's CRDs.
+	CRDs []string
+	// Scheme, if set, is used to check that every version served by the
+	// CRDs is understood by the operator.
+	Scheme *runtime.Scheme
+}
+
+// Checker checks for version skew.
+type Checker struct {
+	c       client.Reader
+	opts    Options
+	version *utilversion.Version
+}
+
+// NewChecker returns a new version skew checker.
+func NewChecker(c client.Reader, opts Options) (*Checker, error) {
+	version, err := utilversion.ParseSemantic(opts.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse operator version %q: %w", opts.Version, err)
+	}
+
+	return &Checker{
+		c:       c,
+		opts:    opts,
+		version: version,
+	}, nil
+}
+
+// Report is the result of a version skew check.
+type Report struct {
+	// Version is the running operator version.
+	Version string
+	// DesiredVersion is the desired operator version, if any.
+	DesiredVersion string
+	// Skews are the detected skews.
+	Skews []Skew
+}
+
+// Check checks for version skew, and records the number of detected skews
+// by type in the metrics.
+func (ch *Checker) Check(ctx context.Context) (*Report, error) {
+	report := &Report{Version: ch.opts.Version}
+
+	if ch.opts.DesiredVersion != nil {
+		desired, err := ch.opts.DesiredVersion(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get desired version: %w", err)
+		}
+
+		report.DesiredVersion = desired
+
+		if desired != "" {
+			skew, err := ch.checkDesiredVersion(desired)
+			if err != nil {
+				return nil, err
+			}
+
+			if skew != nil {
+				report.Skews = append(report.Skews, *skew)
+			}
+		}
+	}
+
+	for _, name := range ch.opts.CRDs {
+		var crd apiextensionsv1.CustomResourceDefinition
+		if err := ch.c.Get(ctx, client.ObjectKey{Name: name}, &crd); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to get crd %q: %w", name, err)
+		}
+
+		report.Skews = append(report.Skews, ch.checkCRD(ctx, &crd)...)
+	}
+
+	counts := make(map[Type]int)
+	for _, skew := range report.Skews {
+		counts[skew.Type]++
+	}
+
+	for _, t := range allTypes {
+		metrics.VersionSkew.WithLabelValues(string(t)).Set(float64(counts[t]))
+	}
+
+	return report, nil
+}
+
+func (ch *Checker) checkDesiredVersion(desired string) (*Skew, error) {
+	desiredVersion, err := utilversion.ParseSemantic(desired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse desired version %q: %w", desired, err)
+	}
+
+	var relation string
+	switch {
+	case ch.version.LessThan(desiredVersion):
+		relation = "older"
+	case desiredVersion.LessThan(ch.version):
+		relation = "newer"
+	default:
+		return nil, nil
+	}
+
+	return &Skew{
+		Type:    TypeDesiredVersion,
+		Message: fmt.Sprintf("operator version %s is %s than the desired version %s", ch.opts.Version, relation, desired),
+	}, nil
+}
+
+func (ch *Checker) checkCRD(ctx context.Context, crd *apiextensionsv1.CustomResourceDefinition) []Skew {
+	logger := log.FromContext(ctx)
+
+	var skews []Skew
+
+	if applied := crd.GetAnnotations()[crds.VersionAnnotation]; applied != "" {
+		appliedVersion, err := utilversion.ParseSemantic(applied)
+		if err != nil {
+			logger.Info("Ignoring invalid crd version annotation", "crd", crd.Name, "version", applied)
+		} else if ch.version.LessThan(appliedVersion) {
+			skews = append(skews, Skew{
+				Type:    TypeCRDVersion,
+				Message: fmt.Sprintf("crd %q was applied by a newer operator (%s)", crd.Name, applied),
+			})
+		}
+	}
+
+	if ch.opts.Scheme != nil {
+		var unknown []string
+		for _, v := range crd.Spec.Versions {
+			gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: v.Name, Kind: crd.Spec.Names.Kind}
+			if v.Served && !ch.opts.Scheme.Recognizes(gvk) {
+				unknown = append(unknown, v.Name)
+			}
+		}
+
+		if len(unknown) > 0 {
+			skews = append(skews, Skew{
+				Type:    TypeUnknownAPIVersion,
+				Message: fmt.Sprintf("crd %q serves versions not understood by the operator: %s", crd.Name, strings.Join(unknown, ", ")),
+			})
+		}
+	}
+
+	return skews
+}
+
+// Skewed returns true if any skew was detected.
+func (r *Report) Skewed() bool {
+	return len(r.Skews) > 0
+}
+
+// Condition returns the VersionSkew condition.
+func (r *Report) Condition(generation int64) metav1.Condition {
+	if !r.Skewed() {
+		return metav1.Condition{
+			Type:               ConditionTypeVersionSkew,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: generation,
+			Reason:             ReasonNoSkew,
+			Message:            fmt.Sprintf("Operator version %s", r.Version),
+		}
+	}
+
+	messages := make([]string, 0, len(r.Skews))
+	for _, skew := range r.Skews {
+		messages = append(messages, skew.Message)
+	}
+
+	return metav1.Condition{
+		Type:               ConditionTypeVersionSkew,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             string(r.Skews[0].Type),
+		Message:            strings.Join(messages, "; "),
+	}
+}
+
+// SetCondition sets the VersionSkew condition on the given conditions slice.
+func (r *Report) SetCondition(conditions *[]metav1.Condition, generation int64) {
+	meta.SetStatusCondition(conditions, r.Condition(generation))
+}
+
+// CheckObject returns ErrObjectTooNew if the object requires a newer
+// operator (see MinOperatorVersionAnnotation).
+func (ch *Checker) CheckObject(obj client.Object) error {
+	required := obj.GetAnnotations()[MinOperatorVersionAnnotation]
+	if required == "" {
+		return nil
+	}
+
+	requiredVersion, err := utilversion.ParseSemantic(required)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s annotation %q: %w", MinOperatorVersionAnnotation, required, err)
+	}
+
+	if ch.version.LessThan(requiredVersion) {
+		return fmt.Errorf("%w: %q requires operator version %s, running %s",
+			ErrObjectTooNew, obj.GetName(), required, ch.opts.Version)
+	}
+
+	return nil
+}
+
+// Wrap wraps a reconciler so that objects requiring a newer operator are
+// not reconciled (a terminal error is returned instead).
+func (ch *Checker) Wrap(r reconcile.Reconciler, c client.Reader, newObject func() client.Object) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		obj := newObject()
+		if err := c.Get(ctx, req.NamespacedName, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				return r.Reconcile(ctx, req)
+			}
+
+			return reconcile.Result{}, fmt.Errorf("failed to get object: %w", err)
+		}
+
+		if err := ch.CheckObject(obj); err != nil {
+			log.FromContext(ctx).Info("Not reconciling object", "reason", err.Error())
+
+			return reconcile.Result{}, reconcile.TerminalError(err)
+		}
+
+		return r.Reconcile(ctx, req)
+	})
+}
+
+// ConfigMapVersion returns the desired version recorded in a ConfigMap. A
+// missing ConfigMap (or key) means there is no desired version.
+func ConfigMapVersion(c client.Reader, key client.ObjectKey, dataKey string) VersionFunc {
+	return func(ctx context.Context) (string, error) {
+		var cm corev1.ConfigMap
+		if err := c.Get(ctx, key, &cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				return "", nil
+			}
+
+			return "", fmt.Errorf("failed to get configmap: %w", err)
+		}
+
+		return cm.Data[dataKey], nil
+	}
+}
+
+// FieldVersion returns the desired version recorded in a field of an object
+// (eg. spec.version of a custom resource). A missing object (or field) means
+// there is no desired version.
+func FieldVersion(c client.Reader, gvk schema.GroupVersionKind, key client.ObjectKey, fields ...string) VersionFunc {
+	return func(ctx context.Context) (string, error) {
+		var obj unstructured.Unstructured
+		obj.SetGroupVersionKind(gvk)
+		if err := c.Get(ctx, key, &obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				return "", nil
+			}
+
+			return "", fmt.Errorf("failed to get %s: %w", gvk.Kind, err)
+		}
+
+		version, _, err := unstructured.NestedString(obj.Object, fields...)
+		if err != nil {
+			return "", fmt.Errorf("failed to get version from %s: %w", strings.Join(fields, "."), err)
+		}
+
+		return version, nil
+	}
+}
@@ -0,0 +1,149 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package versionskew_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/crds"
+	"github.com/gpu-ninja/operator-utils/metrics"
+	"github.com/gpu-ninja/operator-utils/versionskew"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestChecker(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, apiextensionsv1.AddToScheme(scheme))
+
+	// The operator only understands v1alpha1.
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "example.com", Version: "v1alpha1", Kind: "Widget"}, &corev1.Endpoints{})
+
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "widgets.example.com",
+			Annotations: map[string]string{crds.VersionAnnotation: "1.2.0"},
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "example.com",
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: "Widget"},
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{Name: "v1alpha1", Served: true},
+				{Name: "v1beta1", Served: true, Storage: true},
+			},
+		},
+	}
+
+	desired := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "operator-system", Name: "operator-version"},
+		Data:       map[string]string{"version": "v1.2.0"},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd, desired).Build()
+
+	ch, err := versionskew.NewChecker(c, versionskew.Options{
+		Version:        "v1.1.0",
+		DesiredVersion: versionskew.ConfigMapVersion(c, client.ObjectKeyFromObject(desired), "version"),
+		CRDs:           []string{"widgets.example.com", "missing.example.com"},
+		Scheme:         scheme,
+	})
+	require.NoError(t, err)
+
+	report, err := ch.Check(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2.0", report.DesiredVersion)
+	require.True(t, report.Skewed())
+	assert.Equal(t, []versionskew.Skew{
+		{Type: versionskew.TypeDesiredVersion, Message: "operator version v1.1.0 is older than the desired version v1.2.0"},
+		{Type: versionskew.TypeCRDVersion, Message: `crd "widgets.example.com" was applied by a newer operator (1.2.0)`},
+		{Type: versionskew.TypeUnknownAPIVersion, Message: `crd "widgets.example.com" serves versions not understood by the operator: v1beta1`},
+	}, report.Skews)
+
+	condition := report.Condition(3)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, string(versionskew.TypeDesiredVersion), condition.Reason)
+	assert.Equal(t, int64(3), condition.ObservedGeneration)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.VersionSkew.WithLabelValues(string(versionskew.TypeCRDVersion))))
+
+	t.Run("NoSkew", func(t *testing.T) {
+		ch, err := versionskew.NewChecker(c, versionskew.Options{
+			Version:        "1.2.0",
+			DesiredVersion: versionskew.ConfigMapVersion(c, client.ObjectKeyFromObject(desired), "version"),
+			CRDs:           []string{"widgets.example.com"},
+		})
+		require.NoError(t, err)
+
+		report, err := ch.Check(ctx)
+		require.NoError(t, err)
+		assert.False(t, report.Skewed())
+		assert.Equal(t, versionskew.ReasonNoSkew, report.Condition(1).Reason)
+		assert.Equal(t, 0.0, testutil.ToFloat64(metrics.VersionSkew.WithLabelValues(string(versionskew.TypeCRDVersion))))
+	})
+
+	t.Run("Wrap", func(t *testing.T) {
+		tooNew := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "too-new",
+				Annotations: map[string]string{versionskew.MinOperatorVersionAnnotation: "1.3.0"},
+			},
+		}
+		supported := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "supported",
+				Annotations: map[string]string{versionskew.MinOperatorVersionAnnotation: "1.0.0"},
+			},
+		}
+		require.NoError(t, c.Create(ctx, tooNew))
+		require.NoError(t, c.Create(ctx, supported))
+
+		var reconciled []string
+		r := ch.Wrap(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+			reconciled = append(reconciled, req.Name)
+			return reconcile.Result{}, nil
+		}), c, func() client.Object { return &corev1.ConfigMap{} })
+
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(tooNew)})
+		require.ErrorIs(t, err, versionskew.ErrObjectTooNew)
+		assert.ErrorIs(t, err, reconcile.TerminalError(nil))
+
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(supported)})
+		require.NoError(t, err)
+
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "deleted"}})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"supported", "deleted"}, reconciled)
+	})
+}
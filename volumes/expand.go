@@ -0,0 +1,249 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package volumes provides helpers for managing persistent volume claims.
+package volumes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// DefaultStorageClassAnnotation marks the cluster's default storage class.
+	DefaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+)
+
+var (
+	// ErrExpansionNotAllowed is returned when the claim's storage class does not allow volume expansion.
+	ErrExpansionNotAllowed = errors.New("storage class does not allow volume expansion")
+	// ErrShrinkNotSupported is returned when the requested size is smaller than the current size.
+	ErrShrinkNotSupported = errors.New("persistent volume claims cannot be shrunk")
+)
+
+// ExpansionPhase is the phase of a volume expansion.
+type ExpansionPhase string
+
+const (
+	// ExpansionComplete indicates the volume (and its filesystem) has been expanded.
+	ExpansionComplete ExpansionPhase = "Complete"
+	// ExpansionResizing indicates the volume is being expanded by the storage provider.
+	ExpansionResizing ExpansionPhase = "Resizing"
+	// ExpansionFileSystemResizePending indicates the volume has been expanded,
+	// and the filesystem will be expanded when the volume is next mounted.
+	ExpansionFileSystemResizePending ExpansionPhase = "FileSystemResizePending"
+)
+
+// ExpandOptions configures volume expansion.
+type ExpandOptions struct {
+	// OnlineExpansion indicates the storage provider can expand the filesystem
+	// of a mounted volume. If false, pods using the claim are restarted (deleted,
+	// so that their controller recreates them) once the filesystem resize is pending.
+	OnlineExpansion bool
+}
+
+// ExpansionStatus is the status of a volume expansion.
+type ExpansionStatus struct {
+	// Phase is the phase of the expansion.
+	Phase ExpansionPhase
+	// Requested is the requested size of the volume.
+	Requested resource.Quantity
+	// Capacity is the current capacity of the volume.
+	Capacity resource.Quantity
+	// RestartedPods are the pods that were restarted to complete the expansion.
+	RestartedPods []string
+	// Message is a human readable explanation of the expansion's progress.
+	Message string
+}
+
+// Complete returns true if the expansion is complete.
+func (s *ExpansionStatus) Complete() bool {
+	return s.Phase == ExpansionComplete
+}
+
+// Expand requests that the claim be expanded to the given size, and reports
+// the progress of the expansion. It is intended to be called on every
+// reconcile until the expansion is complete. Expanding a claim to its
+// current size is a no-op.
+func Expand(ctx context.Context, c client.Client, pvc *corev1.PersistentVolumeClaim, size resource.Quantity, opts ExpandOptions) (*ExpansionStatus, error) {
+	requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+
+	switch size.Cmp(requested) {
+	case -1:
+		return nil, fmt.Errorf("%w: %s is smaller than %s", ErrShrinkNotSupported, size.String(), requested.String())
+	case 1:
+		if err := checkExpansionAllowed(ctx, c, pvc); err != nil {
+			return nil, err
+		}
+
+		patch := client.MergeFrom(pvc.DeepCopy())
+
+		if pvc.Spec.Resources.Requests == nil {
+			pvc.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+
+		if err := c.Patch(ctx, pvc, patch); err != nil {
+			return nil, fmt.Errorf("failed to patch persistent volume claim: %w", err)
+		}
+
+		log.FromContext(ctx).Info("Requested volume expansion",
+			"name", pvc.Name, "from", requested.String(), "to", size.String())
+	}
+
+	status := &ExpansionStatus{
+		Requested: size,
+		Capacity:  pvc.Status.Capacity[corev1.ResourceStorage],
+	}
+
+	if cond := getCondition(pvc, corev1.PersistentVolumeClaimFileSystemResizePending); cond != nil {
+		status.Phase = ExpansionFileSystemResizePending
+		status.Message = "waiting for filesystem to be resized"
+
+		if !opts.OnlineExpansion {
+			restarted, err := restartPods(ctx, c, pvc, cond.LastTransitionTime)
+			if err != nil {
+				return nil, err
+			}
+
+			status.RestartedPods = restarted
+			status.Message = "waiting for filesystem to be resized on pod restart"
+		}
+
+		return status, nil
+	}
+
+	if status.Capacity.Cmp(size) < 0 {
+		status.Phase = ExpansionResizing
+		status.Message = fmt.Sprintf("waiting for volume to be resized from %s to %s", status.Capacity.String(), size.String())
+
+		if cond := getCondition(pvc, corev1.PersistentVolumeClaimResizing); cond != nil && cond.Message != "" {
+			status.Message += ": " + cond.Message
+		}
+
+		return status, nil
+	}
+
+	status.Phase = ExpansionComplete
+	status.Message = fmt.Sprintf("volume has capacity %s", status.Capacity.String())
+
+	return status, nil
+}
+
+// StorageClassForClaim returns the storage class of a claim (or the cluster's
+// default storage class, if the claim does not specify one).
+func StorageClassForClaim(ctx context.Context, c client.Client, pvc *corev1.PersistentVolumeClaim) (*storagev1.StorageClass, error) {
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		var sc storagev1.StorageClass
+		if err := c.Get(ctx, client.ObjectKey{Name: *pvc.Spec.StorageClassName}, &sc); err != nil {
+			return nil, fmt.Errorf("failed to get storage class: %w", err)
+		}
+
+		return &sc, nil
+	}
+
+	var storageClasses storagev1.StorageClassList
+	if err := c.List(ctx, &storageClasses); err != nil {
+		return nil, fmt.Errorf("failed to list storage classes: %w", err)
+	}
+
+	for i := range storageClasses.Items {
+		if storageClasses.Items[i].Annotations[DefaultStorageClassAnnotation] == "true" {
+			return &storageClasses.Items[i], nil
+		}
+	}
+
+	return nil, apierrors.NewNotFound(storagev1.Resource("storageclasses"), "default")
+}
+
+func checkExpansionAllowed(ctx context.Context, c client.Client, pvc *corev1.PersistentVolumeClaim) error {
+	sc, err := StorageClassForClaim(ctx, c, pvc)
+	if err != nil {
+		return err
+	}
+
+	if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return fmt.Errorf("%w: %s", ErrExpansionNotAllowed, sc.Name)
+	}
+
+	return nil
+}
+
+// restartPods deletes the controlled pods mounting the claim that were
+// created before the filesystem resize became pending.
+func restartPods(ctx context.Context, c client.Client, pvc *corev1.PersistentVolumeClaim, pendingSince metav1.Time) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(pvc.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	var restarted []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+
+		if !usesClaim(pod, pvc.Name) || !pod.DeletionTimestamp.IsZero() || !pod.CreationTimestamp.Before(&pendingSince) {
+			continue
+		}
+
+		// Pods without a controller would not be recreated.
+		if metav1.GetControllerOf(pod) == nil {
+			logger.Info("Not restarting uncontrolled pod to complete volume expansion", "pod", pod.Name)
+			continue
+		}
+
+		if err := c.Delete(ctx, pod); err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to delete pod %q: %w", pod.Name, err)
+		}
+
+		logger.Info("Restarted pod to complete volume expansion", "pod", pod.Name, "claim", pvc.Name)
+
+		restarted = append(restarted, pod.Name)
+	}
+
+	return restarted, nil
+}
+
+func usesClaim(pod *corev1.Pod, claimName string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getCondition(pvc *corev1.PersistentVolumeClaim, condType corev1.PersistentVolumeClaimConditionType) *corev1.PersistentVolumeClaimCondition {
+	for i := range pvc.Status.Conditions {
+		if pvc.Status.Conditions[i].Type == condType && pvc.Status.Conditions[i].Status == corev1.ConditionTrue {
+			return &pvc.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
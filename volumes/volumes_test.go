@@ -0,0 +1,144 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volumes_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/volumes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExpand(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	fixed := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "fixed"},
+	}
+
+	expandable := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "expandable",
+			Annotations: map[string]string{volumes.DefaultStorageClassAnnotation: "true"},
+		},
+		AllowVolumeExpansion: ptr.To(true),
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data-db-0"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+		},
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "db-0",
+			CreationTimestamp: metav1.NewTime(now.Add(-time.Hour)),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "StatefulSet",
+				Name:       "db",
+				UID:        "1234",
+				Controller: ptr.To(true),
+			}},
+		},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-db-0"},
+				},
+			}},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithObjects(fixed, expandable, pvc, pod).WithStatusSubresource(pvc).Build()
+
+	t.Run("Shrink", func(t *testing.T) {
+		_, err := volumes.Expand(ctx, c, pvc, resource.MustParse("512Mi"), volumes.ExpandOptions{})
+		assert.ErrorIs(t, err, volumes.ErrShrinkNotSupported)
+	})
+
+	t.Run("Not Allowed", func(t *testing.T) {
+		pvc := pvc.DeepCopy()
+		pvc.Spec.StorageClassName = ptr.To("fixed")
+
+		_, err := volumes.Expand(ctx, c, pvc, resource.MustParse("2Gi"), volumes.ExpandOptions{})
+		assert.ErrorIs(t, err, volumes.ErrExpansionNotAllowed)
+	})
+
+	t.Run("Resizing", func(t *testing.T) {
+		status, err := volumes.Expand(ctx, c, pvc, resource.MustParse("2Gi"), volumes.ExpandOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, volumes.ExpansionResizing, status.Phase)
+
+		var updated corev1.PersistentVolumeClaim
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(pvc), &updated))
+
+		requested := updated.Spec.Resources.Requests[corev1.ResourceStorage]
+		assert.Equal(t, "2Gi", requested.String())
+	})
+
+	t.Run("File System Resize Pending", func(t *testing.T) {
+		pvc.Status.Capacity[corev1.ResourceStorage] = resource.MustParse("2Gi")
+		pvc.Status.Conditions = []corev1.PersistentVolumeClaimCondition{{
+			Type:               corev1.PersistentVolumeClaimFileSystemResizePending,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(now),
+		}}
+		require.NoError(t, c.Status().Update(ctx, pvc))
+
+		status, err := volumes.Expand(ctx, c, pvc, resource.MustParse("2Gi"), volumes.ExpandOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, volumes.ExpansionFileSystemResizePending, status.Phase)
+		assert.Equal(t, []string{"db-0"}, status.RestartedPods)
+
+		err = c.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		pvc.Status.Conditions = nil
+		require.NoError(t, c.Status().Update(ctx, pvc))
+
+		status, err := volumes.Expand(ctx, c, pvc, resource.MustParse("2Gi"), volumes.ExpandOptions{})
+		require.NoError(t, err)
+
+		assert.True(t, status.Complete())
+	})
+}
@@ -0,0 +1,181 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package waitutil provides context aware polling, with condition
+// combinators, first class support for retryable errors, and an injectable
+// clock so that waits can be driven by a fake clock in tests.
+package waitutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"k8s.io/utils/clock"
+)
+
+const (
+	DefaultInterval = time.Second
+)
+
+// ErrTimeout is returned when a condition is not met before the timeout.
+var ErrTimeout = errors.New("timed out waiting for condition")
+
+// ConditionFunc returns true once the condition is met. Returning a
+// retryable error continues polling, any other error stops polling.
+type ConditionFunc func(ctx context.Context) (bool, error)
+
+// CheckFunc returns nil once a check passes (or a retryable error while pending).
+type CheckFunc func(ctx context.Context) error
+
+// Options configures polling.
+type Options struct {
+	// Interval is how often the condition is checked.
+	Interval time.Duration
+	// Timeout, if set, is the maximum amount of time to wait.
+	Timeout time.Duration
+	// Clock is used to schedule checks and timeouts (useful for testing).
+	Clock clock.WithTicker
+}
+
+// PollUntilContext checks the condition immediately and then every interval
+// until it is met, it returns a non-retryable error, the timeout expires, or
+// the context is cancelled. On timeout, ErrTimeout is returned wrapping the
+// last retryable error (if any).
+func PollUntilContext(ctx context.Context, condition ConditionFunc, opts Options) error {
+	if opts.Interval == 0 {
+		opts.Interval = DefaultInterval
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock{}
+	}
+
+	pollCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var timedOut atomic.Bool
+	if opts.Timeout > 0 {
+		timer := opts.Clock.NewTimer(opts.Timeout)
+		defer timer.Stop()
+
+		go func() {
+			select {
+			case <-timer.C():
+				timedOut.Store(true)
+				cancel()
+			case <-pollCtx.Done():
+			}
+		}()
+	}
+
+	ticker := opts.Clock.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		done, err := condition(pollCtx)
+		switch {
+		case pollCtx.Err() != nil:
+			// The condition was likely interrupted, so its error isn't meaningful.
+		case err != nil && !retryable.Is(err):
+			return err
+		case err != nil:
+			lastErr = err
+		case done:
+			return nil
+		}
+
+		select {
+		case <-pollCtx.Done():
+			if !timedOut.Load() {
+				return ctx.Err()
+			}
+
+			if lastErr != nil {
+				return fmt.Errorf("%w: %w", ErrTimeout, lastErr)
+			}
+
+			return ErrTimeout
+		case <-ticker.C():
+		}
+	}
+}
+
+// Poll is PollUntilContext for checks.
+func Poll(ctx context.Context, check CheckFunc, opts Options) error {
+	return PollUntilContext(ctx, FromCheck(check), opts)
+}
+
+// FromCheck converts a check into a condition.
+func FromCheck(check CheckFunc) ConditionFunc {
+	return func(ctx context.Context) (bool, error) {
+		if err := check(ctx); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+}
+
+// All returns a condition that is met once all of the conditions are met
+// (checked in order, stopping at the first that isn't met).
+func All(conditions ...ConditionFunc) ConditionFunc {
+	return func(ctx context.Context) (bool, error) {
+		for _, condition := range conditions {
+			done, err := condition(ctx)
+			if err != nil || !done {
+				return false, err
+			}
+		}
+
+		return true, nil
+	}
+}
+
+// Any returns a condition that is met once any of the conditions are met.
+// Retryable errors from individual conditions are only returned if none
+// of the conditions are met.
+func Any(conditions ...ConditionFunc) ConditionFunc {
+	return func(ctx context.Context) (bool, error) {
+		var errs []error
+		for _, condition := range conditions {
+			done, err := condition(ctx)
+			if err != nil {
+				if !retryable.Is(err) {
+					return false, err
+				}
+
+				errs = append(errs, err)
+				continue
+			}
+
+			if done {
+				return true, nil
+			}
+		}
+
+		if len(errs) > 0 {
+			return false, retryable.New(errors.Join(errs...))
+		}
+
+		return false, nil
+	}
+}
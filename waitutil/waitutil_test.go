@@ -0,0 +1,132 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package waitutil_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gpu-ninja/operator-utils/retryable"
+	"github.com/gpu-ninja/operator-utils/waitutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var errNotReady = errors.New("not ready")
+
+func TestPollUntilContext(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("FakeClock", func(t *testing.T) {
+		clock := clocktesting.NewFakeClock(time.Now())
+
+		var attempts atomic.Int32
+		done := make(chan error)
+		go func() {
+			done <- waitutil.Poll(ctx, func(ctx context.Context) error {
+				if attempts.Add(1) < 3 {
+					return retryable.New(errNotReady)
+				}
+
+				return nil
+			}, waitutil.Options{Interval: time.Minute, Timeout: time.Hour, Clock: clock})
+		}()
+
+		for i := 0; i < 2; i++ {
+			require.Eventually(t, func() bool { return attempts.Load() == int32(i+1) }, time.Second, time.Millisecond)
+			clock.Step(time.Minute)
+		}
+
+		require.NoError(t, <-done)
+		assert.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		clock := clocktesting.NewFakeClock(time.Now())
+
+		var attempts atomic.Int32
+		done := make(chan error)
+		go func() {
+			done <- waitutil.Poll(ctx, func(ctx context.Context) error {
+				attempts.Add(1)
+				return retryable.New(errNotReady)
+			}, waitutil.Options{Interval: time.Minute, Timeout: 90 * time.Second, Clock: clock})
+		}()
+
+		require.Eventually(t, func() bool { return attempts.Load() == 1 }, time.Second, time.Millisecond)
+		clock.Step(time.Minute)
+		require.Eventually(t, func() bool { return attempts.Load() == 2 }, time.Second, time.Millisecond)
+		clock.Step(time.Minute)
+
+		err := <-done
+		assert.ErrorIs(t, err, waitutil.ErrTimeout)
+		assert.ErrorIs(t, err, errNotReady)
+	})
+
+	t.Run("NonRetryable", func(t *testing.T) {
+		err := waitutil.Poll(ctx, func(ctx context.Context) error {
+			return errNotReady
+		}, waitutil.Options{Interval: time.Millisecond})
+		assert.ErrorIs(t, err, errNotReady)
+		assert.NotErrorIs(t, err, waitutil.ErrTimeout)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := waitutil.PollUntilContext(cancelledCtx, func(ctx context.Context) (bool, error) {
+			return false, nil
+		}, waitutil.Options{Interval: time.Millisecond, Timeout: time.Second})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestCombinators(t *testing.T) {
+	ctx := context.Background()
+
+	met := func(ctx context.Context) (bool, error) { return true, nil }
+	unmet := func(ctx context.Context) (bool, error) { return false, nil }
+	pending := waitutil.FromCheck(func(ctx context.Context) error { return retryable.New(errNotReady) })
+	failed := waitutil.FromCheck(func(ctx context.Context) error { return errNotReady })
+
+	done, err := waitutil.All(met, met)(ctx)
+	assert.NoError(t, err)
+	assert.True(t, done)
+
+	done, err = waitutil.All(met, pending)(ctx)
+	assert.True(t, retryable.Is(err))
+	assert.False(t, done)
+
+	done, err = waitutil.Any(pending, unmet, met)(ctx)
+	assert.NoError(t, err)
+	assert.True(t, done)
+
+	done, err = waitutil.Any(pending, unmet)(ctx)
+	assert.True(t, retryable.Is(err))
+	assert.ErrorIs(t, err, errNotReady)
+	assert.False(t, done)
+
+	_, err = waitutil.Any(failed, met)(ctx)
+	assert.ErrorIs(t, err, errNotReady)
+	assert.False(t, retryable.Is(err))
+}
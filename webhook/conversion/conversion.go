@@ -0,0 +1,311 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package conversion provides utilities for hub and spoke CRD version conversion.
+// Inspired by the conversion utilities in Cluster API.
+// https://github.com/kubernetes-sigs/cluster-api/blob/main/util/conversion/conversion.go
+package conversion
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DataAnnotation is the annotation used to preserve hub fields that can't
+	// be represented in a spoke version, so that they survive a round trip.
+	DataAnnotation = "gpu-ninja.com/conversion-data"
+)
+
+// Funcs are the conversion functions between a spoke and the hub version.
+type Funcs[S, H client.Object] struct {
+	// ConvertTo converts the spoke version to the hub version.
+	ConvertTo func(src S, dst H) error
+	// ConvertFrom converts the hub version to the spoke version.
+	ConvertFrom func(src H, dst S) error
+	// Restore, if set, copies fields that can't be represented in the spoke
+	// version from a previously preserved hub object into the converted hub.
+	// Setting Restore enables annotation based round trip preservation.
+	Restore func(restored H, dst H)
+}
+
+// Registry is a registry of spoke to hub conversion functions.
+type Registry struct {
+	mu         sync.RWMutex
+	converters map[converterKey]*converter
+}
+
+type converterKey struct {
+	spoke reflect.Type
+	hub   reflect.Type
+}
+
+type converter struct {
+	convertTo   func(src, dst client.Object) error
+	convertFrom func(src, dst client.Object) error
+	restore     func(restored, dst client.Object)
+	newHub      func() client.Object
+}
+
+// NewRegistry returns a new, empty, conversion registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		converters: make(map[converterKey]*converter),
+	}
+}
+
+// Register registers the conversion functions for a spoke and hub type.
+func Register[S, H client.Object](r *Registry, funcs Funcs[S, H]) {
+	hubType := reflect.TypeOf((*H)(nil)).Elem()
+
+	c := &converter{
+		convertTo: func(src, dst client.Object) error {
+			return funcs.ConvertTo(src.(S), dst.(H))
+		},
+		convertFrom: func(src, dst client.Object) error {
+			return funcs.ConvertFrom(src.(H), dst.(S))
+		},
+		newHub: func() client.Object {
+			return reflect.New(hubType.Elem()).Interface().(client.Object)
+		},
+	}
+
+	if funcs.Restore != nil {
+		c.restore = func(restored, dst client.Object) {
+			funcs.Restore(restored.(H), dst.(H))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.converters[converterKey{
+		spoke: reflect.TypeOf((*S)(nil)).Elem(),
+		hub:   hubType,
+	}] = c
+}
+
+// ConvertTo converts the spoke object src into the hub object dst. It is
+// intended to be called from the spoke's conversion.Convertible implementation.
+func (r *Registry) ConvertTo(src, dst runtime.Object) error {
+	c, srcObj, dstObj, err := r.lookup(src, dst)
+	if err != nil {
+		return err
+	}
+
+	if err := c.convertTo(srcObj, dstObj); err != nil {
+		return fmt.Errorf("failed to convert %T to %T: %w", src, dst, err)
+	}
+
+	if c.restore == nil {
+		return nil
+	}
+
+	// The preserved data is specific to this conversion so shouldn't leak into the hub.
+	removeAnnotation(dstObj, DataAnnotation)
+
+	// Don't mutate the source object.
+	restored := c.newHub()
+	ok, err := UnmarshalData(srcObj.DeepCopyObject().(client.Object), restored)
+	if err != nil {
+		return fmt.Errorf("failed to restore preserved fields: %w", err)
+	}
+
+	if ok {
+		c.restore(restored, dstObj)
+	}
+
+	return nil
+}
+
+// ConvertFrom converts the hub object src into the spoke object dst. It is
+// intended to be called from the spoke's conversion.Convertible implementation.
+func (r *Registry) ConvertFrom(src, dst runtime.Object) error {
+	c, dstObj, srcObj, err := r.lookup(dst, src)
+	if err != nil {
+		return err
+	}
+
+	if err := c.convertFrom(srcObj, dstObj); err != nil {
+		return fmt.Errorf("failed to convert %T to %T: %w", src, dst, err)
+	}
+
+	if c.restore == nil {
+		return nil
+	}
+
+	if err := MarshalData(srcObj, dstObj); err != nil {
+		return fmt.Errorf("failed to preserve fields: %w", err)
+	}
+
+	return nil
+}
+
+// Convert converts src into dst in-process (eg. so a controller can read
+// objects stored in an older version). Conversions can be between a spoke
+// and its hub (in either direction), or between two spokes of the same hub
+// (by way of the hub).
+func (r *Registry) Convert(src, dst runtime.Object) error {
+	srcType, dstType := reflect.TypeOf(src), reflect.TypeOf(dst)
+
+	if srcType == dstType {
+		reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src.DeepCopyObject()).Elem())
+		return nil
+	}
+
+	r.mu.RLock()
+	_, isSpoke := r.converters[converterKey{spoke: srcType, hub: dstType}]
+	_, isHub := r.converters[converterKey{spoke: dstType, hub: srcType}]
+	hub := r.sharedHub(srcType, dstType)
+	r.mu.RUnlock()
+
+	switch {
+	case isSpoke:
+		return r.ConvertTo(src, dst)
+	case isHub:
+		return r.ConvertFrom(src, dst)
+	case hub != nil:
+		if err := r.ConvertTo(src, hub); err != nil {
+			return err
+		}
+
+		return r.ConvertFrom(hub, dst)
+	default:
+		return fmt.Errorf("no conversion registered between %T and %T", src, dst)
+	}
+}
+
+// ConvertUnstructured decodes an unstructured object (eg. as read in an older
+// version) into its registered type in the scheme, and converts it into dst.
+func (r *Registry) ConvertUnstructured(scheme *runtime.Scheme, src *unstructured.Unstructured, dst runtime.Object) error {
+	obj, err := scheme.New(src.GroupVersionKind())
+	if err != nil {
+		return fmt.Errorf("failed to create object: %w", err)
+	}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(src.Object, obj); err != nil {
+		return fmt.Errorf("failed to convert from unstructured: %w", err)
+	}
+
+	return r.Convert(obj, dst)
+}
+
+// sharedHub returns a new hub object that both spokes convert to, or nil if
+// there is none. The caller must hold the read lock.
+func (r *Registry) sharedHub(a, b reflect.Type) runtime.Object {
+	for key, c := range r.converters {
+		if key.spoke != a {
+			continue
+		}
+
+		if _, ok := r.converters[converterKey{spoke: b, hub: key.hub}]; ok {
+			return c.newHub()
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) lookup(spoke, hub runtime.Object) (*converter, client.Object, client.Object, error) {
+	r.mu.RLock()
+	c, ok := r.converters[converterKey{spoke: reflect.TypeOf(spoke), hub: reflect.TypeOf(hub)}]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("no conversion registered between %T and %T", spoke, hub)
+	}
+
+	spokeObj, ok := spoke.(client.Object)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("expected client object but got %T", spoke)
+	}
+
+	hubObj, ok := hub.(client.Object)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("expected client object but got %T", hub)
+	}
+
+	return c, spokeObj, hubObj, nil
+}
+
+// MarshalData stores the source object (sans metadata) as an annotation on
+// the destination object.
+func MarshalData(src, dst client.Object) error {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(src)
+	if err != nil {
+		return fmt.Errorf("failed to convert to unstructured: %w", err)
+	}
+	delete(u, "metadata")
+
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+
+	// Copy the annotations as they may be shared with the source object.
+	annotations := make(map[string]string)
+	for k, v := range dst.GetAnnotations() {
+		annotations[k] = v
+	}
+	annotations[DataAnnotation] = string(data)
+	dst.SetAnnotations(annotations)
+
+	return nil
+}
+
+// UnmarshalData restores the object preserved by MarshalData from an
+// annotation on the from object into the to object. The annotation is
+// removed from the from object. Returns false if there was no preserved data.
+func UnmarshalData(from client.Object, to any) (bool, error) {
+	data, ok := from.GetAnnotations()[DataAnnotation]
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), to); err != nil {
+		return false, fmt.Errorf("failed to unmarshal data: %w", err)
+	}
+
+	removeAnnotation(from, DataAnnotation)
+
+	return true, nil
+}
+
+func removeAnnotation(obj client.Object, key string) {
+	if _, ok := obj.GetAnnotations()[key]; !ok {
+		return
+	}
+
+	// Copy the annotations as they may be shared with another object.
+	var annotations map[string]string
+	for k, v := range obj.GetAnnotations() {
+		if k == key {
+			continue
+		}
+
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[k] = v
+	}
+	obj.SetAnnotations(annotations)
+}
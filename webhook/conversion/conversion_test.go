@@ -0,0 +1,215 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conversion_test
+
+import (
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/webhook/conversion"
+	"github.com/gpu-ninja/operator-utils/webhook/conversion/conversiontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRegistry(t *testing.T) {
+	r := newRegistry()
+
+	hub := &ThingV2{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: ThingV2Spec{
+			Size:     "large",
+			Replicas: 3,
+		},
+	}
+
+	spoke := &ThingV1{}
+	require.NoError(t, r.ConvertFrom(hub, spoke))
+
+	assert.Equal(t, "test", spoke.Name)
+	assert.Equal(t, "large", spoke.Spec.Size)
+	assert.Contains(t, spoke.Annotations, conversion.DataAnnotation)
+	// The hub shouldn't have been modified.
+	assert.NotContains(t, hub.Annotations, conversion.DataAnnotation)
+
+	roundTripped := &ThingV2{}
+	require.NoError(t, r.ConvertTo(spoke, roundTripped))
+
+	assert.Equal(t, hub, roundTripped)
+
+	t.Run("Unregistered", func(t *testing.T) {
+		err := conversion.NewRegistry().ConvertTo(spoke, roundTripped)
+		assert.Error(t, err)
+	})
+}
+
+func TestConvert(t *testing.T) {
+	r := newRegistry()
+
+	hub := &ThingV2{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       ThingV2Spec{Size: "large", Replicas: 3},
+	}
+
+	alpha := &ThingV1Alpha1{}
+	require.NoError(t, r.Convert(hub, alpha))
+	assert.Equal(t, "large", alpha.Spec.Size)
+
+	// Spoke to spoke, by way of the hub.
+	spoke := &ThingV1{}
+	require.NoError(t, r.Convert(alpha, spoke))
+	assert.Equal(t, "large", spoke.Spec.Size)
+
+	converted := &ThingV2{}
+	require.NoError(t, r.Convert(spoke, converted))
+	assert.Equal(t, hub, converted)
+
+	copied := &ThingV2{}
+	require.NoError(t, r.Convert(hub, copied))
+	assert.Equal(t, hub, copied)
+
+	t.Run("Unstructured", func(t *testing.T) {
+		gv := schema.GroupVersion{Group: "example.com", Version: "v1"}
+		scheme := runtime.NewScheme()
+		scheme.AddKnownTypes(gv, &ThingV1{})
+
+		u := &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": gv.String(),
+			"kind":       "ThingV1",
+			"metadata":   map[string]any{"name": "test"},
+			"spec":       map[string]any{"size": "small"},
+		}}
+
+		converted := &ThingV2{}
+		require.NoError(t, r.ConvertUnstructured(scheme, u, converted))
+		assert.Equal(t, "test", converted.Name)
+		assert.Equal(t, "small", converted.Spec.Size)
+	})
+
+	t.Run("Unregistered", func(t *testing.T) {
+		assert.Error(t, conversion.NewRegistry().Convert(spoke, converted))
+	})
+}
+
+func TestFuzzRoundTrip(t *testing.T) {
+	conversiontest.FuzzRoundTrip(t, conversiontest.FuzzInput{
+		Registry: newRegistry(),
+		NewSpoke: func() client.Object { return &ThingV1{} },
+		NewHub:   func() client.Object { return &ThingV2{} },
+	})
+}
+
+func newRegistry() *conversion.Registry {
+	r := conversion.NewRegistry()
+
+	conversion.Register(r, conversion.Funcs[*ThingV1, *ThingV2]{
+		ConvertTo: func(src *ThingV1, dst *ThingV2) error {
+			dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+			dst.Spec.Size = src.Spec.Size
+			return nil
+		},
+		ConvertFrom: func(src *ThingV2, dst *ThingV1) error {
+			dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+			dst.Spec.Size = src.Spec.Size
+			return nil
+		},
+		Restore: func(restored *ThingV2, dst *ThingV2) {
+			dst.Spec.Replicas = restored.Spec.Replicas
+		},
+	})
+
+	conversion.Register(r, conversion.Funcs[*ThingV1Alpha1, *ThingV2]{
+		ConvertTo: func(src *ThingV1Alpha1, dst *ThingV2) error {
+			dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+			dst.Spec.Size = src.Spec.Size
+			return nil
+		},
+		ConvertFrom: func(src *ThingV2, dst *ThingV1Alpha1) error {
+			dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+			dst.Spec.Size = src.Spec.Size
+			return nil
+		},
+		Restore: func(restored *ThingV2, dst *ThingV2) {
+			dst.Spec.Replicas = restored.Spec.Replicas
+		},
+	})
+
+	return r
+}
+
+type ThingV1Alpha1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ThingV1Spec `json:"spec,omitempty"`
+}
+
+func (in *ThingV1Alpha1) DeepCopyObject() runtime.Object {
+	out := &ThingV1Alpha1{
+		TypeMeta: in.TypeMeta,
+		Spec:     in.Spec,
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+
+	return out
+}
+
+type ThingV1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ThingV1Spec `json:"spec,omitempty"`
+}
+
+type ThingV1Spec struct {
+	Size string `json:"size,omitempty"`
+}
+
+func (in *ThingV1) DeepCopyObject() runtime.Object {
+	out := &ThingV1{
+		TypeMeta: in.TypeMeta,
+		Spec:     in.Spec,
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+
+	return out
+}
+
+type ThingV2 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ThingV2Spec `json:"spec,omitempty"`
+}
+
+type ThingV2Spec struct {
+	Size string `json:"size,omitempty"`
+	// Replicas is not present in v1.
+	Replicas int32 `json:"replicas,omitempty"`
+}
+
+func (in *ThingV2) DeepCopyObject() runtime.Object {
+	out := &ThingV2{
+		TypeMeta: in.TypeMeta,
+		Spec:     in.Spec,
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+
+	return out
+}
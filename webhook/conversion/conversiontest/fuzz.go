@@ -0,0 +1,129 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package conversiontest provides a test harness for fuzzing round trip conversions.
+package conversiontest
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	fuzz "github.com/google/gofuzz"
+	"github.com/gpu-ninja/operator-utils/webhook/conversion"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultIterations is the default number of fuzzed objects to round trip.
+const DefaultIterations = 100
+
+// FuzzInput configures a round trip fuzz test.
+type FuzzInput struct {
+	// Registry contains the conversion functions under test.
+	Registry *conversion.Registry
+	// NewSpoke returns a new, empty, spoke object.
+	NewSpoke func() client.Object
+	// NewHub returns a new, empty, hub object.
+	NewHub func() client.Object
+	// Iterations is the number of fuzzed objects to round trip.
+	Iterations int
+	// FuzzFuncs are additional custom fuzz functions (see gofuzz.Funcs).
+	FuzzFuncs []any
+}
+
+// FuzzRoundTrip fuzzes spoke -> hub -> spoke and hub -> spoke -> hub
+// conversions, failing the test if any information is lost.
+func FuzzRoundTrip(t *testing.T, input FuzzInput) {
+	iterations := input.Iterations
+	if iterations == 0 {
+		iterations = DefaultIterations
+	}
+
+	funcs := append([]any{
+		// Type information is set by the API machinery, not by conversions.
+		func(_ *metav1.TypeMeta, _ fuzz.Continue) {},
+		// Most of the metadata is opaque to conversions and not all of it
+		// survives being marshalled if fuzzed.
+		func(m *metav1.ObjectMeta, c fuzz.Continue) {
+			c.Fuzz(&m.Name)
+			c.Fuzz(&m.Namespace)
+			c.Fuzz(&m.Labels)
+			c.Fuzz(&m.Annotations)
+		},
+	}, input.FuzzFuncs...)
+
+	f := fuzz.New().NilChance(0.5).NumElements(0, 3).Funcs(funcs...)
+
+	t.Run("Spoke-Hub-Spoke", func(t *testing.T) {
+		for i := 0; i < iterations; i++ {
+			spoke := input.NewSpoke()
+			f.Fuzz(spoke)
+			deleteDataAnnotation(spoke)
+
+			hub := input.NewHub()
+			if err := input.Registry.ConvertTo(spoke.DeepCopyObject(), hub); err != nil {
+				t.Fatalf("failed to convert spoke to hub: %v", err)
+			}
+
+			roundTripped := input.NewSpoke()
+			if err := input.Registry.ConvertFrom(hub, roundTripped); err != nil {
+				t.Fatalf("failed to convert hub to spoke: %v", err)
+			}
+			deleteDataAnnotation(roundTripped)
+
+			if !equality.Semantic.DeepEqual(spoke, roundTripped) {
+				t.Fatalf("spoke did not survive round trip:\n%s", cmp.Diff(spoke, roundTripped))
+			}
+		}
+	})
+
+	t.Run("Hub-Spoke-Hub", func(t *testing.T) {
+		for i := 0; i < iterations; i++ {
+			hub := input.NewHub()
+			f.Fuzz(hub)
+			deleteDataAnnotation(hub)
+
+			spoke := input.NewSpoke()
+			if err := input.Registry.ConvertFrom(hub.DeepCopyObject(), spoke); err != nil {
+				t.Fatalf("failed to convert hub to spoke: %v", err)
+			}
+
+			roundTripped := input.NewHub()
+			if err := input.Registry.ConvertTo(spoke, roundTripped); err != nil {
+				t.Fatalf("failed to convert spoke to hub: %v", err)
+			}
+
+			if !equality.Semantic.DeepEqual(hub, roundTripped) {
+				t.Fatalf("hub did not survive round trip:\n%s", cmp.Diff(hub, roundTripped))
+			}
+		}
+	})
+}
+
+func deleteDataAnnotation(obj client.Object) {
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[conversion.DataAnnotation]; !ok {
+		return
+	}
+
+	delete(annotations, conversion.DataAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+}
@@ -0,0 +1,129 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package defaulting provides scaffolding for writing defaulting (mutating) webhooks.
+package defaulting
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gpu-ninja/operator-utils/name"
+	"github.com/gpu-ninja/operator-utils/reference"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Defaulter sets default values on objects of a specific type.
+type Defaulter[T client.Object] interface {
+	// Default sets default values on the given object.
+	Default(ctx context.Context, obj T) error
+}
+
+// DefaulterFunc is a function that implements Defaulter.
+type DefaulterFunc[T client.Object] func(ctx context.Context, obj T) error
+
+// Default implements Defaulter.
+func (f DefaulterFunc[T]) Default(ctx context.Context, obj T) error {
+	return f(ctx, obj)
+}
+
+// NewCustomDefaulter adapts one or more typed Defaulters into a
+// controller-runtime admission.CustomDefaulter. The defaulters are applied
+// in order.
+func NewCustomDefaulter[T client.Object](defaulters ...Defaulter[T]) admission.CustomDefaulter {
+	return &customDefaulter[T]{
+		defaulters: defaulters,
+	}
+}
+
+// Register registers a defaulting webhook for the given type with the manager.
+func Register[T client.Object](mgr ctrl.Manager, obj T, defaulters ...Defaulter[T]) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		WithDefaulter(NewCustomDefaulter(defaulters...)).
+		Complete()
+}
+
+// Value sets the field to the given value if it is currently the zero value.
+func Value[V comparable](field *V, value V) {
+	var zero V
+	if *field == zero {
+		*field = value
+	}
+}
+
+// Name sets the name to a generated, unique, name with the given prefix if
+// it is currently empty.
+func Name(field *string, prefix string) {
+	if *field == "" {
+		*field = name.Generate(prefix)
+	}
+}
+
+// ReferenceNamespace sets the namespace of the reference to the given
+// namespace (typically that of the parent) if it is currently empty.
+func ReferenceNamespace(ref *reference.ObjectReference, namespace string) {
+	if ref != nil && ref.Namespace == "" {
+		ref.Namespace = namespace
+	}
+}
+
+// ResourceRequests fills in any missing resource requests from the given
+// defaults. If a limit has been specified that is lower than the default,
+// the request is set to the limit (as requests cannot exceed limits).
+func ResourceRequests(resources *corev1.ResourceRequirements, defaults corev1.ResourceList) {
+	for resourceName, defaultQuantity := range defaults {
+		if _, ok := resources.Requests[resourceName]; ok {
+			continue
+		}
+
+		quantity := defaultQuantity.DeepCopy()
+		if limit, ok := resources.Limits[resourceName]; ok && limit.Cmp(quantity) < 0 {
+			quantity = limit.DeepCopy()
+		}
+
+		if resources.Requests == nil {
+			resources.Requests = make(corev1.ResourceList)
+		}
+
+		resources.Requests[resourceName] = quantity
+	}
+}
+
+type customDefaulter[T client.Object] struct {
+	defaulters []Defaulter[T]
+}
+
+func (d *customDefaulter[T]) Default(ctx context.Context, obj runtime.Object) error {
+	typedObj, ok := obj.(T)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("unexpected object type %T", obj))
+	}
+
+	for _, defaulter := range d.defaulters {
+		if err := defaulter.Default(ctx, typedObj); err != nil {
+			return fmt.Errorf("failed to default object: %w", err)
+		}
+	}
+
+	return nil
+}
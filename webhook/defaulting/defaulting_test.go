@@ -0,0 +1,91 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package defaulting_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/reference"
+	"github.com/gpu-ninja/operator-utils/webhook/defaulting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCustomDefaulter(t *testing.T) {
+	d := defaulting.NewCustomDefaulter[*corev1.Pod](
+		defaulting.DefaulterFunc[*corev1.Pod](func(_ context.Context, pod *corev1.Pod) error {
+			defaulting.Value(&pod.Spec.ServiceAccountName, "default")
+			return nil
+		}),
+		defaulting.DefaulterFunc[*corev1.Pod](func(_ context.Context, pod *corev1.Pod) error {
+			defaulting.Name(&pod.Spec.Hostname, pod.Name)
+			return nil
+		}),
+	)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+	}
+
+	require.NoError(t, d.Default(context.Background(), pod))
+
+	assert.Equal(t, "default", pod.Spec.ServiceAccountName)
+	assert.Regexp(t, "^test-[a-z0-9]{5}$", pod.Spec.Hostname)
+
+	err := d.Default(context.Background(), &corev1.ConfigMap{})
+	assert.Error(t, err)
+}
+
+func TestReferenceNamespace(t *testing.T) {
+	ref := &reference.ObjectReference{Name: "test"}
+	defaulting.ReferenceNamespace(ref, "default")
+	assert.Equal(t, "default", ref.Namespace)
+
+	ref = &reference.ObjectReference{Name: "test", Namespace: "other"}
+	defaulting.ReferenceNamespace(ref, "default")
+	assert.Equal(t, "other", ref.Namespace)
+}
+
+func TestResourceRequests(t *testing.T) {
+	resources := corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+		Requests: corev1.ResourceList{
+			corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+		},
+	}
+
+	defaulting.ResourceRequests(&resources, corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse("100m"),
+		corev1.ResourceMemory:           resource.MustParse("128Mi"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("2Gi"),
+	})
+
+	assert.Equal(t, "100m", quantityString(resources.Requests[corev1.ResourceCPU]))
+	assert.Equal(t, "64Mi", quantityString(resources.Requests[corev1.ResourceMemory]))
+	assert.Equal(t, "1Gi", quantityString(resources.Requests[corev1.ResourceEphemeralStorage]))
+}
+
+func quantityString(q resource.Quantity) string {
+	return q.String()
+}
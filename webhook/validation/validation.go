@@ -0,0 +1,183 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package validation provides scaffolding for writing validating webhooks.
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Validator validates objects of a specific type.
+type Validator[T client.Object] interface {
+	// ValidateCreate validates a newly created object.
+	ValidateCreate(ctx context.Context, obj T) (admission.Warnings, field.ErrorList)
+	// ValidateUpdate validates an update to an existing object.
+	ValidateUpdate(ctx context.Context, oldObj, newObj T) (admission.Warnings, field.ErrorList)
+	// ValidateDelete validates the deletion of an object.
+	ValidateDelete(ctx context.Context, obj T) (admission.Warnings, field.ErrorList)
+}
+
+// Hook is an additional validation step run on created and updated objects
+// (eg. static validation of references).
+type Hook[T client.Object] func(ctx context.Context, obj T) field.ErrorList
+
+// NewCustomValidator adapts a typed Validator into a controller-runtime
+// admission.CustomValidator. Field errors are aggregated into a single
+// Invalid status for the given group kind.
+func NewCustomValidator[T client.Object](gk schema.GroupKind, v Validator[T], hooks ...Hook[T]) admission.CustomValidator {
+	return &customValidator[T]{
+		gk:        gk,
+		validator: v,
+		hooks:     hooks,
+	}
+}
+
+// Register registers a validating webhook for the given type with the manager.
+func Register[T client.Object](mgr ctrl.Manager, obj T, v Validator[T], hooks ...Hook[T]) error {
+	gvk, err := apiutil.GVKForObject(obj, mgr.GetScheme())
+	if err != nil {
+		return fmt.Errorf("failed to get gvk: %w", err)
+	}
+
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(obj).
+		WithValidator(NewCustomValidator(gvk.GroupKind(), v, hooks...)).
+		Complete()
+}
+
+// ToError converts a list of field errors into an Invalid API error, or nil
+// if there are no errors.
+func ToError(gk schema.GroupKind, name string, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(gk, name, errs)
+}
+
+// ToResponse converts a list of field errors into an admission response.
+// It is useful when implementing admission.Handler directly.
+func ToResponse(gk schema.GroupKind, name string, errs field.ErrorList, warnings ...string) admission.Response {
+	if len(errs) == 0 {
+		return admission.Allowed("").WithWarnings(warnings...)
+	}
+
+	status := apierrors.NewInvalid(gk, name, errs).Status()
+
+	return admission.Response{
+		AdmissionResponse: admissionv1.AdmissionResponse{
+			Allowed:  false,
+			Result:   &status,
+			Warnings: warnings,
+		},
+	}
+}
+
+// Errored returns an admission response for an unexpected error.
+func Errored(err error) admission.Response {
+	var statusErr apierrors.APIStatus
+	if errors.As(err, &statusErr) {
+		status := statusErr.Status()
+		return admission.Response{
+			AdmissionResponse: admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result:  &status,
+			},
+		}
+	}
+
+	return admission.Errored(http.StatusInternalServerError, err)
+}
+
+type customValidator[T client.Object] struct {
+	gk        schema.GroupKind
+	validator Validator[T]
+	hooks     []Hook[T]
+}
+
+func (v *customValidator[T]) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	typedObj, err := v.cast(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings, errs := v.validator.ValidateCreate(ctx, typedObj)
+	errs = append(errs, v.runHooks(ctx, typedObj)...)
+
+	return warnings, ToError(v.gk, typedObj.GetName(), errs)
+}
+
+func (v *customValidator[T]) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	typedOldObj, err := v.cast(oldObj)
+	if err != nil {
+		return nil, err
+	}
+
+	typedNewObj, err := v.cast(newObj)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings, errs := v.validator.ValidateUpdate(ctx, typedOldObj, typedNewObj)
+	errs = append(errs, v.runHooks(ctx, typedNewObj)...)
+
+	return warnings, ToError(v.gk, typedNewObj.GetName(), errs)
+}
+
+func (v *customValidator[T]) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	typedObj, err := v.cast(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings, errs := v.validator.ValidateDelete(ctx, typedObj)
+
+	return warnings, ToError(v.gk, typedObj.GetName(), errs)
+}
+
+func (v *customValidator[T]) runHooks(ctx context.Context, obj T) field.ErrorList {
+	var errs field.ErrorList
+	for _, hook := range v.hooks {
+		errs = append(errs, hook(ctx, obj)...)
+	}
+
+	return errs
+}
+
+func (v *customValidator[T]) cast(obj runtime.Object) (T, error) {
+	typedObj, ok := obj.(T)
+	if !ok {
+		var zero T
+		return zero, apierrors.NewBadRequest(fmt.Sprintf("expected a %s but got a %T", v.gk.Kind, obj))
+	}
+
+	return typedObj, nil
+}
@@ -0,0 +1,121 @@
+/* SPDX-License-Identifier: Apache-2.0
+ *
+ * Copyright 2023 Damian Peckett <damian@pecke.tt>.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validation_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gpu-ninja/operator-utils/webhook/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	hook := func(ctx context.Context, cm *corev1.ConfigMap) field.ErrorList {
+		if cm.Data["ref"] == "" {
+			return field.ErrorList{field.Required(field.NewPath("data", "ref"), "reference is required")}
+		}
+
+		return nil
+	}
+
+	webhook := admission.WithCustomValidator(scheme, &corev1.ConfigMap{},
+		validation.NewCustomValidator[*corev1.ConfigMap](corev1.SchemeGroupVersion.WithKind("ConfigMap").GroupKind(), &configMapValidator{}, hook))
+
+	ctx := context.Background()
+
+	t.Run("Allowed", func(t *testing.T) {
+		resp := webhook.Handle(ctx, newRequest(t, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Data:       map[string]string{"key": "value", "ref": "demo"},
+		}))
+
+		assert.True(t, resp.Allowed)
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		resp := webhook.Handle(ctx, newRequest(t, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		}))
+
+		require.False(t, resp.Allowed)
+		require.NotNil(t, resp.Result)
+
+		assert.Equal(t, metav1.StatusReasonInvalid, resp.Result.Reason)
+		assert.Equal(t, int32(http.StatusUnprocessableEntity), resp.Result.Code)
+		require.NotNil(t, resp.Result.Details)
+		assert.Len(t, resp.Result.Details.Causes, 2)
+	})
+}
+
+func TestToResponse(t *testing.T) {
+	gk := corev1.SchemeGroupVersion.WithKind("ConfigMap").GroupKind()
+
+	resp := validation.ToResponse(gk, "test", nil, "be careful")
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, []string{"be careful"}, resp.Warnings)
+
+	resp = validation.ToResponse(gk, "test", field.ErrorList{
+		field.Invalid(field.NewPath("spec", "replicas"), -1, "must be positive"),
+	})
+	assert.False(t, resp.Allowed)
+	assert.Contains(t, resp.Result.Message, "spec.replicas")
+}
+
+type configMapValidator struct{}
+
+func (v *configMapValidator) ValidateCreate(_ context.Context, cm *corev1.ConfigMap) (admission.Warnings, field.ErrorList) {
+	var errs field.ErrorList
+	if cm.Data["key"] == "" {
+		errs = append(errs, field.Required(field.NewPath("data", "key"), "key is required"))
+	}
+
+	return nil, errs
+}
+
+func (v *configMapValidator) ValidateUpdate(ctx context.Context, _, cm *corev1.ConfigMap) (admission.Warnings, field.ErrorList) {
+	return v.ValidateCreate(ctx, cm)
+}
+
+func (v *configMapValidator) ValidateDelete(_ context.Context, _ *corev1.ConfigMap) (admission.Warnings, field.ErrorList) {
+	return nil, nil
+}
+
+func newRequest(t *testing.T, obj runtime.Object) admission.Request {
+	raw, err := json.Marshal(obj)
+	require.NoError(t, err)
+
+	return admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}